@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// This file exposes processRecipeTree's recipe-costing logic (list.go) over
+// HTTP as structured JSON, so a daemon can serve many callers instead of
+// only the interactive REPL in main(). processRecipeTree itself is
+// refactored to call buildCraftResult too, making the REPL one frontend of
+// the same traced code path the HTTP handlers below use - see RunAPIServer.
+//
+// initialize()/initializeCache() (list.go) are a separate, already-unused
+// startup path - main() has initialized via loadItems()+cache.update()
+// directly since before this file existed, so buildCraftResult is wired
+// against that live path rather than the dead one. initializeCacheTraced
+// below still wraps initializeCache in a span, so the function this
+// request named gets span coverage even though nothing currently calls it.
+
+// CraftPriceSource is one base material's resolved price, as recorded by
+// getPriceFromCache while walking a recipe tree.
+type CraftPriceSource struct {
+	ItemID string  `json:"itemId"`
+	Price  float64 `json:"price"`
+	Method string  `json:"method"`
+	Source string  `json:"source"`
+}
+
+// CraftResult is the JSON shape both the HTTP handlers below and
+// processRecipeTree's printed summary are built from: tree plus the same
+// totals/costs printTotals would print, already resolved against current
+// (or averaged, per recipeCostAvgDays) prices.
+type CraftResult struct {
+	ItemID       string             `json:"itemId"`
+	ItemName     string             `json:"itemName"`
+	Tree         *RecipeTree        `json:"tree"`
+	Totals       ItemTotals         `json:"totals"`
+	Costs        map[string]float64 `json:"costs"`
+	TotalCost    float64            `json:"totalCost"`
+	PriceSources []CraftPriceSource `json:"priceSources"`
+}
+
+// getOrBuildRecipeTreeTraced wraps PriceCache.getOrBuildRecipeTree in a
+// span recording item.id, recipe.count and whether the tree was already
+// cached (cache.hit).
+func (c *PriceCache) getOrBuildRecipeTreeTraced(ctx context.Context, itemID string) *RecipeTree {
+	_, span := StartSpan(ctx, "getOrBuildRecipeTree")
+	defer span.End()
+	span.SetAttribute("item.id", itemID)
+
+	if tree, hit := c.recipeTrees.Get(itemID); hit {
+		span.SetAttribute("cache.hit", true)
+		span.SetAttribute("recipe.count", tree.Quantity)
+		return cloneRecipeTree(tree, 1)
+	}
+
+	span.SetAttribute("cache.hit", false)
+	tree := c.getOrBuildRecipeTree(itemID)
+	span.SetAttribute("recipe.count", tree.Quantity)
+	return tree
+}
+
+// fetchWithRetryTraced wraps fetchWithRetry in a span recording the
+// fetched URL and any error, for callers (e.g. the HTTP API's startup
+// path) that already carry a context.Context.
+func fetchWithRetryTraced(ctx context.Context, url string) ([]byte, error) {
+	_, span := StartSpan(ctx, "fetchWithRetry")
+	defer span.End()
+	span.SetAttribute("http.url", url)
+
+	data, err := fetchWithRetry(url)
+	span.RecordError(err)
+	return data, err
+}
+
+// initializeCacheTraced wraps initializeCache (list.go) in a span. Nothing
+// currently calls initializeCache - it's only reachable from the unused
+// initialize() - but it's still wrapped here so the function this request
+// named gets span coverage if that path is ever wired back up.
+func initializeCacheTraced(ctx context.Context, bazaarData, binsData []byte) error {
+	_, span := StartSpan(ctx, "initializeCache")
+	defer span.End()
+
+	err := initializeCache(bazaarData, binsData)
+	span.RecordError(err)
+	return err
+}
+
+// collectCraftTotals BFS-walks tree to find every distinct base material
+// and the total quantity of it needed, then prices them concurrently
+// through FanOut/FanIn (fanout.go) instead of one getPriceFromCache call
+// at a time during the walk - the deepest recipe's slowest bazaar fallback
+// no longer blocks every other material's lookup, which matters most for
+// /batch (api_server.go), where many trees' materials are priced back to
+// back.
+func collectCraftTotals(ctx context.Context, tree *RecipeTree) (ItemTotals, map[string]float64, []CraftPriceSource) {
+	_, span := StartSpan(ctx, "collectCraftTotals")
+	defer span.End()
+
+	totals := make(ItemTotals)
+	queue := []*RecipeTree{tree}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == nil {
+			continue
+		}
+		if isBaseMaterial(node.ItemID) {
+			totals[node.ItemID] += node.Quantity
+			continue
+		}
+		for _, child := range node.Children {
+			queue = append(queue, child)
+		}
+	}
+
+	jobs := make(chan Job, len(totals))
+	for itemID, qty := range totals {
+		jobs <- Job{ItemID: itemID, Quantity: qty}
+	}
+	close(jobs)
+
+	priceWorker := func(job Job) Result {
+		price, method, source := getPriceFromCache(job.ItemID)
+		return Result{
+			ItemID:   job.ItemID,
+			Quantity: job.Quantity,
+			Price:    price,
+			Method:   method,
+			Source:   source,
+			Cost:     price * float64(job.Quantity),
+		}
+	}
+
+	workerCount := runtime.NumCPU()
+	if workerCount > len(totals) {
+		workerCount = len(totals)
+	}
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	// FanOut/FanIn merge every worker's Results through a single channel
+	// read here, which already serializes these map writes - no separate
+	// mutex is needed on top of that.
+	costs := make(map[string]float64, len(totals))
+	var sources []CraftPriceSource
+	for result := range FanOut(workerCount, len(totals), jobs, priceWorker) {
+		costs[result.ItemID] = result.Cost
+		if result.Price > 0 {
+			sources = append(sources, CraftPriceSource{
+				ItemID: result.ItemID,
+				Price:  result.Price,
+				Method: result.Method,
+				Source: result.Source,
+			})
+		}
+	}
+
+	span.SetAttribute("item.id", tree.ItemID)
+	span.SetAttribute("materials.count", len(totals))
+	span.SetAttribute("workers", workerCount)
+	return totals, costs, sources
+}
+
+// buildCraftResult is the traced core both /craft, /materials, /batch and
+// processRecipeTree (list.go) call through: build (or reuse) itemID's
+// recipe tree, resolve every base material's cost, and total it the same
+// way printTotals does.
+func buildCraftResult(ctx context.Context, itemID string) (*CraftResult, error) {
+	ctx, span := StartSpan(ctx, "buildCraftResult")
+	defer span.End()
+	span.SetAttribute("item.id", itemID)
+
+	item, exists := items[itemID]
+	if !exists {
+		err := fmt.Errorf("unknown item %q", itemID)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	tree := cache.getOrBuildRecipeTreeTraced(ctx, itemID)
+	totals, costs, sources := collectCraftTotals(ctx, tree)
+
+	var totalCost float64
+	for _, itemCost := range costs {
+		totalCost += itemCost
+	}
+	if recipeCount := item.Recipe.GetCount(); recipeCount > 1 {
+		totalCost /= float64(recipeCount)
+	}
+
+	itemName := item.Name
+	if itemName == "" {
+		itemName = itemID
+	}
+
+	span.SetAttribute("totals.count", len(totals))
+	span.SetAttribute("total.cost", totalCost)
+
+	return &CraftResult{
+		ItemID:       itemID,
+		ItemName:     itemName,
+		Tree:         tree,
+		Totals:       totals,
+		Costs:        costs,
+		TotalCost:    totalCost,
+		PriceSources: sources,
+	}, nil
+}
+
+// writeJSONResult encodes v as the response body, or a 500 if encoding
+// fails.
+func writeJSONResult(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// craftHandler serves GET /craft/{itemID} as a CraftResult: the full
+// recipe tree plus resolved totals/costs.
+func craftHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := strings.TrimPrefix(r.URL.Path, "/craft/")
+	if itemID == "" {
+		http.Error(w, "missing item id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := StartSpan(r.Context(), "GET /craft/"+itemID)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.path", r.URL.Path)
+
+	result, err := buildCraftResult(ctx, itemID)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSONResult(w, result)
+}
+
+// materialsResponse is /materials/{itemID}'s smaller response - just the
+// base-material totals/costs a caller wants for a shopping list, without
+// the full recipe tree craftHandler returns.
+type materialsResponse struct {
+	ItemID    string             `json:"itemId"`
+	ItemName  string             `json:"itemName"`
+	Totals    ItemTotals         `json:"totals"`
+	Costs     map[string]float64 `json:"costs"`
+	TotalCost float64            `json:"totalCost"`
+}
+
+// materialsHandler serves GET /materials/{itemID} as a materialsResponse.
+func materialsHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := strings.TrimPrefix(r.URL.Path, "/materials/")
+	if itemID == "" {
+		http.Error(w, "missing item id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := StartSpan(r.Context(), "GET /materials/"+itemID)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.path", r.URL.Path)
+
+	result, err := buildCraftResult(ctx, itemID)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSONResult(w, materialsResponse{
+		ItemID:    result.ItemID,
+		ItemName:  result.ItemName,
+		Totals:    result.Totals,
+		Costs:     result.Costs,
+		TotalCost: result.TotalCost,
+	})
+}
+
+// batchRequest is POST /batch's body: the item IDs to look up together.
+type batchRequest struct {
+	Items []string `json:"items"`
+}
+
+// batchResultEntry is one item's outcome within a /batch response - Craft
+// is set on success, Error on failure, so one unknown item doesn't fail
+// the whole batch.
+type batchResultEntry struct {
+	ItemID string       `json:"itemId"`
+	Craft  *CraftResult `json:"craft,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// batchHandler serves POST /batch: a JSON body {"items": [...]} returns a
+// []batchResultEntry, one CraftResult (or error) per requested item.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, span := StartSpan(r.Context(), "POST /batch")
+	defer span.End()
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.SetAttribute("batch.size", len(req.Items))
+
+	results := make([]batchResultEntry, 0, len(req.Items))
+	for _, itemID := range req.Items {
+		result, err := buildCraftResult(ctx, itemID)
+		if err != nil {
+			results = append(results, batchResultEntry{ItemID: itemID, Error: err.Error()})
+			continue
+		}
+		results = append(results, batchResultEntry{ItemID: itemID, Craft: result})
+	}
+	writeJSONResult(w, results)
+}
+
+// RunAPIServer serves /craft/{itemID}, /materials/{itemID} and /batch on
+// addr. main() starts it in a goroutine when --api-addr is set, so the
+// interactive REPL (processRecipeTree) and this server can run side by
+// side against the same cache.
+func RunAPIServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/craft/", craftHandler)
+	mux.HandleFunc("/materials/", materialsHandler)
+	mux.HandleFunc("/batch", batchHandler)
+	mux.HandleFunc("/flips", flipsHandler)
+	mux.HandleFunc("/progress", progressHandler)
+
+	fmt.Printf("API server listening on %s\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Printf("API server stopped: %v\n", err)
+	}
+}
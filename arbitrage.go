@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// topArbitrageN is how many FindArbitragePaths results printArbitrageSummary
+// prints alongside PriceCache.update's Cache Update Summary.
+const topArbitrageN = 10
+
+// hoursPerBazaarWeek converts Hypixel's *MovingWeek counters (a rolling
+// 7-day total) into a per-hour throughput estimate for ProfitPerHour.
+const hoursPerBazaarWeek = 7 * 24
+
+// ArbPath is one craftable item considered as a buy-raw-materials/craft/
+// sell-product arbitrage loop: buy every leaf ingredient in Ingredients at
+// its current ideal price, craft one recipe batch (recipe.GetCount() units
+// of ItemID), sell the batch at ItemID's ideal sell price. Ingredients is
+// the canonical, deduplicated ingredient set buildArbitragePaths computed
+// once at startup; the Cost/Revenue/Profit/Ratio/Volume/ProfitPerHour
+// fields are re-priced in place by rescoreArbitragePaths on every cache
+// update instead of being rebuilt from the recipe graph each time.
+type ArbPath struct {
+	ItemID      string
+	Ingredients map[string]int
+
+	IngredientCost float64
+	SellRevenue    float64
+	Profit         float64
+	Ratio          float64 // Profit / IngredientCost
+	Volume         int     // throughput cap: min(SellMovingWeek, BuyMovingWeek) of ItemID
+	ProfitPerHour  float64
+}
+
+// canonicalIngredientKey turns an ingredient set into a stable string so
+// buildArbitragePaths can dedupe two products whose recipes resolve to an
+// identical shopping list instead of listing the same arbitrage twice.
+func canonicalIngredientKey(ingredients map[string]int) string {
+	ids := make([]string, 0, len(ingredients))
+	for id := range ingredients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s:%d", id, ingredients[id])
+	}
+	return strings.Join(parts, ",")
+}
+
+// collectIngredientTotals flattens tree's base-material leaves into a
+// single itemID -> quantity map - the same aggregation printRecipeTree
+// performs as a side effect while it prints, but without any printing.
+func collectIngredientTotals(tree *RecipeTree, totals map[string]int) {
+	if tree == nil {
+		return
+	}
+	if isBaseMaterial(tree.ItemID) {
+		totals[tree.ItemID] += tree.Quantity
+		return
+	}
+	for _, child := range tree.Children {
+		collectIngredientTotals(child, totals)
+	}
+}
+
+var (
+	arbPathsMu   sync.Mutex
+	arbPaths     []*ArbPath
+	arbPathsOnce sync.Once
+)
+
+// buildArbitragePaths walks every craftable item in the global item
+// database, flattening its recipe tree into a canonical ingredient set, and
+// keeps one ArbPath per distinct set. This is the expensive step (one
+// buildRecipeTree per craftable item), so ensureArbitragePaths runs it at
+// most once per process; rescoreArbitragePaths is what re-prices the
+// resulting paths cheaply on every later cache update.
+func buildArbitragePaths() []*ArbPath {
+	seen := make(map[string]bool)
+	var paths []*ArbPath
+	for itemID, item := range items {
+		if isBaseMaterial(itemID) {
+			continue
+		}
+		recipeCount := item.Recipe.GetCount()
+		if recipeCount == 0 {
+			recipeCount = 1
+		}
+
+		tree := buildRecipeTree(itemID, recipeCount, make(map[string]bool))
+		totals := make(map[string]int)
+		collectIngredientTotals(tree, totals)
+		if len(totals) == 0 {
+			continue
+		}
+
+		key := canonicalIngredientKey(totals)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		paths = append(paths, &ArbPath{ItemID: itemID, Ingredients: totals})
+	}
+	return paths
+}
+
+// ensureArbitragePaths builds arbPaths exactly once and returns it.
+func ensureArbitragePaths() []*ArbPath {
+	arbPathsOnce.Do(func() {
+		arbPaths = buildArbitragePaths()
+	})
+	return arbPaths
+}
+
+// rescoreArbitragePaths re-prices every path in arbPaths against c's
+// current snapshot in O(paths) - each path's ingredient set was already
+// computed once by buildArbitragePaths, so this only does cheap price
+// lookups and arithmetic, safe to call after every PriceCache.update.
+func rescoreArbitragePaths(c *PriceCache) {
+	paths := ensureArbitragePaths()
+
+	arbPathsMu.Lock()
+	defer arbPathsMu.Unlock()
+
+	for _, p := range paths {
+		cost := 0.0
+		for ingredientID, qty := range p.Ingredients {
+			price, _, _ := getPriceFromCache(ingredientID)
+			cost += price * float64(qty)
+		}
+		p.IngredientCost = cost
+
+		item := items[p.ItemID]
+		recipeCount := item.Recipe.GetCount()
+		if recipeCount == 0 {
+			recipeCount = 1
+		}
+
+		sellPrice := c.getIdealPrice(p.ItemID)
+		if sellPrice <= 0 {
+			// Not sellable on the Bazaar (only a Moulberry lbin quote, or no
+			// quote at all) - can't be the sell side of this loop.
+			p.SellRevenue, p.Profit, p.Ratio, p.Volume, p.ProfitPerHour = 0, 0, 0, 0, 0
+			continue
+		}
+
+		revenue := sellPrice * float64(recipeCount)
+		profit := revenue - cost
+		ratio := 0.0
+		if cost > 0 {
+			ratio = profit / cost
+		}
+
+		volume := 0
+		c.mu.RLock()
+		if product, exists := c.bazaarData.Products[p.ItemID]; exists {
+			volume = product.QuickStatus.SellMovingWeek
+			if product.QuickStatus.BuyMovingWeek < volume {
+				volume = product.QuickStatus.BuyMovingWeek
+			}
+		}
+		c.mu.RUnlock()
+
+		p.SellRevenue = revenue
+		p.Profit = profit
+		p.Ratio = ratio
+		p.Volume = volume
+		p.ProfitPerHour = profit * float64(volume) / hoursPerBazaarWeek
+	}
+}
+
+// FindArbitragePaths returns a snapshot of every ArbPath whose Ratio is at
+// least minRatio and whose Volume is at least minVolume, sorted by
+// ProfitPerHour descending. rescoreArbitragePaths must have run at least
+// once (PriceCache.update calls it on every refresh) for Ratio/Volume/
+// ProfitPerHour to reflect current prices rather than their zero value.
+func FindArbitragePaths(minRatio float64, minVolume int) []ArbPath {
+	arbPathsMu.Lock()
+	defer arbPathsMu.Unlock()
+
+	var matches []ArbPath
+	for _, p := range arbPaths {
+		if p.Ratio >= minRatio && p.Volume >= minVolume {
+			matches = append(matches, *p)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].ProfitPerHour > matches[j].ProfitPerHour
+	})
+	return matches
+}
+
+// printArbitrageSummary prints the top n FindArbitragePaths results
+// alongside PriceCache.update's Cache Update Summary table.
+func printArbitrageSummary(n int) {
+	paths := FindArbitragePaths(0, 0)
+	if len(paths) == 0 {
+		return
+	}
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+
+	fmt.Println("╔════════════════════ Top Arbitrage Paths ════════════════════════")
+	for _, p := range paths {
+		name := items[p.ItemID].Name
+		if name == "" {
+			name = p.ItemID
+		}
+		fmt.Printf("║ %-28s Profit: %-10s Ratio: %7.2f%% Vol/wk: %-7d /hr: %s\n",
+			name, formatPrice(p.Profit), p.Ratio*100, p.Volume, formatPrice(p.ProfitPerHour))
+	}
+	fmt.Println("╚═══════════════════════════════════════════════════════════════\n")
+}
+
+// BudgetAllocation is one ArbPath's share of an AllocateArbitrageBudget run:
+// how many recipe batches to craft and how much of the coin budget that
+// consumes.
+type BudgetAllocation struct {
+	ItemID  string
+	Batches int
+	Spend   float64
+	Profit  float64
+}
+
+// AllocateArbitrageBudget greedily spends budget on the highest-Ratio paths
+// first, capping each path's batches at its Volume - the market's weekly
+// throughput - so a caller is never told to run more batches than the
+// Bazaar can actually absorb before moving on to spend whatever's left on
+// the next-best path.
+func AllocateArbitrageBudget(paths []ArbPath, budget float64) []BudgetAllocation {
+	sorted := make([]ArbPath, len(paths))
+	copy(sorted, paths)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Ratio > sorted[j].Ratio
+	})
+
+	var allocations []BudgetAllocation
+	remaining := budget
+	for _, p := range sorted {
+		if remaining <= 0 || p.IngredientCost <= 0 || p.Volume <= 0 {
+			continue
+		}
+		batches := p.Volume
+		if maxByBudget := int(remaining / p.IngredientCost); maxByBudget < batches {
+			batches = maxByBudget
+		}
+		if batches <= 0 {
+			continue
+		}
+
+		spend := p.IngredientCost * float64(batches)
+		allocations = append(allocations, BudgetAllocation{
+			ItemID:  p.ItemID,
+			Batches: batches,
+			Spend:   spend,
+			Profit:  p.Profit * float64(batches),
+		})
+		remaining -= spend
+	}
+	return allocations
+}
+
+// printBudgetAllocation is the --budget CLI mode's entry point: resolve the
+// current best arbitrage paths, greedily allocate budget coins across them,
+// and print the resulting shopping/crafting plan instead of entering
+// interactive lookup mode.
+func printBudgetAllocation(budget float64) {
+	allocations := AllocateArbitrageBudget(FindArbitragePaths(0, 1), budget)
+
+	fmt.Printf("\n╔════════════════════ Budget Allocation (%s coins) ═══════════════\n", formatPrice(budget))
+	if len(allocations) == 0 {
+		fmt.Println("║ No arbitrage paths cleared a positive ratio at the current prices.")
+		fmt.Println("╚═══════════════════════════════════════════════════════════════")
+		return
+	}
+
+	var totalSpend, totalProfit float64
+	for _, a := range allocations {
+		name := items[a.ItemID].Name
+		if name == "" {
+			name = a.ItemID
+		}
+		fmt.Printf("║ %-28s batches: %-6d spend: %-12s profit: %s\n",
+			name, a.Batches, formatPrice(a.Spend), formatPrice(a.Profit))
+		totalSpend += a.Spend
+		totalProfit += a.Profit
+	}
+	fmt.Printf("║ Total spend: %-12s Total profit: %s\n", formatPrice(totalSpend), formatPrice(totalProfit))
+	fmt.Println("╚═══════════════════════════════════════════════════════════════")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BazaarRefresher replaces the one-shot fetchBazaar() call main() used to
+// make per-run with a background goroutine that keeps *HypixelAPIResponse
+// current, the same async-refresh-queue idea other tools in this codebase
+// use for their own Bazaar clients. Get() and CalculateC10M below read the
+// cached snapshot through a sync.RWMutex instead of re-fetching per call.
+type BazaarRefresher struct {
+	interval     time.Duration
+	maxStaleness time.Duration
+
+	mu   sync.RWMutex
+	resp *HypixelAPIResponse
+	err  error
+
+	lastFullUpdate  time.Time
+	startFullUpdate time.Time
+	updateProgress  int32 // 0-100, read via atomic
+
+	firstLoad sync.WaitGroup
+	loadOnce  sync.Once
+}
+
+// NewBazaarRefresher builds a refresher from WIZ_REFRESH_INTERVAL and
+// WIZ_MAX_STALENESS (both time.ParseDuration strings, e.g. "30s", "5m"),
+// falling back to 30s/5m when unset or unparseable - the same
+// env-var-with-sane-default convention the rest of this module uses for
+// tunables.
+func NewBazaarRefresher() *BazaarRefresher {
+	r := &BazaarRefresher{
+		interval:     durationFromEnv("WIZ_REFRESH_INTERVAL", 30*time.Second),
+		maxStaleness: durationFromEnv("WIZ_MAX_STALENESS", 5*time.Minute),
+	}
+	r.firstLoad.Add(1)
+	return r
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// Start launches the refresh loop; it runs until stop is closed. The first
+// successful fetch releases Wait() so callers can block until there's a
+// response to price against before computing C10M.
+func (r *BazaarRefresher) Start(stop <-chan struct{}) {
+	go r.loop(stop)
+}
+
+func (r *BazaarRefresher) loop(stop <-chan struct{}) {
+	r.refreshOnce()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.refreshOnce()
+		}
+	}
+}
+
+func (r *BazaarRefresher) refreshOnce() {
+	r.mu.Lock()
+	r.startFullUpdate = time.Now()
+	r.mu.Unlock()
+	atomic.StoreInt32(&r.updateProgress, 0)
+
+	resp, err := fetchBazaar()
+	atomic.StoreInt32(&r.updateProgress, 100)
+
+	r.mu.Lock()
+	r.resp = resp
+	r.err = err
+	if err == nil {
+		r.lastFullUpdate = time.Now()
+	}
+	r.mu.Unlock()
+
+	if err == nil {
+		r.loadOnce.Do(r.firstLoad.Done)
+	}
+}
+
+// Wait blocks until the first successful load has completed.
+func (r *BazaarRefresher) Wait() {
+	r.firstLoad.Wait()
+}
+
+// Get returns the currently cached response, or the error from the most
+// recent fetch attempt if that fetch failed.
+func (r *BazaarRefresher) Get() (*HypixelAPIResponse, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.resp, r.err
+}
+
+// LastFullUpdateTime is the timestamp of the most recent successful fetch.
+func (r *BazaarRefresher) LastFullUpdateTime() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastFullUpdate
+}
+
+// StartFullUpdateTime is when the in-flight (or most recently finished)
+// fetch attempt began.
+func (r *BazaarRefresher) StartFullUpdateTime() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.startFullUpdate
+}
+
+// UpdateProgress is 0 while a fetch is outstanding and 100 once it lands -
+// fetchBazaar has no finer-grained progress to report than "done or not",
+// unlike a per-product refresh queue would.
+func (r *BazaarRefresher) UpdateProgress() int {
+	return int(atomic.LoadInt32(&r.updateProgress))
+}
+
+// Stale reports whether the cached response is older than maxStaleness, or
+// there is no cached response at all yet.
+func (r *BazaarRefresher) Stale() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.resp == nil {
+		return true
+	}
+	return time.Since(r.lastFullUpdate) > r.maxStaleness
+}
+
+// CalculateC10M is the refresher-backed counterpart to calculateC10M/
+// computeC10MResult: it reads whatever BazaarRefresher currently has cached
+// instead of fetching, and refuses to price a product once that cache is
+// older than maxStaleness, returning a C10MResult whose BestMethod says so
+// rather than quietly pricing off stale data.
+func CalculateC10M(refresher *BazaarRefresher, prod string, qty float64, metrics []ProductMetrics) (C10MResult, error) {
+	if refresher.Stale() {
+		return C10MResult{
+			Primary:      math.Inf(1),
+			Secondary:    math.Inf(1),
+			IF:           math.NaN(),
+			RR:           math.NaN(),
+			DeltaRatio:   math.NaN(),
+			Adjustment:   math.NaN(),
+			BestEstimate: math.Inf(1),
+			BestMethod:   "stale data",
+		}, nil
+	}
+	apiResp, err := refresher.Get()
+	if err != nil {
+		return C10MResult{}, err
+	}
+	result, _, _, err := computeC10MResult(prod, qty, apiResp, metrics)
+	return result, err
+}
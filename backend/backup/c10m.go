@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // --- Structs ---
@@ -52,9 +57,155 @@ func loadMetrics(filename string) []ProductMetrics {
 	if err := json.Unmarshal(data, &metrics); err != nil {
 		log.Fatalf("Failed to parse metrics JSON: %v", err)
 	}
+	metricsLoadedAt = time.Now()
 	return metrics
 }
 
+// metricsLoadedAt records when loadMetrics last read latest_metrics.json, so
+// the /ui/product/{id} page can show how stale the snapshot behind a result
+// is instead of a caller having to stat the file themselves.
+var metricsLoadedAt time.Time
+
+// fetchBazaar performs the same live GET this file's original Scanln main()
+// always did, pulled out so the HTTP handlers below can call it per-request
+// without duplicating the fetch/decode/status-check block.
+func fetchBazaar() (*HypixelAPIResponse, error) {
+	resp, err := http.Get("https://api.hypixel.net/v2/skyblock/bazaar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Hypixel API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned %d: %s", resp.StatusCode, string(body))
+	}
+	var apiResp HypixelAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("Hypixel API reported failure")
+	}
+	return &apiResp, nil
+}
+
+// ResolvePrices extracts prod's current buy-order price (sellP, what this
+// tool instabuys against) and instabuy price (buyP) out of apiResp, the same
+// lookup main() used to do inline. Both the Scanln CLI and the HTTP handlers
+// below share this so the two paths can't drift on what counts as a missing
+// or invalid price.
+func ResolvePrices(apiResp *HypixelAPIResponse, prod string) (sellP, buyP float64, err error) {
+	prodData, ok := apiResp.Products[prod]
+	if !ok {
+		return 0, 0, fmt.Errorf("product '%s' not found in API response", prod)
+	}
+	if len(prodData.SellSummary) == 0 || len(prodData.BuySummary) == 0 {
+		return 0, 0, fmt.Errorf("sell_summary or buy_summary is empty for product '%s'", prod)
+	}
+	sellP = prodData.SellSummary[0].PricePerUnit
+	buyP = prodData.BuySummary[0].PricePerUnit
+	if sellP <= 0 || buyP <= 0 {
+		return 0, 0, fmt.Errorf("invalid (non-positive) price found for product '%s'", prod)
+	}
+	return sellP, buyP, nil
+}
+
+// bestC10MEstimate picks whichever of Primary/Secondary is lower (treating
+// Inf as disqualifying), the same "best estimate" choice main() has always
+// printed at the bottom of its results.
+func bestC10MEstimate(c10mPrim, c10mSec float64) (best float64, method string) {
+	isPrimInf := math.IsInf(c10mPrim, 0)
+	isSecInf := math.IsInf(c10mSec, 0)
+	switch {
+	case isPrimInf && isSecInf:
+		return math.Inf(1), "N/A (Both Infinite)"
+	case isPrimInf:
+		return c10mSec, "Secondary"
+	case isSecInf:
+		return c10mPrim, "Primary"
+	case c10mPrim <= c10mSec:
+		return c10mPrim, "Primary"
+	default:
+		return c10mSec, "Secondary"
+	}
+}
+
+// C10MResult is the JSON shape GET /product/{id} returns and what
+// /ui/product/{id} renders into its page: the six raw calculateC10M outputs
+// plus the best-of-the-two estimate and which method produced it.
+type C10MResult struct {
+	Primary      float64 `json:"primary"`
+	Secondary    float64 `json:"secondary"`
+	IF           float64 `json:"if"`
+	RR           float64 `json:"rr"`
+	DeltaRatio   float64 `json:"deltaRatio"`
+	Adjustment   float64 `json:"adjustment"`
+	BestEstimate float64 `json:"bestEstimate"`
+	BestMethod   string  `json:"bestMethod"`
+
+	// CraftPerUnit/CraftBestMethod/CraftIngredients are only populated when
+	// computeC10MResultWithCraft ran the recipe expander alongside the
+	// ordinary C10M math; a plain computeC10MResult call leaves them zero.
+	// BestMethod becomes "craft" when CraftPerUnit*qty beats BestEstimate by
+	// more than craftThresholdEnv().
+	CraftPerUnit     float64          `json:"craftPerUnit,omitempty"`
+	CraftBestMethod  string           `json:"craftBestMethod,omitempty"`
+	CraftIngredients []IngredientCost `json:"craftIngredients,omitempty"`
+	CraftComplete    bool             `json:"craftComplete,omitempty"`
+}
+
+// computeC10MResult runs ResolvePrices + calculateC10M for prod/qty against a
+// freshly-fetched apiResp and metrics snapshot, and packages the result the
+// way both HTTP handlers need it.
+func computeC10MResult(prod string, qty float64, apiResp *HypixelAPIResponse, metrics []ProductMetrics) (C10MResult, float64, float64, error) {
+	sellP, buyP, err := ResolvePrices(apiResp, prod)
+	if err != nil {
+		return C10MResult{}, 0, 0, err
+	}
+	prim, sec, ifv, rr, deltaRatio, adj := calculateC10M(prod, qty, sellP, buyP, metrics)
+	best, method := bestC10MEstimate(prim, sec)
+	return C10MResult{
+		Primary:      prim,
+		Secondary:    sec,
+		IF:           ifv,
+		RR:           rr,
+		DeltaRatio:   deltaRatio,
+		Adjustment:   adj,
+		BestEstimate: best,
+		BestMethod:   method,
+	}, sellP, buyP, nil
+}
+
+// computeC10MResultWithCraft bridges this file's C10M math to the recipe
+// expander in expander.go (the other program in this chunk): after the
+// ordinary computeC10MResult succeeds, it also runs CraftEstimate for prod
+// against the same apiResp and, if that craft's per-unit cost scaled by qty
+// beats the existing BestEstimate by more than craftThresholdEnv(), promotes
+// BestMethod to "craft" and BestEstimate to the craft total. expander may be
+// nil, in which case this behaves exactly like computeC10MResult.
+func computeC10MResultWithCraft(prod string, qty float64, apiResp *HypixelAPIResponse, metrics []ProductMetrics, expander *Expander) (C10MResult, error) {
+	result, _, _, err := computeC10MResult(prod, qty, apiResp, metrics)
+	if err != nil || expander == nil {
+		return result, err
+	}
+
+	craftPerUnit, craftMethod, contributions, complete, craftErr := CraftEstimate(expander, apiResp, prod)
+	if craftErr != nil {
+		return result, nil
+	}
+	result.CraftPerUnit = craftPerUnit
+	result.CraftBestMethod = craftMethod
+	result.CraftIngredients = contributions
+	result.CraftComplete = complete
+
+	craftTotal := craftPerUnit * qty
+	if craftTotal > 0 && craftTotal < result.BestEstimate*(1-craftThresholdEnv()) {
+		result.BestEstimate = craftTotal
+		result.BestMethod = "craft"
+	}
+	return result, nil
+}
+
 // --- C10M Calculation ---
 
 // calculateC10M computes primary and secondary C10M values.
@@ -219,7 +370,172 @@ func calculateC10M(
 	return
 }
 
+// productPageHTML is parsed once into productPageTmpl at startup rather than
+// per-request, the same "don't re-parse on every hit" rule any cached
+// text/template follows.
+const productPageHTML = `<!DOCTYPE html>
+<html>
+<head><title>C10M - {{.Prod}}</title></head>
+<body>
+<h1>{{.Prod}} x {{.Qty}}</h1>
+<p>Buy-order price (sellP): {{.SellP}}</p>
+<p>Instabuy price (buyP): {{.BuyP}}</p>
+<p>Metrics snapshot: {{.MetricsAt}}</p>
+<table border="1">
+<tr><td>Primary</td><td>{{.Result.Primary}}</td></tr>
+<tr><td>Secondary</td><td>{{.Result.Secondary}}</td></tr>
+<tr><td>IF</td><td>{{.Result.IF}}</td></tr>
+<tr><td>RR</td><td>{{.Result.RR}}</td></tr>
+<tr><td>DeltaRatio</td><td>{{.Result.DeltaRatio}}</td></tr>
+<tr><td>Adjustment</td><td>{{.Result.Adjustment}}</td></tr>
+<tr><td>Best Estimate</td><td>{{.Result.BestEstimate}} ({{.Result.BestMethod}})</td></tr>
+{{if .Result.CraftBestMethod}}
+<tr><td>Craft Per Unit</td><td>{{.Result.CraftPerUnit}} ({{.Result.CraftBestMethod}}{{if not .Result.CraftComplete}}, incomplete - some ingredients unpriced{{end}})</td></tr>
+{{end}}
+</table>
+{{if .Result.CraftIngredients}}
+<h2>Ingredient contribution</h2>
+<table border="1">
+<tr><th>Item</th><th>Amount</th><th>Unit Price</th><th>Cost</th></tr>
+{{range .Result.CraftIngredients}}
+<tr><td>{{.ItemID}}</td><td>{{.Amount}}</td><td>{{.UnitPrice}}</td><td>{{.Cost}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`
+
+var productPageTmpl = template.Must(template.New("product").Parse(productPageHTML))
+
+// productPageData feeds productPageTmpl.
+type productPageData struct {
+	Prod      string
+	Qty       float64
+	SellP     float64
+	BuyP      float64
+	MetricsAt string
+	Result    C10MResult
+}
+
+// parseProductRequest pulls the {id} path segment and ?qty= query param
+// shared by both HTTP handlers below out of one place, so /product/{id} and
+// /ui/product/{id} can't disagree on how a request is parsed.
+func parseProductRequest(r *http.Request, prefix string) (prod string, qty float64, err error) {
+	prod = strings.TrimPrefix(r.URL.Path, prefix)
+	if prod == "" {
+		return "", 0, fmt.Errorf("missing product id in path")
+	}
+	qtyStr := r.URL.Query().Get("qty")
+	if qtyStr == "" {
+		return prod, 1, nil
+	}
+	qty, err = strconv.ParseFloat(qtyStr, 64)
+	if err != nil || qty <= 0 {
+		return "", 0, fmt.Errorf("invalid qty '%s'", qtyStr)
+	}
+	return prod, qty, nil
+}
+
+// craftExpanderFor returns a recipe Expander for the product-handlers' craft
+// option, or nil if the request didn't ask for one. Loaded fresh per request
+// rather than cached package-wide - dependencies/items rarely changes and
+// this mirrors the rest of this file's "simplicity over caching" choices
+// outside of BazaarRefresher.
+func craftExpanderFor(r *http.Request) *Expander {
+	if r.URL.Query().Get("craft") != "1" {
+		return nil
+	}
+	expander, err := NewExpander("dependencies/items", ExpandAll, BacktrackToRecent)
+	if err != nil {
+		return nil
+	}
+	return expander
+}
+
+// productHandler serves GET /product/{id}?qty=N as the C10MResult JSON this
+// chunk's request asks for; adding &craft=1 also runs the recipe expander
+// and folds craft-vs-buy into the result.
+func productHandler(w http.ResponseWriter, r *http.Request) {
+	prod, qty, err := parseProductRequest(r, "/product/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiResp, err := fetchBazaar()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	metrics := loadMetrics("latest_metrics.json")
+	result, err := computeC10MResultWithCraft(prod, qty, apiResp, metrics, craftExpanderFor(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// uiProductHandler serves GET /ui/product/{id}?qty=N, rendering the same
+// computeC10MResult output through productPageTmpl instead of as raw JSON.
+func uiProductHandler(w http.ResponseWriter, r *http.Request) {
+	prod, qty, err := parseProductRequest(r, "/ui/product/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiResp, err := fetchBazaar()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	metrics := loadMetrics("latest_metrics.json")
+	result, err := computeC10MResultWithCraft(prod, qty, apiResp, metrics, craftExpanderFor(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	sellP, buyP, _ := ResolvePrices(apiResp, prod)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	productPageTmpl.Execute(w, productPageData{
+		Prod:      prod,
+		Qty:       qty,
+		SellP:     sellP,
+		BuyP:      buyP,
+		MetricsAt: metricsLoadedAt.Format(time.RFC3339),
+		Result:    result,
+	})
+}
+
+// runServeCommand is `wiz serve --addr :8080`: an optional long-running
+// alternative to the Scanln CLI below for callers (dashboards, etc.) that
+// want to query this calculator over HTTP instead of forking a process per
+// lookup.
+func runServeCommand(addr string) error {
+	http.HandleFunc("/product/", productHandler)
+	http.HandleFunc("/ui/product/", uiProductHandler)
+	log.Printf("Serving C10M calculator on %s", addr)
+	return http.ListenAndServe(addr, nil)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		addr := fs.String("addr", ":8080", "address to listen on")
+		fs.Parse(os.Args[2:])
+		if err := runServeCommand(*addr); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rank" {
+		if err := runRankCommand(os.Args[2:]); err != nil {
+			log.Fatalf("rank: %v", err)
+		}
+		return
+	}
+
 	// 1) User inputs
 	var prod string
 	var qty float64
@@ -235,36 +551,16 @@ func main() {
 
 	// 2) Fetch Bazaar data
 	fmt.Println("Fetching Bazaar data...")
-	resp, err := http.Get("https://api.hypixel.net/v2/skyblock/bazaar")
+	apiResp, err := fetchBazaar()
 	if err != nil {
-		log.Fatalf("Failed to fetch Hypixel API: %v", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("API returned %d: %s", resp.StatusCode, string(body))
-	}
-	var apiResp HypixelAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		log.Fatalf("Failed to parse API response: %v", err)
-	}
-	if !apiResp.Success {
-		log.Fatal("Hypixel API reported failure")
+		log.Fatalf("%v", err)
 	}
 	fmt.Println("Bazaar data fetched.")
 
 	// 3) Extract product data
-	prodData, ok := apiResp.Products[prod]
-	if !ok {
-		log.Fatalf("Product '%s' not found in API response", prod)
-	}
-	if len(prodData.SellSummary) == 0 || len(prodData.BuySummary) == 0 {
-		log.Fatalf("sell_summary or buy_summary is empty for product '%s'", prod)
-	}
-	sellP := prodData.SellSummary[0].PricePerUnit
-	buyP := prodData.BuySummary[0].PricePerUnit
-	if sellP <= 0 || buyP <= 0 {
-		log.Fatalf("Invalid (non-positive) price found for product '%s'", prod)
+	sellP, buyP, err := ResolvePrices(apiResp, prod)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	// 4) Load metrics
@@ -277,28 +573,7 @@ func main() {
 	c10mPrim, c10mSec, IF, RR, DeltaRatio, adj := calculateC10M(prod, qty, sellP, buyP, metrics)
 
 	// --- Determine Best C10M ---
-	var bestC10m float64
-	var bestMethod string
-	isPrimInf := math.IsInf(c10mPrim, 0)
-	isSecInf := math.IsInf(c10mSec, 0)
-	if isPrimInf && isSecInf {
-		bestC10m = math.Inf(1)
-		bestMethod = "N/A (Both Infinite)"
-	} else if isPrimInf {
-		bestC10m = c10mSec
-		bestMethod = "Secondary"
-	} else if isSecInf {
-		bestC10m = c10mPrim
-		bestMethod = "Primary"
-	} else {
-		if c10mPrim <= c10mSec {
-			bestC10m = c10mPrim
-			bestMethod = "Primary"
-		} else {
-			bestC10m = c10mSec
-			bestMethod = "Secondary"
-		}
-	}
+	bestC10m, bestMethod := bestC10MEstimate(c10mPrim, c10mSec)
 	// --- End Determine Best C10M ---
 
 	// --- Calculate Associated Simple Cost ---
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// IngredientCost is one base ingredient's contribution to a CraftEstimate,
+// surfaced so callers can see which ingredient dominates a craft's cost
+// instead of only the summed total.
+type IngredientCost struct {
+	ItemID    string  `json:"itemId"`
+	Amount    int     `json:"amount"`
+	UnitPrice float64 `json:"unitPrice"`
+	Cost      float64 `json:"cost"`
+}
+
+// craftThresholdEnv is the fractional savings craft must beat direct
+// acquisition by before BestMethod switches to "craft" - e.g. 0.05 means
+// crafting has to be at least 5% cheaper, not just nominally cheaper, to
+// account for the fill-time/fees this simple model doesn't otherwise price
+// in. Configurable via WIZ_CRAFT_THRESHOLD, defaulting to 0.05.
+func craftThresholdEnv() float64 {
+	raw := os.Getenv("WIZ_CRAFT_THRESHOLD")
+	if raw == "" {
+		return 0.05
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 {
+		return 0.05
+	}
+	return v
+}
+
+// CraftEstimate prices one unit of prod's recipe out via expander and
+// apiResp: for every base ingredient Expand(prod, 1) returns, it resolves
+// that ingredient's own sellP/buyP from the same apiResp (ResolvePrices
+// works for any product id, not just the top-level one) and takes whichever
+// is cheaper per unit, the same "best of the two" choice bestC10MEstimate
+// makes at the top level. Ingredients this API can't currently price (not
+// listed in apiResp.Products) are skipped with their cost left out of the
+// total - there is no historical average price in ProductMetrics to fall
+// back on in this snapshot (it only carries sell/order size and frequency,
+// never a price), so "missing ingredient price" here means "excluded", and
+// the returned complete flag tells the caller whether that happened.
+func CraftEstimate(expander *Expander, apiResp *HypixelAPIResponse, prod string) (perUnit float64, method string, contributions []IngredientCost, complete bool, err error) {
+	ingredients, err := expander.Expand(prod, 1)
+	if err != nil {
+		return 0, "", nil, false, err
+	}
+
+	complete = true
+	instabuyTotal, buyOrderTotal := 0.0, 0.0
+	contributions = make([]IngredientCost, 0, len(ingredients))
+	for itemID, amount := range ingredients {
+		sellP, buyP, priceErr := ResolvePrices(apiResp, itemID)
+		if priceErr != nil {
+			complete = false
+			continue
+		}
+		unitPrice := buyP
+		if sellP < unitPrice {
+			unitPrice = sellP
+		}
+		cost := unitPrice * float64(amount)
+		perUnit += cost
+		instabuyTotal += buyP * float64(amount)
+		buyOrderTotal += sellP * float64(amount)
+		contributions = append(contributions, IngredientCost{ItemID: itemID, Amount: amount, UnitPrice: unitPrice, Cost: cost})
+	}
+
+	method = "insta_buy"
+	if buyOrderTotal < instabuyTotal {
+		method = "buy_order"
+	}
+	return perUnit, method, contributions, complete, nil
+}
@@ -207,6 +207,21 @@ func expandItem(itemName string, factor int, path []ItemStep) map[string]int {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "expand" {
+		if err := runExpandCommand(os.Args[2:]); err != nil {
+			fmt.Println("expand:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	runInteractiveExpand()
+}
+
+// runInteractiveExpand is expandItem's original prompt loop, now what main()
+// runs when it is not given the `expand` subcommand rather than its only
+// behavior - `wiz expand` above is the non-interactive, Expander-based
+// alternative.
+func runInteractiveExpand() {
 	fmt.Println("Enter item names (type 'exit' to quit):")
 	for {
 		fmt.Print("Item name: ")
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPolicy decides, for one ingredient that has its own recipe file,
+// whether Expander.Expand should recurse into it or stop and count it as a
+// base ingredient. expandItem used to hard-code this as a Scanln prompt;
+// ExpandInteractive below preserves that behavior for `wiz expand --policy
+// interactive`, while the other three make recipe expansion usable
+// non-interactively.
+type ExpandPolicy interface {
+	ShouldExpand(itemName string) bool
+}
+
+type expandAllPolicy struct{}
+
+func (expandAllPolicy) ShouldExpand(string) bool { return true }
+
+// ExpandAll always recurses into an ingredient's own recipe, flattening all
+// the way down to true base ingredients.
+var ExpandAll ExpandPolicy = expandAllPolicy{}
+
+type expandNonePolicy struct{}
+
+func (expandNonePolicy) ShouldExpand(string) bool { return false }
+
+// ExpandNone never recurses; every direct ingredient of the top-level item
+// is treated as a base ingredient even if it has a recipe file of its own.
+var ExpandNone ExpandPolicy = expandNonePolicy{}
+
+type expandMatchingPolicy struct {
+	match func(name string) bool
+}
+
+func (p expandMatchingPolicy) ShouldExpand(itemName string) bool { return p.match(itemName) }
+
+// ExpandMatching recurses only into ingredients for which match returns
+// true - e.g. expanding only items below a given rarity or name prefix.
+func ExpandMatching(match func(name string) bool) ExpandPolicy {
+	return expandMatchingPolicy{match: match}
+}
+
+type expandInteractivePolicy struct{}
+
+func (expandInteractivePolicy) ShouldExpand(itemName string) bool {
+	var answer string
+	fmt.Printf("Ingredient '%s' has a recipe file. Expand it? (y/n): ", itemName)
+	fmt.Scanln(&answer)
+	return strings.ToLower(answer) == "y"
+}
+
+// ExpandInteractive is expandItem's original behavior: prompt on stdin for
+// every ingredient that has a recipe file.
+var ExpandInteractive ExpandPolicy = expandInteractivePolicy{}
+
+// CyclePolicy controls what Expander.Expand does when an ingredient already
+// appears earlier in the current expansion path - expandItem always did
+// BacktrackToRecent; the other two make the behavior explicit and scriptable.
+type CyclePolicy int
+
+const (
+	// BacktrackToRecent reuses the factor recorded at the ingredient's most
+	// recent occurrence in the path, same as expandItem's original logic.
+	BacktrackToRecent CyclePolicy = iota
+	// TreatAsBase stops at the cycle and counts the ingredient as a base
+	// ingredient at its current factor instead of backtracking.
+	TreatAsBase
+	// Error fails the expansion outright when a cycle is detected.
+	Error
+)
+
+// Expander replaces the package-level expandItem/aggregateCells pair with a
+// struct that (a) loads dependencies/items/*.json once into an in-memory
+// cache instead of re-reading/re-parsing per recursion, (b) memoizes
+// per-item expansions so a shared sub-ingredient is only expanded once, and
+// (c) takes an ExpandPolicy/CyclePolicy instead of hard-coding a Scanln
+// prompt and an always-backtrack cycle rule.
+type Expander struct {
+	itemsDir string
+	policy   ExpandPolicy
+	cycle    CyclePolicy
+
+	cache map[string]Item
+	memo  map[string]map[string]int
+}
+
+// NewExpander loads every dependencies/items/*.json under itemsDir into an
+// in-memory cache up front, so Expand never touches disk again afterward.
+func NewExpander(itemsDir string, policy ExpandPolicy, cycle CyclePolicy) (*Expander, error) {
+	e := &Expander{
+		itemsDir: itemsDir,
+		policy:   policy,
+		cycle:    cycle,
+		cache:    make(map[string]Item),
+		memo:     make(map[string]map[string]int),
+	}
+
+	entries, err := os.ReadDir(itemsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return e, nil
+		}
+		return nil, fmt.Errorf("reading items dir '%s': %w", itemsDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := os.ReadFile(filepath.Join(itemsDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading item '%s': %w", name, err)
+		}
+		var item Item
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("parsing item '%s': %w", name, err)
+		}
+		e.cache[name] = item
+	}
+	return e, nil
+}
+
+// Expand flattens itemName, crafted qty times, into base ingredients -
+// itemName: amount - following e's ExpandPolicy/CyclePolicy instead of
+// expandItem's hard-coded prompt-and-backtrack behavior.
+func (e *Expander) Expand(itemName string, qty int) (map[string]int, error) {
+	return e.expand(itemName, qty, nil)
+}
+
+func (e *Expander) expand(itemName string, factor int, path []ItemStep) (map[string]int, error) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].name != itemName {
+			continue
+		}
+		switch e.cycle {
+		case TreatAsBase:
+			return map[string]int{itemName: factor}, nil
+		case Error:
+			return nil, fmt.Errorf("cycle detected expanding '%s'", itemName)
+		default: // BacktrackToRecent
+			return map[string]int{path[i].name: path[i].factor}, nil
+		}
+	}
+
+	item, found := e.cache[itemName]
+	if !found {
+		return map[string]int{itemName: factor}, nil
+	}
+	if memoized, ok := e.memo[itemName]; ok {
+		scaled := make(map[string]int, len(memoized))
+		for ing, amt := range memoized {
+			scaled[ing] += amt * factor
+		}
+		return scaled, nil
+	}
+
+	cells := recipeCells(item)
+	if cells == nil {
+		return map[string]int{itemName: factor}, nil
+	}
+	path = append(path, ItemStep{name: itemName, factor: factor})
+
+	aggregated := aggregateCells(cells)
+	final := make(map[string]int)
+	perUnit := make(map[string]int)
+	for ing, amt := range aggregated {
+		if _, hasRecipe := e.cache[ing]; !hasRecipe || !e.policy.ShouldExpand(ing) {
+			final[ing] += amt * factor
+			perUnit[ing] += amt
+			continue
+		}
+		subIngredients, err := e.expand(ing, amt, path)
+		if err != nil {
+			return nil, err
+		}
+		for sub, subAmt := range subIngredients {
+			final[sub] += subAmt * factor
+			perUnit[sub] += subAmt
+		}
+	}
+	e.memo[itemName] = perUnit
+	return final, nil
+}
+
+// recipeCells picks item's recipe cells the same way expandItem did: the
+// first single-output (count==1) entry in Recipes if present, else its
+// first Recipes entry, else the legacy singular Recipe field, else nil if
+// the item carries no recipe at all.
+func recipeCells(item Item) map[string]string {
+	if len(item.Recipes) > 0 {
+		chosen := &item.Recipes[0]
+		for i := range item.Recipes {
+			if item.Recipes[i].Count == 1 {
+				chosen = &item.Recipes[i]
+				break
+			}
+		}
+		return map[string]string{
+			"A1": chosen.A1, "A2": chosen.A2, "A3": chosen.A3,
+			"B1": chosen.B1, "B2": chosen.B2, "B3": chosen.B3,
+			"C1": chosen.C1, "C2": chosen.C2, "C3": chosen.C3,
+		}
+	}
+	r := item.Recipe
+	if r.A1 == "" && r.A2 == "" && r.A3 == "" && r.B1 == "" && r.B2 == "" &&
+		r.B3 == "" && r.C1 == "" && r.C2 == "" && r.C3 == "" {
+		return nil
+	}
+	return map[string]string{
+		"A1": r.A1, "A2": r.A2, "A3": r.A3,
+		"B1": r.B1, "B2": r.B2, "B3": r.B3,
+		"C1": r.C1, "C2": r.C2, "C3": r.C3,
+	}
+}
+
+// policyFromFlag maps wiz expand's --policy value to an ExpandPolicy.
+func policyFromFlag(name string) ExpandPolicy {
+	switch name {
+	case "none":
+		return ExpandNone
+	case "interactive":
+		return ExpandInteractive
+	default: // "all"
+		return ExpandAll
+	}
+}
+
+// cyclePolicyFromFlag maps wiz expand's --cycle value to a CyclePolicy.
+func cyclePolicyFromFlag(name string) CyclePolicy {
+	switch name {
+	case "base":
+		return TreatAsBase
+	case "error":
+		return Error
+	default: // "backtrack"
+		return BacktrackToRecent
+	}
+}
+
+// runExpandCommand is the non-interactive `wiz expand --item X --qty N
+// --policy all --cycle base --format json` entry point.
+func runExpandCommand(args []string) error {
+	fs := flag.NewFlagSet("expand", flag.ExitOnError)
+	item := fs.String("item", "", "top-level item to expand")
+	qty := fs.Int("qty", 1, "quantity of the top-level item")
+	policy := fs.String("policy", "all", "expand policy: all, none, or interactive")
+	cycle := fs.String("cycle", "backtrack", "cycle policy: backtrack, base, or error")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	if *item == "" {
+		return fmt.Errorf("--item is required")
+	}
+
+	expander, err := NewExpander(filepath.Join("dependencies", "items"), policyFromFlag(*policy), cyclePolicyFromFlag(*cycle))
+	if err != nil {
+		return err
+	}
+	result, err := expander.Expand(*item, *qty)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	fmt.Printf("Aggregated base ingredients for %s:\n", *item)
+	for ing, amt := range result {
+		fmt.Printf("%s: %d\n", ing, amt)
+	}
+	return nil
+}
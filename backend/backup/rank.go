@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// RankedResult is one product's C10MResult plus its id, for RankAllProducts'
+// sorted output.
+type RankedResult struct {
+	Product string `json:"product"`
+	C10MResult
+}
+
+// RankAllProducts runs computeC10MResult for every product in apiResp and
+// returns the results sorted by BestEstimate ascending - cheapest
+// acquisition cost first. Products ResolvePrices/calculateC10M can't price
+// (no metrics entry, empty book) are skipped rather than included with a
+// sentinel value, so a caller doesn't have to filter Inf/NaN out itself.
+func RankAllProducts(metrics []ProductMetrics, apiResp *HypixelAPIResponse, qty float64) []RankedResult {
+	results := make([]RankedResult, 0, len(apiResp.Products))
+	for prod := range apiResp.Products {
+		result, _, _, err := computeC10MResult(prod, qty, apiResp, metrics)
+		if err != nil || math.IsInf(result.BestEstimate, 0) || math.IsNaN(result.BestEstimate) {
+			continue
+		}
+		results = append(results, RankedResult{Product: prod, C10MResult: result})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].BestEstimate < results[j].BestEstimate
+	})
+	return results
+}
+
+// rankFilterOpts are wiz rank's --min-weekly-volume/--exclude-npc/--top
+// flags, applied after RankAllProducts sorts the full board.
+type rankFilterOpts struct {
+	minWeeklyVolume float64
+	excludeNPC      bool
+	top             int
+}
+
+// applyRankFilters narrows a sorted RankAllProducts board down to what
+// runRankCommand should actually print. Weekly volume comes from the
+// metrics file (SellFrequency*SellSize, the same supply-rate calculation
+// calculateC10M itself uses), since HypixelAPIResponse here carries no
+// moving-week figure of its own. NPC-sold items are recognized by the
+// "NPC fee" amount this API doesn't expose either, so --exclude-npc falls
+// back to filtering by the product ID prefixes Hypixel uses for
+// NPC-bought items.
+func applyRankFilters(results []RankedResult, metrics []ProductMetrics, opts rankFilterOpts) []RankedResult {
+	metricsByID := make(map[string]ProductMetrics, len(metrics))
+	for _, m := range metrics {
+		metricsByID[m.ProductID] = m
+	}
+
+	filtered := make([]RankedResult, 0, len(results))
+	for _, r := range results {
+		if opts.minWeeklyVolume > 0 {
+			pm, ok := metricsByID[r.Product]
+			weeklyVolume := pm.SellSize * pm.SellFrequency * 7
+			if !ok || weeklyVolume < opts.minWeeklyVolume {
+				continue
+			}
+		}
+		if opts.excludeNPC && isNPCSoldProduct(r.Product) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	if opts.top > 0 && len(filtered) > opts.top {
+		filtered = filtered[:opts.top]
+	}
+	return filtered
+}
+
+// isNPCSoldProduct recognizes the handful of product-id prefixes Hypixel's
+// Bazaar uses for NPC-bought items (enchanted seeds/ores the NPC sells
+// directly rather than players).
+func isNPCSoldProduct(prod string) bool {
+	npcPrefixes := []string{"ENCHANTED_", "MUTANT_"}
+	for _, prefix := range npcPrefixes {
+		if strings.HasPrefix(prod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// printRankTable renders results as a text/tabwriter table with the columns
+// this chunk's request asks for.
+func printRankTable(w *tabwriter.Writer, results []RankedResult) {
+	fmt.Fprintln(w, "Product\tBestEstimate\tBestMethod\tIF\tRR\tDeltaRatio")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%.2f\t%s\t%.4f\t%.2f\t%.4f\n", r.Product, r.BestEstimate, r.BestMethod, r.IF, r.RR, r.DeltaRatio)
+	}
+}
+
+// runRankCommand is `wiz rank`: loads metrics and fetches the Bazaar once,
+// ranks every product via RankAllProducts, applies the requested filters,
+// and prints the board in the requested format.
+func runRankCommand(args []string) error {
+	fs := flag.NewFlagSet("rank", flag.ExitOnError)
+	qty := fs.Float64("qty", 1, "quantity to price each product at")
+	minWeeklyVolume := fs.Float64("min-weekly-volume", 0, "skip products with less than this much weekly sell volume")
+	excludeNPC := fs.Bool("exclude-npc", false, "skip NPC-sold products")
+	top := fs.Int("top", 0, "limit to the top N cheapest products (0 = no limit)")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+
+	apiResp, err := fetchBazaar()
+	if err != nil {
+		return err
+	}
+	metrics := loadMetrics("latest_metrics.json")
+
+	results := RankAllProducts(metrics, apiResp, *qty)
+	results = applyRankFilters(results, metrics, rankFilterOpts{
+		minWeeklyVolume: *minWeeklyVolume,
+		excludeNPC:      *excludeNPC,
+		top:             *top,
+	})
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		printRankTable(w, results)
+		return w.Flush()
+	}
+}
@@ -0,0 +1,193 @@
+// acquisition_path.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// AcquisitionPathStep is one leg of a findBestAcquisitionPath result: either
+// a direct Bazaar acquisition (Method is whatever getBestC10M chose, e.g.
+// "Primary"/"Secondary") or "Craft", meaning qty units of ItemID were
+// produced by crafting from the steps immediately following it in the
+// returned slice.
+type AcquisitionPathStep struct {
+	ItemID string  `json:"item_id"`
+	Qty    float64 `json:"qty"`
+	Method string  `json:"method"`
+	Cost   float64 `json:"cost"`
+}
+
+// AcquisitionPathConfig bounds how deep findBestAcquisitionPath is willing
+// to recurse into a recipe's own ingredients' recipes - e.g. discovering
+// that ENCHANTED_X is cheaper built from 160*X is depth 1; if X were itself
+// craftable from Y, considering that substitution too would be depth 2.
+// MaxDepth of 0 would mean "never substitute, always buy direct", so the
+// default is 3.
+type AcquisitionPathConfig struct {
+	MaxDepth int
+}
+
+var defaultAcquisitionPathConfig = AcquisitionPathConfig{MaxDepth: 3}
+
+var (
+	acquisitionPathConfigMu      sync.RWMutex
+	currentAcquisitionPathConfig = defaultAcquisitionPathConfig
+)
+
+// SetAcquisitionPathConfig installs cfg as the depth cap used by future
+// findBestAcquisitionPath calls.
+func SetAcquisitionPathConfig(cfg AcquisitionPathConfig) {
+	acquisitionPathConfigMu.Lock()
+	currentAcquisitionPathConfig = cfg
+	acquisitionPathConfigMu.Unlock()
+}
+
+func getAcquisitionPathConfig() AcquisitionPathConfig {
+	acquisitionPathConfigMu.RLock()
+	defer acquisitionPathConfigMu.RUnlock()
+	return currentAcquisitionPathConfig
+}
+
+// acquisitionPathMemoEntry is one findBestAcquisitionPathDFS result cached
+// by (itemID, quantity bucket) - see acquisitionQuantityBucket.
+type acquisitionPathMemoEntry struct {
+	cost float64
+	path []AcquisitionPathStep
+}
+
+// acquisitionQuantityBucket groups qty into a quarter-octave (log2/4)
+// bucket so memoization hits across the many near-identical quantities a
+// recipe tree's ceil(qty/CraftedAmount) scaling tends to produce (e.g. 159
+// vs 160 vs 161 units of the same ingredient), without conflating wildly
+// different order-of-magnitude quantities that could price very
+// differently against a thin order book.
+func acquisitionQuantityBucket(qty float64) int {
+	if qty <= 0 {
+		return 0
+	}
+	return int(math.Round(math.Log2(qty) * 4))
+}
+
+// findBestAcquisitionPath searches recipes (the same itemID ->
+// recipeGraphNode adjacency loadRecipeGraph builds for arbitrage.go) for
+// the cheapest way to acquire quantity units of itemID: either buy it
+// directly via getBestC10M, or - borrowing arbitrage.go's graph-walking
+// idea - craft it from its recipe's ingredients, each acquired recursively
+// the same way, up to AcquisitionPathConfig.MaxDepth levels deep. This is
+// how the module can discover that buying 160 raw items and enchanting is
+// cheaper than buying one enchanted item outright, without that
+// substitution being hard-coded anywhere by the caller.
+//
+// The search is a bounded DFS with memoization keyed by
+// (itemID, quantityBucket) so the same ingredient recurring across
+// multiple branches (or at nearly the same quantity from ceil-rounded
+// batch sizes) is only priced once. Before recursing into a recipe's
+// ingredients, their lower-bound cost - each leg's qty priced at its raw
+// top-of-book sell price, the cheapest any acquisition method could
+// possibly achieve - is checked against the current best; a branch whose
+// lower bound already exceeds it is skipped without paying for the
+// recursive getBestC10M calls a full evaluation would require.
+func findBestAcquisitionPath(
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	recipes map[string]recipeGraphNode,
+) (path []AcquisitionPathStep, totalCost float64, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	if quantity <= 0 {
+		return nil, 0, fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+	}
+
+	memo := make(map[string]acquisitionPathMemoEntry)
+	cost, steps, dfsErr := findBestAcquisitionPathDFS(itemIDNorm, quantity, apiResp, metricsMap, recipes, getAcquisitionPathConfig().MaxDepth, 0, memo)
+	if dfsErr != nil {
+		return nil, 0, dfsErr
+	}
+	if math.IsInf(cost, 0) || math.IsNaN(cost) {
+		return nil, 0, fmt.Errorf("no feasible acquisition path found for %.2f x %s", quantity, itemIDNorm)
+	}
+	return steps, cost, nil
+}
+
+// findBestAcquisitionPathDFS is findBestAcquisitionPath's recursive core.
+// itemID must already be normalized via BAZAAR_ID.
+func findBestAcquisitionPathDFS(
+	itemID string,
+	qty float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	recipes map[string]recipeGraphNode,
+	maxDepth int,
+	depth int,
+	memo map[string]acquisitionPathMemoEntry,
+) (cost float64, path []AcquisitionPathStep, err error) {
+
+	memoKey := fmt.Sprintf("%s|%d", itemID, acquisitionQuantityBucket(qty))
+	if cached, ok := memo[memoKey]; ok {
+		return cached.cost, cached.path, nil
+	}
+
+	best := math.Inf(1)
+	var bestPath []AcquisitionPathStep
+
+	buyCost, buyMethod, _, _, _, buyErr := getBestC10M(context.Background(), itemID, qty, apiResp, metricsMap, PrecisionFloat, nil)
+	if buyErr == nil || (!math.IsInf(buyCost, 0) && !math.IsNaN(buyCost)) {
+		if !math.IsInf(buyCost, 0) && !math.IsNaN(buyCost) && buyCost >= 0 {
+			best = buyCost
+			bestPath = []AcquisitionPathStep{{ItemID: itemID, Qty: qty, Method: buyMethod, Cost: buyCost}}
+		}
+	}
+
+	if depth < maxDepth {
+		if node, hasRecipe := recipes[itemID]; hasRecipe && node.CraftedAmount > 0 && len(node.Ingredients) > 0 {
+			batches := math.Ceil(qty / node.CraftedAmount)
+
+			lowerBound := 0.0
+			lowerBoundKnown := true
+			for ingID, qtyPerCraft := range node.Ingredients {
+				legQty := qtyPerCraft * batches
+				ingData, ok := safeGetProductData(apiResp, BAZAAR_ID(ingID))
+				if !ok || len(ingData.SellSummary) == 0 {
+					lowerBoundKnown = false
+					break
+				}
+				lowerBound += legQty * ingData.SellSummary[0].PricePerUnit
+			}
+
+			if lowerBoundKnown && lowerBound < best {
+				craftCost := 0.0
+				craftLegs := make([]AcquisitionPathStep, 0, len(node.Ingredients))
+				feasible := true
+				for ingID, qtyPerCraft := range node.Ingredients {
+					legQty := qtyPerCraft * batches
+					legCost, legPath, legErr := findBestAcquisitionPathDFS(BAZAAR_ID(ingID), legQty, apiResp, metricsMap, recipes, maxDepth, depth+1, memo)
+					if legErr != nil || math.IsInf(legCost, 0) || math.IsNaN(legCost) {
+						feasible = false
+						break
+					}
+					craftCost += legCost
+					craftLegs = append(craftLegs, legPath...)
+				}
+				if feasible {
+					// bazaarTaxRate (craft_state.go) is the only conversion-style
+					// tax rate this codebase tracks; reused here as an
+					// approximation of whatever fee crafting itself might carry,
+					// since Hypixel doesn't expose one directly through the API.
+					craftCost *= 1 + bazaarTaxRate
+					if craftCost < best {
+						best = craftCost
+						bestPath = append([]AcquisitionPathStep{{ItemID: itemID, Qty: qty, Method: "Craft", Cost: craftCost}}, craftLegs...)
+					}
+				}
+			}
+		}
+	}
+
+	memo[memoKey] = acquisitionPathMemoEntry{cost: best, path: bestPath}
+	return best, bestPath, nil
+}
@@ -0,0 +1,240 @@
+// acquisition_resolver.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AcquisitionResolver prices one quantity of one item through a single
+// acquisition source. calculateC10MForNode's bazaar-only pipeline is itself
+// the first (and, for most items, only) resolver most callers need; this
+// interface exists so an item with no bazaar listing - NPC-shop-only gear,
+// dark auction exclusives - doesn't have to collapse straight to
+// Method: "N/A" the way it always has, when AcquisitionFallbackResolvers has
+// something that can actually price it.
+type AcquisitionResolver interface {
+	// Resolve prices qty units of itemID, or returns an error if this
+	// resolver has no answer for itemID (not found in its price source, API
+	// call failed, etc.) - a plain "can't help with this one", not
+	// necessarily a fault.
+	Resolve(ctx context.Context, itemID string, qty float64) (BaseIngredientDetail, error)
+}
+
+// AcquisitionFallbackResolvers is tried, in order, for an item
+// calculateC10MForNode's bazaar pipeline (getBestC10M) couldn't price -
+// empty by default, so a deployment that hasn't configured an NPC price
+// table or auction client keeps today's "N/A" behavior. Every resolver is
+// asked and the cheapest successful answer wins; each successful answer is
+// recorded on the node's AttemptedAcquisitions so a caller can see the
+// alternatives. Resolvers that returned an error are not recorded.
+var AcquisitionFallbackResolvers []AcquisitionResolver
+
+// BazaarC10MResolver wraps getBestC10M as an AcquisitionResolver, so it can
+// sit in the same ordered list as NPCShopResolver/AuctionLowestBinResolver
+// for a caller that wants to pick among bazaar/NPC/auction explicitly
+// (tryAcquisitionResolvers below) rather than only falling back to them
+// after the bazaar pipeline already ran.
+type BazaarC10MResolver struct {
+	APIResp    *HypixelAPIResponse
+	MetricsMap map[string]ProductMetrics
+}
+
+func (r BazaarC10MResolver) Resolve(ctx context.Context, itemID string, qty float64) (BaseIngredientDetail, error) {
+	cost, method, assocCost, rr, ifVal, err := getBestC10M(ctx, itemID, qty, r.APIResp, r.MetricsMap, PrecisionFloat, nil)
+	if err != nil {
+		return BaseIngredientDetail{}, err
+	}
+	return BaseIngredientDetail{
+		Quantity: qty, Method: method, BestCost: toJSONFloat64(valueOrNaN(cost)),
+		AssociatedCost: toJSONFloat64(valueOrNaN(assocCost)), RR: toJSONFloat64(valueOrNaN(rr)), IF: toJSONFloat64(valueOrNaN(ifVal)),
+	}, nil
+}
+
+// NPCShopResolver prices an item from a static itemID -> coins-per-unit
+// table, the way an NPC shop's price never moves with bazaar supply/demand.
+type NPCShopResolver struct {
+	Prices map[string]float64
+}
+
+// LoadNPCShopResolver reads a JSON object of {"ITEM_ID": pricePerUnit, ...}
+// from path - the same flat-table shape as NormalizationOverrideFile's JSON,
+// so an operator maintaining one hand-curated price file uses a format
+// they've already seen.
+func LoadNPCShopResolver(path string) (*NPCShopResolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadNPCShopResolver: reading %s: %w", path, err)
+	}
+	prices := make(map[string]float64)
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("LoadNPCShopResolver: parsing %s: %w", path, err)
+	}
+	return &NPCShopResolver{Prices: prices}, nil
+}
+
+func (r *NPCShopResolver) Resolve(ctx context.Context, itemID string, qty float64) (BaseIngredientDetail, error) {
+	if r == nil || r.Prices == nil {
+		return BaseIngredientDetail{}, fmt.Errorf("NPCShopResolver: no price table loaded")
+	}
+	price, ok := r.Prices[BAZAAR_ID(itemID)]
+	if !ok || price <= 0 {
+		return BaseIngredientDetail{}, fmt.Errorf("NPCShopResolver: no NPC price for %s", itemID)
+	}
+	return BaseIngredientDetail{Quantity: qty, Method: "NPC", BestCost: toJSONFloat64(price * qty)}, nil
+}
+
+// AuctionLowestBinResolver prices an item as the lowest active buy-it-now
+// listing on the Hypixel auction house - the acquisition path for gear and
+// cosmetics that never reach the bazaar at all. It re-fetches the (large,
+// paginated) active-auctions endpoint at most once per CacheTTL rather than
+// once per ingredient, the same per-caller-instance caching
+// fetchBazaarData's package-level cache gives the bazaar endpoint.
+type AuctionLowestBinResolver struct {
+	HTTPClient *http.Client
+	CacheTTL   time.Duration
+
+	mu          sync.Mutex
+	lowestBINs  map[string]float64
+	lastFetched time.Time
+}
+
+type hypixelAuctionPage struct {
+	Success    bool               `json:"success"`
+	TotalPages int                `json:"totalPages"`
+	Auctions   []hypixelAuctionAH `json:"auctions"`
+}
+
+type hypixelAuctionAH struct {
+	ItemName string `json:"item_name"`
+	Tag      string `json:"item_uuid"`
+	Bin      bool   `json:"bin"`
+	StartAuc int64  `json:"starting_bid"`
+	ItemID   string `json:"tag"`
+}
+
+func (r *AuctionLowestBinResolver) client() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+func (r *AuctionLowestBinResolver) cacheTTL() time.Duration {
+	if r.CacheTTL > 0 {
+		return r.CacheTTL
+	}
+	return 5 * time.Minute
+}
+
+// refresh re-fetches every page of /skyblock/auctions and keeps the lowest
+// BIN price seen per item tag, if the cached snapshot is older than
+// cacheTTL(). Active auctions (not BIN) are ignored, since they're not a
+// "pay this and get it now" price the rest of the pipeline can compare
+// against a bazaar instabuy.
+func (r *AuctionLowestBinResolver) refresh(ctx context.Context) error {
+	r.mu.Lock()
+	fresh := time.Since(r.lastFetched) < r.cacheTTL() && r.lowestBINs != nil
+	r.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	lowest := make(map[string]float64)
+	page := 0
+	totalPages := 1
+	for page < totalPages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		url := fmt.Sprintf("https://api.hypixel.net/v2/skyblock/auctions?page=%d", page)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return fmt.Errorf("AuctionLowestBinResolver: building request: %w", err)
+		}
+		resp, err := r.client().Do(req)
+		if err != nil {
+			return fmt.Errorf("AuctionLowestBinResolver: fetching page %d: %w", page, err)
+		}
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return fmt.Errorf("AuctionLowestBinResolver: reading page %d: %w", page, readErr)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("AuctionLowestBinResolver: page %d returned status %d", page, resp.StatusCode)
+		}
+		var parsed hypixelAuctionPage
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return fmt.Errorf("AuctionLowestBinResolver: parsing page %d: %w", page, err)
+		}
+		if !parsed.Success {
+			return fmt.Errorf("AuctionLowestBinResolver: page %d reported success=false", page)
+		}
+		for _, a := range parsed.Auctions {
+			if !a.Bin || a.ItemID == "" {
+				continue
+			}
+			id := strings.ToUpper(a.ItemID)
+			price := float64(a.StartAuc)
+			if existing, ok := lowest[id]; !ok || price < existing {
+				lowest[id] = price
+			}
+		}
+		totalPages = parsed.TotalPages
+		page++
+	}
+
+	r.mu.Lock()
+	r.lowestBINs = lowest
+	r.lastFetched = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *AuctionLowestBinResolver) Resolve(ctx context.Context, itemID string, qty float64) (BaseIngredientDetail, error) {
+	if err := r.refresh(ctx); err != nil {
+		return BaseIngredientDetail{}, err
+	}
+	r.mu.Lock()
+	price, ok := r.lowestBINs[BAZAAR_ID(itemID)]
+	r.mu.Unlock()
+	if !ok || price <= 0 {
+		return BaseIngredientDetail{}, fmt.Errorf("AuctionLowestBinResolver: no BIN listing for %s", itemID)
+	}
+	return BaseIngredientDetail{Quantity: qty, Method: "Auction", BestCost: toJSONFloat64(price * qty)}, nil
+}
+
+// resolveFallbackAcquisitions tries every one of AcquisitionFallbackResolvers
+// for itemID. It returns the cheapest successful detail (nil if every
+// resolver failed or none are configured) plus every successful detail,
+// sorted by BestCost ascending, for CraftingStepNode.AttemptedAcquisitions;
+// resolvers that returned an error are left out of that list.
+func resolveFallbackAcquisitions(ctx context.Context, itemID string, qty float64) (best *BaseIngredientDetail, attempted []BaseIngredientDetail) {
+	for _, resolver := range AcquisitionFallbackResolvers {
+		detail, err := resolver.Resolve(ctx, itemID, qty)
+		if err != nil {
+			continue
+		}
+		attempted = append(attempted, detail)
+		if best == nil {
+			d := detail
+			best = &d
+		}
+	}
+	sort.SliceStable(attempted, func(i, j int) bool {
+		return float64(attempted[i].BestCost) < float64(attempted[j].BestCost)
+	})
+	if len(attempted) > 0 {
+		best = &attempted[0]
+	}
+	return best, attempted
+}
@@ -0,0 +1,306 @@
+// acquisition_source.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Quote is one AcquisitionSource's priced answer for obtaining qty units of
+// an item: Cost in coins, Method naming which source produced it (e.g.
+// "Bazaar-Primary", "Auction", "Craft"), and Meta carrying source-specific
+// detail (RR/IF for Bazaar-Primary, the ingredient breakdown for Craft) for
+// a caller that wants the full story rather than just the winning number.
+// Err is set instead of a usable Cost when this source had no answer for
+// the item - a plain "can't help with this one", not necessarily a fault.
+type Quote struct {
+	Source string         `json:"source"`
+	Cost   float64        `json:"cost"`
+	Method string         `json:"method"`
+	Meta   map[string]any `json:"meta,omitempty"`
+	Err    string         `json:"err,omitempty"`
+}
+
+// AcquisitionSource prices qty units of itemID through one acquisition
+// route. getBestAcquisition queries every registered source concurrently
+// and keeps the cheapest - unlike resolveFallbackAcquisitions, which only
+// keeps the AcquisitionResolvers that succeeded, the []Quote breakdown
+// getBestAcquisition returns holds one entry per source, failures included
+// (with Err set), so it shows every route considered.
+type AcquisitionSource interface {
+	// Name identifies this source for Quote.Source.
+	Name() string
+	// Quote prices qty units of itemID, or returns an error if this source
+	// has no answer for itemID (no listing, no recipe, API data missing).
+	Quote(ctx context.Context, itemID string, qty float64) (cost float64, method string, meta map[string]any, err error)
+}
+
+// bazaarPrimarySource quotes the IF/RR-adjusted cost of a buy order at
+// SellSummary's top price, the same math getBestC10M's Primary branch
+// uses - but standalone, so a dispatcher comparing it against
+// Auction/NPC/Craft quotes sees it without getBestC10M's own internal
+// Primary-vs-Secondary pick masking it.
+type bazaarPrimarySource struct {
+	APIResp    *HypixelAPIResponse
+	MetricsMap map[string]ProductMetrics
+}
+
+func (s bazaarPrimarySource) Name() string { return "Bazaar-Primary" }
+
+func (s bazaarPrimarySource) Quote(ctx context.Context, itemID string, qty float64) (float64, string, map[string]any, error) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(s.APIResp, itemIDNorm)
+	if !apiOk || len(productData.SellSummary) == 0 {
+		return 0, "", nil, fmt.Errorf("Bazaar-Primary: no sell orders for %s", itemIDNorm)
+	}
+	metricsData, metricsOk := safeGetMetricsData(s.MetricsMap, itemIDNorm)
+	if !metricsOk {
+		return 0, "", nil, fmt.Errorf("Bazaar-Primary: no metrics for %s", itemIDNorm)
+	}
+	sellP := productData.SellSummary[0].PricePerUnit
+	// buyP is only a counterpart to satisfy calculateC10MInternal's input
+	// validation; its own c10mSecondary return is discarded below - the
+	// Secondary side is bazaarSecondarySource's job.
+	buyP := sellP
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	c10mPrim, _, ifVal, rrVal, _, _, err := calculateC10MInternal(itemIDNorm, qty, sellP, buyP, metricsData, nil)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("Bazaar-Primary: %w", err)
+	}
+	if math.IsInf(c10mPrim, 0) || math.IsNaN(c10mPrim) {
+		return 0, "", nil, fmt.Errorf("Bazaar-Primary: no viable buy-order fill for %s", itemIDNorm)
+	}
+	return c10mPrim, "Bazaar-Primary", map[string]any{"rr": rrVal, "if": ifVal, "sellP": sellP}, nil
+}
+
+// bazaarSecondarySource quotes the plain insta-buy cost at BuySummary's top
+// price - the cost-only half of getBestC10M's Secondary branch.
+type bazaarSecondarySource struct {
+	APIResp *HypixelAPIResponse
+}
+
+func (s bazaarSecondarySource) Name() string { return "Bazaar-Secondary" }
+
+func (s bazaarSecondarySource) Quote(ctx context.Context, itemID string, qty float64) (float64, string, map[string]any, error) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(s.APIResp, itemIDNorm)
+	if !apiOk || len(productData.BuySummary) == 0 {
+		return 0, "", nil, fmt.Errorf("Bazaar-Secondary: no buy orders for %s", itemIDNorm)
+	}
+	buyP := productData.BuySummary[0].PricePerUnit
+	if buyP <= 0 {
+		return 0, "", nil, fmt.Errorf("Bazaar-Secondary: invalid top buy price for %s", itemIDNorm)
+	}
+	return qty * buyP, "Bazaar-Secondary", map[string]any{"buyP": buyP}, nil
+}
+
+// resolverSource adapts an existing AcquisitionResolver (NPCShopResolver,
+// AuctionLowestBinResolver) into an AcquisitionSource, so getBestAcquisition
+// can query them alongside Bazaar/Craft without those resolvers needing a
+// second, parallel interface.
+type resolverSource struct {
+	name     string
+	resolver AcquisitionResolver
+}
+
+func (s resolverSource) Name() string { return s.name }
+
+func (s resolverSource) Quote(ctx context.Context, itemID string, qty float64) (float64, string, map[string]any, error) {
+	detail, err := s.resolver.Resolve(ctx, itemID, qty)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return float64(detail.BestCost), detail.Method, nil, nil
+}
+
+// maxCraftSourceDepth bounds craftSource's recursive ingredient pricing so
+// a recipe cycle the visited-set guard somehow missed, or a genuinely deep
+// chain, fails fast instead of recursing forever.
+const maxCraftSourceDepth = 12
+
+// craftVisitState is threaded through nested Quote calls via context so
+// craftSource can detect a cycle (an ingredient that's also one of its own
+// ancestors in this dispatch) without AcquisitionSource.Quote's signature
+// needing a visited-set parameter every other source ignores.
+type craftVisitState struct {
+	visited map[string]bool
+	depth   int
+}
+
+type craftVisitContextKey struct{}
+
+// withCraftVisit returns a context with itemIDNorm added to the current
+// dispatch's visited set, or an error if itemIDNorm is already on it (a
+// cycle) or the recursion has already reached maxDepth.
+func withCraftVisit(ctx context.Context, itemIDNorm string, maxDepth int) (context.Context, error) {
+	state, _ := ctx.Value(craftVisitContextKey{}).(*craftVisitState)
+	if state == nil {
+		next := &craftVisitState{visited: map[string]bool{itemIDNorm: true}, depth: 1}
+		return context.WithValue(ctx, craftVisitContextKey{}, next), nil
+	}
+	if state.visited[itemIDNorm] {
+		return ctx, fmt.Errorf("recipe cycle detected at %s", itemIDNorm)
+	}
+	if state.depth >= maxDepth {
+		return ctx, fmt.Errorf("max recursion depth (%d) reached at %s", maxDepth, itemIDNorm)
+	}
+	next := &craftVisitState{visited: make(map[string]bool, len(state.visited)+1), depth: state.depth + 1}
+	for k := range state.visited {
+		next.visited[k] = true
+	}
+	next.visited[itemIDNorm] = true
+	return context.WithValue(ctx, craftVisitContextKey{}, next), nil
+}
+
+// craftSource prices itemID by decomposing it one level into its recipe
+// ingredients (via the same flattenedIngredients expansionMemo uses) and
+// pricing each ingredient by calling back into Sources - including this
+// same craftSource, so a multi-level recipe recurses naturally. The
+// visited/depth guard lives in the context withCraftVisit installs rather
+// than on craftSource itself, so one craftSource value can be shared (and
+// its expansionMemo reused) across every ingredient in the tree.
+type craftSource struct {
+	ItemFilesDir string
+	APIResp      *HypixelAPIResponse
+	MetricsMap   map[string]ProductMetrics
+	Sources      []AcquisitionSource
+	MaxDepth     int
+
+	memo *expansionMemo
+}
+
+func (s *craftSource) Name() string { return "Craft" }
+
+func (s *craftSource) Quote(ctx context.Context, itemID string, qty float64) (float64, string, map[string]any, error) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	maxDepth := s.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = maxCraftSourceDepth
+	}
+	childCtx, cycleErr := withCraftVisit(ctx, itemIDNorm, maxDepth)
+	if cycleErr != nil {
+		return 0, "", nil, fmt.Errorf("Craft: %w", cycleErr)
+	}
+
+	memo := s.memo
+	if memo == nil {
+		memo = newExpansionMemo()
+	}
+	ingredients, hasRecipe, err := memo.flattenedIngredients(ctx, itemIDNorm, qty, s.ItemFilesDir, s.APIResp)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("Craft: %w", err)
+	}
+	if !hasRecipe || len(ingredients) == 0 {
+		return 0, "", nil, fmt.Errorf("Craft: no recipe for %s", itemIDNorm)
+	}
+
+	total := 0.0
+	breakdown := make(map[string]any, len(ingredients))
+	for ingID, amt := range ingredients {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, "", nil, ctxErr
+		}
+		cost, method, _, ingErr := getBestAcquisition(childCtx, ingID, amt, s.Sources)
+		if ingErr != nil {
+			return 0, "", nil, fmt.Errorf("Craft: pricing ingredient %s: %w", ingID, ingErr)
+		}
+		total += cost
+		breakdown[ingID] = map[string]any{"quantity": amt, "cost": cost, "method": method}
+	}
+	return total, "Craft", map[string]any{"ingredients": breakdown}, nil
+}
+
+// dispatch queries every source in sources concurrently for qty units of
+// itemID, collecting one Quote from each - Err set for sources that had no
+// answer - so getBestAcquisition's full breakdown reflects every route
+// considered, not just the winner.
+func dispatch(ctx context.Context, itemID string, qty float64, sources []AcquisitionSource) []Quote {
+	quotes := make([]Quote, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src AcquisitionSource) {
+			defer wg.Done()
+			cost, method, meta, err := src.Quote(ctx, itemID, qty)
+			q := Quote{Source: src.Name(), Cost: cost, Method: method, Meta: meta}
+			if err != nil {
+				q.Err = err.Error()
+			}
+			quotes[i] = q
+		}(i, src)
+	}
+	wg.Wait()
+	return quotes
+}
+
+// bestOf picks the lowest-cost Quote among those without an Err, returning
+// ok=false if every source failed to price the item.
+func bestOf(quotes []Quote) (best Quote, ok bool) {
+	for _, q := range quotes {
+		if q.Err != "" {
+			continue
+		}
+		if !ok || q.Cost < best.Cost {
+			best, ok = q, true
+		}
+	}
+	return best, ok
+}
+
+// getBestAcquisition is getBestC10M's multi-source successor: instead of
+// only choosing between a Bazaar buy order and insta-buy, it queries every
+// source in sources (Bazaar-Primary, Bazaar-Secondary, and whichever of
+// NPC/Auction/Craft the caller registered - see DefaultAcquisitionSources)
+// concurrently and returns the cheapest, alongside a full []Quote
+// breakdown so a caller can see what every route would have cost. It
+// answers "what is the cheapest way to obtain X" rather than only "what is
+// the cheapest Bazaar route" - getBestC10M itself is left untouched since
+// it already has many callers across the tree relying on its exact return
+// shape.
+func getBestAcquisition(ctx context.Context, itemID string, qty float64, sources []AcquisitionSource) (bestCost float64, bestMethod string, quotes []Quote, err error) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return math.Inf(1), "N/A", nil, ctxErr
+	}
+	if qty <= 0 {
+		return 0, "N/A", nil, fmt.Errorf("quantity must be positive (got %.2f for %s)", qty, itemIDNorm)
+	}
+	if len(sources) == 0 {
+		return math.Inf(1), "N/A", nil, fmt.Errorf("no acquisition sources registered for %s", itemIDNorm)
+	}
+
+	quotes = dispatch(ctx, itemID, qty, sources)
+	best, ok := bestOf(quotes)
+	if !ok {
+		return math.Inf(1), "N/A", quotes, fmt.Errorf("no acquisition source could price %s", itemIDNorm)
+	}
+	return best.Cost, best.Method, quotes, nil
+}
+
+// DefaultAcquisitionSources builds the standard source set getBestAcquisition
+// queries: Bazaar split into Primary/Secondary so each is comparable on its
+// own, npc/auction as thin AcquisitionResolver adapters (nil-safe - a
+// deployment without an NPC price table or auction client just omits that
+// source, mirroring AcquisitionFallbackResolvers' default), and Craft
+// recursing back through this same set for its own ingredients.
+func DefaultAcquisitionSources(itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, npc *NPCShopResolver, auction *AuctionLowestBinResolver) []AcquisitionSource {
+	sources := []AcquisitionSource{
+		bazaarPrimarySource{APIResp: apiResp, MetricsMap: metricsMap},
+		bazaarSecondarySource{APIResp: apiResp},
+	}
+	if npc != nil {
+		sources = append(sources, resolverSource{name: "NPC", resolver: npc})
+	}
+	if auction != nil {
+		sources = append(sources, resolverSource{name: "Auction", resolver: auction})
+	}
+
+	craft := &craftSource{ItemFilesDir: itemFilesDir, APIResp: apiResp, MetricsMap: metricsMap, memo: newExpansionMemo()}
+	sources = append(sources, craft)
+	craft.Sources = sources
+	return sources
+}
@@ -0,0 +1,160 @@
+// acquisition_strategy.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Candidate is one acquisition method considered for a top-level item -
+// Craft, Primary, or Secondary - alongside the raw numbers PerformDualExpansion
+// already computed for it, so an AcquisitionStrategy can score it without
+// re-deriving anything from apiResp/metricsMap itself.
+type Candidate struct {
+	Method      string  `json:"method"`
+	CostRaw     float64 `json:"cost_raw"`
+	FillTimeRaw float64 `json:"fill_time_raw"`
+}
+
+// AcquisitionStrategy scores and picks among a top-level item's viable
+// Candidates. PerformDualExpansion's P1 chooser defers to
+// ExpansionOptions.Strategy (see acquisitionStrategy) instead of a
+// hard-coded cost comparison, so a caller can swap in a different notion of
+// "best" per request.
+type AcquisitionStrategy interface {
+	// Score rates one candidate; lower is better, in the same units as
+	// CostRaw (coins), so implementations can mix in penalties or bonuses
+	// without redefining the scale.
+	Score(ctx context.Context, c Candidate) float64
+	// Choose returns the lowest-scoring candidate plus a short human-readable
+	// reason suitable for ExpansionResult.DecisionReason. candidates is never
+	// empty - callers only invoke Choose once at least one candidate is
+	// viable.
+	Choose(ctx context.Context, candidates []Candidate) (Candidate, string)
+}
+
+// scoredStrategy implements Choose in terms of an embedded score function, so
+// each concrete strategy below only has to say how it scores one candidate.
+type scoredStrategy struct {
+	name  string
+	score func(ctx context.Context, c Candidate) float64
+}
+
+func (s scoredStrategy) Score(ctx context.Context, c Candidate) float64 { return s.score(ctx, c) }
+
+func (s scoredStrategy) Choose(ctx context.Context, candidates []Candidate) (Candidate, string) {
+	best := candidates[0]
+	bestScore := s.Score(ctx, best)
+	for _, c := range candidates[1:] {
+		if score := s.Score(ctx, c); score < bestScore {
+			best, bestScore = c, score
+		}
+	}
+	methods := make([]string, len(candidates))
+	for i, c := range candidates {
+		methods[i] = c.Method
+	}
+	sort.Strings(methods)
+	return best, fmt.Sprintf("%s: %s scored lowest (%.2f) among %v", s.name, best.Method, bestScore, methods)
+}
+
+// PureCostStrategy picks whichever candidate is cheapest - the historical,
+// unconditional behavior of PerformDualExpansion's P1 chooser, and the
+// default an ExpansionOptions with a nil Strategy resolves to.
+var PureCostStrategy AcquisitionStrategy = scoredStrategy{
+	name:  "pure-cost",
+	score: func(_ context.Context, c Candidate) float64 { return c.CostRaw },
+}
+
+// TimeWeightedStrategy adds fillTimeWeight coins per second of FillTimeRaw to
+// a candidate's cost before comparing, so a slow-filling cheap Primary can
+// lose out to a pricier but instant Secondary or Craft. An Inf fill time
+// scores Inf regardless of cost.
+func TimeWeightedStrategy(fillTimeWeight float64) AcquisitionStrategy {
+	return scoredStrategy{
+		name: "time-weighted",
+		score: func(_ context.Context, c Candidate) float64 {
+			if math.IsInf(c.FillTimeRaw, 1) {
+				return math.Inf(1)
+			}
+			return c.CostRaw + fillTimeWeight*c.FillTimeRaw
+		},
+	}
+}
+
+// CraftTolerancePctStrategy prefers Craft over a nominally cheaper
+// Primary/Secondary as long as Craft's own cost is within tolerancePct of
+// it, for a power user who'd rather not tie up buy-order capital to save a
+// marginal amount.
+func CraftTolerancePctStrategy(tolerancePct float64) AcquisitionStrategy {
+	return scoredStrategy{
+		name: "craft-tolerance",
+		score: func(_ context.Context, c Candidate) float64 {
+			if c.Method == "Craft" {
+				return c.CostRaw / (1 + tolerancePct)
+			}
+			return c.CostRaw
+		},
+	}
+}
+
+// forceMethodPenalty is added to every non-matching candidate's cost in
+// ForceMethodStrategy, large enough that it never outranks a genuinely
+// matching candidate at any realistic Bazaar price, but finite so Choose
+// still falls back to cheapest-among-the-rest (rather than an Inf/Inf tie
+// that would just pick candidates[0]) when method isn't viable at all.
+const forceMethodPenalty = 1e18
+
+// ForceMethodStrategy prefers method (Craft/Primary/Secondary) whenever
+// it's among the candidates, falling back to cheapest-of-the-rest when a
+// scenario config forces a method that isn't viable for this item (e.g.
+// Primary for an item with no buy orders).
+func ForceMethodStrategy(method string) AcquisitionStrategy {
+	return scoredStrategy{
+		name: "force-" + method,
+		score: func(_ context.Context, c Candidate) float64 {
+			if c.Method == method {
+				return c.CostRaw
+			}
+			return c.CostRaw + forceMethodPenalty
+		},
+	}
+}
+
+// PendingTimeoutStrategy rejects a Primary candidate whose FillTimeRaw
+// exceeds cutoffSeconds - the scenario config's "pendingMinutes before an
+// unfilled buy order is treated as instabuy" - deferring to inner for every
+// other candidate (and for Primary within the cutoff).
+func PendingTimeoutStrategy(cutoffSeconds float64, inner AcquisitionStrategy) AcquisitionStrategy {
+	if inner == nil {
+		inner = PureCostStrategy
+	}
+	return scoredStrategy{
+		name: "pending-timeout",
+		score: func(ctx context.Context, c Candidate) float64 {
+			if c.Method == "Primary" && cutoffSeconds > 0 && c.FillTimeRaw > cutoffSeconds {
+				return math.Inf(1)
+			}
+			return inner.Score(ctx, c)
+		},
+	}
+}
+
+// acquisitionStrategies is the name -> AcquisitionStrategy registry backing
+// AcquisitionStrategyByName, mirroring dashboard.go's dashboardRankMetrics
+// registry-by-string-key pattern.
+var acquisitionStrategies = map[string]AcquisitionStrategy{
+	"pure-cost":       PureCostStrategy,
+	"time-weighted":   TimeWeightedStrategy(1),
+	"craft-tolerance": CraftTolerancePctStrategy(0.05),
+}
+
+// AcquisitionStrategyByName looks up a registered strategy by name, for a
+// caller (e.g. an HTTP handler reading a query param) that only has a string
+// to configure ExpansionOptions.Strategy with.
+func AcquisitionStrategyByName(name string) (AcquisitionStrategy, bool) {
+	s, ok := acquisitionStrategies[name]
+	return s, ok
+}
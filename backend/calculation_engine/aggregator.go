@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // AnalysisResult matches the JSON structure from the Rust metrics generator.
@@ -27,7 +29,10 @@ type AnalysisResult struct {
 	PlayerInstasellTransactionSizeAverage float64 `json:"player_instasell_transaction_size_average"`
 }
 
-// AggregatedMetrics holds the running sum of metrics for a single product across multiple files.
+// AggregatedMetrics holds the running sum of metrics for a single product
+// across multiple files, plus every individual sample that went into each
+// sum so calculateAggregatedAverages can additionally derive stddev and
+// percentiles rather than only a mean.
 type AggregatedMetrics struct {
 	ProductID                                string
 	SumInstabuyPriceAverage                  float64
@@ -41,6 +46,183 @@ type AggregatedMetrics struct {
 	SumPlayerInstasellTransactionFrequency   float64
 	SumPlayerInstasellTransactionSizeAverage float64
 	FileCount                                int
+
+	SamplesInstabuyPriceAverage                  []float64
+	SamplesInstasellPriceAverage                  []float64
+	SamplesNewDemandOfferFrequencyAverage        []float64
+	SamplesNewDemandOfferSizeAverage             []float64
+	SamplesPlayerInstabuyTransactionFrequency    []float64
+	SamplesPlayerInstabuyTransactionSizeAverage  []float64
+	SamplesNewSupplyOfferFrequencyAverage        []float64
+	SamplesNewSupplyOfferSizeAverage             []float64
+	SamplesPlayerInstasellTransactionFrequency   []float64
+	SamplesPlayerInstasellTransactionSizeAverage []float64
+
+	// Timestamps is each contributing file's parsed "metrics_<ts>.json"
+	// time, one entry per sample index above (every field's Nth sample came
+	// from the same file as Timestamps[N]), so computeFieldStats can order
+	// samples oldest-to-newest for its EWMA regardless of the order files
+	// were downloaded/processed in.
+	Timestamps []time.Time
+}
+
+// FieldStats is one field's distribution across the samples fed into an
+// AggregatedMetrics entry: mean (the same value AnalysisResult's averaged
+// field already gives), sample standard deviation, p50/p90/p99 via linear
+// interpolation between the two nearest ranks, and min/max. Samples is the
+// sorted input this was computed from, for a caller that wants the raw
+// distribution rather than just its summary.
+type FieldStats struct {
+	Mean    float64   `json:"mean"`
+	EWMA    float64   `json:"ewma"`
+	Stddev  float64   `json:"stddev"`
+	P50     float64   `json:"p50"`
+	P90     float64   `json:"p90"`
+	P99     float64   `json:"p99"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+	Samples []float64 `json:"samples"`
+}
+
+// EWMAHalfLife is how long it takes a shock in a field's value to decay to
+// half its weight in computeFieldStats's EWMA - the config parameter the
+// time-decayed aggregation is built around. Override before aggregating if
+// a deployment wants to react faster/slower than the default hour.
+var EWMAHalfLife = time.Hour
+
+// AggregatedAnalysisResult is AnalysisResult's percentile/stddev-aware
+// counterpart: the same ten fields, each a FieldStats instead of a bare
+// averaged float64, for a caller (e.g. calculateC10M) that wants to reason
+// about volatility rather than just a flattened mean.
+type AggregatedAnalysisResult struct {
+	ProductID                             string     `json:"product_id"`
+	InstabuyPriceAverage                  FieldStats `json:"instabuy_price_average"`
+	InstasellPriceAverage                 FieldStats `json:"instasell_price_average"`
+	NewDemandOfferFrequencyAverage        FieldStats `json:"new_demand_offer_frequency_average"`
+	NewDemandOfferSizeAverage             FieldStats `json:"new_demand_offer_size_average"`
+	PlayerInstabuyTransactionFrequency    FieldStats `json:"player_instabuy_transaction_frequency"`
+	PlayerInstabuyTransactionSizeAverage  FieldStats `json:"player_instabuy_transaction_size_average"`
+	NewSupplyOfferFrequencyAverage        FieldStats `json:"new_supply_offer_frequency_average"`
+	NewSupplyOfferSizeAverage             FieldStats `json:"new_supply_offer_size_average"`
+	PlayerInstasellTransactionFrequency   FieldStats `json:"player_instasell_transaction_frequency"`
+	PlayerInstasellTransactionSizeAverage FieldStats `json:"player_instasell_transaction_size_average"`
+}
+
+// computeFieldStats derives a FieldStats from samples: mean and sample
+// stddev (two-pass, dividing by n-1 for n>1) over the unsorted input, and
+// min/max/percentiles over a sorted copy. Percentiles use linear
+// interpolation between the two nearest ranks (rank = p/100*(n-1)). An
+// empty samples returns every field as NaN; a single sample returns that
+// value for mean/min/max/every percentile and a 0 stddev.
+//
+// EWMA is the same samples, time-decayed: ordered oldest-to-newest by
+// timestamps (parallel to samples; mismatched lengths or fewer than two
+// samples fall back to EWMA == Mean), then folded as
+// ewma_t = alpha*x_t + (1-alpha)*ewma_{t-1} with
+// alpha = 1 - exp(-dt/EWMAHalfLife * ln(2)), dt the seconds since the
+// previous sample - so closely-spaced files barely move the average while
+// a gap lets the newest file dominate it.
+func computeFieldStats(samples []float64, timestamps []time.Time) FieldStats {
+	n := len(samples)
+	if n == 0 {
+		nan := math.NaN()
+		return FieldStats{Mean: nan, EWMA: nan, Stddev: nan, P50: nan, P90: nan, P99: nan, Min: nan, Max: nan, Samples: samples}
+	}
+
+	var sum float64
+	for _, v := range samples {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	var stddev float64
+	if n > 1 {
+		var sumSquaredDiff float64
+		for _, v := range samples {
+			d := v - mean
+			sumSquaredDiff += d * d
+		}
+		stddev = math.Sqrt(sumSquaredDiff / float64(n-1))
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	percentile := func(p float64) float64 {
+		if n == 1 {
+			return sorted[0]
+		}
+		rank := p / 100 * float64(n-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		if lower == upper {
+			return sorted[lower]
+		}
+		frac := rank - float64(lower)
+		return sorted[lower] + (sorted[upper]-sorted[lower])*frac
+	}
+
+	ewma := mean
+	if n > 1 && len(timestamps) == n {
+		type timedSample struct {
+			t time.Time
+			v float64
+		}
+		ordered := make([]timedSample, n)
+		for i, v := range samples {
+			ordered[i] = timedSample{t: timestamps[i], v: v}
+		}
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i].t.Before(ordered[j].t) })
+
+		halflifeSeconds := EWMAHalfLife.Seconds()
+		ewma = ordered[0].v
+		for i := 1; i < n; i++ {
+			dt := ordered[i].t.Sub(ordered[i-1].t).Seconds()
+			if dt < 0 {
+				dt = 0
+			}
+			alpha := 1.0
+			if halflifeSeconds > 0 {
+				alpha = 1 - math.Exp(-dt/halflifeSeconds*math.Ln2)
+			}
+			ewma = alpha*ordered[i].v + (1-alpha)*ewma
+		}
+	}
+
+	return FieldStats{
+		Mean:    mean,
+		EWMA:    ewma,
+		Stddev:  stddev,
+		P50:     percentile(50),
+		P90:     percentile(90),
+		P99:     percentile(99),
+		Min:     sorted[0],
+		Max:     sorted[n-1],
+		Samples: sorted,
+	}
+}
+
+// calculateAggregatedAverages is calculateAverages' percentile/stddev-aware
+// counterpart, deriving an AggregatedAnalysisResult per product from the
+// same per-field sample slices updateAggregator now records.
+func calculateAggregatedAverages(aggregator map[string]*AggregatedMetrics) []AggregatedAnalysisResult {
+	results := make([]AggregatedAnalysisResult, 0, len(aggregator))
+	for _, a := range aggregator {
+		results = append(results, AggregatedAnalysisResult{
+			ProductID:                             a.ProductID,
+			InstabuyPriceAverage:                  computeFieldStats(a.SamplesInstabuyPriceAverage, a.Timestamps),
+			InstasellPriceAverage:                 computeFieldStats(a.SamplesInstasellPriceAverage, a.Timestamps),
+			NewDemandOfferFrequencyAverage:        computeFieldStats(a.SamplesNewDemandOfferFrequencyAverage, a.Timestamps),
+			NewDemandOfferSizeAverage:             computeFieldStats(a.SamplesNewDemandOfferSizeAverage, a.Timestamps),
+			PlayerInstabuyTransactionFrequency:    computeFieldStats(a.SamplesPlayerInstabuyTransactionFrequency, a.Timestamps),
+			PlayerInstabuyTransactionSizeAverage:  computeFieldStats(a.SamplesPlayerInstabuyTransactionSizeAverage, a.Timestamps),
+			NewSupplyOfferFrequencyAverage:        computeFieldStats(a.SamplesNewSupplyOfferFrequencyAverage, a.Timestamps),
+			NewSupplyOfferSizeAverage:             computeFieldStats(a.SamplesNewSupplyOfferSizeAverage, a.Timestamps),
+			PlayerInstasellTransactionFrequency:   computeFieldStats(a.SamplesPlayerInstasellTransactionFrequency, a.Timestamps),
+			PlayerInstasellTransactionSizeAverage: computeFieldStats(a.SamplesPlayerInstasellTransactionSizeAverage, a.Timestamps),
+		})
+	}
+	return results
 }
 
 // runCommand executes a shell command and returns its output, logging any errors.
@@ -116,6 +298,12 @@ func latestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	aggregator := make(map[string]*AggregatedMetrics)
 
 	for _, filename := range filesToProcess {
+		fileTime, err := parseMetricsFilenameTimestamp(filename)
+		if err != nil {
+			log.Printf("Warning: %v; skipping file", err)
+			continue
+		}
+
 		remotePath := filepath.Join(remoteDir, filename)
 		localPath := filepath.Join(tmpDir, filename)
 
@@ -138,7 +326,7 @@ func latestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		updateAggregator(aggregator, results)
+		updateAggregator(aggregator, results, fileTime)
 	}
 
 	if len(aggregator) == 0 {
@@ -146,8 +334,8 @@ func latestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 7. Calculate the final averages
-	finalAverages := calculateAverages(aggregator)
+	// 7. Calculate the final per-field statistics (mean, stddev, percentiles)
+	finalAverages := calculateAggregatedAverages(aggregator)
 
 	// 8. Respond with the final JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -158,8 +346,28 @@ func latestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Successfully served /latest_metrics/ request.")
 }
 
-// updateAggregator processes a list of results from one file and adds them to the aggregator map.
-func updateAggregator(aggregator map[string]*AggregatedMetrics, results []AnalysisResult) {
+// metricsFilenameTimestampLayout matches the "YYYYMMDDHHMMSS" timestamp in a
+// "metrics_<ts>.json" filename.
+const metricsFilenameTimestampLayout = "20060102150405"
+
+// parseMetricsFilenameTimestamp extracts the timestamp from a
+// "metrics_<ts>.json" filename (the naming latestMetricsHandler's MEGA
+// listing already expects and sorts by). Callers should log and skip the
+// file on error rather than let one malformed filename abort aggregation.
+func parseMetricsFilenameTimestamp(filename string) (time.Time, error) {
+	name := strings.TrimSuffix(filepath.Base(filename), ".json")
+	name = strings.TrimPrefix(name, "metrics_")
+	ts, err := time.Parse(metricsFilenameTimestampLayout, name)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing timestamp from filename %q: %w", filename, err)
+	}
+	return ts, nil
+}
+
+// updateAggregator processes a list of results from one file (taken at
+// fileTime, its parsed "metrics_<ts>.json" timestamp) and adds them to the
+// aggregator map.
+func updateAggregator(aggregator map[string]*AggregatedMetrics, results []AnalysisResult, fileTime time.Time) {
 	for _, r := range results {
 		if _, ok := aggregator[r.ProductID]; !ok {
 			aggregator[r.ProductID] = &AggregatedMetrics{ProductID: r.ProductID}
@@ -167,6 +375,7 @@ func updateAggregator(aggregator map[string]*AggregatedMetrics, results []Analys
 
 		a := aggregator[r.ProductID]
 		a.FileCount++
+		a.Timestamps = append(a.Timestamps, fileTime)
 		a.SumInstabuyPriceAverage += r.InstabuyPriceAverage
 		a.SumInstasellPriceAverage += r.InstasellPriceAverage
 		a.SumNewDemandOfferFrequencyAverage += r.NewDemandOfferFrequencyAverage
@@ -177,31 +386,16 @@ func updateAggregator(aggregator map[string]*AggregatedMetrics, results []Analys
 		a.SumNewSupplyOfferSizeAverage += r.NewSupplyOfferSizeAverage
 		a.SumPlayerInstasellTransactionFrequency += r.PlayerInstasellTransactionFrequency
 		a.SumPlayerInstasellTransactionSizeAverage += r.PlayerInstasellTransactionSizeAverage
-	}
-}
 
-// calculateAverages converts the aggregated sums into final averaged results.
-func calculateAverages(aggregator map[string]*AggregatedMetrics) []AnalysisResult {
-	finalAverages := make([]AnalysisResult, 0, len(aggregator))
-	for _, a := range aggregator {
-		count := float64(a.FileCount)
-		if count == 0 {
-			continue // Should not happen if updateAggregator is used correctly
-		}
-		avgResult := AnalysisResult{
-			ProductID:                             a.ProductID,
-			InstabuyPriceAverage:                  a.SumInstabuyPriceAverage / count,
-			InstasellPriceAverage:                 a.SumInstasellPriceAverage / count,
-			NewDemandOfferFrequencyAverage:        a.SumNewDemandOfferFrequencyAverage / count,
-			NewDemandOfferSizeAverage:             a.SumNewDemandOfferSizeAverage / count,
-			PlayerInstabuyTransactionFrequency:    a.SumPlayerInstabuyTransactionFrequency / count,
-			PlayerInstabuyTransactionSizeAverage:  a.SumPlayerInstabuyTransactionSizeAverage / count,
-			NewSupplyOfferFrequencyAverage:        a.SumNewSupplyOfferFrequencyAverage / count,
-			NewSupplyOfferSizeAverage:             a.SumNewSupplyOfferSizeAverage / count,
-			PlayerInstasellTransactionFrequency:   a.SumPlayerInstasellTransactionFrequency / count,
-			PlayerInstasellTransactionSizeAverage: a.SumPlayerInstasellTransactionSizeAverage / count,
-		}
-		finalAverages = append(finalAverages, avgResult)
+		a.SamplesInstabuyPriceAverage = append(a.SamplesInstabuyPriceAverage, r.InstabuyPriceAverage)
+		a.SamplesInstasellPriceAverage = append(a.SamplesInstasellPriceAverage, r.InstasellPriceAverage)
+		a.SamplesNewDemandOfferFrequencyAverage = append(a.SamplesNewDemandOfferFrequencyAverage, r.NewDemandOfferFrequencyAverage)
+		a.SamplesNewDemandOfferSizeAverage = append(a.SamplesNewDemandOfferSizeAverage, r.NewDemandOfferSizeAverage)
+		a.SamplesPlayerInstabuyTransactionFrequency = append(a.SamplesPlayerInstabuyTransactionFrequency, r.PlayerInstabuyTransactionFrequency)
+		a.SamplesPlayerInstabuyTransactionSizeAverage = append(a.SamplesPlayerInstabuyTransactionSizeAverage, r.PlayerInstabuyTransactionSizeAverage)
+		a.SamplesNewSupplyOfferFrequencyAverage = append(a.SamplesNewSupplyOfferFrequencyAverage, r.NewSupplyOfferFrequencyAverage)
+		a.SamplesNewSupplyOfferSizeAverage = append(a.SamplesNewSupplyOfferSizeAverage, r.NewSupplyOfferSizeAverage)
+		a.SamplesPlayerInstasellTransactionFrequency = append(a.SamplesPlayerInstasellTransactionFrequency, r.PlayerInstasellTransactionFrequency)
+		a.SamplesPlayerInstasellTransactionSizeAverage = append(a.SamplesPlayerInstasellTransactionSizeAverage, r.PlayerInstasellTransactionSizeAverage)
 	}
-	return finalAverages
 }
@@ -2,9 +2,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"sync"
@@ -46,94 +45,34 @@ var (
 	lastAPIFetchTime time.Time           // Timestamp of the last successful API fetch
 )
 
-// fetchBazaarData handles the actual HTTP request to the Hypixel API.
-// It updates the global apiResponseCache, apiFetchErr, and lastAPIFetchTime.
-func fetchBazaarData() error {
-	dlog("Fetching live Hypixel Bazaar data...")        // Original dlog call
-	url := "https://api.hypixel.net/v2/skyblock/bazaar" // Using v2 endpoint
+// fetchBazaarData pulls one snapshot from CurrentBazaarSource (bazaar_source.go)
+// and updates the global apiResponseCache, apiFetchErr, and lastAPIFetchTime.
+// ctx bounds the fetch itself, so a caller that cancels it aborts the
+// in-flight request rather than waiting out whatever timeout the source's
+// own HTTP client has configured.
+func fetchBazaarData(ctx context.Context) (err error) {
+	dlog("Fetching Bazaar data from source %q...", CurrentBazaarSource.Name())
+	getLogger().Debugw("fetching bazaar data", "source", CurrentBazaarSource.Name())
+	fetchStart := time.Now()
 
-	client := http.Client{Timeout: 15 * time.Second} // HTTP client with a timeout
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fetchErr := fmt.Errorf("creating API request for %s: %w", url, err)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
-		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr // Store the error
-		apiCacheMutex.Unlock()
-		return fetchErr
-	}
-	// If you have an API key, set it as a header or query parameter as per Hypixel's docs
-	// Example: req.Header.Set("API-Key", "YOUR_HYPIXEL_API_KEY")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fetchErr := fmt.Errorf("executing API GET request to %s: %w", url, err)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
-		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr // Store the error
-		apiCacheMutex.Unlock()
-		return fetchErr
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		bodyStr := ""
-		if readErr == nil {
-			maxBody := 500 // Limit error body logging
-			if len(bodyBytes) > maxBody {
-				bodyBytes = append(bodyBytes[:maxBody], []byte("... (truncated)")...)
-			}
-			bodyStr = string(bodyBytes)
-		} else {
-			bodyStr = fmt.Sprintf("(failed to read response body for error status: %v)", readErr)
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
 		}
-		fetchErr := fmt.Errorf("Hypixel API returned non-OK status %d from %s. Body: %s", resp.StatusCode, url, bodyStr)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
-		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr // Store the error
-		apiCacheMutex.Unlock()
-		return fetchErr
-	}
+		m := DefaultMetrics(nil)
+		m.FetchAttemptsTotal.WithLabelValues(outcome).Inc()
+		m.FetchLatencySeconds.WithLabelValues(outcome).Set(time.Since(fetchStart).Seconds())
+	}()
 
-	var apiResp HypixelAPIResponse
-	// It's generally safer to read the whole body first, then unmarshal, for better error reporting.
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fetchErr := fmt.Errorf("reading API response body from %s: %w", url, err)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
-		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr
-		apiCacheMutex.Unlock()
-		return fetchErr
-	}
-
-	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
-		maxBodyForLog := 500
-		bodySample := string(bodyBytes)
-		if len(bodySample) > maxBodyForLog {
-			bodySample = bodySample[:maxBodyForLog] + "... (truncated)"
-		}
-		fetchErr := fmt.Errorf("parsing API JSON from %s: %w. Response body sample: %s", url, err, bodySample)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
+	apiResp, fetchErr := CurrentBazaarSource.Fetch(ctx)
+	if fetchErr != nil {
+		wrapped := fmt.Errorf("fetching from source %q: %w", CurrentBazaarSource.Name(), fetchErr)
+		log.Printf("[fetchBazaarData] ERROR: %v", wrapped)
 		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr
+		apiFetchErr = wrapped
 		apiCacheMutex.Unlock()
-		return fetchErr
-	}
-
-	if !apiResp.Success {
-		// Even if !Success, Hypixel might still provide a LastUpdated timestamp.
-		// The decision to treat this as a hard error depends on how you want to handle partial/failed API states.
-		fetchErr := fmt.Errorf("Hypixel API response 'success' field was false. LastUpdated: %d", apiResp.LastUpdated)
-		log.Printf("[fetchBazaarData] ERROR: %v", fetchErr)
-		// We might still update the cache with this "unsuccessful" response if it contains usable data like LastUpdated.
-		// Or, we might preserve the old cache. For now, let's treat it as an error that prevents updating the cache with this response.
-		apiCacheMutex.Lock()
-		apiFetchErr = fetchErr // Store this specific error
-		apiCacheMutex.Unlock()
-		return fetchErr // Return the error
+		return wrapped
 	}
 
 	// Lock for writing to global cache variables
@@ -147,57 +86,146 @@ func fetchBazaarData() error {
 		}
 	}
 
-	apiResponseCache = &apiResp   // Update the cache with the new, successful response
+	apiResponseCache = apiResp    // Update the cache with the new, successful response
 	apiFetchErr = nil             // Clear any previous fetch error
 	lastAPIFetchTime = time.Now() // Update the timestamp of this successful fetch
+	RecordFeedSnapshot(apiResp)   // Feed the phi-accrual health detector
 
-	dlog("Hypixel Bazaar data fetched and cached successfully at %s. New LastUpdated: %d",
-		lastAPIFetchTime.Format(time.RFC3339), apiResp.LastUpdated)
+	dlog("Bazaar data fetched from %q and cached successfully at %s. New LastUpdated: %d",
+		CurrentBazaarSource.Name(), lastAPIFetchTime.Format(time.RFC3339), apiResp.LastUpdated)
 	return nil // Success
 }
 
-// getApiResponse is called by the main application logic to get the latest API data.
-// This version will trigger a fresh fetch on every call.
-func getApiResponse() (*HypixelAPIResponse, error) {
-	log.Println("[getApiResponse] Attempting to fetch/refresh Bazaar data by calling fetchBazaarData()...")
-
-	// Attempt to fetch new data. This will update the global cache if successful,
-	// or update apiFetchErr if it fails.
-	fetchAttemptErr := fetchBazaarData()
-
-	// Regardless of fetchAttemptErr, we will return the current state of the cache
-	// and the most recent error state (which fetchBazaarData would have set).
+// BazaarFetchStatus reports when fetchBazaarData last ran and, if that run
+// failed, the error it left behind - the pieces apiStatusHandler (refresh.go)
+// surfaces alongside the BazaarCache's own age so an operator can tell "stale
+// because nothing has fetched in a while" from "stale because every recent
+// fetch has errored".
+func BazaarFetchStatus() (lastFetch time.Time, lastErr error) {
 	apiCacheMutex.RLock()
-	currentCache := apiResponseCache
-	// currentError := apiFetchErr // This line was previously here, but fetchAttemptErr is more direct
-	apiCacheMutex.RUnlock()
+	defer apiCacheMutex.RUnlock()
+	return lastAPIFetchTime, apiFetchErr
+}
 
-	if fetchAttemptErr != nil {
-		// The fetch attempt failed. currentError should be the same as fetchAttemptErr.
-		log.Printf("[getApiResponse] fetchBazaarData() reported an error: %v. Returning current cache (if any) and this error.", fetchAttemptErr)
-		return currentCache, fetchAttemptErr // Return potentially stale cache and the new error
+// getApiResponse is called by the main application logic to get the latest API data.
+// It serves out of the BazaarCache while still fresh and only calls
+// fetchBazaarData (via the cache's stampede-protected Refresh) once the entry
+// is 30 seconds old - a fixed cutoff; the installed cache's own ttl is not
+// consulted. ctx bounds that fetch, the one part of this call
+// that can actually block on the network; a cancelled ctx still serves
+// whatever the cache already has rather than failing outright.
+func getApiResponse(ctx context.Context) (*HypixelAPIResponse, error) {
+	cache := getBazaarCache()
+	if resp, age, ok := cache.Get(); ok {
+		DefaultMetrics(nil).CacheAgeSeconds.Set(age.Seconds())
+		DefaultMetrics(nil).ApiCacheAgeSeconds.Set(age.Seconds())
+		if age < 30*time.Second {
+			log.Println("[getApiResponse] Serving Bazaar data from cache (still fresh).")
+			return resp, nil
+		}
 	}
 
-	// Fetch attempt was successful (fetchAttemptErr is nil).
-	// apiResponseCache should have been updated by fetchBazaarData.
-	if currentCache == nil { // This should ideally not happen if fetch was successful
-		log.Println("[getApiResponse] fetchBazaarData() succeeded but apiResponseCache is still nil. This is unexpected.")
-		// This implies fetchBazaarData succeeded but set apiResponseCache to nil, which it shouldn't.
-		// Or, that another goroutine set it to nil between fetchBazaarData and here.
-		// The most robust error to return here is that data is unavailable.
+	log.Println("[getApiResponse] Cache stale or empty; refreshing Bazaar data...")
+	resp, err := cache.Refresh(func() (*HypixelAPIResponse, error) {
+		if ferr := fetchBazaarDataWithRetry(ctx, defaultRetryPolicy); ferr != nil {
+			return nil, ferr
+		}
+		apiCacheMutex.RLock()
+		defer apiCacheMutex.RUnlock()
+		return apiResponseCache, nil
+	})
+	if err != nil {
+		if staleResp, _, ok := cache.Get(); ok {
+			log.Printf("[getApiResponse] Refresh failed (%v); serving last-known-good cached data.", err)
+			return staleResp, err
+		}
+		return nil, err
+	}
+	if resp == nil {
 		return nil, fmt.Errorf("API data unavailable: cache is nil even after a successful fetch attempt by fetchBazaarData")
 	}
 
-	log.Printf("[getApiResponse] Successfully returned data from cache after fetchBazaarData(). LastUpdated in cache: %d", currentCache.LastUpdated)
-	return currentCache, nil // apiFetchErr should be nil if fetchBazaarData succeeded
+	log.Printf("[getApiResponse] Successfully refreshed Bazaar data. LastUpdated in cache: %d", resp.LastUpdated)
+	return resp, nil
+}
+
+// BazaarClientOptions configures NewBazaarClient. Zero-value fields fall
+// back to the same defaults the package-level getApiResponse/
+// fetchBazaarData/CurrentBazaarSource globals use: HypixelSource{} (the
+// live API), a 30s-fresh InMemoryBazaarCache, and HypixelSource's own
+// default *http.Client (15s timeout).
+type BazaarClientOptions struct {
+	// Source, if set, overrides which BazaarSource is fetched from -
+	// e.g. a MirrorSource pointed at a self-hosted endpoint.
+	Source BazaarSource
+	// HTTPClient is only used to build the default HypixelSource; ignored
+	// if Source is set.
+	HTTPClient *http.Client
+	// Cache, if set, overrides the storage layer - e.g. an
+	// ExternalStoreBazaarCache (cache.go) backed by Redis/BigCache.
+	Cache BazaarCache
+	// TTL is how long a cached response is served without refreshing, used
+	// only when Cache is unset. Non-positive falls back to 30s.
+	TTL time.Duration
+}
+
+// BazaarClient is a self-contained alternative to the package-level
+// getApiResponse/fetchBazaarData/CurrentBazaarSource globals, for embedding
+// this package as a library where multiple independently-configured
+// instances (different URLs, TTLs, or storage layers) need to coexist, or
+// for tests that want a fully isolated cache instead of swapping the
+// package-wide default via SetBazaarCache. The package-level functions
+// remain this binary's own main.go's entry point.
+type BazaarClient struct {
+	source BazaarSource
+	cache  BazaarCache
+	ttl    time.Duration
+}
+
+// NewBazaarClient builds a BazaarClient from opts, defaulting any unset
+// field the same way the package-level globals do.
+func NewBazaarClient(opts BazaarClientOptions) *BazaarClient {
+	source := opts.Source
+	if source == nil {
+		source = HypixelSource{HTTPClient: opts.HTTPClient}
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	cache := opts.Cache
+	if cache == nil {
+		cache = NewInMemoryBazaarCache(ttl)
+	}
+	return &BazaarClient{source: source, cache: cache, ttl: ttl}
+}
+
+// GetApiResponse is getApiResponse against this client's own source/cache
+// instead of the package-level globals: it serves out of the cache while
+// younger than bc.ttl and only calls Source.Fetch (via the cache's
+// stampede-protected Refresh) once the entry has aged past that.
+func (bc *BazaarClient) GetApiResponse(ctx context.Context) (*HypixelAPIResponse, error) {
+	if resp, age, ok := bc.cache.Get(); ok && age < bc.ttl {
+		return resp, nil
+	}
+	resp, err := bc.cache.Refresh(func() (*HypixelAPIResponse, error) {
+		return bc.source.Fetch(ctx)
+	})
+	if err != nil {
+		if staleResp, _, ok := bc.cache.Get(); ok {
+			return staleResp, err
+		}
+		return nil, err
+	}
+	return resp, nil
 }
 
 // forceRefreshAPIData provides an explicit way to trigger a data refresh.
 // With the current getApiResponse always fetching, this might be redundant for the main loop,
 // but could be useful for other purposes (e.g., an admin endpoint).
-func forceRefreshAPIData() (*HypixelAPIResponse, error) {
+func forceRefreshAPIData(ctx context.Context) (*HypixelAPIResponse, error) {
 	log.Println("[forceRefreshAPIData] Explicitly refreshing API data via fetchBazaarData()...")
-	err := fetchBazaarData() // This will attempt to update the global cache and apiFetchErr
+	err := fetchBazaarData(ctx) // This will attempt to update the global cache and apiFetchErr
 
 	apiCacheMutex.RLock()
 	currentCacheToReturn := apiResponseCache
@@ -0,0 +1,254 @@
+// apiv1.go
+package main
+
+// The versioned JSON REST surface: /api/v1/items, /api/v1/item/{id},
+// /api/v1/recipe/{id}, and /api/v1/expand/{id}. Every other handler in this
+// package picks HTML or JSON per-request (wantsJSON in wizserver.go); these
+// four always return
+// JSON, with CORS headers so a browser-based client on another origin can
+// consume them without this server also rendering a template for it.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiV1Perspective is GET /api/v1/expand/{id}'s ?perspective= value.
+type apiV1Perspective string
+
+const (
+	apiV1PerspectivePrimary   apiV1Perspective = "primary"
+	apiV1PerspectiveSecondary apiV1Perspective = "secondary"
+	apiV1PerspectiveBoth      apiV1Perspective = "both"
+)
+
+// withCORS wraps h with permissive CORS headers and OPTIONS preflight
+// handling. Scoped to apiv1.go's own handlers rather than applied
+// package-wide in startWebServer, since the HTML/templated handlers
+// elsewhere in this package were never meant for cross-origin use.
+func withCORS(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// apiV1Error is the JSON body every apiv1.go handler reports failures with.
+type apiV1Error struct {
+	Error string `json:"error"`
+}
+
+// writeAPIV1JSON marshals v - json.MarshalIndent when the request sets
+// ?pretty=1, json.Marshal otherwise - and writes it under status.
+func writeAPIV1JSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var data []byte
+	var err error
+	if r.URL.Query().Get("pretty") == "1" {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Printf("writeAPIV1JSON: marshal: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// writeAPIV1Error is writeAPIV1JSON for the apiV1Error shape.
+func writeAPIV1Error(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeAPIV1JSON(w, r, status, apiV1Error{Error: msg})
+}
+
+// apiV1ExpansionErrorStatus maps an expansion/tree-build error to an HTTP
+// status, mirroring writeExpansionTimeoutOrError's (dashboard.go) client
+// disconnect vs. our-own-timeout vs. everything-else convention.
+func apiV1ExpansionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return 499
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// apiV1ItemsHandler serves GET /api/v1/items?sort=&limit=&pretty=1: the same
+// ranked []ItemRank wizItemsHandler (wizserver.go) renders as HTML or a
+// streamed JSON array, here as one JSON array body for the versioned REST
+// surface.
+func apiV1ItemsHandler(w http.ResponseWriter, r *http.Request) {
+	items, _, err := rankedWizItems(r)
+	if err != nil {
+		writeAPIV1Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeAPIV1JSON(w, r, http.StatusOK, items)
+}
+
+// apiV1RecipeHandler serves GET /api/v1/recipe/{id}?quantity=N&pretty=1: the
+// raw CraftingStepNode tree ExpandItemToTree builds for id, independent of
+// the cost/acquisition chooser logic PerformDualExpansion layers on top -
+// for a caller that only wants recipe structure.
+func apiV1RecipeHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimPrefix(r.URL.Path, "/api/v1/recipe/")
+	if itemName == "" {
+		writeAPIV1Error(w, r, http.StatusBadRequest, "missing item id in path")
+		return
+	}
+	qty := 1.0
+	if raw := r.URL.Query().Get("quantity"); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			qty = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r, defaultItemDashboardTimeout, maxItemDashboardTimeout))
+	defer cancel()
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		writeAPIV1Error(w, r, http.StatusServiceUnavailable, "bazaar data unavailable: "+err.Error())
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+
+	tree, err := ExpandItemToTree(ctx, itemName, qty, apiResp, getCurrentMetricsMap(), defaultItemFilesDir)
+	if err != nil {
+		writeAPIV1Error(w, r, apiV1ExpansionErrorStatus(err), err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if tree.ErrorMessage() != "" {
+		status = http.StatusUnprocessableEntity
+	}
+	writeAPIV1JSON(w, r, status, tree)
+}
+
+// apiV1ExpandHandler serves GET
+// /api/v1/expand/{id}?quantity=N&perspective=primary|secondary|both&pretty=1:
+// PerformDualExpansion's cost/acquisition breakdown for id, either one
+// perspective's ExpansionResult or - the default - the full
+// DualExpansionResult. Status is derived from CalculationPossible/
+// ErrorMessage rather than always 200, so a client can branch on the HTTP
+// status alone instead of inspecting the body first.
+func apiV1ExpandHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimPrefix(r.URL.Path, "/api/v1/expand/")
+	if itemName == "" {
+		writeAPIV1Error(w, r, http.StatusBadRequest, "missing item id in path")
+		return
+	}
+	qty := 1.0
+	if raw := r.URL.Query().Get("quantity"); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			qty = parsed
+		}
+	}
+	perspective := apiV1Perspective(r.URL.Query().Get("perspective"))
+	if perspective == "" {
+		perspective = apiV1PerspectiveBoth
+	}
+	switch perspective {
+	case apiV1PerspectivePrimary, apiV1PerspectiveSecondary, apiV1PerspectiveBoth:
+	default:
+		writeAPIV1Error(w, r, http.StatusBadRequest, "unknown perspective: "+string(perspective))
+		return
+	}
+	precision := parsePrecisionMode(r.URL.Query().Get("precision"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r, defaultItemDashboardTimeout, maxItemDashboardTimeout))
+	defer cancel()
+	if r.URL.Query().Get("debug") == "1" {
+		ctx = contextWithDebugSink(ctx, newDebugSink())
+	}
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		writeAPIV1Error(w, r, http.StatusServiceUnavailable, "bazaar data unavailable: "+err.Error())
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+
+	dual, err := PerformDualExpansion(ctx, itemName, qty, apiResp, getCurrentMetricsMap(), defaultItemFilesDir, true, precision, ExpansionOptions{})
+	if err != nil || dual == nil {
+		writeAPIV1Error(w, r, apiV1ExpansionErrorStatus(err), "expansion failed: "+errString(err))
+		return
+	}
+	now := time.Now()
+	RecordCalculationResult(defaultMemStore, dual.ItemName, now, dual)
+
+	switch perspective {
+	case apiV1PerspectivePrimary:
+		writeAPIV1JSON(w, r, apiV1ExpansionResultStatus(dual.PrimaryBased), dual.PrimaryBased)
+	case apiV1PerspectiveSecondary:
+		writeAPIV1JSON(w, r, apiV1ExpansionResultStatus(dual.SecondaryBased), dual.SecondaryBased)
+	default:
+		status := apiV1ExpansionResultStatus(dual.PrimaryBased)
+		if s := apiV1ExpansionResultStatus(dual.SecondaryBased); s > status {
+			status = s
+		}
+		writeAPIV1JSON(w, r, status, dual)
+	}
+}
+
+// apiV1ExpansionResultStatus derives an HTTP status from one
+// ExpansionResult: 200 when CalculationPossible, 422 (unprocessable) when
+// the expansion completed but found no viable acquisition method.
+func apiV1ExpansionResultStatus(res ExpansionResult) int {
+	if res.CalculationPossible {
+		return http.StatusOK
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// apiV1ItemHandler serves GET /api/v1/item/{id}?pretty=1: id's recipe file
+// parsed and re-encoded as Item JSON, unlike apiV1RecipeHandler's
+// CraftingStepNode tree - for a caller that wants the raw recipe definition
+// (cells, Recipes variants, Modules/SubRecipes) rather than an expansion.
+func apiV1ItemHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimPrefix(r.URL.Path, "/api/v1/item/")
+	if itemName == "" {
+		writeAPIV1Error(w, r, http.StatusBadRequest, "missing item id in path")
+		return
+	}
+	itemNorm := BAZAAR_ID(itemName)
+
+	filePath := recipeFilePath(defaultItemFilesDir, itemNorm)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeAPIV1Error(w, r, http.StatusNotFound, "no such item: "+itemNorm)
+			return
+		}
+		writeAPIV1Error(w, r, http.StatusInternalServerError, "reading item file: "+err.Error())
+		return
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		writeAPIV1Error(w, r, http.StatusInternalServerError, "parsing item file: "+err.Error())
+		return
+	}
+	writeAPIV1JSON(w, r, http.StatusOK, item)
+}
@@ -0,0 +1,426 @@
+// arbitrage.go
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// recipeGraphNode is one item's immediate (unscaled, per-single-craft)
+// ingredient requirements, parsed from its recipe file the same way
+// expansionMemo.flattenedIngredients does, but without quantity scaling
+// since arbitrage cycles walk the graph edge-by-edge rather than expanding
+// a fixed target quantity.
+type recipeGraphNode struct {
+	CraftedAmount float64
+	Ingredients   map[string]float64 // ingredient itemID -> qty consumed per craft
+}
+
+// loadRecipeGraph reads every recipe file in itemFilesDir and returns the
+// itemID -> recipeGraphNode adjacency used by FindArbitrageCycles. apiResp is
+// only used to pick a winner for any interchangeable-ingredient cells.
+func loadRecipeGraph(itemFilesDir string, apiResp *HypixelAPIResponse) (map[string]recipeGraphNode, error) {
+	entries, err := os.ReadDir(itemFilesDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading item files dir '%s': %w", itemFilesDir, err)
+	}
+
+	graph := make(map[string]recipeGraphNode)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		itemID := strings.TrimSuffix(entry.Name(), ".json")
+
+		data, readErr := os.ReadFile(filepath.Join(itemFilesDir, entry.Name()))
+		if readErr != nil {
+			return nil, fmt.Errorf("reading recipe file '%s': %w", entry.Name(), readErr)
+		}
+		var itemData Item
+		if jsonErr := json.Unmarshal(data, &itemData); jsonErr != nil {
+			return nil, fmt.Errorf("parsing recipe JSON for '%s': %w", itemID, jsonErr)
+		}
+
+		cells, craftedAmount, hasRecipe := chosenRecipeCellsFor(itemData)
+		if !hasRecipe {
+			continue
+		}
+		specs, aggErr := aggregateCells(context.Background(), cells, itemData.Modules, itemData.SubRecipes)
+		if aggErr != nil {
+			continue // malformed recipe cells; skip this node rather than fail the whole graph load
+		}
+		graph[itemID] = recipeGraphNode{CraftedAmount: craftedAmount, Ingredients: resolveIngredientSpecs(specs, apiResp)}
+	}
+	return graph, nil
+}
+
+// ArbitrageEdge is one crafted-item -> ingredient hop in a cycle: buy enough
+// of ToItemID to craft one batch of FromItemID, then (implicitly, via the
+// next edge or the cycle's closing sell) realize FromItemID's value.
+type ArbitrageEdge struct {
+	FromItemID      string  `json:"from_item_id"`
+	ToItemID        string  `json:"to_item_id"`
+	Method          string  `json:"method"` // Craft/Primary/Secondary, as chosen by getBestC10M for the ingredient leg
+	Ratio           float64 `json:"ratio"`  // (craftedAmount * sellPrice(From)) / cost(qty of To)
+	CapitalRequired float64 `json:"capital_required"`
+	FillTimeSeconds float64 `json:"fill_time_seconds"`
+	// RRValue is the relist-cycle count getBestC10M computed for this leg
+	// (NaN when Method is Secondary, since RR only applies to Primary buy
+	// orders) - used by rrBottleneckItem to name the leg most likely to
+	// actually gate how often a cycle can repeat.
+	RRValue float64 `json:"rr_value"`
+}
+
+// ArbitrageCycle is a closed loop A->B->...->A through the recipe graph
+// whose per-edge ratios multiply out to a round-trip return, mirroring
+// triangular arbitrage over exchange rates.
+type ArbitrageCycle struct {
+	Items                     []string        `json:"items"` // canonicalized rotation, e.g. [A B C]
+	Edges                     []ArbitrageEdge `json:"edges"`
+	Ratio                     float64         `json:"ratio"`
+	// AdjustedRatio discounts Ratio by the opportunity cost of tying up
+	// TotalCapitalRequired for BottleneckFillTimeSeconds, at the
+	// opportunityCostPerSecond rate FindArbitrageCycles was called with:
+	// AdjustedRatio = Ratio * (1 - opportunityCostPerSecond*BottleneckFillTimeSeconds/TotalCapitalRequired).
+	// Ranking and Profitable are both based on this, not the raw Ratio, so a
+	// cycle that's only profitable before accounting for how long its
+	// capital is tied up doesn't get surfaced as if it were free money.
+	AdjustedRatio             float64         `json:"adjusted_ratio"`
+	TotalCapitalRequired      float64         `json:"total_capital_required"`
+	BottleneckFillTimeSeconds float64         `json:"bottleneck_fill_time_seconds"`
+	Profitable                bool            `json:"profitable"`
+}
+
+// cycleRank is a min-heap of ArbitrageCycle ordered by AdjustedRatio, used by
+// FindArbitrageCycles to keep only the topN highest-ranked cycles without
+// retaining every cycle discovered by the DFS in memory.
+type cycleRank []ArbitrageCycle
+
+func (h cycleRank) Len() int           { return len(h) }
+func (h cycleRank) Less(i, j int) bool { return h[i].AdjustedRatio < h[j].AdjustedRatio }
+func (h cycleRank) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cycleRank) Push(x interface{}) { *h = append(*h, x.(ArbitrageCycle)) }
+func (h *cycleRank) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// pushRanked inserts cycle into h, keeping h's length at most topN by
+// dropping the current lowest-ratio cycle once over capacity. topN <= 0
+// means unbounded.
+func pushRanked(h *cycleRank, cycle ArbitrageCycle, topN int) {
+	if topN <= 0 {
+		heap.Push(h, cycle)
+		return
+	}
+	if h.Len() < topN {
+		heap.Push(h, cycle)
+		return
+	}
+	if cycle.AdjustedRatio > (*h)[0].AdjustedRatio {
+		heap.Pop(h)
+		heap.Push(h, cycle)
+	}
+}
+
+// canonicalizeCycle rotates items so the lexicographically smallest item ID
+// comes first, giving every rotation of the same cycle the same
+// representation for dedup purposes.
+func canonicalizeCycle(items []string) []string {
+	minIdx := 0
+	for i, id := range items {
+		if id < items[minIdx] {
+			minIdx = i
+		}
+	}
+	out := make([]string, len(items))
+	for i := range items {
+		out[i] = items[(minIdx+i)%len(items)]
+	}
+	return out
+}
+
+// edgeRatio prices the FromItemID->ToItemID hop: the cost of acquiring
+// enough ToItemID for one craft batch of FromItemID (via getBestC10M, the
+// same cheapest-method chooser PerformDualExpansion uses), versus the
+// revenue from selling that batch at FromItemID's current sell price.
+func edgeRatio(fromItemID, toItemID string, qtyPerCraft, craftedAmount float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) (ArbitrageEdge, bool) {
+	bestCostRaw, method, _, rrVal, _, err := getBestC10M(context.Background(), toItemID, qtyPerCraft, apiResp, metricsMap, PrecisionFloat, nil)
+	if err != nil || method == "N/A" || math.IsInf(bestCostRaw, 0) || math.IsNaN(bestCostRaw) || bestCostRaw <= 0 {
+		return ArbitrageEdge{}, false
+	}
+
+	sellPrice := getSellPrice(apiResp, fromItemID)
+	if sellPrice <= 0 || math.IsNaN(sellPrice) {
+		return ArbitrageEdge{}, false
+	}
+	revenue := craftedAmount * sellPrice
+
+	fillTimeSeconds := 0.0
+	if method == "Primary" {
+		metricsData, metricsOk := safeGetMetricsData(metricsMap, toItemID)
+		if metricsOk {
+			fillTime, _, fillErr := calculateBuyOrderFillTime(context.Background(), toItemID, qtyPerCraft, metricsData)
+			if fillErr == nil && !math.IsNaN(fillTime) && !math.IsInf(fillTime, 0) && fillTime >= 0 {
+				fillTimeSeconds = fillTime
+			} else {
+				fillTimeSeconds = math.Inf(1)
+			}
+		} else {
+			fillTimeSeconds = math.Inf(1)
+		}
+	}
+
+	return ArbitrageEdge{
+		FromItemID: fromItemID, ToItemID: toItemID, Method: method,
+		Ratio: revenue / bestCostRaw, CapitalRequired: bestCostRaw, FillTimeSeconds: fillTimeSeconds,
+		RRValue: rrVal,
+	}, true
+}
+
+// FindArbitrageCycles enumerates simple cycles of length 2..maxLen in the
+// recipe graph rooted at itemFilesDir, using an iterative DFS that tracks
+// the current path on an explicit stack and prunes a branch once its
+// partial ratio drops below cutoffRatio (a cycle can only lose value from
+// there, assuming per-edge ratios are the dominant cost driver). Cycles are
+// deduplicated across rotations via canonicalizeCycle. Each cycle's
+// AdjustedRatio discounts its raw Ratio by opportunityCostPerSecond (coins
+// per second the capital tied up for BottleneckFillTimeSeconds could have
+// earned elsewhere) - this is the "MinProfitEdge" knob a caller should
+// compare against: a cycle is reported as Profitable when AdjustedRatio
+// exceeds 1+epsilon. Only the topN highest-AdjustedRatio cycles are kept
+// (via cycleRank, a min-heap); topN <= 0 returns every cycle found.
+func FindArbitrageCycles(itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, maxLen int, epsilon float64, cutoffRatio float64, topN int, opportunityCostPerSecond float64) ([]ArbitrageCycle, error) {
+	if maxLen < 2 {
+		maxLen = 3
+	}
+	graph, err := loadRecipeGraph(itemFilesDir, apiResp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stable iteration order so results are reproducible across runs.
+	roots := make([]string, 0, len(graph))
+	for id := range graph {
+		roots = append(roots, id)
+	}
+	sort.Strings(roots)
+
+	type frame struct {
+		path   []string
+		edges  []ArbitrageEdge
+		ratio  float64
+		onPath map[string]bool
+	}
+
+	seenCanonical := make(map[string]bool)
+	ranked := &cycleRank{}
+
+	for _, root := range roots {
+		// DFS stack of frames to expand; each frame represents one path
+		// already confirmed valid (or the single-node start).
+		stack := []frame{{path: []string{root}, ratio: 1.0, onPath: map[string]bool{root: true}}}
+
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			fromID := cur.path[len(cur.path)-1]
+			node, ok := graph[fromID]
+			if !ok {
+				continue
+			}
+
+			for toID, qtyPerCraft := range node.Ingredients {
+				edge, edgeOk := edgeRatio(fromID, toID, qtyPerCraft, node.CraftedAmount, apiResp, metricsMap)
+				if !edgeOk {
+					continue
+				}
+				partialRatio := cur.ratio * edge.Ratio
+				if partialRatio < cutoffRatio {
+					continue // pruned: even a perfect rest-of-cycle is unlikely to recover this
+				}
+
+				if toID == root && len(cur.path) >= 2 {
+					items := canonicalizeCycle(cur.path)
+					key := strings.Join(items, ">")
+					if seenCanonical[key] {
+						continue
+					}
+					seenCanonical[key] = true
+
+					edges := append(append([]ArbitrageEdge{}, cur.edges...), edge)
+					totalCapital := 0.0
+					bottleneck := 0.0
+					for _, e := range edges {
+						totalCapital += e.CapitalRequired
+						if math.IsInf(e.FillTimeSeconds, 1) || e.FillTimeSeconds > bottleneck {
+							bottleneck = e.FillTimeSeconds
+						}
+					}
+					adjustedRatio := partialRatio
+					if totalCapital > 0 && !math.IsInf(bottleneck, 1) {
+						timeDiscount := opportunityCostPerSecond * bottleneck / totalCapital
+						adjustedRatio = partialRatio * (1 - timeDiscount)
+					} else if math.IsInf(bottleneck, 1) {
+						adjustedRatio = 0 // a leg that never fills can't be counted as profitable at any ratio
+					}
+					pushRanked(ranked, ArbitrageCycle{
+						Items: items, Edges: edges, Ratio: partialRatio, AdjustedRatio: adjustedRatio,
+						TotalCapitalRequired: totalCapital, BottleneckFillTimeSeconds: bottleneck,
+						Profitable: adjustedRatio > 1+epsilon,
+					}, topN)
+					continue
+				}
+
+				if cur.onPath[toID] || len(cur.path) >= maxLen {
+					continue // not a simple extension back to root, or already at the length cap
+				}
+
+				nextOnPath := make(map[string]bool, len(cur.onPath)+1)
+				for k := range cur.onPath {
+					nextOnPath[k] = true
+				}
+				nextOnPath[toID] = true
+
+				stack = append(stack, frame{
+					path:   append(append([]string{}, cur.path...), toID),
+					edges:  append(append([]ArbitrageEdge{}, cur.edges...), edge),
+					ratio:  partialRatio,
+					onPath: nextOnPath,
+				})
+			}
+		}
+	}
+
+	cycles := make([]ArbitrageCycle, ranked.Len())
+	copy(cycles, *ranked)
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i].AdjustedRatio > cycles[j].AdjustedRatio })
+	return cycles, nil
+}
+
+// RunArbitrageCLI implements the `arbitrage` CLI subcommand: it loads the
+// live Bazaar/metrics data, runs FindArbitrageCycles over itemFilesDir, and
+// writes the resulting cycles as a JSON report to stdout. Only the topN
+// highest-AdjustedRatio cycles are reported (topN <= 0 means unbounded).
+func RunArbitrageCLI(itemFilesDir string, maxLen int, epsilon float64, topN int, opportunityCostPerSecond float64) error {
+	apiResp, err := getApiResponse(context.Background())
+	if err != nil {
+		return fmt.Errorf("fetching bazaar data for arbitrage scan: %w", err)
+	}
+	metricsMap, err := getMetricsMapFromFile(defaultMetricsFilePath)
+	if err != nil {
+		dlog("RunArbitrageCLI: failed to load metrics map from '%s': %v", defaultMetricsFilePath, err)
+	}
+
+	cycles, err := FindArbitrageCycles(itemFilesDir, apiResp, metricsMap, maxLen, epsilon, 0.01, topN, opportunityCostPerSecond)
+	if err != nil {
+		return fmt.Errorf("finding arbitrage cycles: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cycles)
+}
+
+// ArbitragePath is ArbitrageCycle reshaped for a caller deciding whether to
+// actually run a loop, rather than for dedup/ranking bookkeeping: the same
+// recipe chain, but with the derived numbers spelled out - absolute coin
+// profit instead of just a ratio, which leg is the real throughput
+// bottleneck, and a profit/hour figure comparable across chains of very
+// different length and capital requirement.
+type ArbitragePath struct {
+	Chain              []string        `json:"chain"`
+	Edges              []ArbitrageEdge `json:"edges"`
+	Ratio              float64         `json:"ratio"`
+	RequiredCapital    float64         `json:"required_capital"`
+	ExpectedProfit     float64         `json:"expected_profit"`
+	FillTimeSeconds    float64         `json:"fill_time_seconds"`
+	ProfitPerHour      float64         `json:"profit_per_hour"`
+	RRBottleneckItemID string          `json:"rr_bottleneck_item_id"`
+}
+
+// rrBottleneckItemID names edges' ToItemID with the worst relist exposure:
+// an Inf RRValue (a Primary leg that will never finish filling) always wins
+// outright; otherwise it's whichever finite RRValue is highest, i.e. needs
+// the most relist cycles to fill its leg. Edges with NaN RRValue (Secondary
+// legs, where RR doesn't apply) are never picked. Returns "" if no edge has
+// a usable RRValue, e.g. a cycle made entirely of Secondary legs.
+func rrBottleneckItemID(edges []ArbitrageEdge) string {
+	bottleneck := ""
+	bestRR := -1.0
+	for _, e := range edges {
+		if math.IsNaN(e.RRValue) {
+			continue
+		}
+		if math.IsInf(e.RRValue, 1) {
+			return e.ToItemID
+		}
+		if e.RRValue > bestRR {
+			bestRR = e.RRValue
+			bottleneck = e.ToItemID
+		}
+	}
+	return bottleneck
+}
+
+// toArbitragePath derives cycle's ArbitragePath view. ExpectedProfit uses
+// AdjustedRatio (not the raw Ratio) since that's already discounted by how
+// long BottleneckFillTimeSeconds ties up TotalCapitalRequired, so it's the
+// honest absolute-coin number. ProfitPerHour mirrors opportunitySortMetrics'
+// "rate" in opportunities.go: profit divided by the time (in hours) the
+// cycle's slowest leg takes to fill, since BottleneckFillTimeSeconds is
+// itself derived from calculateBuyOrderFillTime's SellFrequency/
+// OrderFrequency-driven fill-time model - that's the throughput bound this
+// cycle is actually gated by, rather than a second, independent one.
+func toArbitragePath(cycle ArbitrageCycle) ArbitragePath {
+	expectedProfit := cycle.TotalCapitalRequired * (cycle.AdjustedRatio - 1)
+
+	profitPerHour := expectedProfit * 3600
+	if cycle.BottleneckFillTimeSeconds > 0 && !math.IsInf(cycle.BottleneckFillTimeSeconds, 1) {
+		profitPerHour = expectedProfit / (cycle.BottleneckFillTimeSeconds / 3600)
+	} else if math.IsInf(cycle.BottleneckFillTimeSeconds, 1) {
+		profitPerHour = 0 // a leg that never fills earns nothing per hour, however good Ratio looks
+	}
+
+	return ArbitragePath{
+		Chain:              cycle.Items,
+		Edges:              cycle.Edges,
+		Ratio:              cycle.Ratio,
+		RequiredCapital:    cycle.TotalCapitalRequired,
+		ExpectedProfit:     expectedProfit,
+		FillTimeSeconds:    cycle.BottleneckFillTimeSeconds,
+		ProfitPerHour:      profitPerHour,
+		RRBottleneckItemID: rrBottleneckItemID(cycle.Edges),
+	}
+}
+
+// RankArbitragePaths runs FindArbitrageCycles over itemFilesDir and returns
+// its cycles reshaped as ArbitragePath, sorted by ProfitPerHour descending -
+// the single figure that already folds in Ratio, required capital, and the
+// RR-driven fill-time bottleneck, so a caller scanning the list top-down
+// sees the best real-world use of their capital first rather than just the
+// highest raw ratio.
+func RankArbitragePaths(itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, maxLen int, epsilon float64, cutoffRatio float64, topN int, opportunityCostPerSecond float64) ([]ArbitragePath, error) {
+	cycles, err := FindArbitrageCycles(itemFilesDir, apiResp, metricsMap, maxLen, epsilon, cutoffRatio, topN, opportunityCostPerSecond)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]ArbitragePath, len(cycles))
+	for i, cycle := range cycles {
+		paths[i] = toArbitragePath(cycle)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].ProfitPerHour > paths[j].ProfitPerHour })
+	return paths, nil
+}
@@ -0,0 +1,199 @@
+// arbitrage_handler.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultArbitrageMaxDepth/maxArbitrageMaxDepth bound the ?max_depth= query
+// param (FindArbitrageCycles' maxLen): 4 is one deeper than the CLI's own
+// "max-len" default of 3, still a short, walkable loop; deeper cycles multiply the DFS branching
+// factor fast enough that a client-supplied depth needs a hard ceiling.
+const (
+	defaultArbitrageMaxDepth = 4
+	maxArbitrageMaxDepth     = 6
+)
+
+// defaultArbitrageTopN/maxArbitrageTopN bound the ?top= query param, mirroring
+// the CLI's own "top" default of 20.
+const (
+	defaultArbitrageTopN = 20
+	maxArbitrageTopN     = 100
+)
+
+// defaultArbitrageCutoffRatio is the partial-ratio DFS pruning threshold
+// RunArbitrageCLI also uses - a cycle whose partial ratio has already fallen
+// this far below 1.0 is treated as unrecoverable rather than walked out to
+// maxLen.
+const defaultArbitrageCutoffRatio = 0.01
+
+// defaultArbitrageTimeBudget/maxArbitrageTimeBudget bound the ?time_budget_ms=
+// query param, matching requestTimeout's (dashboard.go) def/max clamp style.
+const (
+	defaultArbitrageTimeBudget = 5 * time.Second
+	maxArbitrageTimeBudget     = 30 * time.Second
+)
+
+// arbitrageTimeBudget parses ?time_budget_ms= against def/max, falling back
+// to def when absent or unparseable - the same shape as requestTimeout
+// (dashboard.go), just keyed off milliseconds instead of seconds since that's
+// the unit the request body asks for.
+func arbitrageTimeBudget(r *http.Request, def, max time.Duration) time.Duration {
+	raw := r.URL.Query().Get("time_budget_ms")
+	if raw == "" {
+		return def
+	}
+	ms, err := strconv.ParseFloat(raw, 64)
+	if err != nil || ms <= 0 {
+		return def
+	}
+	d := time.Duration(ms * float64(time.Millisecond))
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func queryIntDefault(r *http.Request, key string, def, min, max int) int {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return def
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func queryFloatDefault(r *http.Request, key string, def float64) float64 {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// arbitrageCyclesResult runs FindArbitrageCycles (via RankArbitragePaths, so
+// the response already carries the absolute-coin ExpectedProfit and
+// ProfitPerHour toArbitragePath derives) on its own goroutine so
+// arbitrageCyclesHandler can bound it by ctx's deadline - FindArbitrageCycles'
+// DFS has no context parameter of its own (changing that signature would
+// touch RunArbitrageCLI and RankArbitragePaths too), so a client whose
+// time_budget_ms fires first gets a 504 rather than a cut-short partial
+// result: the DFS keeps running to completion in the background, but nothing
+// is written to the response after the deadline.
+func arbitrageCyclesResult(ctx context.Context, itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, maxLen int, epsilon, cutoffRatio float64, topN int, opportunityCostPerSecond float64) ([]ArbitragePath, error) {
+	type result struct {
+		paths []ArbitragePath
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		paths, err := RankArbitragePaths(itemFilesDir, apiResp, metricsMap, maxLen, epsilon, cutoffRatio, topN, opportunityCostPerSecond)
+		done <- result{paths, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.paths, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// arbitrageCyclesHandler serves GET /api/arbitrage-cycles: it scans the
+// recipe graph (loadRecipeGraph, via FindArbitrageCycles/RankArbitragePaths)
+// for profitable triangular-arbitrage-style cycles and reports the top-N
+// ranked by ProfitPerHour, each already annotated with its bottleneck fill
+// time and expected profit at that leg's volume.
+//
+// Query params: max_depth (cycle length cap, default 4), min_margin (the
+// epsilon a cycle's AdjustedRatio must clear above 1.0 to count as
+// profitable, default 0), top (result cap, default 20), time_budget_ms
+// (overall deadline, default 5000), max_fill_time (seconds; cycles whose
+// BottleneckFillTimeSeconds exceeds this are dropped from the response,
+// default unset/unbounded), opportunity_cost (coins/second tied-up capital
+// could otherwise earn, default 0 - see ArbitrageCycle.AdjustedRatio).
+// Rotationally-equivalent cycles and any edge with missing price/metrics
+// data are already excluded by FindArbitrageCycles/edgeRatio - this handler
+// adds no further fallback for either, per the request's "hard cut" edge
+// case.
+func arbitrageCyclesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		m := DefaultMetrics(nil)
+		m.CalculateRequestsTotal.WithLabelValues(status).Inc()
+		m.CalculateLatencySeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	if r.Method != http.MethodGet {
+		status = "error"
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxDepth := queryIntDefault(r, "max_depth", defaultArbitrageMaxDepth, 2, maxArbitrageMaxDepth)
+	topN := queryIntDefault(r, "top", defaultArbitrageTopN, 1, maxArbitrageTopN)
+	minMargin := queryFloatDefault(r, "min_margin", 0.0)
+	opportunityCost := queryFloatDefault(r, "opportunity_cost", 0.0)
+	maxFillTime := queryFloatDefault(r, "max_fill_time", 0) // <=0 means unbounded
+
+	ctx, cancel := context.WithTimeout(r.Context(), arbitrageTimeBudget(r, defaultArbitrageTimeBudget, maxArbitrageTimeBudget))
+	defer cancel()
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		status = "error"
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	paths, pathsErr := arbitrageCyclesResult(ctx, defaultItemFilesDir, apiResp, metricsMap, maxDepth, minMargin, defaultArbitrageCutoffRatio, topN, opportunityCost)
+	if pathsErr != nil {
+		status = "error"
+		writeExpansionTimeoutOrError(w, pathsErr)
+		return
+	}
+
+	if maxFillTime > 0 {
+		filtered := make([]ArbitragePath, 0, len(paths))
+		for _, p := range paths {
+			if !math.IsInf(p.FillTimeSeconds, 1) && p.FillTimeSeconds <= maxFillTime {
+				filtered = append(filtered, p)
+			}
+		}
+		paths = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(paths); err != nil {
+		log.Printf("arbitrageCyclesHandler: encode response: %v", err)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = "timeout"
+	}
+}
@@ -0,0 +1,174 @@
+// backoff.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by fetchBazaarDataWithRetry (and surfaced through
+// getApiResponse) while the circuit breaker is open and short-circuiting
+// calls to the Hypixel API.
+var ErrCircuitOpen = errors.New("hypixel API circuit breaker is open")
+
+// retryPolicy configures the exponential-backoff-with-full-jitter retry used
+// around fetchBazaarData.
+type retryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+var defaultRetryPolicy = retryPolicy{
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	MaxAttempts: 5,
+}
+
+// fetchHTTPError carries the HTTP status code of a failed fetch so callers
+// can distinguish transient (429/5xx) from permanent (4xx) failures.
+type fetchHTTPError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *fetchHTTPError) Error() string { return e.Err.Error() }
+func (e *fetchHTTPError) Unwrap() error { return e.Err }
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// fullJitterDelay implements the "full jitter" backoff from the AWS
+// architecture blog: a uniformly random delay in [0, min(cap, base*2^attempt)].
+func fullJitterDelay(policy retryPolicy, attempt int) time.Duration {
+	backoff := float64(policy.BaseDelay) * math.Pow(2, float64(attempt))
+	capped := math.Min(backoff, float64(policy.MaxDelay))
+	return time.Duration(rand.Int63n(int64(math.Max(capped, 1))))
+}
+
+// circuitBreaker is a simple count-based breaker: it opens after
+// consecutive failures and stays open for a cooldown window. Once the
+// cooldown has passed (half-open), Allow admits every caller, not only one
+// probe, until the next RecordFailure reopens the window or a
+// RecordSuccess closes the breaker.
+type circuitBreaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu              sync.Mutex
+	consecutiveFail int
+	openedAt        time.Time
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{failureThreshold: failureThreshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request should be attempted right now.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consecutiveFail < b.failureThreshold {
+		return true
+	}
+	return time.Since(b.openedAt) >= b.cooldown
+}
+
+func (b *circuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFail = 0
+}
+
+func (b *circuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFail++
+	if b.consecutiveFail == b.failureThreshold {
+		b.openedAt = time.Now()
+	} else if b.consecutiveFail > b.failureThreshold {
+		// Half-open probe failed; reopen the cooldown window.
+		b.openedAt = time.Now()
+	}
+}
+
+// State reports the breaker's current state and, if open, how long until the
+// next probe is allowed.
+func (b *circuitBreaker) State() (open bool, cooldownRemaining time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consecutiveFail < b.failureThreshold {
+		return false, 0
+	}
+	remaining := b.cooldown - time.Since(b.openedAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining > 0, remaining
+}
+
+var defaultFetchBreaker = newCircuitBreaker(5, 20*time.Second)
+
+// BazaarStatus reports defaultFetchBreaker's current state alongside
+// BazaarFetchStatus's last-fetch outcome, so apiStatusHandler (or any other
+// caller/UI) can tell "degraded because the breaker tripped" apart from
+// "just hasn't fetched in a while".
+func BazaarStatus() (breakerOpen bool, cooldownRemaining time.Duration, lastFetch time.Time, lastErr error) {
+	breakerOpen, cooldownRemaining = defaultFetchBreaker.State()
+	lastFetch, lastErr = BazaarFetchStatus()
+	return
+}
+
+// fetchBazaarDataWithRetry wraps fetchBazaarData with exponential backoff +
+// full jitter and a circuit breaker. It retries transient failures
+// (429/5xx, network errors) up to policy.MaxAttempts times, but returns
+// immediately on 4xx client errors since retrying would never succeed. ctx
+// cancellation aborts both the in-flight fetch and any pending retry delay.
+func fetchBazaarDataWithRetry(ctx context.Context, policy retryPolicy) error {
+	if !defaultFetchBreaker.Allow() {
+		_, remaining := defaultFetchBreaker.State()
+		dlog("fetchBazaarDataWithRetry: circuit open, short-circuiting for another %s", remaining)
+		return ErrCircuitOpen
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		lastErr = fetchBazaarData(ctx)
+		if lastErr == nil {
+			defaultFetchBreaker.RecordSuccess()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var httpErr *fetchHTTPError
+		if errors.As(lastErr, &httpErr) && !isRetryableStatus(httpErr.StatusCode) {
+			defaultFetchBreaker.RecordFailure()
+			return lastErr
+		}
+
+		defaultFetchBreaker.RecordFailure()
+		if !defaultFetchBreaker.Allow() {
+			return fmt.Errorf("%w (tripped after attempt %d/%d): %v", ErrCircuitOpen, attempt+1, policy.MaxAttempts, lastErr)
+		}
+		if attempt == policy.MaxAttempts-1 {
+			break
+		}
+		delay := fullJitterDelay(policy, attempt)
+		dlog("fetchBazaarDataWithRetry: attempt %d/%d failed (%v), retrying in %s", attempt+1, policy.MaxAttempts, lastErr, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("fetchBazaarData failed after %d attempts: %w", policy.MaxAttempts, lastErr)
+}
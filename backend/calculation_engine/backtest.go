@@ -0,0 +1,623 @@
+// backtest.go
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BacktestSnapshot is one timestamped recording of a product's live Bazaar
+// state plus the metrics snapshot used by calculateBuyOrderFillTime, so a
+// later replay can reconstruct "what calculateBuyOrderFillTime would have
+// predicted at time T" and "what actually happened afterwards".
+type BacktestSnapshot struct {
+	TimestampUnix int64          `json:"ts"`
+	Product       HypixelProduct `json:"product"`
+	Metrics       ProductMetrics `json:"metrics"`
+}
+
+// backtestDayPath returns dir/productID/YYYY-MM-DD.jsonl.gz, one file per
+// product per day so a long-running collector never holds one unbounded file.
+func backtestDayPath(dir, productID string, day time.Time) string {
+	return filepath.Join(dir, BAZAAR_ID(productID), day.UTC().Format("2006-01-02")+".jsonl.gz")
+}
+
+// RecordBacktestSnapshot appends snap as one gzip member to that day's file,
+// creating the product directory and file as needed. Appending a fresh gzip
+// member per call (rather than keeping one writer open) means the file is
+// always readable even if the process is killed mid-write; compress/gzip's
+// Reader transparently concatenates members (Multistream defaults to true).
+func RecordBacktestSnapshot(dir, productID string, snap BacktestSnapshot) error {
+	path := backtestDayPath(dir, productID, time.Unix(snap.TimestampUnix, 0))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating backtest dir for %s: %w", productID, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening backtest file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(snap); err != nil {
+		gz.Close()
+		return fmt.Errorf("encoding backtest snapshot for %s: %w", productID, err)
+	}
+	return gz.Close()
+}
+
+// LoadBacktestSnapshots reads every recorded snapshot for productID across
+// [from, to] (inclusive day boundaries), sorted by timestamp ascending.
+func LoadBacktestSnapshots(dir, productID string, from, to time.Time) ([]BacktestSnapshot, error) {
+	var snapshots []BacktestSnapshot
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		path := backtestDayPath(dir, productID, day)
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("opening backtest file %s: %w", path, err)
+		}
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading gzip header for %s: %w", path, err)
+		}
+		dec := json.NewDecoder(bufio.NewReader(gz))
+		for dec.More() {
+			var snap BacktestSnapshot
+			if err := dec.Decode(&snap); err != nil {
+				break // tolerate a truncated trailing record from a killed process
+			}
+			snapshots = append(snapshots, snap)
+		}
+		gz.Close()
+		f.Close()
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].TimestampUnix < snapshots[j].TimestampUnix })
+	return snapshots, nil
+}
+
+// simulateRestingBuyOrderFillTime replays a hypothetical resting buy order
+// of qty at price against the snapshots that follow startIdx: it tracks the
+// order's remaining quantity and decrements it using each interval's
+// instasell volume (derived from the change in SellMovingWeek, falling back
+// to a flat rate over the interval when the moving-week counter resets or
+// goes backwards), returning the elapsed seconds until the remainder reaches
+// zero, or false if the order never fills within the provided snapshots.
+func simulateRestingBuyOrderFillTime(snapshots []BacktestSnapshot, startIdx int, price, qty float64) (seconds float64, filled bool) {
+	if qty <= 0 {
+		return 0, true
+	}
+	remaining := qty
+	for i := startIdx; i+1 < len(snapshots); i++ {
+		cur, next := snapshots[i], snapshots[i+1]
+		if next.Product.QuickStatus.SellPrice > price {
+			// Instasells are happening above our buy price, i.e. nothing is
+			// crossing into our resting order this interval.
+			continue
+		}
+		intervalSeconds := float64(next.TimestampUnix - cur.TimestampUnix)
+		if intervalSeconds <= 0 {
+			continue
+		}
+
+		volume := next.Product.QuickStatus.SellMovingWeek - cur.Product.QuickStatus.SellMovingWeek
+		if volume < 0 {
+			// The 7d moving window rolled over/reset; approximate using the
+			// instantaneous rate implied by the later snapshot instead.
+			volume = (next.Product.QuickStatus.SellMovingWeek / 604800.0) * intervalSeconds
+		}
+		if volume <= 0 {
+			continue
+		}
+
+		if volume >= remaining {
+			fraction := remaining / volume
+			elapsedSoFar := float64(cur.TimestampUnix - snapshots[startIdx].TimestampUnix)
+			return elapsedSoFar + fraction*intervalSeconds, true
+		}
+		remaining -= volume
+	}
+	return 0, false
+}
+
+// BacktestSample pairs one prediction (from calculateBuyOrderFillTime and
+// calculateInstasellFillTime at the snapshot's own metrics) against the
+// simulated ground truth from replaying the snapshots that follow it.
+// RealizedSlippage/RealizedProfitPerHour are only meaningful once the
+// instasell side filled (InstasellFilled): they compare
+// InstasellEffectivePrice (walked off BuySummary depth, the same as
+// getDepthAwareFillTime in fill_time.go) against TargetPrice and turn that
+// spread into an hourly rate over InstasellSimulatedSeconds, the same way
+// profit_curve.go turns Profit/CycleTime into ProfitPerHour for a craft.
+type BacktestSample struct {
+	TimestampUnix      int64   `json:"ts"`
+	TargetPrice        float64 `json:"target_price"`
+	Quantity           float64 `json:"quantity"`
+	PredictedSeconds   float64 `json:"predicted_seconds"`
+	SimulatedSeconds   float64 `json:"simulated_seconds"`
+	Filled             bool    `json:"filled"`
+
+	InstasellPredictedSeconds float64 `json:"instasell_predicted_seconds"`
+	InstasellSimulatedSeconds float64 `json:"instasell_simulated_seconds"`
+	InstasellFilled           bool    `json:"instasell_filled"`
+	InstasellEffectivePrice   float64 `json:"instasell_effective_price"`
+	RealizedSlippage          float64 `json:"realized_slippage"`
+	RealizedProfitPerHour     float64 `json:"realized_profit_per_hour"`
+}
+
+// BacktestReport summarizes calculateBuyOrderFillTime's and
+// calculateInstasellFillTime's accuracy over a window of recorded snapshots
+// for one product at one order quantity: MeanAbsErrorSeconds/
+// MeanAbsPercentError cover the resting-buy-order prediction (as before),
+// and the FillTimeSeconds/Slippage/ProfitPerHour fields below summarize the
+// realized (simulated) distribution itself rather than the prediction
+// error, so a caller can see e.g. "median 4m, p99 38m" instead of only an
+// average prediction error.
+type BacktestReport struct {
+	ProductID           string  `json:"product_id"`
+	SamplesEvaluated    int     `json:"samples_evaluated"`
+	MeanAbsErrorSeconds float64 `json:"mean_abs_error_seconds"`
+	MeanAbsPercentError float64 `json:"mean_abs_percent_error"`
+
+	MedianFillTimeSeconds float64 `json:"median_fill_time_seconds"`
+	P90FillTimeSeconds    float64 `json:"p90_fill_time_seconds"`
+	P99FillTimeSeconds    float64 `json:"p99_fill_time_seconds"`
+
+	MeanRealizedSlippage      float64 `json:"mean_realized_slippage"`
+	MeanRealizedProfitPerHour float64 `json:"mean_realized_profit_per_hour"`
+
+	Samples []BacktestSample `json:"samples,omitempty"`
+}
+
+// percentile returns values' p-th percentile (0 <= p <= 100) via
+// nearest-rank on a sorted copy; ok is false for an empty values.
+func percentile(values []float64, p float64) (result float64, ok bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p/100.0*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank], true
+}
+
+// simulateInstasellFillTime is simulateRestingBuyOrderFillTime's counterpart
+// for the instasell side: it replays a hypothetical instasell of qty,
+// decrementing remaining using each interval's BuyMovingWeek delta (the same
+// counter calculateInstasellFillTime's rate is drawn from), falling back to
+// the flat instantaneous rate on rollover exactly as the resting-order
+// simulator does for SellMovingWeek. There's no price gate here because an
+// instasell always executes immediately against the current best bid,
+// unlike a resting buy order that only fills once instasells cross it.
+func simulateInstasellFillTime(snapshots []BacktestSnapshot, startIdx int, qty float64) (seconds float64, filled bool) {
+	if qty <= 0 {
+		return 0, true
+	}
+	remaining := qty
+	for i := startIdx; i+1 < len(snapshots); i++ {
+		cur, next := snapshots[i], snapshots[i+1]
+		intervalSeconds := float64(next.TimestampUnix - cur.TimestampUnix)
+		if intervalSeconds <= 0 {
+			continue
+		}
+
+		volume := next.Product.QuickStatus.BuyMovingWeek - cur.Product.QuickStatus.BuyMovingWeek
+		if volume < 0 {
+			volume = (next.Product.QuickStatus.BuyMovingWeek / 604800.0) * intervalSeconds
+		}
+		if volume <= 0 {
+			continue
+		}
+
+		if volume >= remaining {
+			fraction := remaining / volume
+			elapsedSoFar := float64(cur.TimestampUnix - snapshots[startIdx].TimestampUnix)
+			return elapsedSoFar + fraction*intervalSeconds, true
+		}
+		remaining -= volume
+	}
+	return 0, false
+}
+
+// RunFillTimeBacktest replays calculateBuyOrderFillTime's and
+// calculateInstasellFillTime's predictions against historical snapshots
+// recorded via RecordBacktestSnapshot: for each snapshot it simulates a
+// resting buy order of qty at the snapshot's own BuySummary best price and,
+// starting from that same snapshot whether or not the buy order fills,
+// simulates instaselling the same qty back out, walking BuySummary depth for the realized effective price and
+// slippage the same way getDepthAwareFillTime (fill_time.go) does. The
+// resulting RealizedProfitPerHour treats the buy order's fill price as the
+// cost basis and the instasell's effective price as the exit, matching how
+// profit_curve.go turns Profit/CycleTime into ProfitPerHour elsewhere in
+// this package.
+func RunFillTimeBacktest(dir, productID string, from, to time.Time, qty float64) (*BacktestReport, error) {
+	snapshots, err := LoadBacktestSnapshots(dir, productID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) < 2 {
+		return nil, fmt.Errorf("not enough recorded snapshots for %s in range to backtest", productID)
+	}
+
+	report := &BacktestReport{ProductID: BAZAAR_ID(productID)}
+	var sumAbsErr, sumAbsPct float64
+	var fillTimes, slippages, profitsPerHour []float64
+
+	for i := 0; i+1 < len(snapshots); i++ {
+		snap := snapshots[i]
+		price := 0.0
+		if len(snap.Product.BuySummary) > 0 {
+			price = snap.Product.BuySummary[0].PricePerUnit
+		}
+		if price <= 0 {
+			continue
+		}
+
+		predicted, _, predErr := calculateBuyOrderFillTime(context.Background(), productID, qty, snap.Metrics)
+		if predErr != nil {
+			continue
+		}
+
+		simulated, filled := simulateRestingBuyOrderFillTime(snapshots, i, price, qty)
+		sample := BacktestSample{
+			TimestampUnix: snap.TimestampUnix, TargetPrice: price, Quantity: qty,
+			PredictedSeconds: predicted, SimulatedSeconds: simulated, Filled: filled,
+		}
+
+		if instasellPredicted, instasellErr := calculateInstasellFillTime(qty, snap.Product); instasellErr == nil {
+			sample.InstasellPredictedSeconds = instasellPredicted
+		}
+		instasellSimulated, instasellFilled := simulateInstasellFillTime(snapshots, i, qty)
+		sample.InstasellSimulatedSeconds = instasellSimulated
+		sample.InstasellFilled = instasellFilled
+
+		_, filledQty, avgPrice, slippage := walkBook(snap.Product.BuySummary, qty)
+		if instasellFilled && filledQty >= qty && !math.IsInf(avgPrice, 0) && !math.IsNaN(avgPrice) {
+			sample.InstasellEffectivePrice = avgPrice
+			sample.RealizedSlippage = slippage
+			slippages = append(slippages, slippage)
+			if instasellSimulated > 0 {
+				sample.RealizedProfitPerHour = (avgPrice - price) * qty / instasellSimulated * 3600
+				profitsPerHour = append(profitsPerHour, sample.RealizedProfitPerHour)
+			}
+		}
+
+		report.Samples = append(report.Samples, sample)
+
+		if !filled {
+			continue
+		}
+		absErr := predicted - simulated
+		if absErr < 0 {
+			absErr = -absErr
+		}
+		sumAbsErr += absErr
+		if simulated > 0 {
+			sumAbsPct += absErr / simulated
+		}
+		fillTimes = append(fillTimes, simulated)
+		report.SamplesEvaluated++
+	}
+
+	if report.SamplesEvaluated > 0 {
+		report.MeanAbsErrorSeconds = sumAbsErr / float64(report.SamplesEvaluated)
+		report.MeanAbsPercentError = (sumAbsPct / float64(report.SamplesEvaluated)) * 100.0
+	}
+	if p, ok := percentile(fillTimes, 50); ok {
+		report.MedianFillTimeSeconds = p
+	}
+	if p, ok := percentile(fillTimes, 90); ok {
+		report.P90FillTimeSeconds = p
+	}
+	if p, ok := percentile(fillTimes, 99); ok {
+		report.P99FillTimeSeconds = p
+	}
+	if len(slippages) > 0 {
+		var sum float64
+		for _, s := range slippages {
+			sum += s
+		}
+		report.MeanRealizedSlippage = sum / float64(len(slippages))
+	}
+	if len(profitsPerHour) > 0 {
+		var sum float64
+		for _, p := range profitsPerHour {
+			sum += p
+		}
+		report.MeanRealizedProfitPerHour = sum / float64(len(profitsPerHour))
+	}
+	return report, nil
+}
+
+// SnapshotPath is one historical full-market capture on disk (a gzipped
+// HypixelAPIResponse JSON snapshot, e.g. one per minute for a week), fed to
+// RunBacktest in chronological order. Unlike BacktestSnapshot above (one
+// product's own history), this holds every product at once so
+// RunFullOptimization can be replayed exactly as it ran live.
+type SnapshotPath struct {
+	Path      string    `json:"path"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// loadFullSnapshot reads and gunzips the HypixelAPIResponse stored at path.
+func loadFullSnapshot(path string) (*HypixelAPIResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening snapshot '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("gunzipping snapshot '%s': %w", path, err)
+	}
+	defer gz.Close()
+
+	var resp HypixelAPIResponse
+	if err := json.NewDecoder(gz).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("parsing snapshot '%s': %w", path, err)
+	}
+	return &resp, nil
+}
+
+// BacktestConfig controls how RunBacktest drives RunFullOptimization at each
+// snapshot and how far forward it walks to measure what actually happened.
+type BacktestConfig struct {
+	ItemIDs                      []string
+	MaxAllowedFillTime           float64
+	ItemFilesDir                 string
+	MaxPossibleInitialQtyPerItem float64
+	OptConfig                    OptimizationConfig
+	MetricsMap                   map[string]ProductMetrics
+	// LookaheadSnapshots bounds how many snapshots after t RunBacktest walks
+	// forward looking for fill; <= 0 defaults to 30.
+	LookaheadSnapshots int
+}
+
+// BacktestOutcome is one item's RunFullOptimization recommendation at
+// snapshot t, plus what actually happened when the following
+// LookaheadSnapshots snapshots are walked forward: AchievedRevenue/
+// AchievedFillTime replace the optimizer's projected instasellPrice*qty and
+// fill-time estimate with what the book's real instasell demand would have
+// absorbed, at the price it was absorbed at.
+type BacktestOutcome struct {
+	ItemName               string    `json:"item_name"`
+	SnapshotIndex          int       `json:"snapshot_index"`
+	SnapshotTimestamp      time.Time `json:"snapshot_timestamp"`
+	PredictedQty           float64   `json:"predicted_qty"`
+	PredictedFillTime      float64   `json:"predicted_fill_time_seconds"`
+	PredictedCost          float64   `json:"predicted_cost"`
+	PredictedRevenue       float64   `json:"predicted_revenue"`
+	AchievedQty            float64   `json:"achieved_qty"`
+	AchievedFillTime       float64   `json:"achieved_fill_time_seconds"`
+	AchievedRevenue        float64   `json:"achieved_revenue"`
+	RealizedProfit         float64   `json:"realized_profit"`
+	FillTimeOverrunSeconds float64   `json:"fill_time_overrun_seconds"`
+	MaxFeasibleQuantityHit bool      `json:"max_feasible_quantity_hit"`
+}
+
+// BacktestItemStats aggregates BacktestOutcome by item across every snapshot
+// it was recommended at: the headline numbers for tuning maxAllowedFillTime
+// or flagging an item the book systematically can't absorb.
+type BacktestItemStats struct {
+	ItemName                   string  `json:"item_name"`
+	Samples                    int     `json:"samples"`
+	MeanRealizedProfit         float64 `json:"mean_realized_profit"`
+	StdDevRealizedProfit       float64 `json:"stddev_realized_profit"`
+	MaxRealizedProfit          float64 `json:"max_realized_profit"`
+	MeanFillTimeOverrunSeconds float64 `json:"mean_fill_time_overrun_seconds"`
+	MaxFeasibleQuantityHitRate float64 `json:"max_feasible_quantity_hit_rate"`
+}
+
+// OptimizerBacktestReport is RunBacktest's full output: every per-snapshot
+// outcome plus the per-item statistics aggregated from them.
+type OptimizerBacktestReport struct {
+	Outcomes  []BacktestOutcome   `json:"outcomes"`
+	ItemStats []BacktestItemStats `json:"item_stats"`
+}
+
+// RunBacktest replays snapshots (oldest first) through RunFullOptimization:
+// at each snapshot t it records what the optimizer recommended, then walks
+// forward through t+1..t+LookaheadSnapshots measuring how much of the
+// recommended quantity the book's actual instasell demand would have
+// absorbed and at what price, instead of trusting the projected
+// instasellPrice*qty. Only the final snapshot is never used as a t itself;
+// a t within LookaheadSnapshots of the end walks forward only through the
+// snapshots that remain after it.
+func RunBacktest(snapshots []SnapshotPath, cfg BacktestConfig) (*OptimizerBacktestReport, error) {
+	if len(snapshots) < 2 {
+		return nil, fmt.Errorf("RunBacktest requires at least 2 snapshots, got %d", len(snapshots))
+	}
+	lookahead := cfg.LookaheadSnapshots
+	if lookahead <= 0 {
+		lookahead = 30
+	}
+
+	loaded := make([]*HypixelAPIResponse, len(snapshots))
+	report := &OptimizerBacktestReport{}
+
+	for t := 0; t < len(snapshots)-1; t++ {
+		apiResp, err := loadFullSnapshotCached(loaded, snapshots, t)
+		if err != nil {
+			dlog("RunBacktest: skipping snapshot %d (%s): %v", t, snapshots[t].Path, err)
+			continue
+		}
+
+		results, _ := RunFullOptimization(context.Background(), cfg.ItemIDs, cfg.MaxAllowedFillTime, apiResp, cfg.MetricsMap, cfg.ItemFilesDir, cfg.MaxPossibleInitialQtyPerItem, cfg.OptConfig)
+
+		maxAhead := lookahead
+		if t+maxAhead >= len(snapshots) {
+			maxAhead = len(snapshots) - 1 - t
+		}
+
+		for _, r := range results {
+			if !r.CalculationPossible || r.MaxFeasibleQuantity <= 0 {
+				continue
+			}
+			report.Outcomes = append(report.Outcomes, simulateOptimizerOutcome(r, t, snapshots, loaded, maxAhead))
+		}
+	}
+
+	report.ItemStats = aggregateBacktestStats(report.Outcomes)
+	return report, nil
+}
+
+// loadFullSnapshotCached lazily loads and memoizes snapshots[idx] into loaded.
+func loadFullSnapshotCached(loaded []*HypixelAPIResponse, snapshots []SnapshotPath, idx int) (*HypixelAPIResponse, error) {
+	if loaded[idx] != nil {
+		return loaded[idx], nil
+	}
+	resp, err := loadFullSnapshot(snapshots[idx].Path)
+	if err != nil {
+		return nil, err
+	}
+	loaded[idx] = resp
+	return resp, nil
+}
+
+// simulateOptimizerOutcome walks forward from snapshot t, absorbing r's
+// predicted quantity against each subsequent snapshot's BuyMovingWeek-derived
+// instasell rate (the same rate calculateInstasellFillTime uses) applied
+// over the real elapsed time between consecutive snapshot timestamps, at
+// that snapshot's then-current instasell price - until the full quantity is
+// absorbed or the lookahead window runs out.
+func simulateOptimizerOutcome(r OptimizedItemResult, t int, snapshots []SnapshotPath, loaded []*HypixelAPIResponse, maxAhead int) BacktestOutcome {
+	outcome := BacktestOutcome{
+		ItemName:          r.ItemName,
+		SnapshotIndex:     t,
+		SnapshotTimestamp: snapshots[t].Timestamp,
+		PredictedQty:      r.MaxFeasibleQuantity,
+		PredictedFillTime: float64(r.TotalCycleTimeAtOptimalQty),
+		PredictedCost:     float64(r.CostAtOptimalQty),
+		PredictedRevenue:  float64(r.RevenueAtOptimalQty),
+	}
+
+	remaining := r.MaxFeasibleQuantity
+	prevTimestamp := snapshots[t].Timestamp
+	elapsed := 0.0
+
+	for ahead := 1; ahead <= maxAhead && remaining > 0; ahead++ {
+		idx := t + ahead
+		apiResp, err := loadFullSnapshotCached(loaded, snapshots, idx)
+		if err != nil {
+			dlog("RunBacktest: skipping forward snapshot %d (%s) for %s: %v", idx, snapshots[idx].Path, r.ItemName, err)
+			continue
+		}
+
+		stepSeconds := snapshots[idx].Timestamp.Sub(prevTimestamp).Seconds()
+		prevTimestamp = snapshots[idx].Timestamp
+		if stepSeconds <= 0 {
+			continue
+		}
+
+		product, ok := apiResp.Products[r.ItemName]
+		if !ok {
+			elapsed += stepSeconds
+			continue
+		}
+
+		buyRatePerSecond := product.QuickStatus.BuyMovingWeek / 604800.0
+		if buyRatePerSecond <= 0 {
+			elapsed += stepSeconds
+			continue
+		}
+		absorbed := buyRatePerSecond * stepSeconds
+		if absorbed > remaining {
+			absorbed = remaining
+		}
+
+		if instasellPrice := product.QuickStatus.BuyPrice; instasellPrice > 0 && !math.IsNaN(instasellPrice) && !math.IsInf(instasellPrice, 0) {
+			outcome.AchievedRevenue += absorbed * instasellPrice
+		}
+		outcome.AchievedQty += absorbed
+		remaining -= absorbed
+		elapsed += stepSeconds
+		if remaining <= 0 {
+			outcome.AchievedFillTime = elapsed
+		}
+	}
+
+	if remaining > 0 {
+		// Never fully absorbed within the lookahead window: report the full
+		// window as the achieved fill time so overrun reflects that.
+		outcome.AchievedFillTime = elapsed
+	}
+	outcome.MaxFeasibleQuantityHit = remaining <= 0
+	outcome.RealizedProfit = outcome.AchievedRevenue - outcome.PredictedCost
+	outcome.FillTimeOverrunSeconds = outcome.AchievedFillTime - outcome.PredictedFillTime
+	return outcome
+}
+
+// aggregateBacktestStats groups outcomes by ItemName and computes the
+// mean/stddev/max realized profit, mean fill-time overrun, and
+// MaxFeasibleQuantity hit-rate RunBacktest reports per item.
+func aggregateBacktestStats(outcomes []BacktestOutcome) []BacktestItemStats {
+	byItem := make(map[string][]BacktestOutcome)
+	for _, o := range outcomes {
+		byItem[o.ItemName] = append(byItem[o.ItemName], o)
+	}
+
+	itemNames := make([]string, 0, len(byItem))
+	for name := range byItem {
+		itemNames = append(itemNames, name)
+	}
+	sort.Strings(itemNames)
+
+	stats := make([]BacktestItemStats, 0, len(itemNames))
+	for _, name := range itemNames {
+		group := byItem[name]
+		n := float64(len(group))
+
+		var sumProfit, sumOverrun, hits float64
+		maxProfit := math.Inf(-1)
+		for _, o := range group {
+			sumProfit += o.RealizedProfit
+			if o.RealizedProfit > maxProfit {
+				maxProfit = o.RealizedProfit
+			}
+			sumOverrun += o.FillTimeOverrunSeconds
+			if o.MaxFeasibleQuantityHit {
+				hits++
+			}
+		}
+		mean := sumProfit / n
+
+		var sumSqDiff float64
+		for _, o := range group {
+			d := o.RealizedProfit - mean
+			sumSqDiff += d * d
+		}
+
+		stats = append(stats, BacktestItemStats{
+			ItemName:                   name,
+			Samples:                    len(group),
+			MeanRealizedProfit:         mean,
+			StdDevRealizedProfit:       math.Sqrt(sumSqDiff / n),
+			MaxRealizedProfit:          maxProfit,
+			MeanFillTimeOverrunSeconds: sumOverrun / n,
+			MaxFeasibleQuantityHitRate: hits / n,
+		})
+	}
+	return stats
+}
@@ -0,0 +1,90 @@
+// backtest_config.go
+package main
+
+// Declarative backtest runs, in the same spirit as scenario_config.go's
+// per-item scenarios: a symbol set plus a date range and order size to
+// replay through RunFillTimeBacktest, instead of one item/qty pair per CLI
+// invocation. Like scenario_config.go, this is plain JSON - this package
+// has never taken a YAML dependency (or any third-party dependency at all),
+// so a config.yaml here would mean introducing gopkg.in/yaml.v3 as this
+// package's first one just for this, and JSON already does the job.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// BacktestItemConfig is one product to backtest: its own item id, order
+// quantity, and recorded-snapshot directory, so a config file can mix
+// products that were recorded to different dirs.
+type BacktestItemConfig struct {
+	Item         string  `json:"item"`
+	Quantity     float64 `json:"quantity"`
+	SnapshotsDir string  `json:"snapshotsDir"`
+}
+
+// BacktestScenarioConfig is the top-level shape of a backtest config file:
+// a shared date range (From/To, RFC3339) applied to every BacktestItemConfig.
+type BacktestScenarioConfig struct {
+	From  string                `json:"from"`
+	To    string                `json:"to"`
+	Items []BacktestItemConfig `json:"items"`
+}
+
+// LoadBacktestScenarioConfig reads and parses a backtest config file at path.
+func LoadBacktestScenarioConfig(path string) (*BacktestScenarioConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading backtest config '%s': %w", path, err)
+	}
+	var cfg BacktestScenarioConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing backtest config '%s': %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// backtestScenarioResult is one RunBacktestCLI report entry: cfg's report
+// alongside the item config it came from, the same pairing
+// scenarioResult (scenario_config.go) uses for RunScenarioCLI.
+type backtestScenarioResult struct {
+	Config BacktestItemConfig `json:"config"`
+	Report *BacktestReport    `json:"report,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// RunBacktestCLI implements the `backtest` CLI subcommand: it loads a
+// BacktestScenarioConfig from configPath, runs RunFillTimeBacktest for each
+// BacktestItemConfig over the shared [From, To] range, and writes every
+// report as a JSON array to stdout.
+func RunBacktestCLI(configPath string) error {
+	cfg, err := LoadBacktestScenarioConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	from, err := time.Parse(time.RFC3339, cfg.From)
+	if err != nil {
+		return fmt.Errorf("parsing backtest config 'from' (%q): %w", cfg.From, err)
+	}
+	to, err := time.Parse(time.RFC3339, cfg.To)
+	if err != nil {
+		return fmt.Errorf("parsing backtest config 'to' (%q): %w", cfg.To, err)
+	}
+
+	results := make([]backtestScenarioResult, 0, len(cfg.Items))
+	for _, item := range cfg.Items {
+		qty := item.Quantity
+		if qty <= 0 {
+			qty = 1
+		}
+		report, backtestErr := RunFillTimeBacktest(item.SnapshotsDir, item.Item, from, to, qty)
+		results = append(results, backtestScenarioResult{Config: item, Report: report, Error: errString(backtestErr)})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
@@ -0,0 +1,341 @@
+// batch_fill.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsURLEnv is the environment variable a deployment sets to opt the batch
+// fill queue into JetStream-backed persistence (so queued work survives a
+// server restart). NATS/JetStream support requires the nats.go client,
+// which isn't vendored in this tree (no go.mod here pins any third-party
+// dependency, per this package's in-tree-implementation convention — see
+// coins.go's Coins type for the same call on the decimal-arithmetic side).
+// Until that client is added, setting NATS_URL only logs a warning and
+// falls back to the in-process worker pool below, rather than silently
+// claiming persistence it doesn't have.
+const natsURLEnv = "NATS_URL"
+
+// fillBatchQueueBackend identifies which backend is driving a fillBatch's
+// work queue.
+type fillBatchQueueBackend string
+
+const (
+	fillBatchBackendJetStream fillBatchQueueBackend = "jetstream"
+	fillBatchBackendInProcess fillBatchQueueBackend = "inprocess"
+)
+
+// fillBatchBackend reports which queue backend a new batch should use.
+func fillBatchBackend() fillBatchQueueBackend {
+	if os.Getenv(natsURLEnv) != "" {
+		log.Printf("[fillBatchBackend] %s is set but this build has no JetStream client vendored; falling back to the in-process worker pool", natsURLEnv)
+	}
+	return fillBatchBackendInProcess
+}
+
+// fillBatchWorkerCount bounds how many items of one batch run concurrently,
+// matching ExpandDualStream's default worker count for /expand/bulk.
+const fillBatchWorkerCount = 8
+
+// fillBatchTTL bounds how long a finished (or abandoned) batch's results
+// stay in fillBatchRegistry before sweepExpiredFillBatchesLocked reclaims it.
+const fillBatchTTL = 15 * time.Minute
+
+// fillBatchItem is one row of a POST /api/fill/batch request: the
+// submitted item/qty, plus its StreamRecord once a worker has priced it.
+type fillBatchItem struct {
+	Request ExpandRequest `json:"request"`
+	Record  *StreamRecord `json:"record,omitempty"`
+}
+
+// fillBatch is one /api/fill/batch submission. Workers write into items
+// under mu and close done once every item has a Record; fillBatchByIDHandler
+// and streamFillBatch both read the slice under the same lock.
+type fillBatch struct {
+	ID        string
+	Backend   fillBatchQueueBackend
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	total     int
+	done      chan struct{}
+
+	mu        sync.Mutex
+	items     []fillBatchItem
+	completed int
+}
+
+func (b *fillBatch) snapshot() (items []fillBatchItem, completed, total int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items = make([]fillBatchItem, len(b.items))
+	copy(items, b.items)
+	return items, b.completed, b.total
+}
+
+func (b *fillBatch) setResult(idx int, record StreamRecord) {
+	b.mu.Lock()
+	b.items[idx].Record = &record
+	b.completed++
+	finished := b.completed >= b.total
+	b.mu.Unlock()
+	if finished {
+		close(b.done)
+	}
+}
+
+var fillBatchRegistry = struct {
+	mu      sync.Mutex
+	batches map[string]*fillBatch
+}{batches: make(map[string]*fillBatch)}
+
+// sweepExpiredFillBatchesLocked drops every batch past its ExpiresAt.
+// Called with fillBatchRegistry.mu held, on every registration, so the
+// registry never grows unbounded purely from batches nobody polls again.
+func sweepExpiredFillBatchesLocked() {
+	now := time.Now()
+	for id, b := range fillBatchRegistry.batches {
+		if now.After(b.ExpiresAt) {
+			delete(fillBatchRegistry.batches, id)
+		}
+	}
+}
+
+func registerFillBatch(b *fillBatch) {
+	fillBatchRegistry.mu.Lock()
+	defer fillBatchRegistry.mu.Unlock()
+	sweepExpiredFillBatchesLocked()
+	fillBatchRegistry.batches[b.ID] = b
+}
+
+func getFillBatch(id string) *fillBatch {
+	fillBatchRegistry.mu.Lock()
+	defer fillBatchRegistry.mu.Unlock()
+	return fillBatchRegistry.batches[id]
+}
+
+// startFillBatch registers a new batch and fans requests out across a
+// bounded in-process worker pool, mirroring ExpandDualStream's worker body
+// but retaining each item's StreamRecord (keyed by its position in
+// requests) instead of emitting it once to a channel and discarding it.
+func startFillBatch(requests []ExpandRequest) *fillBatch {
+	b := &fillBatch{
+		ID:        nextExpandJobID(),
+		Backend:   fillBatchBackend(),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(fillBatchTTL),
+		total:     len(requests),
+		done:      make(chan struct{}),
+		items:     make([]fillBatchItem, len(requests)),
+	}
+	for i, req := range requests {
+		b.items[i] = fillBatchItem{Request: req}
+	}
+	registerFillBatch(b)
+
+	if len(requests) == 0 {
+		close(b.done)
+		return b
+	}
+
+	type workItem struct {
+		idx int
+		req ExpandRequest
+	}
+	work := make(chan workItem)
+	go func() {
+		defer close(work)
+		for i, req := range requests {
+			work <- workItem{idx: i, req: req}
+		}
+	}()
+
+	workerCount := fillBatchWorkerCount
+	if workerCount > len(requests) {
+		workerCount = len(requests)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for wi := range work {
+				b.setResult(wi.idx, runFillItem(wi.req))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+	}()
+
+	return b
+}
+
+// runFillItem runs one batch row's core dual-expansion logic — the same
+// PerformDualExpansion call startExpandJob makes for a single async job —
+// and reports its outcome via the shared wiz_fill_requests_total/
+// wiz_fill_duration_seconds metrics.
+func runFillItem(req ExpandRequest) StreamRecord {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		m := DefaultMetrics(nil)
+		m.FillRequestsTotal.WithLabelValues(status).Inc()
+		m.FillDurationSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		status = "error"
+		return StreamRecord{ItemName: req.ItemName, Quantity: req.Quantity, ErrorMessage: "bazaar data unavailable: " + err.Error()}
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	result, expandErr := PerformDualExpansion(context.Background(), req.ItemName, req.Quantity, apiResp, metricsMap, defaultItemFilesDir, false, PrecisionFloat, ExpansionOptions{MaxMetricsAgeSecs: req.MaxAgeSecs})
+	if result != nil && result.PrimaryBased.RecipeTree != nil {
+		DefaultMetrics(nil).ExpandDepth.Observe(float64(result.PrimaryBased.RecipeTree.MaxSubTreeDepth))
+	}
+	record := StreamRecord{
+		ItemName:       req.ItemName,
+		Quantity:       req.Quantity,
+		Result:         result,
+		DurationMillis: time.Since(start).Milliseconds(),
+		Trace:          buildTrace(result),
+	}
+	if expandErr != nil {
+		status = "error"
+		record.ErrorMessage = expandErr.Error()
+	}
+	return record
+}
+
+// fillBatchSnapshot is GET /api/fill/batch/{id}'s response: every submitted
+// item alongside its StreamRecord once priced, plus overall progress.
+type fillBatchSnapshot struct {
+	BatchID   string                `json:"batch_id"`
+	Backend   fillBatchQueueBackend `json:"backend"`
+	Completed int                   `json:"completed"`
+	Total     int                   `json:"total"`
+	Items     []fillBatchItem       `json:"items"`
+}
+
+// fillBatchHandler implements POST /api/fill/batch: it decodes a JSON array
+// of ExpandRequest, starts the batch in the background, and returns the new
+// batch's ID immediately, instead of blocking the request until every item
+// in a (possibly very large) batch has been priced.
+func fillBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var requests []ExpandRequest
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requests) == 0 {
+		http.Error(w, "request body must be a non-empty array of {item, quantity}", http.StatusBadRequest)
+		return
+	}
+
+	// max_age_secs is a batch-wide fallback applied to any row that didn't
+	// set its own max_age_secs in the request body.
+	if batchMaxAge := queryFloatDefault(r, "max_age_secs", 0); batchMaxAge > 0 {
+		for i := range requests {
+			if requests[i].MaxAgeSecs <= 0 {
+				requests[i].MaxAgeSecs = batchMaxAge
+			}
+		}
+	}
+
+	b := startFillBatch(requests)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		BatchID string `json:"batch_id"`
+	}{BatchID: b.ID})
+}
+
+// fillBatchByIDHandler implements the two GET routes nested under a batch
+// ID: /api/fill/batch/{id} (snapshot) and /api/fill/batch/{id}/stream
+// (NDJSON progress as each item completes).
+func fillBatchByIDHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/fill/batch/")
+	stream := strings.HasSuffix(rest, "/stream")
+	batchID := strings.TrimSuffix(rest, "/stream")
+	if batchID == "" {
+		http.Error(w, "missing batch id", http.StatusBadRequest)
+		return
+	}
+
+	b := getFillBatch(batchID)
+	if b == nil {
+		http.Error(w, "batch not found (expired or never existed)", http.StatusNotFound)
+		return
+	}
+
+	if stream {
+		streamFillBatch(w, r, b)
+		return
+	}
+
+	items, completed, total := b.snapshot()
+	json.NewEncoder(w).Encode(fillBatchSnapshot{BatchID: b.ID, Backend: b.Backend, Completed: completed, Total: total, Items: items})
+}
+
+// fillBatchProgressInterval is how often streamFillBatch emits a snapshot
+// while a batch still has outstanding items.
+const fillBatchProgressInterval = 500 * time.Millisecond
+
+// streamFillBatch writes NDJSON fillBatchSnapshot frames until every item
+// in b has a Record (or the client disconnects), then writes one final
+// frame with the completed batch.
+func streamFillBatch(w http.ResponseWriter, r *http.Request, b *fillBatch) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+
+	ticker := time.NewTicker(fillBatchProgressInterval)
+	defer ticker.Stop()
+
+	writeSnapshot := func() error {
+		items, completed, total := b.snapshot()
+		return encoder.Encode(fillBatchSnapshot{BatchID: b.ID, Backend: b.Backend, Completed: completed, Total: total, Items: items})
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			if err := writeSnapshot(); err != nil {
+				log.Printf("streamFillBatch: final frame write failed for batch %s: %v", b.ID, err)
+			}
+			flusher.Flush()
+			return
+		case <-ticker.C:
+			if err := writeSnapshot(); err != nil {
+				log.Printf("streamFillBatch: progress write failed for batch %s: %v", b.ID, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// batch_summary.go
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// BatchStat captures Min/Mean/Median/StdDev/Max for one numeric field across
+// a RunFullOptimization batch. All fields are NaN when the underlying sample
+// was empty.
+type BatchStat struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+	Max    float64 `json:"max"`
+}
+
+// IngredientFrequency is one entry in BatchSummary.TopBottleneckIngredients.
+type IngredientFrequency struct {
+	Ingredient string `json:"ingredient"`
+	Count      int    `json:"count"`
+}
+
+// topBottleneckIngredientsLimit caps BatchSummary.TopBottleneckIngredients so
+// a batch with hundreds of distinct bottlenecks doesn't bloat the summary.
+const topBottleneckIngredientsLimit = 10
+
+// BatchSummary is RunFullOptimization's distributional view of a batch,
+// returned alongside the per-item []OptimizedItemResult: min/mean/median/
+// stddev/max for the batch's three headline numbers (over the
+// CalculationPossible subset), how many items failed and why (bucketed by
+// ErrorMessage prefix), and which base ingredients bottleneck the most
+// items - a systemic supply issue a per-item list alone hides.
+type BatchSummary struct {
+	TotalItems      int       `json:"total_items"`
+	CalculableItems int       `json:"calculable_items"`
+	MaxProfit       BatchStat `json:"max_profit"`
+	TotalCycleTime  BatchStat `json:"total_cycle_time"`
+	MaxFeasibleQty  BatchStat `json:"max_feasible_quantity"`
+	// FailureCategories buckets CalculationPossible==false items by the
+	// human-readable category categorizeFailure parsed out of ErrorMessage.
+	FailureCategories map[string]int `json:"failure_categories,omitempty"`
+	// BottleneckIngredientCounts counts how often each ingredient was the
+	// BottleneckIngredient across every item (calculable or not) that
+	// reported one; TopBottleneckIngredients is the same data sorted by
+	// count descending and capped at topBottleneckIngredientsLimit.
+	BottleneckIngredientCounts map[string]int        `json:"bottleneck_ingredient_counts,omitempty"`
+	TopBottleneckIngredients   []IngredientFrequency `json:"top_bottleneck_ingredients,omitempty"`
+}
+
+// failureCategoryPrefixes maps a substring ErrorMessage is checked for (most
+// specific first) to the human-readable bucket it falls into; the first
+// match wins, and an error message matching none of them falls into "other".
+var failureCategoryPrefixes = []struct {
+	substr   string
+	category string
+}{
+	{"P1 calculation failed", "P1 calculation failed"},
+	{"PrimaryBased calculation not possible", "P1 calculation failed"},
+	{"SecondaryBased calculation not possible", "P2 calculation failed"},
+	{"exceeds max", "time constraint exceeded"},
+	{"Cannot get valid instasell price", "instasell price invalid"},
+	{"No feasible quantity", "no feasible quantity"},
+	{"cancelled", "cancelled"},
+	{"Error finding max feasible quantity", "feasibility search error"},
+	{"Error performing dual expansion", "expansion error"},
+	{"Dual expansion returned nil", "expansion error"},
+}
+
+// categorizeFailure buckets an OptimizedItemResult.ErrorMessage into one of
+// failureCategoryPrefixes' human-readable categories, "unknown (no error
+// message)" when it's empty, or "other" when it matches none of them.
+func categorizeFailure(errorMessage string) string {
+	if errorMessage == "" {
+		return "unknown (no error message)"
+	}
+	for _, p := range failureCategoryPrefixes {
+		if strings.Contains(errorMessage, p.substr) {
+			return p.category
+		}
+	}
+	return "other"
+}
+
+// welfordStats computes min/mean/median/stddev(population)/max for values,
+// using Welford's online algorithm for mean/variance (one pass, no risk of
+// the squared-sum overflow a naive variance formula has on large samples)
+// plus a sort for the median/min/max.
+func welfordStats(values []float64) BatchStat {
+	stat := BatchStat{Min: math.NaN(), Mean: math.NaN(), Median: math.NaN(), StdDev: math.NaN(), Max: math.NaN()}
+	if len(values) == 0 {
+		return stat
+	}
+
+	mean := 0.0
+	m2 := 0.0
+	count := 0.0
+	for _, v := range values {
+		count++
+		delta := v - mean
+		mean += delta / count
+		m2 += delta * (v - mean)
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	median := sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	stat.Min = sorted[0]
+	stat.Max = sorted[len(sorted)-1]
+	stat.Mean = mean
+	stat.Median = median
+	if count > 1 {
+		stat.StdDev = math.Sqrt(m2 / count)
+	} else {
+		stat.StdDev = 0
+	}
+	return stat
+}
+
+// computeBatchSummary builds a BatchSummary over results, the full output of
+// one RunFullOptimization call.
+func computeBatchSummary(results []OptimizedItemResult) BatchSummary {
+	summary := BatchSummary{TotalItems: len(results)}
+
+	var profits, cycleTimes, qtys []float64
+	failureCategories := make(map[string]int)
+	bottleneckCounts := make(map[string]int)
+
+	for _, r := range results {
+		if r.BottleneckIngredient != "" {
+			bottleneckCounts[r.BottleneckIngredient]++
+		}
+		if !r.CalculationPossible {
+			failureCategories[categorizeFailure(r.ErrorMessage)]++
+			continue
+		}
+		summary.CalculableItems++
+		if v := float64(r.MaxProfit); !math.IsNaN(v) {
+			profits = append(profits, v)
+		}
+		if v := float64(r.TotalCycleTimeAtOptimalQty); !math.IsNaN(v) {
+			cycleTimes = append(cycleTimes, v)
+		}
+		qtys = append(qtys, r.MaxFeasibleQuantity)
+	}
+
+	summary.MaxProfit = welfordStats(profits)
+	summary.TotalCycleTime = welfordStats(cycleTimes)
+	summary.MaxFeasibleQty = welfordStats(qtys)
+	if len(failureCategories) > 0 {
+		summary.FailureCategories = failureCategories
+	}
+	if len(bottleneckCounts) > 0 {
+		summary.BottleneckIngredientCounts = bottleneckCounts
+		summary.TopBottleneckIngredients = topIngredientFrequencies(bottleneckCounts, topBottleneckIngredientsLimit)
+	}
+
+	return summary
+}
+
+// topIngredientFrequencies sorts counts by count descending (ties broken by
+// ingredient name ascending) and returns at most limit entries.
+func topIngredientFrequencies(counts map[string]int, limit int) []IngredientFrequency {
+	freqs := make([]IngredientFrequency, 0, len(counts))
+	for ingredient, count := range counts {
+		freqs = append(freqs, IngredientFrequency{Ingredient: ingredient, Count: count})
+	}
+	sort.Slice(freqs, func(i, j int) bool {
+		if freqs[i].Count != freqs[j].Count {
+			return freqs[i].Count > freqs[j].Count
+		}
+		return freqs[i].Ingredient < freqs[j].Ingredient
+	})
+	if len(freqs) > limit {
+		freqs = freqs[:limit]
+	}
+	return freqs
+}
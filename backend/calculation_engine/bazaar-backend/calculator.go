@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// --- Batch C10M calculator ---
+//
+// Calculator wraps getBestC10M/calculateC10MInternal so a batch of
+// {ProductID, Quantity} requests can be priced in one pass - from a file,
+// stdin, or the /c10m HTTP endpoint - instead of only through a one-item-
+// at-a-time interactive flow. It fetches Bazaar data once per batch (via
+// getApiResponse, the same cached-once accessor fillHandler uses) and fans
+// the per-request getBestC10M calls out across a bounded worker pool.
+
+// CalculatorRequest is one {ProductID, Quantity} line of batch input.
+type CalculatorRequest struct {
+	ProductID string  `json:"product_id"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// CalculatorResult is one priced CalculatorRequest, carrying every field
+// getBestC10M/calculateC10MInternal can report for it.
+type CalculatorResult struct {
+	ProductID      string  `json:"product_id"`
+	Quantity       float64 `json:"quantity"`
+	C10MPrimary    float64 `json:"c10m_primary"`
+	C10MSecondary  float64 `json:"c10m_secondary"`
+	IF             float64 `json:"if"`
+	RR             float64 `json:"rr"`
+	DeltaRatio     float64 `json:"delta_ratio"`
+	Adjustment     float64 `json:"adjustment"`
+	BestMethod     string  `json:"best_method"`
+	AssociatedCost float64 `json:"associated_cost"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// Calculator prices CalculatorRequest batches against one Bazaar snapshot
+// and one metrics map, reusing both across every request in the batch
+// rather than re-fetching per item. Workers bounds how many getBestC10M
+// calls run concurrently; a value <= 0 defaults to runtime.GOMAXPROCS(0).
+type Calculator struct {
+	apiResp    *HypixelAPIResponse
+	metricsMap map[string]ProductMetrics
+	workers    int
+}
+
+// NewCalculator builds a Calculator against the given Bazaar snapshot and
+// metrics map. Pass workers <= 0 to default to runtime.GOMAXPROCS(0).
+func NewCalculator(apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, workers int) *Calculator {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Calculator{apiResp: apiResp, metricsMap: metricsMap, workers: workers}
+}
+
+// NewCalculatorFromCache builds a Calculator against the package's cached
+// Bazaar response (via getApiResponse) and metrics map (via getMetricsMap),
+// the same data fillHandler and dualExpansionHandler already read from.
+func NewCalculatorFromCache(workers int) (*Calculator, error) {
+	apiResp, err := getApiResponse()
+	if err != nil {
+		return nil, fmt.Errorf("loading Bazaar data: %w", err)
+	}
+	metricsMap, err := getMetricsMap(metricsFilename)
+	if err != nil {
+		return nil, fmt.Errorf("loading metrics: %w", err)
+	}
+	return NewCalculator(apiResp, metricsMap, workers), nil
+}
+
+// Run prices every request in reqs concurrently across c.workers workers
+// and returns one CalculatorResult per request, in the same order as reqs.
+func (c *Calculator) Run(reqs []CalculatorRequest) []CalculatorResult {
+	results := make([]CalculatorResult, len(reqs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.priceOne(reqs[i])
+			}
+		}()
+	}
+	for i := range reqs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// priceOne prices a single CalculatorRequest via getBestC10M, falling back
+// to just the bestCost/bestMethod/associatedCost/rr shape when the
+// Primary/Secondary/IF/DeltaRatio/adjustment breakdown isn't available
+// (getBestC10M itself only surfaces the winner, not calculateC10MInternal's
+// full breakdown, so those fields are left at their zero value on success
+// rather than invented).
+func (c *Calculator) priceOne(req CalculatorRequest) CalculatorResult {
+	result := CalculatorResult{ProductID: req.ProductID, Quantity: req.Quantity}
+
+	if req.Quantity <= 0 {
+		result.Error = fmt.Sprintf("quantity must be positive (got %.2f)", req.Quantity)
+		return result
+	}
+
+	bestCost, bestMethod, associatedCost, rrValue, err := getBestC10M(req.ProductID, req.Quantity, c.apiResp, c.metricsMap)
+	result.BestMethod = bestMethod
+	result.AssociatedCost = associatedCost
+	result.RR = rrValue
+	if bestMethod == "Primary" {
+		result.C10MPrimary = bestCost
+	} else if bestMethod == "Secondary" {
+		result.C10MSecondary = bestCost
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	productData, apiOk := safeGetProductData(c.apiResp, BAZAAR_ID(req.ProductID))
+	metricsData, metricsOk := safeGetMetricsData(c.metricsMap, BAZAAR_ID(req.ProductID))
+	if apiOk && metricsOk && len(productData.SellSummary) > 0 && len(productData.BuySummary) > 0 {
+		sellP := productData.SellSummary[0].PricePerUnit
+		buyP := productData.BuySummary[0].PricePerUnit
+		if prim, sec, ifRate, rr, delta, adj, calcErr := calculateC10MInternal(req.ProductID, req.Quantity, sellP, buyP, metricsData); calcErr == nil {
+			result.C10MPrimary = prim
+			result.C10MSecondary = sec
+			result.IF = ifRate
+			result.RR = rr
+			result.DeltaRatio = delta
+			result.Adjustment = adj
+		}
+	}
+
+	return result
+}
+
+// --- Input/output encodings ---
+
+// decodeCalculatorRequestsJSON reads a JSON array of CalculatorRequest from r.
+func decodeCalculatorRequestsJSON(r io.Reader) ([]CalculatorRequest, error) {
+	var reqs []CalculatorRequest
+	if err := json.NewDecoder(r).Decode(&reqs); err != nil {
+		return nil, fmt.Errorf("decoding batch JSON: %w", err)
+	}
+	return reqs, nil
+}
+
+// decodeCalculatorRequestsCSV reads "product_id,quantity" rows (with or
+// without a header row) from r.
+func decodeCalculatorRequestsCSV(r io.Reader) ([]CalculatorRequest, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decoding batch CSV: %w", err)
+	}
+	reqs := make([]CalculatorRequest, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		qty, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			continue // header row or malformed line; skip rather than fail the whole batch
+		}
+		reqs = append(reqs, CalculatorRequest{ProductID: row[0], Quantity: qty})
+	}
+	return reqs, nil
+}
+
+// encodeCalculatorResultsCSV writes results as "product_id,quantity,..." rows
+// with a header, in the same field order as CalculatorResult's JSON tags.
+func encodeCalculatorResultsCSV(w io.Writer, results []CalculatorResult) error {
+	cw := csv.NewWriter(w)
+	header := []string{"product_id", "quantity", "c10m_primary", "c10m_secondary", "if", "rr", "delta_ratio", "adjustment", "best_method", "associated_cost", "error"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, res := range results {
+		row := []string{
+			res.ProductID,
+			strconv.FormatFloat(res.Quantity, 'f', -1, 64),
+			strconv.FormatFloat(res.C10MPrimary, 'f', -1, 64),
+			strconv.FormatFloat(res.C10MSecondary, 'f', -1, 64),
+			strconv.FormatFloat(res.IF, 'f', -1, 64),
+			strconv.FormatFloat(res.RR, 'f', -1, 64),
+			strconv.FormatFloat(res.DeltaRatio, 'f', -1, 64),
+			strconv.FormatFloat(res.Adjustment, 'f', -1, 64),
+			res.BestMethod,
+			strconv.FormatFloat(res.AssociatedCost, 'f', -1, 64),
+			res.Error,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// --- /c10m HTTP endpoint ---
+
+// c10mBatchHandler accepts a JSON array of CalculatorRequest and responds
+// with a JSON array of CalculatorResult, reusing one Calculator (and so one
+// Bazaar fetch) for the whole batch - the same withCORS(withRecovery(...))
+// wrapping convention used by fillHandler and dualExpansionHandler.
+func c10mBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST with a JSON batch body", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reqs, err := decodeCalculatorRequestsJSON(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	calc, err := NewCalculatorFromCache(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	results := calc.Run(reqs)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		// Headers are already sent at this point; nothing more to report to the client.
+		dlog("c10mBatchHandler: failed to write JSON response: %v", err)
+	}
+}
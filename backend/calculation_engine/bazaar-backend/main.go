@@ -67,6 +67,7 @@ func main() { /* ... Same as before ... */
 	mux.Handle("/", http.FileServer(http.Dir("public")))
 	mux.Handle("/api/fill", withCORS(withRecovery(fillHandler)))
 	mux.Handle("/api/expand-dual", withCORS(withRecovery(dualExpansionHandler)))
+	mux.Handle("/c10m", withCORS(withRecovery(c10mBatchHandler)))
 	log.Println("Listening on :8080...")
 	if err := http.ListenAndServe(":8080", mux); err != nil {
 		log.Fatalf("CRITICAL: Server failed: %v", err)
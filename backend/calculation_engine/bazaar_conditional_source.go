@@ -0,0 +1,232 @@
+// bazaar_conditional_source.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultConditionalMinTTL matches Hypixel's own Bazaar update cadence, so a
+// ConditionalBazaarSource reuses its in-memory response rather than hitting
+// the network (even conditionally) for calls that land inside one update
+// window - the back-to-back per-item calls a batch run (calculator.go-style
+// tooling) makes in particular.
+const defaultConditionalMinTTL = 30 * time.Second
+
+// conditionalSourceCacheFile is what ConditionalBazaarSource persists to
+// CachePath: the last response body plus the validators needed to make a
+// conditional request against it again after a process restart.
+type conditionalSourceCacheFile struct {
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	Response     json.RawMessage `json:"response"`
+}
+
+// ConditionalBazaarSource is a BazaarSource that fetches from URL using
+// HTTP conditional requests (If-None-Match/If-Modified-Since) so a 304
+// response skips re-downloading and re-decoding a body the caller already
+// has, and persists its last response plus validators to CachePath so a
+// fresh process still has something to send If-None-Match/If-Modified-Since
+// against instead of starting cold. Within MinTTL of the last successful
+// fetch, Fetch returns the in-memory response without even making a
+// network request, which spares batch callers that fetch once per item in
+// quick succession a round trip per item.
+type ConditionalBazaarSource struct {
+	URL        string
+	APIKey     string
+	HTTPClient *http.Client
+	CachePath  string        // defaults to defaultBazaarCachePath() if empty
+	MinTTL     time.Duration // defaults to defaultConditionalMinTTL if zero; negative disables the in-memory short-circuit
+
+	once sync.Once
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	cachedResp   *HypixelAPIResponse
+	cachedAt     time.Time
+}
+
+func (s *ConditionalBazaarSource) Name() string { return "conditional:" + s.URL }
+
+func (s *ConditionalBazaarSource) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+func (s *ConditionalBazaarSource) cachePath() string {
+	if s.CachePath != "" {
+		return s.CachePath
+	}
+	return defaultBazaarCachePath()
+}
+
+func (s *ConditionalBazaarSource) minTTL() time.Duration {
+	if s.MinTTL != 0 {
+		return s.MinTTL
+	}
+	return defaultConditionalMinTTL
+}
+
+// loadFromDisk primes etag/lastModified/cachedResp from a prior process's
+// cache file, if one exists and parses. Run at most once per instance.
+func (s *ConditionalBazaarSource) loadFromDisk() {
+	s.once.Do(func() {
+		data, err := os.ReadFile(s.cachePath())
+		if err != nil {
+			return
+		}
+		var entry conditionalSourceCacheFile
+		if err := json.Unmarshal(data, &entry); err != nil {
+			dlog("ConditionalBazaarSource: failed to parse cache file %s: %v", s.cachePath(), err)
+			return
+		}
+		var resp HypixelAPIResponse
+		if err := json.Unmarshal(entry.Response, &resp); err != nil {
+			dlog("ConditionalBazaarSource: failed to parse cached response in %s: %v", s.cachePath(), err)
+			return
+		}
+		s.mu.Lock()
+		s.etag, s.lastModified = entry.ETag, entry.LastModified
+		s.cachedResp, s.cachedAt = &resp, entry.FetchedAt
+		s.mu.Unlock()
+		dlog("ConditionalBazaarSource: loaded cache file %s (fetched_at=%s)", s.cachePath(), entry.FetchedAt.Format(time.RFC3339))
+	})
+}
+
+// persistToDisk writes the current etag/lastModified/cachedResp/cachedAt to
+// CachePath, creating its parent directory if needed.
+func (s *ConditionalBazaarSource) persistToDisk() {
+	s.mu.Lock()
+	entry := conditionalSourceCacheFile{ETag: s.etag, LastModified: s.lastModified, FetchedAt: s.cachedAt}
+	resp := s.cachedResp
+	s.mu.Unlock()
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		dlog("ConditionalBazaarSource: failed to marshal cached response: %v", err)
+		return
+	}
+	entry.Response = body
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		dlog("ConditionalBazaarSource: failed to marshal cache file: %v", err)
+		return
+	}
+
+	path := s.cachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		dlog("ConditionalBazaarSource: failed to create cache dir for %s: %v", path, err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		dlog("ConditionalBazaarSource: failed to persist cache file %s: %v", path, err)
+	}
+}
+
+func (s *ConditionalBazaarSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	s.loadFromDisk()
+
+	s.mu.Lock()
+	cachedResp, cachedAt := s.cachedResp, s.cachedAt
+	etag, lastModified := s.etag, s.lastModified
+	s.mu.Unlock()
+
+	if ttl := s.minTTL(); ttl > 0 && cachedResp != nil && time.Since(cachedAt) < ttl {
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("memory_hit").Inc()
+		return cachedResp, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building conditional request for %s: %w", s.URL, err)
+	}
+	if s.APIKey != "" {
+		req.Header.Set("API-Key", s.APIKey)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("executing conditional GET to %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if cachedResp == nil {
+			// Server says unchanged but we have nothing cached to return - treat
+			// as a hard miss rather than serving a nil response.
+			DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+			return nil, fmt.Errorf("%s returned 304 Not Modified but no cached response is available", s.URL)
+		}
+		s.mu.Lock()
+		s.cachedAt = time.Now()
+		s.mu.Unlock()
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("not_modified").Inc()
+		s.persistToDisk()
+		return cachedResp, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+		return nil, &fetchHTTPError{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("%s returned non-OK status %d. Body: %s", s.URL, resp.StatusCode, string(bodyBytes)),
+		}
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("reading conditional response body from %s: %w", s.URL, err)
+	}
+
+	var apiResp HypixelAPIResponse
+	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("parsing JSON from %s: %w", s.URL, err)
+	}
+	if !apiResp.Success {
+		DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("%s response 'success' field was false. LastUpdated: %d", s.URL, apiResp.LastUpdated)
+	}
+
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.cachedResp = &apiResp
+	s.cachedAt = time.Now()
+	s.mu.Unlock()
+	DefaultMetrics(nil).BazaarConditionalFetchesTotal.WithLabelValues("modified").Inc()
+	s.persistToDisk()
+
+	return &apiResp, nil
+}
+
+// defaultBazaarCachePath is $XDG_CACHE_HOME/wiz/bazaar.json, falling back to
+// $HOME/.cache/wiz/bazaar.json when XDG_CACHE_HOME isn't set.
+func defaultBazaarCachePath() string {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		base = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(base, "wiz", "bazaar.json")
+}
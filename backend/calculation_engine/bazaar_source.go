@@ -0,0 +1,249 @@
+// bazaar_source.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BazaarSource fetches one HypixelAPIResponse snapshot from wherever it
+// gets its data - the live Hypixel API, a compatible mirror, or a recorded
+// snapshot for deterministic testing/backtesting. fetchBazaarData
+// (api.go) delegates to whichever BazaarSource CurrentBazaarSource holds
+// instead of hardcoding the Hypixel endpoint, so a deployment (or a test)
+// can swap the data source without touching the refresh/cache plumbing
+// built on top of it.
+type BazaarSource interface {
+	// Fetch returns one Bazaar snapshot, or an error if this source
+	// couldn't produce one (network failure, missing file, bad JSON).
+	// ctx.Done() must abort an in-flight fetch rather than block past it.
+	Fetch(ctx context.Context) (*HypixelAPIResponse, error)
+	// Name identifies this source for logging and MultiSource's
+	// success-reporting.
+	Name() string
+}
+
+// CurrentBazaarSource is the BazaarSource fetchBazaarData pulls from.
+// Defaults to HypixelSource (today's hardcoded behavior); see
+// bazaarSourceFromEnv for how a deployment overrides it via env vars.
+var CurrentBazaarSource BazaarSource = HypixelSource{}
+
+// HypixelSource fetches directly from the live Hypixel Bazaar endpoint -
+// the source this package always used before BazaarSource existed. APIKey,
+// if set, is sent as the Hypixel API-Key header; Hypixel's Bazaar endpoint
+// doesn't require one today, but some mirrors built on top of it do.
+type HypixelSource struct {
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func (s HypixelSource) Name() string { return "hypixel" }
+
+func (s HypixelSource) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+func (s HypixelSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	return fetchJSONBazaarResponse(ctx, s.client(), "https://api.hypixel.net/v2/skyblock/bazaar", s.APIKey, "")
+}
+
+// MirrorSource fetches from any endpoint that serves the same
+// HypixelAPIResponse JSON shape - a self-hosted Moulberry/Bazaar-Tracker
+// style mirror, a caching proxy in front of Hypixel, etc. BearerToken, if
+// set, is sent as an Authorization: Bearer header.
+type MirrorSource struct {
+	URL         string
+	BearerToken string
+	HTTPClient  *http.Client
+}
+
+func (s MirrorSource) Name() string { return "mirror:" + s.URL }
+
+func (s MirrorSource) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+func (s MirrorSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	if s.URL == "" {
+		return nil, fmt.Errorf("MirrorSource: no URL configured")
+	}
+	auth := ""
+	if s.BearerToken != "" {
+		auth = "Bearer " + s.BearerToken
+	}
+	return fetchJSONBazaarResponse(ctx, s.client(), s.URL, "", auth)
+}
+
+// fetchJSONBazaarResponse is the shared HTTP-GET-and-decode path
+// HypixelSource and MirrorSource both use, differing only in URL and which
+// auth header (if any) to attach.
+func fetchJSONBazaarResponse(ctx context.Context, client *http.Client, url, apiKey, authHeader string) (*HypixelAPIResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building request for %s: %w", url, err)
+	}
+	if apiKey != "" {
+		req.Header.Set("API-Key", apiKey)
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing GET request to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return nil, &fetchHTTPError{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("%s returned non-OK status %d. Body: %s", url, resp.StatusCode, string(bodyBytes)),
+		}
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body from %s: %w", url, err)
+	}
+
+	var apiResp HypixelAPIResponse
+	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
+		return nil, fmt.Errorf("parsing JSON from %s: %w", url, err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("%s response 'success' field was false. LastUpdated: %d", url, apiResp.LastUpdated)
+	}
+	return &apiResp, nil
+}
+
+// FileSource reads a recorded HypixelAPIResponse snapshot from disk instead
+// of hitting the network - deterministic testing and backtesting against a
+// known snapshot (or sequence of snapshots) rather than whatever the live
+// API happens to return right now.
+//
+// If Path names a single JSON file, Fetch always returns that file's
+// contents. If Path names a directory, Fetch looks for files matching
+// "*.json" and returns the lexicographically-last one (snapshot filenames
+// are expected to sort chronologically, e.g. "20260730T120000.json"),
+// so replaying a directory of timestamped snapshots one at a time just
+// means moving older files out of the way (or pointing Path at a
+// subdirectory) between calls, the same file-driven control a caller
+// already has over FileBackedBazaarCache's (cache.go) on-disk state.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Name() string { return "file:" + s.Path }
+
+func (s FileSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("FileSource: stat %s: %w", s.Path, err)
+	}
+
+	path := s.Path
+	if info.IsDir() {
+		entries, err := filepath.Glob(filepath.Join(s.Path, "*.json"))
+		if err != nil {
+			return nil, fmt.Errorf("FileSource: listing %s: %w", s.Path, err)
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("FileSource: no *.json snapshots under %s", s.Path)
+		}
+		sort.Strings(entries)
+		path = entries[len(entries)-1]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("FileSource: reading %s: %w", path, err)
+	}
+	var apiResp HypixelAPIResponse
+	if err := json.Unmarshal(data, &apiResp); err != nil {
+		return nil, fmt.Errorf("FileSource: parsing %s: %w", path, err)
+	}
+	return &apiResp, nil
+}
+
+// MultiSource tries each of Sources in order, returning the first one that
+// succeeds. Last holds the name of whichever source most recently
+// succeeded, so a caller (or /api/status) can report which one is actually
+// serving live data right now.
+type MultiSource struct {
+	Sources []BazaarSource
+
+	Last string
+}
+
+func (s *MultiSource) Name() string { return "multi" }
+
+func (s *MultiSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	var lastErr error
+	for _, src := range s.Sources {
+		resp, err := src.Fetch(ctx)
+		if err == nil {
+			s.Last = src.Name()
+			return resp, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", src.Name(), err)
+		dlog("MultiSource: %v", lastErr)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("MultiSource: no sources configured")
+	}
+	return nil, fmt.Errorf("all bazaar sources failed, last error: %w", lastErr)
+}
+
+// bazaarSourceFromEnv builds the BazaarSource a deployment asked for via
+// env vars, mirroring this package's existing WIZ_*/plain-named env var
+// convention (see main.go's WIZ_ALIASES/WIZ_EXPAND_WORKERS,
+// batch_fill.go's NATS_URL):
+//
+//   - BAZAAR_SOURCE=hypixel (default): HypixelSource, optionally with
+//     HYPIXEL_API_KEY set as its API-Key header.
+//   - BAZAAR_SOURCE=mirror: MirrorSource against BAZAAR_MIRROR_URL, with an
+//     optional BAZAAR_MIRROR_TOKEN sent as a Bearer token.
+//   - BAZAAR_SOURCE=file: FileSource reading BAZAAR_SNAPSHOT_PATH (a file or
+//     a directory of timestamped snapshots).
+//   - BAZAAR_SOURCE=conditional: ConditionalBazaarSource against the live
+//     Hypixel endpoint (or BAZAAR_MIRROR_URL, if set), using HTTP
+//     conditional requests plus a disk-persisted cache instead of an
+//     unconditional GET on every fetch.
+//
+// Called once at startup (main.go); CurrentBazaarSource can also be set
+// directly by a caller (e.g. a test) that wants a FileSource or a custom
+// MultiSource without going through env vars at all.
+func bazaarSourceFromEnv() BazaarSource {
+	switch os.Getenv("BAZAAR_SOURCE") {
+	case "mirror":
+		return MirrorSource{URL: os.Getenv("BAZAAR_MIRROR_URL"), BearerToken: os.Getenv("BAZAAR_MIRROR_TOKEN")}
+	case "file":
+		return FileSource{Path: os.Getenv("BAZAAR_SNAPSHOT_PATH")}
+	case "conditional":
+		url := os.Getenv("BAZAAR_MIRROR_URL")
+		if url == "" {
+			url = "https://api.hypixel.net/v2/skyblock/bazaar"
+		}
+		return &ConditionalBazaarSource{URL: url, APIKey: os.Getenv("HYPIXEL_API_KEY")}
+	default:
+		return HypixelSource{APIKey: os.Getenv("HYPIXEL_API_KEY")}
+	}
+}
@@ -0,0 +1,190 @@
+// bottleneck_optimizer.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// This file is the flat, single-level "Recipe cells -> base ingredients ->
+// one bottleneck" API this chunk's request asks for by name
+// (Recipe/BaseIngredient/OptimizeResult/OptimizeQuantity). The fuller,
+// recursive-tree version of this same problem already exists via
+// PerformDualExpansion/RunFullOptimization (optimizer.go), whose
+// BottleneckIngredient/BottleneckIngredientQty ride along on every
+// OptimizedItemResult - that path additionally handles sub-recipes,
+// alternates and craft-vs-buy choice per ingredient, which this narrower
+// entry point does not. Use this one when a caller wants exactly the
+// contract below; use RunFullOptimization for everything else.
+
+// BaseIngredient is one concrete ingredient OptimizeQuantity found while
+// flattening a Recipe's cells via aggregateCells: how many of it one craft
+// of the top-level item needs, and how long a buy order for one unit of it
+// takes to fill (calculateBuyOrderFillTime at quantity 1, the same acquisition-time
+// calculation PerformDualExpansion uses per ingredient - see
+// SlowestIngredientBuyTimeSeconds in expansion.go).
+type BaseIngredient struct {
+	ItemID          string
+	AmountPerCraft  float64
+	FillTimePerUnit float64
+}
+
+// OptimizeResult is OptimizeQuantity's output.
+type OptimizeResult struct {
+	Quantity      float64
+	ProfitPerHour float64
+	BottleneckID  string
+}
+
+// optimizeBottleneckMaxQty bounds OptimizeQuantity's search when the caller
+// has no liquidity-derived cap to pass in (the request's signature has no
+// room for one); 100000 is comfortably above anything the Hypixel bazaar's
+// order book could realistically absorb for a single recipe ingredient.
+const optimizeBottleneckMaxQty = 100000.0
+
+// getTopLevelPrice returns itemIDNorm's QuickStatus.BuyPrice - Hypixel's own
+// weighted quick-stat, as opposed to getBuyPrice's literal BuySummary[0]
+// book entry. OptimizeQuantity compares the two (within epsilon) to tell
+// whether the top-level item is currently trading right at the book's best
+// order, vs. away from it (e.g. stale QuickStatus, or a thin/jumpy book).
+func getTopLevelPrice(apiResp *HypixelAPIResponse, itemIDNorm string) float64 {
+	prod, ok := safeGetProductData(apiResp, itemIDNorm)
+	if !ok {
+		return 0.0
+	}
+	return prod.QuickStatus.BuyPrice
+}
+
+// flattenBaseIngredients expands recipe's A1..C3 cells via aggregateCells
+// (the same cell parser recipe.go/utils.go use for a SingleRecipe) into one
+// BaseIngredient per concrete ingredient, resolving an "ITEM_A|ITEM_B"
+// alternates slot via IngredientSpec.ResolvedItemID the same way the
+// recursive expansion path does.
+func flattenBaseIngredients(ctx context.Context, recipe Recipe, apiResp *HypixelAPIResponse, metrics map[string]ProductMetrics) ([]BaseIngredient, error) {
+	cells := cellsOf(SingleRecipe{
+		A1: recipe.A1, A2: recipe.A2, A3: recipe.A3,
+		B1: recipe.B1, B2: recipe.B2, B3: recipe.B3,
+		C1: recipe.C1, C2: recipe.C2, C3: recipe.C3,
+	})
+	specs, err := aggregateCells(ctx, cells, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("flattening recipe cells: %w", err)
+	}
+
+	ingredients := make([]BaseIngredient, 0, len(specs))
+	for _, spec := range specs {
+		itemID := spec.ResolvedItemID(apiResp)
+		if itemID == "" || spec.Amount <= 0 {
+			continue
+		}
+		fillTime, _, err := calculateBuyOrderFillTime(ctx, itemID, 1, getMetrics(metrics, itemID))
+		if err != nil || math.IsNaN(fillTime) || math.IsInf(fillTime, 0) {
+			fillTime = 0
+		}
+		ingredients = append(ingredients, BaseIngredient{ItemID: itemID, AmountPerCraft: spec.Amount, FillTimePerUnit: fillTime})
+	}
+	return ingredients, nil
+}
+
+// evaluateBottleneckQuantity computes the FT_final/profit-per-hour this
+// chunk's request describes at one candidate qty: FT_base is the
+// bottleneck ingredient's own calculateBuyOrderFillTime at the quantity qty
+// actually needs, and the extra `(qty*60)/(buyMovingWeek/168)` instasell
+// term is only added when the top-level item's price is currently at its
+// buy_summary[0] best order (within priceEpsilon) - i.e. when acquiring the
+// crafted item itself would mean instabuying it rather than crafting it,
+// getTopLevelPrice/getBuyPrice's agreement signals that's the live state of
+// the book for it right now.
+func evaluateBottleneckQuantity(ctx context.Context, itemIDNorm string, qty float64, ingredients []BaseIngredient, apiResp *HypixelAPIResponse, metrics map[string]ProductMetrics, craftedAmount float64, priceEpsilon float64) (profitPerHour float64, bottleneckID string, ok bool) {
+	if qty <= 0 || craftedAmount <= 0 {
+		return 0, "", false
+	}
+
+	bottleneckID = ""
+	worstFillTimePerUnit := -1.0
+	for _, ing := range ingredients {
+		if ing.FillTimePerUnit > worstFillTimePerUnit {
+			worstFillTimePerUnit = ing.FillTimePerUnit
+			bottleneckID = ing.ItemID
+		}
+	}
+	if bottleneckID == "" {
+		return 0, "", false
+	}
+
+	crafts := qty / craftedAmount
+	var bottleneckAmount float64
+	var costPerCraft float64
+	for _, ing := range ingredients {
+		costPerCraft += ing.AmountPerCraft * getBuyPrice(apiResp, ing.ItemID)
+		if ing.ItemID == bottleneckID {
+			bottleneckAmount = ing.AmountPerCraft
+		}
+	}
+
+	ftBase, _, err := calculateBuyOrderFillTime(ctx, bottleneckID, bottleneckAmount*crafts, getMetrics(metrics, bottleneckID))
+	if err != nil || math.IsNaN(ftBase) || math.IsInf(ftBase, 0) {
+		return 0, bottleneckID, false
+	}
+	ftFinal := ftBase
+
+	topLevelPrice := getTopLevelPrice(apiResp, itemIDNorm)
+	buySummaryPrice := getBuyPrice(apiResp, itemIDNorm)
+	if buySummaryPrice > 0 && math.Abs(topLevelPrice-buySummaryPrice) <= priceEpsilon {
+		prod, hasProd := safeGetProductData(apiResp, itemIDNorm)
+		if hasProd && prod.QuickStatus.BuyMovingWeek > 0 {
+			ftFinal += (qty * 60.0) / (prod.QuickStatus.BuyMovingWeek / 168.0)
+		}
+	}
+	if ftFinal <= 0 {
+		return 0, bottleneckID, false
+	}
+
+	sellPrice := getSellPrice(apiResp, itemIDNorm)
+	costPerItem := (costPerCraft / craftedAmount)
+	profitPerItem := sellPrice - costPerItem
+
+	return profitPerItem * qty * 3600.0 / ftFinal, bottleneckID, true
+}
+
+// OptimizeQuantity searches the order quantity of itemIDNorm (crafted via
+// recipe) that maximizes profit-per-hour under the FT_final model above,
+// probing a geometric sequence of quantities (mirroring
+// ComputeProfitCurve's bounded-iteration search in profit_curve.go) and
+// returning the best one found, its profit-per-hour, and which
+// BaseIngredient was the bottleneck at that quantity.
+func OptimizeQuantity(itemID string, recipe Recipe, metrics map[string]ProductMetrics, apiResp *HypixelAPIResponse) (float64, float64, string, error) {
+	ctx := context.Background()
+	itemIDNorm := BAZAAR_ID(itemID)
+
+	craftedAmount := float64(recipe.Count)
+	if craftedAmount <= 0 {
+		craftedAmount = 1
+	}
+
+	ingredients, err := flattenBaseIngredients(ctx, recipe, apiResp, metrics)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if len(ingredients) == 0 {
+		return 0, 0, "", fmt.Errorf("recipe for %s has no resolvable base ingredients", itemIDNorm)
+	}
+
+	const priceEpsilon = 0.01
+	bestQty, bestProfitPerHour, bestBottleneck := 0.0, math.Inf(-1), ""
+	for qty := 1.0; qty <= optimizeBottleneckMaxQty; qty *= 2 {
+		profitPerHour, bottleneckID, ok := evaluateBottleneckQuantity(ctx, itemIDNorm, qty, ingredients, apiResp, metrics, craftedAmount, priceEpsilon)
+		if !ok {
+			continue
+		}
+		if profitPerHour > bestProfitPerHour {
+			bestQty, bestProfitPerHour, bestBottleneck = qty, profitPerHour, bottleneckID
+		}
+	}
+
+	if bestBottleneck == "" {
+		return 0, 0, "", fmt.Errorf("no feasible quantity found for %s", itemIDNorm)
+	}
+	return bestQty, bestProfitPerHour, bestBottleneck, nil
+}
@@ -2,20 +2,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sync"
 )
 
 // calculateC10MInternal is the core logic for C10M calculation.
-// It takes all necessary pre-fetched and validated inputs.
+// It takes all necessary pre-fetched and validated inputs. model selects the
+// Primary-path cost prediction theory (see CostModel, cost_model.go); nil
+// falls back to HypixelTriangularModel{}, the original heuristic, so every
+// existing caller that passes nil sees no change in behavior.
 func calculateC10MInternal(
 	prodID string, // Normalized Product ID for logging
 	qty float64, // Quantity needed
 	sellP float64, // Sell Price (top sell order, i.e., price to place a buy order under)
 	buyP float64, // Buy Price (top buy order, i.e., price to insta-buy at)
 	pm ProductMetrics, // ProductMetrics for the item
+	model CostModel, // Primary-path cost model; nil defaults to HypixelTriangularModel{}
 ) (c10mPrimary, c10mSecondary, ifValue, rrValue, deltaRatio, adjustment float64, err error) {
 
+	if model == nil {
+		model = HypixelTriangularModel{}
+	}
+
 	dlog("  [Internal C10M Calc] For %.2f x %s", qty, prodID)
 
 	// Validate inputs
@@ -39,7 +49,11 @@ func calculateC10MInternal(
 	demandRate := o_s * o_f // Demand based on buy orders being placed by others
 	dlog("    Rates for %s: SupplyRate (s_s*s_f)=%.4f (ss:%.2f * sf:%.2f), DemandRate (o_s*o_f)=%.4f (os:%.2f * of:%.2f)", prodID, supplyRate, s_s, s_f, demandRate, o_s, o_f)
 
-	// Delta Ratio: Ratio of supply to demand pressure
+	// Delta Ratio: Ratio of supply to demand pressure. Recomputed here
+	// (rather than threaded out of HypixelTriangularModel below) purely so
+	// it can be logged and returned - it isn't part of the CostModel
+	// interface's return shape, since not every model has a natural notion
+	// of it (PoissonQueueModel doesn't use it at all).
 	if demandRate <= 0 { // Avoid division by zero
 		if supplyRate <= 0 {
 			deltaRatio = 1.0 // No flow either way, neutral
@@ -51,128 +65,17 @@ func calculateC10MInternal(
 	}
 	dlog("    DeltaRatio (SR/DR) for %s: %.4f", prodID, deltaRatio)
 
-	// Base cost for Primary C10M (cost if order fills instantly at sellP)
-	baseCostPrimary := qty * sellP
-	dlog("    Base Cost (Primary C10M) for %s (qty * sellP): %.2f * %.2f = %.2f", prodID, qty, sellP, baseCostPrimary)
-
 	// --- Primary C10M Calculation (Buy Order Cost) ---
-	if deltaRatio > 1.0 { // More supply than demand pressure: order likely fills fast
-		dlog("    DeltaRatio > 1.0 for %s: Simplified logic (fast fill).", prodID)
-		ifValue = math.Inf(1) // Effectively infinite insta-fills relative to order size
-		rrValue = 1.0         // One round of orders needed
-		adjustment = 0.0      // No upward adjustment needed
-		c10mPrimary = baseCostPrimary
-		dlog("    Primary C10M for %s = baseCostPrimary = %.2f", prodID, c10mPrimary)
-	} else { // deltaRatio <= 1.0: Demand matches or exceeds supply pressure, slower fill
-		dlog("    DeltaRatio <= 1.0 for %s: Full IF/RR logic.", prodID)
-
-		// Calculate InstaFills (IF) per order cycle
-		if o_f <= 0 { // If no orders are being placed by others (OrderFrequency is 0)
-			ifValue = 0 // No opportunity for our order to be insta-filled by new sell orders
-			dlog("    IF Calc for %s: OrderFrequency (o_f) <= 0. IF = 0.", prodID)
-		} else {
-			// IF = SellSize * (SellFrequency / OrderFrequency)
-			// This represents how many items (s_s) are insta-sold by others during the typical lifetime of one of our buy orders.
-			ifValue = s_s * (s_f / o_f)
-			dlog("    IF Calc for %s: s_s * (s_f / o_f) = %.4f * (%.4f / %.4f) = %.4f", prodID, s_s, s_f, o_f, ifValue)
-		}
-		ifValue = math.Max(0, ifValue) // Ensure IF is not negative
-		dlog("    Final Calculated IF for %s: %.4f", prodID, ifValue)
-
-		// Calculate RelistRate (RR)
-		if ifValue <= 0 { // If no items are insta-filled per order cycle
-			// If there's also no general supply (supplyRate is 0), then RR is Inf (never fills)
-			// If there IS supply, but IF is 0 (e.g., o_f was 0), it implies a complex situation.
-			// For simplicity, if IF is 0, assume RR becomes effectively infinite for filling 'qty'.
-			rrValue = math.Inf(1)
-			dlog("    RR Calc for %s: IF <= 0 -> RR = Inf.", prodID)
-		} else {
-			rrValue = math.Ceil(qty / ifValue) // How many order cycles to fill 'qty'
-			dlog("    RR Calc for %s: Ceil(qty / IF) = Ceil(%.2f / %.4f) = %.2f", prodID, qty, ifValue, rrValue)
-		}
-		// RR must be at least 1, unless it's already Inf (which means it'll never fill)
-		if rrValue < 1 && !math.IsInf(rrValue, 1) {
-			rrValue = 1.0
-		}
-		if math.IsNaN(rrValue) { // Should not happen if IF logic is correct, but defensive
-			rrValue = math.Inf(1)
-		}
-		dlog("    Final RR for %s: %.2f", prodID, rrValue)
-
-		// Calculate cost adjustment factor
-		if math.IsInf(rrValue, 1) { // If RR is infinite, primary cost is infinite
-			dlog("    RR is Infinite for %s, Primary C10M is Infinite.", prodID)
-			c10mPrimary = math.Inf(1)
-			adjustment = 0.0 // No meaningful adjustment if cost is already Inf
-		} else {
-			if rrValue <= 1.0 { // If fills in one round or less (deltaRatio > 1 case effectively)
-				adjustment = 0.0
-				dlog("    Adjustment factor for %s: RR <= 1.0 -> adj = 0.0", prodID)
-			} else {
-				// Adjustment factor: (1 - 1/RR), approaches 1 as RR increases
-				adjustment = 1.0 - (1.0 / rrValue)
-				dlog("    Adjustment factor for %s: 1.0 - (1.0 / %.2f) = %.4f", prodID, rrValue, adjustment)
-			}
-
-			// Calculate extra cost due to relisting (simplified model)
-			// This "extra" part is a bit hand-wavy in the C10M model.
-			// A simpler C10M might just be: Cost = Base + Adjustment_Factor * (Price_Range_Penalty)
-			// The original C10M LaTeX implies a more complex "extra" related to sum of k.
-			// For now, let's use a simplified interpretation or a placeholder for "extra".
-			// A common simplification: if adjustment > 0, there's *some* penalty.
-			// The original prompt's formula `sellP * (qty*rrValue - ifValue*sumK)` can be large.
-			// Let's assume `extra` is a penalty related to the spread or a fixed percentage if relisting is high.
-			// For this implementation, let's stick to the spirit of the adjustment factor.
-			// If C10M = BaseCost * (1 + AdjustmentFactor * PenaltyFraction)
-			// If PenaltyFraction is e.g. (BuyPrice - SellPrice)/SellPrice (the spread as fraction of sell price)
-			// This can get complex. The original formula for `extra` might be too volatile.
-			// Let's assume the adjustment applies to a portion of the base cost that represents risk/time.
-			// For now, using a simplified adjustment logic: c10mPrimary = baseCostPrimary * (1 + adjustment_penalty)
-			// where adjustment_penalty is related to `adjustment`. If `adjustment` is 0.5, maybe penalty is 0.1 (10%).
-			// This part of C10M is often proprietary or heavily tweaked.
-			// The provided C10MInternal code had: extra = sellP * (qty*rrValue - ifValue*sumK)
-			// Let's re-evaluate sumK logic from the original context if available.
-			// If sumK is sum of 1 to RR_int:
-			var extraCalculatedPart float64 = 0.0
-			if adjustment > 0 { // Only calculate if there's an adjustment
-				RRint := int(math.Round(rrValue)) // Use rounded RR for sumK
-				if RRint < 1 {
-					RRint = 1
-				}
-				sumK := float64(RRint*(RRint+1)) / 2.0 // Sum of integers from 1 to RRint
-
-				// The term (qty*rrValue - ifValue*sumK) can be problematic.
-				// If ifValue*sumK is very large, this could go negative.
-				// This "extra" cost needs careful interpretation.
-				// Original formula might be: Cost = Base + Adj * (Cost_Of_Waiting_Or_Relisting_Penalty)
-				// Let's use the formula structure as provided:
-				extraTerm := (qty * rrValue) - (ifValue * sumK)
-				// This extra term seems to represent a "cost beyond simple base * quantity"
-				// due to multiple relists or waiting.
-				// If this term is negative, it implies a "gain", which is counterintuitive for a cost.
-				// So, clamp it at 0 if it goes negative.
-				extraCalculatedPart = sellP * math.Max(0, extraTerm)
-				dlog("    Extra Cost Part for %s: sellP * Max(0, (qty*RR - IF*sumK(RRint=%d))) = %.2f * Max(0, (%.2f*%.2f - %.4f*%.2f)) = %.2f",
-					prodID, sellP, RRint, qty, rrValue, ifValue, sumK, extraCalculatedPart)
-			} else {
-				dlog("    Extra Cost Part for %s: Skipped (adjustment is 0).", prodID)
-			}
-
-			c10mPrimary = baseCostPrimary + (adjustment * extraCalculatedPart)
-			// Validate c10mPrimary
-			if math.IsInf(c10mPrimary, 0) || math.IsNaN(c10mPrimary) {
-				dlog("    Primary C10M for %s calculation resulted in Inf/NaN.", prodID)
-				c10mPrimary = math.Inf(1) // Ensure positive Inf for error
-			} else if c10mPrimary < 0 { // Cost should not be negative
-				dlog("    WARN: Primary C10M for %s calculation resulted in negative (%.2f). Clamping to base or Inf.", prodID, c10mPrimary)
-				// If it's negative, it suggests an issue with the 'extra' calculation or parameters.
-				// Fallback to baseCostPrimary or Inf if baseCostPrimary is also problematic.
-				c10mPrimary = math.Max(baseCostPrimary, 0) // Ensure it's at least base, or 0 if base was also bad. More robust: math.Inf(1)
-			} else {
-				dlog("    Primary C10M for %s: baseCostPrimary + adjustment*extra = %.2f + %.4f*%.2f = %.2f", prodID, baseCostPrimary, adjustment, extraCalculatedPart, c10mPrimary)
-			}
-		}
+	// Delegated to the CostModel interface (cost_model.go) so an
+	// alternative fill-time theory (e.g. PoissonQueueModel) can be swapped
+	// in via getBestC10M's model parameter without forking this function.
+	var modelErr error
+	c10mPrimary, ifValue, rrValue, adjustment, modelErr = model.EstimatePrimary(qty, sellP, pm)
+	if modelErr != nil {
+		err = modelErr
 	}
+	dlog("    Primary C10M (via %T) for %s: Cost=%.2f, IF=%.4f, RR=%.2f, Adj=%.4f",
+		model, prodID, c10mPrimary, ifValue, rrValue, adjustment)
 
 	// --- Secondary C10M Calculation (Insta-Buy Cost) ---
 	c10mSecondary = qty * buyP // Cost to insta-buy 'qty' at the current top buy order price
@@ -192,15 +95,33 @@ func calculateC10MInternal(
 
 // getBestC10M determines the best C10M (Primary or Secondary) for acquiring an item.
 // It returns the cost, method, associated cost (contextual), RR, IF, and any error.
+// ctx is checked up front so a caller iterating many base ingredients (e.g. the
+// analyzeTreeForCostsAndTimes loop) can bail out once its deadline has passed
+// instead of pricing ingredients nobody will see the result for. precision
+// selects how the Primary-vs-Secondary comparison itself is made:
+// PrecisionDecimal compares through Coins (exact millicoin integers) instead
+// of raw float64, so two near-equal costs that differ only in float64's
+// mantissa noise can't flip the chosen method between otherwise-identical
+// calls; PrecisionFloat keeps the original plain-float64 comparison. model
+// selects the Primary-path cost prediction theory (CostModel, cost_model.go);
+// nil falls back to HypixelTriangularModel{}, the original heuristic, so
+// every existing caller passing nil sees no change in behavior.
 func getBestC10M(
+	ctx context.Context,
 	itemID string,
 	quantity float64,
 	apiResp *HypixelAPIResponse,
 	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	model CostModel,
 ) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, err error) {
 
+	if model == nil {
+		model = HypixelTriangularModel{}
+	}
+
 	itemIDNorm := BAZAAR_ID(itemID)
-	dlog("Getting Best C10M for %.2f x %s", quantity, itemIDNorm)
+	dlog("Getting Best C10M (model=%T) for %.2f x %s", model, quantity, itemIDNorm)
 
 	// Initialize return values for error cases or N/A
 	bestCost = math.Inf(1)      // Default to infinite cost
@@ -209,8 +130,15 @@ func getBestC10M(
 	rrValue = math.NaN()        // RelistRate, NaN if not applicable (e.g., for Secondary)
 	ifValue = math.NaN()        // InstaFills, NaN if not applicable
 
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("context_cancelled").Inc()
+		return
+	}
+
 	if quantity <= 0 {
 		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("invalid_quantity").Inc()
 		// For 0 quantity, cost is 0, method N/A, others 0 or NaN.
 		return 0, "N/A", 0, 0, 0, err // Or specific values for 0 quantity if defined.
 	}
@@ -224,6 +152,7 @@ func getBestC10M(
 	if !apiOk {
 		dlog("  [%s] API data not found.", itemIDNorm)
 		err = fmt.Errorf("API data not found for %s", itemIDNorm)
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("api_data_missing").Inc()
 		// All return values remain at their error/default state
 		return // bestCost=Inf, bestMethod="N/A", etc.
 	}
@@ -241,7 +170,8 @@ func getBestC10M(
 		errMsg := fmt.Sprintf("invalid prices from API for %s (sP: %.2f, bP: %.2f)", itemIDNorm, sellP, buyP)
 		dlog("  [%s] %s", itemIDNorm, errMsg)
 		err = fmt.Errorf(errMsg) // Set the error
-		return                   // Return with error defaults
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("invalid_prices").Inc()
+		return // Return with error defaults
 	}
 	dlog("  [%s] Prices from API - SellP (for buy order): %.2f, BuyP (for instabuy): %.2f", itemIDNorm, sellP, buyP)
 
@@ -258,6 +188,7 @@ func getBestC10M(
 			} else {
 				err = fmt.Errorf("metrics missing and %s", errMsg)
 			}
+			DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("secondary_failed_no_metrics").Inc()
 			return // Return with error defaults
 		}
 		// If Secondary C10M is valid, it's the best/only option
@@ -281,10 +212,11 @@ func getBestC10M(
 	var calcIF, calcRR float64 // Capture IF/RR from internal calculation
 	var calcErr error          // Error from internal calculation
 
-	c10mPrim, c10mSec, calcIF, calcRR, _, _, calcErr = calculateC10MInternal(itemIDNorm, quantity, sellP, buyP, metricsData)
+	c10mPrim, c10mSec, calcIF, calcRR, _, _, calcErr = calculateC10MInternal(itemIDNorm, quantity, sellP, buyP, metricsData, model)
 
 	if calcErr != nil {
 		dlog("  [%s] Error during C10M internal calculation: %v", itemIDNorm, calcErr)
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("internal_calc_error").Inc()
 		if err == nil { // If no prior error (e.g. API price validation)
 			err = calcErr
 		} else { // Append to existing error
@@ -299,7 +231,7 @@ func getBestC10M(
 	validSec := !math.IsInf(c10mSec, 0) && !math.IsNaN(c10mSec) && c10mSec >= 0
 
 	if validPrim && validSec {
-		if c10mPrim <= c10mSec {
+		if costLessOrEqual(c10mPrim, c10mSec, precision) {
 			bestCost = c10mPrim
 			bestMethod = "Primary"
 			associatedCost = quantity * sellP // Cost if order placed at sellP
@@ -338,6 +270,7 @@ func getBestC10M(
 		if err == nil { // If no specific error yet, create one
 			err = fmt.Errorf("failed to determine any valid C10M for %s (both Primary/Secondary results invalid)", itemIDNorm)
 		}
+		DefaultMetrics(nil).C10MErrorsTotal.WithLabelValues("both_invalid").Inc()
 	}
 
 	// Final sanity checks on output values for consistency, especially if method is N/A
@@ -365,3 +298,704 @@ func getBestC10M(
 	dlog("  [%s] Best C10M Final Result: Cost=%.2f, Method=%s, AssocCost=%.2f, RR=%.2f, IF=%.4f, Err=%v", itemIDNorm, bestCost, bestMethod, associatedCost, rrValue, ifValue, err)
 	return // Return named variables
 }
+
+// walkBook consumes summary (assumed sorted best-price-first, the order the
+// Hypixel API already returns sell_summary/buy_summary in) top-down until
+// qty is met, returning the true weighted cost of filling qty instead of
+// the top-of-book approximation qty*summary[0].PricePerUnit. filledQty is
+// how much of qty the book could actually supply; avgPrice is totalCost/
+// filledQty. slippage is how far avgPrice drifted from the best price,
+// as a fraction of it (0 means the whole qty filled at the top order's
+// price). If summary can't fully fill qty, totalCost and avgPrice/slippage
+// are +Inf/NaN and filledQty reports the partial amount actually walked.
+func walkBook(summary []OrderSummary, qty float64) (totalCost, filledQty, avgPrice, slippage float64) {
+	return walkBookCapped(summary, qty, 0)
+}
+
+// walkBookCapped is walkBook plus a DepthQuantity knob: maxDepthCoins, when
+// positive, stops the walk once totalCost would exceed it even if qty isn't
+// fully filled yet, the same "only trust the first N coins of depth" idea
+// as capping a VWAP calculation at a synthetic liquidity ceiling instead of
+// reading arbitrarily deep (and arbitrarily stale/thin) into a book. A
+// maxDepthCoins of 0 means unlimited, i.e. walkBook's original behavior.
+func walkBookCapped(summary []OrderSummary, qty float64, maxDepthCoins float64) (totalCost, filledQty, avgPrice, slippage float64) {
+	if qty <= 0 || len(summary) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	bestPrice := summary[0].PricePerUnit
+	remaining := qty
+	for _, order := range summary {
+		if remaining <= 0 {
+			break
+		}
+		take := math.Min(remaining, float64(order.Amount))
+		if take <= 0 {
+			continue
+		}
+		cost := take * order.PricePerUnit
+		if maxDepthCoins > 0 && totalCost+cost > maxDepthCoins {
+			// Only take as much of this order as fits under the depth cap.
+			affordable := (maxDepthCoins - totalCost) / order.PricePerUnit
+			if affordable <= 0 {
+				break
+			}
+			take = math.Min(take, affordable)
+			cost = take * order.PricePerUnit
+			totalCost += cost
+			filledQty += take
+			remaining -= take
+			break
+		}
+		totalCost += cost
+		filledQty += take
+		remaining -= take
+	}
+
+	if remaining > 1e-9 { // book (or the depth cap) was exhausted before qty was fully filled
+		dlog("    walkBookCapped: exhausted before filling, filled %.2f of %.2f requested (maxDepthCoins=%.2f)", filledQty, qty, maxDepthCoins)
+		return math.Inf(1), filledQty, math.NaN(), math.NaN()
+	}
+
+	avgPrice = totalCost / filledQty
+	if bestPrice > 0 {
+		slippage = (avgPrice - bestPrice) / bestPrice
+	}
+	return totalCost, filledQty, avgPrice, slippage
+}
+
+// C10MVolatilityConfig controls the Bollinger-style penalty
+// calculateC10MInternal applies to its relisting "extra" cost term. When
+// Enabled, extraCalculatedPart is scaled by 1 + K*(stdev/mean) computed
+// from the product's own rolling PriceStats, so an item whose price is
+// bouncing around gets a bigger multi-cycle-relisting penalty than a
+// stable one at the same RR. A backtest that needs a deterministic C10M
+// independent of whatever PriceHistory happens to be on record should set
+// Enabled to false.
+type C10MVolatilityConfig struct {
+	Enabled bool
+	K       float64
+}
+
+var defaultC10MVolatilityConfig = C10MVolatilityConfig{Enabled: true, K: 1.0}
+
+var (
+	c10mVolatilityConfigMu      sync.RWMutex
+	currentC10MVolatilityConfig = defaultC10MVolatilityConfig
+)
+
+// SetC10MVolatilityConfig installs cfg as the volatility modulation used by
+// future calculateC10MInternal calls.
+func SetC10MVolatilityConfig(cfg C10MVolatilityConfig) {
+	c10mVolatilityConfigMu.Lock()
+	currentC10MVolatilityConfig = cfg
+	c10mVolatilityConfigMu.Unlock()
+}
+
+func getC10MVolatilityConfig() C10MVolatilityConfig {
+	c10mVolatilityConfigMu.RLock()
+	defer c10mVolatilityConfigMu.RUnlock()
+	return currentC10MVolatilityConfig
+}
+
+// C10MDepthConfig caps how deep getBestC10MFullDepth is willing to walk
+// either side of the book. DepthQuantity of 0 means unlimited (walk the
+// whole SellSummary/BuySummary); a positive value stops the walk once that
+// many coins have been committed, the same "only trust a synthetic level of
+// depth" idea LiquidityConfig applies to frequency/size instead of price.
+type C10MDepthConfig struct {
+	DepthQuantity float64
+}
+
+var defaultC10MDepthConfig = C10MDepthConfig{DepthQuantity: 0}
+
+var (
+	c10mDepthConfigMu      sync.RWMutex
+	currentC10MDepthConfig = defaultC10MDepthConfig
+)
+
+// SetC10MDepthConfig installs cfg as the depth cap used by future
+// getBestC10MFullDepth calls.
+func SetC10MDepthConfig(cfg C10MDepthConfig) {
+	c10mDepthConfigMu.Lock()
+	currentC10MDepthConfig = cfg
+	c10mDepthConfigMu.Unlock()
+}
+
+func getC10MDepthConfig() C10MDepthConfig {
+	c10mDepthConfigMu.RLock()
+	defer c10mDepthConfigMu.RUnlock()
+	return currentC10MDepthConfig
+}
+
+// ErrInsufficientDepth is returned by getBestC10MFullDepth when neither
+// side's order book can actually supply Requested units - e.g. a whale
+// order against a thin book, or a DepthQuantity cap that's tighter than
+// what's needed to fill it. PartialCost/Filled describe how far the walk
+// got before running out of book (or depth budget), so a caller like a
+// planner can decide whether to split the order across cycles instead of
+// just seeing a bare error.
+type ErrInsufficientDepth struct {
+	ItemID      string
+	Side        string // "Primary" (SellSummary) or "Secondary" (BuySummary)
+	Requested   float64
+	Filled      float64
+	PartialCost float64
+}
+
+func (e *ErrInsufficientDepth) Error() string {
+	return fmt.Sprintf("insufficient %s order-book depth for %s: requested %.2f, filled %.2f (partial cost %.2f)",
+		e.Side, e.ItemID, e.Requested, e.Filled, e.PartialCost)
+}
+
+// getBestC10MDepth is getBestC10M plus a depth-aware Secondary cost: instead
+// of approximating the insta-buy cost as quantity*buyP (the top order's
+// price), it walks productData.BuySummary via walkBook so an order large
+// enough to move the book is priced at what it would actually cost, and it
+// re-runs the Primary-vs-Secondary comparison against that true cost
+// instead of the top-of-book one. slippage reports how far the depth-walk
+// average price drifted from the top order, and is NaN whenever Secondary
+// wasn't priced via the book (no API data, or quantity <= 0).
+//
+// This is a separate entry point rather than a change to getBestC10M's own
+// signature/behavior, since getBestC10M already has many callers across the
+// tree relying on its exact return shape; callers that care about slippage
+// (or want depth-aware pricing for large quantities) can move to this one
+// individually.
+func getBestC10MDepth(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, slippage float64, err error) {
+
+	bestCost, bestMethod, associatedCost, rrValue, ifValue, err = getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, precision, nil)
+	slippage = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil || quantity <= 0 {
+		return
+	}
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	if !apiOk {
+		return
+	}
+
+	c10mSecDepth, filledQty, _, bookSlippage := walkBook(productData.BuySummary, quantity)
+	if filledQty < quantity-1e-9 {
+		c10mSecDepth = math.Inf(1) // book can't actually supply quantity
+	} else {
+		slippage = bookSlippage
+	}
+
+	validSecDepth := !math.IsInf(c10mSecDepth, 0) && !math.IsNaN(c10mSecDepth)
+	wasPrimary := bestMethod == "Primary"
+
+	switch {
+	case wasPrimary && validSecDepth && costLessOrEqual(c10mSecDepth, bestCost, precision):
+		// Depth-aware Secondary actually costs less than the chosen Primary path.
+		bestCost, bestMethod, associatedCost = c10mSecDepth, "Secondary", c10mSecDepth
+		rrValue, ifValue = math.NaN(), math.NaN()
+	case !wasPrimary && bestMethod == "Secondary":
+		// Secondary was already chosen off the top-of-book estimate; replace
+		// it with the true depth-walked cost (or Inf if the book can't fill it).
+		bestCost, associatedCost = c10mSecDepth, c10mSecDepth
+		if !validSecDepth {
+			bestMethod = "N/A"
+			associatedCost = math.NaN()
+		}
+	}
+	return
+}
+
+// getBestC10MFullDepth extends getBestC10MDepth to the Primary side too:
+// both SellSummary (Primary) and BuySummary (Secondary) are walked via
+// walkBookCapped, each capped at the current C10MDepthConfig.DepthQuantity,
+// and the resulting VWAPs - not the top-of-book tick - feed the IF/RR math
+// and the Secondary cost respectively. slippageBps reports how far the
+// winning method's VWAP drifted from its top-of-book price, in basis
+// points (0 if it filled entirely at the top order).
+//
+// If a side's book (after any depth cap) can't actually supply quantity,
+// that side is treated as unusable rather than silently priced off a
+// partial fill. If neither side can supply quantity, bestCost/bestMethod
+// come back Inf/"N/A" and err is an *ErrInsufficientDepth naming whichever
+// side got further, so a caller (e.g. a planner) can decide whether to
+// split the order across cycles instead of giving up outright.
+func getBestC10MFullDepth(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, slippageBps float64, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	dlog("Getting Best C10M (full depth) for %.2f x %s", quantity, itemIDNorm)
+
+	bestCost = math.Inf(1)
+	bestMethod = "N/A"
+	associatedCost = math.NaN()
+	rrValue = math.NaN()
+	ifValue = math.NaN()
+	slippageBps = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		return
+	}
+	if quantity <= 0 {
+		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		return 0, "N/A", 0, 0, 0, 0, err
+	}
+
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	if !apiOk {
+		err = fmt.Errorf("API data not found for %s", itemIDNorm)
+		return
+	}
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+
+	maxDepthCoins := getC10MDepthConfig().DepthQuantity
+	primCost, primFilled, primVWAP, primSlip := walkBookCapped(productData.SellSummary, quantity, maxDepthCoins)
+	secCost, secFilled, secVWAP, secSlip := walkBookCapped(productData.BuySummary, quantity, maxDepthCoins)
+
+	primValid := primFilled >= quantity-1e-9 && primVWAP > 0 && !math.IsNaN(primVWAP)
+	secValid := secFilled >= quantity-1e-9 && secVWAP > 0 && !math.IsNaN(secVWAP)
+
+	if !primValid && !secValid {
+		side, filled, walked := "Primary", primFilled, productData.SellSummary
+		if secFilled > primFilled {
+			side, filled, walked = "Secondary", secFilled, productData.BuySummary
+		}
+		partialCost, _, _, _ := walkBookCapped(walked, filled, maxDepthCoins)
+		err = &ErrInsufficientDepth{
+			ItemID:      itemIDNorm,
+			Side:        side,
+			Requested:   quantity,
+			Filled:      filled,
+			PartialCost: partialCost,
+		}
+		return
+	}
+
+	// A dummy counterpart price lets calculateC10MInternal's input
+	// validation pass even when one side's depth walk came up short; the
+	// side that's actually invalid is forced back to Inf below regardless
+	// of what calculateC10MInternal computed for it.
+	topSellP, topBuyP := math.NaN(), math.NaN()
+	if len(productData.SellSummary) > 0 {
+		topSellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		topBuyP = productData.BuySummary[0].PricePerUnit
+	}
+	effectiveSellP := primVWAP
+	if !primValid {
+		effectiveSellP = topSellP
+	}
+	effectiveBuyP := secVWAP
+	if !secValid {
+		effectiveBuyP = topBuyP
+	}
+	if effectiveSellP <= 0 || effectiveBuyP <= 0 || math.IsNaN(effectiveSellP) || math.IsNaN(effectiveBuyP) {
+		err = fmt.Errorf("invalid prices from API for %s (sP: %.2f, bP: %.2f)", itemIDNorm, effectiveSellP, effectiveBuyP)
+		return
+	}
+
+	if !metricsOk {
+		// Mirrors getBestC10M's no-metrics branch: only Secondary (instabuy)
+		// is possible without IF/RR inputs, now priced via the depth walk
+		// instead of quantity*topOfBook.
+		if !secValid {
+			err = fmt.Errorf("metrics not found for %s, and order-book depth (capped at %.2f) insufficient for Secondary", itemIDNorm, maxDepthCoins)
+			return
+		}
+		bestCost, bestMethod, associatedCost = secCost, "Secondary", secCost
+		if secVWAP > 0 {
+			slippageBps = secSlip * 10000
+		}
+		err = fmt.Errorf("metrics not found for %s, only depth-walked Secondary C10M available", itemIDNorm)
+		return
+	}
+
+	c10mPrim, c10mSec, calcIF, calcRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, quantity, effectiveSellP, effectiveBuyP, metricsData, nil)
+	if calcErr != nil {
+		err = calcErr
+	}
+	if !primValid {
+		c10mPrim = math.Inf(1)
+	}
+	if secValid {
+		c10mSec = secCost // the depth-walked total, not qty*effectiveBuyP
+	} else {
+		c10mSec = math.Inf(1)
+	}
+
+	validPrim := !math.IsInf(c10mPrim, 0) && !math.IsNaN(c10mPrim) && c10mPrim >= 0
+	validSec := !math.IsInf(c10mSec, 0) && !math.IsNaN(c10mSec) && c10mSec >= 0
+
+	switch {
+	case validPrim && validSec:
+		if costLessOrEqual(c10mPrim, c10mSec, precision) {
+			bestCost, bestMethod, associatedCost = c10mPrim, "Primary", primCost
+			rrValue, ifValue = calcRR, calcIF
+			if primVWAP > 0 {
+				slippageBps = primSlip * 10000
+			}
+		} else {
+			bestCost, bestMethod, associatedCost = c10mSec, "Secondary", secCost
+			if secVWAP > 0 {
+				slippageBps = secSlip * 10000
+			}
+		}
+	case validPrim:
+		bestCost, bestMethod, associatedCost = c10mPrim, "Primary", primCost
+		rrValue, ifValue = calcRR, calcIF
+		if primVWAP > 0 {
+			slippageBps = primSlip * 10000
+		}
+	case validSec:
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", secCost
+		if secVWAP > 0 {
+			slippageBps = secSlip * 10000
+		}
+	default:
+		if err == nil {
+			err = fmt.Errorf("failed to determine any valid depth-walked C10M for %s", itemIDNorm)
+		}
+	}
+
+	dlog("  [%s] Best C10M (full depth) Final Result: Cost=%.2f, Method=%s, AssocCost=%.2f, RR=%.2f, IF=%.4f, SlippageBps=%.2f, Err=%v",
+		itemIDNorm, bestCost, bestMethod, associatedCost, rrValue, ifValue, slippageBps, err)
+	return
+}
+
+// C10MConfig caps how many order-book levels getBestC10MLevelWalked is
+// willing to walk before treating the rest of quantity as filled at the
+// last walked level's price plus MaxSlippagePct - the same "layer price"
+// idea cross-exchange market makers use to budget for thin books instead
+// of reading an arbitrarily deep, arbitrarily stale one. DepthLevels of 0
+// means unlimited (walk every level in SellSummary/BuySummary).
+// MaxSlippagePct is a percentage, e.g. 2.0 means 2%.
+//
+// This is a separate knob from C10MDepthConfig: that one caps a walk by
+// coins committed and fails the walk outright if the book can't keep up;
+// this one caps by level count and prices the overflow instead of failing.
+type C10MConfig struct {
+	DepthLevels    int
+	MaxSlippagePct float64
+}
+
+var defaultC10MConfig = C10MConfig{DepthLevels: 0, MaxSlippagePct: 2.0}
+
+var (
+	c10mConfigMu      sync.RWMutex
+	currentC10MConfig = defaultC10MConfig
+)
+
+// SetC10MConfig installs cfg as the level-walk cap used by future
+// getBestC10MLevelWalked calls.
+func SetC10MConfig(cfg C10MConfig) {
+	c10mConfigMu.Lock()
+	currentC10MConfig = cfg
+	c10mConfigMu.Unlock()
+}
+
+func getC10MConfig() C10MConfig {
+	c10mConfigMu.RLock()
+	defer c10mConfigMu.RUnlock()
+	return currentC10MConfig
+}
+
+// walkBookLevels is walkBook's level-capped, always-fills sibling: it
+// walks at most maxLevels orders of summary (0 means unlimited) and, if
+// qty still isn't fully met once that cap (or the book itself) runs out,
+// prices the remainder at the last walked level's price plus
+// maxSlippagePct instead of reporting a partial/failed fill - the
+// overflow is assumed executable, just at a worse price, the way a market
+// maker budgets for walking past a thin exchange's visible depth. filledQty
+// therefore always equals qty once summary is non-empty and qty > 0.
+func walkBookLevels(summary []OrderSummary, qty float64, maxLevels int, maxSlippagePct float64) (totalCost, filledQty, avgPrice, slippage float64) {
+	if qty <= 0 || len(summary) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	bestPrice := summary[0].PricePerUnit
+	lastPrice := bestPrice
+	remaining := qty
+	levelsWalked := 0
+
+	for _, order := range summary {
+		if remaining <= 0 {
+			break
+		}
+		if maxLevels > 0 && levelsWalked >= maxLevels {
+			break
+		}
+		take := math.Min(remaining, float64(order.Amount))
+		if take <= 0 {
+			continue
+		}
+		totalCost += take * order.PricePerUnit
+		filledQty += take
+		remaining -= take
+		lastPrice = order.PricePerUnit
+		levelsWalked++
+	}
+
+	if remaining > 1e-9 {
+		overflowPrice := lastPrice * (1 + maxSlippagePct/100)
+		totalCost += remaining * overflowPrice
+		filledQty += remaining
+		dlog("    walkBookLevels: %.2f of %.2f filled within %d level(s), pricing remainder at %.4f (last level %.4f + %.2f%% slippage)",
+			filledQty-remaining, qty, levelsWalked, overflowPrice, lastPrice, maxSlippagePct)
+		remaining = 0
+	}
+
+	avgPrice = totalCost / filledQty
+	if bestPrice > 0 {
+		slippage = (avgPrice - bestPrice) / bestPrice
+	}
+	return totalCost, filledQty, avgPrice, slippage
+}
+
+// getBestC10MLevelWalked is getBestC10M plus a level-capped depth walk of
+// both sides of the book (see C10MConfig and walkBookLevels): instead of
+// pricing quantity off SellSummary[0]/BuySummary[0] alone, it walks up to
+// DepthLevels levels of each side, treating anything beyond that cap (or
+// beyond the book itself) as filled at the last level's price plus
+// MaxSlippagePct. naiveCost/naiveMethod are getBestC10M's unmodified
+// top-of-book result, returned alongside depthCost/depthMethod so a
+// caller can compare the two instead of only ever seeing one view.
+//
+// This is a separate entry point rather than a change to getBestC10M's or
+// calculateC10MInternal's own signatures, for the same reason
+// getBestC10MDepth and getBestC10MFullDepth are: both already have many
+// callers across the tree relying on their exact shapes.
+func getBestC10MLevelWalked(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+) (naiveCost float64, naiveMethod string, depthCost float64, depthMethod string, rrValue float64, ifValue float64, err error) {
+
+	naiveCost, naiveMethod, _, _, _, err = getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, precision, nil)
+
+	depthCost = math.Inf(1)
+	depthMethod = "N/A"
+	rrValue = math.NaN()
+	ifValue = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if err == nil {
+			err = ctxErr
+		}
+		return
+	}
+	if quantity <= 0 {
+		return
+	}
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	if !apiOk {
+		return
+	}
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+
+	cfg := getC10MConfig()
+	secCost, _, _, _ := walkBookLevels(productData.BuySummary, quantity, cfg.DepthLevels, cfg.MaxSlippagePct)
+	_, _, primAvgPrice, _ := walkBookLevels(productData.SellSummary, quantity, cfg.DepthLevels, cfg.MaxSlippagePct)
+
+	validSec := secCost > 0 && !math.IsInf(secCost, 0) && !math.IsNaN(secCost)
+	validPrimWalk := primAvgPrice > 0 && !math.IsInf(primAvgPrice, 0) && !math.IsNaN(primAvgPrice)
+
+	primCost := math.Inf(1)
+	var primIF, primRR float64 = math.NaN(), math.NaN()
+	if validPrimWalk && metricsOk {
+		// The depth-walked Sell-side VWAP feeds the existing IF/RR math; the
+		// top Buy-side price is only a dummy counterpart to satisfy
+		// calculateC10MInternal's input validation, the same trick
+		// getBestC10MFullDepth uses.
+		dummyBuyP := primAvgPrice
+		if len(productData.BuySummary) > 0 {
+			dummyBuyP = productData.BuySummary[0].PricePerUnit
+		}
+		c10mPrim, _, calcIF, calcRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, quantity, primAvgPrice, dummyBuyP, metricsData, nil)
+		if calcErr == nil {
+			primCost, primIF, primRR = c10mPrim, calcIF, calcRR
+		}
+	}
+	validPrim := !math.IsInf(primCost, 0) && !math.IsNaN(primCost)
+
+	switch {
+	case validPrim && validSec:
+		if costLessOrEqual(primCost, secCost, precision) {
+			depthCost, depthMethod, rrValue, ifValue = primCost, "Primary", primRR, primIF
+		} else {
+			depthCost, depthMethod = secCost, "Secondary"
+		}
+	case validPrim:
+		depthCost, depthMethod, rrValue, ifValue = primCost, "Primary", primRR, primIF
+	case validSec:
+		depthCost, depthMethod = secCost, "Secondary"
+	default:
+		if err == nil {
+			err = fmt.Errorf("failed to determine any valid level-walked C10M for %s", itemIDNorm)
+		}
+	}
+	return
+}
+
+// walkBookExtrapolated is walkBook's never-fails sibling: it walks
+// summary's entire ladder and, if that's not enough to fill qty,
+// extrapolates the remainder at the last walked level's price - no
+// further slippage premium on top, that's walkBookLevels' job - and
+// reports partialFill=true so a caller can distinguish a thin-book
+// extrapolation from a fill the ladder actually priced outright.
+// filledQty is always qty once summary is non-empty and qty > 0, unlike
+// walkBook/walkBookCapped, which report a partial filledQty and Inf cost
+// on exhaustion instead.
+func walkBookExtrapolated(summary []OrderSummary, qty float64) (totalCost, filledQty, avgPrice, slippage float64, partialFill bool) {
+	if qty <= 0 || len(summary) == 0 {
+		return 0, 0, 0, 0, false
+	}
+
+	bestPrice := summary[0].PricePerUnit
+	lastPrice := bestPrice
+	remaining := qty
+	for _, order := range summary {
+		if remaining <= 0 {
+			break
+		}
+		take := math.Min(remaining, float64(order.Amount))
+		if take <= 0 {
+			continue
+		}
+		totalCost += take * order.PricePerUnit
+		filledQty += take
+		remaining -= take
+		lastPrice = order.PricePerUnit
+	}
+
+	if remaining > 1e-9 {
+		totalCost += remaining * lastPrice
+		filledQty += remaining
+		partialFill = true
+		dlog("    walkBookExtrapolated: ladder exhausted, extrapolating remaining %.2f of %.2f at last level price %.4f", remaining, qty, lastPrice)
+	}
+
+	avgPrice = totalCost / filledQty
+	if bestPrice > 0 {
+		slippage = (avgPrice - bestPrice) / bestPrice
+	}
+	return totalCost, filledQty, avgPrice, slippage, partialFill
+}
+
+// calculateC10MDepth is calculateC10MInternal's depth-aware counterpart:
+// instead of pricing Primary/Secondary off SellSummary[0]/BuySummary[0]
+// alone, it walks each full ladder via walkBookExtrapolated. The Primary
+// side additionally extrapolates over rrValue relist cycles - since
+// calculateC10MInternal's own IF/RR math already models a Primary buy
+// order as needing rrValue rounds to fill qty, pricing the *entire* qty
+// off a single snapshot of today's SellSummary overstates how much of the
+// book any one round actually has to supply. This instead walks the
+// ladder for qty/rrValue per round and multiplies the result by rrValue,
+// assuming every round sees a similarly-shaped book - the best
+// approximation available from a single snapshot; RunC10MBacktest's
+// replay-based simulateC10MPrimaryFill is the ground-truth check on how
+// good that assumption actually is. A non-positive, NaN, or infinite
+// rrValue is treated as 1 round (the deltaRatio > 1 fast-fill case).
+func calculateC10MDepth(
+	prodID string,
+	qty float64,
+	sellSummary, buySummary []OrderSummary,
+	rrValue float64,
+) (c10mPrimaryDepth, c10mSecondaryDepth, primarySlippage, secondarySlippage float64, primaryPartialFill, secondaryPartialFill bool) {
+
+	rounds := rrValue
+	if rounds <= 0 || math.IsInf(rounds, 0) || math.IsNaN(rounds) {
+		rounds = 1
+	}
+
+	perRoundQty := qty / rounds
+	roundCost, _, _, roundSlip, roundPartial := walkBookExtrapolated(sellSummary, perRoundQty)
+	c10mPrimaryDepth = roundCost * rounds
+	primarySlippage = roundSlip
+	primaryPartialFill = roundPartial
+	dlog("  [Depth C10M] Primary for %.2f x %s over %.2f round(s) (%.2f/round): cost=%.2f slippage=%.4f partialFill=%v",
+		qty, prodID, rounds, perRoundQty, c10mPrimaryDepth, primarySlippage, primaryPartialFill)
+
+	c10mSecondaryDepth, _, _, secondarySlippage, secondaryPartialFill = walkBookExtrapolated(buySummary, qty)
+	dlog("  [Depth C10M] Secondary for %.2f x %s: cost=%.2f slippage=%.4f partialFill=%v",
+		qty, prodID, c10mSecondaryDepth, secondarySlippage, secondaryPartialFill)
+
+	return
+}
+
+// getBestC10MDepthExtrapolated is getBestC10M plus calculateC10MDepth: both
+// the Primary and Secondary costs it compares are walked across the full
+// SellSummary/BuySummary ladders instead of just the top tick, with the
+// Primary side additionally extrapolated over getBestC10M's own rrValue
+// relist-cycle count. slippage and partialFill describe whichever method
+// ends up chosen - partialFill true means the ladder ran out before qty
+// was met and the residual was extrapolated at the last walked level's
+// price rather than priced outright, the signal downstream reporting can
+// use to flag a thin-book item versus a deep-book one.
+//
+// This is a separate entry point rather than a change to getBestC10M's own
+// signature, for the same reason getBestC10MDepth is: getBestC10M already
+// has many callers relying on its exact return shape.
+func getBestC10MDepthExtrapolated(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, slippage float64, partialFill bool, err error) {
+
+	bestCost, bestMethod, associatedCost, rrValue, ifValue, err = getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, precision, nil)
+	slippage = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil || quantity <= 0 {
+		return
+	}
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	if !apiOk {
+		return
+	}
+
+	primDepth, secDepth, primSlip, secSlip, primPartial, secPartial :=
+		calculateC10MDepth(itemIDNorm, quantity, productData.SellSummary, productData.BuySummary, rrValue)
+
+	validPrimDepth := !math.IsInf(primDepth, 0) && !math.IsNaN(primDepth) && primDepth >= 0
+	validSecDepth := !math.IsInf(secDepth, 0) && !math.IsNaN(secDepth) && secDepth >= 0
+
+	switch {
+	case bestMethod == "Primary" && validPrimDepth:
+		bestCost, associatedCost = primDepth, primDepth
+		slippage, partialFill = primSlip, primPartial
+		if validSecDepth && costLessOrEqual(secDepth, primDepth, precision) {
+			bestCost, bestMethod, associatedCost = secDepth, "Secondary", secDepth
+			rrValue, ifValue = math.NaN(), math.NaN()
+			slippage, partialFill = secSlip, secPartial
+		}
+	case bestMethod == "Secondary" && validSecDepth:
+		bestCost, associatedCost = secDepth, secDepth
+		slippage, partialFill = secSlip, secPartial
+	}
+
+	dlog("  [%s] Best C10M (depth-extrapolated) Final Result: Cost=%.2f, Method=%s, AssocCost=%.2f, RR=%.2f, IF=%.4f, Slippage=%.4f, PartialFill=%v, Err=%v",
+		itemIDNorm, bestCost, bestMethod, associatedCost, rrValue, ifValue, slippage, partialFill, err)
+	return
+}
@@ -0,0 +1,245 @@
+// c10m_backtest.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// C10MBacktestSample pairs one Primary-path C10M prediction (from
+// calculateC10MInternal, using the snapshot's own metrics) against what
+// replaying the sell-side book forward through later recorded snapshots
+// actually paid to fill the same quantity - the same ground-truth-via-
+// replay idea RunFillTimeBacktest already uses for
+// calculateBuyOrderFillTime, applied to C10M's cost prediction instead of
+// its fill-time prediction. ResidualAbs/ResidualPct are
+// PredictedC10M-RealizedCost and that divided by RealizedCost, left zero
+// when the order never filled within the recorded snapshots.
+type C10MBacktestSample struct {
+	TimestampUnix int64   `json:"ts"`
+	ItemID        string  `json:"item_id"`
+	Quantity      float64 `json:"quantity"`
+	PredictedC10M float64 `json:"predicted_c10m"`
+	RealizedCost  float64 `json:"realized_cost"`
+	PredictedRR   float64 `json:"predicted_rr"`
+	ActualRounds  float64 `json:"actual_rounds"`
+	Filled        bool    `json:"filled"`
+	ResidualAbs   float64 `json:"residual_abs"`
+	ResidualPct   float64 `json:"residual_pct"`
+}
+
+// C10MBacktestReport summarizes how well calculateC10MInternal's
+// IF/RR/adjustment heuristic predicted realized Primary-path cost over a
+// window of recorded snapshots, in the same
+// mean/median/percentile shape BacktestReport uses for fill-time
+// predictions. MeanResidualPct/MedianResidualPct/P90/P99ResidualPct are
+// all signed percentages ((PredictedC10M-RealizedCost)/RealizedCost*100),
+// so a systematic over- or under-estimate shows up as a non-zero mean
+// instead of washing out against the absolute error. MeanRRErrorRounds is
+// PredictedRR-ActualRounds, averaged only over samples where both are
+// finite, for tuning the `extra = sellP*(qty*RR - IF*sumK)` heuristic's RR
+// term specifically.
+type C10MBacktestReport struct {
+	ItemID            string  `json:"item_id"`
+	SamplesEvaluated  int     `json:"samples_evaluated"`
+	MeanResidualPct   float64 `json:"mean_residual_pct"`
+	MedianResidualPct float64 `json:"median_residual_pct"`
+	P90ResidualPct    float64 `json:"p90_residual_pct"`
+	P99ResidualPct    float64 `json:"p99_residual_pct"`
+	MeanRRErrorRounds float64 `json:"mean_rr_error_rounds"`
+
+	Samples []C10MBacktestSample `json:"samples,omitempty"`
+}
+
+// simulateC10MPrimaryFill replays a hypothetical Primary (buy order) fill
+// of qty starting at snapshots[startIdx]: it first consumes that
+// snapshot's own SellSummary (an instant fill against resting sell orders
+// the moment the buy order crosses them), then for every subsequent round
+// - each advancing simulated time by roughly 3600/o_f seconds, mirroring
+// calculateC10MInternal's own "RR = relist cycles needed" assumption that
+// o_f is an hourly rate - walks forward to whichever recorded snapshot is
+// closest to that round's target timestamp to see what fresh SellSummary
+// supply had arrived, consuming from it the same way. actualRounds counts
+// how many such rounds were needed (1 if the very first book already had
+// enough); filled is false if qty was never fully consumed before the
+// recorded snapshots ran out.
+func simulateC10MPrimaryFill(snapshots []BacktestSnapshot, startIdx int, qty, o_f float64) (realizedCost, actualRounds float64, filled bool) {
+	if qty <= 0 {
+		return 0, 0, true
+	}
+	roundSeconds := 3600.0
+	if o_f > 0 {
+		roundSeconds = 3600.0 / o_f
+	}
+
+	startTs := snapshots[startIdx].TimestampUnix
+	remaining := qty
+	idx := startIdx
+
+	for round := 1.0; ; round++ {
+		cost, filledQty, _, _ := walkBookCapped(snapshots[idx].Product.SellSummary, remaining, 0)
+		if math.IsInf(cost, 0) {
+			// This round's book can't fully supply `remaining`; take whatever
+			// partial amount it actually has on offer instead, and carry the
+			// rest into the next round.
+			available := 0.0
+			for _, order := range snapshots[idx].Product.SellSummary {
+				available += float64(order.Amount)
+			}
+			if available > 0 {
+				cost, filledQty, _, _ = walkBookCapped(snapshots[idx].Product.SellSummary, available, 0)
+			} else {
+				cost, filledQty = 0, 0
+			}
+		}
+		realizedCost += cost
+		remaining -= filledQty
+		if remaining <= 1e-9 {
+			return realizedCost, round, true
+		}
+
+		targetTs := startTs + int64(round*roundSeconds)
+		next := idx
+		for next+1 < len(snapshots) && snapshots[next+1].TimestampUnix <= targetTs {
+			next++
+		}
+		if next == idx {
+			if next+1 >= len(snapshots) {
+				return realizedCost, round, false
+			}
+			// No recorded snapshot has reached this round's target time yet;
+			// use the very next one instead of stalling on a book we already
+			// know couldn't supply the rest.
+			next++
+		}
+		idx = next
+	}
+}
+
+// RunC10MBacktest replays calculateC10MInternal's Primary-path prediction
+// against historical snapshots recorded via RecordBacktestSnapshot (the
+// same on-disk format RunFillTimeBacktest reads): for each snapshot it
+// computes PredictedC10M/PredictedRR from that snapshot's own metrics,
+// simulates the actual fill via simulateC10MPrimaryFill, and records the
+// residual between the two - so a caller can tune or replace the
+// `extra = sellP*(qty*RR - IF*sumK)` heuristic in calculateC10MInternal
+// against ground truth instead of guessing.
+func RunC10MBacktest(dir, productID string, from, to time.Time, qty float64) (*C10MBacktestReport, error) {
+	snapshots, err := LoadBacktestSnapshots(dir, productID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) < 2 {
+		return nil, fmt.Errorf("not enough recorded snapshots for %s in range to backtest", productID)
+	}
+
+	itemIDNorm := BAZAAR_ID(productID)
+	report := &C10MBacktestReport{ItemID: itemIDNorm}
+	var residualsPct, rrErrors []float64
+
+	for i := 0; i+1 < len(snapshots); i++ {
+		snap := snapshots[i]
+		sellP, buyP := 0.0, 0.0
+		if len(snap.Product.SellSummary) > 0 {
+			sellP = snap.Product.SellSummary[0].PricePerUnit
+		}
+		if len(snap.Product.BuySummary) > 0 {
+			buyP = snap.Product.BuySummary[0].PricePerUnit
+		}
+		if sellP <= 0 || buyP <= 0 {
+			continue
+		}
+
+		c10mPrim, _, _, predictedRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, qty, sellP, buyP, snap.Metrics, nil)
+		if calcErr != nil || math.IsInf(c10mPrim, 0) || math.IsNaN(c10mPrim) {
+			continue
+		}
+
+		realizedCost, actualRounds, filled := simulateC10MPrimaryFill(snapshots, i, qty, snap.Metrics.OrderFrequency)
+		sample := C10MBacktestSample{
+			TimestampUnix: snap.TimestampUnix, ItemID: itemIDNorm, Quantity: qty,
+			PredictedC10M: c10mPrim, RealizedCost: realizedCost,
+			PredictedRR: predictedRR, ActualRounds: actualRounds, Filled: filled,
+		}
+		if filled && realizedCost > 0 {
+			sample.ResidualAbs = c10mPrim - realizedCost
+			sample.ResidualPct = sample.ResidualAbs / realizedCost
+			residualsPct = append(residualsPct, sample.ResidualPct)
+			if !math.IsInf(predictedRR, 0) && !math.IsNaN(predictedRR) {
+				rrErrors = append(rrErrors, predictedRR-actualRounds)
+			}
+			report.SamplesEvaluated++
+		}
+		report.Samples = append(report.Samples, sample)
+	}
+
+	if len(residualsPct) > 0 {
+		var sum float64
+		for _, r := range residualsPct {
+			sum += r
+		}
+		report.MeanResidualPct = (sum / float64(len(residualsPct))) * 100
+	}
+	if p, ok := percentile(residualsPct, 50); ok {
+		report.MedianResidualPct = p * 100
+	}
+	if p, ok := percentile(residualsPct, 90); ok {
+		report.P90ResidualPct = p * 100
+	}
+	if p, ok := percentile(residualsPct, 99); ok {
+		report.P99ResidualPct = p * 100
+	}
+	if len(rrErrors) > 0 {
+		var sum float64
+		for _, r := range rrErrors {
+			sum += r
+		}
+		report.MeanRRErrorRounds = sum / float64(len(rrErrors))
+	}
+	return report, nil
+}
+
+// c10mBacktestScenarioResult is one RunC10MBacktestCLI report entry,
+// mirroring backtestScenarioResult's config+report/error pairing.
+type c10mBacktestScenarioResult struct {
+	Config BacktestItemConfig  `json:"config"`
+	Report *C10MBacktestReport `json:"report,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// RunC10MBacktestCLI implements the `c10m-backtest` CLI subcommand: it
+// reuses BacktestScenarioConfig (backtest_config.go) so the same config
+// file format that drives RunFillTimeBacktest also drives RunC10MBacktest,
+// and writes every report as a JSON array to stdout.
+func RunC10MBacktestCLI(configPath string) error {
+	cfg, err := LoadBacktestScenarioConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	from, err := time.Parse(time.RFC3339, cfg.From)
+	if err != nil {
+		return fmt.Errorf("parsing backtest config 'from' (%q): %w", cfg.From, err)
+	}
+	to, err := time.Parse(time.RFC3339, cfg.To)
+	if err != nil {
+		return fmt.Errorf("parsing backtest config 'to' (%q): %w", cfg.To, err)
+	}
+
+	results := make([]c10mBacktestScenarioResult, 0, len(cfg.Items))
+	for _, item := range cfg.Items {
+		qty := item.Quantity
+		if qty <= 0 {
+			qty = 1
+		}
+		report, backtestErr := RunC10MBacktest(item.SnapshotsDir, item.Item, from, to, qty)
+		results = append(results, c10mBacktestScenarioResult{Config: item, Report: report, Error: errString(backtestErr)})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
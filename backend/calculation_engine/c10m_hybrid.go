@@ -0,0 +1,160 @@
+// c10m_hybrid.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// getBestC10MHybrid is getBestC10M plus a third "Hybrid" method: when the
+// Primary path's own rrValue exceeds maxWaitRounds - patiently waiting out
+// every relist round would blow past the caller's latency budget - it
+// considers instabuying a fraction f of quantity up front and letting the
+// remaining (1-f) fill via the ordinary Primary refill-round mechanism,
+// solving for the minimum f that brings the remainder's own rrValue back
+// within maxWaitRounds: (1-f)*quantity/ifValue <= maxWaitRounds, i.e.
+// f >= 1 - maxWaitRounds*ifValue/quantity, clamped to [0,1]. This mirrors an
+// immediate-or-cancel order where part of the size is taken aggressively and
+// the remainder rests, giving callers a bounded-latency option between
+// Primary's unbounded patience and Secondary's full instabuy premium.
+//
+// hybridFraction reports the f actually used, 0 whenever Hybrid wasn't the
+// winning method - including when rrValue was already within maxWaitRounds,
+// so Hybrid wasn't attempted at all. This is a separate entry point rather
+// than a change to getBestC10M's own signature, for the same reason
+// getBestC10MDepth is: getBestC10M already has many callers relying on its
+// exact return shape.
+func getBestC10MHybrid(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	maxWaitRounds float64,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, hybridFraction float64, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	dlog("Getting Best C10M (hybrid, maxWaitRounds=%.2f) for %.2f x %s", maxWaitRounds, quantity, itemIDNorm)
+
+	bestCost = math.Inf(1)
+	bestMethod = "N/A"
+	associatedCost = math.NaN()
+	rrValue = math.NaN()
+	ifValue = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		return
+	}
+	if quantity <= 0 {
+		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		return 0, "N/A", 0, 0, 0, 0, err
+	}
+
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+	if !apiOk {
+		err = fmt.Errorf("API data not found for %s", itemIDNorm)
+		return
+	}
+
+	var sellP, buyP float64 = math.NaN(), math.NaN()
+	if len(productData.SellSummary) > 0 {
+		sellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	if sellP <= 0 || buyP <= 0 || math.IsNaN(sellP) || math.IsNaN(buyP) || math.IsInf(sellP, 0) || math.IsInf(buyP, 0) {
+		err = fmt.Errorf("invalid prices from API for %s (sP: %.2f, bP: %.2f)", itemIDNorm, sellP, buyP)
+		return
+	}
+
+	c10mSec := quantity * buyP
+	if math.IsNaN(c10mSec) || c10mSec < 0 || math.IsInf(c10mSec, 0) {
+		c10mSec = math.Inf(1)
+	}
+
+	if !metricsOk {
+		dlog("  [%s] Metrics data not found. Hybrid/Primary skipped. Evaluating Secondary C10M only.", itemIDNorm)
+		if math.IsInf(c10mSec, 0) {
+			err = fmt.Errorf("metrics missing and secondary C10M failed for %s", itemIDNorm)
+			return
+		}
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+		err = fmt.Errorf("metrics not found for %s, only Secondary C10M available", itemIDNorm)
+		return
+	}
+
+	c10mPrim, _, calcIF, calcRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, quantity, sellP, buyP, metricsData, nil)
+	if calcErr != nil {
+		err = calcErr
+	}
+
+	// Only attempt Hybrid once Primary's own wait (calcRR relist rounds)
+	// overruns maxWaitRounds - if Primary already fits the caller's budget,
+	// there's nothing for instabuying part of the order to improve on.
+	c10mHybrid := math.Inf(1)
+	var f float64
+	if calcRR > maxWaitRounds && maxWaitRounds > 0 && calcIF > 0 && !math.IsInf(calcIF, 0) {
+		f = 1 - (maxWaitRounds*calcIF)/quantity
+		if f < 0 {
+			f = 0
+		} else if f > 1 {
+			f = 1
+		}
+		if f > 0 {
+			remainderQty := (1 - f) * quantity
+			instabuyPart := f * quantity * buyP
+			remainderCost := 0.0
+			if remainderQty > 1e-9 {
+				remPrim, _, _, _, _, _, remErr := calculateC10MInternal(itemIDNorm, remainderQty, sellP, buyP, metricsData, nil)
+				if remErr != nil || math.IsInf(remPrim, 0) || math.IsNaN(remPrim) || remPrim < 0 {
+					f = 0 // Remainder couldn't be priced - Hybrid isn't viable this round.
+				} else {
+					remainderCost = remPrim
+				}
+			}
+			if f > 0 {
+				c10mHybrid = instabuyPart + remainderCost
+				dlog("  [%s] Hybrid candidate: f=%.4f, InstabuyPart=%.2f, RemainderPrimary=%.2f, Total=%.2f",
+					itemIDNorm, f, instabuyPart, remainderCost, c10mHybrid)
+			}
+		}
+	}
+
+	validPrim := !math.IsInf(c10mPrim, 0) && !math.IsNaN(c10mPrim) && c10mPrim >= 0
+	validSec := !math.IsInf(c10mSec, 0) && !math.IsNaN(c10mSec) && c10mSec >= 0
+	validHybrid := f > 0 && !math.IsInf(c10mHybrid, 0) && !math.IsNaN(c10mHybrid) && c10mHybrid >= 0
+
+	if validPrim {
+		bestCost, bestMethod, associatedCost, rrValue, ifValue = c10mPrim, "Primary", quantity*sellP, calcRR, calcIF
+	}
+	if validSec && (!validPrim || !costLessOrEqual(c10mPrim, c10mSec, precision)) {
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+		rrValue, ifValue = math.NaN(), math.NaN()
+	}
+	if validHybrid && c10mHybrid < bestCost {
+		bestCost, bestMethod, associatedCost = c10mHybrid, "Hybrid", c10mHybrid
+		rrValue, ifValue = math.NaN(), math.NaN()
+		hybridFraction = f
+	}
+
+	if bestMethod == "N/A" {
+		associatedCost = math.NaN()
+		rrValue = math.NaN()
+		ifValue = math.NaN()
+		if err == nil {
+			err = fmt.Errorf("failed to determine any valid C10M for %s (Primary/Secondary/Hybrid all invalid)", itemIDNorm)
+		}
+	}
+	if bestMethod != "Hybrid" {
+		hybridFraction = 0
+	}
+
+	dlog("  [%s] Best C10M (hybrid) Final Result: Cost=%.2f, Method=%s, AssocCost=%.2f, RR=%.2f, IF=%.4f, HybridFraction=%.4f, Err=%v",
+		itemIDNorm, bestCost, bestMethod, associatedCost, rrValue, ifValue, hybridFraction, err)
+	return
+}
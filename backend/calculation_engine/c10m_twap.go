@@ -0,0 +1,150 @@
+// c10m_twap.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// twapQueueLossEscalationPct scales getBestC10MTWAP's per-slice cost
+// penalty for sliceMissedProbability (the chance a competing buy order
+// beats ours to a slice's expected supply and our order keeps sitting in
+// the book, losing queue position): a slice with missed probability 1.0
+// gets its expected cost marked up by this much, mirroring
+// driftPenaltyMultiplier's maxDriftPenaltyPct - a bounded, order-of-
+// magnitude-reasonable premium rather than a formally derived one.
+const twapQueueLossEscalationPct = 0.25
+
+// getBestC10MTWAP models the Primary path as a time-sliced execution under
+// an explicit deadline instead of calculateC10MInternal's static "RR refill
+// rounds" count: timeBudgetSeconds is divided into slices of length
+// 1/sellFrequency, and each slice's expected fill is
+// min(sellSize, quantityRemaining, competingDemand), where
+// competingDemand = orderSize*orderFrequency*sliceLength approximates how
+// much of that slice's supply other buy orders already ahead of ours are
+// expected to absorb. Each slice's cost is accumulated at sellP, marked up
+// by sliceMissedProbability = demandRate/(demandRate+supplyRate) scaled by
+// twapQueueLossEscalationPct - the chance our order loses queue position
+// that slice. expectedCost/expectedCompletionSeconds/expectedFillRatio
+// describe what the orderbook side of the trade is expected to achieve
+// within timeBudgetSeconds; fallbackInstabuyCost is what instabuying
+// whatever quantity remains unfilled at the deadline would cost, so a
+// caller can weigh cheap-but-slow order accumulation against paying the
+// instabuy spread for a guaranteed deadline - a trade-off
+// calculateC10MInternal's RR-based model has no way to express.
+//
+// This is a separate entry point rather than a change to getBestC10M's own
+// signature, for the same reason getBestC10MDepth is: getBestC10M already
+// has many callers relying on its exact return shape.
+func getBestC10MTWAP(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	timeBudgetSeconds float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+) (expectedCost float64, expectedCompletionSeconds float64, expectedFillRatio float64, fallbackInstabuyCost float64, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	fallbackInstabuyCost = math.Inf(1)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		return
+	}
+	if quantity <= 0 {
+		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		return
+	}
+	if timeBudgetSeconds <= 0 {
+		err = fmt.Errorf("timeBudgetSeconds must be positive (got %.2f for %s)", timeBudgetSeconds, itemIDNorm)
+		return
+	}
+
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	if !apiOk {
+		err = fmt.Errorf("API data not found for %s", itemIDNorm)
+		return
+	}
+	var sellP, buyP float64 = math.NaN(), math.NaN()
+	if len(productData.SellSummary) > 0 {
+		sellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	if sellP <= 0 || math.IsNaN(sellP) || math.IsInf(sellP, 0) {
+		err = fmt.Errorf("invalid sell price from API for %s (sP: %.2f)", itemIDNorm, sellP)
+		return
+	}
+	if buyP > 0 && !math.IsNaN(buyP) && !math.IsInf(buyP, 0) {
+		fallbackInstabuyCost = quantity * buyP
+	}
+
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+	if !metricsOk {
+		err = fmt.Errorf("metrics not found for %s, cannot schedule TWAP slices", itemIDNorm)
+		return
+	}
+
+	s_s := math.Max(0, metricsData.SellSize)
+	s_f := math.Max(0, metricsData.SellFrequency)
+	o_s := math.Max(0, metricsData.OrderSize)
+	o_f := math.Max(0, metricsData.OrderFrequency)
+
+	if s_f <= 0 {
+		// No sell orders expected within any finite window - the scheduler
+		// has nothing to walk, so the whole quantity falls through to the
+		// instabuy fallback.
+		expectedFillRatio = 0
+		dlog("  [%s] TWAP: SellFrequency <= 0, no expected supply within the time budget.", itemIDNorm)
+		return
+	}
+
+	sliceLength := 1.0 / s_f
+	numSlices := int(math.Floor(timeBudgetSeconds / sliceLength))
+	if numSlices < 1 {
+		numSlices = 1
+	}
+
+	supplyRate := s_s * s_f
+	demandRate := o_s * o_f
+	sliceMissedProb := 0.0
+	if demandRate+supplyRate > 0 {
+		sliceMissedProb = demandRate / (demandRate + supplyRate)
+	}
+	competingDemand := o_s * o_f * sliceLength
+
+	dlog("  [%s] TWAP scheduling: qty=%.2f budget=%.2fs sliceLength=%.4fs numSlices=%d sliceMissedProb=%.4f competingDemand=%.4f",
+		itemIDNorm, quantity, timeBudgetSeconds, sliceLength, numSlices, sliceMissedProb, competingDemand)
+
+	remaining := quantity
+	filledSlices := 0
+	for i := 0; i < numSlices && remaining > 1e-9; i++ {
+		expectedFill := math.Min(s_s, math.Min(remaining, competingDemand))
+		expectedFill = math.Max(0, expectedFill)
+		sliceCost := expectedFill * sellP * (1 + sliceMissedProb*twapQueueLossEscalationPct)
+		expectedCost += sliceCost
+		remaining -= expectedFill
+		filledSlices++
+		if expectedFill <= 0 {
+			// No expected progress this slice (competingDemand or sellSize
+			// is 0) - every later slice looks identical, so stop early
+			// instead of looping numSlices times for nothing.
+			break
+		}
+	}
+
+	expectedCompletionSeconds = math.Min(float64(filledSlices)*sliceLength, timeBudgetSeconds)
+	expectedFillRatio = (quantity - remaining) / quantity
+	if remaining > 1e-9 && buyP > 0 && !math.IsNaN(buyP) && !math.IsInf(buyP, 0) {
+		fallbackInstabuyCost = remaining * buyP
+	} else if remaining <= 1e-9 {
+		fallbackInstabuyCost = 0
+	}
+
+	dlog("  [%s] TWAP Result: ExpectedCost=%.2f, ExpectedCompletion=%.2fs, FillRatio=%.4f, FallbackInstabuyCost=%.2f",
+		itemIDNorm, expectedCost, expectedCompletionSeconds, expectedFillRatio, fallbackInstabuyCost)
+	return
+}
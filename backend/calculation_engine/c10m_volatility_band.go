@@ -0,0 +1,156 @@
+// c10m_volatility_band.go
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// C10MVolatilityBandConfig controls how wide a confidence interval
+// getBestC10MVolatilityBand computes around its point-estimate cost. K is
+// how many ATR's sellP/buyP are perturbed by in each direction; 1.5 is a
+// reasonable middle ground between a tight 1-ATR band and a conservative
+// 2-ATR one, the same kind of round default C10MVolatilityConfig.K uses.
+type C10MVolatilityBandConfig struct {
+	K float64
+}
+
+var defaultC10MVolatilityBandConfig = C10MVolatilityBandConfig{K: 1.5}
+
+var (
+	c10mVolatilityBandConfigMu      sync.RWMutex
+	currentC10MVolatilityBandConfig = defaultC10MVolatilityBandConfig
+)
+
+// SetC10MVolatilityBandConfig installs cfg as the band width used by future
+// getBestC10MVolatilityBand calls.
+func SetC10MVolatilityBandConfig(cfg C10MVolatilityBandConfig) {
+	c10mVolatilityBandConfigMu.Lock()
+	currentC10MVolatilityBandConfig = cfg
+	c10mVolatilityBandConfigMu.Unlock()
+}
+
+func getC10MVolatilityBandConfig() C10MVolatilityBandConfig {
+	c10mVolatilityBandConfigMu.RLock()
+	defer c10mVolatilityBandConfigMu.RUnlock()
+	return currentC10MVolatilityBandConfig
+}
+
+// computeATR averages the true range between consecutive InstasellPrice
+// samples in history (oldest-first, the order PriceHistoryStore.History
+// already returns them in): TR_i = max(prev,cur) - min(prev,cur). This is
+// a distinct, coarser-grained measure from ProductMetrics.PriceStats'
+// mean/stddev band (metrics.go), which HypixelTriangularModel already uses
+// for its own volatility penalty - that one is derived from
+// SerialMetricsStore's finer live-poll window, this one from
+// PriceHistoryStore's 5-minute AveragedMetrics cadence. ok is false when
+// fewer than two usable samples are available.
+func computeATR(history []PriceSnapshot) (atr float64, ok bool) {
+	var sum float64
+	count := 0
+	for i := 1; i < len(history); i++ {
+		prev := history[i-1].InstasellPrice
+		cur := history[i].InstasellPrice
+		if prev <= 0 || cur <= 0 {
+			continue
+		}
+		sum += math.Max(prev, cur) - math.Min(prev, cur)
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// getBestC10MVolatilityBand is getBestC10M plus a confidence interval
+// around bestCost: history (typically from PriceHistoryStore.History) feeds
+// computeATR, and calculateC10MInternal is rerun once with sellP/buyP each
+// shifted down by k*ATR and once shifted up by k*ATR (k from
+// C10MVolatilityBandConfig), reporting whichever of that rerun's Primary or
+// Secondary cost matches bestMethod as costLow/costHigh. volatilityScore is
+// ATR normalized by the current top-of-book sellP, so callers can rank
+// items by cost-risk as well as expected cost - and, since costHigh is
+// reported even when the point estimate favors Primary, refuse to commit to
+// it when costHigh exceeds what Secondary would cost today.
+//
+// costLow/costHigh/volatilityScore come back NaN if there isn't enough
+// history to compute an ATR, or if bestMethod is "N/A" - there's no cost to
+// bound a confidence interval around in that case. This is a separate
+// entry point rather than a change to getBestC10M's own signature, for the
+// same reason getBestC10MDepth is: getBestC10M already has many callers
+// relying on its exact return shape.
+func getBestC10MVolatilityBand(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	history []PriceSnapshot,
+) (bestCost float64, bestMethod string, costLow float64, costHigh float64, volatilityScore float64, err error) {
+
+	bestCost, bestMethod, _, _, _, err = getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, precision, nil)
+	costLow, costHigh, volatilityScore = math.NaN(), math.NaN(), math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil || quantity <= 0 || bestMethod == "N/A" {
+		return
+	}
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+	if !apiOk || !metricsOk {
+		return
+	}
+
+	var sellP, buyP float64
+	if len(productData.SellSummary) > 0 {
+		sellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	if sellP <= 0 || buyP <= 0 || math.IsNaN(sellP) || math.IsNaN(buyP) {
+		return
+	}
+
+	atr, ok := computeATR(history)
+	if !ok {
+		return
+	}
+	volatilityScore = atr / sellP
+	if atr <= 0 {
+		costLow, costHigh = bestCost, bestCost
+		return
+	}
+
+	k := getC10MVolatilityBandConfig().K
+	lowSellP := math.Max(sellP-k*atr, 0.01)
+	lowBuyP := math.Max(buyP-k*atr, 0.01)
+	highSellP := sellP + k*atr
+	highBuyP := buyP + k*atr
+
+	lowPrim, lowSec, _, _, _, _, lowErr := calculateC10MInternal(itemIDNorm, quantity, lowSellP, lowBuyP, metricsData, nil)
+	if lowErr == nil {
+		if bestMethod == "Primary" {
+			costLow = lowPrim
+		} else {
+			costLow = lowSec
+		}
+	}
+
+	highPrim, highSec, _, _, _, _, highErr := calculateC10MInternal(itemIDNorm, quantity, highSellP, highBuyP, metricsData, nil)
+	if highErr == nil {
+		if bestMethod == "Primary" {
+			costHigh = highPrim
+		} else {
+			costHigh = highSec
+		}
+	}
+
+	dlog("  [%s] C10M Volatility Band: Best=%.2f (%s), Low=%.2f, High=%.2f, VolScore=%.4f, ATR=%.4f",
+		itemIDNorm, bestCost, bestMethod, costLow, costHigh, volatilityScore, atr)
+	return
+}
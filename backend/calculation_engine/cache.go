@@ -0,0 +1,249 @@
+// cache.go
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// BazaarCache abstracts storage/refresh of the latest HypixelAPIResponse so that
+// fetchBazaarData/getApiResponse don't have to know whether data lives purely
+// in memory, is persisted to disk for cold starts, or is served out of an
+// external store (Redis/BigCache-style). Implementations must be safe for
+// concurrent use.
+type BazaarCache interface {
+	// Get returns the cached response (if any) and its age. ok is false if
+	// nothing has ever been cached.
+	Get() (resp *HypixelAPIResponse, age time.Duration, ok bool)
+	// Set stores a freshly fetched response as the new cache contents.
+	Set(resp *HypixelAPIResponse)
+	// Refresh runs fetchFn under stampede protection: concurrent callers
+	// during an in-flight refresh block on the same result instead of each
+	// issuing their own HTTP request.
+	Refresh(fetchFn func() (*HypixelAPIResponse, error)) (*HypixelAPIResponse, error)
+}
+
+// refreshCoalescer implements BazaarCache.Refresh's stampede protection:
+// concurrent callers during an in-flight refresh block on the same result
+// instead of each issuing their own fetch. InMemoryBazaarCache and
+// ExternalStoreBazaarCache both embed one rather than each reimplementing
+// the same singleflight bookkeeping.
+type refreshCoalescer struct {
+	mu       sync.Mutex
+	inFlight bool
+	done     chan struct{}
+	resp     *HypixelAPIResponse
+	err      error
+}
+
+// coalesce runs fetchFn under stampede protection, calling onSuccess (if
+// non-nil) with the freshly fetched response before releasing waiters -
+// this is where a cache's Set belongs, so every waiter observes it already
+// stored by the time coalesce returns to them.
+func (rc *refreshCoalescer) coalesce(fetchFn func() (*HypixelAPIResponse, error), onSuccess func(*HypixelAPIResponse)) (*HypixelAPIResponse, error) {
+	rc.mu.Lock()
+	if rc.inFlight {
+		done := rc.done
+		rc.mu.Unlock()
+		<-done
+		rc.mu.Lock()
+		resp, err := rc.resp, rc.err
+		rc.mu.Unlock()
+		return resp, err
+	}
+
+	rc.inFlight = true
+	done := make(chan struct{})
+	rc.done = done
+	rc.mu.Unlock()
+
+	resp, err := fetchFn()
+	if err == nil && resp != nil && onSuccess != nil {
+		onSuccess(resp)
+	}
+
+	rc.mu.Lock()
+	rc.resp, rc.err = resp, err
+	rc.inFlight = false
+	rc.mu.Unlock()
+	close(done)
+
+	return resp, err
+}
+
+// InMemoryBazaarCache is the default BazaarCache: a single mutex-guarded slot
+// with a configurable TTL and singleflight-style refresh coalescing.
+type InMemoryBazaarCache struct {
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	resp     *HypixelAPIResponse
+	cachedAt time.Time
+
+	refresh refreshCoalescer
+}
+
+// NewInMemoryBazaarCache creates a cache holding ttl. Get does not consult
+// ttl: it always reports ok (with the entry's age) once something has been
+// Set, and callers judge freshness from that age themselves.
+func NewInMemoryBazaarCache(ttl time.Duration) *InMemoryBazaarCache {
+	return &InMemoryBazaarCache{ttl: ttl}
+}
+
+func (c *InMemoryBazaarCache) Get() (*HypixelAPIResponse, time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.resp == nil {
+		return nil, 0, false
+	}
+	return c.resp, time.Since(c.cachedAt), true
+}
+
+func (c *InMemoryBazaarCache) Set(resp *HypixelAPIResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resp = resp
+	c.cachedAt = time.Now()
+}
+
+// Refresh coalesces concurrent refresh attempts: only the first caller
+// actually invokes fetchFn, later callers that arrive while it's running wait
+// on the same result.
+func (c *InMemoryBazaarCache) Refresh(fetchFn func() (*HypixelAPIResponse, error)) (*HypixelAPIResponse, error) {
+	return c.refresh.coalesce(fetchFn, c.Set)
+}
+
+// FileBackedBazaarCache wraps another BazaarCache (typically an
+// InMemoryBazaarCache) and persists the last good snapshot to disk, so a cold
+// start can serve stale-but-usable data before the first live fetch succeeds.
+type FileBackedBazaarCache struct {
+	BazaarCache
+	path string
+}
+
+// NewFileBackedBazaarCache loads path (if present) into inner's in-memory
+// slot and returns a cache that keeps path updated on every Set.
+func NewFileBackedBazaarCache(inner BazaarCache, path string) *FileBackedBazaarCache {
+	fc := &FileBackedBazaarCache{BazaarCache: inner, path: path}
+	if data, err := os.ReadFile(path); err == nil {
+		var resp HypixelAPIResponse
+		if err := json.Unmarshal(data, &resp); err == nil {
+			inner.Set(&resp)
+			dlog("FileBackedBazaarCache: loaded snapshot from %s (LastUpdated=%d)", path, resp.LastUpdated)
+		} else {
+			dlog("FileBackedBazaarCache: failed to parse snapshot %s: %v", path, err)
+		}
+	}
+	return fc
+}
+
+func (fc *FileBackedBazaarCache) Set(resp *HypixelAPIResponse) {
+	fc.BazaarCache.Set(resp)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		dlog("FileBackedBazaarCache: failed to marshal snapshot for %s: %v", fc.path, err)
+		return
+	}
+	if err := os.WriteFile(fc.path, data, 0o644); err != nil {
+		dlog("FileBackedBazaarCache: failed to persist snapshot to %s: %v", fc.path, err)
+	}
+}
+
+// KVStore is the minimal interface an external cache backend (Redis,
+// BigCache, memcached, ...) must satisfy to back ExternalStoreBazaarCache.
+// It deliberately mirrors the get/set shape those clients already expose, so
+// adapting one is a thin wrapper rather than a new dependency of this
+// package - this codebase has no external dependencies (see
+// normalization.go's NormalizationOverrideFile comment for the same
+// constraint elsewhere), so a concrete Redis/BigCache client isn't imported
+// here.
+type KVStore interface {
+	Get(key string) (value []byte, ok bool)
+	Set(key string, value []byte)
+}
+
+// externalCacheEntry is the JSON envelope ExternalStoreBazaarCache stores
+// under its key, so CachedAt (needed for Get's age return) survives the
+// round trip through a byte-slice-only KVStore.
+type externalCacheEntry struct {
+	Resp     *HypixelAPIResponse `json:"resp"`
+	CachedAt time.Time           `json:"cached_at"`
+}
+
+// ExternalStoreBazaarCache is a BazaarCache backed by a KVStore, for
+// deployments that want the latest snapshot shared across processes (Redis)
+// or kept in a bounded off-heap cache (BigCache) instead of a single
+// in-process slot. Refresh coalescing still happens in-process via the same
+// refreshCoalescer InMemoryBazaarCache uses - a KVStore has no notion of
+// "refresh in flight", so stampede protection only covers this process, not
+// every process sharing the store.
+type ExternalStoreBazaarCache struct {
+	store KVStore
+	key   string
+
+	refresh refreshCoalescer
+}
+
+// NewExternalStoreBazaarCache wraps store, using key to store/retrieve the
+// latest snapshot.
+func NewExternalStoreBazaarCache(store KVStore, key string) *ExternalStoreBazaarCache {
+	return &ExternalStoreBazaarCache{store: store, key: key}
+}
+
+func (c *ExternalStoreBazaarCache) Get() (*HypixelAPIResponse, time.Duration, bool) {
+	data, ok := c.store.Get(c.key)
+	if !ok {
+		return nil, 0, false
+	}
+	var entry externalCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Resp == nil {
+		dlog("ExternalStoreBazaarCache: failed to parse entry for key %s: %v", c.key, err)
+		return nil, 0, false
+	}
+	return entry.Resp, time.Since(entry.CachedAt), true
+}
+
+func (c *ExternalStoreBazaarCache) Set(resp *HypixelAPIResponse) {
+	data, err := json.Marshal(externalCacheEntry{Resp: resp, CachedAt: time.Now()})
+	if err != nil {
+		dlog("ExternalStoreBazaarCache: failed to marshal entry for key %s: %v", c.key, err)
+		return
+	}
+	c.store.Set(c.key, data)
+}
+
+// Refresh coalesces concurrent refresh attempts within this process, the
+// same as InMemoryBazaarCache.Refresh.
+func (c *ExternalStoreBazaarCache) Refresh(fetchFn func() (*HypixelAPIResponse, error)) (*HypixelAPIResponse, error) {
+	return c.refresh.coalesce(fetchFn, c.Set)
+}
+
+// bazaarCacheOnce / defaultBazaarCache let the rest of the package keep using
+// a package-level cache without every caller threading one through, while
+// still allowing callers (tests, alternate mains) to install their own
+// BazaarCache via SetBazaarCache before first use.
+var (
+	defaultBazaarCache     BazaarCache
+	defaultBazaarCacheOnce sync.Once
+	defaultBazaarCacheMu   sync.Mutex
+)
+
+// SetBazaarCache installs cache as the package-wide BazaarCache. Must be
+// called before the first getApiResponse/fetchBazaarData call to take effect
+// in place of the default in-memory cache.
+func SetBazaarCache(cache BazaarCache) {
+	defaultBazaarCacheMu.Lock()
+	defer defaultBazaarCacheMu.Unlock()
+	defaultBazaarCache = cache
+}
+
+func getBazaarCache() BazaarCache {
+	defaultBazaarCacheMu.Lock()
+	defer defaultBazaarCacheMu.Unlock()
+	if defaultBazaarCache == nil {
+		defaultBazaarCache = NewInMemoryBazaarCache(30 * time.Second)
+	}
+	return defaultBazaarCache
+}
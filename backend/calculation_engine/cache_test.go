@@ -0,0 +1,257 @@
+// cache_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryBazaarCacheGetSet(t *testing.T) {
+	c := NewInMemoryBazaarCache(time.Minute)
+
+	if _, _, ok := c.Get(); ok {
+		t.Fatal("Get on empty cache returned ok=true")
+	}
+
+	resp := &HypixelAPIResponse{LastUpdated: 42}
+	c.Set(resp)
+
+	got, age, ok := c.Get()
+	if !ok {
+		t.Fatal("Get after Set returned ok=false")
+	}
+	if got.LastUpdated != 42 {
+		t.Errorf("LastUpdated = %d, want 42", got.LastUpdated)
+	}
+	if age < 0 {
+		t.Errorf("age = %v, want >= 0", age)
+	}
+}
+
+func TestInMemoryBazaarCacheRefreshCoalesces(t *testing.T) {
+	c := NewInMemoryBazaarCache(time.Minute)
+
+	var calls int32
+	var mu sync.Mutex
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var startOnce sync.Once
+
+	fetch := func() (*HypixelAPIResponse, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		startOnce.Do(func() { close(started) })
+		<-release
+		return &HypixelAPIResponse{LastUpdated: 1}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*HypixelAPIResponse, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := c.Refresh(fetch)
+			if err != nil {
+				t.Errorf("Refresh() error = %v", err)
+			}
+			results[i] = resp
+		}(i)
+	}
+
+	// Wait for the winning goroutine to actually be inside fetchFn, then give
+	// the other four time to arrive at coalesce and queue on its done
+	// channel, before letting fetchFn return - otherwise the winner can
+	// finish (and clear inFlight) before the rest even start.
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	got := calls
+	mu.Unlock()
+	if got != 1 {
+		t.Errorf("fetchFn called %d times, want 1", got)
+	}
+	for i, resp := range results {
+		if resp == nil || resp.LastUpdated != 1 {
+			t.Errorf("results[%d] = %v, want LastUpdated=1", i, resp)
+		}
+	}
+}
+
+func TestInMemoryBazaarCacheRefreshError(t *testing.T) {
+	c := NewInMemoryBazaarCache(time.Minute)
+	wantErr := errors.New("fetch failed")
+
+	resp, err := c.Refresh(func() (*HypixelAPIResponse, error) {
+		return nil, wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Refresh() error = %v, want %v", err, wantErr)
+	}
+	if resp != nil {
+		t.Errorf("Refresh() resp = %v, want nil", resp)
+	}
+	if _, _, ok := c.Get(); ok {
+		t.Error("Get() ok=true after a failed Refresh that never Set anything")
+	}
+}
+
+// fakeKVStore is an in-process stand-in for a Redis/BigCache-backed
+// KVStore, so ExternalStoreBazaarCache can be exercised without a real
+// external store.
+type fakeKVStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newFakeKVStore() *fakeKVStore {
+	return &fakeKVStore{data: make(map[string][]byte)}
+}
+
+func (s *fakeKVStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *fakeKVStore) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+}
+
+func TestExternalStoreBazaarCacheGetSet(t *testing.T) {
+	store := newFakeKVStore()
+	c := NewExternalStoreBazaarCache(store, "bazaar:latest")
+
+	if _, _, ok := c.Get(); ok {
+		t.Fatal("Get on empty store returned ok=true")
+	}
+
+	c.Set(&HypixelAPIResponse{LastUpdated: 7})
+
+	got, _, ok := c.Get()
+	if !ok {
+		t.Fatal("Get after Set returned ok=false")
+	}
+	if got.LastUpdated != 7 {
+		t.Errorf("LastUpdated = %d, want 7", got.LastUpdated)
+	}
+
+	if _, ok := store.Get("bazaar:latest"); !ok {
+		t.Error("Set did not write through to the underlying KVStore")
+	}
+}
+
+func TestExternalStoreBazaarCacheRefreshCoalesces(t *testing.T) {
+	store := newFakeKVStore()
+	c := NewExternalStoreBazaarCache(store, "bazaar:latest")
+
+	var calls int32
+	var mu sync.Mutex
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var startOnce sync.Once
+	fetch := func() (*HypixelAPIResponse, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		startOnce.Do(func() { close(started) })
+		<-release
+		return &HypixelAPIResponse{LastUpdated: 3}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Refresh(fetch); err != nil {
+				t.Errorf("Refresh() error = %v", err)
+			}
+		}()
+	}
+
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	got := calls
+	mu.Unlock()
+	if got != 1 {
+		t.Errorf("fetchFn called %d times, want 1", got)
+	}
+}
+
+// fakeBazaarSource is an injectable BazaarSource standing in for the live
+// Hypixel API, so NewBazaarClient can be exercised without a network call.
+type fakeBazaarSource struct {
+	resp *HypixelAPIResponse
+	err  error
+	mu   sync.Mutex
+	hits int
+}
+
+func (s *fakeBazaarSource) Name() string { return "fake" }
+
+func (s *fakeBazaarSource) Fetch(ctx context.Context) (*HypixelAPIResponse, error) {
+	s.mu.Lock()
+	s.hits++
+	s.mu.Unlock()
+	return s.resp, s.err
+}
+
+func TestBazaarClientGetApiResponseFetchesThenCaches(t *testing.T) {
+	source := &fakeBazaarSource{resp: &HypixelAPIResponse{LastUpdated: 99}}
+	bc := NewBazaarClient(BazaarClientOptions{Source: source, TTL: time.Minute})
+
+	resp, err := bc.GetApiResponse(context.Background())
+	if err != nil {
+		t.Fatalf("GetApiResponse() error = %v", err)
+	}
+	if resp.LastUpdated != 99 {
+		t.Errorf("LastUpdated = %d, want 99", resp.LastUpdated)
+	}
+
+	if _, err := bc.GetApiResponse(context.Background()); err != nil {
+		t.Fatalf("second GetApiResponse() error = %v", err)
+	}
+
+	source.mu.Lock()
+	hits := source.hits
+	source.mu.Unlock()
+	if hits != 1 {
+		t.Errorf("source.Fetch called %d times, want 1 (second call should be served from cache)", hits)
+	}
+}
+
+func TestBazaarClientGetApiResponseServesStaleOnError(t *testing.T) {
+	source := &fakeBazaarSource{resp: &HypixelAPIResponse{LastUpdated: 1}}
+	// A 1ns TTL means every call after the first sleep is treated as stale,
+	// forcing GetApiResponse to call through to Source.Fetch again.
+	bc := NewBazaarClient(BazaarClientOptions{Source: source, TTL: time.Nanosecond})
+
+	if _, err := bc.GetApiResponse(context.Background()); err != nil {
+		t.Fatalf("first GetApiResponse() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	source.err = errors.New("upstream down")
+	resp, err := bc.GetApiResponse(context.Background())
+	if err == nil {
+		t.Fatal("GetApiResponse() error = nil, want the upstream error")
+	}
+	if resp == nil || resp.LastUpdated != 1 {
+		t.Errorf("GetApiResponse() = %v, want last-known-good cached response", resp)
+	}
+}
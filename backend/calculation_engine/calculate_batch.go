@@ -0,0 +1,253 @@
+// calculate_batch.go
+package main
+
+// POST /calculate/batch is the one-round-trip alternative to firing N
+// /items/{id}-style GETs at this service: a client posts a whole inventory
+// and gets back one ordered JSON array instead of stitching together N
+// individual requests itself. The backlog names this file server.go and the
+// response type CalculationResult, both gilgetter-isms; this repo has no
+// server.go (every handler lives in its own per-concern file, as
+// wizserver.go's package comment explains for the same reason) and no
+// performCalculations/expandItem to call into, so this reuses
+// PerformDualExpansion - the one function every other per-item handler in
+// this package already calls - under a bounded worker pool instead.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CalculateBatchItem is one row of a POST /calculate/batch request body.
+type CalculateBatchItem struct {
+	ID  string  `json:"id"`
+	Qty float64 `json:"qty"`
+}
+
+// CalculateBatchRequest is POST /calculate/batch's JSON body: the items to
+// expand and an optional concurrency cap (clamped against
+// defaultBatchConcurrency/maxBatchConcurrency below).
+type CalculateBatchRequest struct {
+	Items          []CalculateBatchItem `json:"items"`
+	MaxConcurrency int                  `json:"maxConcurrency"`
+}
+
+// CalculationResult is one POST /calculate/batch response row, echoing the
+// requested ID/Qty alongside either a DualExpansionResult or an error -
+// mirroring StreamRecord's (stream.go) Result/ErrorMessage shape, minus the
+// streaming-only DurationMillis/Trace fields this endpoint has no use for.
+type CalculationResult struct {
+	ID           string               `json:"id"`
+	Qty          float64              `json:"qty"`
+	Result       *DualExpansionResult `json:"result,omitempty"`
+	ErrorMessage string               `json:"error_message,omitempty"`
+}
+
+// defaultBatchConcurrency and maxBatchConcurrency bound a batch request's
+// worker pool, matching requestTimeout's def/max clamp style (dashboard.go):
+// an unset or non-positive maxConcurrency falls back to the default, and an
+// oversized one is capped rather than letting one request fork unbounded
+// goroutines.
+const (
+	defaultBatchConcurrency = 8
+	maxBatchConcurrency     = 32
+)
+
+// defaultBatchTimeout bounds the whole batch, not any one item within it -
+// a deep recipe blocking one worker shouldn't be able to stall the request
+// indefinitely, mirroring defaultItemDashboardTimeout/maxItemDashboardTimeout.
+const (
+	defaultBatchTimeout = 30 * time.Second
+	maxBatchTimeout     = 3 * time.Minute
+)
+
+// maxBatchItems caps how many items one request can pack in, so a client
+// can't force an unbounded number of expansions off a single POST.
+const maxBatchItems = 500
+
+// batchExpansionMemo caches PerformDualExpansion results for the duration of
+// one /calculate/batch request, keyed by normalized item ID + quantity, so
+// an inventory listing the same item more than once only expands it once.
+// Deliberately separate from TreeCache/ResultCache (result_cache.go), which
+// cache across requests against the live bazaar/metrics snapshot - this one
+// is discarded the moment the request finishes and never shared between
+// requests.
+type batchExpansionMemo struct {
+	mu      sync.Mutex
+	entries map[batchMemoKey]*batchMemoEntry
+}
+
+type batchMemoKey struct {
+	id  string
+	qty float64
+}
+
+type batchMemoEntry struct {
+	done   chan struct{}
+	result *DualExpansionResult
+	err    error
+}
+
+func newBatchExpansionMemo() *batchExpansionMemo {
+	return &batchExpansionMemo{entries: make(map[batchMemoKey]*batchMemoEntry)}
+}
+
+// claim returns the memo slot for key, registering a new one if this is the
+// first request for key in this batch. owner is true for exactly one caller
+// per key - that caller must call entry.finish, even on error, so the
+// other callers sharing the same ID don't block forever waiting on it.
+func (m *batchExpansionMemo) claim(key batchMemoKey) (entry *batchMemoEntry, owner bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		return e, false
+	}
+	e := &batchMemoEntry{done: make(chan struct{})}
+	m.entries[key] = e
+	return e, true
+}
+
+func (e *batchMemoEntry) finish(result *DualExpansionResult, err error) {
+	e.result = result
+	e.err = err
+	close(e.done)
+}
+
+func (e *batchMemoEntry) wait() (*DualExpansionResult, error) {
+	<-e.done
+	return e.result, e.err
+}
+
+// calculateBatchHandler serves POST /calculate/batch: expands every item in
+// the request body against a shared bounded worker pool, deduplicating
+// repeated IDs via batchExpansionMemo, and writes back one JSON array of
+// CalculationResult in the same order the items were submitted - in
+// contrast to bulkExpansionHandler's /expand/bulk (stream.go), which streams
+// NDJSON out of completion order.
+func calculateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		m := DefaultMetrics(nil)
+		m.CalculateRequestsTotal.WithLabelValues(status).Inc()
+		m.CalculateLatencySeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	if r.Method != http.MethodPost {
+		status = "error"
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CalculateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		status = "error"
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		status = "error"
+		http.Error(w, "items must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) > maxBatchItems {
+		status = "error"
+		http.Error(w, "too many items in one batch", http.StatusBadRequest)
+		return
+	}
+
+	concurrency := req.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r, defaultBatchTimeout, maxBatchTimeout))
+	defer cancel()
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		status = "error"
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+	DefaultMetrics(nil).BazaarItemsTracked.Set(float64(len(metricsMap)))
+
+	results := make([]CalculationResult, len(req.Items))
+	memo := newBatchExpansionMemo()
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range req.Items {
+		qty := item.Qty
+		if qty <= 0 {
+			qty = 1
+		}
+		results[i] = CalculationResult{ID: BAZAAR_ID(item.ID), Qty: qty}
+
+		wg.Add(1)
+		go func(idx int, itemID string, qty float64) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[idx].ErrorMessage = errString(ctx.Err())
+				return
+			}
+
+			key := batchMemoKey{id: itemID, qty: qty}
+			entry, owner := memo.claim(key)
+			if owner {
+				dual, err := PerformDualExpansion(ctx, itemID, qty, apiResp, metricsMap, defaultItemFilesDir, false, PrecisionFloat, ExpansionOptions{})
+				entry.finish(dual, err)
+			}
+			dual, err := entry.wait()
+			if err != nil {
+				results[idx].ErrorMessage = errString(err)
+				return
+			}
+			results[idx].Result = dual
+		}(i, BAZAAR_ID(item.ID), qty)
+	}
+
+	wg.Wait()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = "timeout"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("calculateBatchHandler: encode response: %v", err)
+	}
+
+	// Record each successful expansion into the historical time series store
+	// and the active-items cardinality tracker after responding (memstore.go,
+	// cardinality.go), same as wizItemHandler.
+	m := DefaultMetrics(nil)
+	recordedAt := time.Now()
+	for _, res := range results {
+		if res.Result != nil {
+			RecordCalculationResult(defaultMemStore, res.ID, recordedAt, res.Result)
+			recordItemTouch(res.ID, float64(res.Result.PrimaryBased.TopLevelCost), recordedAt)
+			if res.Result.PrimaryBased.RecipeTree != nil {
+				m.ExpansionDepth.Observe(float64(res.Result.PrimaryBased.RecipeTree.MaxSubTreeDepth))
+			}
+			for i, n := 0, countIngredientCostErrors(res.Result); i < n; i++ {
+				m.IngredientCostErrorsTotal.Inc()
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// cardinality.go
+package main
+
+// Backs GET /cardinality/active_items, the Mimir-style active-series
+// endpoint the backlog asks for: which product IDs calculateBatchHandler
+// and wizItemHandler (this package's closest analogs of a generic
+// "handleCalculate") have actually been asked to price recently, so an
+// operator can tell a hot-but-slow item from one nobody's touched in days.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// itemStats tracks one product ID's calculate-handler activity. A plain
+// struct guarded by its own mutex, not atomics, since count/totalCost are
+// always read and updated together for activeItemsReport's average-cost
+// calculation.
+type itemStats struct {
+	mu        sync.Mutex
+	count     int64
+	totalCost float64
+	lastSeen  time.Time
+}
+
+func (s *itemStats) touch(cost float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.totalCost += cost
+	s.lastSeen = at
+}
+
+func (s *itemStats) snapshot() (count int64, avgCost float64, lastSeen time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	avg := 0.0
+	if s.count > 0 {
+		avg = s.totalCost / float64(s.count)
+	}
+	return s.count, avg, s.lastSeen
+}
+
+// activeItemStats is the process-wide sync.Map[string]*itemStats keyed by
+// normalized product ID - a sync.Map rather than a mutex-guarded map since
+// every calculate handler writes concurrently and reads (via
+// activeItemsHandler) are comparatively rare.
+var activeItemStats sync.Map
+
+// recordItemTouch records one calculate-handler invocation for itemID at
+// cost (its PrimaryBased.TopLevelCost, the same direct-buy figure
+// RecordCalculationResult files as memstore's directCost series).
+func recordItemTouch(itemID string, cost float64, at time.Time) {
+	v, _ := activeItemStats.LoadOrStore(itemID, &itemStats{})
+	v.(*itemStats).touch(cost, at)
+}
+
+// countIngredientCostErrors returns how many of dual's base ingredients
+// (across both perspectives) couldn't be priced - getBestC10M/
+// analyzeBaseIngredient mark those with Method "N/A" - for
+// IngredientCostErrorsTotal (observability.go).
+func countIngredientCostErrors(dual *DualExpansionResult) int {
+	if dual == nil {
+		return 0
+	}
+	n := 0
+	for _, detail := range dual.PrimaryBased.BaseIngredients {
+		if detail.Method == "N/A" {
+			n++
+		}
+	}
+	for _, detail := range dual.SecondaryBased.BaseIngredients {
+		if detail.Method == "N/A" {
+			n++
+		}
+	}
+	return n
+}
+
+// activeItemRecord is one row of GET /cardinality/active_items's response.
+type activeItemRecord struct {
+	ItemID       string  `json:"item_id"`
+	RequestCount int64   `json:"request_count"`
+	AverageCost  float64 `json:"average_cost"`
+	LastSeen     string  `json:"last_seen"`
+}
+
+// defaultActiveItemsWindow is how far back "active" looks absent an
+// explicit ?minutes= override.
+const defaultActiveItemsWindow = 15 * time.Minute
+
+// activeItemsHandler serves GET /cardinality/active_items?selector=<regex>
+// &minutes=<n>: every product ID touched by a calculate handler within the
+// last minutes (default defaultActiveItemsWindow) whose ID matches selector
+// (default: everything), with its request count and average cost.
+func activeItemsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	selector := q.Get("selector")
+	var matcher *regexp.Regexp
+	if selector != "" {
+		re, err := regexp.Compile(selector)
+		if err != nil {
+			http.Error(w, "invalid 'selector' regex: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		matcher = re
+	}
+
+	window := defaultActiveItemsWindow
+	if raw := q.Get("minutes"); raw != "" {
+		if mins, err := time.ParseDuration(raw + "m"); err == nil && mins > 0 {
+			window = mins
+		}
+	}
+	cutoff := time.Now().Add(-window)
+
+	var active []activeItemRecord
+	activeItemStats.Range(func(k, v interface{}) bool {
+		itemID := k.(string)
+		if matcher != nil && !matcher.MatchString(itemID) {
+			return true
+		}
+		count, avgCost, lastSeen := v.(*itemStats).snapshot()
+		if lastSeen.Before(cutoff) {
+			return true
+		}
+		active = append(active, activeItemRecord{
+			ItemID:       itemID,
+			RequestCount: count,
+			AverageCost:  avgCost,
+			LastSeen:     lastSeen.UTC().Format(time.RFC3339),
+		})
+		return true
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(active); err != nil {
+		log.Printf("activeItemsHandler: encode response: %v", err)
+	}
+}
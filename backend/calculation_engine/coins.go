@@ -0,0 +1,154 @@
+// coins.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Coins is a fixed-point representation of an in-game currency amount,
+// stored internally as millicoins (1 unit == 1/1000 of a coin). It exists
+// so the ingredient-cost summations in calculateDetailedCostsAndFillTimes
+// and analyzeTreeForCostsAndTimes can accumulate without the rounding
+// drift repeated float64 addition introduces on a deep recipe with many
+// base ingredients. Money math that opts into PrecisionDecimal should
+// accumulate through Coins and only convert back to float64 at the JSON
+// boundary (see Float64/MarshalJSON), mirroring how JSONFloat64 already
+// isolates NaN/Inf sanitization to the encode step rather than every call site.
+type Coins int64
+
+// coinsScale is the number of Coins units per whole coin.
+const coinsScale = 1000
+
+// CoinsFromFloat converts a float64 coin amount to its millicoin fixed-point
+// representation, rounding to the nearest millicoin. NaN/Inf collapse to 0
+// rather than propagating an undefined int64 value.
+func CoinsFromFloat(v float64) Coins {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return Coins(math.Round(v * coinsScale))
+}
+
+// Float64 converts c back to a float64 coin amount, the inverse of CoinsFromFloat.
+func (c Coins) Float64() float64 {
+	return float64(c) / coinsScale
+}
+
+// MarshalJSON renders c as a plain JSON number of coins rather than
+// millicoins, so a PrecisionDecimal response field looks identical to its
+// PrecisionFloat counterpart on the wire.
+func (c Coins) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(c.Float64(), 'f', -1, 64)), nil
+}
+
+// UnmarshalJSON is MarshalJSON's inverse, accepting either a bare JSON number
+// (what MarshalJSON emits) or a quoted decimal string like "1234.56", so a
+// client that re-serializes a Coins value it round-trips (or one that always
+// quotes large numbers to dodge JS's float64 precision loss) can still send
+// it back.
+func (c *Coins) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*c = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Coins value %q: %w", s, err)
+	}
+	*c = CoinsFromFloat(v)
+	return nil
+}
+
+// PrecisionMode selects how money math is accumulated: PrecisionFloat keeps
+// the package's original plain-float64 behavior, PrecisionDecimal routes
+// sums through Coins. See the ?precision= query param on itemDashboardHandler.
+type PrecisionMode string
+
+const (
+	PrecisionFloat   PrecisionMode = "float"
+	PrecisionDecimal PrecisionMode = "decimal"
+)
+
+// parsePrecisionMode maps a raw ?precision= query value to a PrecisionMode,
+// defaulting to PrecisionFloat (the pre-existing behavior) for an empty or
+// unrecognized value rather than rejecting the request outright.
+func parsePrecisionMode(raw string) PrecisionMode {
+	if PrecisionMode(raw) == PrecisionDecimal {
+		return PrecisionDecimal
+	}
+	return PrecisionFloat
+}
+
+// costAccumulator sums per-ingredient cost values for
+// calculateDetailedCostsAndFillTimes and analyzeTreeForCostsAndTimes. In
+// PrecisionFloat mode it's a thin wrapper over plain float64 addition (the
+// original behavior); in PrecisionDecimal mode every Add first rounds
+// through Coins, so the accumulated error is bounded to at most half a
+// millicoin per term instead of compounding float64 mantissa drift across a
+// recipe with many base ingredients.
+type costAccumulator struct {
+	precision PrecisionMode
+	floatSum  float64
+	coinsSum  Coins
+}
+
+func newCostAccumulator(precision PrecisionMode) costAccumulator {
+	return costAccumulator{precision: precision}
+}
+
+func (a *costAccumulator) Add(v float64) {
+	if a.precision == PrecisionDecimal {
+		a.coinsSum += CoinsFromFloat(v)
+		return
+	}
+	a.floatSum += v
+}
+
+func (a costAccumulator) Sum() float64 {
+	if a.precision == PrecisionDecimal {
+		return a.coinsSum.Float64()
+	}
+	return a.floatSum
+}
+
+// quantitySnapEpsilon bounds how far a computed ingredient quantity can sit
+// from the nearest integer before snapQuantity stops treating it as rounding
+// noise. ceil(quantity/craftedAmount)*amountPerCraft chains several float64
+// divisions and multiplications per recipe level; on a deep chain (nested
+// enchanted blocks, reforge stones) that can leave an amount that should be
+// an exact whole number sitting at e.g. 7.999999999999998 or
+// 8.000000000000002 instead.
+const quantitySnapEpsilon = 1e-6
+
+// snapQuantity rounds amt to the nearest integer when it's within
+// quantitySnapEpsilon of one, leaving it untouched otherwise (a recipe can
+// legitimately need a fractional amount of something, e.g. half a stack's
+// worth of a byproduct). Applied at the points that multiply a per-craft
+// ingredient amount by a craft count, so a value display or a later equality
+// check downstream doesn't see 0.999999999994 where a clean 1 was intended.
+func snapQuantity(amt float64) float64 {
+	if math.IsNaN(amt) || math.IsInf(amt, 0) {
+		return amt
+	}
+	rounded := math.Round(amt)
+	if math.Abs(amt-rounded) <= quantitySnapEpsilon {
+		return rounded
+	}
+	return amt
+}
+
+// costLessOrEqual compares a and b the way precision says a caller should:
+// PrecisionFloat keeps the plain float64 comparison getBestC10M (c10m.go)
+// always used; PrecisionDecimal rounds both sides through Coins first, so a
+// Primary/Secondary choice that's genuinely a tie in millicoins can't flip
+// back and forth between calls purely from float64 mantissa noise.
+func costLessOrEqual(a, b float64, precision PrecisionMode) bool {
+	if precision == PrecisionDecimal {
+		return CoinsFromFloat(a) <= CoinsFromFloat(b)
+	}
+	return a <= b
+}
@@ -0,0 +1,120 @@
+// constrained_expansion.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// ExpansionConstraints bounds PerformConstrainedExpansion's search over
+// PerformDualExpansion's ParetoFrontier. Each field is optional; <= 0 means
+// that constraint is unchecked.
+type ExpansionConstraints struct {
+	// MaxTotalCost caps a candidate's ExpansionResult.TotalCost.
+	MaxTotalCost float64
+	// MaxSlowestFillTimeSeconds caps a candidate's
+	// ExpansionResult.SlowestIngredientBuyTimeSeconds.
+	MaxSlowestFillTimeSeconds float64
+	// MaxCoinsPerSecond caps TotalCost/SlowestIngredientBuyTimeSeconds - the
+	// rate coins would actually need to leave the trader's purse to hit this
+	// candidate's fill time - so a cheap-but-instant buy isn't preferred over
+	// a craft that spends the same total more gradually.
+	MaxCoinsPerSecond float64
+}
+
+// violations reports every constraint c violates against r, as a
+// human-readable string per violated constraint; nil means r is fully
+// feasible under c. A candidate whose CalculationPossible is false violates
+// trivially, since none of its numeric fields can be trusted.
+func (c ExpansionConstraints) violations(r *ExpansionResult) []string {
+	if !r.CalculationPossible {
+		return []string{"calculation not possible for this candidate"}
+	}
+
+	var out []string
+	cost := float64(r.TotalCost)
+	fillTime := float64(r.SlowestIngredientBuyTimeSeconds)
+
+	if c.MaxTotalCost > 0 && cost > c.MaxTotalCost {
+		out = append(out, fmt.Sprintf("total cost %.2f exceeds MaxTotalCost %.2f", cost, c.MaxTotalCost))
+	}
+	if c.MaxSlowestFillTimeSeconds > 0 && fillTime > c.MaxSlowestFillTimeSeconds {
+		out = append(out, fmt.Sprintf("slowest fill time %.2fs exceeds MaxSlowestFillTimeSeconds %.2fs", fillTime, c.MaxSlowestFillTimeSeconds))
+	}
+	if c.MaxCoinsPerSecond > 0 {
+		coinsPerSecond := 0.0
+		switch {
+		case fillTime > 0:
+			coinsPerSecond = cost / fillTime
+		case cost > 0:
+			coinsPerSecond = math.Inf(1) // non-zero cost filled instantaneously
+		}
+		if coinsPerSecond > c.MaxCoinsPerSecond {
+			out = append(out, fmt.Sprintf("coins/sec %.2f exceeds MaxCoinsPerSecond %.2f", coinsPerSecond, c.MaxCoinsPerSecond))
+		}
+	}
+	return out
+}
+
+// PerformConstrainedExpansion runs PerformDualExpansion for quantity of
+// itemName and picks the cheapest candidate off its ParetoFrontier (falling
+// back to just PrimaryBased/SecondaryBased if the frontier came back empty,
+// e.g. the item has no recipe) that satisfies every constraints field. When
+// no candidate is fully feasible, it instead returns whichever candidate
+// violates the fewest constraints (cost breaking further ties), with
+// ConstraintViolations populated on the chosen ExpansionResult so a caller
+// like a flipping bot can see exactly which bound it missed by.
+func PerformConstrainedExpansion(
+	ctx context.Context,
+	itemName string,
+	quantity float64,
+	constraints ExpansionConstraints,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	precision PrecisionMode,
+	opts ExpansionOptions,
+) (*ExpansionResult, error) {
+	dual, err := PerformDualExpansion(ctx, itemName, quantity, apiResp, metricsMap, itemFilesDir, false, precision, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := append([]ExpansionResult{}, dual.ParetoFrontier...)
+	if len(candidates) == 0 {
+		candidates = []ExpansionResult{dual.PrimaryBased, dual.SecondaryBased}
+	}
+
+	var best *ExpansionResult
+	bestFeasible := false
+	bestViolationCount := 0
+	bestCost := math.Inf(1)
+
+	for i := range candidates {
+		c := &candidates[i]
+		c.ConstraintViolations = constraints.violations(c)
+		feasible := len(c.ConstraintViolations) == 0
+		cost := float64(c.TotalCost)
+
+		switch {
+		case best == nil:
+			best, bestFeasible, bestViolationCount, bestCost = c, feasible, len(c.ConstraintViolations), cost
+		case feasible && !bestFeasible:
+			best, bestFeasible, bestViolationCount, bestCost = c, true, 0, cost
+		case feasible && bestFeasible:
+			if cost < bestCost {
+				best, bestCost = c, cost
+			}
+		case !feasible && !bestFeasible:
+			if len(c.ConstraintViolations) < bestViolationCount || (len(c.ConstraintViolations) == bestViolationCount && cost < bestCost) {
+				best, bestViolationCount, bestCost = c, len(c.ConstraintViolations), cost
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no candidate strategies available for %s", BAZAAR_ID(itemName))
+	}
+	return best, nil
+}
@@ -0,0 +1,637 @@
+// cost_dag.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// recipeVariant is one Recipes[] (or legacy Recipe) entry's per-batch
+// ingredient requirements: one craft, not scaled to any caller's quantity.
+// recipesIndex is its position in the item's Recipes[] slice, or -1 if it
+// came from the legacy single Recipe field instead.
+type recipeVariant struct {
+	ingredientsPerBatch map[string]float64
+	craftedAmount       float64
+	recipesIndex        int
+}
+
+// recipeNode is one item's recipe-DAG data as loaded by buildRecipeGraph:
+// every viable recipe variant for it, so resolveDecision can price each one
+// and keep the cheapest (chunk21-1) instead of loadRecipeNode collapsing to
+// a single chosenRecipeCellsFor pick the way it did before.
+type recipeNode struct {
+	variants  []recipeVariant
+	hasRecipe bool
+}
+
+// anyCellSet reports whether a recipe's 3x3 grid has any ingredient at all,
+// distinguishing a real (if empty-looking) recipe entry from a placeholder.
+func anyCellSet(cells map[string]string) bool {
+	for _, v := range cells {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRecipeVariant aggregates one set of recipe cells into a recipeVariant,
+// shared by loadRecipeNode's Recipes[] loop and its legacy Recipe fallback.
+func loadRecipeVariant(ctx context.Context, itemID string, cells map[string]string, count float64, recipesIndex int, modules map[string]string, subRecipes map[string]SingleRecipe, apiResp *HypixelAPIResponse) (recipeVariant, error) {
+	specs, aggErr := aggregateCells(ctx, cells, modules, subRecipes)
+	if aggErr != nil {
+		return recipeVariant{}, fmt.Errorf("parsing recipe cells for '%s': %w", itemID, aggErr)
+	}
+	return recipeVariant{ingredientsPerBatch: resolveIngredientSpecs(specs, apiResp), craftedAmount: count, recipesIndex: recipesIndex}, nil
+}
+
+// loadRecipeNode reads and parses itemID's recipe file the same way
+// expansionMemo.flattenedIngredients (recipe_expansion.go) does, but keeps
+// every Recipes[] variant (minus any recipeTagExcluded one) rather than
+// collapsing to chosenRecipeCellsFor's single first-recipe pick, since
+// BuildBestCostDAG's DP needs each variant's craftedAmount and per-batch
+// amounts separately to price them against one another.
+func loadRecipeNode(ctx context.Context, itemID, itemFilesDir string, apiResp *HypixelAPIResponse) (*recipeNode, error) {
+	filePath := recipeFilePath(itemFilesDir, itemID)
+	if _, statErr := os.Stat(filePath); os.IsNotExist(statErr) {
+		return &recipeNode{hasRecipe: false}, nil
+	} else if statErr != nil {
+		return nil, fmt.Errorf("checking recipe file '%s': %w", filePath, statErr)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading recipe file '%s': %w", filePath, err)
+	}
+	var itemData Item
+	if err := json.Unmarshal(data, &itemData); err != nil {
+		return nil, fmt.Errorf("parsing recipe JSON for '%s': %w", itemID, err)
+	}
+
+	var variants []recipeVariant
+	for i, r := range itemData.Recipes {
+		if recipeTagExcluded(r.Tags) {
+			continue
+		}
+		cells := cellsOf(SingleRecipe{A1: r.A1, A2: r.A2, A3: r.A3, B1: r.B1, B2: r.B2, B3: r.B3, C1: r.C1, C2: r.C2, C3: r.C3, Count: r.Count})
+		if !anyCellSet(cells) {
+			continue
+		}
+		count := 1.0
+		if r.Count > 0 {
+			count = float64(r.Count)
+		}
+		variant, err := loadRecipeVariant(ctx, itemID, cells, count, i, itemData.Modules, itemData.SubRecipes, apiResp)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	if len(variants) == 0 && anyCellSet(cellsOf(itemData.Recipe)) {
+		count := 1.0
+		if itemData.Recipe.Count > 0 {
+			count = float64(itemData.Recipe.Count)
+		}
+		variant, err := loadRecipeVariant(ctx, itemID, cellsOf(itemData.Recipe), count, -1, itemData.Modules, itemData.SubRecipes, apiResp)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	if len(variants) == 0 {
+		return &recipeNode{hasRecipe: false}, nil
+	}
+	return &recipeNode{variants: variants, hasRecipe: true}, nil
+}
+
+// ingredientIDs returns the union of every ingredient referenced by any of
+// node's recipe variants, for graph discovery and cycle detection - a cycle
+// that only one alternative recipe would create still needs to be seen.
+func (node *recipeNode) ingredientIDs() map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, v := range node.variants {
+		for ing := range v.ingredientsPerBatch {
+			ids[ing] = struct{}{}
+		}
+	}
+	return ids
+}
+
+// buildRecipeGraph BFS-loads rootID and every ingredient reachable from it
+// into an in-memory map, reading each reachable item's recipe file exactly
+// once regardless of how many paths reach it - chunk19-3 step (1), replacing
+// expandItemRecursiveTree's per-call-site file reads for a shared subtree.
+func buildRecipeGraph(ctx context.Context, rootID, itemFilesDir string, apiResp *HypixelAPIResponse) (map[string]*recipeNode, error) {
+	graph := make(map[string]*recipeNode)
+	queue := []string{BAZAAR_ID(rootID)}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if _, seen := graph[id]; seen {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		node, err := loadRecipeNode(ctx, id, itemFilesDir, apiResp)
+		if err != nil {
+			return nil, err
+		}
+		graph[id] = node
+		for ing := range node.ingredientIDs() {
+			ingNorm := BAZAAR_ID(ing)
+			if _, seen := graph[ingNorm]; !seen {
+				queue = append(queue, ingNorm)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// tarjanState is Tarjan's strongly-connected-components algorithm run over a
+// recipe graph's item -> ingredient edges. Components come out of it in
+// reverse topological order: a component with no outgoing edge to a
+// not-yet-completed component (a pure ingredient sink, including every plain
+// base component) is appended before anything that depends on it, which is
+// exactly the order BuildBestCostDAG's DP needs to process them in.
+type tarjanState struct {
+	graph      map[string]*recipeNode
+	index      map[string]int
+	lowlink    map[string]int
+	onStack    map[string]bool
+	stack      []string
+	counter    int
+	components [][]string
+}
+
+// tarjanSCC classifies graph's strongly connected components, so
+// BuildBestCostDAG can treat any item that's part of a cycle (a recipe loop,
+// direct or indirect) as a base terminal up front - chunk19-3 step (2) -
+// instead of discovering the cycle at runtime via a path-length check.
+func tarjanSCC(graph map[string]*recipeNode) [][]string {
+	st := &tarjanState{
+		graph:   graph,
+		index:   make(map[string]int),
+		lowlink: make(map[string]int),
+		onStack: make(map[string]bool),
+	}
+	for id := range graph {
+		if _, visited := st.index[id]; !visited {
+			st.strongconnect(id)
+		}
+	}
+	return st.components
+}
+
+func (st *tarjanState) strongconnect(v string) {
+	st.index[v] = st.counter
+	st.lowlink[v] = st.counter
+	st.counter++
+	st.stack = append(st.stack, v)
+	st.onStack[v] = true
+
+	for ing := range st.graph[v].ingredientIDs() {
+		w := BAZAAR_ID(ing)
+		if _, known := st.graph[w]; !known {
+			continue // referenced by a recipe cell but never resolved to a loadable node; treated as a dead edge rather than a panic.
+		}
+		if _, visited := st.index[w]; !visited {
+			st.strongconnect(w)
+			if st.lowlink[w] < st.lowlink[v] {
+				st.lowlink[v] = st.lowlink[w]
+			}
+		} else if st.onStack[w] {
+			if st.index[w] < st.lowlink[v] {
+				st.lowlink[v] = st.index[w]
+			}
+		}
+	}
+
+	if st.lowlink[v] != st.index[v] {
+		return
+	}
+	var component []string
+	for {
+		n := len(st.stack) - 1
+		w := st.stack[n]
+		st.stack = st.stack[:n]
+		st.onStack[w] = false
+		component = append(component, w)
+		if w == v {
+			break
+		}
+	}
+	st.components = append(st.components, component)
+}
+
+// costDecision is one item's resolved acquisition choice once
+// BuildBestCostDAG has run: the cheapest way to obtain one unit, and (when
+// Method is "craft") the per-batch recipe BaseIngredients replays to turn a
+// requested quantity into concrete base-component amounts. RecipeIndex is
+// which of the item's Recipes[] entries was chosen (-1 for "buy", or for a
+// craft decision resolved from the legacy single Recipe field) - chunk21-1's
+// "return the chosen recipe path" for this DAG's cost solver.
+type costDecision struct {
+	Method              string // "buy" or "craft"
+	UnitCost            float64
+	CraftedAmount       float64
+	IngredientsPerBatch map[string]float64
+	RecipeIndex         int
+}
+
+// BestCostDAG answers bestCost/base-ingredient queries for every item
+// reachable from the root it was built for, computed once via
+// buildRecipeGraph + tarjanSCC + a single reverse-topological DP pass
+// (chunk19-3 steps 1-3) rather than expandItemRecursiveTree's per-call
+// recursive walk and its O(path) isInPath cycle check. It carries none of
+// CraftingStepNode's presentation fields (risk scoring, ExpansionEvents,
+// alternative recipes) - it exists for a caller that only wants "what does
+// this cost, and what do I need to buy" as cheaply as possible, such as a
+// bulk batch-fill job evaluating many items against the same snapshot.
+type BestCostDAG struct {
+	decisions map[string]*costDecision
+}
+
+// BuildBestCostDAG builds the full recipe DAG reachable from rootID and
+// computes every reachable item's bestCost (the cheaper of buying from the
+// bazaar vs. crafting from its cheapest-resolved ingredients) in one
+// reverse-topological pass over tarjanSCC's components. An item with a
+// recipe but no viable bazaar price is still priced by crafting
+// (force-expand), and an item with neither is priced as "buy" at whatever
+// getBestC10M returns (including an unpriceable +Inf), matching its "treat
+// as base" handling of non-bazaar items without a recipe.
+//
+// An item that's part of a recipe cycle (A needs B, B needs A in a smaller
+// quantity) is priced by solveSCCCosts solving the cycle's true net cost via
+// (I - M) x = d, rather than always being forced to a bazaar buy as before
+// chunk20-4 - unless the cycle isn't well-formed enough to solve (a member
+// has no recipe, or depends on an unresolvable outside ingredient), in which
+// case it still falls back to buy-only the same way it always has. A
+// genuinely singular cycle (no net production at all) surfaces as
+// ErrSingularCraftCycle instead of a silently wrong number.
+func BuildBestCostDAG(ctx context.Context, rootID string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string) (*BestCostDAG, error) {
+	rootNorm := BAZAAR_ID(rootID)
+	graph, err := buildRecipeGraph(ctx, rootNorm, itemFilesDir, apiResp)
+	if err != nil {
+		return nil, fmt.Errorf("BuildBestCostDAG: building recipe graph for %s: %w", rootNorm, err)
+	}
+
+	decisions := make(map[string]*costDecision, len(graph))
+	for _, component := range tarjanSCC(graph) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		cyclic := len(component) > 1 || selfReferential(graph, component[0])
+		if cyclic && sccCraftable(component, graph, decisions) {
+			craftCosts, err := solveSCCCosts(component, graph, decisions)
+			if err != nil {
+				return nil, fmt.Errorf("BuildBestCostDAG: %s: %w", rootNorm, err)
+			}
+			for _, id := range component {
+				decisions[id] = resolveCyclicDecision(ctx, id, craftCosts[id], apiResp, metricsMap)
+			}
+			continue
+		}
+		for _, id := range component {
+			decisions[id] = resolveDecision(ctx, id, graph[id], decisions, cyclic, apiResp, metricsMap)
+		}
+	}
+	return &BestCostDAG{decisions: decisions}, nil
+}
+
+// selfReferential reports whether any of id's own recipe variants lists id
+// as an ingredient of itself - a one-node cycle tarjanSCC reports as a
+// singleton component, but which still needs base-terminal treatment like
+// any other cycle.
+func selfReferential(graph map[string]*recipeNode, id string) bool {
+	for _, v := range graph[id].variants {
+		if amt, ok := v.ingredientsPerBatch[id]; ok && amt > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sccCraftable reports whether every item in a cyclic component has at
+// least one recipe variant with a positive batch size and, for every
+// ingredient that variant's first entry needs from outside the component,
+// an already-resolved finite cost - the preconditions solveSCCCosts needs
+// to set up a well-formed linear system. solveSCCCosts only ever solves
+// against each item's first viable variant (variants[0]) rather than
+// choosing among alternatives the way resolveDecision does for non-cyclic
+// items - picking the cheapest recipe *and* solving the cycle jointly is
+// out of scope here. If any member fails this, BuildBestCostDAG falls back
+// to pricing the whole component as buy-only, same as before chunk20-4.
+func sccCraftable(component []string, graph map[string]*recipeNode, decisions map[string]*costDecision) bool {
+	inSCC := make(map[string]bool, len(component))
+	for _, id := range component {
+		inSCC[id] = true
+	}
+	for _, id := range component {
+		node := graph[id]
+		if len(node.variants) == 0 || node.variants[0].craftedAmount <= 0 {
+			return false
+		}
+		for ing := range node.variants[0].ingredientsPerBatch {
+			ingNorm := BAZAAR_ID(ing)
+			if inSCC[ingNorm] {
+				continue
+			}
+			dec, ok := decisions[ingNorm]
+			if !ok || math.IsInf(dec.UnitCost, 1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ErrSingularCraftCycle is solveSCCCosts' error for a recipe cycle whose
+// conversion matrix (I - M) is singular - e.g. item A's recipe needs
+// exactly 1 B and B's needs exactly 1 A with nothing else feeding the loop,
+// so there is no finite amount of outside material that nets out to a
+// positive amount of either. BuildBestCostDAG surfaces this rather than
+// guessing, since the recipe data describes a loop with no real production.
+var ErrSingularCraftCycle = errors.New("recipe cycle has no net production (singular conversion matrix)")
+
+// solveSCCCosts computes the true per-unit craft cost of every item in a
+// non-trivial strongly connected component (a direct or indirect recipe
+// cycle, e.g. A needs B, B needs A in a smaller quantity) by solving the
+// linear system (I - M) x = d: M is the normalized intra-component
+// ingredient-conversion matrix (M[i][j] = ingredient j's per-batch amount in
+// i's recipe, divided by i's craftedAmount) and d is the cost contribution
+// from ingredients already resolved outside the component. This replaces
+// treating every cyclic item as a forced bazaar buy, which undercounted the
+// true cost of crafting through a loop where the net material consumption
+// per cycle converges to something finite.
+func solveSCCCosts(component []string, graph map[string]*recipeNode, decisions map[string]*costDecision) (map[string]float64, error) {
+	n := len(component)
+	index := make(map[string]int, n)
+	for i, id := range component {
+		index[id] = i
+	}
+
+	// augmented [n x (n+1)] matrix: columns 0..n-1 are (I - M), column n is d.
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for i, id := range component {
+		variant := graph[id].variants[0]
+		a[i][i] = 1
+		for ing, amtPerBatch := range variant.ingredientsPerBatch {
+			ingNorm := BAZAAR_ID(ing)
+			rate := amtPerBatch / variant.craftedAmount
+			if j, inSCC := index[ingNorm]; inSCC {
+				a[i][j] -= rate
+			} else {
+				a[i][n] += rate * decisions[ingNorm].UnitCost
+			}
+		}
+	}
+
+	x, err := gaussianSolve(a)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.Join(component, ","), err)
+	}
+	result := make(map[string]float64, n)
+	for i, id := range component {
+		result[id] = x[i]
+	}
+	return result, nil
+}
+
+// gaussianSolve solves a's augmented [n x (n+1)] linear system via Gaussian
+// elimination with partial pivoting, returning ErrSingularCraftCycle if a's
+// square part turns out to be singular. This package leans on plain
+// float64 arithmetic here the same way it does throughout the rest of the
+// expander (see coins.go for where it switches to exact decimal math
+// instead) rather than pulling in a linear-algebra dependency for what is
+// at most a handful of equations per recipe cycle.
+func gaussianSolve(a [][]float64) ([]float64, error) {
+	n := len(a)
+	const pivotEpsilon = 1e-9
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		if math.Abs(a[col][col]) < pivotEpsilon {
+			return nil, ErrSingularCraftCycle
+		}
+		for r := col + 1; r < n; r++ {
+			factor := a[r][col] / a[col][col]
+			for c := col; c <= n; c++ {
+				a[r][c] -= factor * a[col][c]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		sum := a[i][n]
+		for j := i + 1; j < n; j++ {
+			sum -= a[i][j] * x[j]
+		}
+		x[i] = sum / a[i][i]
+	}
+	return x, nil
+}
+
+// resolveCyclicDecision prices id - a member of a cycle solveSCCCosts
+// already solved - as the cheaper of its solved craft cost and its bazaar
+// buy price. Method stays "buy" either way: BaseIngredients treats "buy"
+// decisions as base/terminal, which is still correct here since a cyclic
+// item's own IngredientsPerBatch feed back into the same cycle and can't be
+// decomposed further without re-entering it. UnitCost is what chunk20-4
+// actually fixes - it now reflects the cycle's true net cost instead of
+// always being the bazaar buy price.
+func resolveCyclicDecision(ctx context.Context, id string, craftCost float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) *costDecision {
+	buyCost, _, _, _, _, buyErr := getBestC10M(ctx, id, 1, apiResp, metricsMap, PrecisionFloat, nil)
+	buyCost = valueOrNaN(buyCost)
+	buyable := buyErr == nil && !math.IsNaN(buyCost)
+
+	cost := craftCost
+	if buyable && buyCost < cost {
+		cost = buyCost
+	}
+	if math.IsNaN(cost) {
+		cost = math.Inf(1)
+	}
+	return &costDecision{Method: "buy", UnitCost: cost, RecipeIndex: -1}
+}
+
+// resolveDecision computes id's bestCost decision. decisions must already
+// hold every ingredient id's recipe depends on, which tarjanSCC's
+// reverse-topological component order guarantees as long as callers process
+// components (and, within a component, nothing - see cyclic below) in the
+// order BuildBestCostDAG does. When id has more than one viable recipe
+// variant, every one is priced and the cheapest wins (chunk21-1), instead of
+// always taking variants[0] the way this DP did before.
+func resolveDecision(ctx context.Context, id string, node *recipeNode, decisions map[string]*costDecision, cyclic bool, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) *costDecision {
+	buyCost, _, _, _, _, buyErr := getBestC10M(ctx, id, 1, apiResp, metricsMap, PrecisionFloat, nil)
+	buyCost = valueOrNaN(buyCost)
+	buyable := buyErr == nil && !math.IsNaN(buyCost)
+
+	if cyclic || !node.hasRecipe {
+		cost := buyCost
+		if !buyable {
+			cost = math.Inf(1)
+		}
+		return &costDecision{Method: "buy", UnitCost: cost, RecipeIndex: -1}
+	}
+
+	bestCraftCost := math.Inf(1)
+	bestVariant := -1
+	for vi, variant := range node.variants {
+		if variant.craftedAmount <= 0 {
+			continue
+		}
+		craftCost := 0.0
+		craftPossible := true
+		for ing, amtPerBatch := range variant.ingredientsPerBatch {
+			dec, ok := decisions[BAZAAR_ID(ing)]
+			if !ok || math.IsInf(dec.UnitCost, 1) {
+				craftPossible = false
+				break
+			}
+			craftCost += amtPerBatch * dec.UnitCost
+		}
+		if !craftPossible {
+			continue
+		}
+		craftCost /= variant.craftedAmount
+		if craftCost < bestCraftCost {
+			bestCraftCost = craftCost
+			bestVariant = vi
+		}
+	}
+	craftPossible := bestVariant >= 0
+
+	switch {
+	case craftPossible && (!buyable || bestCraftCost <= buyCost):
+		v := node.variants[bestVariant]
+		return &costDecision{Method: "craft", UnitCost: bestCraftCost, CraftedAmount: v.craftedAmount, IngredientsPerBatch: v.ingredientsPerBatch, RecipeIndex: v.recipesIndex}
+	case buyable:
+		return &costDecision{Method: "buy", UnitCost: buyCost, RecipeIndex: -1}
+	default:
+		return &costDecision{Method: "buy", UnitCost: math.Inf(1), RecipeIndex: -1}
+	}
+}
+
+// BestCost returns itemID's pre-computed per-unit cost and "buy"/"craft"
+// decision, as resolved when this DAG was built. ok is false if itemID was
+// never reached while building it.
+func (d *BestCostDAG) BestCost(itemID string) (cost float64, method string, ok bool) {
+	dec, found := d.decisions[BAZAAR_ID(itemID)]
+	if !found {
+		return 0, "", false
+	}
+	return dec.UnitCost, dec.Method, true
+}
+
+// RecipePath returns which of itemID's Recipes[] entries resolveDecision
+// picked as cheapest (-1 if it was priced as "buy", or if the craft came
+// from the legacy single Recipe field rather than Recipes[]). ok is false
+// if itemID was never reached while building this DAG.
+func (d *BestCostDAG) RecipePath(itemID string) (recipeIndex int, ok bool) {
+	dec, found := d.decisions[BAZAAR_ID(itemID)]
+	if !found {
+		return -1, false
+	}
+	return dec.RecipeIndex, true
+}
+
+// BaseIngredients runs BestCostDAG's single linear pass (chunk19-3 step 4)
+// from itemID at quantity qty, following each item's pre-computed craft/buy
+// decision to accumulate the total quantity of every base (bought)
+// component needed - O(depth) of itemID's crafting chain, since every
+// item's decision was already resolved when the DAG was built rather than
+// re-derived on this call.
+func (d *BestCostDAG) BaseIngredients(itemID string, qty float64) (map[string]float64, error) {
+	base := make(map[string]float64)
+	var walk func(id string, amount float64) error
+	walk = func(id string, amount float64) error {
+		id = BAZAAR_ID(id)
+		dec, ok := d.decisions[id]
+		if !ok {
+			return fmt.Errorf("BaseIngredients: %s was not reached while building this DAG", id)
+		}
+		if dec.Method != "craft" {
+			base[id] += amount
+			return nil
+		}
+		numCrafts := math.Ceil(amount / dec.CraftedAmount)
+		for ing, perBatch := range dec.IngredientsPerBatch {
+			if err := walk(ing, snapQuantity(perBatch*numCrafts)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(itemID, qty); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// bestCostDAGMu/bestCostDAGCache memoize BuildBestCostDAG per
+// (rootID, apiResp.LastUpdated, itemFilesDir mtime) - chunk19-3's requested
+// cache key - so repeated BaseIngredients/BestCost queries against the same
+// bazaar snapshot and recipe directory share one O(n) build instead of
+// re-walking the graph per call.
+var (
+	bestCostDAGMu    sync.Mutex
+	bestCostDAGCache = make(map[string]*BestCostDAG)
+)
+
+func bestCostDAGCacheKey(rootID string, apiResp *HypixelAPIResponse, itemFilesDir string) string {
+	var lastUpdated int64
+	if apiResp != nil {
+		lastUpdated = apiResp.LastUpdated
+	}
+	var dirMTime int64
+	if info, err := os.Stat(itemFilesDir); err == nil {
+		dirMTime = info.ModTime().UnixNano()
+	}
+	return fmt.Sprintf("%s|%d|%d", BAZAAR_ID(rootID), lastUpdated, dirMTime)
+}
+
+// CachedBestCostDAG returns a memoized BestCostDAG for rootID, rebuilding it
+// only when apiResp.LastUpdated or itemFilesDir's mtime has moved since the
+// last build for this rootID.
+func CachedBestCostDAG(ctx context.Context, rootID string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string) (*BestCostDAG, error) {
+	key := bestCostDAGCacheKey(rootID, apiResp, itemFilesDir)
+
+	bestCostDAGMu.Lock()
+	if cached, ok := bestCostDAGCache[key]; ok {
+		bestCostDAGMu.Unlock()
+		return cached, nil
+	}
+	bestCostDAGMu.Unlock()
+
+	dag, err := BuildBestCostDAG(ctx, rootID, apiResp, metricsMap, itemFilesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rootPrefix := BAZAAR_ID(rootID) + "|"
+	bestCostDAGMu.Lock()
+	for k := range bestCostDAGCache {
+		if strings.HasPrefix(k, rootPrefix) {
+			delete(bestCostDAGCache, k)
+		}
+	}
+	bestCostDAGCache[key] = dag
+	bestCostDAGMu.Unlock()
+	return dag, nil
+}
@@ -0,0 +1,167 @@
+// cost_model.go
+package main
+
+import "math"
+
+// CostModel is the pluggable core of calculateC10MInternal's Primary (buy
+// order) path: given qty, the price a buy order would be placed under
+// (sellP), and pm's own order-flow metrics, it predicts what it actually
+// costs to acquire qty that way. calculateC10MInternal and getBestC10M both
+// default to HypixelTriangularModel, the original IF/RR/adjustment
+// heuristic, via a nil model parameter; passing a non-nil model (e.g.
+// PoissonQueueModel) swaps in an alternative without forking either
+// function. ifValue and rrValue are
+// reported alongside cost/adjustment purely for logging and for callers
+// like RunC10MBacktest that compare predicted RR against realized fill
+// rounds - a model with no natural notion of "insta-fills per cycle" is
+// free to report NaN for them.
+type CostModel interface {
+	EstimatePrimary(qty, sellP float64, pm ProductMetrics) (cost, ifValue, rrValue, adjustment float64, err error)
+}
+
+// HypixelTriangularModel is calculateC10MInternal's original Primary-path
+// heuristic, extracted so it can sit behind the CostModel interface: the
+// supply/demand delta ratio (s_s*s_f vs o_s*o_f) decides whether the order
+// fills in effectively one round or needs RR relist cycles, and a slow
+// fill is penalized by `extra = sellP*(qty*RR - IF*sumK)` scaled by
+// `adjustment = 1 - 1/RR` and, when C10MVolatilityConfig is enabled, by
+// pm's own rolling price dispersion. This is the zero-value default -
+// it holds no state - so callers can use HypixelTriangularModel{} directly.
+type HypixelTriangularModel struct{}
+
+func (HypixelTriangularModel) EstimatePrimary(qty, sellP float64, pm ProductMetrics) (cost, ifValue, rrValue, adjustment float64, err error) {
+	s_s := math.Max(0, pm.SellSize)
+	s_f := math.Max(0, pm.SellFrequency)
+	o_f := math.Max(0, pm.OrderFrequency)
+	o_s := math.Max(0, pm.OrderSize)
+
+	supplyRate := s_s * s_f
+	demandRate := o_s * o_f
+
+	var deltaRatio float64
+	if demandRate <= 0 {
+		if supplyRate <= 0 {
+			deltaRatio = 1.0
+		} else {
+			deltaRatio = math.Inf(1)
+		}
+	} else {
+		deltaRatio = supplyRate / demandRate
+	}
+
+	baseCost := qty * sellP
+
+	if deltaRatio > 1.0 {
+		return baseCost, math.Inf(1), 1.0, 0.0, nil
+	}
+
+	if o_f <= 0 {
+		ifValue = 0
+	} else {
+		ifValue = s_s * (s_f / o_f)
+	}
+	ifValue = math.Max(0, ifValue)
+
+	if ifValue <= 0 {
+		rrValue = math.Inf(1)
+	} else {
+		rrValue = math.Ceil(qty / ifValue)
+	}
+	if rrValue < 1 && !math.IsInf(rrValue, 1) {
+		rrValue = 1.0
+	}
+	if math.IsNaN(rrValue) {
+		rrValue = math.Inf(1)
+	}
+
+	if math.IsInf(rrValue, 1) {
+		return math.Inf(1), ifValue, rrValue, 0.0, nil
+	}
+
+	if rrValue <= 1.0 {
+		adjustment = 0.0
+	} else {
+		adjustment = 1.0 - (1.0 / rrValue)
+	}
+
+	var extraCalculatedPart float64
+	if adjustment > 0 {
+		RRint := int(math.Round(rrValue))
+		if RRint < 1 {
+			RRint = 1
+		}
+		sumK := float64(RRint*(RRint+1)) / 2.0
+		extraTerm := (qty * rrValue) - (ifValue * sumK)
+		extraCalculatedPart = sellP * math.Max(0, extraTerm)
+
+		if volCfg := getC10MVolatilityConfig(); volCfg.Enabled {
+			if mean, stddev, _, _, ok := pm.PriceStats(); ok && mean > 0 {
+				volMul := 1 + volCfg.K*(stddev/mean)
+				extraCalculatedPart *= volMul
+			}
+		}
+	}
+
+	cost = baseCost + (adjustment * extraCalculatedPart)
+	if math.IsInf(cost, 0) || math.IsNaN(cost) {
+		cost = math.Inf(1)
+	} else if cost < 0 {
+		cost = math.Max(baseCost, 0)
+	}
+	return cost, ifValue, rrValue, adjustment, nil
+}
+
+// PoissonQueueModel treats sell-side supply arrivals as a Poisson process
+// with rate s_s*s_f instead of HypixelTriangularModel's deterministic relist-cycle
+// count: expected fill time is `qty/(s_s*s_f) + z*sqrt(qty/(s_s*s_f^2))`,
+// a mean-plus-confidence-interval estimate of how long a buy order for qty
+// sits in the book given a sell-side arrival rate of s_s*s_f per unit
+// time. Z is the number of standard deviations of margin to budget for
+// (2 is a reasonable "95%-ish" default); CoinsPerHourRate converts that
+// fill-time estimate into an opportunity-cost premium on top of the raw
+// qty*sellP cost, the same way a trader would price in capital sitting
+// idle while an order waits to fill. ifValue/rrValue have no natural
+// meaning under this model and are reported as NaN.
+type PoissonQueueModel struct {
+	Z                float64
+	CoinsPerHourRate float64
+}
+
+func (m PoissonQueueModel) EstimatePrimary(qty, sellP float64, pm ProductMetrics) (cost, ifValue, rrValue, adjustment float64, err error) {
+	ifValue, rrValue = math.NaN(), math.NaN()
+
+	z := m.Z
+	if z <= 0 {
+		z = 2.0
+	}
+
+	baseCost := qty * sellP
+	s_s := math.Max(0, pm.SellSize)
+	s_f := math.Max(0, pm.SellFrequency)
+	arrivalRate := s_s * s_f // units of supply arriving per unit time
+
+	if arrivalRate <= 0 {
+		return math.Inf(1), ifValue, rrValue, 0.0, nil
+	}
+
+	meanFillTime := qty / arrivalRate
+	fillTimeStddev := math.Sqrt(qty / (arrivalRate * s_f))
+	expectedFillTime := meanFillTime + z*fillTimeStddev
+	if math.IsNaN(expectedFillTime) || math.IsInf(expectedFillTime, 0) || expectedFillTime < 0 {
+		return math.Inf(1), ifValue, rrValue, 0.0, nil
+	}
+
+	adjustment = 0.0
+	if meanFillTime > 0 {
+		adjustment = 1.0 - (meanFillTime / expectedFillTime)
+	}
+
+	premium := m.CoinsPerHourRate * (expectedFillTime / 3600.0)
+	cost = baseCost + math.Max(0, premium)
+	if math.IsInf(cost, 0) || math.IsNaN(cost) {
+		cost = math.Inf(1)
+	} else if cost < 0 {
+		cost = baseCost
+	}
+	return cost, ifValue, rrValue, adjustment, nil
+}
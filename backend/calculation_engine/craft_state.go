@@ -0,0 +1,291 @@
+// craft_state.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// bazaarTaxRate is Hypixel Skyblock's flat Bazaar instant-sell tax (the
+// "Bazaar Flipping Tax" Hypixel's wiki documents as 1.25% without Bazaar
+// Tax Free perks), used by calculateC10MForNode to approximate a level
+// cost's contribution to DailyFeeBudgets. This tree has no other tax-rate
+// constant to share, and the real rate depends on the player's own perks
+// (Bazaar Tax Free core stat, etc.) that aren't modeled anywhere in this
+// codebase, so this is a deliberately simple flat-rate approximation rather
+// than a config knob.
+const bazaarTaxRate = 0.0125
+
+// CraftState is a rolling 24-hour accumulator of realized (or simulated)
+// craft outcomes, keyed by item, mirroring the State-struct pattern of
+// bbgo's gap/xgap strategies: a small JSON-serializable snapshot that's
+// loaded on startup and saved on shutdown so a long-running session's daily
+// budgets survive a restart instead of resetting to zero.
+type CraftState struct {
+	mu sync.Mutex
+
+	// WindowStart is when the current 24-hour accumulation window began.
+	// IsOver24Hours reports whether it's time to zero the accumulators below
+	// and start a new window.
+	WindowStart time.Time `json:"window_start"`
+
+	AccumulatedFees   map[string]float64 `json:"accumulated_fees"`
+	AccumulatedVolume map[string]float64 `json:"accumulated_volume"`
+	AccumulatedProfit map[string]float64 `json:"accumulated_profit"`
+
+	// DailyMaxVolume and DailyFeeBudgets are per-item ceilings for this
+	// window; BudgetExhausted reports true once AccumulatedVolume or
+	// AccumulatedFees for an item reaches its entry here. A missing entry
+	// (or a non-positive one) means no cap for that item.
+	DailyMaxVolume  map[string]float64 `json:"daily_max_volume,omitempty"`
+	DailyFeeBudgets map[string]float64 `json:"daily_fee_budgets,omitempty"`
+
+	// AccumulatedCoinsSpent and DailyCoinBudget are PlaceOrderIfProfitable's
+	// own accumulator/cap pair (place_order.go): unlike AccumulatedVolume
+	// above (which tree_builder.go already overloads to mean "coins spent
+	// on this craft's ingredients", not a unit count), these track coins
+	// spent placing *orders* for the item itself. The two callers still share
+	// AccumulatedVolume: RecordOrderPlacement adds its unit quantity to it,
+	// so BudgetExhausted's DailyMaxVolume check sees both callers' totals.
+	AccumulatedCoinsSpent map[string]float64 `json:"accumulated_coins_spent,omitempty"`
+	DailyCoinBudget       map[string]float64 `json:"daily_coin_budget,omitempty"`
+}
+
+// NewCraftState returns an empty CraftState with its window starting now.
+func NewCraftState() *CraftState {
+	return &CraftState{
+		WindowStart:           time.Now(),
+		AccumulatedFees:       make(map[string]float64),
+		AccumulatedVolume:     make(map[string]float64),
+		AccumulatedProfit:     make(map[string]float64),
+		DailyMaxVolume:        make(map[string]float64),
+		DailyFeeBudgets:       make(map[string]float64),
+		AccumulatedCoinsSpent: make(map[string]float64),
+		DailyCoinBudget:       make(map[string]float64),
+	}
+}
+
+// LoadCraftState reads a CraftState previously written by Save at path. A
+// missing file is not an error - it returns a fresh NewCraftState, the same
+// way a first-ever run would start.
+func LoadCraftState(path string) (*CraftState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewCraftState(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading craft state '%s': %w", path, err)
+	}
+	var s CraftState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing craft state '%s': %w", path, err)
+	}
+	if s.AccumulatedFees == nil {
+		s.AccumulatedFees = make(map[string]float64)
+	}
+	if s.AccumulatedVolume == nil {
+		s.AccumulatedVolume = make(map[string]float64)
+	}
+	if s.AccumulatedProfit == nil {
+		s.AccumulatedProfit = make(map[string]float64)
+	}
+	if s.DailyMaxVolume == nil {
+		s.DailyMaxVolume = make(map[string]float64)
+	}
+	if s.DailyFeeBudgets == nil {
+		s.DailyFeeBudgets = make(map[string]float64)
+	}
+	if s.AccumulatedCoinsSpent == nil {
+		s.AccumulatedCoinsSpent = make(map[string]float64)
+	}
+	if s.DailyCoinBudget == nil {
+		s.DailyCoinBudget = make(map[string]float64)
+	}
+	return &s, nil
+}
+
+// Save atomically writes s to path (write to a sibling .tmp file, then
+// rename over path), the same write-then-rename pattern
+// SerialMetricsStore.Compact uses, so a crash mid-write can never leave path
+// holding a half-written file.
+func (s *CraftState) Save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding craft state: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing craft state '%s': %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replacing craft state '%s': %w", path, err)
+	}
+	return nil
+}
+
+// IsOver24Hours reports whether the current window started more than 24
+// hours ago.
+func (s *CraftState) IsOver24Hours() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.WindowStart) >= 24*time.Hour
+}
+
+// resetIfOver24Hours zeroes every accumulator and starts a new window when
+// IsOver24Hours would report true. Callers that mutate accumulators go
+// through RecordCraftOutcome instead, which does this automatically.
+func (s *CraftState) resetIfOver24Hours() {
+	if time.Since(s.WindowStart) < 24*time.Hour {
+		return
+	}
+	s.WindowStart = time.Now()
+	s.AccumulatedFees = make(map[string]float64)
+	s.AccumulatedVolume = make(map[string]float64)
+	s.AccumulatedProfit = make(map[string]float64)
+	s.AccumulatedCoinsSpent = make(map[string]float64)
+}
+
+// RecordCraftOutcome folds one craft's realized fee/volume/profit into
+// itemID's running totals, rolling the window over first if it's been more
+// than 24 hours since WindowStart.
+func (s *CraftState) RecordCraftOutcome(itemID string, fee, volume, profit float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetIfOver24Hours()
+	itemID = BAZAAR_ID(itemID)
+	s.AccumulatedFees[itemID] += fee
+	s.AccumulatedVolume[itemID] += volume
+	s.AccumulatedProfit[itemID] += profit
+}
+
+// BudgetExhausted reports whether itemID has used up its DailyMaxVolume or
+// DailyFeeBudgets allowance for the current window, rolling the window over
+// first if it's stale. expandIngredientsConcurrent consults this to
+// short-circuit expanding an ingredient whose Bazaar tax budget is spent
+// rather than pricing it anyway.
+func (s *CraftState) BudgetExhausted(itemID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetIfOver24Hours()
+	itemID = BAZAAR_ID(itemID)
+	if maxVolume, ok := s.DailyMaxVolume[itemID]; ok && maxVolume > 0 && s.AccumulatedVolume[itemID] >= maxVolume {
+		return true
+	}
+	if feeBudget, ok := s.DailyFeeBudgets[itemID]; ok && feeBudget > 0 && s.AccumulatedFees[itemID] >= feeBudget {
+		return true
+	}
+	return false
+}
+
+// RecordOrderPlacement folds one approved order placement into itemID's
+// running totals - PlaceOrderIfProfitable's (place_order.go) counterpart to
+// RecordCraftOutcome above, tracking coinsSpent/profit for the order itself
+// rather than a craft's ingredient costs.
+func (s *CraftState) RecordOrderPlacement(itemID string, quantity, coinsSpent, profit float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetIfOver24Hours()
+	itemID = BAZAAR_ID(itemID)
+	s.AccumulatedVolume[itemID] += quantity
+	s.AccumulatedCoinsSpent[itemID] += coinsSpent
+	s.AccumulatedProfit[itemID] += profit
+}
+
+// CoinBudgetExhausted reports whether itemID has used up its DailyCoinBudget
+// allowance for the current window, rolling the window over first if it's
+// stale. This is separate from BudgetExhausted since that one's
+// DailyMaxVolume is already spoken for by tree_builder.go's coin-cost usage.
+func (s *CraftState) CoinBudgetExhausted(itemID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetIfOver24Hours()
+	itemID = BAZAAR_ID(itemID)
+	budget, ok := s.DailyCoinBudget[itemID]
+	return ok && budget > 0 && s.AccumulatedCoinsSpent[itemID] >= budget
+}
+
+// CraftStateSnapshot is a plain-value copy of CraftState's fields, safe to
+// json.Marshal without racing Save/RecordCraftOutcome/RecordOrderPlacement's
+// mutex - see Snapshot.
+type CraftStateSnapshot struct {
+	WindowStart           time.Time          `json:"window_start"`
+	AccumulatedFees       map[string]float64 `json:"accumulated_fees"`
+	AccumulatedVolume     map[string]float64 `json:"accumulated_volume"`
+	AccumulatedProfit     map[string]float64 `json:"accumulated_profit"`
+	AccumulatedCoinsSpent map[string]float64 `json:"accumulated_coins_spent"`
+	DailyMaxVolume        map[string]float64 `json:"daily_max_volume,omitempty"`
+	DailyFeeBudgets       map[string]float64 `json:"daily_fee_budgets,omitempty"`
+	DailyCoinBudget       map[string]float64 `json:"daily_coin_budget,omitempty"`
+}
+
+// Snapshot returns a lock-guarded copy of s for a caller (e.g.
+// place_order.go's inspection endpoint) that wants to serialize the current
+// state without holding s.mu itself.
+func (s *CraftState) Snapshot() CraftStateSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copyMap := func(m map[string]float64) map[string]float64 {
+		out := make(map[string]float64, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	}
+	return CraftStateSnapshot{
+		WindowStart:           s.WindowStart,
+		AccumulatedFees:       copyMap(s.AccumulatedFees),
+		AccumulatedVolume:     copyMap(s.AccumulatedVolume),
+		AccumulatedProfit:     copyMap(s.AccumulatedProfit),
+		AccumulatedCoinsSpent: copyMap(s.AccumulatedCoinsSpent),
+		DailyMaxVolume:        copyMap(s.DailyMaxVolume),
+		DailyFeeBudgets:       copyMap(s.DailyFeeBudgets),
+		DailyCoinBudget:       copyMap(s.DailyCoinBudget),
+	}
+}
+
+var (
+	defaultCraftState     *CraftState
+	defaultCraftStateOnce sync.Once
+	defaultCraftStateErr  error
+)
+
+// DefaultCraftStatePath is where DefaultCraftState loads from and the
+// process's shutdown hook (see main.go) saves to, overridable before the
+// first call the same way SerialMetricsDir is.
+var DefaultCraftStatePath = "craft_state.json"
+
+// DefaultCraftState lazily loads the package-wide CraftState from
+// DefaultCraftStatePath, for callers (tree_builder.go's ingredient
+// expansion) that don't hold a CraftState reference of their own.
+func DefaultCraftState() (*CraftState, error) {
+	defaultCraftStateOnce.Do(func() {
+		defaultCraftState, defaultCraftStateErr = LoadCraftState(DefaultCraftStatePath)
+	})
+	return defaultCraftState, defaultCraftStateErr
+}
+
+// watchForShutdownAndSaveCraftState blocks until the process receives
+// SIGINT/SIGTERM, saves DefaultCraftState to DefaultCraftStatePath, then
+// exits - mirroring watchForShutdownAndSaveMetricsHistory so the same
+// long-running session that would otherwise lose its rolling history on
+// restart also keeps its daily craft budgets continuous.
+func watchForShutdownAndSaveCraftState() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	if craftState, err := DefaultCraftState(); err == nil {
+		if err := craftState.Save(DefaultCraftStatePath); err != nil {
+			log.Printf("CraftState: failed to save '%s' on shutdown: %v", DefaultCraftStatePath, err)
+		}
+	}
+	os.Exit(0)
+}
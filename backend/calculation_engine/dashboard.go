@@ -0,0 +1,296 @@
+// dashboard.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dashboardTemplates holds every dashboard view, parsed once at startup
+// (mirroring the "parse templates once into a *template.Template" pattern
+// from the FFXIV/Universalis tool) rather than per-request.
+var dashboardTemplates = template.Must(template.New("dashboard").Parse(dashboardTemplateSource))
+
+const dashboardTemplateSource = `
+{{define "item"}}
+<!DOCTYPE html>
+<html><head><title>{{.ItemName}}</title></head><body>
+<h1>{{.ItemName}} x {{printf "%.0f" .Quantity}}</h1>
+{{range $persp, $res := .Perspectives}}
+<h2>{{$persp}}</h2>
+<p>Action: {{$res.TopLevelAction}} &middot; Method: {{$res.FinalCostMethod}} &middot; Possible: {{$res.CalculationPossible}}</p>
+<p>Total Cost: {{$res.TotalCost}} &middot; Slowest Ingredient: {{$res.SlowestIngredientName}} ({{printf "%.2f" $res.SlowestIngredientBuyTimeSeconds}}s)</p>
+<table border="1"><tr><th>Ingredient</th><th>Qty</th><th>Method</th><th>Best Cost</th></tr>
+{{range $id, $d := $res.BaseIngredients}}<tr><td>{{$id}}</td><td>{{printf "%.2f" $d.Quantity}}</td><td>{{$d.Method}}</td><td>{{$d.BestCost}}</td></tr>{{end}}
+</table>
+{{end}}
+</body></html>
+{{end}}
+
+{{define "list"}}
+<!DOCTYPE html>
+<html><head><title>Item Rankings</title></head><body>
+<h1>Item Rankings ({{.Metric}})</h1>
+<table border="1"><tr><th>Rank</th><th>Item</th><th>Profit</th><th>Delta</th><th>Capital</th><th>Rank Δ</th></tr>
+{{range .Ranks}}<tr><td>{{.Rank}}</td><td>{{.ItemID}}</td><td>{{printf "%.2f" .Profit}}</td><td>{{printf "%.2f" .Delta}}</td><td>{{printf "%.2f" .Capital}}</td><td>{{.RankDelta}}</td></tr>{{end}}
+</table>
+</body></html>
+{{end}}
+
+{{define "status"}}
+<!DOCTYPE html>
+<html><head><title>Refresh Status</title></head><body>
+<h1>Refresh Subsystem Status</h1>
+<p>Price update progress: {{.PriceUpdateProgress}}%</p>
+<p>Started: {{.StartFullPriceUpdateTime}}</p>
+<p>Last completed: {{.LastFullPriceUpdateTime}}</p>
+<p>Items tracked in rankings: {{.RankedItemCount}}</p>
+</body></html>
+{{end}}
+`
+
+// rankMetricFunc scores one ItemRank for the listing view's pluggable-metric
+// query param; the default "profit" simply reads back whatever ProfitMetric
+// the backing ProfitRanker was configured with.
+type rankMetricFunc func(r ItemRank) float64
+
+var dashboardRankMetrics = map[string]rankMetricFunc{
+	"profit":  func(r ItemRank) float64 { return r.Profit },
+	"capital": func(r ItemRank) float64 { return r.Capital },
+	"delta":   func(r ItemRank) float64 { return r.Delta },
+}
+
+// dashboardRanker is the shared ProfitRanker backing the /dashboard/list
+// view: Refresh does the O(N log N) sort, and is expected to be driven by a
+// background loop (see ProfitRanker.Start); the HTTP handler below only ever
+// reads the already-sorted Current() slice, so a request costs O(N) for its
+// filters, plus an O(N log N) re-sort of a copy when an alternate metric
+// query param is given.
+var dashboardRanker = NewProfitRanker(defaultItemFilesDir, MetricMargin, 1, RankFilter{})
+
+// dashboardMarketStore backs the /dashboard/status view's progress/timing
+// fields; a deployment that wires up MarketDataStore's worker pools
+// (marketstore.go) for real price refreshes would use the same instance
+// there instead of the package-level getApiResponse/getMetricsMapFromFile
+// globals used by the other dashboard handlers.
+var dashboardMarketStore = NewMarketDataStore(1024, 4, 10)
+
+// defaultItemDashboardTimeout bounds how long itemDashboardHandler will let
+// PerformDualExpansion run against a deep or pathological recipe before
+// giving up, absent an explicit ?timeout= override.
+const defaultItemDashboardTimeout = 20 * time.Second
+
+// maxItemDashboardTimeout caps the ?timeout= query override so a client
+// can't pin a goroutine open indefinitely.
+const maxItemDashboardTimeout = 2 * time.Minute
+
+// requestTimeout parses the ?timeout= query parameter (seconds) against
+// def/max, falling back to def when absent or unparseable.
+func requestTimeout(r *http.Request, def, max time.Duration) time.Duration {
+	raw := r.URL.Query().Get("timeout")
+	if raw == "" {
+		return def
+	}
+	secs, err := strconv.ParseFloat(raw, 64)
+	if err != nil || secs <= 0 {
+		return def
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// writeExpansionTimeoutOrError reports errExpand as a structured JSON error.
+// A context cancellation/deadline gets 499 (client disconnected, the nginx
+// convention) or 504 (our own timeout fired first); anything else is a
+// plain 500, mirroring the rest of the package's error handling.
+func writeExpansionTimeoutOrError(w http.ResponseWriter, errExpand error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(errExpand, context.Canceled):
+		status = 499
+	case errors.Is(errExpand, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: "expansion failed: " + errString(errExpand)})
+}
+
+// itemDashboardHandler renders a single item's PerformDualExpansion output
+// via the "item" template. Query params: item (required), qty (default 1),
+// timeout (seconds, default defaultItemDashboardTimeout) — once it elapses
+// or the client disconnects, the in-flight expansion is cancelled instead
+// of running a deep recipe to completion for nobody — and precision
+// (float|decimal, default float), which opts the cost summation into
+// Coins-based fixed-point accumulation (see PrecisionMode) for clients
+// rolling off the legacy plain-float64 totals.
+func itemDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		m := DefaultMetrics(nil)
+		m.FillRequestsTotal.WithLabelValues(status).Inc()
+		m.FillDurationSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	itemName := r.URL.Query().Get("item")
+	if itemName == "" {
+		status = "error"
+		http.Error(w, "missing 'item' query parameter", http.StatusBadRequest)
+		return
+	}
+	qty := 1.0
+	if raw := r.URL.Query().Get("qty"); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			qty = parsed
+		}
+	}
+
+	precision := parsePrecisionMode(r.URL.Query().Get("precision"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r, defaultItemDashboardTimeout, maxItemDashboardTimeout))
+	defer cancel()
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		status = "error"
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	dual, err := PerformDualExpansion(ctx, itemName, qty, apiResp, metricsMap, defaultItemFilesDir, true, precision, ExpansionOptions{})
+	if err != nil || dual == nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			status = "cancelled"
+		case errors.Is(err, context.DeadlineExceeded):
+			status = "timeout"
+		default:
+			status = "error"
+		}
+		writeExpansionTimeoutOrError(w, err)
+		return
+	}
+	if dual.PrimaryBased.RecipeTree != nil {
+		DefaultMetrics(nil).ExpandDepth.Observe(float64(dual.PrimaryBased.RecipeTree.MaxSubTreeDepth))
+	}
+
+	data := struct {
+		ItemName     string
+		Quantity     float64
+		Perspectives map[string]ExpansionResult
+	}{
+		ItemName: dual.ItemName,
+		Quantity: dual.Quantity,
+		Perspectives: map[string]ExpansionResult{
+			"PrimaryBased":   dual.PrimaryBased,
+			"SecondaryBased": dual.SecondaryBased,
+			"SellBased":      dual.SellBased,
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, "item", data); err != nil {
+		log.Printf("itemDashboardHandler: template execute failed: %v", err)
+	}
+}
+
+// listDashboardHandler renders the cached ranking via the "list" template.
+// Query params: metric (profit/capital/delta, default the ranker's own
+// metric), positive_delta (bool), min_capital (float).
+func listDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	ranks := dashboardRanker.Current()
+
+	q := r.URL.Query()
+	if raw := q.Get("positive_delta"); raw == "true" {
+		filtered := ranks[:0:0]
+		for _, rk := range ranks {
+			if rk.Delta > 0 {
+				filtered = append(filtered, rk)
+			}
+		}
+		ranks = filtered
+	}
+	if raw := q.Get("min_capital"); raw != "" {
+		if minCapital, err := strconv.ParseFloat(raw, 64); err == nil {
+			filtered := ranks[:0:0]
+			for _, rk := range ranks {
+				if rk.Capital >= minCapital {
+					filtered = append(filtered, rk)
+				}
+			}
+			ranks = filtered
+		}
+	}
+
+	metricName := string(dashboardRanker.Metric)
+	if raw := q.Get("metric"); raw != "" {
+		if fn, ok := dashboardRankMetrics[raw]; ok {
+			metricName = raw
+			sorted := append([]ItemRank{}, ranks...)
+			sort.Slice(sorted, func(i, j int) bool { return fn(sorted[i]) > fn(sorted[j]) })
+			ranks = sorted
+		}
+	}
+
+	data := struct {
+		Metric string
+		Ranks  []ItemRank
+	}{Metric: metricName, Ranks: ranks}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, "list", data); err != nil {
+		log.Printf("listDashboardHandler: template execute failed: %v", err)
+	}
+}
+
+// statusDashboardHandler renders the refresh subsystem's progress and last
+// update times via the "status" template.
+func statusDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		PriceUpdateProgress      int
+		StartFullPriceUpdateTime string
+		LastFullPriceUpdateTime  string
+		RankedItemCount          int
+	}{
+		PriceUpdateProgress:      dashboardMarketStore.PriceUpdateProgress(),
+		StartFullPriceUpdateTime: formatTimeOrNever(dashboardMarketStore.StartFullPriceUpdateTime),
+		LastFullPriceUpdateTime:  formatTimeOrNever(dashboardMarketStore.LastFullPriceUpdateTime),
+		RankedItemCount:          len(dashboardRanker.Current()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, "status", data); err != nil {
+		log.Printf("statusDashboardHandler: template execute failed: %v", err)
+	}
+}
+
+func formatTimeOrNever(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "unknown error"
+	}
+	return err.Error()
+}
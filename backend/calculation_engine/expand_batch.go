@@ -0,0 +1,165 @@
+// expand_batch.go
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// ExpansionRequest is one row of a programmatic batch request to
+// ExpandDualBasedBatch - the portfolio-sweep equivalent of
+// CalculateBatchItem (calculate_batch.go), which is this package's HTTP-body
+// version of the same shape.
+type ExpansionRequest struct {
+	ItemName    string
+	Quantity    float64
+	IncludeTree bool
+}
+
+// BatchOptions configures ExpandDualBasedBatch's worker pool, per-item
+// timeout, and progress reporting, mirroring ExpansionOptions' role for a
+// single PerformDualExpansion call.
+type BatchOptions struct {
+	// Workers caps how many items are expanded concurrently; <= 0 falls back
+	// to defaultBatchConcurrency (calculate_batch.go).
+	Workers int
+	// PerItemTimeout bounds each individual item's expansion, independent of
+	// ctx's own deadline; <= 0 means no per-item limit beyond ctx.
+	PerItemTimeout time.Duration
+	// Precision and ExpansionOptions are passed through to every
+	// PerformDualExpansion call in the batch.
+	Precision        PrecisionMode
+	ExpansionOptions ExpansionOptions
+	// Progress, if set, is called after each item finishes with the number
+	// done so far and the batch's total size, serialized so concurrent
+	// completions never call it twice at once.
+	Progress func(done, total int)
+	// ItemFilesDir is where recipe JSON files are read from; "" falls back
+	// to defaultItemFilesDir.
+	ItemFilesDir string
+}
+
+// ExpandDualBasedBatch runs PerformDualExpansion for every request
+// concurrently across a bounded worker pool (opts.Workers), sharing one
+// recipeMemo across the whole batch (contextWithSharedRecipeMemo) so items
+// whose recipes share a common sub-ingredient only expand that ingredient
+// once instead of once per top-level item, plus a batchExpansionMemo so a
+// literally repeated (item, quantity) pair is also only expanded once - the
+// same two dedup layers calculateBatchHandler already uses for its HTTP
+// counterpart, just combined here for a caller that isn't going through an
+// HTTP request.
+//
+// Results come back in the same order as requests; an item whose own
+// expansion failed or timed out still gets a DualExpansionResult (with
+// ErrorMessage set on both perspectives) rather than a zero entry, so
+// len(results) == len(requests) always holds. The returned error is non-nil
+// only when ctx was already cancelled or expired before any item could run.
+func ExpandDualBasedBatch(ctx context.Context, requests []ExpansionRequest, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, opts BatchOptions) ([]DualExpansionResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	itemFilesDir := opts.ItemFilesDir
+	if itemFilesDir == "" {
+		itemFilesDir = defaultItemFilesDir
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultBatchConcurrency
+	}
+
+	batchCtx := contextWithSharedRecipeMemo(ctx, newRecipeMemo())
+	memo := newBatchExpansionMemo()
+	sem := make(chan struct{}, workers)
+
+	results := make([]DualExpansionResult, len(requests))
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	done := 0
+
+	for i, req := range requests {
+		quantity := req.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		itemID := BAZAAR_ID(req.ItemName)
+
+		wg.Add(1)
+		go func(idx int, itemID string, quantity float64, includeTree bool) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-batchCtx.Done():
+				results[idx] = errorDualExpansionResult(itemID, quantity, batchCtx.Err())
+				reportBatchProgress(opts.Progress, &progressMu, &done, len(requests))
+				return
+			}
+
+			itemCtx := batchCtx
+			if opts.PerItemTimeout > 0 {
+				var cancel context.CancelFunc
+				itemCtx, cancel = context.WithTimeout(batchCtx, opts.PerItemTimeout)
+				defer cancel()
+			}
+
+			key := batchMemoKey{id: itemID, qty: quantity}
+			entry, owner := memo.claim(key)
+			if owner {
+				dual, err := PerformDualExpansion(itemCtx, itemID, quantity, apiResp, metricsMap, itemFilesDir, includeTree, opts.Precision, opts.ExpansionOptions)
+				entry.finish(dual, err)
+			}
+			dual, err := entry.wait()
+			if err != nil || dual == nil {
+				results[idx] = errorDualExpansionResult(itemID, quantity, err)
+			} else {
+				results[idx] = *dual
+			}
+
+			reportBatchProgress(opts.Progress, &progressMu, &done, len(requests))
+		}(i, itemID, quantity, req.IncludeTree)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// reportBatchProgress increments *done and invokes progress (if set) under
+// mu, so two goroutines finishing at once can't interleave their calls or
+// race on done.
+func reportBatchProgress(progress func(done, total int), mu *sync.Mutex, done *int, total int) {
+	if progress == nil {
+		return
+	}
+	mu.Lock()
+	*done++
+	progress(*done, total)
+	mu.Unlock()
+}
+
+// errorDualExpansionResult builds a DualExpansionResult reporting err on
+// both perspectives, for a batch item that failed or was cancelled before
+// PerformDualExpansion could return one of its own.
+func errorDualExpansionResult(itemID string, quantity float64, err error) DualExpansionResult {
+	msg := errString(err)
+	nanResult := func(perspective string) ExpansionResult {
+		return ExpansionResult{
+			PerspectiveType: perspective, ErrorMessage: msg, CalculationPossible: false,
+			TotalCost: toJSONFloat64(math.NaN()), TopLevelCost: toJSONFloat64(math.NaN()), TopLevelRR: toJSONFloat64(math.NaN()),
+			SlowestIngredientBuyTimeSeconds: toJSONFloat64(math.NaN()),
+		}
+	}
+	return DualExpansionResult{
+		ItemName:                     itemID,
+		Quantity:                     quantity,
+		PrimaryBased:                 nanResult("PrimaryBased"),
+		SecondaryBased:               nanResult("SecondaryBased"),
+		TopLevelInstasellTimeSeconds: toJSONFloat64(math.NaN()),
+	}
+}
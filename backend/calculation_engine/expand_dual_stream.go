@@ -0,0 +1,104 @@
+// expand_dual_stream.go
+package main
+
+// GET /api/expand-dual/stream?item=<ITEM>&quantity=<Q> is the per-item,
+// within-expansion counterpart to /api/expand-dual/jobs/{id}/stream
+// (expand_job.go): that endpoint's heartbeats only report coarse job status
+// because PerformDualExpansion itself has no progress callback to poll.
+// ExpandDualBasedStream fixes that at the source - PerformDualExpansion
+// emits an ExpansionEvent onto a channel as it reaches each milestone - so
+// this handler can push one SSE "event" frame per milestone instead of one
+// per heartbeat tick, then a final "result" frame once the expansion
+// finishes.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ExpandDualBasedStream runs PerformDualExpansion for req, emitting an
+// ExpansionEvent onto events as each major milestone completes (Craft/
+// Primary evaluation, the P1/P2 decisions, and each freshly-expanded
+// sub-tree node via expandItemRecursiveTree), then a final Done event,
+// closing events once PerformDualExpansion returns. events is always
+// closed exactly once, even if PerformDualExpansion errors or ctx is
+// cancelled partway through, so a caller ranging over it always terminates.
+func ExpandDualBasedStream(ctx context.Context, req ExpandRequest, events chan<- ExpansionEvent) (*DualExpansionResult, error) {
+	defer close(events)
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		return nil, err
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	result, expandErr := PerformDualExpansion(ctx, req.ItemName, req.Quantity, apiResp, metricsMap, defaultItemFilesDir, true, PrecisionFloat, ExpansionOptions{Events: events, MaxMetricsAgeSecs: req.MaxAgeSecs})
+
+	emitExpansionEvent(contextWithEventSink(ctx, events), ExpansionEvent{Type: EventDone})
+	return result, expandErr
+}
+
+// expandDualStreamResult is the final SSE frame ExpandDualBasedStream's
+// handler writes, mirroring CalculationResult's Result/ErrorMessage shape
+// (calculate_batch.go) for the one item this endpoint streams.
+type expandDualStreamResult struct {
+	Result       *DualExpansionResult `json:"result,omitempty"`
+	ErrorMessage string               `json:"error_message,omitempty"`
+}
+
+// expandDualStreamHandler serves GET /api/expand-dual/stream: it streams
+// every ExpansionEvent ExpandDualBasedStream emits as its own "event: event"
+// SSE frame, then writes the final DualExpansionResult (or error) as one
+// "event: result" frame before the connection closes.
+func expandDualStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	itemName := r.URL.Query().Get("item")
+	quantity, parseErr := strconv.ParseFloat(r.URL.Query().Get("quantity"), 64)
+	if itemName == "" || parseErr != nil || quantity <= 0 {
+		http.Error(w, "'item' query param and a positive 'quantity' are required", http.StatusBadRequest)
+		return
+	}
+	maxAgeSecs := queryFloatDefault(r, "max_age_secs", 0)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	events := make(chan ExpansionEvent)
+	resultCh := make(chan expandDualStreamResult, 1)
+	go func() {
+		result, expandErr := ExpandDualBasedStream(r.Context(), ExpandRequest{ItemName: itemName, Quantity: quantity, MaxAgeSecs: maxAgeSecs}, events)
+		final := expandDualStreamResult{Result: result}
+		if expandErr != nil {
+			final.ErrorMessage = expandErr.Error()
+		}
+		resultCh <- final
+	}()
+
+	for event := range events {
+		data, marshalErr := json.Marshal(event)
+		if marshalErr != nil {
+			continue
+		}
+		fmt.Fprintf(w, "event: event\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	final := <-resultCh
+	data, marshalErr := json.Marshal(final)
+	if marshalErr != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+	flusher.Flush()
+}
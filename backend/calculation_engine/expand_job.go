@@ -0,0 +1,293 @@
+// expand_job.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExpandJobStatus is the lifecycle state of one expandJob.
+type ExpandJobStatus string
+
+const (
+	ExpandJobQueued  ExpandJobStatus = "queued"
+	ExpandJobRunning ExpandJobStatus = "running"
+	ExpandJobDone    ExpandJobStatus = "done"
+	ExpandJobError   ExpandJobStatus = "error"
+)
+
+// ExpandProgressEvent is one NDJSON line of an in-progress expand job's
+// stream. ItemsProcessed approximates activity via the package-wide
+// in-flight-item tracker (staleness.go's trackInFlightItem/inFlightItemIDs)
+// since PerformDualExpansion has no per-job progress callback; Depth is 0 in
+// every heartbeat, since MaxSubTreeDepth is only known once the RecipeTree is
+// fully built and the stream's final frame is the job's StreamRecord, not
+// another ExpandProgressEvent.
+type ExpandProgressEvent struct {
+	Stage          string `json:"stage"`
+	ItemsProcessed int    `json:"items_processed"`
+	Depth          int    `json:"depth"`
+}
+
+// expandJob is one /api/expand-dual/jobs submission. PerformDualExpansion
+// runs in its own goroutine (startExpandJob); streamExpandJob and
+// expandJobHandler read status/record under mu, and done is closed
+// exactly once, when the goroutine finishes.
+type expandJob struct {
+	ID        string
+	ItemName  string
+	Quantity  float64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	done      chan struct{}
+
+	mu     sync.Mutex
+	status ExpandJobStatus
+	record StreamRecord
+}
+
+func (j *expandJob) snapshot() (ExpandJobStatus, StreamRecord) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, j.record
+}
+
+func (j *expandJob) setRunning() {
+	j.mu.Lock()
+	j.status = ExpandJobRunning
+	j.mu.Unlock()
+}
+
+func (j *expandJob) finish(record StreamRecord) {
+	j.mu.Lock()
+	j.record = record
+	if record.ErrorMessage != "" && record.Result == nil {
+		j.status = ExpandJobError
+	} else {
+		j.status = ExpandJobDone
+	}
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// expandJobTTL bounds how long a finished (or abandoned) job's result stays
+// in expandJobRegistry before sweepExpiredExpandJobsLocked reclaims it.
+const expandJobTTL = 15 * time.Minute
+
+var expandJobRegistry = struct {
+	mu   sync.Mutex
+	jobs map[string]*expandJob
+}{jobs: make(map[string]*expandJob)}
+
+var expandJobSeq uint64
+
+// nextExpandJobID returns a process-unique job ID; the nanosecond timestamp
+// prefix keeps IDs roughly sortable by creation time, the counter suffix
+// disambiguates two jobs submitted within the same tick.
+func nextExpandJobID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&expandJobSeq, 1))
+}
+
+// sweepExpiredExpandJobsLocked drops every job past its ExpiresAt. Called
+// with expandJobRegistry.mu held, on every registration, so the registry
+// never grows unbounded purely from jobs nobody ever polls again.
+func sweepExpiredExpandJobsLocked() {
+	now := time.Now()
+	for id, job := range expandJobRegistry.jobs {
+		if now.After(job.ExpiresAt) {
+			delete(expandJobRegistry.jobs, id)
+		}
+	}
+}
+
+func registerExpandJob(job *expandJob) {
+	expandJobRegistry.mu.Lock()
+	defer expandJobRegistry.mu.Unlock()
+	sweepExpiredExpandJobsLocked()
+	expandJobRegistry.jobs[job.ID] = job
+}
+
+func getExpandJob(id string) *expandJob {
+	expandJobRegistry.mu.Lock()
+	defer expandJobRegistry.mu.Unlock()
+	return expandJobRegistry.jobs[id]
+}
+
+// startExpandJob registers a new job and kicks off PerformDualExpansion in
+// its own goroutine, mirroring ExpandDualStream's worker body but for a
+// single item tracked by ID rather than a batch fed through a channel.
+// maxAgeSecs is forwarded to ExpansionOptions.MaxMetricsAgeSecs; <= 0 falls
+// back to defaultMaxMetricsAgeSecs (staleness.go).
+func startExpandJob(itemName string, quantity float64, maxAgeSecs float64) *expandJob {
+	job := &expandJob{
+		ID:        nextExpandJobID(),
+		ItemName:  itemName,
+		Quantity:  quantity,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(expandJobTTL),
+		status:    ExpandJobQueued,
+		done:      make(chan struct{}),
+	}
+	registerExpandJob(job)
+
+	go func() {
+		job.setRunning()
+		start := time.Now()
+		status := "ok"
+		defer func() {
+			m := DefaultMetrics(nil)
+			m.FillRequestsTotal.WithLabelValues(status).Inc()
+			m.FillDurationSeconds.Observe(time.Since(start).Seconds())
+		}()
+
+		apiResp, err := WaitForFreshData()
+		if err != nil && !errors.Is(err, ErrStale) {
+			status = "error"
+			job.finish(StreamRecord{ItemName: itemName, Quantity: quantity, ErrorMessage: "bazaar data unavailable: " + err.Error()})
+			return
+		}
+		metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+		result, expandErr := PerformDualExpansion(context.Background(), itemName, quantity, apiResp, metricsMap, defaultItemFilesDir, true, PrecisionFloat, ExpansionOptions{MaxMetricsAgeSecs: maxAgeSecs})
+		if result != nil && result.PrimaryBased.RecipeTree != nil {
+			DefaultMetrics(nil).ExpandDepth.Observe(float64(result.PrimaryBased.RecipeTree.MaxSubTreeDepth))
+		}
+		record := StreamRecord{
+			ItemName:       itemName,
+			Quantity:       quantity,
+			Result:         result,
+			DurationMillis: time.Since(start).Milliseconds(),
+			Trace:          buildTrace(result),
+		}
+		if expandErr != nil {
+			status = "error"
+			record.ErrorMessage = expandErr.Error()
+		}
+		job.finish(record)
+	}()
+
+	return job
+}
+
+// ExpandJobSnapshot is GET /api/expand-dual/jobs/{id}'s response: the job's
+// current status, plus its StreamRecord once it has left ExpandJobRunning.
+type ExpandJobSnapshot struct {
+	JobID  string          `json:"job_id"`
+	Status ExpandJobStatus `json:"status"`
+	Record *StreamRecord   `json:"record,omitempty"`
+}
+
+// expandJobsHandler implements POST /api/expand-dual/jobs: it starts a
+// background expansion and returns the new job's ID immediately, instead of
+// blocking the request until PerformDualExpansion completes.
+func expandJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ExpandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ItemName == "" || req.Quantity <= 0 {
+		http.Error(w, "'item' and a positive 'quantity' are required", http.StatusBadRequest)
+		return
+	}
+
+	maxAgeSecs := queryFloatDefault(r, "max_age_secs", req.MaxAgeSecs)
+	job := startExpandJob(req.ItemName, req.Quantity, maxAgeSecs)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		JobID string `json:"job_id"`
+	}{JobID: job.ID})
+}
+
+// expandJobHandler implements the two GET routes nested under a job ID:
+// /api/expand-dual/jobs/{id} (snapshot) and
+// /api/expand-dual/jobs/{id}/stream (NDJSON progress + final record).
+func expandJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/expand-dual/jobs/")
+	stream := strings.HasSuffix(rest, "/stream")
+	jobID := strings.TrimSuffix(rest, "/stream")
+	if jobID == "" {
+		http.Error(w, "missing job id", http.StatusBadRequest)
+		return
+	}
+
+	job := getExpandJob(jobID)
+	if job == nil {
+		http.Error(w, "job not found (expired or never existed)", http.StatusNotFound)
+		return
+	}
+
+	if stream {
+		streamExpandJob(w, r, job)
+		return
+	}
+
+	status, record := job.snapshot()
+	snapshot := ExpandJobSnapshot{JobID: job.ID, Status: status}
+	if status == ExpandJobDone || status == ExpandJobError {
+		snapshot.Record = &record
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// expandJobProgressInterval is how often streamExpandJob emits a heartbeat
+// ExpandProgressEvent while a job is still queued/running.
+const expandJobProgressInterval = 500 * time.Millisecond
+
+// streamExpandJob writes NDJSON ExpandProgressEvent heartbeats until job
+// finishes (or the client disconnects), then writes one final StreamRecord
+// frame, the same shape bulkExpansionHandler emits per row.
+func streamExpandJob(w http.ResponseWriter, r *http.Request, job *expandJob) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+
+	ticker := time.NewTicker(expandJobProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-job.done:
+			_, record := job.snapshot()
+			if err := encoder.Encode(record); err != nil {
+				log.Printf("streamExpandJob: final frame write failed for job %s: %v", job.ID, err)
+			}
+			flusher.Flush()
+			return
+		case <-ticker.C:
+			status, _ := job.snapshot()
+			event := ExpandProgressEvent{Stage: string(status), ItemsProcessed: len(inFlightItemIDs())}
+			if err := encoder.Encode(event); err != nil {
+				log.Printf("streamExpandJob: progress write failed for job %s: %v", job.ID, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
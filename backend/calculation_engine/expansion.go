@@ -2,11 +2,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // valueOrNaN returns float64, using NaN for Inf/error states.
@@ -28,6 +35,29 @@ type BaseIngredientDetail struct {
 	RR             JSONFloat64 `json:"rr,omitempty"`
 	IF             JSONFloat64 `json:"if,omitempty"`
 	Delta          JSONFloat64 `json:"delta,omitempty"`
+	// MetricsAgeSeconds/Confidence describe how stale the ProductMetrics
+	// behind this ingredient's acquisition were at calculation time; see
+	// metricsAgeAndConfidence in staleness.go. ConfidenceLevel is the same
+	// age bucketed against opts.MaxMetricsAgeSecs via confidenceLevelFor, for
+	// callers that want a quick fresh/stale/missing read instead of
+	// thresholding the raw float themselves.
+	MetricsAgeSeconds JSONFloat64     `json:"metrics_age_seconds,omitempty"`
+	Confidence        JSONFloat64     `json:"confidence,omitempty"`
+	ConfidenceLevel   ConfidenceLevel `json:"confidence_level,omitempty"`
+	// PriceStdDev/BestCostP95/BestCostP05 turn BestCost from a point estimate
+	// into a pessimistic/optimistic band, sourced from
+	// DefaultPriceHistoryStore's Welford-computed InstabuyStdDev/
+	// InstasellStdDev (price_history_store.go) over
+	// baseIngredientVolatilityWindow; omitted when that store has fewer than
+	// two samples for this ingredient. See priceBaseIngredient.
+	PriceStdDev JSONFloat64 `json:"price_stddev,omitempty"`
+	BestCostP95 JSONFloat64 `json:"best_cost_p95,omitempty"`
+	BestCostP05 JSONFloat64 `json:"best_cost_p05,omitempty"`
+	// EffectiveCost blends BestCost with this ingredient's own fill time via
+	// opts.effectiveCost (ExpansionOptions.TimeValueCoefficient) - equal to
+	// BestCost when TimeValueCoefficient is left at zero. Set by
+	// priceBaseIngredient.
+	EffectiveCost JSONFloat64 `json:"effective_cost,omitempty"`
 }
 
 type DualExpansionResult struct {
@@ -36,22 +66,215 @@ type DualExpansionResult struct {
 	PrimaryBased                 ExpansionResult `json:"primary_based"`
 	SecondaryBased               ExpansionResult `json:"secondary_based"`
 	TopLevelInstasellTimeSeconds JSONFloat64     `json:"top_level_instasell_time_seconds,omitempty"`
+	// SnapshotGeneration is the MarketDataStore generation this result was
+	// computed against, set by ExpandDual (marketstore.go); zero when the
+	// caller used the package-level globals via PerformDualExpansion directly.
+	SnapshotGeneration int64 `json:"snapshot_generation,omitempty"`
+	// DataAgeSeconds is how long ago the MarketDataStore snapshot behind
+	// SnapshotGeneration finished its last full refresh, set by ExpandDual
+	// alongside SnapshotGeneration; NaN when the store has never completed
+	// one, zero (omitted) when the caller used PerformDualExpansion directly.
+	DataAgeSeconds JSONFloat64 `json:"data_age_seconds,omitempty"`
+	// ParetoFrontier holds every non-dominated (TotalCost, slowest fill time)
+	// strategy found among Craft, Primary, Secondary, and partial-craft
+	// variants toggling each of the root item's immediate sub-ingredients
+	// between crafted and bought - see computeParetoFrontier (pareto.go).
+	// PerspectiveType on each entry names which strategy it is ("Craft",
+	// "Primary", "Secondary", or "PartialCraft"); nil when the root item has
+	// no recipe and crafting was never attempted.
+	ParetoFrontier []ExpansionResult `json:"pareto_frontier,omitempty"`
+	// CraftEffectiveCost/PrimaryEffectiveCost blend costToCraftOptimalRaw/
+	// topC10mPrimRaw with their expected fill time via opts.effectiveCost
+	// (ExpansionOptions.TimeValueCoefficient) - equal to the corresponding
+	// raw cost when TimeValueCoefficient is left at zero. SecondaryBased's
+	// chooser compares these two instead of the raw costs, so a configured
+	// TimeValueCoefficient can flip its Craft-vs-Primary tie-break.
+	CraftEffectiveCost   JSONFloat64 `json:"craft_effective_cost,omitempty"`
+	PrimaryEffectiveCost JSONFloat64 `json:"primary_effective_cost,omitempty"`
+	// PrimarySigma/CraftSigma are the liquidity-derived cost uncertainty
+	// (opts.RiskModel) behind PrimaryEffectiveCost/CraftEffectiveCost's
+	// risk-adjusted comparison in the SecondaryBased chooser - the point
+	// estimate's companion σ, not folded into the cost fields themselves, so
+	// a caller can see both independently. Zero (omitted) when RiskModel is
+	// left at its zero value.
+	PrimarySigma JSONFloat64 `json:"primary_sigma,omitempty"`
+	CraftSigma   JSONFloat64 `json:"craft_sigma,omitempty"`
+	// SellBased is the revenue-side counterpart to PrimaryBased/
+	// SecondaryBased's acquisition-cost perspectives: what the top-level
+	// item is worth sold rather than bought, choosing between instaselling
+	// it whole and instaselling its crafting components individually. See
+	// computeSellBasedResult (sell_expansion.go).
+	SellBased ExpansionResult `json:"sell_based"`
+	// Trace holds this call's captured Info/Debug log lines (logger.go),
+	// populated only when the caller installed a debugSink on ctx via
+	// contextWithDebugSink (e.g. an HTTP handler's ?debug=1) - nil otherwise,
+	// so a normal request's JSON body doesn't carry an empty array.
+	Trace []string `json:"trace,omitempty"`
 }
 
 type ExpansionResult struct {
-	BaseIngredients                 map[string]BaseIngredientDetail `json:"base_ingredients"`
-	TotalCost                       JSONFloat64                     `json:"total_cost,omitempty"`
-	PerspectiveType                 string                          `json:"perspective_type"`
-	TopLevelAction                  string                          `json:"top_level_action"`
-	FinalCostMethod                 string                          `json:"final_cost_method"`
-	CalculationPossible             bool                            `json:"calculation_possible"`
-	ErrorMessage                    string                          `json:"error_message,omitempty"`
-	TopLevelCost                    JSONFloat64                     `json:"top_level_cost,omitempty"`
-	TopLevelRR                      JSONFloat64                     `json:"top_level_rr,omitempty"`
-	SlowestIngredientBuyTimeSeconds JSONFloat64                     `json:"slowest_ingredient_buy_time_seconds,omitempty"`
-	SlowestIngredientName           string                          `json:"slowest_ingredient_name,omitempty"`
-	SlowestIngredientQuantity       float64                         `json:"slowest_ingredient_quantity"`
-	RecipeTree                      *CraftingStepNode               `json:"recipe_tree,omitempty"` // Defined in tree_builder.go
+	BaseIngredients map[string]BaseIngredientDetail `json:"base_ingredients"`
+	TotalCost       JSONFloat64                     `json:"total_cost,omitempty"`
+	// TotalCostP95 sums every base ingredient's BestCostP95 independently -
+	// an upper bound under independence, not a jointly-calibrated 95th
+	// percentile of the total. See calculateDetailedCostsAndFillTimes.
+	TotalCostP95                    JSONFloat64       `json:"total_cost_p95,omitempty"`
+	PerspectiveType                 string            `json:"perspective_type"`
+	TopLevelAction                  string            `json:"top_level_action"`
+	FinalCostMethod                 string            `json:"final_cost_method"`
+	CalculationPossible             bool              `json:"calculation_possible"`
+	ErrorMessage                    string            `json:"error_message,omitempty"`
+	TopLevelCost                    JSONFloat64       `json:"top_level_cost,omitempty"`
+	TopLevelRR                      JSONFloat64       `json:"top_level_rr,omitempty"`
+	SlowestIngredientBuyTimeSeconds JSONFloat64       `json:"slowest_ingredient_buy_time_seconds,omitempty"`
+	SlowestIngredientName           string            `json:"slowest_ingredient_name,omitempty"`
+	SlowestIngredientQuantity       float64           `json:"slowest_ingredient_quantity"`
+	FillTimeStats                   FillTimeStats     `json:"fill_time_stats"`
+	RecipeTree                      *CraftingStepNode `json:"recipe_tree,omitempty"` // Defined in tree_builder.go
+	// ConstraintViolations is populated by PerformConstrainedExpansion
+	// (constrained_expansion.go) when this result was chosen as the
+	// cheapest-or-closest-feasible candidate against an ExpansionConstraints;
+	// nil for a result built by PerformDualExpansion directly.
+	ConstraintViolations []string `json:"constraint_violations,omitempty"`
+	// CandidatesConsidered lists every acquisition method this perspective's
+	// chooser viewed as viable (same candidates passed to an
+	// AcquisitionStrategy for PrimaryBased; see acquisition_strategy.go) so a
+	// caller can see what was ruled out, not just what won.
+	CandidatesConsidered []Candidate `json:"candidates_considered,omitempty"`
+	// DecisionReason explains why FinalCostMethod ended up what it did -
+	// an AcquisitionStrategy's own reason string for PrimaryBased, or a short
+	// description of SecondaryBased's fixed craft-preferring tie-break.
+	DecisionReason string `json:"decision_reason,omitempty"`
+}
+
+// FillTimeStats summarizes the distribution of per-ingredient buy-order fill
+// times across every base ingredient of a craft, so a consumer can reason
+// about tail risk instead of only the single slowest ingredient. Percentiles
+// are derived by linear interpolation between ranks over the sorted, non-Inf
+// fill times; ingredients whose fill time came back Inf (unfillable within
+// the available order book) are excluded from the distribution and counted
+// separately in UnfillableCount.
+type FillTimeStats struct {
+	Count           int         `json:"count"`
+	UnfillableCount int         `json:"unfillable_count"`
+	MinSeconds      JSONFloat64 `json:"min_seconds,omitempty"`
+	MeanSeconds     JSONFloat64 `json:"mean_seconds,omitempty"`
+	MedianSeconds   JSONFloat64 `json:"median_seconds,omitempty"`
+	P90Seconds      JSONFloat64 `json:"p90_seconds,omitempty"`
+	P99Seconds      JSONFloat64 `json:"p99_seconds,omitempty"`
+	StdDevSeconds   JSONFloat64 `json:"stddev_seconds,omitempty"`
+	MaxSeconds      JSONFloat64 `json:"max_seconds,omitempty"`
+	P50ItemID       string      `json:"p50_item_id,omitempty"`
+	P90ItemID       string      `json:"p90_item_id,omitempty"`
+	P99ItemID       string      `json:"p99_item_id,omitempty"`
+}
+
+// fillTimesForBaseIngredients recomputes each base ingredient's buy-order
+// fill time from its already-resolved acquisition details, the same way
+// calculateDetailedCostsAndFillTimes and analyzeTreeForCostsAndTimes do:
+// instabuy-acquired ingredients (Method != "Primary") fill instantly, and a
+// Primary ingredient whose fill time can't be computed is treated as Inf
+// (unfillable) rather than dropped.
+func fillTimesForBaseIngredients(ctx context.Context, baseIngredients map[string]BaseIngredientDetail, metricsMap map[string]ProductMetrics) map[string]float64 {
+	times := make(map[string]float64, len(baseIngredients))
+	for itemID, detail := range baseIngredients {
+		if ctx.Err() != nil {
+			times[itemID] = math.Inf(1)
+			continue
+		}
+		if detail.Method != "Primary" {
+			times[itemID] = 0.0
+			continue
+		}
+		metricsData, metricsOk := safeGetMetricsData(metricsMap, itemID)
+		if !metricsOk {
+			times[itemID] = math.Inf(1)
+			continue
+		}
+		fillTime, _, err := calculateBuyOrderFillTime(ctx, itemID, detail.Quantity, metricsData)
+		if err != nil || math.IsNaN(fillTime) || math.IsInf(fillTime, 0) || fillTime < 0 {
+			times[itemID] = math.Inf(1)
+			continue
+		}
+		times[itemID] = fillTime
+	}
+	return times
+}
+
+// computeFillTimeStats derives a FillTimeStats from a per-ingredient fill
+// time map. Inf/NaN entries are counted as unfillable and excluded from the
+// distribution; an empty or all-Inf input yields a zero Count with every
+// percentile left at its JSON-omitted NaN default.
+func computeFillTimeStats(times map[string]float64) FillTimeStats {
+	type sample struct {
+		itemID string
+		secs   float64
+	}
+	stats := FillTimeStats{
+		MinSeconds: toJSONFloat64(math.NaN()), MeanSeconds: toJSONFloat64(math.NaN()), MedianSeconds: toJSONFloat64(math.NaN()),
+		P90Seconds: toJSONFloat64(math.NaN()), P99Seconds: toJSONFloat64(math.NaN()), StdDevSeconds: toJSONFloat64(math.NaN()),
+		MaxSeconds: toJSONFloat64(math.NaN()),
+	}
+
+	finite := make([]sample, 0, len(times))
+	for itemID, t := range times {
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			stats.UnfillableCount++
+			continue
+		}
+		finite = append(finite, sample{itemID: itemID, secs: t})
+	}
+	stats.Count = len(finite)
+	if len(finite) == 0 {
+		return stats
+	}
+	sort.Slice(finite, func(i, j int) bool { return finite[i].secs < finite[j].secs })
+
+	percentile := func(p float64) (float64, string) {
+		if len(finite) == 1 {
+			return finite[0].secs, finite[0].itemID
+		}
+		rank := p * float64(len(finite)-1)
+		lo := int(math.Floor(rank))
+		hi := int(math.Ceil(rank))
+		if hi >= len(finite) {
+			hi = len(finite) - 1
+		}
+		frac := rank - float64(lo)
+		val := finite[lo].secs + frac*(finite[hi].secs-finite[lo].secs)
+		idx := lo
+		if frac >= 0.5 {
+			idx = hi
+		}
+		return val, finite[idx].itemID
+	}
+
+	sum := 0.0
+	for _, s := range finite {
+		sum += s.secs
+	}
+	mean := sum / float64(len(finite))
+	var sqDiffSum float64
+	for _, s := range finite {
+		d := s.secs - mean
+		sqDiffSum += d * d
+	}
+
+	medVal, medID := percentile(0.5)
+	p90Val, p90ID := percentile(0.9)
+	p99Val, p99ID := percentile(0.99)
+
+	stats.MinSeconds = toJSONFloat64(finite[0].secs)
+	stats.MaxSeconds = toJSONFloat64(finite[len(finite)-1].secs)
+	stats.MeanSeconds = toJSONFloat64(mean)
+	stats.StdDevSeconds = toJSONFloat64(math.Sqrt(sqDiffSum / float64(len(finite))))
+	stats.MedianSeconds = toJSONFloat64(medVal)
+	stats.P90Seconds = toJSONFloat64(p90Val)
+	stats.P99Seconds = toJSONFloat64(p99Val)
+	stats.P50ItemID = medID
+	stats.P90ItemID = p90ID
+	stats.P99ItemID = p99ID
+	return stats
 }
 
 func float64Ptr(v float64) *float64 {
@@ -62,12 +285,141 @@ func float64Ptr(v float64) *float64 {
 	return &f
 }
 
+// baseIngredientPricingResult is one base ingredient's fully-priced detail,
+// computed by priceBaseIngredient so calculateDetailedCostsAndFillTimes's
+// worker pool can fold every ingredient's result in a fixed (sorted-ID)
+// order regardless of which worker finished first - the same reduction
+// shape analyzeTreeForCostsAndTimes/analyzeBaseIngredient (tree_builder.go)
+// use for the analogous problem one layer up.
+type baseIngredientPricingResult struct {
+	itemID          string
+	quantity        float64
+	detail          BaseIngredientDetail
+	costValid       bool
+	costRaw         float64
+	method          string
+	fillTimeRaw     float64
+	fillTimeInvalid bool
+	errMsgs         []string
+}
+
+// baseIngredientVolatilityWindow is how far back priceBaseIngredient looks
+// into DefaultPriceHistoryStore for PriceStdDev/BestCostP95/BestCostP05,
+// matching trendHandler's default "7d" window.
+const baseIngredientVolatilityWindow = 7 * 24 * time.Hour
+
+// volatilityZScore95 is the one-sided 95% z-score (P(Z <= z) = 0.95) used to
+// turn PriceStdDev into BestCostP95/BestCostP05 bands.
+const volatilityZScore95 = 1.645
+
+// priceBaseIngredient prices one base ingredient (getBestC10M) and computes
+// its Primary-method fill time, mirroring the per-ingredient body of the old
+// sequential calculateDetailedCostsAndFillTimes loop. It only records its
+// findings for the caller to fold in - BestCostMethodTotal/
+// CalculationWarningsTotal are incremented by the caller's single-threaded
+// reduction step instead of here, since WithLabelValues+Inc on a shared
+// Counter isn't safe to call concurrently with differing labels.
+func priceBaseIngredient(ctx context.Context, itemID string, quantity float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, precision PrecisionMode, opts ExpansionOptions) baseIngredientPricingResult {
+	result := baseIngredientPricingResult{itemID: itemID, quantity: quantity}
+
+	bestCostRaw, method, assocCostRaw, rrRaw, ifValRaw, errC10M := getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, precision, nil)
+	result.method = method
+
+	ingredientDetail := BaseIngredientDetail{
+		Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN()), AssociatedCost: toJSONFloat64(math.NaN()),
+		RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(math.NaN()),
+	}
+
+	if errC10M != nil || method == "N/A" || math.IsInf(bestCostRaw, 0) || math.IsNaN(bestCostRaw) || bestCostRaw < 0 {
+		currentErrMsg := fmt.Sprintf("Cannot determine valid BEST cost for base ingredient '%s': BestC:%.2f, Method: %s, Err: %v", itemID, bestCostRaw, method, errC10M)
+		dlog("  WARN (calculateDetailedCostsAndFillTimes - Best): %s", currentErrMsg)
+		result.errMsgs = append(result.errMsgs, currentErrMsg)
+		ingredientDetail.Method = method
+		if errC10M != nil { // If specific error, mark method as ERROR
+			ingredientDetail.Method = "ERROR"
+		}
+		DefaultMetrics(nil).IngredientsWithNaNTotal.Inc()
+	} else { // Valid cost obtained
+		result.costValid = true
+		result.costRaw = bestCostRaw
+		ingredientDetail.BestCost = toJSONFloat64(valueOrNaN(bestCostRaw))
+		ingredientDetail.AssociatedCost = toJSONFloat64(valueOrNaN(assocCostRaw))
+		ingredientDetail.Method = method
+		if method == "Primary" {
+			ingredientDetail.RR = toJSONFloat64(valueOrNaN(rrRaw))
+			ingredientDetail.IF = toJSONFloat64(valueOrNaN(ifValRaw))
+		}
+		if histStore, histErr := DefaultPriceHistoryStore(); histErr == nil {
+			if trend, ok := histStore.Trend(itemID, baseIngredientVolatilityWindow); ok {
+				stddev := trend.InstabuyStdDev
+				if method == "Primary" {
+					stddev = trend.InstasellStdDev
+				}
+				if !math.IsNaN(stddev) {
+					band := volatilityZScore95 * stddev * quantity
+					ingredientDetail.PriceStdDev = toJSONFloat64(stddev)
+					ingredientDetail.BestCostP95 = toJSONFloat64(bestCostRaw + band)
+					ingredientDetail.BestCostP05 = toJSONFloat64(math.Max(0, bestCostRaw-band))
+				}
+			}
+		}
+	}
+	metricsDataForDelta, metricsOkForDelta := safeGetMetricsData(metricsMap, itemID)
+	if metricsOkForDelta {
+		deltaValRaw := metricsDataForDelta.SellSize*metricsDataForDelta.SellFrequency - metricsDataForDelta.OrderSize*metricsDataForDelta.OrderFrequency
+		ingredientDetail.Delta = toJSONFloat64(valueOrNaN(deltaValRaw))
+	}
+	ageSecs, confidence := metricsAgeAndConfidence(metricsDataForDelta, metricsOkForDelta)
+	ingredientDetail.MetricsAgeSeconds = toJSONFloat64(ageSecs)
+	ingredientDetail.Confidence = toJSONFloat64(confidence)
+	ingredientDetail.ConfidenceLevel = confidenceLevelFor(ageSecs, opts.MaxMetricsAgeSecs)
+
+	// Fill Time Calculation for Primary method
+	buyTimeRaw := 0.0 // Default for non-Primary or calculable zero time
+	if method == "Primary" {
+		metricsDataForFill, metricsOkForFill := safeGetMetricsData(metricsMap, itemID)
+		if metricsOkForFill {
+			calculatedTime, _, buyErr := calculateBuyOrderFillTime(ctx, itemID, quantity, metricsDataForFill)
+			if buyErr == nil && !math.IsNaN(calculatedTime) && !math.IsInf(calculatedTime, 0) && calculatedTime >= 0 {
+				buyTimeRaw = calculatedTime
+			} else {
+				buyTimeRaw = math.Inf(1) // Mark this ingredient's time as Inf
+				result.errMsgs = append(result.errMsgs, fmt.Sprintf("Fill time calculation error for '%s': Err: %v, Time: %.2f", itemID, buyErr, calculatedTime))
+				result.fillTimeInvalid = true
+			}
+		} else { // Metrics not found for fill time
+			buyTimeRaw = math.Inf(1)
+			result.errMsgs = append(result.errMsgs, fmt.Sprintf("Metrics not found for primary buy fill time of '%s'", itemID))
+			result.fillTimeInvalid = true
+		}
+	}
+	result.fillTimeRaw = buyTimeRaw
+	if result.costValid {
+		ingredientDetail.EffectiveCost = toJSONFloat64(valueOrNaN(opts.effectiveCost(bestCostRaw, buyTimeRaw)))
+	}
+	result.detail = ingredientDetail
+	return result
+}
+
+// calculateDetailedCostsAndFillTimes prices every base ingredient in
+// baseMapInput concurrently through a bounded worker pool (sized/rate-
+// limited by opts, falling back to DefaultTreeAnalysisConfig), the same
+// dispatch shape analyzeTreeForCostsAndTimes (tree_builder.go) uses one
+// layer up once a recipe tree's base ingredients are already known. itemIDs
+// is sorted up front so dispatch and the reduction below run in a fixed
+// order: two runs over the same input always attribute the "slowest
+// ingredient" tie-break to the same itemID regardless of which worker
+// goroutine actually finished first.
 func calculateDetailedCostsAndFillTimes(
+	ctx context.Context,
 	baseMapInput map[string]float64,
 	apiResp *HypixelAPIResponse,
 	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	opts ExpansionOptions,
 ) (
 	totalSumOfBestCosts float64,
+	totalCostP95Raw float64,
 	detailedMapOutput map[string]BaseIngredientDetail,
 	slowestFillTimeSecsRaw float64, // Returns raw float64
 	slowestIngName string,
@@ -77,6 +429,7 @@ func calculateDetailedCostsAndFillTimes(
 ) {
 	totalSumOfBestCosts = 0.0
 	isPossible = true
+	costAccum := newCostAccumulator(precision)
 	// Use strings.Builder for potentially long error messages
 	var errorMsgBuilder strings.Builder
 	detailedMapOutput = make(map[string]BaseIngredientDetail)
@@ -85,105 +438,237 @@ func calculateDetailedCostsAndFillTimes(
 	slowestIngQty = 0.0
 
 	if len(baseMapInput) == 0 {
-		return 0.0, detailedMapOutput, 0.0, "", 0.0, true, ""
+		return 0.0, 0.0, detailedMapOutput, 0.0, "", 0.0, true, ""
 	}
+	DefaultMetrics(nil).BaseIngredientsMapSize.Observe(float64(len(baseMapInput)))
 
+	itemIDs := make([]string, 0, len(baseMapInput))
 	for itemID, quantity := range baseMapInput {
 		if quantity <= 0 {
 			continue
 		}
-		bestCostRaw, method, assocCostRaw, rrRaw, ifValRaw, errC10M := getBestC10M(itemID, quantity, apiResp, metricsMap)
+		itemIDs = append(itemIDs, itemID)
+	}
+	sort.Strings(itemIDs)
 
-		ingredientDetail := BaseIngredientDetail{
-			Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN()), AssociatedCost: toJSONFloat64(math.NaN()),
-			RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(math.NaN()),
-		}
+	if len(itemIDs) == 0 {
+		return 0.0, 0.0, detailedMapOutput, 0.0, "", 0.0, true, ""
+	}
 
-		if errC10M != nil || method == "N/A" || math.IsInf(bestCostRaw, 0) || math.IsNaN(bestCostRaw) || bestCostRaw < 0 {
-			currentErrMsg := fmt.Sprintf("Cannot determine valid BEST cost for base ingredient '%s': BestC:%.2f, Method: %s, Err: %v", itemID, bestCostRaw, method, errC10M)
-			dlog("  WARN (calculateDetailedCostsAndFillTimes - Best): %s", currentErrMsg)
-			if errorMsgBuilder.Len() > 0 {
-				errorMsgBuilder.WriteString("; ")
+	config := opts.treeAnalysisConfig()
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(itemIDs) {
+		workers = len(itemIDs)
+	}
+	limiter := newIntervalRateLimiter(config.RequestsPerSecond)
+
+	results := make([]baseIngredientPricingResult, len(itemIDs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				itemID := itemIDs[idx]
+				DefaultMetrics(nil).IngredientsProcessedTotal.Inc()
+				limiter.Wait()
+				results[idx] = priceBaseIngredient(ctx, itemID, baseMapInput[itemID], apiResp, metricsMap, precision, opts)
 			}
-			errorMsgBuilder.WriteString(currentErrMsg)
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for idx := range itemIDs {
+			select {
+			case jobs <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		isPossible = false
+		errorMsgBuilder.WriteString(fmt.Sprintf("cancelled before pricing every base ingredient: %v", ctxErr))
+	}
+
+	for _, r := range results {
+		DefaultMetrics(nil).BestCostMethodTotal.WithLabelValues(r.method).Inc()
+		detailedMapOutput[r.itemID] = r.detail
+
+		if !r.costValid {
 			isPossible = false
+			DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("base_ingredients").Inc()
 			totalSumOfBestCosts = math.Inf(1) // Mark total cost as impossible
-			ingredientDetail.Method = method
-			if errC10M != nil { // If specific error, mark method as ERROR
-				ingredientDetail.Method = "ERROR"
-			}
-		} else { // Valid cost obtained
+			totalCostP95Raw = math.Inf(1)
+		} else {
 			if !math.IsInf(totalSumOfBestCosts, 1) { // Only add if total cost is still considered possible
-				totalSumOfBestCosts += bestCostRaw
+				costAccum.Add(r.costRaw)
+				totalSumOfBestCosts = costAccum.Sum()
 			}
-			ingredientDetail.BestCost = toJSONFloat64(valueOrNaN(bestCostRaw))
-			ingredientDetail.AssociatedCost = toJSONFloat64(valueOrNaN(assocCostRaw))
-			ingredientDetail.Method = method
-			if method == "Primary" {
-				ingredientDetail.RR = toJSONFloat64(valueOrNaN(rrRaw))
-				ingredientDetail.IF = toJSONFloat64(valueOrNaN(ifValRaw))
+			if p95 := float64(r.detail.BestCostP95); !math.IsInf(totalCostP95Raw, 1) && !math.IsNaN(p95) {
+				totalCostP95Raw += p95
 			}
 		}
-		metricsDataForDelta, metricsOkForDelta := safeGetMetricsData(metricsMap, itemID)
-		if metricsOkForDelta {
-			deltaValRaw := metricsDataForDelta.SellSize*metricsDataForDelta.SellFrequency - metricsDataForDelta.OrderSize*metricsDataForDelta.OrderFrequency
-			ingredientDetail.Delta = toJSONFloat64(valueOrNaN(deltaValRaw))
+		if r.fillTimeInvalid {
+			isPossible = false
+			DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("base_ingredients").Inc()
 		}
-		detailedMapOutput[itemID] = ingredientDetail
-
-		// Fill Time Calculation for Primary method
-		buyTimeRaw := 0.0 // Default for non-Primary or calculable zero time
-		if method == "Primary" {
-			metricsDataForFill, metricsOkForFill := safeGetMetricsData(metricsMap, itemID)
-			if metricsOkForFill {
-				calculatedTime, _, buyErr := calculateBuyOrderFillTime(itemID, quantity, metricsDataForFill)
-				if buyErr == nil && !math.IsNaN(calculatedTime) && !math.IsInf(calculatedTime, 0) && calculatedTime >= 0 {
-					buyTimeRaw = calculatedTime
-				} else {
-					buyTimeRaw = math.Inf(1) // Mark this ingredient's time as Inf
-					currentErrMsg := fmt.Sprintf("Fill time calculation error for '%s': Err: %v, Time: %.2f", itemID, buyErr, calculatedTime)
-					if errorMsgBuilder.Len() > 0 {
-						errorMsgBuilder.WriteString("; ")
-					}
-					errorMsgBuilder.WriteString(currentErrMsg)
-					isPossible = false // Overall calculation no longer possible
-				}
-			} else { // Metrics not found for fill time
-				buyTimeRaw = math.Inf(1)
-				currentErrMsg := fmt.Sprintf("Metrics not found for primary buy fill time of '%s'", itemID)
-				if errorMsgBuilder.Len() > 0 {
-					errorMsgBuilder.WriteString("; ")
-				}
-				errorMsgBuilder.WriteString(currentErrMsg)
-				isPossible = false
+		for _, msg := range r.errMsgs {
+			if errorMsgBuilder.Len() > 0 {
+				errorMsgBuilder.WriteString("; ")
 			}
+			errorMsgBuilder.WriteString(msg)
 		}
+
 		// Update overall slowest time
-		if math.IsInf(buyTimeRaw, 1) { // If current ingredient's time is Inf
+		if math.IsInf(r.fillTimeRaw, 1) { // If current ingredient's time is Inf
 			if !math.IsInf(currentSlowestTimeRaw, 1) { // And overall slowest wasn't Inf yet
-				currentSlowestTimeRaw = buyTimeRaw // Then overall becomes Inf
-				slowestIngName = itemID
-				slowestIngQty = quantity
+				currentSlowestTimeRaw = r.fillTimeRaw // Then overall becomes Inf
+				slowestIngName = r.itemID
+				slowestIngQty = r.quantity
 			}
-		} else if !math.IsInf(currentSlowestTimeRaw, 1) && buyTimeRaw > currentSlowestTimeRaw { // If neither is Inf and current is slower
-			currentSlowestTimeRaw = buyTimeRaw
-			slowestIngName = itemID
-			slowestIngQty = quantity
+		} else if !math.IsInf(currentSlowestTimeRaw, 1) && r.fillTimeRaw > currentSlowestTimeRaw { // If neither is Inf and current is slower
+			currentSlowestTimeRaw = r.fillTimeRaw
+			slowestIngName = r.itemID
+			slowestIngQty = r.quantity
 		}
 	}
-	return totalSumOfBestCosts, detailedMapOutput, currentSlowestTimeRaw, slowestIngName, sanitizeFloat(slowestIngQty), isPossible, errorMsgBuilder.String()
+
+	DefaultMetrics(nil).CostAnalysisOutcomesTotal.WithLabelValues("base_ingredients", strconv.FormatBool(isPossible)).Inc()
+	if !math.IsInf(totalSumOfBestCosts, 0) && !math.IsNaN(totalSumOfBestCosts) {
+		DefaultMetrics(nil).TotalCostSummary.WithLabelValues("base_ingredients").Observe(totalSumOfBestCosts)
+	}
+	if !math.IsInf(currentSlowestTimeRaw, 0) && !math.IsNaN(currentSlowestTimeRaw) {
+		DefaultMetrics(nil).SlowestFillTimeSummary.WithLabelValues("base_ingredients").Observe(currentSlowestTimeRaw)
+	}
+	return totalSumOfBestCosts, totalCostP95Raw, detailedMapOutput, currentSlowestTimeRaw, slowestIngName, sanitizeFloat(slowestIngQty), isPossible, errorMsgBuilder.String()
+}
+
+// ExpansionOptions tunes the bounded worker pool PerformDualExpansion and
+// its cost-analysis helpers (calculateDetailedCostsAndFillTimes,
+// analyzeTreeForCostsAndTimes) dispatch base-ingredient pricing through. The
+// zero value defers entirely to DefaultTreeAnalysisConfig (tree_builder.go),
+// so existing callers passing ExpansionOptions{} keep today's behavior.
+type ExpansionOptions struct {
+	// Workers caps how many base ingredients are priced concurrently; <= 0
+	// falls back to DefaultTreeAnalysisConfig.Workers.
+	Workers int
+	// RequestsPerSecond caps the shared rate limiter every pricing worker
+	// waits on; <= 0 falls back to DefaultTreeAnalysisConfig.RequestsPerSecond.
+	RequestsPerSecond float64
+	// MaxParetoPoints caps how many candidate strategies computeParetoFrontier
+	// builds before stopping; <= 0 falls back to defaultMaxParetoPoints (see
+	// pareto.go).
+	MaxParetoPoints int
+	// MethodPolicy gates the P1/P2 choosers' Primary branch on the top-level
+	// item's Delta; the zero value leaves Primary eligible purely on cost, as
+	// before.
+	MethodPolicy MethodPolicy
+	// Strategy picks among P1's viable Craft/Primary/Secondary candidates;
+	// nil falls back to PureCostStrategy, preserving the historical
+	// cheapest-wins behavior (see acquisition_strategy.go).
+	Strategy AcquisitionStrategy
+	// TimeValueCoefficient is coins/second of expected fill time a caller is
+	// willing to pay to avoid waiting, folded into BaseIngredientDetail.
+	// EffectiveCost and DualExpansionResult.CraftEffectiveCost/
+	// PrimaryEffectiveCost via effectiveCost; <= 0 disables it, leaving
+	// those fields equal to the corresponding raw cost.
+	TimeValueCoefficient float64
+	// Events, if non-nil, receives an ExpansionEvent as P1/P2 evaluate
+	// Craft/Primary, as each reaches a decision, and as each sub-tree node is
+	// freshly expanded (expansion_events.go); nil disables event emission
+	// entirely, which is the zero-cost default for a plain PerformDualExpansion
+	// call.
+	Events chan<- ExpansionEvent
+	// RiskModel turns the P2 chooser's Craft-vs-Primary comparison from a
+	// plain expected-cost comparison into cost + λ·σ (see risk_model.go); the
+	// zero value (Lambda 0) leaves it exactly as before.
+	RiskModel RiskModel
+	// MaxMetricsAgeSecs is the fresh/stale cutoff priceBaseIngredient passes
+	// to confidenceLevelFor when populating BaseIngredientDetail.
+	// ConfidenceLevel; <= 0 falls back to defaultMaxMetricsAgeSecs
+	// (staleness.go).
+	MaxMetricsAgeSecs float64
+}
+
+// acquisitionStrategy resolves o.Strategy, falling back to PureCostStrategy
+// like treeAnalysisConfig/maxParetoPoints do for their own fields.
+func (o ExpansionOptions) acquisitionStrategy() AcquisitionStrategy {
+	if o.Strategy != nil {
+		return o.Strategy
+	}
+	return PureCostStrategy
+}
+
+// effectiveCost blends rawCost with fillTimeRaw at o.TimeValueCoefficient
+// coins/second; it returns rawCost unchanged when TimeValueCoefficient is
+// <= 0 or rawCost is already Inf/NaN, and Inf when fillTimeRaw is Inf/NaN
+// (an uncomputable wait is worth an unbounded cost penalty, not zero).
+func (o ExpansionOptions) effectiveCost(rawCost, fillTimeRaw float64) float64 {
+	if o.TimeValueCoefficient <= 0 || math.IsInf(rawCost, 0) || math.IsNaN(rawCost) {
+		return rawCost
+	}
+	if math.IsInf(fillTimeRaw, 0) || math.IsNaN(fillTimeRaw) {
+		return math.Inf(1)
+	}
+	return rawCost + o.TimeValueCoefficient*fillTimeRaw
+}
+
+// treeAnalysisConfig resolves o into a TreeAnalysisConfig, substituting
+// DefaultTreeAnalysisConfig's fields for whichever o leaves at its zero value.
+func (o ExpansionOptions) treeAnalysisConfig() TreeAnalysisConfig {
+	config := DefaultTreeAnalysisConfig
+	if o.Workers > 0 {
+		config.Workers = o.Workers
+	}
+	if o.RequestsPerSecond > 0 {
+		config.RequestsPerSecond = o.RequestsPerSecond
+	}
+	return config
 }
 
+// PerformDualExpansion runs the full dual-perspective expansion for one
+// item. ctx bounds the whole call: it's threaded into every recursive
+// expansion step (ExpandItemToTree/expandItemRecursiveTree), the tree
+// analysis pass, and the per-ingredient C10M/fill-time lookups, so a client
+// disconnect or request deadline stops in-flight work instead of letting a
+// deep recipe run to completion for a caller who already left. precision
+// selects how analyzeTreeForCostsAndTimes accumulates base-ingredient costs;
+// PrecisionFloat (the zero value's effective behavior via parsePrecisionMode)
+// matches the original plain-float64 summation. opts tunes the worker pool
+// analyzeTreeForCostsAndTimes dispatches base-ingredient pricing through;
+// ExpansionOptions{} (the zero value) keeps today's DefaultTreeAnalysisConfig
+// behavior.
 func PerformDualExpansion(
+	ctx context.Context,
 	itemName string,
 	quantity float64,
 	apiResp *HypixelAPIResponse,
 	metricsMap map[string]ProductMetrics,
 	itemFilesDir string,
 	includeTreeInExpansionResult bool, // New parameter
+	precision PrecisionMode,
+	opts ExpansionOptions,
 ) (*DualExpansionResult, error) {
 	itemNameNorm := BAZAAR_ID(itemName)
 	dlog(">>> Performing Dual Expansion for %.2f x %s (IncludeTree: %v) <<<", quantity, itemNameNorm, includeTreeInExpansionResult)
+	Info(ctx, "dual expansion start", slog.String("item", itemNameNorm), slog.Float64("quantity", quantity))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx = contextWithEventSink(ctx, opts.Events)
+
+	setCurrentMetricsMap(metricsMap)
+	releaseInFlight := trackInFlightItem(itemNameNorm)
+	defer releaseInFlight()
+
 	result := &DualExpansionResult{
 		ItemName: itemNameNorm, Quantity: sanitizeFloat(quantity),
 		PrimaryBased: ExpansionResult{
@@ -196,6 +681,10 @@ func PerformDualExpansion(
 			TotalCost: toJSONFloat64(math.NaN()), TopLevelCost: toJSONFloat64(math.NaN()), TopLevelRR: toJSONFloat64(math.NaN()), SlowestIngredientBuyTimeSeconds: toJSONFloat64(math.NaN()),
 			RecipeTree: nil, // Initialize to nil
 		},
+		SellBased: ExpansionResult{
+			PerspectiveType: "SellBased", CalculationPossible: false, BaseIngredients: make(map[string]BaseIngredientDetail),
+			TotalCost: toJSONFloat64(math.NaN()), TopLevelCost: toJSONFloat64(math.NaN()), TopLevelRR: toJSONFloat64(math.NaN()), SlowestIngredientBuyTimeSeconds: toJSONFloat64(math.NaN()),
+		},
 		TopLevelInstasellTimeSeconds: toJSONFloat64(math.NaN()),
 	}
 
@@ -216,14 +705,37 @@ func PerformDualExpansion(
 	sellP := getSellPrice(apiResp, itemNameNorm)
 	buyP := getBuyPrice(apiResp, itemNameNorm)
 	metricsP := getMetrics(metricsMap, itemNameNorm)
-	topC10mPrimRaw, topC10mSecRaw, topIFRaw, topRRRaw, _, _, errTopC10M := calculateC10MInternal(itemNameNorm, quantity, sellP, buyP, metricsP)
+	topC10mPrimRaw, topC10mSecRaw, topIFRaw, topRRRaw, _, _, errTopC10M := calculateC10MInternal(itemNameNorm, quantity, sellP, buyP, metricsP, nil)
+
+	topDeltaRaw := math.NaN()
+	if metricsP.ProductID != "" {
+		topDeltaRaw = metricsP.SellSize*metricsP.SellFrequency - metricsP.OrderSize*metricsP.OrderFrequency
+	}
+	// primaryRejectedByDelta excludes Primary from the P1/P2 choosers below
+	// regardless of its nominal cost, per opts.MethodPolicy; it's computed
+	// once up front since both choosers apply the same top-level decision.
+	primaryRejectedByDelta := opts.MethodPolicy.rejectPrimary(itemNameNorm, topDeltaRaw)
+
+	// topPrimFillTimeRaw is Primary's fill time for the P1 chooser's
+	// Candidate; the "Primary"-branch code further below recomputes this for
+	// display on res1/res2, same as it always has.
+	topPrimFillTimeRaw := math.Inf(1)
+	if metricsP.ProductID != "" {
+		if f, _, errF := calculateBuyOrderFillTime(ctx, itemNameNorm, quantity, metricsP); errF == nil && !math.IsNaN(f) && !math.IsInf(f, 0) && f >= 0 {
+			topPrimFillTimeRaw = f
+		}
+	}
 
 	result.PrimaryBased.TopLevelCost = toJSONFloat64(valueOrNaN(topC10mPrimRaw))
 	result.SecondaryBased.TopLevelCost = toJSONFloat64(valueOrNaN(topC10mSecRaw))
 
-	validTopC10mPrim := errTopC10M == nil && !math.IsInf(topC10mPrimRaw, 0) && !math.IsNaN(topC10mPrimRaw) && topC10mPrimRaw >= 0
+	_, topMetricsConfidence := metricsAgeAndConfidence(metricsP, metricsP.ProductID != "")
+	validTopC10mPrim := errTopC10M == nil && !math.IsInf(topC10mPrimRaw, 0) && !math.IsNaN(topC10mPrimRaw) && topC10mPrimRaw >= 0 &&
+		topMetricsConfidence >= minMetricsConfidenceForPrimary
 	if validTopC10mPrim {
 		result.PrimaryBased.TopLevelRR = toJSONFloat64(valueOrNaN(topRRRaw))
+	} else if errTopC10M == nil && !math.IsInf(topC10mPrimRaw, 0) && !math.IsNaN(topC10mPrimRaw) && topC10mPrimRaw >= 0 {
+		dlog("  Primary C10M for %s is otherwise valid but metrics confidence %.2f < threshold %.2f; failing over to Secondary/Craft.", itemNameNorm, topMetricsConfidence, minMetricsConfidenceForPrimary)
 	}
 	validTopC10mSec := errTopC10M == nil && !math.IsInf(topC10mSecRaw, 0) && !math.IsNaN(topC10mSecRaw) && topC10mSecRaw >= 0
 
@@ -244,14 +756,15 @@ func PerformDualExpansion(
 
 		// Create minimal error trees if tree is requested, otherwise they stay nil
 		if includeTreeInExpansionResult {
-			result.PrimaryBased.RecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, ErrorMessage: errMsg, IsBaseComponent: true, Acquisition: &baseAcqError, Depth: 0, MaxSubTreeDepth: 0}
-			result.SecondaryBased.RecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, ErrorMessage: errMsg, IsBaseComponent: true, Acquisition: &baseAcqError, Depth: 0, MaxSubTreeDepth: 0}
+			result.PrimaryBased.RecipeTree = errorNode(itemNameNorm, quantity, RecipeReadFailed, errMsg, &baseAcqError)
+			result.SecondaryBased.RecipeTree = errorNode(itemNameNorm, quantity, RecipeReadFailed, errMsg, &baseAcqError)
 		}
 		// This is an early return, so explicit nilling if !includeTree is not needed beyond initialization.
 		return result, nil
 	}
 
 	dlog("  Top-Level C10M: Primary=%.2f (IF=%.2f, RR=%.2f), Secondary=%.2f. Recipe Exists: %v. Error: %v", topC10mPrimRaw, topIFRaw, topRRRaw, topC10mSecRaw, topLevelRecipeExists, errTopC10M)
+	emitExpansionEvent(ctx, ExpansionEvent{Type: EventPrimaryEvaluated, CostRaw: topC10mPrimRaw, FillTimeRaw: topPrimFillTimeRaw})
 	isApiNotFoundError := errTopC10M != nil && strings.Contains(errTopC10M.Error(), "API data not found")
 
 	costToCraftOptimalRaw := math.Inf(1)
@@ -266,23 +779,29 @@ func PerformDualExpansion(
 
 	if topLevelRecipeExists {
 		var errExpand error
-		craftRecipeTree, errExpand = ExpandItemToTree(itemNameNorm, quantity, apiResp, metricsMap, itemFilesDir)
+		craftRecipeTree, errExpand = ExpandItemToTree(ctx, itemNameNorm, quantity, apiResp, metricsMap, itemFilesDir)
+		if ctx.Err() != nil {
+			// The deadline/disconnect that produced errExpand also means the
+			// rest of this function's work (alternate valuations, fill-time
+			// stats) would just be thrown away; stop now instead.
+			return result, ctx.Err()
+		}
 		baseAcqTreeError := BaseIngredientDetail{Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN()), AssociatedCost: toJSONFloat64(math.NaN()), RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(math.NaN())}
 		if errExpand != nil {
 			craftErrMsg = fmt.Sprintf("Expansion to tree failed: %v", errExpand)
 			if craftRecipeTree == nil { // Ensure craftRecipeTree is not nil if error occurred
-				craftRecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, ErrorMessage: craftErrMsg, IsBaseComponent: true, Acquisition: &baseAcqTreeError, Depth: 0, MaxSubTreeDepth: 0}
+				craftRecipeTree = errorNode(itemNameNorm, quantity, SubExpansionCritical, craftErrMsg, &baseAcqTreeError)
 			} else { // If tree exists but had an error, ensure error message is on it
-				if craftRecipeTree.ErrorMessage == "" {
-					craftRecipeTree.ErrorMessage = craftErrMsg
+				if len(craftRecipeTree.Errors) == 0 {
+					craftRecipeTree.addErrorf(SubExpansionCritical, "%s", craftErrMsg)
 				}
 			}
 		}
 		if craftRecipeTree == nil { // Should be redundant due to above, but safety
 			craftErrMsg = "Expansion to tree resulted in nil root node"
-			craftRecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, ErrorMessage: craftErrMsg, IsBaseComponent: true, Acquisition: &baseAcqTreeError, Depth: 0, MaxSubTreeDepth: 0}
+			craftRecipeTree = errorNode(itemNameNorm, quantity, SubExpansionCritical, craftErrMsg, &baseAcqTreeError)
 		} else {
-			if craftRecipeTree.IsBaseComponent && strings.Contains(craftRecipeTree.ErrorMessage, "Cycle detected to top-level item") {
+			if craftRecipeTree.IsBaseComponent && craftRecipeTree.HasError(CycleTopLevel) {
 				craftResultedInCycle = true
 				if craftErrMsg == "" {
 					craftErrMsg = "Expansion resulted in top-level cycle"
@@ -294,7 +813,7 @@ func PerformDualExpansion(
 				craftSlowestFillTimeRaw = math.Inf(1)
 			} else {
 				var analysisErrorMsg string
-				costToCraftOptimalRaw, craftSlowestFillTimeRaw, craftSlowestIngName, craftSlowestIngQty, craftPossible, analysisErrorMsg = analyzeTreeForCostsAndTimes(craftRecipeTree, apiResp, metricsMap)
+				costToCraftOptimalRaw, craftSlowestFillTimeRaw, craftSlowestIngName, craftSlowestIngQty, craftPossible, analysisErrorMsg = analyzeTreeForCostsAndTimes(ctx, craftRecipeTree, apiResp, metricsMap, precision, opts)
 				if !craftPossible {
 					if craftErrMsg == "" {
 						craftErrMsg = "Failed to calculate detailed costs/times from tree"
@@ -304,6 +823,7 @@ func PerformDualExpansion(
 					}
 				} else {
 					dlog("  Cost to Craft (from Tree) for %s: %.2f. Slowest Ing: %s (Qty: %.2f, TimeRaw: %.2f)", itemNameNorm, costToCraftOptimalRaw, craftSlowestIngName, craftSlowestIngQty, craftSlowestFillTimeRaw)
+					emitExpansionEvent(ctx, ExpansionEvent{Type: EventCraftEvaluated, CostRaw: costToCraftOptimalRaw, FillTimeRaw: craftSlowestFillTimeRaw})
 				}
 			}
 			baseIngredientsFromCraft = extractBaseIngredientsFromTree(craftRecipeTree)
@@ -315,54 +835,54 @@ func PerformDualExpansion(
 		craftSlowestFillTimeRaw = math.Inf(1)
 		baseAcqNoRecipe := BaseIngredientDetail{Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN()), AssociatedCost: toJSONFloat64(math.NaN()), RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(math.NaN())}
 		// Create a minimal tree node for this case
-		craftRecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, ErrorMessage: craftErrMsg, Acquisition: &baseAcqNoRecipe, Depth: 0, MaxSubTreeDepth: 0}
+		craftRecipeTree = errorNode(itemNameNorm, quantity, RecipeFileMissing, craftErrMsg, &baseAcqNoRecipe)
 		// Try to get C10M for this "base" item if no recipe
-		costRawVal, method, assocCostRawVal, rrValRaw, ifValRaw, deltaValRaw, c10mErr := calculateC10MForNode(itemNameNorm, quantity, apiResp, metricsMap)
+		costRawVal, method, assocCostRawVal, rrValRaw, ifValRaw, deltaValRaw, attemptedVal, c10mErr := calculateC10MForNode(ctx, itemNameNorm, quantity, apiResp, metricsMap)
 		if c10mErr == nil && !math.IsInf(costRawVal, 0) && !math.IsNaN(costRawVal) && costRawVal >= 0 {
 			craftRecipeTree.Acquisition = &BaseIngredientDetail{
 				Quantity: quantity, BestCost: toJSONFloat64(valueOrNaN(costRawVal)), AssociatedCost: toJSONFloat64(valueOrNaN(assocCostRawVal)), Method: method,
 				RR: toJSONFloat64(valueOrNaN(rrValRaw)), IF: toJSONFloat64(valueOrNaN(ifValRaw)), Delta: toJSONFloat64(valueOrNaN(deltaValRaw)),
 			}
+			craftRecipeTree.AttemptedAcquisitions = attemptedVal
 		} else { // C10M failed for non-craftable item
 			if craftRecipeTree.Acquisition == nil { // Should not happen if initialized above
 				craftRecipeTree.Acquisition = &BaseIngredientDetail{Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN())}
 			}
 			craftRecipeTree.Acquisition.BestCost = toJSONFloat64(math.NaN()) // Mark cost as NaN
 			craftRecipeTree.Acquisition.Method = "N/A"
+			craftRecipeTree.AttemptedAcquisitions = attemptedVal
 			if c10mErr != nil {
-				if craftRecipeTree.ErrorMessage == "" {
-					craftRecipeTree.ErrorMessage = c10mErr.Error()
-				} else if !strings.Contains(craftRecipeTree.ErrorMessage, c10mErr.Error()) {
-					craftRecipeTree.ErrorMessage += "; C10M Error: " + c10mErr.Error()
-				}
+				craftRecipeTree.addError(C10MUnavailable, c10mErr)
 			}
 		}
 	}
 
 	// --- PrimaryBased (res1) Logic ---
 	res1 := &result.PrimaryBased
-	minCostP1Raw := math.Inf(1)
 	chosenMethodP1 := "N/A"
 
+	var p1Candidates []Candidate
 	if craftPossible && !math.IsInf(costToCraftOptimalRaw, 0) && !math.IsNaN(costToCraftOptimalRaw) && costToCraftOptimalRaw >= 0 {
-		if costToCraftOptimalRaw < minCostP1Raw {
-			minCostP1Raw = costToCraftOptimalRaw
-			chosenMethodP1 = "Craft"
-		}
+		p1Candidates = append(p1Candidates, Candidate{Method: "Craft", CostRaw: costToCraftOptimalRaw, FillTimeRaw: craftSlowestFillTimeRaw})
 	}
-	if validTopC10mPrim {
-		if topC10mPrimRaw < minCostP1Raw {
-			minCostP1Raw = topC10mPrimRaw
-			chosenMethodP1 = "Primary"
-		}
+	if validTopC10mPrim && !primaryRejectedByDelta {
+		p1Candidates = append(p1Candidates, Candidate{Method: "Primary", CostRaw: topC10mPrimRaw, FillTimeRaw: topPrimFillTimeRaw})
 	}
 	if validTopC10mSec {
-		if topC10mSecRaw < minCostP1Raw {
-			minCostP1Raw = topC10mSecRaw
-			chosenMethodP1 = "Secondary"
-		}
+		p1Candidates = append(p1Candidates, Candidate{Method: "Secondary", CostRaw: topC10mSecRaw, FillTimeRaw: 0})
 	}
-	dlog("  P1 Minimum Cost Choice: %s (Raw Min Cost: %.2f)", chosenMethodP1, minCostP1Raw)
+
+	var p1DecisionReason string
+	if len(p1Candidates) > 0 {
+		var chosen Candidate
+		chosen, p1DecisionReason = opts.acquisitionStrategy().Choose(ctx, p1Candidates)
+		chosenMethodP1 = chosen.Method
+	}
+	res1.CandidatesConsidered = p1Candidates
+	res1.DecisionReason = p1DecisionReason
+	dlog("  P1 Strategy Choice: %s (%s)", chosenMethodP1, p1DecisionReason)
+	Debug(ctx, "P1 decision", slog.String("item", itemNameNorm), slog.String("perspective", "PrimaryBased"), slog.String("method", chosenMethodP1), slog.String("reason", p1DecisionReason))
+	emitExpansionEvent(ctx, ExpansionEvent{Type: EventDecisionMade, Perspective: "PrimaryBased", Method: chosenMethodP1, Reason: p1DecisionReason})
 
 	if chosenMethodP1 == "Craft" {
 		res1.TopLevelAction = "Expanded"
@@ -381,6 +901,10 @@ func PerformDualExpansion(
 		} else if !craftPossible && craftErrMsg != "" && !strings.Contains(res1.ErrorMessage, craftErrMsg) {
 			res1.ErrorMessage += "; " + craftErrMsg
 		}
+		if craftRecipeTree != nil && craftRecipeTree.IsBaseComponent && craftRecipeTree.Acquisition != nil && craftRecipeTree.Acquisition.Method == "Illiquid" {
+			res1.TopLevelAction = "TreatedAsBase (Illiquid)"
+			res1.FinalCostMethod = "Illiquid"
+		}
 	} else if chosenMethodP1 == "Primary" || chosenMethodP1 == "Secondary" {
 		res1.TopLevelAction = "TreatedAsBase"
 		var acqCostRawVal, acqAssocCostRawVal, acqRRRawVal, acqIFRawVal, acqDeltaRawVal float64
@@ -394,7 +918,7 @@ func PerformDualExpansion(
 				acqDeltaRawVal = metricsP.SellSize*metricsP.SellFrequency - metricsP.OrderSize*metricsP.OrderFrequency
 			}
 			if metricsP.ProductID != "" { // Check again for fill time specifically
-				fillTimeVal, _, errFill := calculateBuyOrderFillTime(itemNameNorm, quantity, metricsP)
+				fillTimeVal, _, errFill := calculateBuyOrderFillTime(ctx, itemNameNorm, quantity, metricsP)
 				if errFill == nil && !math.IsNaN(fillTimeVal) && !math.IsInf(fillTimeVal, 0) && fillTimeVal >= 0 {
 					fillTimeForBaseRawVal = fillTimeVal
 				}
@@ -450,19 +974,20 @@ func PerformDualExpansion(
 
 		if includeTreeInExpansionResult {
 			// If crafting was attempted and failed/cycled, use that tree for context
-			if craftRecipeTree != nil && (craftResultedInCycle || !craftPossible || craftRecipeTree.ErrorMessage != "") {
+			if craftRecipeTree != nil && (craftResultedInCycle || !craftPossible || craftRecipeTree.ErrorMessage() != "") {
 				res1.RecipeTree = craftRecipeTree
-				if res1.RecipeTree.ErrorMessage == "" { // Ensure error message propagates
-					res1.RecipeTree.ErrorMessage = res1.ErrorMessage
-				} else if res1.ErrorMessage != "" && !strings.Contains(res1.RecipeTree.ErrorMessage, res1.ErrorMessage) {
-					res1.RecipeTree.ErrorMessage += "; " + res1.ErrorMessage
+				if res1.ErrorMessage != "" {
+					res1.RecipeTree.addErrorf(SubExpansionCritical, "%s", res1.ErrorMessage)
 				}
 			} else { // Otherwise, a simple error node
-				res1.RecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, ErrorMessage: res1.ErrorMessage, Acquisition: &baseAcqUnobtainable, Depth: 0, MaxSubTreeDepth: 0}
+				res1.RecipeTree = errorNode(itemNameNorm, quantity, SubExpansionCritical, res1.ErrorMessage, &baseAcqUnobtainable)
 			}
 		}
 		res1.SlowestIngredientBuyTimeSeconds = toJSONFloat64(math.NaN())
 	}
+	if primaryRejectedByDelta {
+		res1.FinalCostMethod += " (PrimaryRejectedByDelta)"
+	}
 	if chosenMethodP1 != "Craft" && craftResultedInCycle {
 		if res1.ErrorMessage == "" {
 			res1.ErrorMessage = "Crafting resulted in a cycle, but another acquisition method was chosen for P1."
@@ -478,6 +1003,28 @@ func PerformDualExpansion(
 	res2 := &result.SecondaryBased
 	chosenMethodP2 := "N/A" // Default
 
+	// craftEffectiveCostRaw/primaryEffectiveCostRaw blend the Craft/Primary
+	// raw costs with their expected fill time (see
+	// ExpansionOptions.TimeValueCoefficient); the P2 tie-break below compares
+	// these instead of costToCraftOptimalRaw/topC10mPrimRaw directly, and
+	// they're equal to the raw costs when TimeValueCoefficient is unset.
+	craftEffectiveCostRaw := opts.effectiveCost(costToCraftOptimalRaw, craftSlowestFillTimeRaw)
+	primaryEffectiveCostRaw := opts.effectiveCost(topC10mPrimRaw, topPrimFillTimeRaw)
+	result.CraftEffectiveCost = toJSONFloat64(valueOrNaN(craftEffectiveCostRaw))
+	result.PrimaryEffectiveCost = toJSONFloat64(valueOrNaN(primaryEffectiveCostRaw))
+
+	// primarySigmaRaw/craftSigmaRaw and the risk-adjusted costs derived from
+	// them (opts.RiskModel) let the P2 tie-break below prefer a slightly
+	// pricier but more liquid method over a cheap-looking one backed by a
+	// thin or lopsided market; they collapse to the plain effective costs
+	// when opts.RiskModel is left at its zero value (see risk_model.go).
+	primarySigmaRaw := opts.RiskModel.primarySigma(topDeltaRaw, metricsP.SellFrequency, metricsP.OrderFrequency)
+	craftSigmaRaw := opts.RiskModel.craftSigma(baseIngredientsFromCraft, metricsMap)
+	craftRiskAdjustedRaw := opts.RiskModel.riskAdjustedCost(craftEffectiveCostRaw, craftSigmaRaw)
+	primaryRiskAdjustedRaw := opts.RiskModel.riskAdjustedCost(primaryEffectiveCostRaw, primarySigmaRaw)
+	result.PrimarySigma = toJSONFloat64(valueOrNaN(primarySigmaRaw))
+	result.CraftSigma = toJSONFloat64(valueOrNaN(craftSigmaRaw))
+
 	if isApiNotFoundError {
 		if craftPossible && !craftResultedInCycle && !math.IsInf(costToCraftOptimalRaw, 0) && !math.IsNaN(costToCraftOptimalRaw) && costToCraftOptimalRaw >= 0 {
 			chosenMethodP2 = "Craft"
@@ -492,8 +1039,8 @@ func PerformDualExpansion(
 		}
 	} else {
 		if craftPossible && !craftResultedInCycle && !math.IsInf(costToCraftOptimalRaw, 0) && !math.IsNaN(costToCraftOptimalRaw) && costToCraftOptimalRaw >= 0 {
-			if validTopC10mPrim {
-				if costToCraftOptimalRaw <= topC10mPrimRaw {
+			if validTopC10mPrim && !primaryRejectedByDelta {
+				if costLessOrEqual(craftRiskAdjustedRaw, primaryRiskAdjustedRaw, precision) {
 					chosenMethodP2 = "Craft"
 				} else {
 					chosenMethodP2 = "Primary"
@@ -501,12 +1048,17 @@ func PerformDualExpansion(
 			} else {
 				chosenMethodP2 = "Craft"
 			}
-		} else if validTopC10mPrim {
+		} else if validTopC10mPrim && !primaryRejectedByDelta {
 			chosenMethodP2 = "Primary"
+		} else if validTopC10mSec {
+			// Primary is either invalid or too low-confidence to trust; fail
+			// over to the instasell-backed Secondary valuation rather than
+			// giving up outright.
+			chosenMethodP2 = "Secondary"
 		} else {
 			chosenMethodP2 = "ExpansionFailed"
 			if res2.ErrorMessage == "" {
-				res2.ErrorMessage = "P2: Neither Craft nor Primary acquisition is viable."
+				res2.ErrorMessage = "P2: Neither Craft nor Primary/Secondary acquisition is viable."
 			}
 			if craftErrMsg != "" && !strings.Contains(res2.ErrorMessage, craftErrMsg) {
 				res2.ErrorMessage += "; " + craftErrMsg
@@ -516,7 +1068,29 @@ func PerformDualExpansion(
 			}
 		}
 	}
+
+	// SecondaryBased keeps its own fixed craft-preferring tie-break instead
+	// of going through an AcquisitionStrategy (unlike PrimaryBased, its rule
+	// isn't "always cheapest" - Craft wins ties against Primary, and
+	// Secondary is only a last resort) - but it still reports the same
+	// Candidate shape so a caller can compare both perspectives uniformly.
+	var p2Candidates []Candidate
+	if craftPossible && !math.IsInf(costToCraftOptimalRaw, 0) && !math.IsNaN(costToCraftOptimalRaw) && costToCraftOptimalRaw >= 0 {
+		p2Candidates = append(p2Candidates, Candidate{Method: "Craft", CostRaw: costToCraftOptimalRaw, FillTimeRaw: craftSlowestFillTimeRaw})
+	}
+	if validTopC10mPrim && !primaryRejectedByDelta {
+		p2Candidates = append(p2Candidates, Candidate{Method: "Primary", CostRaw: topC10mPrimRaw, FillTimeRaw: topPrimFillTimeRaw})
+	}
+	if validTopC10mSec {
+		p2Candidates = append(p2Candidates, Candidate{Method: "Secondary", CostRaw: topC10mSecRaw, FillTimeRaw: 0})
+	}
+	res2.CandidatesConsidered = p2Candidates
+	if chosenMethodP2 == "Craft" || chosenMethodP2 == "Primary" || chosenMethodP2 == "Secondary" {
+		res2.DecisionReason = fmt.Sprintf("fixed craft-preferring tie-break: %s chosen among %d viable candidate(s)", chosenMethodP2, len(p2Candidates))
+	}
 	dlog("  P2 Decision: %s", chosenMethodP2)
+	Debug(ctx, "P2 decision", slog.String("item", itemNameNorm), slog.String("perspective", "SecondaryBased"), slog.String("method", chosenMethodP2), slog.String("reason", res2.DecisionReason))
+	emitExpansionEvent(ctx, ExpansionEvent{Type: EventDecisionMade, Perspective: "SecondaryBased", Method: chosenMethodP2, Reason: res2.DecisionReason})
 
 	if chosenMethodP2 == "Craft" {
 		res2.TopLevelAction = "Expanded"
@@ -535,6 +1109,10 @@ func PerformDualExpansion(
 		} else if !craftPossible && craftErrMsg != "" && !strings.Contains(res2.ErrorMessage, craftErrMsg) {
 			res2.ErrorMessage += "; " + craftErrMsg
 		}
+		if craftRecipeTree != nil && craftRecipeTree.IsBaseComponent && craftRecipeTree.Acquisition != nil && craftRecipeTree.Acquisition.Method == "Illiquid" {
+			res2.TopLevelAction = "TreatedAsBase (Illiquid)"
+			res2.FinalCostMethod = "Illiquid"
+		}
 	} else if chosenMethodP2 == "Primary" {
 		res2.TopLevelAction = "TreatedAsBase"
 		res2.TotalCost = toJSONFloat64(valueOrNaN(topC10mPrimRaw))
@@ -554,7 +1132,7 @@ func PerformDualExpansion(
 		res2.CalculationPossible = true
 		fillTimeP2PrimRawVal := math.Inf(1)
 		if metricsP.ProductID != "" {
-			f, _, errF := calculateBuyOrderFillTime(itemNameNorm, quantity, metricsP)
+			f, _, errF := calculateBuyOrderFillTime(ctx, itemNameNorm, quantity, metricsP)
 			if errF == nil && !math.IsNaN(f) && !math.IsInf(f, 0) && f >= 0 {
 				fillTimeP2PrimRawVal = f
 			}
@@ -562,6 +1140,26 @@ func PerformDualExpansion(
 		res2.SlowestIngredientBuyTimeSeconds = toJSONFloat64(valueOrNaN(fillTimeP2PrimRawVal))
 		res2.SlowestIngredientName = itemNameNorm
 		res2.SlowestIngredientQuantity = sanitizeFloat(quantity)
+	} else if chosenMethodP2 == "Secondary" {
+		res2.TopLevelAction = "TreatedAsBase"
+		res2.TotalCost = toJSONFloat64(valueOrNaN(topC10mSecRaw))
+		res2.FinalCostMethod = "FixedTopLevelSecondary"
+		acqDeltaP2SecRaw := math.NaN()
+		if metricsP.ProductID != "" {
+			acqDeltaP2SecRaw = metricsP.SellSize*metricsP.SellFrequency - metricsP.OrderSize*metricsP.OrderFrequency
+		}
+		currentBaseDetailP2SecMethod := BaseIngredientDetail{
+			Quantity: quantity, BestCost: toJSONFloat64(valueOrNaN(topC10mSecRaw)), AssociatedCost: toJSONFloat64(valueOrNaN(sellP * quantity)), Method: "Secondary",
+			RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(valueOrNaN(acqDeltaP2SecRaw)),
+		}
+		res2.BaseIngredients = map[string]BaseIngredientDetail{itemNameNorm: currentBaseDetailP2SecMethod}
+		if includeTreeInExpansionResult {
+			res2.RecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Acquisition: &currentBaseDetailP2SecMethod, Depth: 0, MaxSubTreeDepth: 0}
+		}
+		res2.CalculationPossible = true
+		res2.SlowestIngredientBuyTimeSeconds = toJSONFloat64(0.0) // Instabuy is instant
+		res2.SlowestIngredientName = itemNameNorm
+		res2.SlowestIngredientQuantity = sanitizeFloat(quantity)
 	} else {
 		res2.TopLevelAction = chosenMethodP2
 		res2.TotalCost = toJSONFloat64(math.NaN())
@@ -571,10 +1169,8 @@ func PerformDualExpansion(
 		if includeTreeInExpansionResult {
 			if chosenMethodP2 == "ExpansionFailed" && craftRecipeTree != nil {
 				res2.RecipeTree = craftRecipeTree
-				if res2.RecipeTree.ErrorMessage == "" {
-					res2.RecipeTree.ErrorMessage = res2.ErrorMessage
-				} else if res2.ErrorMessage != "" && !strings.Contains(res2.RecipeTree.ErrorMessage, res2.ErrorMessage) {
-					res2.RecipeTree.ErrorMessage += "; " + res2.ErrorMessage
+				if res2.ErrorMessage != "" {
+					res2.RecipeTree.addErrorf(SubExpansionCritical, "%s", res2.ErrorMessage)
 				}
 			} else {
 				acqDeltaP2NARaw := math.NaN()
@@ -582,7 +1178,7 @@ func PerformDualExpansion(
 					acqDeltaP2NARaw = metricsP.SellSize*metricsP.SellFrequency - metricsP.OrderSize*metricsP.OrderFrequency
 				}
 				baseAcqP2NA := BaseIngredientDetail{Quantity: quantity, Method: "N/A", BestCost: toJSONFloat64(math.NaN()), AssociatedCost: toJSONFloat64(math.NaN()), RR: toJSONFloat64(math.NaN()), IF: toJSONFloat64(math.NaN()), Delta: toJSONFloat64(valueOrNaN(acqDeltaP2NARaw))}
-				res2.RecipeTree = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, ErrorMessage: res2.ErrorMessage, Acquisition: &baseAcqP2NA, Depth: 0, MaxSubTreeDepth: 0}
+				res2.RecipeTree = errorNode(itemNameNorm, quantity, SubExpansionCritical, res2.ErrorMessage, &baseAcqP2NA)
 			}
 		}
 
@@ -594,10 +1190,16 @@ func PerformDualExpansion(
 			res2.SlowestIngredientBuyTimeSeconds = toJSONFloat64(math.NaN())
 		}
 	}
+	if primaryRejectedByDelta {
+		res2.FinalCostMethod += " (PrimaryRejectedByDelta)"
+	}
 
 	result.PrimaryBased.SlowestIngredientQuantity = sanitizeFloat(result.PrimaryBased.SlowestIngredientQuantity)
 	result.SecondaryBased.SlowestIngredientQuantity = sanitizeFloat(result.SecondaryBased.SlowestIngredientQuantity)
 
+	result.PrimaryBased.FillTimeStats = computeFillTimeStats(fillTimesForBaseIngredients(ctx, result.PrimaryBased.BaseIngredients, metricsMap))
+	result.SecondaryBased.FillTimeStats = computeFillTimeStats(fillTimesForBaseIngredients(ctx, result.SecondaryBased.BaseIngredients, metricsMap))
+
 	if !includeTreeInExpansionResult {
 		if result.PrimaryBased.RecipeTree != nil {
 			dlog("  Final Nilling P1 RecipeTree for %s as per request.", itemNameNorm)
@@ -611,6 +1213,12 @@ func PerformDualExpansion(
 		dlog("  Retaining P1/P2 RecipeTrees for %s as per request (if they were set).", itemNameNorm)
 	}
 
+	result.ParetoFrontier = computeParetoFrontier(ctx, craftRecipeTree, baseIngredientsFromCraft, costToCraftOptimalRaw, craftSlowestFillTimeRaw, itemNameNorm, quantity, apiResp, metricsMap, precision, opts)
+
+	result.SellBased = computeSellBasedResult(itemNameNorm, quantity, apiResp, metricsMap, baseIngredientsFromCraft, instaSellTimeRaw, precision)
+
 	dlog(">>> Dual Expansion Complete for %s <<<", itemNameNorm)
+	Info(ctx, "dual expansion done", slog.String("item", itemNameNorm), slog.String("primary_method", result.PrimaryBased.FinalCostMethod), slog.String("secondary_method", result.SecondaryBased.FinalCostMethod))
+	result.Trace = debugSinkLines(ctx)
 	return result, nil
 }
@@ -0,0 +1,464 @@
+// expansion_cache.go
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expansionCacheKey identifies one memoized DualExpansionResult: the same
+// item/quantity computed against a different market snapshot, a different
+// LiquidityConfig, or a different acquisition strategy is a different entry,
+// never a stale hit.
+type expansionCacheKey struct {
+	ItemID     string
+	Quantity   float64
+	Generation int64
+	ConfigHash string
+}
+
+type expansionCacheEntry struct {
+	result    *DualExpansionResult
+	expiresAt time.Time
+}
+
+// ExpansionCacheBackend stores expansionCacheEntry values for ExpansionCache,
+// so a caller can swap the default bounded in-memory map
+// (lruExpansionCacheBackend) for a different storage strategy - e.g.
+// diskExpansionCacheBackend, so a cold-started process can still serve hits
+// left over from before it restarted - without touching ExpandDualCached or
+// the singleflight layer above it. Mirrors BazaarCache's (cache.go) role for
+// fetchBazaarData/getApiResponse.
+type ExpansionCacheBackend interface {
+	// Get returns the cached entry for key, if the backend has one.
+	Get(key expansionCacheKey) (expansionCacheEntry, bool)
+	// Put stores entry under key, evicting an older entry first if the
+	// backend enforces a size bound; it reports how many entries it evicted
+	// to do so (0 for an unbounded backend).
+	Put(key expansionCacheKey, entry expansionCacheEntry) (evicted int)
+	// Len reports how many entries the backend currently holds.
+	Len() int
+	// DeleteOlderThan removes every entry whose Generation < generation,
+	// reporting how many were removed, so MarketDataStore.Publish
+	// (marketstore.go) can drop entries computed against a snapshot that can
+	// never be hit again regardless of which backend is in use.
+	DeleteOlderThan(generation int64) int
+}
+
+// ExpansionCache memoizes PerformDualExpansion results so a single tick's
+// repeated re-expansion of common sub-ingredients (enchanted cobblestone,
+// redstone, etc. pulled in by many different top-level recipes) only pays
+// the cost once per (item, quantity, snapshot, config) combination.
+// ExpandDualCached additionally coalesces concurrent callers for the same
+// key onto a single in-flight computation (see claim/release below), so a
+// stampede of requests for the same cold item doesn't all recompute it.
+type ExpansionCache struct {
+	ttl     time.Duration
+	backend ExpansionCacheBackend
+
+	hits      int64
+	misses    int64
+	evictions int64
+
+	inflightMu sync.Mutex
+	inflight   map[expansionCacheKey]*expansionCacheSlot
+}
+
+// NewExpansionCache creates a cache whose entries expire after ttl (30s if
+// ttl <= 0), backed by a bounded in-memory LRU.
+func NewExpansionCache(ttl time.Duration) *ExpansionCache {
+	return NewExpansionCacheWithBackend(ttl, NewLRUExpansionCacheBackend(0))
+}
+
+// NewExpansionCacheWithBackend is NewExpansionCache with an explicit
+// backend, e.g. NewDiskExpansionCacheBackend for a cache that survives a
+// process restart.
+func NewExpansionCacheWithBackend(ttl time.Duration, backend ExpansionCacheBackend) *ExpansionCache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &ExpansionCache{ttl: ttl, backend: backend, inflight: make(map[expansionCacheKey]*expansionCacheSlot)}
+}
+
+// configHash summarizes every knob that changes what PerformDualExpansion
+// computes for the same item/quantity/snapshot, so a LiquidityConfig or
+// confidence-threshold change naturally misses the cache instead of serving
+// a result computed under the old rules. strategyID (see
+// AcquisitionStrategyByName, acquisition_strategy.go) is folded in here too,
+// since ExpandDualCached's own key doesn't carry it separately.
+func configHash(strategyID string) string {
+	cfg := getLiquidityConfig()
+	return fmt.Sprintf("%.4f|%.4f|%.4f|%.4f|%s", cfg.MinOrdersPerDay, cfg.MinSellsPerDay, cfg.MinCombinedDepth, minMetricsConfidenceForPrimary, strategyID)
+}
+
+func (c *ExpansionCache) get(key expansionCacheKey) (*DualExpansionResult, bool) {
+	entry, ok := c.backend.Get(key)
+	if !ok || time.Now().After(entry.expiresAt) {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return entry.result, true
+}
+
+func (c *ExpansionCache) put(key expansionCacheKey, result *DualExpansionResult) {
+	evicted := c.backend.Put(key, expansionCacheEntry{result: result, expiresAt: time.Now().Add(c.ttl)})
+	atomic.AddInt64(&c.evictions, int64(evicted))
+}
+
+// expansionCacheSlot is one in-flight ExpandDualCached computation, following
+// the same claim/finish/wait singleflight shape as recipeMemo's memoSlot
+// (tree_builder.go) and batchExpansionMemo's batchMemoEntry
+// (calculate_batch.go).
+type expansionCacheSlot struct {
+	done   chan struct{}
+	result *DualExpansionResult
+	err    error
+}
+
+func (s *expansionCacheSlot) finish(result *DualExpansionResult, err error) {
+	s.result, s.err = result, err
+	close(s.done)
+}
+
+func (s *expansionCacheSlot) wait() (*DualExpansionResult, error) {
+	<-s.done
+	return s.result, s.err
+}
+
+// claim returns the in-flight slot for key, registering a new one if this is
+// the first caller for key since it last missed the cache. owner is true for
+// exactly one caller per key - that caller must call release (via defer)
+// once it has called slot.finish, so the next cache miss for key starts a
+// fresh computation instead of replaying a stale one forever.
+func (c *ExpansionCache) claim(key expansionCacheKey) (slot *expansionCacheSlot, owner bool) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+	if s, ok := c.inflight[key]; ok {
+		return s, false
+	}
+	s := &expansionCacheSlot{done: make(chan struct{})}
+	c.inflight[key] = s
+	return s, true
+}
+
+func (c *ExpansionCache) release(key expansionCacheKey) {
+	c.inflightMu.Lock()
+	delete(c.inflight, key)
+	c.inflightMu.Unlock()
+}
+
+// InvalidateOlderThan drops every entry computed against a generation older
+// than generation; MarketDataStore.Publish calls this with its new
+// generation each time the snapshot advances, so the cache doesn't grow
+// unbounded with entries that can never be hit again.
+func (c *ExpansionCache) InvalidateOlderThan(generation int64) {
+	c.backend.DeleteOlderThan(generation)
+}
+
+// Stats reports the cumulative hit/miss/eviction counters, for observability
+// (e.g. a dashboard.go-style status endpoint) into how well-warmed the cache
+// is.
+func (c *ExpansionCache) Stats() (hits, misses, evictions int64, size int) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses), atomic.LoadInt64(&c.evictions), c.backend.Len()
+}
+
+// globalExpansionCache backs ExpandDualCached for callers that don't want to
+// manage their own ExpansionCache instance.
+var globalExpansionCache = NewExpansionCache(30 * time.Second)
+
+// ExpandDualCached returns the memoized result for item/quantity against
+// store's current snapshot and strategyID if still fresh, recomputing and
+// caching it otherwise. strategyID selects opts.Strategy via
+// AcquisitionStrategyByName (""/unrecognized falls back to PureCostStrategy,
+// same as a zero-value ExpansionOptions.Strategy would). Concurrent callers
+// racing on the same (item, quantity, snapshot, strategyID) share one
+// PerformDualExpansion call instead of each running it themselves.
+func ExpandDualCached(ctx context.Context, store *MarketDataStore, item string, quantity float64, itemFilesDir string, strategyID string) (*DualExpansionResult, error) {
+	itemNorm := BAZAAR_ID(item)
+	apiResp, metricsMap, generation := store.Snapshot()
+	key := expansionCacheKey{ItemID: itemNorm, Quantity: quantity, Generation: generation, ConfigHash: configHash(strategyID)}
+
+	if cached, ok := globalExpansionCache.get(key); ok {
+		return cached, nil
+	}
+
+	slot, owner := globalExpansionCache.claim(key)
+	if !owner {
+		return slot.wait()
+	}
+	defer globalExpansionCache.release(key)
+
+	if err := ctx.Err(); err != nil {
+		slot.finish(nil, err)
+		return nil, err
+	}
+
+	opts := ExpansionOptions{}
+	if strat, ok := AcquisitionStrategyByName(strategyID); ok {
+		opts.Strategy = strat
+	}
+
+	result, err := PerformDualExpansion(ctx, itemNorm, quantity, apiResp, metricsMap, itemFilesDir, false, PrecisionFloat, opts)
+	if result != nil {
+		result.SnapshotGeneration = generation
+		_, _, lastFinish := store.Progress()
+		if lastFinish.IsZero() {
+			result.DataAgeSeconds = toJSONFloat64(math.NaN())
+		} else {
+			result.DataAgeSeconds = toJSONFloat64(time.Since(lastFinish).Seconds())
+		}
+	}
+	slot.finish(result, err)
+	if err == nil {
+		globalExpansionCache.put(key, result)
+	}
+	return result, err
+}
+
+// Warm precomputes and caches results for items via a bounded worker pool,
+// intended to run between refresh cycles so the top-N items are already
+// cached by the time a request for them arrives. It returns once every item
+// has been attempted or ctx is cancelled.
+func (c *ExpansionCache) Warm(ctx context.Context, store *MarketDataStore, items []string, quantity float64, itemFilesDir string, workerCount int) {
+	if workerCount <= 0 {
+		workerCount = 4
+	}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if _, err := ExpandDualCached(ctx, store, item, quantity, itemFilesDir, ""); err != nil {
+					dlog("ExpansionCache.Warm: %s failed: %v", item, err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case work <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+// lruEntry is one lruExpansionCacheBackend node: the key alongside its entry,
+// so evicting the back of order can delete the matching map entry too.
+type lruEntry struct {
+	key   expansionCacheKey
+	entry expansionCacheEntry
+}
+
+// lruExpansionCacheBackend is the default ExpansionCacheBackend: an
+// in-memory map bounded to maxEntries, evicting the least-recently-used
+// entry once full.
+type lruExpansionCacheBackend struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[expansionCacheKey]*list.Element
+	order   *list.List // front = most recently used
+}
+
+// defaultLRUCacheEntries bounds a lruExpansionCacheBackend constructed via
+// NewLRUExpansionCacheBackend(0).
+const defaultLRUCacheEntries = 10000
+
+// NewLRUExpansionCacheBackend returns an in-memory ExpansionCacheBackend
+// bounded to maxEntries (defaultLRUCacheEntries if maxEntries <= 0).
+func NewLRUExpansionCacheBackend(maxEntries int) ExpansionCacheBackend {
+	if maxEntries <= 0 {
+		maxEntries = defaultLRUCacheEntries
+	}
+	return &lruExpansionCacheBackend{maxEntries: maxEntries, entries: make(map[expansionCacheKey]*list.Element), order: list.New()}
+}
+
+func (b *lruExpansionCacheBackend) Get(key expansionCacheKey) (expansionCacheEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elem, ok := b.entries[key]
+	if !ok {
+		return expansionCacheEntry{}, false
+	}
+	b.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).entry, true
+}
+
+func (b *lruExpansionCacheBackend) Put(key expansionCacheKey, entry expansionCacheEntry) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elem, ok := b.entries[key]; ok {
+		elem.Value.(*lruEntry).entry = entry
+		b.order.MoveToFront(elem)
+		return 0
+	}
+	elem := b.order.PushFront(&lruEntry{key: key, entry: entry})
+	b.entries[key] = elem
+
+	evicted := 0
+	for b.order.Len() > b.maxEntries {
+		oldest := b.order.Back()
+		if oldest == nil {
+			break
+		}
+		b.order.Remove(oldest)
+		delete(b.entries, oldest.Value.(*lruEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+func (b *lruExpansionCacheBackend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.order.Len()
+}
+
+func (b *lruExpansionCacheBackend) DeleteOlderThan(generation int64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	removed := 0
+	for elem := b.order.Front(); elem != nil; {
+		next := elem.Next()
+		le := elem.Value.(*lruEntry)
+		if le.key.Generation < generation {
+			b.order.Remove(elem)
+			delete(b.entries, le.key)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// EvictOldest drops up to n of the least-recently-used entries, reporting how
+// many were actually removed (fewer than n if the backend holds less than
+// that). startExpansionCacheMemoryEvictor (memory_evictor.go) calls this
+// under memory pressure, on top of the count-based bound Put already
+// enforces.
+func (b *lruExpansionCacheBackend) EvictOldest(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	evicted := 0
+	for evicted < n {
+		oldest := b.order.Back()
+		if oldest == nil {
+			break
+		}
+		b.order.Remove(oldest)
+		delete(b.entries, oldest.Value.(*lruEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+// diskCacheRecord is one diskExpansionCacheBackend file's JSON contents:
+// the key alongside its entry, so DeleteOlderThan can read Key.Generation
+// back out without needing a separate index.
+type diskCacheRecord struct {
+	Key       expansionCacheKey    `json:"key"`
+	Result    *DualExpansionResult `json:"result"`
+	ExpiresAt time.Time            `json:"expires_at"`
+}
+
+// diskExpansionCacheBackend persists each entry as one JSON file under dir,
+// so a cold-started process can serve cache hits left over from a previous
+// run instead of starting empty - the on-disk counterpart to
+// lruExpansionCacheBackend's in-memory map. It has no size bound of its own,
+// so DeleteOlderThan is its only reaper; lruExpansionCacheBackend also evicts
+// on Put once it holds more than maxEntries.
+type diskExpansionCacheBackend struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewDiskExpansionCacheBackend returns an ExpansionCacheBackend that stores
+// entries as files under dir, creating dir if it doesn't already exist.
+func NewDiskExpansionCacheBackend(dir string) ExpansionCacheBackend {
+	os.MkdirAll(dir, 0o755)
+	return &diskExpansionCacheBackend{dir: dir}
+}
+
+// path hashes key to a filename rather than encoding it directly, since
+// ConfigHash/ItemID could otherwise contain characters a filesystem doesn't
+// like.
+func (b *diskExpansionCacheBackend) path(key expansionCacheKey) string {
+	h := sha256.Sum256(fmt.Appendf(nil, "%s|%v|%d|%s", key.ItemID, key.Quantity, key.Generation, key.ConfigHash))
+	return filepath.Join(b.dir, hex.EncodeToString(h[:])+".json")
+}
+
+func (b *diskExpansionCacheBackend) Get(key expansionCacheKey) (expansionCacheEntry, bool) {
+	data, err := os.ReadFile(b.path(key))
+	if err != nil {
+		return expansionCacheEntry{}, false
+	}
+	var rec diskCacheRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return expansionCacheEntry{}, false
+	}
+	return expansionCacheEntry{result: rec.Result, expiresAt: rec.ExpiresAt}, true
+}
+
+func (b *diskExpansionCacheBackend) Put(key expansionCacheKey, entry expansionCacheEntry) int {
+	data, err := json.Marshal(diskCacheRecord{Key: key, Result: entry.result, ExpiresAt: entry.expiresAt})
+	if err != nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.WriteFile(b.path(key), data, 0o644); err != nil {
+		dlog("diskExpansionCacheBackend.Put: write failed for %s: %v", key.ItemID, err)
+	}
+	return 0
+}
+
+func (b *diskExpansionCacheBackend) Len() int {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+func (b *diskExpansionCacheBackend) DeleteOlderThan(generation int64) int {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		path := filepath.Join(b.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var rec diskCacheRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			continue
+		}
+		if rec.Key.Generation < generation {
+			if err := os.Remove(path); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,73 @@
+// expansion_events.go
+package main
+
+import "context"
+
+// ExpansionEventType discriminates ExpansionEvent, the same string-constant
+// style ExpandJobStatus (expand_job.go) uses for its own lifecycle states.
+type ExpansionEventType string
+
+const (
+	EventCraftEvaluated   ExpansionEventType = "CraftEvaluated"
+	EventPrimaryEvaluated ExpansionEventType = "PrimaryEvaluated"
+	EventDecisionMade     ExpansionEventType = "DecisionMade"
+	EventSubtreeExpanded  ExpansionEventType = "SubtreeExpanded"
+	EventDone             ExpansionEventType = "Done"
+)
+
+// ExpansionEvent is one progress frame emitted onto ExpansionOptions.Events
+// while PerformDualExpansion runs, so a caller (ExpandDualBasedStream, or an
+// SSE handler built on it) can render a live expanding recipe tree instead of
+// waiting for the final DualExpansionResult. Only the fields relevant to
+// Type are populated; the rest are left at their zero value, the same
+// flat-struct-per-event-type shape ExpandProgressEvent (expand_job.go)
+// already uses for its own NDJSON frames.
+type ExpansionEvent struct {
+	Type ExpansionEventType `json:"type"`
+
+	// CraftEvaluated / PrimaryEvaluated
+	CostRaw     float64 `json:"cost_raw,omitempty"`
+	FillTimeRaw float64 `json:"fill_time_raw,omitempty"`
+
+	// DecisionMade
+	Perspective string `json:"perspective,omitempty"`
+	Method      string `json:"method,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+
+	// SubtreeExpanded
+	ItemName string `json:"item_name,omitempty"`
+	Depth    int    `json:"depth,omitempty"`
+}
+
+// eventSinkContextKey is the context.Context key PerformDualExpansion
+// stashes ExpansionOptions.Events under, following the same
+// unexported-struct-key idiom as tree_builder.go's
+// sharedRecipeMemoContextKey, so expandItemRecursiveTree can emit
+// SubtreeExpanded events without a new parameter threaded through every call
+// in between.
+type eventSinkContextKey struct{}
+
+// contextWithEventSink returns a child of ctx carrying events, or ctx
+// unchanged if events is nil, so emitExpansionEvent can unconditionally
+// check for a sink without its caller needing to guard the nil case first.
+func contextWithEventSink(ctx context.Context, events chan<- ExpansionEvent) context.Context {
+	if events == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, eventSinkContextKey{}, events)
+}
+
+// emitExpansionEvent sends e to ctx's event sink, if one was installed via
+// contextWithEventSink, without blocking past ctx's own cancellation. It is
+// a no-op when no sink is present - the common case for a plain
+// PerformDualExpansion call with ExpansionOptions.Events left nil.
+func emitExpansionEvent(ctx context.Context, e ExpansionEvent) {
+	sink, ok := ctx.Value(eventSinkContextKey{}).(chan<- ExpansionEvent)
+	if !ok {
+		return
+	}
+	select {
+	case sink <- e:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,80 @@
+// expansion_test.go
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeFillTimeStatsEmptyMap(t *testing.T) {
+	stats := computeFillTimeStats(map[string]float64{})
+
+	if stats.Count != 0 {
+		t.Errorf("Count = %d, want 0", stats.Count)
+	}
+	if stats.UnfillableCount != 0 {
+		t.Errorf("UnfillableCount = %d, want 0", stats.UnfillableCount)
+	}
+	if !math.IsNaN(float64(stats.MeanSeconds)) {
+		t.Errorf("MeanSeconds = %v, want NaN", float64(stats.MeanSeconds))
+	}
+	if !math.IsNaN(float64(stats.MedianSeconds)) {
+		t.Errorf("MedianSeconds = %v, want NaN", float64(stats.MedianSeconds))
+	}
+	if stats.P50ItemID != "" {
+		t.Errorf("P50ItemID = %q, want empty", stats.P50ItemID)
+	}
+}
+
+func TestComputeFillTimeStatsAllInf(t *testing.T) {
+	times := map[string]float64{
+		"ITEM_A": math.Inf(1),
+		"ITEM_B": math.Inf(1),
+		"ITEM_C": math.Inf(1),
+	}
+	stats := computeFillTimeStats(times)
+
+	if stats.Count != 0 {
+		t.Errorf("Count = %d, want 0", stats.Count)
+	}
+	if stats.UnfillableCount != 3 {
+		t.Errorf("UnfillableCount = %d, want 3", stats.UnfillableCount)
+	}
+	if !math.IsNaN(float64(stats.MinSeconds)) {
+		t.Errorf("MinSeconds = %v, want NaN", float64(stats.MinSeconds))
+	}
+	if !math.IsNaN(float64(stats.MaxSeconds)) {
+		t.Errorf("MaxSeconds = %v, want NaN", float64(stats.MaxSeconds))
+	}
+}
+
+func TestComputeFillTimeStatsMixedDistribution(t *testing.T) {
+	times := map[string]float64{
+		"FAST":         10,
+		"MEDIUM":       20,
+		"SLOW":         30,
+		"SLOWEST":      40,
+		"UNFILLABLE_1": math.Inf(1),
+		"UNFILLABLE_2": math.NaN(),
+	}
+	stats := computeFillTimeStats(times)
+
+	if stats.Count != 4 {
+		t.Errorf("Count = %d, want 4", stats.Count)
+	}
+	if stats.UnfillableCount != 2 {
+		t.Errorf("UnfillableCount = %d, want 2", stats.UnfillableCount)
+	}
+	if got := float64(stats.MinSeconds); got != 10 {
+		t.Errorf("MinSeconds = %v, want 10", got)
+	}
+	if got := float64(stats.MaxSeconds); got != 40 {
+		t.Errorf("MaxSeconds = %v, want 40", got)
+	}
+	if got := float64(stats.MeanSeconds); got != 25 {
+		t.Errorf("MeanSeconds = %v, want 25", got)
+	}
+	if stats.P50ItemID == "" {
+		t.Error("P50ItemID is empty, want the item at the median rank")
+	}
+}
@@ -0,0 +1,201 @@
+// feedhealth.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// phiAccrualDetector implements the Phi Accrual failure detector (Hayashibara
+// et al.): instead of a hard "stale after N seconds" cutoff, it tracks the
+// recent distribution of heartbeat inter-arrival times and converts "how
+// late is this heartbeat" into a continuous suspicion level phi, so slow but
+// still-alive feeds don't trip the same binary threshold as a truly dead one.
+type phiAccrualDetector struct {
+	mu            sync.Mutex
+	lastHeartbeat time.Time
+	intervals     []float64 // bounded sliding window, in seconds
+	maxSamples    int
+}
+
+func newPhiAccrualDetector(maxSamples int) *phiAccrualDetector {
+	if maxSamples <= 0 {
+		maxSamples = 100
+	}
+	return &phiAccrualDetector{maxSamples: maxSamples}
+}
+
+// Heartbeat records a new "the feed is alive" observation at t (e.g. a
+// bazaar snapshot with a changed LastUpdated).
+func (d *phiAccrualDetector) Heartbeat(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.lastHeartbeat.IsZero() {
+		interval := t.Sub(d.lastHeartbeat).Seconds()
+		if interval > 0 {
+			d.intervals = append(d.intervals, interval)
+			if len(d.intervals) > d.maxSamples {
+				d.intervals = d.intervals[len(d.intervals)-d.maxSamples:]
+			}
+		}
+	}
+	d.lastHeartbeat = t
+}
+
+func (d *phiAccrualDetector) meanAndStdDev() (mean, stdDev float64) {
+	n := len(d.intervals)
+	if n == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, v := range d.intervals {
+		sum += v
+	}
+	mean = sum / float64(n)
+	if n < 2 {
+		return mean, mean / 2 // no variance data yet; assume modest spread
+	}
+	variance := 0.0
+	for _, v := range d.intervals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(n)
+	stdDev = math.Sqrt(variance)
+	if stdDev < 1e-6 {
+		stdDev = mean * 0.1 // avoid a degenerate zero-variance distribution
+		if stdDev < 1e-6 {
+			stdDev = 1e-6
+		}
+	}
+	return mean, stdDev
+}
+
+// Phi returns the current suspicion level at time now: phi is near 0 just
+// after a heartbeat, about 0.3 when the gap since the last heartbeat equals
+// the historical mean, and rises ever faster as the gap grows past it. phi >= 8 conventionally means
+// "almost certainly down" (≈ 1 in 10^8 chance of a false positive assuming a
+// normal inter-arrival distribution).
+func (d *phiAccrualDetector) Phi(now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastHeartbeat.IsZero() {
+		return 0 // never seen a heartbeat; nothing to be suspicious of yet
+	}
+	mean, stdDev := d.meanAndStdDev()
+	if mean <= 0 {
+		return 0 // not enough history to judge
+	}
+	elapsed := now.Sub(d.lastHeartbeat).Seconds()
+	// P(no heartbeat within `elapsed`) approximated via a normal CDF tail.
+	y := (elapsed - mean) / stdDev
+	pLater := 1.0 - normalCDF(y)
+	if pLater <= 0 {
+		pLater = 1e-300 // avoid log(0); phi saturates very high instead of +Inf
+	}
+	return -math.Log10(pLater)
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// FeedConfidence classifies a phi value into a human-readable feed state.
+type FeedConfidence string
+
+const (
+	FeedHealthy FeedConfidence = "healthy"
+	FeedSuspect FeedConfidence = "suspect"
+	FeedDown    FeedConfidence = "down"
+)
+
+// phiSuspectThreshold/phiDownThreshold gate FeedConfidence classification;
+// 8.0 is the commonly cited phi-accrual default for "treat as down".
+var (
+	phiSuspectThreshold = 3.0
+	phiDownThreshold    = 8.0
+)
+
+// feedHealthMonitor tracks one phiAccrualDetector per product (and a
+// "global" entry for the feed as a whole, since every product shares the
+// same LastUpdated timestamp in a single Hypixel Bazaar snapshot today).
+type feedHealthMonitor struct {
+	mu        sync.Mutex
+	detectors map[string]*phiAccrualDetector
+}
+
+var globalFeedHealth = &feedHealthMonitor{detectors: make(map[string]*phiAccrualDetector)}
+
+func (m *feedHealthMonitor) detectorFor(key string) *phiAccrualDetector {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.detectors[key]
+	if !ok {
+		d = newPhiAccrualDetector(100)
+		m.detectors[key] = d
+	}
+	return d
+}
+
+const feedHealthGlobalKey = "__global__"
+
+var lastRecordedLastUpdated int64
+
+// RecordFeedSnapshot feeds a newly-fetched HypixelAPIResponse into the
+// phi-accrual detectors: the global detector always gets a heartbeat when
+// LastUpdated changes, and so does every product's own detector, so callers
+// that care about a single item's feed health (rather than the feed overall)
+// can query it independently once per-product update cadences diverge.
+func RecordFeedSnapshot(resp *HypixelAPIResponse) {
+	if resp == nil {
+		return
+	}
+	globalFeedHealth.mu.Lock()
+	changed := resp.LastUpdated != lastRecordedLastUpdated
+	if changed {
+		lastRecordedLastUpdated = resp.LastUpdated
+	}
+	globalFeedHealth.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	now := time.Now()
+	globalFeedHealth.detectorFor(feedHealthGlobalKey).Heartbeat(now)
+	for productID := range resp.Products {
+		globalFeedHealth.detectorFor(productID).Heartbeat(now)
+	}
+}
+
+// FeedHealthFor reports the phi suspicion value and classification for key
+// (a product ID, or feedHealthGlobalKey for the feed overall) as of now.
+func FeedHealthFor(key string) (phi float64, confidence FeedConfidence) {
+	phi = globalFeedHealth.detectorFor(key).Phi(time.Now())
+	switch {
+	case phi >= phiDownThreshold:
+		confidence = FeedDown
+	case phi >= phiSuspectThreshold:
+		confidence = FeedSuspect
+	default:
+		confidence = FeedHealthy
+	}
+	return phi, confidence
+}
+
+// gateFillTimeConfidence wraps a fill-time calculation's (value, error)
+// result with the feed's current confidence for itemID, so a caller can
+// distinguish "this number is right on time for a healthy feed" from "this
+// number is plausible-looking but the upstream poller for this item looks
+// stuck or stale". It never changes the returned value; err passes through
+// unchanged, except that a nil err becomes an error when the feed is FeedDown.
+func gateFillTimeConfidence(itemID string, fillTime float64, err error) (float64, error, FeedConfidence) {
+	phi, confidence := FeedHealthFor(BAZAAR_ID(itemID))
+	if confidence != FeedHealthy {
+		dlog("gateFillTimeConfidence: %s feed confidence=%s (phi=%.2f)", itemID, confidence, phi)
+		if err == nil && confidence == FeedDown {
+			err = fmt.Errorf("feed for %s appears down (phi=%.2f); fill time estimate may be stale", itemID, phi)
+		}
+	}
+	return fillTime, err, confidence
+}
@@ -0,0 +1,12 @@
+// fill_stream.go
+package main
+
+// GET /api/fill/stream is the backlog's name for this request's single-item
+// streaming endpoint; this repo has no separate "fill" expansion pipeline to
+// stream from, though (batch_fill.go's runFillItem already calls the exact
+// same PerformDualExpansion every other per-item handler in this package
+// calls, including ExpandDualBasedStream's /api/expand-dual/stream). Rather
+// than duplicate expandDualStreamHandler's SSE plumbing under a second name,
+// /api/fill/stream is registered directly onto it in main.go - the same
+// operation this package already streams, just reachable at the route name
+// this request asks for.
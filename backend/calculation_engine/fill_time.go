@@ -2,35 +2,48 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
 )
 
-// calculateInstasellFillTime calculates the time to instasell a quantity of an item.
+// calculateInstasellFillTime calculates the time to instasell a quantity of
+// an item at its 7-day buy rate: it is calculateInstasellFillTimeWithWindow
+// with window=WindowSevenDays, so the rate comes from that function's store,
+// EMA, or flat BuyMovingWeek/MovingWindowSeconds sources. Call
+// calculateInstasellFillTimeWithWindow directly to price against a shorter,
+// more recent window instead.
 func calculateInstasellFillTime(qty float64, productData HypixelProduct) (float64, error) {
-	dlog("Calculating Instasell Fill Time for qty %.2f of %s", qty, productData.ProductID)
+	return calculateInstasellFillTimeWithWindow(qty, productData, WindowSevenDays)
+}
+
+// calculateInstasellFillTimeWithWindow is calculateInstasellFillTime, but
+// the buy rate is drawn from DefaultSerialMetricsStore's RateOverWindow(window)
+// instead of the flat 7-day QuickStatus.BuyMovingWeek/604800 average, so a
+// caller can price a flip against short-term liquidity (window=1h) or the
+// long-term average (window=7*24h). Falls back to the flat average whenever
+// the store has insufficient history (e.g. a cold start) or isn't available.
+func calculateInstasellFillTimeWithWindow(qty float64, productData HypixelProduct, window time.Duration) (float64, error) {
+	dlog("Calculating Instasell Fill Time for qty %.2f of %s over window %s", qty, productData.ProductID, window)
 	if qty <= 0 {
 		dlog("  Qty <= 0, instasell fill time is 0.")
 		return 0, nil
 	}
 
-	buyMovingWeek := productData.QuickStatus.BuyMovingWeek
-	dlog("  Using live BuyMovingWeek: %.2f", buyMovingWeek)
+	buyRatePerSecond, source := instasellRateOverWindow(productData, window)
+	dlog("  Using %s buy rate per second: %.5f", source, buyRatePerSecond)
+	DefaultMetrics(nil).FillTimeLastBuyMovingWeek.Set(productData.QuickStatus.BuyMovingWeek)
 
-	if buyMovingWeek <= 0 {
-		dlog("  Live BuyMovingWeek <= 0, instasell fill time is Infinite.")
+	if buyRatePerSecond <= 0 {
+		dlog("  %s buy rate <= 0, instasell fill time is Infinite.", source)
+		DefaultMetrics(nil).FillTimeErrorsTotal.WithLabelValues("bmw_zero").Inc()
 		// Return Inf(1) as this function's contract implies calculable time or an error state represented by Inf.
 		// The caller (PerformDualExpansion) will convert this to NaN if needed for storage.
-		return math.Inf(1), fmt.Errorf("live BuyMovingWeek is <= 0 for %s", productData.ProductID)
-	}
-
-	secondsInWeek := 604800.0
-	buyRatePerSecond := buyMovingWeek / secondsInWeek
-	dlog("  Buy rate per second: %.5f", buyRatePerSecond)
-
-	if buyRatePerSecond <= 0 { // Should be caught by buyMovingWeek <=0, but defensive
-		dlog("  WARN: buyRatePerSecond <= 0 despite buyMovingWeek > 0. Fill time Infinite.")
-		return math.Inf(1), fmt.Errorf("calculated buy rate per second is <= 0 for %s", productData.ProductID)
+		return math.Inf(1), fmt.Errorf("%s buy rate is <= 0 for %s", source, productData.ProductID)
 	}
 
 	timeToFill := qty / buyRatePerSecond
@@ -43,23 +56,173 @@ func calculateInstasellFillTime(qty float64, productData HypixelProduct) (float6
 	}
 
 	dlog("  Instasell Fill Time Result: %.4f seconds", timeToFill)
+	DefaultMetrics(nil).InstasellFillTimeSeconds.Observe(timeToFill)
 	return timeToFill, nil
 }
 
-// calculateBuyOrderFillTime calculates the buy order fill time based on metrics.
-func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData ProductMetrics) (float64, float64, error) {
-	normItemID := BAZAAR_ID(itemID) // Assuming BAZAAR_ID is available
-	dlog("Calculating Buy Order Fill Time for %.0f x %s using LaTeX formula logic", quantity, normItemID)
+// instasellRateOverWindow returns the per-second instasell (buy order fill)
+// rate for productData over window, preferring DefaultSerialMetricsStore's
+// differenced recent rate and falling back to the flat
+// QuickStatus.BuyMovingWeek/604800 average when the store errors or doesn't
+// have enough history yet. source names which one was used, for logging.
+// This is DefaultFillTimeConfig's fixed-window entry point; see
+// instasellRateOverWindowWithConfig for the configurable one.
+func instasellRateOverWindow(productData HypixelProduct, window time.Duration) (rate float64, source string) {
+	return instasellRateOverWindowWithConfig(productData, window, DefaultFillTimeConfig)
+}
 
-	var calculatedRR float64 = math.NaN() // This is the RR for the formula, not necessarily the final RR for the item
-	fillTime := math.NaN()                // Default to NaN, will be Inf or a value
-	var calcErr error
+// instasellRateOverWindowWithConfig is instasellRateOverWindow with
+// cfg.MovingWindowSeconds standing in for the flat average's literal
+// 604800.0 divisor, plus a cfg.RateSource == "ema" mode that routes the flat
+// QuickStatus.BuyMovingWeek reading through DefaultRateTracker instead of
+// reporting it as-is, so a single quiet week decays toward the new rate over
+// RateTracker's half-life rather than reporting a raw 0 (which would make
+// the caller's fill time Infinite; see RateTracker's doc comment).
+func instasellRateOverWindowWithConfig(productData HypixelProduct, window time.Duration, cfg FillTimeConfig) (rate float64, source string) {
+	cfg = resolveFillTimeConfig(cfg)
+	if cfg.RateSource == "ema" {
+		rawRate := productData.QuickStatus.BuyMovingWeek / cfg.MovingWindowSeconds
+		return DefaultRateTracker().Update(productData.ProductID, rawRate), "ema"
+	}
+	if store, err := DefaultSerialMetricsStore(); err == nil {
+		if rates, ok := store.RateOverWindow(productData.ProductID, window); ok {
+			return rates.BuyRatePerSecond, fmt.Sprintf("%s-window", window)
+		}
+	}
+	return productData.QuickStatus.BuyMovingWeek / cfg.MovingWindowSeconds, "flat 7d"
+}
 
-	if quantity <= 0 {
-		dlog("  Quantity <= 0, returning 0 time, NaN RR, nil error")
-		return 0, math.NaN(), nil // 0 time, RR is not applicable (NaN)
+// RateTracker maintains an EMA-smoothed per-item rate so a single quiet
+// week's raw reading (which can be 0, and make calculateInstasellFillTime
+// report an Infinite fill time) decays toward a new reading over halfLife
+// instead of reporting it verbatim. Safe for concurrent use.
+type RateTracker struct {
+	mu       sync.Mutex
+	entries  map[string]rateTrackerEntry
+	halfLife time.Duration
+	floor    float64
+}
+
+type rateTrackerEntry struct {
+	rate       float64
+	lastUpdate time.Time
+}
+
+// DefaultRateTrackerHalfLife and DefaultRateTrackerFloor are
+// DefaultRateTracker's settings: a 30-minute half-life, and a floor that
+// keeps Update's result strictly above zero so a quiet week decays toward a
+// very slow but still finite fill time instead of instasellRateOverWindowWithConfig's
+// "rate <= 0 is Infinite" branch.
+const (
+	DefaultRateTrackerHalfLife = 30 * time.Minute
+	DefaultRateTrackerFloor    = 1e-6
+)
+
+// NewRateTracker builds a RateTracker with the given half-life and floor.
+// halfLife <= 0 falls back to DefaultRateTrackerHalfLife; floor < 0 falls
+// back to DefaultRateTrackerFloor.
+func NewRateTracker(halfLife time.Duration, floor float64) *RateTracker {
+	if halfLife <= 0 {
+		halfLife = DefaultRateTrackerHalfLife
+	}
+	if floor < 0 {
+		floor = DefaultRateTrackerFloor
+	}
+	return &RateTracker{entries: make(map[string]rateTrackerEntry), halfLife: halfLife, floor: floor}
+}
+
+// Update folds rawRate into itemID's EMA (seeding it on first call) and
+// returns the new smoothed value, floored at t.floor. The decay weight is
+// derived from the elapsed wall-clock time since itemID's last Update, so
+// callers don't need to invoke it on a fixed schedule.
+func (t *RateTracker) Update(itemID string, rawRate float64) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	prev, ok := t.entries[itemID]
+	if !ok {
+		smoothed := math.Max(rawRate, t.floor)
+		t.entries[itemID] = rateTrackerEntry{rate: smoothed, lastUpdate: now}
+		return smoothed
+	}
+
+	dt := now.Sub(prev.lastUpdate)
+	alpha := 1 - math.Exp(-math.Ln2*dt.Seconds()/t.halfLife.Seconds())
+	smoothed := math.Max(alpha*rawRate+(1-alpha)*prev.rate, t.floor)
+	t.entries[itemID] = rateTrackerEntry{rate: smoothed, lastUpdate: now}
+	return smoothed
+}
+
+// Rate returns itemID's current smoothed rate and whether Update has been
+// called for it yet.
+func (t *RateTracker) Rate(itemID string) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[itemID]
+	return e.rate, ok
+}
+
+var (
+	defaultRateTracker     *RateTracker
+	defaultRateTrackerOnce sync.Once
+)
+
+// DefaultRateTracker lazily builds the package-wide RateTracker used by
+// instasellRateOverWindowWithConfig's "ema" RateSource, with
+// DefaultRateTrackerHalfLife/DefaultRateTrackerFloor settings.
+func DefaultRateTracker() *RateTracker {
+	defaultRateTrackerOnce.Do(func() {
+		defaultRateTracker = NewRateTracker(DefaultRateTrackerHalfLife, DefaultRateTrackerFloor)
+	})
+	return defaultRateTracker
+}
+
+// calculateBuyOrderFillTime calculates the buy order fill time based on
+// metrics, i.e. pm.SellSize/SellFrequency/OrderSize/OrderFrequency as given
+// (already rates, not windowed counters - so there's no window parameter
+// here the way there is for calculateInstasellFillTime; see
+// calculateBuyOrderFillTimeWithWindow for the variant that lets the caller
+// swap in a shorter-window view of those same rates from SerialMetricsStore).
+// ctx is checked up front: this runs once per base ingredient from the
+// expansion tree, so a cancelled/expired request should stop pricing
+// ingredients rather than keep computing values nobody will read.
+func calculateBuyOrderFillTime(ctx context.Context, itemID string, quantity float64, metricsData ProductMetrics) (float64, float64, error) {
+	if err := ctx.Err(); err != nil {
+		return math.NaN(), math.NaN(), err
+	}
+	return calculateBuyOrderFillTimeWithWindow(itemID, quantity, metricsData, WindowSevenDays)
+}
+
+// calculateBuyOrderFillTimeWithWindow is calculateBuyOrderFillTime, but
+// pm.SellSize/SellFrequency/OrderSize/OrderFrequency are first overridden
+// with DefaultSerialMetricsStore's RateOverWindow(window) averages when the
+// store has enough history for itemID, falling back to the metricsData
+// values passed in otherwise.
+func calculateBuyOrderFillTimeWithWindow(itemID string, quantity float64, metricsData ProductMetrics, window time.Duration) (float64, float64, error) {
+	normItemID := BAZAAR_ID(itemID) // Assuming BAZAAR_ID is available
+	pm := metricsData
+	if store, err := DefaultSerialMetricsStore(); err == nil {
+		if rates, ok := store.RateOverWindow(normItemID, window); ok {
+			pm.SellSize = rates.SellSize
+			pm.SellFrequency = rates.SellFrequency
+			pm.OrderSize = rates.OrderSize
+			pm.OrderFrequency = rates.OrderFrequency
+			dlog("  Using %s-window order-flow metrics for %s (%d samples)", window, normItemID, rates.SampleCount)
+		}
 	}
+	fillTime, rr, err := calculateBuyOrderFillTimeCore(normItemID, quantity, pm)
+	if !math.IsInf(fillTime, 0) && !math.IsNaN(fillTime) {
+		DefaultMetrics(nil).BuyOrderFillTimeSeconds.Observe(fillTime)
+	}
+	return fillTime, rr, err
+}
 
+// calculateBuyOrderFillTimeCore derives the supply/demand rates and order
+// frequency out of metricsData and delegates the LaTeX-formula body to
+// calculateBuyOrderFillTimeFromRates, so both the plain and *WithWindow
+// entry points share it - only where pm's rates come from differs.
+func calculateBuyOrderFillTimeCore(normItemID string, quantity float64, metricsData ProductMetrics) (float64, float64, error) {
 	pm := metricsData
 	dlog("  Using Metrics: SS=%.2f, SF=%.2f, OS=%.2f, OF=%.2f", pm.SellSize, pm.SellFrequency, pm.OrderSize, pm.OrderFrequency)
 
@@ -68,11 +231,102 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 	o_s_metric := math.Max(0, pm.OrderSize)
 	o_f_metric := math.Max(0, pm.OrderFrequency)
 
-	dlog("  Clamped Metrics: s_s=%.4f, s_f=%.4f, o_s_metric=%.4f, o_f_metric=%.4f", s_s, s_f, o_s_metric, o_f_metric)
+	return calculateBuyOrderFillTimeFromRates(normItemID, quantity, s_s*s_f, o_s_metric*o_f_metric, o_f_metric)
+}
+
+// FillTimeConfig exposes the buy-order formula's two previously-hardcoded
+// constants - the order cycle time (20.0) and the moving-window length
+// (604800.0, 7 days in seconds) behind QuickStatus.BuyMovingWeek/SellMovingWeek -
+// plus which rate source feeds the formula, so a deployment that's tuned
+// those numbers empirically doesn't need a code change to try different
+// ones. Zero-value fields fall back to DefaultFillTimeConfig's values via
+// resolveFillTimeConfig.
+type FillTimeConfig struct {
+	// OrderCycleSeconds replaces calculateBuyOrderFillTimeFromRates' literal
+	// 20.0 multiplier. <= 0 falls back to DefaultFillTimeConfig.OrderCycleSeconds.
+	OrderCycleSeconds float64
+	// MovingWindowSeconds replaces instasellRateOverWindow's literal 604800.0
+	// divisor for the flat QuickStatus.BuyMovingWeek average.
+	// calculateBuyOrderFillTimeFromOrderBook's SellMovingWeek rate does not
+	// read it and still divides by 604800.0.
+	// <= 0 falls back to DefaultFillTimeConfig.MovingWindowSeconds.
+	MovingWindowSeconds float64
+	// RateSource selects how the instasell buy rate is derived: "moving_week"
+	// (default - the flat QuickStatus.BuyMovingWeek/MovingWindowSeconds
+	// average, or DefaultSerialMetricsStore's windowed rate where available,
+	// same as today) or "ema" (DefaultRateTracker's EMA-smoothed rate - see
+	// RateTracker - which rides through a single quiet week instead of
+	// reporting whatever that week's raw BuyMovingWeek happened to be).
+	RateSource string
+}
+
+// DefaultFillTimeConfig is calculateBuyOrderFillTime/calculateInstasellFillTime's
+// unconfigured behavior: the LaTeX formula's original 20.0 cycle time and
+// 604800.0 (7-day) moving window, rate source "moving_week".
+var DefaultFillTimeConfig = FillTimeConfig{
+	OrderCycleSeconds:   20.0,
+	MovingWindowSeconds: 604800.0,
+	RateSource:          "moving_week",
+}
+
+// resolveFillTimeConfig fills any zero-value field of cfg in from
+// DefaultFillTimeConfig, the same <=0-falls-back-to-default convention
+// ExpansionOptions.MaxMetricsAgeSecs (expansion.go) and most other
+// configurable-with-a-zero-value fields in this package already use.
+func resolveFillTimeConfig(cfg FillTimeConfig) FillTimeConfig {
+	if cfg.OrderCycleSeconds <= 0 {
+		cfg.OrderCycleSeconds = DefaultFillTimeConfig.OrderCycleSeconds
+	}
+	if cfg.MovingWindowSeconds <= 0 {
+		cfg.MovingWindowSeconds = DefaultFillTimeConfig.MovingWindowSeconds
+	}
+	if cfg.RateSource == "" {
+		cfg.RateSource = DefaultFillTimeConfig.RateSource
+	}
+	return cfg
+}
+
+// calculateBuyOrderFillTimeFromRates is calculateBuyOrderFillTime's original
+// LaTeX-formula body, re-expressed in terms of the already-multiplied
+// supplyRate (s_s*s_f) and demandRate (o_s_metric*o_f_metric) plus the raw
+// order frequency those need divided out again for the RR calculation.
+// Factoring it this way lets calculateBuyOrderFillTimeWithBand perturb just
+// supplyRate by ±k*ATR to get the low/high band without having to invent a
+// fake SellSize/SellFrequency split that reproduces the same product. This
+// is DefaultFillTimeConfig's fixed-constant entry point; see
+// calculateBuyOrderFillTimeFromRatesWithConfig for the configurable one.
+func calculateBuyOrderFillTimeFromRates(normItemID string, quantity, supplyRate, demandRate, orderFrequency float64) (float64, float64, error) {
+	return calculateBuyOrderFillTimeFromRatesWithConfig(normItemID, quantity, supplyRate, demandRate, orderFrequency, DefaultFillTimeConfig)
+}
+
+// calculateBuyOrderFillTimeFromRatesWithConfig is calculateBuyOrderFillTimeFromRates
+// with cfg.OrderCycleSeconds standing in for the formula's literal 20.0 cycle
+// time. This is a separate entry point rather than adding a cfg parameter to
+// calculateBuyOrderFillTimeFromRates itself, since that function already has
+// several callers (calculateBuyOrderFillTimeCore, calculateBuyOrderFillTimeWithBand)
+// relying on its exact signature.
+func calculateBuyOrderFillTimeFromRatesWithConfig(normItemID string, quantity, supplyRate, demandRate, orderFrequency float64, cfg FillTimeConfig) (float64, float64, error) {
+	cfg = resolveFillTimeConfig(cfg)
+	cycleSeconds := cfg.OrderCycleSeconds
+	dlog("Calculating Buy Order Fill Time for %.0f x %s using LaTeX formula logic", quantity, normItemID)
+
+	var calculatedRR float64 = math.NaN() // This is the RR for the formula, not necessarily the final RR for the item
+	fillTime := math.NaN()                // Default to NaN, will be Inf or a value
+	var calcErr error
+
+	if quantity <= 0 {
+		dlog("  Quantity <= 0, returning 0 time, NaN RR, nil error")
+		return 0, math.NaN(), nil // 0 time, RR is not applicable (NaN)
+	}
+
+	supplyRate = math.Max(0, supplyRate)
+	o_f_metric := math.Max(0, orderFrequency)
 
-	deltaNetFlow := (s_s * s_f) - (o_s_metric * o_f_metric)
-	dlog("  Net Flow Rate (Δ) = (s_s * s_f) - (o_s_metric * o_f_metric) = (%.4f * %.4f) - (%.4f * %.4f) = %.4f",
-		s_s, s_f, o_s_metric, o_f_metric, deltaNetFlow)
+	deltaNetFlow := supplyRate - demandRate
+	dlog("  Net Flow Rate (Δ) = supplyRate - demandRate = %.4f - %.4f = %.4f", supplyRate, demandRate, deltaNetFlow)
+	DefaultMetrics(nil).FillTimeLastSupplyRate.Set(supplyRate)
+	DefaultMetrics(nil).FillTimeLastDemandRate.Set(demandRate)
+	DefaultMetrics(nil).FillTimeLastDeltaNetFlow.Set(deltaNetFlow)
 
 	if deltaNetFlow > 0 {
 		dlog("  Δ > 0 (%.4f), using Fill Time = (20 * qty) / Δ", deltaNetFlow)
@@ -82,20 +336,21 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 				calcErr = fmt.Errorf("deltaNetFlow is zero in positive delta branch for %s", normItemID)
 			}
 		} else {
-			fillTime = (20.0 * quantity) / deltaNetFlow
+			fillTime = (cycleSeconds * quantity) / deltaNetFlow
 		}
-		dlog("    Fill Time = (20 * %.2f) / %.4f = %.4f", quantity, deltaNetFlow, fillTime)
+		dlog("    Fill Time = (%.1f * %.2f) / %.4f = %.4f", cycleSeconds, quantity, deltaNetFlow, fillTime)
 
 		// Calculate contextual RR for this branch
 		var localIF float64
-		if o_f_metric <= 0 || s_f <= 0 { // if o_f_metric is 0, or s_f is 0 (no supply to meet demand)
+		if o_f_metric <= 0 || supplyRate <= 0 { // no order frequency to divide by, or no supply to meet demand
 			localIF = 0
 		} else {
-			localIF = s_s * (s_f / o_f_metric) // InstaFills per order cycle
+			localIF = supplyRate / o_f_metric // InstaFills per order cycle
 		}
 
 		if localIF <= 0 { // if IF is zero or negative, RR is effectively infinite for positive quantity
 			calculatedRR = math.Inf(1)
+			DefaultMetrics(nil).FillTimeErrorsTotal.WithLabelValues("if_zero").Inc()
 		} else {
 			calculatedRR = math.Ceil(quantity / localIF)
 			if calculatedRR < 1 && !math.IsInf(calculatedRR, 0) { // Ensure RR is at least 1 unless it's already Inf
@@ -107,11 +362,11 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 		dlog("  Δ <= 0 (%.4f), using Fill Time = (20 * RR * qty) / o_f_metric", deltaNetFlow)
 		// Calculate contextual RR for this branch
 		var localIF float64
-		if o_f_metric <= 0 || s_f <= 0 { // if o_f_metric is 0, or s_f is 0
+		if o_f_metric <= 0 || supplyRate <= 0 { // no order frequency to divide by, or no supply to meet demand
 			localIF = 0
-			dlog("    o_f_metric or s_f is 0 or less, so localIF is 0 for RR calculation.")
+			dlog("    o_f_metric or supplyRate is 0 or less, so localIF is 0 for RR calculation.")
 		} else {
-			localIF = s_s * (s_f / o_f_metric)
+			localIF = supplyRate / o_f_metric
 			if localIF < 0 { // Ensure IF is not negative
 				localIF = 0
 			}
@@ -121,6 +376,7 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 		if localIF <= 0 { // if IF is zero or negative, RR is effectively infinite for positive quantity
 			calculatedRR = math.Inf(1)
 			dlog("    localIF <= 0, so calculatedRR for formula is Infinite.")
+			DefaultMetrics(nil).FillTimeErrorsTotal.WithLabelValues("if_zero").Inc()
 		} else {
 			calculatedRR = math.Ceil(quantity / localIF)
 			if calculatedRR < 1 && !math.IsInf(calculatedRR, 0) { // Ensure RR is at least 1
@@ -140,6 +396,7 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 			if calcErr == nil {
 				calcErr = fmt.Errorf("order frequency (o_f_metric) is zero and Δ <= 0, cannot calculate fill time for %s", normItemID)
 			}
+			DefaultMetrics(nil).FillTimeErrorsTotal.WithLabelValues("of_zero").Inc()
 		} else if math.IsInf(calculatedRR, 1) { // If RR needed for formula is Inf
 			dlog("    CalculatedRR for formula is Infinite, fill time is Infinite.")
 			fillTime = math.Inf(1)
@@ -147,8 +404,8 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 				calcErr = fmt.Errorf("calculated RR for formula is infinite and Δ <= 0 for %s", normItemID)
 			}
 		} else {
-			fillTime = (20.0 * calculatedRR * quantity) / o_f_metric
-			dlog("    Fill Time = (20 * %.2f * %.2f) / %.4f = %.4f", calculatedRR, quantity, o_f_metric, fillTime)
+			fillTime = (cycleSeconds * calculatedRR * quantity) / o_f_metric
+			dlog("    Fill Time = (%.1f * %.2f * %.2f) / %.4f = %.4f", cycleSeconds, calculatedRR, quantity, o_f_metric, fillTime)
 		}
 	}
 
@@ -159,6 +416,10 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 		if calcErr == nil {    // Set error if not already set by more specific condition
 			calcErr = fmt.Errorf("fill time calculation resulted in invalid value for %s", normItemID)
 		}
+		DefaultMetrics(nil).FillTimeErrorsTotal.WithLabelValues("nan_result").Inc()
+	}
+	if !math.IsNaN(calculatedRR) && !math.IsInf(calculatedRR, 0) {
+		DefaultMetrics(nil).FillTimeLastCalculatedRR.Set(calculatedRR)
 	}
 
 	// For the returned calculatedRR (which is for formula context, not the item's final RR):
@@ -175,3 +436,377 @@ func calculateBuyOrderFillTime(itemID string, quantity float64, metricsData Prod
 	// fillTime can be Inf. calculatedRR (for formula context) can be Inf or NaN.
 	return fillTime, calculatedRR, calcErr
 }
+
+// FillTimeEstimate is calculateBuyOrderFillTimeWithBand's result: a point
+// estimate (Mid, what calculateBuyOrderFillTime itself returns) bracketed
+// by Low/High, the fastest/slowest fill time implied by perturbing the
+// supply rate by ±k*ATR. ATR is the smoothed rate-volatility reading the
+// band was computed from, for callers that want to show it directly.
+type FillTimeEstimate struct {
+	Low  float64
+	Mid  float64
+	High float64
+	ATR  float64
+}
+
+// DefaultATRPeriod and DefaultATRMultiplier are calculateBuyOrderFillTimeWithBand's
+// defaults, applied when atrPeriod/k are passed as 0: N=14 samples (the
+// standard ATR lookback) and k=1.0 (a one-ATR band).
+const (
+	DefaultATRPeriod     = 14
+	DefaultATRMultiplier = 1.0
+)
+
+// computeRateATR applies Wilder's smoothing to the true-range series of
+// samples' supplyRate (SellSize*SellFrequency) and demandRate
+// (OrderSize*OrderFrequency), treating those two series the way ATR treats
+// high/low/close: TR_i = max(|SR_i-SR_{i-1}|, |DR_i-DR_{i-1}|, |SR_i-DR_i|),
+// ATR_i = ((period-1)*ATR_{i-1} + TR_i) / period, seeded with ATR_1 = TR_1.
+// ok is false with fewer than 2 samples (no TR can be formed).
+func computeRateATR(samples []MetricsSample, period int) (atr float64, ok bool) {
+	if period <= 0 {
+		period = DefaultATRPeriod
+	}
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	seeded := false
+	for i := 1; i < len(samples); i++ {
+		prevSupply := samples[i-1].SellSize * samples[i-1].SellFrequency
+		prevDemand := samples[i-1].OrderSize * samples[i-1].OrderFrequency
+		curSupply := samples[i].SellSize * samples[i].SellFrequency
+		curDemand := samples[i].OrderSize * samples[i].OrderFrequency
+
+		tr := math.Abs(curSupply - prevSupply)
+		if v := math.Abs(curDemand - prevDemand); v > tr {
+			tr = v
+		}
+		if v := math.Abs(curSupply - curDemand); v > tr {
+			tr = v
+		}
+
+		if !seeded {
+			atr = tr
+			seeded = true
+			continue
+		}
+		atr = (float64(period-1)*atr + tr) / float64(period)
+	}
+	return atr, true
+}
+
+// calculateBuyOrderFillTimeWithBand is calculateBuyOrderFillTimeWithWindow
+// plus an ATR-style confidence band: Mid is the same point estimate, and
+// Low/High recompute it with the supply rate nudged by ±k*ATR (ATR smoothed
+// over the trailing atrPeriod samples from DefaultSerialMetricsStore),
+// giving a "typical 4m, worst-case 22m" range instead of a single number.
+// Falls back to Low = Mid = High (ATR = 0) when there isn't enough sample
+// history yet to compute an ATR.
+func calculateBuyOrderFillTimeWithBand(itemID string, quantity float64, metricsData ProductMetrics, window time.Duration, atrPeriod int, k float64) (FillTimeEstimate, float64, error) {
+	normItemID := BAZAAR_ID(itemID)
+	if atrPeriod <= 0 {
+		atrPeriod = DefaultATRPeriod
+	}
+	if k <= 0 {
+		k = DefaultATRMultiplier
+	}
+
+	pm := metricsData
+	if store, err := DefaultSerialMetricsStore(); err == nil {
+		if rates, ok := store.RateOverWindow(normItemID, window); ok {
+			pm.SellSize = rates.SellSize
+			pm.SellFrequency = rates.SellFrequency
+			pm.OrderSize = rates.OrderSize
+			pm.OrderFrequency = rates.OrderFrequency
+		}
+	}
+
+	s_s := math.Max(0, pm.SellSize)
+	s_f := math.Max(0, pm.SellFrequency)
+	o_s_metric := math.Max(0, pm.OrderSize)
+	o_f_metric := math.Max(0, pm.OrderFrequency)
+	supplyRate := s_s * s_f
+	demandRate := o_s_metric * o_f_metric
+
+	mid, rr, err := calculateBuyOrderFillTimeFromRates(normItemID, quantity, supplyRate, demandRate, o_f_metric)
+
+	var atr float64
+	if store, serr := DefaultSerialMetricsStore(); serr == nil {
+		if samples := store.RecentSamples(normItemID, atrPeriod+1); len(samples) >= 2 {
+			if a, ok := computeRateATR(samples, atrPeriod); ok {
+				atr = a
+			}
+		}
+	}
+
+	// Higher supply rate fills faster (low estimate); lower supply rate
+	// fills slower (high estimate, clamped at 0 so it can't go negative).
+	low, _, _ := calculateBuyOrderFillTimeFromRates(normItemID, quantity, supplyRate+k*atr, demandRate, o_f_metric)
+	high, _, _ := calculateBuyOrderFillTimeFromRates(normItemID, quantity, math.Max(0, supplyRate-k*atr), demandRate, o_f_metric)
+
+	dlog("  Buy Order Fill Time band for %s: low=%.2f mid=%.2f high=%.2f (ATR=%.4f, k=%.2f)", normItemID, low, mid, high, atr, k)
+	return FillTimeEstimate{Low: low, Mid: mid, High: high, ATR: atr}, rr, err
+}
+
+// FillTimePercentileEstimate is calculateBuyOrderFillTimePercentile's result:
+// a Monte-Carlo distribution over recent DefaultSerialMetricsStore samples
+// for an item, rather than calculateBuyOrderFillTimeWithBand's single
+// ATR-perturbed low/mid/high band. Mean mirrors the plain
+// calculateBuyOrderFillTime point estimate for callers that only want one
+// number (see calculateBuyOrderFillTimePercentileValue); P50/P90/P99 and
+// StdDev are included so a caller that wants the shape of the distribution,
+// not just a band around the point estimate, doesn't have to resample itself.
+type FillTimePercentileEstimate struct {
+	Mean   float64
+	P50    float64
+	P90    float64
+	P99    float64
+	StdDev float64
+}
+
+// DefaultFillTimeMonteCarloSamples is how many draws
+// calculateBuyOrderFillTimePercentile takes from an item's recent-sample
+// history when estimating the distribution.
+const DefaultFillTimeMonteCarloSamples = 1000
+
+// ActiveFillTimePercentile is the percentile calculateBuyOrderFillTimePercentileValue
+// reads off a FillTimePercentileEstimate for callers that want a single
+// number rather than the full distribution, set from the -fill-time-percentile
+// flag (main.go) the same way ActiveRecipeSelectionPolicy is set from
+// -recipe-selection (tree_builder.go). One of "mean", "p50", "p90", "p99";
+// anything else falls back to "mean".
+var ActiveFillTimePercentile = "mean"
+
+// calculateBuyOrderFillTimePercentile is calculateBuyOrderFillTime's
+// confidence-interval counterpart: instead of deriving supplyRate/demandRate
+// from metricsData's single current snapshot, it resamples (with
+// replacement) numSamples times from DefaultSerialMetricsStore's recent
+// MetricsSample history for itemID, runs each draw through
+// calculateBuyOrderFillTimeFromRates, and summarizes the finite results as
+// a FillTimePercentileEstimate. Falls back to a degenerate (all fields
+// equal) estimate built from metricsData's own snapshot when the store has
+// no history yet, same fallback metricsData/pm substitution pattern as
+// calculateBuyOrderFillTimeWithWindow/calculateBuyOrderFillTimeWithBand use.
+// numSamples <= 0 defaults to DefaultFillTimeMonteCarloSamples.
+func calculateBuyOrderFillTimePercentile(itemID string, quantity float64, metricsData ProductMetrics, window time.Duration, numSamples int) (FillTimePercentileEstimate, error) {
+	normItemID := BAZAAR_ID(itemID)
+	if numSamples <= 0 {
+		numSamples = DefaultFillTimeMonteCarloSamples
+	}
+
+	var history []MetricsSample
+	if store, err := DefaultSerialMetricsStore(); err == nil {
+		history = store.RecentSamples(normItemID, 0)
+	}
+
+	if len(history) == 0 {
+		pm := metricsData
+		mid, _, err := calculateBuyOrderFillTimeCore(normItemID, quantity, pm)
+		return FillTimePercentileEstimate{Mean: mid, P50: mid, P90: mid, P99: mid, StdDev: 0}, err
+	}
+
+	draws := make([]float64, 0, numSamples)
+	var firstErr error
+	for i := 0; i < numSamples; i++ {
+		sample := history[rand.Intn(len(history))]
+		supplyRate := math.Max(0, sample.SellSize) * math.Max(0, sample.SellFrequency)
+		demandRate := math.Max(0, sample.OrderSize) * math.Max(0, sample.OrderFrequency)
+		fillTime, _, err := calculateBuyOrderFillTimeFromRates(normItemID, quantity, supplyRate, demandRate, sample.OrderFrequency)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if !math.IsNaN(fillTime) && !math.IsInf(fillTime, 0) {
+			draws = append(draws, fillTime)
+		}
+	}
+
+	if len(draws) == 0 {
+		dlog("  Monte-Carlo fill time estimate for %s: no finite draws out of %d, returning Inf", normItemID, numSamples)
+		inf := math.Inf(1)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("all %d Monte-Carlo draws were non-finite for %s", numSamples, normItemID)
+		}
+		return FillTimePercentileEstimate{Mean: inf, P50: inf, P90: inf, P99: inf, StdDev: inf}, firstErr
+	}
+
+	sort.Float64s(draws)
+	var sum float64
+	for _, v := range draws {
+		sum += v
+	}
+	mean := sum / float64(len(draws))
+	var sqDiffSum float64
+	for _, v := range draws {
+		sqDiffSum += (v - mean) * (v - mean)
+	}
+	stdDev := math.Sqrt(sqDiffSum / float64(len(draws)))
+
+	estimate := FillTimePercentileEstimate{
+		Mean:   mean,
+		P50:    percentileOf(draws, 0.50),
+		P90:    percentileOf(draws, 0.90),
+		P99:    percentileOf(draws, 0.99),
+		StdDev: stdDev,
+	}
+	dlog("  Monte-Carlo fill time estimate for %s (%d/%d finite draws): mean=%.2f p50=%.2f p90=%.2f p99=%.2f stddev=%.2f",
+		normItemID, len(draws), numSamples, estimate.Mean, estimate.P50, estimate.P90, estimate.P99, estimate.StdDev)
+	return estimate, nil
+}
+
+// percentileOf returns the p-th (0..1) percentile of sorted, a sorted
+// ascending slice, linearly interpolating between the two closest ranks.
+// sorted must be non-empty.
+func percentileOf(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := p * float64(len(sorted)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := idx - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[hi]*frac
+}
+
+// calculateBuyOrderFillTimePercentileValue is calculateBuyOrderFillTimePercentile,
+// reduced to the single number ActiveFillTimePercentile names - the
+// backward-compatible counterpart for callers that, like
+// calculateBuyOrderFillTime's existing callers, just want one float64
+// rather than the full FillTimePercentileEstimate.
+func calculateBuyOrderFillTimePercentileValue(itemID string, quantity float64, metricsData ProductMetrics, window time.Duration, numSamples int) (float64, error) {
+	estimate, err := calculateBuyOrderFillTimePercentile(itemID, quantity, metricsData, window, numSamples)
+	switch ActiveFillTimePercentile {
+	case "p50":
+		return estimate.P50, err
+	case "p90":
+		return estimate.P90, err
+	case "p99":
+		return estimate.P99, err
+	default:
+		return estimate.Mean, err
+	}
+}
+
+// calculateBuyOrderFillTimeFromOrderBook is an order-book-depth-aware
+// alternative to calculateBuyOrderFillTime: instead of deriving fill time
+// purely from moving-week aggregates, it sums the live ahead-of-you volume
+// in productData.BuySummary at prices >= targetPrice (splitting the volume
+// at an exact price tie evenly across that price level's order count, since
+// OrderSummary doesn't expose per-order queue position), then divides by an
+// estimated fill rate drawn from the live SellMovingWeek (buy orders are
+// filled by instasell transactions, so that's the rate ahead-of-you and
+// your-own volume both drain at).
+func calculateBuyOrderFillTimeFromOrderBook(itemID string, targetPrice float64, qty float64, productData HypixelProduct) (float64, error) {
+	normItemID := BAZAAR_ID(itemID)
+	dlog("Calculating order-book-depth Buy Order Fill Time for %.2f x %s at price %.2f", qty, normItemID, targetPrice)
+
+	if qty <= 0 {
+		return 0, nil
+	}
+	if targetPrice <= 0 {
+		return math.Inf(1), fmt.Errorf("targetPrice must be > 0 for %s", normItemID)
+	}
+
+	aheadVolume := 0.0
+	for _, order := range productData.BuySummary {
+		switch {
+		case order.PricePerUnit > targetPrice:
+			aheadVolume += float64(order.Amount)
+		case order.PricePerUnit == targetPrice:
+			if order.Orders > 1 {
+				// Assume our order lands at the back of this price level's queue.
+				aheadVolume += float64(order.Amount) * float64(order.Orders-1) / float64(order.Orders)
+			}
+		default:
+			// BuySummary is sorted best-price-first (descending); once we pass
+			// targetPrice there's nothing ahead of us left to sum.
+		}
+	}
+
+	sellRatePerSecond := productData.QuickStatus.SellMovingWeek / 604800.0
+	if sellRatePerSecond <= 0 {
+		dlog("  Live SellMovingWeek <= 0, order-book fill time is Infinite.")
+		return math.Inf(1), fmt.Errorf("live SellMovingWeek is <= 0 for %s", normItemID)
+	}
+
+	fillTime := (aheadVolume + qty) / sellRatePerSecond
+	if math.IsNaN(fillTime) || math.IsInf(fillTime, 0) || fillTime < 0 {
+		return math.Inf(1), fmt.Errorf("order-book fill time calculation resulted in invalid value for %s", normItemID)
+	}
+
+	dlog("  Order-book fill time: ahead=%.2f, qty=%.2f, rate/s=%.5f => %.4f seconds", aheadVolume, qty, sellRatePerSecond, fillTime)
+	return fillTime, nil
+}
+
+// DepthAwareFillTime is getDepthAwareFillTime's result: the order-book-walk
+// counterparts to calculateBuyOrderFillTimeFromOrderBook's queue-position
+// estimate and calculateInstasellFillTime's flat-rate one, exposed
+// alongside those simpler moving-week-based estimates rather than in place
+// of them, so a caller can compare "naive" vs "book-aware" numbers.
+type DepthAwareFillTime struct {
+	BuyOrderFillTimeSeconds float64 // resting buy order at TargetPrice; calculateBuyOrderFillTimeFromOrderBook's result
+	InstasellFilledQty      float64 // how much of qty the book could actually absorb (may be < qty if it's exhausted)
+	InstasellEffectivePrice float64 // walkBook-weighted average instasell price, not just BuySummary[0]'s top price
+	InstasellSlippage       float64 // (InstasellEffectivePrice - BuySummary[0]'s top price) / top price; <= 0, since the walk only moves down the buy book
+}
+
+// getDepthAwareFillTime is calculateBuyOrderFillTimeFromOrderBook and
+// calculateInstasellFillTime's depth-aware counterpart: instead of pricing
+// the whole of qty at a single quick-status rate, it walks productData's
+// live order book the way getBestC10MDepth (c10m.go) already does for
+// Secondary cost - BuySummary's ahead-of-targetPrice volume for a resting
+// buy order's queue position, and BuySummary again via walkBook for the
+// weighted-average instasell fill price/slippage that qty would actually
+// get, instead of assuming it all clears at the top order's price.
+//
+// Despite the name, an instasell fills against *resting buy orders* -
+// BuySummary, not SellSummary - matching how
+// calculateBuyOrderFillTimeFromOrderBook and getBestC10MDepth already read
+// this API's two arrays; SellSummary is where getSellPrice's instabuy
+// quote comes from instead, not instasell.
+func getDepthAwareFillTime(itemID string, targetPrice, qty float64, productData HypixelProduct) (DepthAwareFillTime, error) {
+	normItemID := BAZAAR_ID(itemID)
+	dlog("Calculating depth-aware fill time for %.2f x %s at target price %.2f", qty, normItemID, targetPrice)
+
+	var result DepthAwareFillTime
+	var firstErr error
+
+	buyFillTime, err := calculateBuyOrderFillTimeFromOrderBook(itemID, targetPrice, qty, productData)
+	result.BuyOrderFillTimeSeconds = buyFillTime
+	if err != nil {
+		firstErr = err
+	}
+
+	_, filledQty, avgPrice, slippage := walkBook(productData.BuySummary, qty)
+	result.InstasellFilledQty = filledQty
+	result.InstasellEffectivePrice = avgPrice
+	result.InstasellSlippage = slippage
+	if math.IsNaN(avgPrice) && firstErr == nil {
+		firstErr = fmt.Errorf("buy_summary book can't fully absorb %.2f units of %s (filled %.2f)", qty, normItemID, filledQty)
+	}
+
+	dlog("  Depth-aware fill time for %s: buyOrderFillTime=%.4f, instasellFilled=%.2f/%.2f, effectivePrice=%.2f, slippage=%.4f",
+		normItemID, result.BuyOrderFillTimeSeconds, result.InstasellFilledQty, qty, result.InstasellEffectivePrice, result.InstasellSlippage)
+	return result, firstErr
+}
+
+// calculateInstasellFillTimeWithConfidence and calculateBuyOrderFillTimeWithConfidence
+// wrap their namesakes with the feedhealth phi-accrual detector's current
+// read on itemID's feed, so callers can tell "this number is fresh" from
+// "this number is plausible but the upstream Hypixel poller for this item
+// looks stuck" without the underlying calculations having to know about
+// feed health themselves.
+func calculateInstasellFillTimeWithConfidence(itemID string, qty float64, productData HypixelProduct) (float64, FeedConfidence, error) {
+	fillTime, err := calculateInstasellFillTime(qty, productData)
+	fillTime, err, confidence := gateFillTimeConfidence(itemID, fillTime, err)
+	return fillTime, confidence, err
+}
+
+func calculateBuyOrderFillTimeWithConfidence(itemID string, quantity float64, metricsData ProductMetrics) (float64, float64, FeedConfidence, error) {
+	fillTime, rr, err := calculateBuyOrderFillTime(context.Background(), itemID, quantity, metricsData)
+	fillTime, err, confidence := gateFillTimeConfidence(itemID, fillTime, err)
+	return fillTime, rr, confidence, err
+}
@@ -0,0 +1,93 @@
+// fill_time_queue.go
+package main
+
+import (
+	"context"
+	"math"
+)
+
+// BuyOrderFillEstimate is calculateBuyOrderFillEstimate's percentile-based
+// alternative to calculateBuyOrderFillTime's single LaTeX-formula point
+// estimate: a caller choosing between p50 (typical case) and p90/p99
+// (pessimistic, for risk-averse sizing) instead of always getting one
+// number.
+type BuyOrderFillEstimate struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	// SteadyStateDeficit is demandRate-supplyRate; positive means the queue
+	// is unstable (competing demand outpaces supply) and P50/P90/P99 are all
+	// +Inf, so a caller can report "underwater by N/s" instead of just "never".
+	SteadyStateDeficit float64 `json:"steady_state_deficit"`
+}
+
+// mm1WaitPercentile inverts the M/M/1 waiting-time CDF
+// P(W<=t) = 1 - rho*exp(-muMinusLambda*t) for the t at which it equals p:
+// t = ln(rho/(1-p)) / muMinusLambda. Returns 0 when rho/(1-p) <= 1 (the CDF
+// already exceeds p at t=0), and +Inf when the queue isn't stable
+// (muMinusLambda <= 0 - the caller is expected to have already handled that
+// case, this is just a defensive fallback).
+func mm1WaitPercentile(rho, muMinusLambda, p float64) float64 {
+	if rho <= 0 {
+		return 0
+	}
+	if muMinusLambda <= 0 {
+		return math.Inf(1)
+	}
+	ratio := rho / (1 - p)
+	if ratio <= 1 {
+		return 0
+	}
+	return math.Log(ratio) / muMinusLambda
+}
+
+// calculateBuyOrderFillEstimate models filling a quantity-sized buy order as
+// an M/M/1 queue: arrivals are competing buy-order demand (OrderSize*
+// OrderFrequency, the same demandRate calculateBuyOrderFillTimeCore already
+// derives), service rate is supply (SellSize*SellFrequency), and the user's
+// own order occupies ceil(quantity/SellSize) service slots worth of the
+// deterministic quantity/supplyRate term every percentile is built on top
+// of. When demandRate >= supplyRate (rho >= 1) the queue never drains. p50/
+// p90/p99 all report +Inf, and SteadyStateDeficit reports by how much
+// (demandRate-supplyRate) so a caller can show "underwater by N/s" instead
+// of a bare infinity.
+func calculateBuyOrderFillEstimate(ctx context.Context, itemID string, quantity float64, metricsData ProductMetrics) (BuyOrderFillEstimate, error) {
+	if err := ctx.Err(); err != nil {
+		return BuyOrderFillEstimate{}, err
+	}
+	normItemID := BAZAAR_ID(itemID)
+	if quantity <= 0 {
+		return BuyOrderFillEstimate{}, nil
+	}
+
+	pm := metricsData
+	sellSize := math.Max(0, pm.SellSize)
+	supplyRate := sellSize * math.Max(0, pm.SellFrequency)
+	demandRate := math.Max(0, pm.OrderSize) * math.Max(0, pm.OrderFrequency)
+	deficit := demandRate - supplyRate
+
+	dlog("Calculating Buy Order Fill Estimate (M/M/1) for %.2f x %s: supplyRate=%.4f demandRate=%.4f", quantity, normItemID, supplyRate, demandRate)
+
+	if supplyRate <= 0 || deficit >= 0 {
+		return BuyOrderFillEstimate{P50: math.Inf(1), P90: math.Inf(1), P99: math.Inf(1), SteadyStateDeficit: deficit}, nil
+	}
+
+	// serviceSlots is this queue's unit of "how many of the user's own items
+	// still need servicing" - folded into the deterministic baseline below
+	// via quantity/supplyRate rather than multiplied in separately, since
+	// quantity/supplyRate already is serviceSlots*(1/supplyRate per SellSize
+	// unit) once SellSize cancels out of the ratio.
+	serviceSlots := math.Ceil(quantity / math.Max(sellSize, 1e-9))
+	_ = serviceSlots
+
+	rho := demandRate / supplyRate
+	muMinusLambda := supplyRate - demandRate
+	baseline := quantity / supplyRate
+
+	return BuyOrderFillEstimate{
+		P50:                mm1WaitPercentile(rho, muMinusLambda, 0.50) + baseline,
+		P90:                mm1WaitPercentile(rho, muMinusLambda, 0.90) + baseline,
+		P99:                mm1WaitPercentile(rho, muMinusLambda, 0.99) + baseline,
+		SteadyStateDeficit: deficit,
+	}, nil
+}
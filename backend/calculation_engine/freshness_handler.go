@@ -0,0 +1,106 @@
+// freshness_handler.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ItemFreshness is one entry's freshness report: how old the ProductMetrics
+// backing getBestC10M's Primary path is for itemID, the confidence that age
+// implies (metricsAgeAndConfidence/confidenceLevelFor, staleness.go), and how
+// many PriceHistory samples that moving average was built from.
+type ItemFreshness struct {
+	ItemID            string          `json:"item_id"`
+	MetricsAgeSeconds float64         `json:"metrics_age_seconds"`
+	Confidence        float64         `json:"confidence"`
+	ConfidenceLevel   ConfidenceLevel `json:"confidence_level"`
+	SampleCount       int             `json:"sample_count"`
+}
+
+// itemFreshness builds one ItemFreshness entry for itemID against metricsMap,
+// against maxAgeSecs (<=0 falls back to defaultMaxMetricsAgeSecs).
+func itemFreshness(itemID string, metricsMap map[string]ProductMetrics, maxAgeSecs float64) ItemFreshness {
+	pm, ok := safeGetMetricsData(metricsMap, BAZAAR_ID(itemID))
+	ageSecs, confidence := metricsAgeAndConfidence(pm, ok)
+	return ItemFreshness{
+		ItemID:            itemID,
+		MetricsAgeSeconds: ageSecs,
+		Confidence:        confidence,
+		ConfidenceLevel:   confidenceLevelFor(ageSecs, maxAgeSecs),
+		SampleCount:       len(pm.PriceHistory),
+	}
+}
+
+// FreshnessResponse is GET /api/freshness's payload. LastAPIPollSeconds/
+// LastAPIPollError mirror BazaarFetchStatus; StaleItems is ListStaleItems'
+// top-N listing over every entry in the metrics map, which is wider than
+// /stale's listing of only the items an in-flight expansion references.
+type FreshnessResponse struct {
+	LastAPIPollSeconds float64            `json:"last_api_poll_seconds"`
+	LastAPIPollError   string             `json:"last_api_poll_error,omitempty"`
+	Items              []ItemFreshness    `json:"items,omitempty"`
+	StaleItems         []StaleItemSummary `json:"stale_items,omitempty"`
+}
+
+// defaultFreshnessStaleTop matches staleItemsHandler's own "n" default.
+const defaultFreshnessStaleTop = 20
+
+// freshnessHandler serves GET /api/freshness and GET /api/freshness/{item}.
+// With a trailing item (mirroring historyHandler/trendHandler's "/history/
+// {item}" shape), it reports that one item's ItemFreshness. Without one, it
+// reports the last Bazaar API poll plus the top-N stalest items
+// (ListStaleItems, staleness.go) - the same gilgetter "stale items" concept
+// staleItemsHandler already serves at /stale, surfaced here too since the
+// request ties freshness and staleness together in one endpoint.
+//
+// Query params: max_age_secs (the fresh/stale cutoff passed to
+// confidenceLevelFor; <= 0 falls back to defaultMaxMetricsAgeSecs), n (top-N
+// stale items to include, default 20, ignored when an item is given).
+func freshnessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxAgeSecs := queryFloatDefault(r, "max_age_secs", 0)
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	itemID := strings.TrimPrefix(r.URL.Path, "/api/freshness")
+	itemID = strings.TrimPrefix(itemID, "/")
+	if itemID != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(itemFreshness(itemID, metricsMap, maxAgeSecs))
+		return
+	}
+
+	n := defaultFreshnessStaleTop
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+	staleThreshold := time.Duration(maxAgeSecs * float64(time.Second))
+	if staleThreshold <= 0 {
+		staleThreshold = time.Duration(defaultMaxMetricsAgeSecs() * float64(time.Second))
+	}
+	stale := ListStaleItems(metricsMap, staleThreshold)
+	if len(stale) > n {
+		stale = stale[:n]
+	}
+
+	lastFetch, fetchErr := BazaarFetchStatus()
+	resp := FreshnessResponse{StaleItems: stale}
+	if !lastFetch.IsZero() {
+		resp.LastAPIPollSeconds = time.Since(lastFetch).Seconds()
+	}
+	if fetchErr != nil {
+		resp.LastAPIPollError = fetchErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,117 @@
+// ingredient_spec.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IngredientSpec is one recipe cell's parsed ingredient requirement, richer
+// than a bare "ID -> amount" entry so aggregateCells can express NBT-style
+// qualifiers, interchangeable ingredient alternates, and per-slot yield
+// overrides without pre-baking every variant into the normalization map.
+type IngredientSpec struct {
+	ItemID     string            // normalized concrete ID; "" when Alternates is non-empty
+	Alternates []string          // normalized candidate IDs for "ITEM_A|ITEM_B:AMOUNT" slots; nil for a single-ID slot
+	Amount     float64           // amount needed per single craft, already divided by any "*YIELD" override
+	Attrs      map[string]string // NBT-style qualifiers from a "+ATTR=VAL,..." suffix; nil if none
+}
+
+// ResolvedItemID returns the concrete normalized ID this spec refers to:
+// ItemID directly for a single-candidate slot, or whichever of Alternates
+// apiResp prices cheapest via getBuyPrice otherwise. Ties and all-unpriced
+// alternates fall back to the first alternate in DSL order, so the choice
+// stays deterministic even with no live pricing data.
+func (s *IngredientSpec) ResolvedItemID(apiResp *HypixelAPIResponse) string {
+	if len(s.Alternates) == 0 {
+		return s.ItemID
+	}
+	best := s.Alternates[0]
+	bestPrice := math.Inf(1)
+	for _, alt := range s.Alternates {
+		if price := getBuyPrice(apiResp, alt); price > 0 && price < bestPrice {
+			bestPrice = price
+			best = alt
+		}
+	}
+	return best
+}
+
+// resolveIngredientSpecs collapses aggregateCells' richer output back down to
+// the classic ingredient-ID -> per-craft-amount map the expansion code
+// operates on, choosing each alternate slot's cheapest candidate against
+// apiResp. This is the one place an alternate group turns into a concrete
+// ID, so every caller downstream of it (including isInPath, via the
+// recursive expansion functions) always sees a resolved item ID rather than
+// an alternates group key.
+func resolveIngredientSpecs(specs map[string]*IngredientSpec, apiResp *HypixelAPIResponse) map[string]float64 {
+	resolved := make(map[string]float64, len(specs))
+	for _, spec := range specs {
+		id := spec.ResolvedItemID(apiResp)
+		if id == "" {
+			continue
+		}
+		resolved[id] += spec.Amount
+	}
+	return resolved
+}
+
+// parseCellDSL parses one recipe cell's content against the extended
+// "ITEM_ID:AMOUNT[+ATTR=VAL,...]" DSL, kept backwards compatible with the
+// original "ITEM_ID" / "ITEM_ID:AMOUNT" syntax:
+//   - "ITEM_A|ITEM_B:AMOUNT"               - interchangeable alternates, cheapest wins at solve time
+//   - "ITEM_ID:AMOUNT+ATTR=VAL,ATTR2=VAL2" - NBT-style qualifiers attached to the ID
+//   - "ITEM_ID:AMOUNT*YIELD"               - per-slot yield override
+//
+// Returned ids are raw (non-normalized); normalization, amount validation and
+// metrics/dlog all happen in aggregateCells so that stays the single place
+// those concerns live.
+func parseCellDSL(cellContent string) (ids []string, amountStr string, yield float64, attrs map[string]string, err error) {
+	yield = 1.0
+	rest := cellContent
+
+	if plusIdx := strings.Index(rest, "+"); plusIdx != -1 {
+		attrPart := rest[plusIdx+1:]
+		rest = rest[:plusIdx]
+		attrs = make(map[string]string)
+		for _, kv := range strings.Split(attrPart, ",") {
+			kv = strings.TrimSpace(kv)
+			if kv == "" {
+				continue
+			}
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+				return nil, "", 0, nil, fmt.Errorf("malformed attribute %q in cell %q", kv, cellContent)
+			}
+			attrs[strings.ToUpper(strings.TrimSpace(pair[0]))] = strings.TrimSpace(pair[1])
+		}
+	}
+
+	idPart := rest
+	if colonIdx := strings.Index(rest, ":"); colonIdx != -1 {
+		idPart = rest[:colonIdx]
+		amountStr = rest[colonIdx+1:]
+	}
+
+	if starIdx := strings.Index(amountStr, "*"); starIdx != -1 {
+		yieldStr := strings.TrimSpace(amountStr[starIdx+1:])
+		amountStr = amountStr[:starIdx]
+		y, yErr := strconv.ParseFloat(yieldStr, 64)
+		if yErr != nil || y <= 0 {
+			return nil, "", 0, nil, fmt.Errorf("invalid yield %q in cell %q", yieldStr, cellContent)
+		}
+		yield = y
+	}
+
+	for _, part := range strings.Split(idPart, "|") {
+		if part = strings.TrimSpace(part); part != "" {
+			ids = append(ids, part)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, "", 0, nil, fmt.Errorf("empty ingredient ID in cell %q", cellContent)
+	}
+	return ids, amountStr, yield, attrs, nil
+}
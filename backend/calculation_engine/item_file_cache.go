@@ -0,0 +1,128 @@
+// item_file_cache.go
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// errItemFileReadFailed and errItemFileParseFailed let loadItemFileCached's
+// callers tell a read failure apart from a parse failure via errors.Is,
+// the same distinction expandItemRecursiveTree's RecipeReadFailed/
+// RecipeParseFailed NodeErrorKinds already make.
+var (
+	errItemFileReadFailed  = errors.New("reading recipe file")
+	errItemFileParseFailed = errors.New("parsing recipe JSON")
+)
+
+// itemFileCacheEntry is one cached recipe file parse: the decoded Item
+// alongside the file's ModTime at the moment it was parsed, so a later
+// lookup can tell a changed-on-disk file apart from a stale cache hit.
+type itemFileCacheEntry struct {
+	item    Item
+	modTime time.Time
+}
+
+// itemFileLRUEntry is one itemFileCache list node: the key alongside its
+// entry, mirroring lruExpansionCacheBackend's lruEntry so evicting the back
+// of order can delete the matching map entry too.
+type itemFileLRUEntry struct {
+	path  string
+	entry itemFileCacheEntry
+}
+
+// itemFileCache is an in-memory, mtime-invalidated cache of parsed recipe
+// files, bounded to maxEntries LRU-evicted entries - the same shape as
+// lruExpansionCacheBackend, but keyed by file path instead of an expansion
+// key, and backing loadItemFileCached's os.Stat+os.ReadFile+json.Unmarshal
+// instead of a full DualExpansionResult.
+type itemFileCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+// defaultItemFileCacheEntries bounds the package-wide globalItemFileCache.
+const defaultItemFileCacheEntries = 20000
+
+func newItemFileCache(maxEntries int) *itemFileCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultItemFileCacheEntries
+	}
+	return &itemFileCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *itemFileCache) get(path string) (itemFileCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		return itemFileCacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*itemFileLRUEntry).entry, true
+}
+
+func (c *itemFileCache) put(path string, entry itemFileCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		elem.Value.(*itemFileLRUEntry).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	elem := c.order.PushFront(&itemFileLRUEntry{path: path, entry: entry})
+	c.entries[path] = elem
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*itemFileLRUEntry).path)
+	}
+}
+
+// globalItemFileCache is the package-wide cache loadItemFileCached reads
+// and writes through, shared by every expandWorkerPool worker.
+var globalItemFileCache = newItemFileCache(0)
+
+// loadItemFileCached reads and parses the recipe file at path, returning
+// (Item{}, false, nil) when it doesn't exist - the same "no recipe" shape
+// expandItemRecursiveTree and flattenedIngredients already handle inline.
+// A cache hit whose stored modTime still matches the file's current mtime
+// skips the os.ReadFile+json.Unmarshal entirely; any other case (cache miss,
+// or the file's mtime moved since it was cached) re-reads and re-parses,
+// replacing the cached entry.
+func loadItemFileCached(path string) (Item, bool, error) {
+	info, statErr := os.Stat(path)
+	if os.IsNotExist(statErr) {
+		return Item{}, false, nil
+	}
+	if statErr != nil {
+		return Item{}, false, fmt.Errorf("checking recipe file '%s': %w", path, statErr)
+	}
+
+	if cached, ok := globalItemFileCache.get(path); ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.item, true, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Item{}, false, fmt.Errorf("%w '%s': %v", errItemFileReadFailed, path, err)
+	}
+	var itemData Item
+	if err := json.Unmarshal(data, &itemData); err != nil {
+		return Item{}, false, fmt.Errorf("%w for '%s': %v", errItemFileParseFailed, path, err)
+	}
+	globalItemFileCache.put(path, itemFileCacheEntry{item: itemData, modTime: info.ModTime()})
+	return itemData, true, nil
+}
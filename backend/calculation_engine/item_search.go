@@ -0,0 +1,385 @@
+// item_search.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// itemSearchDoc is what gets indexed for one item file: its own identity
+// plus every string a user might plausibly search by, so "hyperion" finds
+// HYPERION.json even if the query doesn't match ItemID exactly.
+type itemSearchDoc struct {
+	ItemID      string
+	DisplayName string
+	Ingredients []string
+}
+
+// ItemMatch is one SearchItems result: a normalized BAZAAR_ID plus the
+// relevance score assigned it, so a caller can rank or threshold results
+// without re-querying the index itself.
+type ItemMatch struct {
+	ItemID string  `json:"itemId"`
+	Score  float64 `json:"score"`
+}
+
+// itemSearchIndexVersion identifies the on-disk inverted-index format,
+// bumped whenever the indexed fields or scoring change so a stale on-disk
+// index is rebuilt from scratch rather than silently serving results built
+// under an older shape.
+const itemSearchIndexVersion = 1
+
+// itemSearchIndexFile is the whole search index persisted to disk: the
+// sha256-per-file side index BuildItemSearchIndex uses to decide which item
+// files need re-indexing, plus the inverted postings list itself, so a
+// restart with mostly-unchanged item files doesn't have to re-tokenize
+// every document. This repo avoids reaching for a third-party search engine
+// for the same reason coins.go avoids shopspring/decimal and
+// fillBatchBackend (batch_fill.go) avoids a NATS client: no go.mod here
+// pins any third-party dependency, so the index is a plain hand-rolled
+// term -> itemID -> term-frequency map instead.
+type itemSearchIndexFile struct {
+	Version  int                          `json:"version"`
+	Hashes   map[string]string            `json:"hashes"`   // itemFilesDir-relative path -> sha256 hex
+	Postings map[string]map[string]int    `json:"postings"` // token -> itemID -> term frequency
+	Docs     map[string]itemSearchDocJSON `json:"docs"`      // itemID -> its indexed fields, for re-save without re-reading every file
+}
+
+type itemSearchDocJSON struct {
+	DisplayName string   `json:"displayName"`
+	Ingredients []string `json:"ingredients"`
+	TokenCount  int      `json:"tokenCount"`
+}
+
+var (
+	itemSearchMu    sync.RWMutex
+	itemSearchState *itemSearchIndexFile
+)
+
+func itemSearchIndexPath(itemFilesDir string) string {
+	return filepath.Join(filepath.Dir(strings.TrimRight(itemFilesDir, string(filepath.Separator))), "item_search_index.json")
+}
+
+// itemSearchTokenizer splits s into lowercase alphanumeric tokens, the same
+// simple normalization applied to both indexed documents and incoming
+// queries so they compare on equal footing.
+func itemSearchTokenizer(s string) []string {
+	var tokens []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			tokens = append(tokens, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// BuildItemSearchIndex walks every *.json file under itemFilesDir, indexing
+// each item's ID, display name, and every recipe cell's ingredient ID into a
+// term -> itemID -> frequency inverted index persisted at
+// itemSearchIndexPath(itemFilesDir). A file whose content hash matches what
+// the index last recorded for that path is skipped and its prior postings
+// are kept as-is, so a restart with mostly-unchanged item files only
+// re-tokenizes what actually changed.
+func BuildItemSearchIndex(itemFilesDir string) error {
+	idx := loadItemSearchIndex(itemFilesDir)
+	seenItemIDs := make(map[string]struct{})
+	changed := false
+
+	err := filepath.Walk(itemFilesDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		rel, relErr := filepath.Rel(itemFilesDir, path)
+		if relErr != nil {
+			rel = path
+		}
+
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			dlog("BuildItemSearchIndex: skipping %s: %v", path, readErr)
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+
+		var itemData Item
+		if err := json.Unmarshal(data, &itemData); err != nil {
+			dlog("BuildItemSearchIndex: skipping %s (unparseable): %v", path, err)
+			return nil
+		}
+		if itemData.ItemID == "" {
+			return nil
+		}
+		itemID := BAZAAR_ID(itemData.ItemID)
+		seenItemIDs[itemID] = struct{}{}
+
+		if idx.Hashes[rel] == hash {
+			return nil
+		}
+
+		doc := itemSearchDoc{
+			ItemID:      itemID,
+			DisplayName: itemData.Name,
+			Ingredients: ingredientIDsOf(itemData),
+		}
+		removeFromPostings(idx, itemID)
+		indexDoc(idx, doc)
+		idx.Hashes[rel] = hash
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking item files dir %s: %w", itemFilesDir, err)
+	}
+
+	// Drop postings for items whose backing file is gone, so a removed item
+	// doesn't keep surfacing in results forever.
+	for itemID := range idx.Docs {
+		if _, ok := seenItemIDs[itemID]; !ok {
+			removeFromPostings(idx, itemID)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := saveItemSearchIndex(itemFilesDir, idx); err != nil {
+			dlog("BuildItemSearchIndex: failed to persist index: %v", err)
+		}
+	}
+
+	itemSearchMu.Lock()
+	itemSearchState = idx
+	itemSearchMu.Unlock()
+	return nil
+}
+
+// indexDoc tokenizes doc's fields and adds its postings to idx.
+func indexDoc(idx *itemSearchIndexFile, doc itemSearchDoc) {
+	freq := make(map[string]int)
+	for _, tok := range itemSearchTokenizer(doc.ItemID) {
+		freq[tok]++
+	}
+	for _, tok := range itemSearchTokenizer(doc.DisplayName) {
+		freq[tok]++
+	}
+	for _, ing := range doc.Ingredients {
+		for _, tok := range itemSearchTokenizer(ing) {
+			freq[tok]++
+		}
+	}
+	tokenCount := 0
+	for tok, n := range freq {
+		if idx.Postings[tok] == nil {
+			idx.Postings[tok] = make(map[string]int)
+		}
+		idx.Postings[tok][doc.ItemID] = n
+		tokenCount += n
+	}
+	idx.Docs[doc.ItemID] = itemSearchDocJSON{
+		DisplayName: doc.DisplayName,
+		Ingredients: doc.Ingredients,
+		TokenCount:  tokenCount,
+	}
+}
+
+// removeFromPostings drops every posting itemID holds, so re-indexing a
+// changed file (or dropping a deleted one) doesn't leave stale entries
+// behind under its old tokens.
+func removeFromPostings(idx *itemSearchIndexFile, itemID string) {
+	for tok, postings := range idx.Postings {
+		if _, ok := postings[itemID]; ok {
+			delete(postings, itemID)
+			if len(postings) == 0 {
+				delete(idx.Postings, tok)
+			}
+		}
+	}
+	delete(idx.Docs, itemID)
+}
+
+// ingredientIDsOf collects every ITEM_ID referenced across an Item's recipe
+// cells (both Recipe and Recipes[]), via the same cell-parsing aggregateCells
+// relies on, so a search for an ingredient's name also surfaces items that
+// craft from it.
+func ingredientIDsOf(itemData Item) []string {
+	seen := make(map[string]struct{})
+	var ids []string
+	add := func(cells map[string]string) {
+		for _, cell := range cells {
+			if cell == "" {
+				continue
+			}
+			cellIDs, _, _, _, err := parseCellDSL(cell)
+			if err != nil {
+				continue
+			}
+			for _, id := range cellIDs {
+				if id == "" {
+					continue
+				}
+				if _, ok := seen[id]; !ok {
+					seen[id] = struct{}{}
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	add(cellsOf(itemData.Recipe))
+	for _, r := range itemData.Recipes {
+		add(cellsOf(SingleRecipe{A1: r.A1, A2: r.A2, A3: r.A3, B1: r.B1, B2: r.B2, B3: r.B3, C1: r.C1, C2: r.C2, C3: r.C3}))
+	}
+	return ids
+}
+
+func loadItemSearchIndex(itemFilesDir string) *itemSearchIndexFile {
+	data, err := os.ReadFile(itemSearchIndexPath(itemFilesDir))
+	if err == nil {
+		var idx itemSearchIndexFile
+		if json.Unmarshal(data, &idx) == nil && idx.Version == itemSearchIndexVersion {
+			if idx.Hashes == nil {
+				idx.Hashes = make(map[string]string)
+			}
+			if idx.Postings == nil {
+				idx.Postings = make(map[string]map[string]int)
+			}
+			if idx.Docs == nil {
+				idx.Docs = make(map[string]itemSearchDocJSON)
+			}
+			return &idx
+		}
+	}
+	return &itemSearchIndexFile{
+		Version:  itemSearchIndexVersion,
+		Hashes:   make(map[string]string),
+		Postings: make(map[string]map[string]int),
+		Docs:     make(map[string]itemSearchDocJSON),
+	}
+}
+
+func saveItemSearchIndex(itemFilesDir string, idx *itemSearchIndexFile) error {
+	idx.Version = itemSearchIndexVersion
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(itemSearchIndexPath(itemFilesDir), data, 0o644)
+}
+
+// SearchItems runs query against the inverted index BuildItemSearchIndex
+// maintains, returning up to limit matches ordered by descending relevance
+// score (the sum of each matched token's term frequency in that item's
+// document, normalized by the document's total token count so a short
+// display name isn't drowned out by a long ingredient list). Returns an
+// error if the index hasn't been built yet for this process
+// (BuildItemSearchIndex must run at startup first).
+func SearchItems(query string, limit int) ([]ItemMatch, error) {
+	itemSearchMu.RLock()
+	idx := itemSearchState
+	itemSearchMu.RUnlock()
+	if idx == nil {
+		return nil, fmt.Errorf("item search index not built yet")
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+
+	tokens := itemSearchTokenizer(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	scores := make(map[string]float64)
+	for _, tok := range tokens {
+		for itemID, freq := range idx.Postings[tok] {
+			tokenCount := idx.Docs[itemID].TokenCount
+			if tokenCount == 0 {
+				tokenCount = 1
+			}
+			scores[itemID] += float64(freq) / float64(tokenCount)
+		}
+	}
+	if len(scores) == 0 {
+		return nil, nil
+	}
+
+	matches := make([]ItemMatch, 0, len(scores))
+	for itemID, score := range scores {
+		matches = append(matches, ItemMatch{ItemID: itemID, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ItemID < matches[j].ItemID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// searchItemsHandler serves GET /search?q=...&limit=... over the index
+// BuildItemSearchIndex builds, mirroring the plain
+// json.NewEncoder(w).Encode pattern the rest of this package's handlers use.
+func searchItemsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing required query parameter 'q'", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	matches, err := SearchItems(q, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// RunSearchCLI builds (or reuses a freshly-built) search index over
+// itemFilesDir and prints up to limit matches for query as JSON to stdout,
+// mirroring RunArbitrageCLI's (arbitrage.go) one-shot-command shape for the
+// "wiz search" subcommand main() dispatches to.
+func RunSearchCLI(itemFilesDir, query string, limit int) error {
+	if err := BuildItemSearchIndex(itemFilesDir); err != nil {
+		return fmt.Errorf("building item search index: %w", err)
+	}
+	matches, err := SearchItems(query, limit)
+	if err != nil {
+		return fmt.Errorf("searching '%s': %w", query, err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(matches)
+}
@@ -0,0 +1,94 @@
+// labels.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LabelMatcher is one "key=value" or "key=~regex" term of a Selector,
+// matching Prometheus's own label-matcher syntax since that's the closest
+// familiar vocabulary for anyone filtering products by tag.
+type LabelMatcher struct {
+	Name  string
+	Value string
+	Regex *regexp.Regexp // nil for an exact (=) match
+}
+
+// Matches reports whether labelSet[m.Name] satisfies m.
+func (m LabelMatcher) Matches(labelSet map[string]string) bool {
+	v, ok := labelSet[m.Name]
+	if !ok {
+		return false
+	}
+	if m.Regex != nil {
+		return m.Regex.MatchString(v)
+	}
+	return v == m.Value
+}
+
+// Selector is a PromQL-style matcher list, e.g.
+// `category="FARMING",tier=~"EPIC|LEGENDARY"`, used to filter
+// ProductMetrics.Labels via PriceStore.Query.
+type Selector []LabelMatcher
+
+// labelTermPattern matches one comma-separated selector term: a bare label
+// name, an operator (= or =~), and a double-quoted value.
+var labelTermPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|=)\s*"((?:[^"\\]|\\.)*)"\s*$`)
+
+// ParseSelector parses a comma-separated list of label matchers, e.g.
+// `category="FARMING",tier=~"EPIC|LEGENDARY"`. An empty/whitespace-only raw
+// string parses to an empty Selector, which MatchesAny treats as "matches
+// every product".
+func ParseSelector(raw string) (Selector, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var sel Selector
+	for _, term := range strings.Split(raw, ",") {
+		m := labelTermPattern.FindStringSubmatch(term)
+		if m == nil {
+			return nil, fmt.Errorf("invalid label matcher %q", strings.TrimSpace(term))
+		}
+		name, op, value := m[1], m[2], m[3]
+		matcher := LabelMatcher{Name: name, Value: value}
+		if op == "=~" {
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex in matcher %q: %w", strings.TrimSpace(term), err)
+			}
+			matcher.Regex = re
+		}
+		sel = append(sel, matcher)
+	}
+	return sel, nil
+}
+
+// MatchesAny reports whether sel is satisfied by at least one of
+// labelSets - one product can be taggable through more than one acquisition
+// channel (see ProductMetrics.Labels), and it's a match if any one of those
+// channels satisfies every matcher in sel. An empty Selector matches
+// anything, including a product with no label sets at all.
+func (sel Selector) MatchesAny(labelSets []map[string]string) bool {
+	if len(sel) == 0 {
+		return true
+	}
+	for _, set := range labelSets {
+		if sel.matchesSet(set) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sel Selector) matchesSet(set map[string]string) bool {
+	for _, m := range sel {
+		if !m.Matches(set) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+// liquidity.go
+package main
+
+import "sync"
+
+// LiquidityConfig sets the floor below which an item is considered too
+// illiquid to bother pricing individually: MinOrdersPerDay/MinSellsPerDay
+// gate on OrderFrequency/SellFrequency directly, and MinCombinedDepth gates
+// on an approximate daily traded volume (frequency * size, summed across
+// both sides of the book).
+type LiquidityConfig struct {
+	MinOrdersPerDay  float64
+	MinSellsPerDay   float64
+	MinCombinedDepth float64
+}
+
+var defaultLiquidityConfig = LiquidityConfig{
+	MinOrdersPerDay:  1.0,
+	MinSellsPerDay:   1.0,
+	MinCombinedDepth: 1.0,
+}
+
+var (
+	liquidityConfigMu      sync.RWMutex
+	currentLiquidityConfig = defaultLiquidityConfig
+)
+
+// SetLiquidityConfig installs cfg as the floor used by future skip-set
+// rebuilds; it does not itself trigger a rebuild.
+func SetLiquidityConfig(cfg LiquidityConfig) {
+	liquidityConfigMu.Lock()
+	currentLiquidityConfig = cfg
+	liquidityConfigMu.Unlock()
+}
+
+func getLiquidityConfig() LiquidityConfig {
+	liquidityConfigMu.RLock()
+	defer liquidityConfigMu.RUnlock()
+	return currentLiquidityConfig
+}
+
+// SkipSet is the set of (normalized) item IDs currently below the liquidity
+// floor; consulted by expandItemRecursiveTree before recursing into a craft
+// branch so an all-illiquid recipe short-circuits to a single base-item
+// pricing call instead of per-ingredient buy-order/instabuy math.
+type SkipSet map[string]struct{}
+
+var (
+	skipSetMu      sync.RWMutex
+	currentSkipSet = make(SkipSet)
+)
+
+func isIlliquid(pm ProductMetrics, cfg LiquidityConfig) bool {
+	combinedDepth := pm.OrderFrequency*pm.OrderSize + pm.SellFrequency*pm.SellSize
+	return pm.OrderFrequency < cfg.MinOrdersPerDay ||
+		pm.SellFrequency < cfg.MinSellsPerDay ||
+		combinedDepth < cfg.MinCombinedDepth
+}
+
+// rebuildSkipSet recomputes currentSkipSet from metricsMap against the
+// currently installed LiquidityConfig. Called from setCurrentMetricsMap
+// (metrics.go) only when it is handed a different map than last time, so
+// expansions against an unchanged snapshot never repeat this scan.
+func rebuildSkipSet(metricsMap map[string]ProductMetrics) {
+	cfg := getLiquidityConfig()
+	next := make(SkipSet, len(metricsMap))
+	for id, pm := range metricsMap {
+		if isIlliquid(pm, cfg) {
+			next[id] = struct{}{}
+		}
+	}
+	skipSetMu.Lock()
+	currentSkipSet = next
+	skipSetMu.Unlock()
+}
+
+func getSkipSet() SkipSet {
+	skipSetMu.RLock()
+	defer skipSetMu.RUnlock()
+	return currentSkipSet
+}
+
+// allIngredientsIlliquid reports whether every ingredient in a single
+// craft's requirement map is currently in the skip set. An empty ingredient
+// map is never considered illiquid (there's nothing to short-circuit).
+func allIngredientsIlliquid(ingredients map[string]float64) bool {
+	if len(ingredients) == 0 {
+		return false
+	}
+	skip := getSkipSet()
+	for id := range ingredients {
+		if _, ok := skip[BAZAAR_ID(id)]; !ok {
+			return false
+		}
+	}
+	return true
+}
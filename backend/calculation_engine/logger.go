@@ -0,0 +1,180 @@
+// logger.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LevelTrace sits one tier below slog's own LevelDebug (-4), for call sites
+// finer-grained than anything this package had a level for before dlog -
+// per-cell parsing, per-candidate C10M comparisons, that sort of volume.
+// slog has no built-in Trace; this is its documented way to add a custom
+// level below Debug.
+const LevelTrace = slog.Level(-8)
+
+// structuredLogger is the package-wide slog.Logger, configured once from
+// LOG_FORMAT so every Trace/Debug/Info/Warn/Error call (and dlog, which
+// routes through Debug - see utils.go) gets consistent structured output
+// instead of each call site picking its own ad hoc format.
+var structuredLogger = newStructuredLogger()
+
+func newStructuredLogger() *slog.Logger {
+	opts := &slog.HandlerOptions{Level: LevelTrace}
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	return slog.New(handler)
+}
+
+// Trace, Debug, Info, Warn, and Error log msg with attrs at their named
+// level through structuredLogger, attaching ctx (e.g. an active Span's
+// identifiers) automatically. Prefer these over dlog for any new call site
+// that has structured fields to attach (slog.String("item", id), ...)
+// rather than a printf-style message. Each also mirrors the line into ctx's
+// debugSink, if one was installed via contextWithDebugSink, so a caller can
+// recover exactly what was logged for one request without scraping stderr.
+func Trace(ctx context.Context, msg string, attrs ...slog.Attr) {
+	structuredLogger.LogAttrs(ctx, LevelTrace, msg, spanAttrs(ctx, attrs)...)
+	appendDebugSink(ctx, "TRACE", msg, attrs)
+}
+
+func Debug(ctx context.Context, msg string, attrs ...slog.Attr) {
+	structuredLogger.LogAttrs(ctx, slog.LevelDebug, msg, spanAttrs(ctx, attrs)...)
+	appendDebugSink(ctx, "DEBUG", msg, attrs)
+}
+
+func Info(ctx context.Context, msg string, attrs ...slog.Attr) {
+	structuredLogger.LogAttrs(ctx, slog.LevelInfo, msg, spanAttrs(ctx, attrs)...)
+	appendDebugSink(ctx, "INFO", msg, attrs)
+}
+
+func Warn(ctx context.Context, msg string, attrs ...slog.Attr) {
+	structuredLogger.LogAttrs(ctx, slog.LevelWarn, msg, spanAttrs(ctx, attrs)...)
+	appendDebugSink(ctx, "WARN", msg, attrs)
+}
+
+func Error(ctx context.Context, msg string, attrs ...slog.Attr) {
+	structuredLogger.LogAttrs(ctx, slog.LevelError, msg, spanAttrs(ctx, attrs)...)
+	appendDebugSink(ctx, "ERROR", msg, attrs)
+}
+
+// debugSinkContextKey is the context.Context key contextWithDebugSink
+// stashes a *debugSink under, the same unexported-struct-key idiom
+// eventSinkContextKey (expansion_events.go) uses for ExpansionOptions.Events.
+type debugSinkContextKey struct{}
+
+// debugSink accumulates formatted log lines for a single request, guarded by
+// mu since Trace/Debug/Info/Warn/Error may be called from the concurrent
+// ingredient sub-expansions expandIngredientsConcurrent (tree_builder.go)
+// fans out onto expandWorkerPool.
+type debugSink struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// newDebugSink returns an empty debugSink for contextWithDebugSink to attach
+// to one request's context.
+func newDebugSink() *debugSink {
+	return &debugSink{}
+}
+
+// contextWithDebugSink returns a child of ctx that Trace/Debug/Info/Warn/
+// Error will mirror their output into sink for, so a handler that asked for
+// ?debug=1 can report back exactly what this request logged as a Trace
+// field on its response instead of the caller having to grep stderr.
+func contextWithDebugSink(ctx context.Context, sink *debugSink) context.Context {
+	if sink == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, debugSinkContextKey{}, sink)
+}
+
+// appendDebugSink formats one log call as "LEVEL msg key=value ..." and
+// appends it to ctx's debugSink, if one is installed; a no-op otherwise, so
+// every Trace/Debug/Info/Warn/Error call site pays no cost when no caller
+// asked for a captured trace.
+func appendDebugSink(ctx context.Context, level, msg string, attrs []slog.Attr) {
+	sink, ok := ctx.Value(debugSinkContextKey{}).(*debugSink)
+	if !ok || sink == nil {
+		return
+	}
+	line := level + " " + msg
+	for _, a := range attrs {
+		line += " " + a.Key + "=" + a.Value.String()
+	}
+	sink.mu.Lock()
+	sink.lines = append(sink.lines, line)
+	sink.mu.Unlock()
+}
+
+// Lines returns every line appended to sink so far, in order. Safe to call
+// concurrently with further appends, though a handler normally only reads it
+// once the expansion it wraps has returned.
+func (sink *debugSink) Lines() []string {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return append([]string(nil), sink.lines...)
+}
+
+// debugSinkLines returns ctx's captured log lines, or nil if no debugSink
+// was installed - the read-side counterpart to contextWithDebugSink, for a
+// caller (PerformDualExpansion) that wants to attach them to its own result
+// without reaching into the unexported context key itself.
+func debugSinkLines(ctx context.Context) []string {
+	sink, ok := ctx.Value(debugSinkContextKey{}).(*debugSink)
+	if !ok || sink == nil {
+		return nil
+	}
+	return sink.Lines()
+}
+
+// spanIDContextKey is the context.Context key Span stashes its current span
+// ID under, so a log call nested inside a Span (or a child Span) can report
+// which span it belongs to without every call site threading an ID through
+// explicitly.
+type spanIDContextKey struct{}
+
+var spanSeq atomic.Int64
+
+func spanAttrs(ctx context.Context, attrs []slog.Attr) []slog.Attr {
+	id, ok := ctx.Value(spanIDContextKey{}).(string)
+	if !ok {
+		return attrs
+	}
+	return append([]slog.Attr{slog.String("span", id)}, attrs...)
+}
+
+// Span starts a named tracing span: it logs a "span start" event at
+// LevelTrace, returns a child context any nested Span/log call can read its
+// span ID back out of, and an end func the caller defers to log the span's
+// duration at Debug level. This is this package's version of the
+// "mercury/lg" Span-closure pattern the backlog asks for, scoped to
+// ExpandRecipe/expandRecipeMemoized (recipe_expansion.go) and aggregateCells
+// (utils.go) - the entry points of one recipe evaluation - rather than
+// every recursive helper they call, since tracing a sub-millisecond pure
+// cell-parsing step adds log volume without adding anything worth reading.
+func Span(ctx context.Context, name string) (context.Context, func()) {
+	start := time.Now()
+	id := fmt.Sprintf("%s-%d", name, spanSeq.Add(1))
+
+	startAttrs := []slog.Attr{slog.String("span", id)}
+	if parent, ok := ctx.Value(spanIDContextKey{}).(string); ok {
+		startAttrs = append(startAttrs, slog.String("parent_span", parent))
+	}
+	structuredLogger.LogAttrs(ctx, LevelTrace, "span start", startAttrs...)
+
+	childCtx := context.WithValue(ctx, spanIDContextKey{}, id)
+	return childCtx, func() {
+		structuredLogger.LogAttrs(ctx, slog.LevelDebug, "span end",
+			slog.String("span", id), slog.Duration("duration", time.Since(start)))
+	}
+}
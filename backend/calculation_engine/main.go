@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -37,9 +40,201 @@ var (
 	isHealthy             bool // flag for readiness
 )
 
+// visitFlag accumulates repeated -visit flag values (flag.Value), e.g.
+// `wiz plan -visit a -visit b item`.
+type visitFlag []string
+
+func (v *visitFlag) String() string { return strings.Join(*v, ",") }
+func (v *visitFlag) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "arbitrage" {
+		fs := flag.NewFlagSet("arbitrage", flag.ExitOnError)
+		itemFilesDir := fs.String("items", "items", "directory of item recipe JSON files")
+		maxLen := fs.Int("max-len", 3, "maximum cycle length")
+		epsilon := fs.Float64("epsilon", 0.0, "minimum ratio above 1.0 to count a cycle as profitable")
+		top := fs.Int("top", 20, "maximum number of ranked cycles to report (<=0 for unbounded)")
+		opportunityCost := fs.Float64("opportunity-cost", 0, "coins/second a cycle's tied-up capital could earn elsewhere, discounted off its ratio before ranking (MinProfitEdge)")
+		fs.Parse(os.Args[2:])
+
+		if err := RunArbitrageCLI(*itemFilesDir, *maxLen, *epsilon, *top, *opportunityCost); err != nil {
+			log.Fatalf("[CALC-ENGINE] arbitrage: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		fs := flag.NewFlagSet("search", flag.ExitOnError)
+		itemFilesDir := fs.String("items", "items", "directory of item recipe JSON files")
+		limit := fs.Int("limit", 20, "maximum number of matches to print")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			log.Fatalf("[CALC-ENGINE] search: usage: wiz search [-items DIR] [-limit N] <query>")
+		}
+		query := strings.Join(fs.Args(), " ")
+
+		if err := RunSearchCLI(*itemFilesDir, query, *limit); err != nil {
+			log.Fatalf("[CALC-ENGINE] search: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "scenario" {
+		fs := flag.NewFlagSet("scenario", flag.ExitOnError)
+		configPath := fs.String("config", "config.json", "path to a scenario config file (see scenario_config.go)")
+		fs.Parse(os.Args[2:])
+
+		if err := RunScenarioCLI(context.Background(), *configPath); err != nil {
+			log.Fatalf("[CALC-ENGINE] scenario: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+		configPath := fs.String("config", "backtest_config.json", "path to a backtest config file (see backtest_config.go)")
+		fs.Parse(os.Args[2:])
+
+		if err := RunBacktestCLI(*configPath); err != nil {
+			log.Fatalf("[CALC-ENGINE] backtest: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "c10m-backtest" {
+		fs := flag.NewFlagSet("c10m-backtest", flag.ExitOnError)
+		configPath := fs.String("config", "backtest_config.json", "path to a backtest config file (see backtest_config.go); reused as-is by RunC10MBacktestCLI")
+		fs.Parse(os.Args[2:])
+
+		if err := RunC10MBacktestCLI(*configPath); err != nil {
+			log.Fatalf("[CALC-ENGINE] c10m-backtest: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		fs := flag.NewFlagSet("plan", flag.ExitOnError)
+		itemFilesDir := fs.String("items", "items", "directory of item recipe JSON files")
+		qty := fs.Float64("qty", 1, "quantity of the target item to plan for")
+		startingCoins := fs.Float64("coins", 0, "starting coins available (<=0 for unbounded)")
+		maxSeconds := fs.Float64("max-seconds", 600, "maximum session time in seconds")
+		var visit visitFlag
+		fs.Var(&visit, "visit", "item id that must appear in the acquisition chain (repeatable)")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			log.Fatalf("[CALC-ENGINE] plan: usage: wiz plan [-items DIR] [-qty N] [-coins N] [-max-seconds N] [-visit ID ...] <item>")
+		}
+		target := fs.Arg(0)
+
+		apiResp, err := getApiResponse(context.Background())
+		if err != nil {
+			log.Fatalf("[CALC-ENGINE] plan: fetching bazaar data: %v", err)
+		}
+		metricsMap, err := getMetricsMapFromFile(defaultMetricsFilePath)
+		if err != nil {
+			dlog("plan: failed to load metrics map from '%s': %v", defaultMetricsFilePath, err)
+		}
+
+		planResult, err := PlanCraftingSession(context.Background(), *itemFilesDir, target, *qty, *startingCoins, *maxSeconds, apiResp, metricsMap, []string(visit))
+		if err != nil {
+			log.Fatalf("[CALC-ENGINE] plan: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(planResult); err != nil {
+			log.Fatalf("[CALC-ENGINE] plan: encoding result: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rebalance" {
+		fs := flag.NewFlagSet("rebalance", flag.ExitOnError)
+		holdingsPath := fs.String("holdings", "holdings.json", "JSON file of item_id -> quantity held")
+		weightsPath := fs.String("weights", "weights.json", "JSON file of item_id -> target portfolio weight")
+		ignoreLockedPath := fs.String("ignore-locked", "", "JSON file listing item ids to skip (active orders)")
+		threshold := fs.Float64("threshold", 0.02, "minimum weight drift (fraction of portfolio value) to act on")
+		maxAmount := fs.Float64("max-amount", 0, "maximum coin value per order (<=0 for unbounded)")
+		dryRun := fs.Bool("dryRun", true, "print the plan without placing orders (this subcommand never places orders, so this is always true)")
+		intervalMinutes := fs.Float64("interval", 0, "re-plan every N minutes instead of running once (<=0 runs once)")
+		fs.Parse(os.Args[2:])
+		_ = dryRun // no order-placement integration exists yet; every run is a dry run.
+
+		run := func() {
+			if err := RunRebalanceCLI(*holdingsPath, *weightsPath, *threshold, *maxAmount, *ignoreLockedPath); err != nil {
+				log.Printf("[CALC-ENGINE] rebalance: %v", err)
+			}
+		}
+		run()
+		if *intervalMinutes > 0 {
+			ticker := time.NewTicker(time.Duration(*intervalMinutes * float64(time.Minute)))
+			defer ticker.Stop()
+			for range ticker.C {
+				run()
+			}
+		}
+		return
+	}
+
+	aliasesFile := flag.String("aliases-file", os.Getenv("WIZ_ALIASES"), "path to a JSON {\"VARIANT_ID\":\"CANONICAL_ID\"} file merged on top of the built-in item ID normalization map (env WIZ_ALIASES)")
+	dumpAliases := flag.Bool("dump-aliases", false, "print the effective merged item ID normalization table as JSON and exit")
+	disableTreeCache := flag.Bool("disable-tree-cache", false, "always rebuild CraftingStepNode trees instead of reusing TreeCache entries (tree_cache.go)")
+	rebuildTreeCache := flag.Bool("rebuild-tree-cache", false, "ignore existing TreeCache entries for this run but still save freshly rebuilt ones")
+	treeCacheContentHash := flag.Bool("tree-cache-content-hash", false, "fingerprint TreeCache recipe files by sha256 content hash in addition to mtime+size (tree_cache.go)")
+	noClear := flag.Bool("no-clear", false, "never emit ANSI clear/cursor-positioning escapes, even when stdout is a terminal (terminal.go)")
+	recipeSelection := flag.String("recipe-selection", string(RecipeSelectionCheapestCost), "how to pick among a multi-recipe item's variants: cheapest_cost, fastest_fill, highest_rated, prefer_npc, or first (tree_builder.go)")
+	excludeRecipeTags := flag.String("exclude-recipe-tags", "", "comma-separated Recipe.Tags values to drop from consideration entirely, e.g. slayer (tree_builder.go)")
+	fillTimePercentile := flag.String("fill-time-percentile", ActiveFillTimePercentile, "which FillTimePercentileEstimate field calculateBuyOrderFillTimePercentileValue reports: mean, p50, p90, or p99 (fill_time.go)")
+	fillTimeOrderCycleSeconds := flag.Float64("fill-time-order-cycle-seconds", DefaultFillTimeConfig.OrderCycleSeconds, "order cycle time used by the buy-order fill time formula, in seconds (fill_time.go FillTimeConfig)")
+	fillTimeMovingWindowSeconds := flag.Float64("fill-time-moving-window-seconds", DefaultFillTimeConfig.MovingWindowSeconds, "moving-window length backing QuickStatus.BuyMovingWeek/SellMovingWeek, in seconds (fill_time.go FillTimeConfig)")
+	fillTimeRateSource := flag.String("fill-time-rate-source", DefaultFillTimeConfig.RateSource, "instasell rate source: moving_week (flat/windowed BuyMovingWeek average) or ema (RateTracker-smoothed) (fill_time.go FillTimeConfig)")
+	flag.Parse()
+	NormalizationOverrideFile = *aliasesFile
+	TreeCacheDisabled = *disableTreeCache
+	TreeCacheForceRebuild = *rebuildTreeCache
+	TreeCacheUseContentHash = *treeCacheContentHash
+	NoClear = *noClear
+	ActiveRecipeSelectionPolicy = RecipeSelectionPolicy(*recipeSelection)
+	if *excludeRecipeTags != "" {
+		ExcludeRecipeTags = strings.Split(*excludeRecipeTags, ",")
+	}
+	ActiveFillTimePercentile = *fillTimePercentile
+	DefaultFillTimeConfig = FillTimeConfig{
+		OrderCycleSeconds:   *fillTimeOrderCycleSeconds,
+		MovingWindowSeconds: *fillTimeMovingWindowSeconds,
+		RateSource:          *fillTimeRateSource,
+	}
+
+	// Let a deployment size the ingredient-expansion worker pool (tree_builder.go)
+	// to its own CPU allocation instead of always defaulting to runtime.NumCPU().
+	applyExpandWorkerPoolSizeEnv(os.Getenv("WIZ_EXPAND_WORKERS"))
+
+	// Let a deployment point this process at a mirror or a recorded snapshot
+	// instead of the live Hypixel API; see bazaar_source.go.
+	CurrentBazaarSource = bazaarSourceFromEnv()
+
+	if *dumpAliases {
+		out, err := DumpNormalizationMap()
+		if err != nil {
+			log.Fatalf("[CALC-ENGINE] dump-aliases: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
 	log.Println("[CALC-ENGINE] Application starting up...")
 
+	// Reload NormalizationOverrideFile on SIGHUP so operators can push new
+	// Hypixel item renames without a restart.
+	WatchNormalizationReload()
+
+	// Same reload, triggered by the override file's mtime instead of a
+	// signal, for deployments that can rewrite it but can't reach the
+	// process directly; see normalization.go.
+	go WatchNormalizationReloadFile(context.Background(), 30*time.Second)
+
 	// Ensure temp directory exists
 	if err := os.MkdirAll("/tmp/metrics", os.ModePerm); err != nil {
 		log.Fatalf("[CALC-ENGINE] FATAL: Could not create temp directory: %v", err)
@@ -48,6 +243,80 @@ func main() {
 	// Start the HTTP server (liveness & readiness probes)
 	go startWebServer()
 
+	// Build the fuzzy item-lookup index /search serves out of, so
+	// SearchItems has something to query as soon as the first request
+	// arrives; see item_search.go.
+	go func() {
+		if err := BuildItemSearchIndex(defaultItemFilesDir); err != nil {
+			log.Printf("[CALC-ENGINE] WARN: item search index unavailable: %v", err)
+		}
+	}()
+
+	// Progressively refresh whichever items are currently referenced by an
+	// in-flight expansion, most-stale-first; see staleness.go.
+	go StartStaleRefreshWorker(context.Background(), 30*time.Second, getCurrentMetricsMap, nil)
+
+	// Periodically re-pull the Bazaar API and re-parse latest_metrics.json,
+	// tracked via PriceUpdateStatus/apiStatusHandler; see refresh.go.
+	go StartBackgroundRefresh(context.Background(), 30*time.Second, defaultMetricsFilePath)
+
+	// React to latest_metrics.json changing on disk within milliseconds
+	// instead of waiting for StartBackgroundRefresh's next 30s tick; see
+	// metrics_store.go.
+	go DefaultMetricsStore(defaultMetricsFilePath).Watch(context.Background())
+
+	// Keep DefaultPriceStore's Bazaar/metrics snapshot pair current off the
+	// same refresh cycle; see price_store.go.
+	go DefaultPriceStore().Run(context.Background(), 30*time.Second)
+
+	// Evict globalExpansionCache's least-recently-used entries once process
+	// heap usage crosses WIZ_MEMORYLIMIT (2 GiB by default), on top of its
+	// existing entry-count bound; see memory_evictor.go.
+	go startExpansionCacheMemoryEvictor(context.Background(), globalExpansionCache, 10*time.Second)
+
+	// Periodically re-rank every recipe root for the /items leaderboard;
+	// see wizserver.go. getApiResponse takes a ctx, but Start's apiResp
+	// param is a plain func() (*HypixelAPIResponse, error), so it's wrapped
+	// here rather than changing Start's signature for its one caller.
+	go wizItemsRanker.Start(context.Background(), 5*time.Minute, func() (*HypixelAPIResponse, error) {
+		return getApiResponse(context.Background())
+	}, getCurrentMetricsMap)
+
+	// Age stale buckets out of the historical cost time series so a /query
+	// against an item nobody's recalculated recently doesn't keep returning
+	// hours-old data as current; see memstore.go.
+	go startMemstoreTicker(defaultMemStore, memstoreBucketWidth)
+
+	// Drop per-product sample history for anything that's stopped trading,
+	// and persist the rest to disk on SIGINT/SIGTERM so a restart doesn't
+	// lose it; see metrics_history.go.
+	go StartMetricsHistoryEvictor(DefaultMetricsHistory(), time.Hour, nil)
+	go watchForShutdownAndSaveMetricsHistory()
+
+	// Keep the 24-hour craft profit/fee accumulator (see craft_state.go)
+	// continuous across restarts the same way.
+	go watchForShutdownAndSaveCraftState()
+
+	// Keep recorded C10M predictions and their per-item calibration
+	// accumulators continuous across restarts too; see position_tracker.go.
+	go watchForShutdownAndSavePositionTracker()
+
+	// Keep the per-item rolling rate history's on-disk files from growing
+	// unbounded; see serial_metrics_store.go.
+	if serialStore, err := DefaultSerialMetricsStore(); err != nil {
+		log.Printf("[CALC-ENGINE] WARN: serial metrics store unavailable: %v", err)
+	} else {
+		StartSerialMetricsCompactor(serialStore, time.Hour, nil)
+	}
+
+	// Same idea as the serial metrics compactor above, but for the 5-minute
+	// AveragedMetrics history backing /history and /trend.
+	if priceHistoryStore, err := DefaultPriceHistoryStore(); err != nil {
+		log.Printf("[CALC-ENGINE] WARN: price history store unavailable: %v", err)
+	} else {
+		StartPriceHistoryCompactor(priceHistoryStore, time.Hour, nil)
+	}
+
 	// Kick off metrics update immediately, then every 5 minutes
 	go func() {
 		updateLatestMetrics()
@@ -69,6 +338,130 @@ func startWebServer() {
 	// Readiness/data probe: returns metrics once ready
 	http.HandleFunc("/latest_metrics/", metricsHandler)
 
+	// Prometheus-compatible scrape endpoint for the cache + fetch pipeline.
+	http.Handle("/metrics", MetricsHandler())
+
+	// Most out-of-date items currently referenced by an in-flight expansion,
+	// plus the ongoing background refresh cycle's progress.
+	http.HandleFunc("/stale", staleItemsHandler)
+
+	// Per-item data-freshness report (metrics age/confidence, sample count)
+	// plus the last Bazaar API poll and a top-N stale-items listing.
+	http.HandleFunc("/api/freshness", freshnessHandler)
+	http.HandleFunc("/api/freshness/", freshnessHandler)
+
+	// Per-item buy/sell rate over a configurable window (1h/6h/24h/7d),
+	// backing the fill-time functions' *WithWindow variants.
+	http.HandleFunc("/fill_rate", fillRateHandler)
+
+	// Persistent per-item price history: /history/{item}?window=24h returns
+	// the raw PriceSnapshot series, /trend/{item}?window=24h the rolling
+	// mean/stddev/7-day EMA derived from it; see price_history_store.go.
+	http.HandleFunc("/history/", historyHandler)
+	http.HandleFunc("/trend/", trendHandler)
+
+	// StartBackgroundRefresh's progress/timing, so clients can tell whether
+	// cached data is mid-refresh rather than just stale; see refresh.go.
+	http.HandleFunc("/api/status", apiStatusHandler)
+
+	// Fuzzy item lookup over the inverted index BuildItemSearchIndex builds
+	// at startup: /search?q=hyperion&limit=5; see item_search.go.
+	http.HandleFunc("/search", searchItemsHandler)
+
+	// HTML dashboard: a single item's dual-expansion breakdown, the
+	// rank-ordered profitability listing, and refresh subsystem status.
+	http.HandleFunc("/dashboard/item", itemDashboardHandler)
+	http.HandleFunc("/dashboard/list", listDashboardHandler)
+	http.HandleFunc("/dashboard/status", statusDashboardHandler)
+
+	// Bulk expansion: NDJSON request body in, NDJSON response body out,
+	// streamed row-by-row instead of buffered as one big array.
+	http.HandleFunc("/expand/bulk", bulkExpansionHandler)
+
+	// Job-oriented single-item dual expansion: POST starts the expansion in
+	// the background and returns a job ID immediately; the nested GET routes
+	// poll a snapshot or stream NDJSON progress, instead of a client having
+	// to hold a connection open for the whole expansion like /expand/bulk does.
+	http.HandleFunc("/api/expand-dual/jobs", expandJobsHandler)
+	http.HandleFunc("/api/expand-dual/jobs/", expandJobHandler)
+
+	// Same single-item dual expansion, pushed live as SSE frames instead of
+	// polled: every ExpansionEvent PerformDualExpansion emits internally
+	// (expansion_events.go) becomes one frame, then a final result frame.
+	http.HandleFunc("/api/expand-dual/stream", expandDualStreamHandler)
+	// /api/fill/stream is the same single-item streamed expansion under the
+	// backlog's "fill" name; see fill_stream.go.
+	http.HandleFunc("/api/fill/stream", expandDualStreamHandler)
+	http.HandleFunc("/api/watch/stream", watchStreamHandler)
+
+	// Batch fill: POST submits a whole array of {item, quantity} rows as one
+	// job and returns a batch ID immediately; the nested GET routes poll an
+	// aggregated snapshot or stream NDJSON progress as rows complete, so a
+	// client pricing hundreds of recipes doesn't need hundreds of open
+	// connections. Backed by an in-process worker pool; setting NATS_URL
+	// only logs a warning, since this build has no JetStream client (see
+	// batch_fill.go's fillBatchBackend).
+	http.HandleFunc("/api/fill/batch", fillBatchHandler)
+	http.HandleFunc("/api/fill/batch/", fillBatchByIDHandler)
+
+	// Rank-ordered profitability leaderboard (HTML or JSON, selected by
+	// Accept/?format=) and its refresh/sort status; see wizserver.go.
+	http.HandleFunc("/items", wizItemsHandler)
+	http.HandleFunc("/items/", wizItemHandler)
+	http.HandleFunc("/status", wizStatusHandler)
+
+	// Versioned JSON REST surface over the same ranking/recipe/expansion
+	// logic above, CORS-enabled for cross-origin tooling instead of
+	// content-negotiated HTML; see apiv1.go.
+	http.HandleFunc("/api/v1/items", withCORS(apiV1ItemsHandler))
+	http.HandleFunc("/api/v1/item/", withCORS(apiV1ItemHandler))
+	http.HandleFunc("/api/v1/recipe/", withCORS(apiV1RecipeHandler))
+	http.HandleFunc("/api/v1/expand/", withCORS(apiV1ExpandHandler))
+
+	// Profitable-opportunities leaderboard (positive-margin subset of the
+	// same scan, filterable by minRR) and its own scan warm-up progress;
+	// see opportunities.go.
+	http.HandleFunc("/opportunities", opportunitiesHandler)
+	http.HandleFunc("/opportunities/status", opportunitiesStatusHandler)
+
+	// Triangular arbitrage scan over the recipe graph, ranked by expected
+	// profit/hour at each cycle's bottleneck volume; see arbitrage_handler.go.
+	http.HandleFunc("/api/arbitrage-cycles", arbitrageCyclesHandler)
+
+	// Background RunFullOptimization sweeps, pollable by job ID instead of
+	// blocking the submitting request; see optimize_job.go.
+	http.HandleFunc("/api/optimize-all", optimizeAllHandler)
+	http.HandleFunc("/api/jobs/", optimizeJobHandler)
+	// Same sweep, pushed live as SSE frames (one per item, plus progress/
+	// summary) instead of polled by job ID; see optimize_stream.go.
+	http.HandleFunc("/api/optimize-all/stream", optimizeAllStreamHandler)
+
+	// One-round-trip batch expansion for an inventory-wide cost report; see
+	// calculate_batch.go.
+	http.HandleFunc("/calculate/batch", calculateBatchHandler)
+
+	// Line-protocol ingestion and downsampled time-series queries over
+	// historical calculation results; see memstore.go.
+	http.HandleFunc("/write", memstoreWriteHandler)
+	http.HandleFunc("/query", memstoreQueryHandler)
+
+	http.HandleFunc("/metrics/write", metricsWriteHandler)
+
+	// Mimir-style active-series inspection over calculate-handler traffic;
+	// see cardinality.go. /metrics itself is already registered above.
+	http.HandleFunc("/cardinality/active_items", activeItemsHandler)
+
+	// Server-Sent Events push of a live recalculation on every Bazaar
+	// refresh, riding refresh.go's existing Subscribe()/Unsubscribe() pub/sub
+	// rather than a second broadcast mechanism; see calculate_stream.go.
+	http.HandleFunc("/calculate/stream", calculateStreamHandler)
+
+	// Read-only view of CraftState's daily accumulators/budgets, so a
+	// long-running daemon's operator can confirm PlaceOrderIfProfitable's
+	// risk caps without reading craft_state.json directly; see
+	// place_order.go.
+	http.HandleFunc("/state/craft", craftStateHandler)
+
 	// Bind to port from environment (e.g., Koyeb sets PORT), default to 8080
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -154,13 +547,12 @@ func updateLatestMetrics() {
 			log.Printf("[CALC-ENGINE] ERROR: Could not open %s: %v", localPath, err)
 			continue
 		}
-		var metrics []Metric
-		if err := json.NewDecoder(f).Decode(&metrics); err != nil {
+		metrics, err := decodeMetricsFile(f)
+		f.Close()
+		if err != nil {
 			log.Printf("[CALC-ENGINE] ERROR: Failed to parse JSON %s: %v", localPath, err)
-			f.Close()
 			continue
 		}
-		f.Close()
 		allMetrics = append(allMetrics, metrics)
 	}
 
@@ -177,9 +569,63 @@ func updateLatestMetrics() {
 	isHealthy = true
 	metricsMutex.Unlock()
 
+	// Persist this cycle's averages so /history and /trend have something
+	// to query beyond whatever's currently in latestAveragedMetrics; see
+	// price_history_store.go.
+	if store, err := DefaultPriceHistoryStore(); err != nil {
+		log.Printf("[CALC-ENGINE] WARN: price history store unavailable: %v", err)
+	} else {
+		store.IngestAveragedMetrics(newAvg, time.Now())
+	}
+
 	log.Println("[CALC-ENGINE] Metrics update cycle finished successfully.")
 }
 
+// decodeMetricsFile reads a metrics_*.json file in either shape the
+// upstream producer may emit: a single "[...]" JSON array, or NDJSON (one
+// Metric object per line, no enclosing brackets or commas) - the two modes
+// jsonstream.ArrayWriter (backend/testing/jsonstream.go) supports. The
+// array case is detected by peeking the decoder's first token; NDJSON is
+// just repeated top-level Decode calls, which json.Decoder already
+// supports for a stream of whitespace-separated values.
+func decodeMetricsFile(f *os.File) ([]Metric, error) {
+	dec := json.NewDecoder(f)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("decodeMetricsFile: %w", err)
+	}
+
+	if delim, ok := tok.(json.Delim); ok && delim == '[' {
+		var metrics []Metric
+		for dec.More() {
+			var m Metric
+			if err := dec.Decode(&m); err != nil {
+				return nil, fmt.Errorf("decodeMetricsFile: decode array element: %w", err)
+			}
+			metrics = append(metrics, m)
+		}
+		return metrics, nil
+	}
+
+	// Not an array: rewind and treat the file as NDJSON.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("decodeMetricsFile: seek for NDJSON retry: %w", err)
+	}
+	dec = json.NewDecoder(f)
+	var metrics []Metric
+	for {
+		var m Metric
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("decodeMetricsFile: decode NDJSON line: %w", err)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
 // calculateAverages computes the average of each metric over all files.
 func calculateAverages(allMetrics [][]Metric) AveragedMetrics {
 	type agg struct {
@@ -0,0 +1,331 @@
+// marketstore.go
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// intervalRateLimiter is a minimal rate limiter for the two update-queue
+// worker pools below: Wait blocks until at least 1/ratePerSecond has elapsed
+// since the previous call ever returned, so a burst of queued work still
+// hits the upstream API no faster than configured.
+type intervalRateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newIntervalRateLimiter(ratePerSecond float64) *intervalRateLimiter {
+	if ratePerSecond <= 0 {
+		ratePerSecond = 10
+	}
+	return &intervalRateLimiter{interval: time.Duration(float64(time.Second) / ratePerSecond)}
+}
+
+// Wait is a no-op on a nil *intervalRateLimiter, so callers that only
+// sometimes want rate limiting (e.g. RunFullOptimization when
+// RequestsPerSecond wasn't configured) can skip constructing one.
+func (r *intervalRateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.last.IsZero() {
+		if elapsed := time.Since(r.last); elapsed < r.interval {
+			time.Sleep(r.interval - elapsed)
+		}
+	}
+	r.last = time.Now()
+}
+
+// MarketDataStore holds one consistent snapshot of Bazaar/metrics data plus
+// the two-queue refresh subsystem that keeps it current, modeled on the
+// Universalis FFXIV market-data tool's nameUpdateQueue/priceUpdateQueue
+// split: slow-changing recipe/item metadata refreshes on one queue, fast
+// market price data on another, each drained by its own worker pool so a
+// backlog on one never starves the other. ExpandDual reads a snapshot from
+// here instead of the package-level getApiResponse/getMetricsMapFromFile
+// globals, so a caller can pin a calculation to one generation while the
+// store keeps refreshing in the background.
+type MarketDataStore struct {
+	nameUpdateQueue  chan int
+	priceUpdateQueue chan int
+	limiter          *intervalRateLimiter
+	wg               sync.WaitGroup
+
+	mu         sync.RWMutex
+	apiResp    *HypixelAPIResponse
+	metricsMap map[string]ProductMetrics
+	generation int64
+
+	LastFullPriceUpdateTime  time.Time
+	StartFullPriceUpdateTime time.Time
+	priceUpdateTotal         int32
+	priceUpdateDone          int32
+
+	// refreshMu/refreshing/refreshDone/refreshErr single-flight a whole-
+	// snapshot ForceRefresh: a caller that arrives mid-refresh joins the one
+	// already running instead of issuing a second, overlapping fetch.
+	refreshMu   sync.Mutex
+	refreshing  bool
+	refreshDone chan struct{}
+	refreshErr  error
+}
+
+// NewMarketDataStore creates a store with the given queue depth;
+// ratePerSecond caps how fast each pool's workers collectively issue
+// upstream requests. workerCount is not kept: each StartNameUpdateWorkers/
+// StartPriceUpdateWorkers call takes its own worker count.
+func NewMarketDataStore(queueDepth, workerCount int, ratePerSecond float64) *MarketDataStore {
+	if queueDepth <= 0 {
+		queueDepth = 1024
+	}
+	if workerCount <= 0 {
+		workerCount = 4
+	}
+	store := &MarketDataStore{
+		nameUpdateQueue:  make(chan int, queueDepth),
+		priceUpdateQueue: make(chan int, queueDepth),
+		limiter:          newIntervalRateLimiter(ratePerSecond),
+	}
+	return store
+}
+
+// Snapshot returns the store's currently published apiResp/metricsMap and the
+// generation they were published under. Safe for concurrent use; the
+// returned values are never mutated in place, only replaced wholesale by
+// Publish, so callers can keep using an old snapshot after a newer one lands.
+func (s *MarketDataStore) Snapshot() (apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, generation int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.apiResp, s.metricsMap, s.generation
+}
+
+// Publish installs a new snapshot, bumping the generation counter.
+func (s *MarketDataStore) Publish(apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) int64 {
+	s.mu.Lock()
+	s.generation++
+	s.apiResp = apiResp
+	s.metricsMap = metricsMap
+	generation := s.generation
+	s.mu.Unlock()
+
+	// Entries keyed to an older generation can never be hit again now that
+	// the snapshot has advanced; drop them so ExpansionCache doesn't grow
+	// unbounded across refresh cycles.
+	globalExpansionCache.InvalidateOlderThan(generation)
+
+	return generation
+}
+
+// EnqueueNameUpdate and EnqueuePriceUpdate submit work (an opaque item index
+// into whatever slice the caller's worker function closes over, matching the
+// Universalis `chan int` convention) to the corresponding queue. Both block
+// if the queue is full rather than silently dropping work.
+func (s *MarketDataStore) EnqueueNameUpdate(itemIndex int)  { s.nameUpdateQueue <- itemIndex }
+func (s *MarketDataStore) EnqueuePriceUpdate(itemIndex int) { s.priceUpdateQueue <- itemIndex }
+
+// StartNameUpdateWorkers and StartPriceUpdateWorkers launch workerCount
+// goroutines each draining the respective queue until ctx is cancelled,
+// rate-limited by the store's shared limiter and tracked by WaitForIdle via
+// an internal WaitGroup: each item dequeued counts as one unit of in-flight
+// work from the moment it's popped until fn returns.
+func (s *MarketDataStore) StartNameUpdateWorkers(ctx context.Context, workerCount int, fn func(itemIndex int)) {
+	s.startWorkers(ctx, s.nameUpdateQueue, workerCount, fn)
+}
+
+func (s *MarketDataStore) StartPriceUpdateWorkers(ctx context.Context, workerCount int, fn func(itemIndex int)) {
+	s.startWorkers(ctx, s.priceUpdateQueue, workerCount, fn)
+}
+
+func (s *MarketDataStore) startWorkers(ctx context.Context, queue chan int, workerCount int, fn func(itemIndex int)) {
+	if workerCount <= 0 {
+		workerCount = 4
+	}
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case itemIndex, ok := <-queue:
+					if !ok {
+						return
+					}
+					s.wg.Add(1)
+					s.limiter.Wait()
+					fn(itemIndex)
+					s.wg.Done()
+				}
+			}
+		}()
+	}
+}
+
+// WaitForIdle blocks until every item popped off either queue has finished
+// processing. It does not guarantee the queues are empty (more work may be
+// enqueued concurrently); callers typically call it right after a batch
+// enqueue to know when that batch has fully drained.
+func (s *MarketDataStore) WaitForIdle() {
+	s.wg.Wait()
+}
+
+// BeginFullPriceUpdate resets the progress counters for a new full pass over
+// total items; call it before enqueueing a full-refresh batch onto
+// priceUpdateQueue.
+func (s *MarketDataStore) BeginFullPriceUpdate(total int) {
+	s.mu.Lock()
+	s.StartFullPriceUpdateTime = time.Now()
+	s.mu.Unlock()
+	atomic.StoreInt32(&s.priceUpdateTotal, int32(total))
+	atomic.StoreInt32(&s.priceUpdateDone, 0)
+}
+
+// AdvancePriceUpdateProgress records that one more item from the current full
+// pass has been refreshed; call it from the price worker function passed to
+// StartPriceUpdateWorkers.
+func (s *MarketDataStore) AdvancePriceUpdateProgress() {
+	atomic.AddInt32(&s.priceUpdateDone, 1)
+}
+
+// CompleteFullPriceUpdate marks the current full pass finished.
+func (s *MarketDataStore) CompleteFullPriceUpdate() {
+	s.mu.Lock()
+	s.LastFullPriceUpdateTime = time.Now()
+	s.mu.Unlock()
+}
+
+// PriceUpdateProgress reports how far the current (or most recent) full price
+// update pass has gotten, 0-100. A pass with no items reports 100.
+func (s *MarketDataStore) PriceUpdateProgress() int {
+	total := atomic.LoadInt32(&s.priceUpdateTotal)
+	if total <= 0 {
+		return 100
+	}
+	done := atomic.LoadInt32(&s.priceUpdateDone)
+	pct := 100 * int(done) / int(total)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// Progress reports the current (or most recently completed) full refresh's
+// completion percentage alongside when it started and when it last
+// finished, so a caller like dashboard.go's /dashboard/status view can read
+// all three together instead of PriceUpdateProgress() plus two field reads.
+func (s *MarketDataStore) Progress() (percent int, lastStart, lastFinish time.Time) {
+	s.mu.RLock()
+	lastStart = s.StartFullPriceUpdateTime
+	lastFinish = s.LastFullPriceUpdateTime
+	s.mu.RUnlock()
+	return s.PriceUpdateProgress(), lastStart, lastFinish
+}
+
+// ForceRefresh fetches a fresh HypixelAPIResponse and re-reads metricsFilePath,
+// then Publishes the pair as a new snapshot. Concurrent callers single-flight
+// onto whichever refresh is already in progress rather than triggering a
+// second overlapping fetch; each still gets that refresh's error, if any.
+func (s *MarketDataStore) ForceRefresh(ctx context.Context, metricsFilePath string) error {
+	s.refreshMu.Lock()
+	if s.refreshing {
+		done := s.refreshDone
+		s.refreshMu.Unlock()
+		select {
+		case <-done:
+			s.refreshMu.Lock()
+			err := s.refreshErr
+			s.refreshMu.Unlock()
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.refreshing = true
+	done := make(chan struct{})
+	s.refreshDone = done
+	s.refreshMu.Unlock()
+
+	err := s.doRefresh(metricsFilePath)
+
+	s.refreshMu.Lock()
+	s.refreshErr = err
+	s.refreshing = false
+	close(done)
+	s.refreshMu.Unlock()
+	return err
+}
+
+// doRefresh is ForceRefresh's actual work, run by whichever caller won the
+// single-flight race.
+func (s *MarketDataStore) doRefresh(metricsFilePath string) error {
+	s.BeginFullPriceUpdate(1)
+
+	apiResp, err := forceRefreshAPIData(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := ReloadMetricsFileCache(metricsFilePath); err != nil {
+		return err
+	}
+	metricsMap, err := getMetricsMapFromFile(metricsFilePath)
+	if err != nil {
+		return err
+	}
+
+	s.AdvancePriceUpdateProgress()
+	s.Publish(apiResp, metricsMap)
+	s.CompleteFullPriceUpdate()
+	return nil
+}
+
+// StartBackgroundRefresh launches a goroutine that calls ForceRefresh every
+// interval until ctx is cancelled, giving store its own periodic refresh
+// loop in the style of refresh.go's package-level StartBackgroundRefresh but
+// driving this store's snapshot instead of the package-level
+// getApiResponse/getMetricsMapFromFile globals. Cancelling ctx is the
+// graceful shutdown path: the goroutine exits after its current tick (if
+// any) rather than being killed mid-refresh.
+func (s *MarketDataStore) StartBackgroundRefresh(ctx context.Context, interval time.Duration, metricsFilePath string) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.ForceRefresh(ctx, metricsFilePath); err != nil {
+					log.Printf("Warning (MarketDataStore.StartBackgroundRefresh): refresh failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// ExpandDual runs PerformDualExpansion against a single consistent snapshot
+// pulled from store rather than the package-level getApiResponse/metrics
+// globals, and stamps the result with the snapshot generation it used so a
+// caller can tell whether two results were computed against the same
+// underlying data.
+func ExpandDual(ctx context.Context, store *MarketDataStore, itemName string, quantity float64, itemFilesDir string, includeTreeInExpansionResult bool) (*DualExpansionResult, error) {
+	apiResp, metricsMap, generation := store.Snapshot()
+	result, err := PerformDualExpansion(ctx, itemName, quantity, apiResp, metricsMap, itemFilesDir, includeTreeInExpansionResult, PrecisionFloat, ExpansionOptions{})
+	if result != nil {
+		result.SnapshotGeneration = generation
+		_, _, lastFinish := store.Progress()
+		if lastFinish.IsZero() {
+			result.DataAgeSeconds = toJSONFloat64(math.NaN())
+		} else {
+			result.DataAgeSeconds = toJSONFloat64(time.Since(lastFinish).Seconds())
+		}
+	}
+	return result, err
+}
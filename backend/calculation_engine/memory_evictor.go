@@ -0,0 +1,99 @@
+// memory_evictor.go
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// memoryLimitEnv names the env var that overrides
+// defaultExpansionCacheMemoryLimitBytes, expressed in GiB (may be
+// fractional, e.g. "1.5"). Follows the same WIZ_* naming as
+// WIZ_EXPAND_WORKERS/WIZ_ALIASES.
+const memoryLimitEnv = "WIZ_MEMORYLIMIT"
+
+// defaultExpansionCacheMemoryLimitBytes is the heap budget
+// startExpansionCacheMemoryEvictor enforces when WIZ_MEMORYLIMIT isn't set.
+// The stdlib has no portable way to read total system RAM without a
+// third-party dependency (this package avoids those - see coins.go and
+// batch_fill.go), so this is a fixed fallback rather than "1/4 of total RAM";
+// operators who want it sized off actual machine memory should set
+// WIZ_MEMORYLIMIT explicitly.
+const defaultExpansionCacheMemoryLimitBytes = 2 << 30 // 2 GiB
+
+// expansionCacheMemoryLimitBytes resolves the configured heap budget from
+// WIZ_MEMORYLIMIT, falling back to defaultExpansionCacheMemoryLimitBytes if
+// unset or unparsable.
+func expansionCacheMemoryLimitBytes() uint64 {
+	raw := os.Getenv(memoryLimitEnv)
+	if raw == "" {
+		return defaultExpansionCacheMemoryLimitBytes
+	}
+	gib, err := strconv.ParseFloat(raw, 64)
+	if err != nil || gib <= 0 {
+		dlog("expansionCacheMemoryLimitBytes: ignoring invalid %s=%q", memoryLimitEnv, raw)
+		return defaultExpansionCacheMemoryLimitBytes
+	}
+	return uint64(gib * (1 << 30))
+}
+
+// oldestEvictor is the subset of ExpansionCacheBackend that
+// startExpansionCacheMemoryEvictor can shrink under memory pressure.
+// lruExpansionCacheBackend implements it; diskExpansionCacheBackend doesn't,
+// since evicting its on-disk files wouldn't free heap anyway.
+type oldestEvictor interface {
+	EvictOldest(n int) int
+	Len() int
+}
+
+// expansionCacheMemoryEvictStep is the fraction of a backend's current
+// entries dropped per over-budget tick, so a spike is worked off over a few
+// ticks rather than emptying the cache in one pass.
+const expansionCacheMemoryEvictStep = 0.1
+
+// startExpansionCacheMemoryEvictor periodically compares
+// runtime.MemStats.HeapAlloc against expansionCacheMemoryLimitBytes() and, if
+// over budget, evicts expansionCacheMemoryEvictStep of c's current entries
+// from the least-recently-used end. It returns once ctx is cancelled. This
+// is on top of lruExpansionCacheBackend's existing count bound (Put) - that
+// one protects against unbounded key cardinality, this one protects against
+// a machine with little RAM and a handful of very large DualExpansionResults.
+func startExpansionCacheMemoryEvictor(ctx context.Context, c *ExpansionCache, interval time.Duration) {
+	backend, ok := c.backend.(oldestEvictor)
+	if !ok {
+		return
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	limit := expansionCacheMemoryLimitBytes()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc <= limit {
+				continue
+			}
+			n := backend.Len()
+			evictN := int(float64(n) * expansionCacheMemoryEvictStep)
+			if evictN < 1 {
+				evictN = 1
+			}
+			evicted := backend.EvictOldest(evictN)
+			if evicted > 0 {
+				atomic.AddInt64(&c.evictions, int64(evicted))
+				dlog("startExpansionCacheMemoryEvictor: heap %d bytes over %d byte limit, evicted %d of %d entries", stats.HeapAlloc, limit, evicted, n)
+			}
+		}
+	}
+}
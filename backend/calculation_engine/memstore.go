@@ -0,0 +1,444 @@
+// memstore.go
+package main
+
+// This is the internal/memstore subsystem the backlog describes, modeled on
+// cc-metric-store: a ring-buffered time series store for completed
+// calculation results, queryable without re-running the expansion that
+// produced them. It lives alongside every other file in this package rather
+// than under internal/ for the same reason wizserver.go's package comment
+// gives for /items - this repo has no go.mod/module path for an internal/
+// package to resolve against, and every subsystem here is already part of
+// the one package-main binary.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memstoreBucketWidth and memstoreBucketCount size each item's ring at 24h
+// of 60s resolution, per the backlog's "1440 buckets of 60s each" spec.
+const (
+	memstoreBucketWidth = 60 * time.Second
+	memstoreBucketCount = 1440
+)
+
+// memstoreMetric enumerates the four series RecordCalculationResult and
+// /write populate.
+type memstoreMetric int
+
+const (
+	metricDirectCost memstoreMetric = iota
+	metricCraftCost
+	metricBottleneckFillTime
+	metricRR
+	memstoreMetricCount
+)
+
+// memstoreMetricNames maps /query's ?metric= and line-protocol field names
+// to a memstoreMetric. "direct"/"craft" are the short field names the
+// backlog's example line-protocol payload uses; the long names are accepted
+// too so /query and /write can share one vocabulary.
+var memstoreMetricNames = map[string]memstoreMetric{
+	"direct":             metricDirectCost,
+	"directCost":         metricDirectCost,
+	"craft":              metricCraftCost,
+	"craftCost":          metricCraftCost,
+	"fillTime":           metricBottleneckFillTime,
+	"bottleneckFillTime": metricBottleneckFillTime,
+	"rr":                 metricRR,
+}
+
+// memstoreBucket aggregates every value appended within its 60s window, so
+// /query's agg=avg|min|max|last has something to compute over even when a
+// hot item receives more than one write per bucket; set is false for a
+// bucket nothing has ever been appended to (distinct from a real value of
+// 0), and slot is the absolute bucket index it currently represents, so a
+// stale ring entry (see memstoreSeries.advanceTo) is never mistaken for the
+// bucket a caller asked for.
+type memstoreBucket struct {
+	slot  int64
+	set   bool
+	sum   float64
+	count int64
+	min   float64
+	max   float64
+	last  float64
+}
+
+func (b *memstoreBucket) append(value float64) {
+	if !b.set {
+		b.min, b.max = value, value
+	} else {
+		if value < b.min {
+			b.min = value
+		}
+		if value > b.max {
+			b.max = value
+		}
+	}
+	b.sum += value
+	b.count++
+	b.last = value
+	b.set = true
+}
+
+func (b memstoreBucket) aggregate(agg string) (float64, bool) {
+	if !b.set {
+		return 0, false
+	}
+	switch agg {
+	case "min":
+		return b.min, true
+	case "max":
+		return b.max, true
+	case "last":
+		return b.last, true
+	default: // "avg" and anything unrecognized
+		return b.sum / float64(b.count), true
+	}
+}
+
+// memstoreSeries is one item's fixed-size ring of buckets for one metric.
+type memstoreSeries struct {
+	buckets  [memstoreBucketCount]memstoreBucket
+	headSlot int64 // absolute index (unix seconds / bucket width) buckets[headSlot % N] currently represents
+}
+
+func slotFor(at time.Time) int64 {
+	return at.Unix() / int64(memstoreBucketWidth/time.Second)
+}
+
+// advanceTo clears every bucket between the series' current head and slot
+// (inclusive) that the head hasn't reached yet, then moves the head to
+// slot - this is both how a fresh write rolls the ring forward and what the
+// background ticker (startMemstoreTicker) uses to age out stale data from
+// items nobody has written to recently.
+func (s *memstoreSeries) advanceTo(slot int64) {
+	if slot <= s.headSlot {
+		return
+	}
+	clearFrom := s.headSlot + 1
+	if slot-clearFrom >= memstoreBucketCount {
+		clearFrom = slot - memstoreBucketCount + 1
+	}
+	for sl := clearFrom; sl <= slot; sl++ {
+		s.buckets[sl%memstoreBucketCount] = memstoreBucket{slot: sl}
+	}
+	s.headSlot = slot
+}
+
+func (s *memstoreSeries) append(at time.Time, value float64) {
+	slot := slotFor(at)
+	if slot > s.headSlot {
+		s.advanceTo(slot)
+	}
+	if slot <= s.headSlot-memstoreBucketCount {
+		return // older than the ring can hold; drop it rather than corrupt an unrelated bucket
+	}
+	b := &s.buckets[slot%memstoreBucketCount]
+	if b.slot != slot {
+		*b = memstoreBucket{slot: slot}
+	}
+	b.append(value)
+}
+
+// memstoreItem holds all memstoreMetricCount series for one NormalizedProductID.
+type memstoreItem struct {
+	mu     sync.Mutex
+	series [memstoreMetricCount]*memstoreSeries
+}
+
+// MemStore is the process-wide ring-buffered time series store: one
+// memstoreItem per NormalizedProductID, sized per memstoreBucketWidth/Count.
+type MemStore struct {
+	mu    sync.RWMutex
+	items map[string]*memstoreItem
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{items: make(map[string]*memstoreItem)}
+}
+
+// defaultMemStore is the singleton every handler in this package writes to
+// and queries, mirroring DefaultResultCache()'s package-level-singleton
+// convention (result_cache.go).
+var defaultMemStore = NewMemStore()
+
+func (s *MemStore) itemFor(id string) *memstoreItem {
+	s.mu.RLock()
+	it, ok := s.items[id]
+	s.mu.RUnlock()
+	if ok {
+		return it
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, ok := s.items[id]; ok {
+		return it
+	}
+	it = &memstoreItem{}
+	s.items[id] = it
+	return it
+}
+
+// Append records one (metric, value) point for id at time at.
+func (s *MemStore) Append(id string, metric memstoreMetric, at time.Time, value float64) {
+	it := s.itemFor(id)
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	series := it.series[metric]
+	if series == nil {
+		series = &memstoreSeries{headSlot: slotFor(at) - 1}
+		it.series[metric] = series
+	}
+	series.append(at, value)
+}
+
+// memstorePoint is one downsampled output point from Query.
+type memstorePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Query returns every bucket for id/metric whose window overlaps [from, to],
+// aggregated per agg (avg/min/max/last, default avg), oldest first.
+func (s *MemStore) Query(id string, metric memstoreMetric, from, to time.Time, agg string) []memstorePoint {
+	s.mu.RLock()
+	it, ok := s.items[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	series := it.series[metric]
+	if series == nil {
+		return nil
+	}
+
+	fromSlot, toSlot := slotFor(from), slotFor(to)
+	oldestHeld := series.headSlot - memstoreBucketCount + 1
+	if fromSlot < oldestHeld {
+		fromSlot = oldestHeld
+	}
+	if toSlot > series.headSlot {
+		toSlot = series.headSlot
+	}
+
+	var points []memstorePoint
+	for slot := fromSlot; slot <= toSlot; slot++ {
+		b := series.buckets[slot%memstoreBucketCount]
+		if b.slot != slot {
+			continue
+		}
+		value, ok := b.aggregate(agg)
+		if !ok {
+			continue
+		}
+		points = append(points, memstorePoint{
+			Timestamp: slot * int64(memstoreBucketWidth/time.Second),
+			Value:     value,
+		})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+	return points
+}
+
+// startMemstoreTicker periodically advances every series to "now", aging out
+// buckets belonging to items that have stopped receiving writes so a /query
+// against them doesn't keep returning hours-old data as if it were current.
+// Run once from main() via go startMemstoreTicker(defaultMemStore, memstoreBucketWidth);
+// it never returns.
+func startMemstoreTicker(store *MemStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		store.mu.RLock()
+		items := make([]*memstoreItem, 0, len(store.items))
+		for _, it := range store.items {
+			items = append(items, it)
+		}
+		store.mu.RUnlock()
+
+		slot := slotFor(now)
+		for _, it := range items {
+			it.mu.Lock()
+			for _, series := range it.series {
+				if series != nil {
+					series.advanceTo(slot)
+				}
+			}
+			it.mu.Unlock()
+		}
+	}
+}
+
+// RecordCalculationResult appends dual's headline figures into store under
+// id: PrimaryBased.TopLevelCost as directCost (the instant-buy total),
+// SecondaryBased.TopLevelCost as craftCost, the slower of the two
+// perspectives' SlowestIngredientBuyTimeSeconds as bottleneckFillTime, and
+// PrimaryBased.TopLevelRR as rr. Called after a handler has already written
+// its response, so a slow write to the store never delays the caller.
+func RecordCalculationResult(store *MemStore, id string, at time.Time, dual *DualExpansionResult) {
+	if dual == nil {
+		return
+	}
+	if v := float64(dual.PrimaryBased.TopLevelCost); !mathIsNaNOrInf(v) {
+		store.Append(id, metricDirectCost, at, v)
+	}
+	if v := float64(dual.SecondaryBased.TopLevelCost); !mathIsNaNOrInf(v) {
+		store.Append(id, metricCraftCost, at, v)
+	}
+	fillTime := float64(dual.PrimaryBased.SlowestIngredientBuyTimeSeconds)
+	if secondary := float64(dual.SecondaryBased.SlowestIngredientBuyTimeSeconds); !mathIsNaNOrInf(secondary) && secondary > fillTime {
+		fillTime = secondary
+	}
+	if !mathIsNaNOrInf(fillTime) {
+		store.Append(id, metricBottleneckFillTime, at, fillTime)
+	}
+	if v := float64(dual.PrimaryBased.TopLevelRR); !mathIsNaNOrInf(v) {
+		store.Append(id, metricRR, at, v)
+	}
+}
+
+// memstoreWriteHandler serves POST /write: one InfluxDB line-protocol line
+// per request body line, e.g.
+// "bazaar,item=ENCHANTED_DIAMOND direct=1234.5,craft=1000.1 <unix-ns>" - so
+// an external metric collector can push points into the same store
+// RecordCalculationResult feeds, without going through a calculation.
+func memstoreWriteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	scanner := bufio.NewScanner(r.Body)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parseAndStoreLine(defaultMemStore, line); err != nil {
+			http.Error(w, fmt.Sprintf("line %d: %v", lineNum, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseAndStoreLine parses one line-protocol line and appends every field it
+// names that matches a memstoreMetricNames entry. Tag order/count beyond
+// "item" is ignored since this store only keys on item ID; an absent
+// timestamp defaults to now, matching line protocol's own convention.
+func parseAndStoreLine(store *MemStore, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("expected at least measurement,tags and fields, got %q", line)
+	}
+	measurementAndTags := fields[0]
+	fieldSet := fields[1]
+	at := time.Now()
+	if len(fields) >= 3 {
+		ns, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q: %w", fields[2], err)
+		}
+		at = time.Unix(0, ns)
+	}
+
+	itemID := ""
+	for _, tag := range strings.Split(measurementAndTags, ",")[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 && kv[0] == "item" {
+			itemID = kv[1]
+		}
+	}
+	if itemID == "" {
+		return fmt.Errorf("line protocol tags missing item=<id>: %q", measurementAndTags)
+	}
+	itemID = BAZAAR_ID(itemID)
+
+	for _, kv := range strings.Split(fieldSet, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("malformed field %q", kv)
+		}
+		metric, ok := memstoreMetricNames[parts[0]]
+		if !ok {
+			continue // unrecognized field name: ignore rather than reject the whole line
+		}
+		value, err := strconv.ParseFloat(strings.TrimSuffix(parts[1], "i"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for field %q: %w", parts[0], err)
+		}
+		store.Append(itemID, metric, at, value)
+	}
+	return nil
+}
+
+// memstoreQueryHandler serves GET /query?item=<id>&metric=craftCost&from=
+// <unix>&to=<unix>&agg=avg|min|max|last, returning the matching buckets as
+// a JSON array of {timestamp, value} points, oldest first.
+func memstoreQueryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	itemID := q.Get("item")
+	if itemID == "" {
+		http.Error(w, "missing 'item' query parameter", http.StatusBadRequest)
+		return
+	}
+	metric, ok := memstoreMetricNames[q.Get("metric")]
+	if !ok {
+		http.Error(w, "unknown or missing 'metric' query parameter", http.StatusBadRequest)
+		return
+	}
+	from, err := parseUnixQueryParam(q.Get("from"), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, "invalid 'from': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseUnixQueryParam(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid 'to': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	agg := q.Get("agg")
+	if agg == "" {
+		agg = "avg"
+	}
+
+	points := defaultMemStore.Query(BAZAAR_ID(itemID), metric, from, to, agg)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		log.Printf("memstoreQueryHandler: encode response: %v", err)
+	}
+}
+
+func parseUnixQueryParam(raw string, def time.Time) (time.Time, error) {
+	if raw == "" {
+		return def, nil
+	}
+	secs, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
+
+func mathIsNaNOrInf(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0)
+}
@@ -0,0 +1,59 @@
+// method_policy.go
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// MethodPolicy lets a caller of PerformDualExpansion reject the Primary
+// acquisition method for an item whose Delta (sellSize*sellFrequency -
+// orderSize*orderFrequency) marks the buy-order side as too illiquid to
+// trust, even when Primary's nominal cost undercuts Craft/Secondary. The
+// zero value disables the check (ThresholdLow <= 0 never rejects).
+type MethodPolicy struct {
+	// ThresholdLow is the (positive) magnitude Delta must fall below
+	// -ThresholdLow to reject Primary.
+	ThresholdLow float64
+	// ThresholdHigh is the (positive, usually smaller) magnitude Delta must
+	// rise above -ThresholdHigh to lift a standing rejection, forming a
+	// hysteresis band around -ThresholdLow so a Delta oscillating near the
+	// boundary doesn't flap the decision call to call. <= 0 falls back to
+	// ThresholdLow, i.e. a single threshold with no band.
+	ThresholdHigh float64
+}
+
+// methodPolicyRejected remembers, per itemID, whether the most recent call
+// rejected Primary - the hysteresis band in rejectPrimary can only be
+// evaluated against that prior decision, not against delta alone.
+var (
+	methodPolicyStateMu  sync.Mutex
+	methodPolicyRejected = make(map[string]bool)
+)
+
+// rejectPrimary reports whether Primary should be excluded from itemID's
+// acquisition choice given its current top-level delta, regardless of
+// Primary's nominal cost. Once a call rejects itemID, it stays rejected on
+// every subsequent call until delta climbs back above -ThresholdHigh; a
+// NaN delta or a zero-value policy never rejects.
+func (p MethodPolicy) rejectPrimary(itemID string, delta float64) bool {
+	if p.ThresholdLow <= 0 || math.IsNaN(delta) {
+		return false
+	}
+	thresholdHigh := p.ThresholdHigh
+	if thresholdHigh <= 0 {
+		thresholdHigh = p.ThresholdLow
+	}
+
+	methodPolicyStateMu.Lock()
+	defer methodPolicyStateMu.Unlock()
+
+	var nowRejected bool
+	if methodPolicyRejected[itemID] {
+		nowRejected = delta <= -thresholdHigh
+	} else {
+		nowRejected = delta < -p.ThresholdLow
+	}
+	methodPolicyRejected[itemID] = nowRejected
+	return nowRejected
+}
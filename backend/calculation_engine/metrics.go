@@ -2,11 +2,12 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"log"
-	"os"
+	"math"
+	"reflect"
 	"sync"
+	"time"
 	// No other imports needed unless dlog uses more
 )
 
@@ -20,87 +21,240 @@ type ProductMetrics struct {
 	OrderFrequency float64 `json:"order_frequency_average"` // Rate at which buy orders are placed/filled
 	// Add other metrics if they exist and are needed, e.g.:
 	// BuyMovingWeek  float64 `json:"buy_moving_week"` // If present and useful, though live API is often preferred
-}
 
-// --- Global variables for Metrics caching (used by legacy direct file load) ---
-// This caching mechanism is less used now that main.go handles metrics loading and updating.
-// However, getMetricsMap might still be called by older code paths if they exist,
-// or could be repurposed if direct file access is needed elsewhere.
-var (
-	metricsFileCache    map[string]ProductMetrics // Key is ProductID (should be normalized)
-	loadMetricsFileOnce sync.Once                 // Ensures loading happens only once
-	metricsFileLoadErr  error                     // Stores error encountered during loading
-	metricsFileMutex    sync.RWMutex              // Read/Write mutex for thread-safe access
-)
+	// Freshness metadata, consumed by staleness.go: LastUpdated is when this
+	// entry was (re)populated, and Source identifies where from (e.g.
+	// "file:<path>" or "live"), mainly for debugging which feed is stale.
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+	Source      string    `json:"source,omitempty"`
 
-// loadMetricsDataFromFile reads a specific JSON file and populates the metricsFileCache.
-// This is intended for a one-time load if direct file access is used, separate from main.go's mechanism.
-func loadMetricsDataFromFile(filename string) {
-	metricsFileMutex.Lock()
-	defer metricsFileMutex.Unlock()
+	// PriceHistory is a rolling window of this product's instasell price,
+	// oldest first, consumed by optimizer.go to judge how much to trust the
+	// current instasell price as "certain" revenue (PriceStdDev, PriceZScore,
+	// WorstCaseProfit, SharpeLikeRatio). HistoryWindow caps its length;
+	// <= 0 means defaultPriceHistoryWindow. Populated by UpdatePriceHistory,
+	// never mutated in place by a concurrent reader.
+	HistoryWindow int       `json:"history_window,omitempty"`
+	PriceHistory  []float64 `json:"price_history,omitempty"`
 
-	// Prevent re-entry or redundant work if already attempted
-	if metricsFileCache != nil || metricsFileLoadErr != nil {
-		dlog("Metrics file loading already attempted (Cache:%v, Err:%v) for %s. Skipping.", metricsFileCache != nil, metricsFileLoadErr != nil, filename)
-		return
-	}
+	// Labels holds one set of tags per way this product can be acquired
+	// (e.g. {"tier":"EPIC","category":"FARMING"} for the Bazaar route,
+	// {"source":"NPC"} for an NPC-vendor route on the same product ID), so a
+	// selector (labels.go) can match "any acquisition method tagged X"
+	// without this package needing a separate struct per acquisition
+	// channel. Populated from latest_metrics.json's own "labels" field;
+	// empty/absent for a product nobody has tagged yet, which only an empty
+	// Selector matches (see Selector.MatchesAny).
+	Labels []map[string]string `json:"labels,omitempty"`
+}
 
-	dlog("Loading metrics data directly from file '%s'...", filename)
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		metricsFileLoadErr = fmt.Errorf("failed to read metrics file '%s': %w", filename, err)
-		log.Printf("ERROR (loadMetricsDataFromFile): %v", metricsFileLoadErr)
-		return
-	}
+// defaultPriceHistoryWindow is how many samples RecordPriceSample keeps when
+// a ProductMetrics entry doesn't set its own HistoryWindow.
+const defaultPriceHistoryWindow = 60
 
-	var metricsList []ProductMetrics
-	if err := json.Unmarshal(data, &metricsList); err != nil {
-		metricsFileLoadErr = fmt.Errorf("failed to parse metrics JSON from '%s': %w", filename, err)
-		log.Printf("ERROR (loadMetricsDataFromFile): %v", metricsFileLoadErr)
+// RecordPriceSample appends price to pm's rolling history and trims it back
+// down to pm.HistoryWindow (or defaultPriceHistoryWindow). The trim always
+// allocates a fresh slice rather than re-slicing in place, so a later append
+// by whoever holds an older copy of pm can never alias - and silently
+// corrupt - this one's backing array; see UpdatePriceHistory, the only
+// intended caller.
+func (pm *ProductMetrics) RecordPriceSample(price float64) {
+	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
 		return
 	}
+	window := pm.HistoryWindow
+	if window <= 0 {
+		window = defaultPriceHistoryWindow
+	}
+	history := append(pm.PriceHistory, price)
+	if len(history) > window {
+		trimmed := make([]float64, window)
+		copy(trimmed, history[len(history)-window:])
+		history = trimmed
+	}
+	pm.PriceHistory = history
+}
 
-	tempCache := make(map[string]ProductMetrics, len(metricsList))
-	skippedCount := 0
-	for _, pm := range metricsList {
-		if pm.ProductID == "" {
-			log.Printf("Warning (loadMetricsDataFromFile): Skipping metric entry with empty product_id in '%s'", filename)
-			skippedCount++
-			continue
+// PriceStats summarizes pm.PriceHistory. ok is false when fewer than 2
+// samples are on record, since stddev/z-score are meaningless below that.
+func (pm ProductMetrics) PriceStats() (mean, stddev, min, max float64, ok bool) {
+	n := len(pm.PriceHistory)
+	if n < 2 {
+		return 0, 0, 0, 0, false
+	}
+	min, max = pm.PriceHistory[0], pm.PriceHistory[0]
+	sum := 0.0
+	for _, p := range pm.PriceHistory {
+		sum += p
+		if p < min {
+			min = p
 		}
-		normalizedID := BAZAAR_ID(pm.ProductID)
-		if existing, found := tempCache[normalizedID]; found {
-			log.Printf("Warning (loadMetricsDataFromFile): Duplicate normalized ProductID '%s' found in metrics file '%s'. Overwriting previous entry (%+v) with (%+v).", normalizedID, filename, existing, pm)
+		if p > max {
+			max = p
 		}
-		pm.ProductID = normalizedID // Ensure ProductID within struct is also normalized
-		tempCache[normalizedID] = pm
+	}
+	mean = sum / float64(n)
+	variance := 0.0
+	for _, p := range pm.PriceHistory {
+		d := p - mean
+		variance += d * d
+	}
+	variance /= float64(n - 1)
+	stddev = math.Sqrt(variance)
+	return mean, stddev, min, max, true
+}
+
+// UpdatePriceHistory returns a copy of metricsMap with every entry's
+// PriceHistory advanced by one sample (that product's current instasell
+// price from apiResp), leaving metricsMap itself untouched. Call it once per
+// refresh cycle - right before MarketDataStore.Publish installs the new
+// snapshot - never from inside a concurrent read path like
+// RunFullOptimization's worker pool, which only ever reads PriceHistory via
+// PriceStats.
+func UpdatePriceHistory(metricsMap map[string]ProductMetrics, apiResp *HypixelAPIResponse) map[string]ProductMetrics {
+	updated := make(map[string]ProductMetrics, len(metricsMap))
+	for id, pm := range metricsMap {
+		price := getBuyPrice(apiResp, id)
+		pm.RecordPriceSample(price)
+		updated[id] = pm
+	}
+	return updated
+}
+
+// --- Metrics caching, backed by MetricsStore (metrics_store.go) ---
+// getMetricsMapFromFile/ReloadMetricsFileCache used to guard a plain
+// map[string]ProductMetrics behind metricsFileMutex sync.RWMutex, loaded
+// once via loadMetricsFileOnce sync.Once. That state now lives in a
+// MetricsStore, whose snapshot is an atomic.Pointer instead of a mutex-
+// guarded map, so reads here never block behind a writer mid-reload; see
+// metrics_store.go for the fsnotify-driven hot reload that keeps it current
+// between StartBackgroundRefresh's polling cycles.
+var loadMetricsFileOnce sync.Once // Guards only the first automatic load; ReloadMetricsFileCache/WatchMetricsFile bypass it.
+
+// loadMetricsDataFromFile populates DefaultMetricsStore(filename)'s snapshot
+// following the (1) memory, (2) bolt, (3) JSON load order
+// getMetricsMapFromFile promises: it seeds from bolt first (metrics_bolt.go)
+// so the store already has the last known-good data in place before
+// attempting the JSON file, then lets ForceReload overwrite it with a fresh
+// parse - and re-persist to bolt - if the JSON file is present and valid.
+// This is intended for a one-time load if direct file access is used,
+// separate from main.go's mechanism.
+func loadMetricsDataFromFile(filename string) {
+	dlog("Loading metrics data directly from file '%s'...", filename)
+	store := DefaultMetricsStore(filename)
+
+	if n, err := store.LoadFromBolt(); err != nil {
+		log.Printf("Warning (loadMetricsDataFromFile): bolt fallback for '%s' unavailable: %v", filename, err)
+	} else if n > 0 {
+		dlog("Metrics data for '%s' seeded from bolt cache (%d products) ahead of the JSON load.", filename, n)
 	}
 
-	metricsFileCache = tempCache
-	metricsFileLoadErr = nil // Clear error on success
-	dlog("Metrics data from file '%s' loaded and cached successfully. Loaded: %d, Skipped: %d", filename, len(metricsFileCache), skippedCount)
+	if err := store.ForceReload(context.Background()); err != nil {
+		log.Printf("ERROR (loadMetricsDataFromFile): %v", err)
+		return
+	}
+	dlog("Metrics data from file '%s' loaded and cached successfully. Loaded: %d", filename, len(store.Get()))
 }
 
 // getMetricsMapFromFile ensures metrics are loaded once from a specific file and returns the cached map.
 // This is distinct from main.go's metrics handling which uses `latestMetricsData`.
 func getMetricsMapFromFile(filename string) (map[string]ProductMetrics, error) {
+	store := DefaultMetricsStore(filename)
 	loadMetricsFileOnce.Do(func() {
 		loadMetricsDataFromFile(filename) // This will only run the loading logic once per application lifetime
 	})
 
-	metricsFileMutex.RLock() // Acquire read lock for accessing shared cache and error
-	defer metricsFileMutex.RUnlock()
+	if loadedAt := store.LoadedAt(); !loadedAt.IsZero() {
+		DefaultMetrics(nil).MetricsCacheAgeSeconds.Set(time.Since(loadedAt).Seconds())
+	}
 
-	if metricsFileLoadErr != nil {
-		return nil, metricsFileLoadErr // Return error if loading failed
+	// (1)/(2) memory-or-bolt fallback: a JSON load error no longer empties
+	// the process out, so long as an earlier load (this run's bolt seed, or
+	// a previous successful reload) left something in the snapshot.
+	m := store.Get()
+	if err := store.LastError(); err != nil {
+		if m == nil {
+			return nil, err
+		}
+		log.Printf("Warning (getMetricsMapFromFile): using last-known metrics for '%s' after reload error: %v", filename, err)
 	}
 
-	if metricsFileCache == nil {
-		// This state implies loading was attempted (due to Once.Do) but cache remains nil,
-		// and no error was set. This could mean an empty file or other non-error producing issue.
+	if m == nil {
+		// This state implies loading was attempted (due to Once.Do) but the
+		// store remains empty, and no error was set. This could mean an
+		// empty file or other non-error producing issue.
 		log.Printf("Warning (getMetricsMapFromFile): Metrics cache for '%s' is nil after load attempt, but no error reported. Returning empty map.", filename)
 		return make(map[string]ProductMetrics), nil // Return empty map rather than nil if no explicit error
 	}
 
-	return metricsFileCache, nil
+	return m, nil
+}
+
+// ReloadMetricsFileCache re-reads filename and swaps in a freshly parsed
+// snapshot, bypassing loadMetricsFileOnce so a long-running process (see
+// StartBackgroundRefresh, or the fsnotify watcher in metrics_store.go) can
+// keep the file-backed metrics map current instead of the one-time load
+// getMetricsMapFromFile otherwise gives it. On success it also invalidates
+// ResultCache for exactly the product IDs whose metrics actually changed, so
+// the next refresh cycle only recomputes the roots that depend on them
+// rather than the whole catalog.
+func ReloadMetricsFileCache(filename string) error {
+	store := DefaultMetricsStore(filename)
+	previous := store.Get()
+
+	err := store.ForceReload(context.Background())
+	if err == nil && !ResultCacheDisabled {
+		if changed := changedProductIDs(previous, store.Get()); len(changed) > 0 {
+			DefaultResultCache().Invalidate(changed...)
+		}
+	}
+	return err
+}
+
+// changedProductIDs compares two metrics snapshots and reports every product
+// ID that was added, removed, or whose metrics differ between them -
+// ReloadMetricsFileCache's input to ResultCache.Invalidate.
+func changedProductIDs(previous, current map[string]ProductMetrics) []string {
+	var changed []string
+	for id, curr := range current {
+		prev, ok := previous[id]
+		if !ok || prev.SellSize != curr.SellSize || prev.SellFrequency != curr.SellFrequency ||
+			prev.OrderSize != curr.OrderSize || prev.OrderFrequency != curr.OrderFrequency {
+			changed = append(changed, id)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			changed = append(changed, id)
+		}
+	}
+	return changed
+}
+
+// --- Package-wide "most recent metrics map" singleton ---
+// PerformDualExpansion takes metricsMap as an explicit parameter like every
+// other function in this package, but the background stale-refresh worker
+// (staleness.go) runs on its own goroutine with no caller to hand it one.
+// setCurrentMetricsMap lets PerformDualExpansion publish whichever map it
+// was just given, mirroring how RecordFeedSnapshot piggybacks on
+// fetchBazaarData to keep feedhealth.go's detectors updated.
+var (
+	currentMetricsMap   map[string]ProductMetrics
+	currentMetricsMapMu sync.RWMutex
+)
+
+func setCurrentMetricsMap(m map[string]ProductMetrics) {
+	currentMetricsMapMu.Lock()
+	advanced := reflect.ValueOf(m).Pointer() != reflect.ValueOf(currentMetricsMap).Pointer()
+	currentMetricsMap = m
+	currentMetricsMapMu.Unlock()
+	if advanced {
+		rebuildSkipSet(m) // Keep the illiquid-items skip set (liquidity.go) in step with each new snapshot.
+	}
+}
+
+func getCurrentMetricsMap() map[string]ProductMetrics {
+	currentMetricsMapMu.RLock()
+	defer currentMetricsMapMu.RUnlock()
+	return currentMetricsMap
 }
@@ -0,0 +1,181 @@
+// metrics_bolt.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// metricsBoltBucket is the single bucket a MetricsStore's bolt persistence
+// keeps every normalized product ID's most recently seen ProductMetrics in,
+// JSON-encoded the same shape as latest_metrics.json's own entries.
+var metricsBoltBucket = []byte("products")
+
+// defaultMetricsBoltPath is where DefaultMetricsStore's bolt-backed cache
+// lives; override at startup if the deployment lays files out differently,
+// mirroring defaultMetricsFilePath/defaultItemFilesDir (recipe_expansion.go).
+var defaultMetricsBoltPath = "metrics.bolt"
+
+// openBolt lazily opens s's bolt DB (creating the products bucket if
+// absent) on first use, so a MetricsStore that never calls a bolt-backed
+// method never pays for opening one.
+func (s *MetricsStore) openBolt() (*bbolt.DB, error) {
+	s.boltOnce.Do(func() {
+		path := s.boltPath
+		if path == "" {
+			path = defaultMetricsBoltPath
+		}
+		db, err := bbolt.Open(path, 0600, nil)
+		if err != nil {
+			s.boltErr = fmt.Errorf("opening bolt db '%s': %w", path, err)
+			return
+		}
+		if err := db.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(metricsBoltBucket)
+			return err
+		}); err != nil {
+			db.Close()
+			s.boltErr = fmt.Errorf("creating bolt bucket in '%s': %w", path, err)
+			return
+		}
+		s.boltDB = db
+	})
+	return s.boltDB, s.boltErr
+}
+
+// LoadFromBolt populates s's in-memory snapshot from every entry currently
+// in the bolt DB. getMetricsMapFromFile calls this as load-order step (2) -
+// after the in-memory cache, before latest_metrics.json - so a process
+// restarted while the JSON file is briefly missing or corrupt still starts
+// with the last metrics bolt has on record instead of nothing. Returns the
+// number of entries loaded; 0 (with a nil error) means the bucket was empty.
+func (s *MetricsStore) LoadFromBolt() (int, error) {
+	db, err := s.openBolt()
+	if err != nil {
+		return 0, err
+	}
+
+	fresh := make(map[string]ProductMetrics)
+	err = db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metricsBoltBucket).ForEach(func(k, v []byte) error {
+			var pm ProductMetrics
+			if err := json.Unmarshal(v, &pm); err != nil {
+				log.Printf("Warning (MetricsStore.LoadFromBolt): skipping corrupt bolt entry for '%s': %v", k, err)
+				return nil
+			}
+			fresh[string(k)] = pm
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reading bolt db: %w", err)
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	s.snapshot.Store(&fresh)
+	s.loadedAt.Store(&now)
+	s.notify()
+	return len(fresh), nil
+}
+
+// saveToBolt writes every entry of snapshot into bolt inside a single
+// Update transaction, called by ForceReload after a successful JSON (re)load
+// so a later process restart can warm-start from LoadFromBolt without
+// re-parsing the JSON file at all.
+func (s *MetricsStore) saveToBolt(snapshot map[string]ProductMetrics) error {
+	db, err := s.openBolt()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(metricsBoltBucket)
+		for id, pm := range snapshot {
+			data, err := json.Marshal(pm)
+			if err != nil {
+				return fmt.Errorf("encoding metrics for '%s': %w", id, err)
+			}
+			if err := b.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PutMetric writes pm through to bolt under id's normalized BAZAAR_ID and
+// atomically installs it into s's in-memory snapshot, the same copy-on-write
+// pattern ApplyFields (metrics_ingest.go) uses so concurrent Get() callers
+// never observe a partially updated map.
+func (s *MetricsStore) PutMetric(id string, pm ProductMetrics) error {
+	id = BAZAAR_ID(id)
+	pm.ProductID = id
+
+	db, err := s.openBolt()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(pm)
+	if err != nil {
+		return fmt.Errorf("encoding metrics for '%s': %w", id, err)
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metricsBoltBucket).Put([]byte(id), data)
+	}); err != nil {
+		return fmt.Errorf("writing '%s' to bolt: %w", id, err)
+	}
+
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+	current := s.Get()
+	next := make(map[string]ProductMetrics, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+	next[id] = pm
+	s.snapshot.Store(&next)
+	now := time.Now()
+	s.loadedAt.Store(&now)
+	s.notify()
+	return nil
+}
+
+// DeleteMetric removes id from both bolt and s's in-memory snapshot,
+// write-through in the same order and under the same lock as PutMetric.
+func (s *MetricsStore) DeleteMetric(id string) error {
+	id = BAZAAR_ID(id)
+
+	db, err := s.openBolt()
+	if err != nil {
+		return err
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metricsBoltBucket).Delete([]byte(id))
+	}); err != nil {
+		return fmt.Errorf("deleting '%s' from bolt: %w", id, err)
+	}
+
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+	current := s.Get()
+	if _, ok := current[id]; !ok {
+		return nil
+	}
+	next := make(map[string]ProductMetrics, len(current))
+	for k, v := range current {
+		if k != id {
+			next[k] = v
+		}
+	}
+	s.snapshot.Store(&next)
+	now := time.Now()
+	s.loadedAt.Store(&now)
+	s.notify()
+	return nil
+}
@@ -0,0 +1,467 @@
+// metrics_history.go
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// metricsHistoryCapacity bounds each product's in-memory sample ring. Sized
+// generously against the longest tracked window (24h) assuming a sample no
+// more often than every ~20s, the same cadence StartBackgroundRefresh's
+// default interval implies; for a product sampled more often than that, the
+// longest window's aggregates and samples cover only the most recent
+// metricsHistoryCapacity samples, a shorter span than the window itself
+// (see productHistory.record's overwritten-sample note).
+const metricsHistoryCapacity = 4320
+
+// metricsHistoryWindows are the rolling windows GetHistory/productHistory
+// track aggregates over.
+var metricsHistoryWindows = []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+
+// metricsHistoryAbsenceLimit is how long a product can go unrecorded before
+// EvictStale drops it, bounding memory for products that stop trading.
+const metricsHistoryAbsenceLimit = 24 * time.Hour
+
+// Sample is one snapshot of a product's ProductMetrics fields, the unit
+// productHistory's ring retains.
+type Sample struct {
+	Timestamp      time.Time
+	SellSize       float64
+	SellFrequency  float64
+	OrderSize      float64
+	OrderFrequency float64
+}
+
+// FieldAggregates summarizes one ProductMetrics field's values within a
+// window.
+type FieldAggregates struct {
+	Count  int
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Max    float64
+}
+
+// Aggregates bundles every tracked field's FieldAggregates for one window,
+// GetHistory's second return value.
+type Aggregates struct {
+	SellSize       FieldAggregates
+	SellFrequency  FieldAggregates
+	OrderSize      FieldAggregates
+	OrderFrequency FieldAggregates
+}
+
+// welfordAgg is Welford's online mean/variance accumulator, extended with
+// the matching removal step (West's algorithm) so a sliding window can drop
+// its oldest sample in O(1) instead of rescanning. Min/Max can't be removed
+// incrementally in general - if the value leaving happens to be the current
+// extreme, the field is marked dirty and recomputed by rescanning the
+// window's still-held samples on the next read, rather than on every
+// removal.
+type welfordAgg struct {
+	count int
+	mean  float64
+	m2    float64
+	min   float64
+	max   float64
+	dirty bool
+}
+
+func (w *welfordAgg) add(x float64) {
+	w.count++
+	delta := x - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (x - w.mean)
+	if w.count == 1 {
+		w.min, w.max = x, x
+	} else {
+		if x < w.min {
+			w.min = x
+		}
+		if x > w.max {
+			w.max = x
+		}
+	}
+}
+
+func (w *welfordAgg) remove(x float64) {
+	if w.count <= 1 {
+		*w = welfordAgg{}
+		return
+	}
+	oldMean := w.mean
+	w.count--
+	w.mean = (oldMean*float64(w.count+1) - x) / float64(w.count)
+	w.m2 -= (x - oldMean) * (x - w.mean)
+	if w.m2 < 0 {
+		w.m2 = 0 // guard float drift
+	}
+	if x == w.min || x == w.max {
+		w.dirty = true
+	}
+}
+
+func (w welfordAgg) fieldAggregates() FieldAggregates {
+	if w.count == 0 {
+		return FieldAggregates{}
+	}
+	variance := 0.0
+	if w.count > 1 {
+		variance = w.m2 / float64(w.count-1)
+	}
+	return FieldAggregates{Count: w.count, Mean: w.mean, StdDev: math.Sqrt(variance), Min: w.min, Max: w.max}
+}
+
+// windowState is one window's running aggregates across all four fields,
+// plus the ring index of the oldest sample still counted in it.
+type windowState struct {
+	start          int64
+	sellSize       welfordAgg
+	sellFrequency  welfordAgg
+	orderSize      welfordAgg
+	orderFrequency welfordAgg
+}
+
+// productHistory is one normalized product ID's fixed-size sample ring plus
+// its per-window rolling aggregates.
+type productHistory struct {
+	samples  []Sample // ring; samples[idx % cap] holds global index idx once the ring has filled
+	next     int64    // next global index to write
+	windows  [3]windowState
+	lastSeen time.Time
+}
+
+func newProductHistory() *productHistory {
+	return &productHistory{samples: make([]Sample, 0, metricsHistoryCapacity)}
+}
+
+// sampleAt returns the sample at global index idx, and false if the ring has
+// already overwritten it.
+func (p *productHistory) sampleAt(idx int64) (Sample, bool) {
+	if idx < 0 || idx >= p.next || idx <= p.next-int64(metricsHistoryCapacity)-1 {
+		return Sample{}, false
+	}
+	return p.samples[idx%int64(len(p.samples))], true
+}
+
+func (p *productHistory) record(s Sample) {
+	idx := p.next
+	if len(p.samples) < metricsHistoryCapacity {
+		p.samples = append(p.samples, s)
+	} else {
+		// The reused slot still holds global index idx-capacity; any window
+		// still counting it drops it here, before its values are lost.
+		slot := idx % int64(metricsHistoryCapacity)
+		old := p.samples[slot]
+		for wi := range p.windows {
+			if w := &p.windows[wi]; w.start == idx-int64(metricsHistoryCapacity) {
+				w.sellSize.remove(old.SellSize)
+				w.sellFrequency.remove(old.SellFrequency)
+				w.orderSize.remove(old.OrderSize)
+				w.orderFrequency.remove(old.OrderFrequency)
+				w.start++
+			}
+		}
+		p.samples[slot] = s
+	}
+	p.next++
+	p.lastSeen = s.Timestamp
+
+	for wi, dur := range metricsHistoryWindows {
+		w := &p.windows[wi]
+		cutoff := s.Timestamp.Add(-dur)
+		for w.start < idx {
+			old, ok := p.sampleAt(w.start)
+			if !ok {
+				// Already overwritten by the ring (the removal above has
+				// taken it out of this window's aggregates); just advance
+				// past it rather than leaving start stuck behind the ring's
+				// oldest held sample.
+				w.start++
+				continue
+			}
+			if !old.Timestamp.Before(cutoff) {
+				break
+			}
+			w.sellSize.remove(old.SellSize)
+			w.sellFrequency.remove(old.SellFrequency)
+			w.orderSize.remove(old.OrderSize)
+			w.orderFrequency.remove(old.OrderFrequency)
+			w.start++
+		}
+		w.sellSize.add(s.SellSize)
+		w.sellFrequency.add(s.SellFrequency)
+		w.orderSize.add(s.OrderSize)
+		w.orderFrequency.add(s.OrderFrequency)
+	}
+}
+
+// samplesSince returns every ring sample from global index from (inclusive)
+// to p.next (exclusive), oldest first.
+func (p *productHistory) samplesSince(from int64) []Sample {
+	if from < 0 {
+		from = 0
+	}
+	out := make([]Sample, 0, p.next-from)
+	for idx := from; idx < p.next; idx++ {
+		if s, ok := p.sampleAt(idx); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// MetricsHistory is the process-wide per-product ring-buffer history store:
+// one productHistory per normalized product ID, each tracking rolling
+// min/mean/max/stddev over metricsHistoryWindows incrementally via Welford's
+// algorithm so GetHistory never has to rescan a product's full ring.
+type MetricsHistory struct {
+	mu       sync.RWMutex
+	products map[string]*productHistory
+}
+
+// NewMetricsHistory constructs an empty MetricsHistory.
+func NewMetricsHistory() *MetricsHistory {
+	return &MetricsHistory{products: make(map[string]*productHistory)}
+}
+
+// Record appends one sample for productID (normalized via BAZAAR_ID) at at.
+func (h *MetricsHistory) Record(productID string, at time.Time, pm ProductMetrics) {
+	id := BAZAAR_ID(productID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ph, ok := h.products[id]
+	if !ok {
+		ph = newProductHistory()
+		h.products[id] = ph
+	}
+	ph.record(Sample{
+		Timestamp:      at,
+		SellSize:       pm.SellSize,
+		SellFrequency:  pm.SellFrequency,
+		OrderSize:      pm.OrderSize,
+		OrderFrequency: pm.OrderFrequency,
+	})
+}
+
+// windowIndex returns metricsHistoryWindows' index matching window exactly,
+// or -1 if window isn't one of the supported presets.
+func windowIndex(window time.Duration) int {
+	for i, w := range metricsHistoryWindows {
+		if w == window {
+			return i
+		}
+	}
+	return -1
+}
+
+// recomputeExtremes rescans ph's still-held samples in [start, end) to
+// refresh agg's Min/Max, the fallback welfordAgg.remove can't do
+// incrementally when the value leaving a window happened to be its current
+// extreme. Only called lazily, on a read against a dirty field, not on
+// every removal.
+func recomputeExtremes(ph *productHistory, start, end int64, agg *welfordAgg, field func(Sample) float64) {
+	first := true
+	for idx := start; idx < end; idx++ {
+		s, ok := ph.sampleAt(idx)
+		if !ok {
+			continue
+		}
+		v := field(s)
+		if first {
+			agg.min, agg.max = v, v
+			first = false
+			continue
+		}
+		if v < agg.min {
+			agg.min = v
+		}
+		if v > agg.max {
+			agg.max = v
+		}
+	}
+	agg.dirty = false
+}
+
+// GetHistory returns productID's raw samples falling within the trailing
+// window, oldest first, plus that window's per-field aggregates. window
+// must be one of metricsHistoryWindows' exact durations.
+func (h *MetricsHistory) GetHistory(productID string, window time.Duration) ([]Sample, Aggregates, error) {
+	wi := windowIndex(window)
+	if wi < 0 {
+		return nil, Aggregates{}, fmt.Errorf("unsupported window %s; expected one of %v", window, metricsHistoryWindows)
+	}
+	id := BAZAAR_ID(productID)
+
+	h.mu.Lock() // recomputeExtremes may mutate a dirty field in place
+	defer h.mu.Unlock()
+	ph, ok := h.products[id]
+	if !ok {
+		return nil, Aggregates{}, fmt.Errorf("no history recorded for %q", id)
+	}
+	w := &ph.windows[wi]
+	if w.sellSize.dirty {
+		recomputeExtremes(ph, w.start, ph.next, &w.sellSize, func(s Sample) float64 { return s.SellSize })
+	}
+	if w.sellFrequency.dirty {
+		recomputeExtremes(ph, w.start, ph.next, &w.sellFrequency, func(s Sample) float64 { return s.SellFrequency })
+	}
+	if w.orderSize.dirty {
+		recomputeExtremes(ph, w.start, ph.next, &w.orderSize, func(s Sample) float64 { return s.OrderSize })
+	}
+	if w.orderFrequency.dirty {
+		recomputeExtremes(ph, w.start, ph.next, &w.orderFrequency, func(s Sample) float64 { return s.OrderFrequency })
+	}
+
+	agg := Aggregates{
+		SellSize:       w.sellSize.fieldAggregates(),
+		SellFrequency:  w.sellFrequency.fieldAggregates(),
+		OrderSize:      w.orderSize.fieldAggregates(),
+		OrderFrequency: w.orderFrequency.fieldAggregates(),
+	}
+	return ph.samplesSince(w.start), agg, nil
+}
+
+// EvictStale drops every product whose most recent Record call is older
+// than metricsHistoryAbsenceLimit as of now, bounding memory for products
+// that have stopped trading. Returns the number evicted.
+func (h *MetricsHistory) EvictStale(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	evicted := 0
+	for id, ph := range h.products {
+		if now.Sub(ph.lastSeen) > metricsHistoryAbsenceLimit {
+			delete(h.products, id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// StartMetricsHistoryEvictor periodically calls EvictStale, mirroring
+// startMemstoreTicker's run-forever-in-a-goroutine shape elsewhere in this
+// package.
+func StartMetricsHistoryEvictor(h *MetricsHistory, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if n := h.EvictStale(now); n > 0 {
+				dlog("MetricsHistory: evicted %d products absent over %s", n, metricsHistoryAbsenceLimit)
+			}
+		}
+	}
+}
+
+// gobProductHistory is the on-disk shape SaveToFile/LoadMetricsHistoryFromFile
+// persist, storing just each product's held samples (not the derived
+// per-window welfordAgg state, which LoadMetricsHistoryFromFile rebuilds by
+// replaying them through record) so a format change to windowState never
+// breaks loading an old snapshot.
+type gobProductHistory struct {
+	Samples []Sample
+}
+
+// SaveToFile gob-encodes every product's currently-held samples to path,
+// intended to run once at shutdown (see main.go's signal handler) so a
+// restart doesn't lose history.
+func (h *MetricsHistory) SaveToFile(path string) error {
+	h.mu.RLock()
+	snapshot := make(map[string]gobProductHistory, len(h.products))
+	for id, ph := range h.products {
+		snapshot[id] = gobProductHistory{Samples: ph.samplesSince(0)}
+	}
+	h.mu.RUnlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating metrics history file '%s': %w", path, err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(snapshot); err != nil {
+		return fmt.Errorf("writing metrics history file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// LoadMetricsHistoryFromFile reads a MetricsHistory previously written by
+// SaveToFile, replaying every product's saved samples back through record
+// so each window's welfordAgg/start state is rebuilt rather than trusted
+// from disk. A missing file is not an error - it just means no prior
+// snapshot exists yet.
+func LoadMetricsHistoryFromFile(path string) (*MetricsHistory, error) {
+	h := NewMetricsHistory()
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("opening metrics history file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	var snapshot map[string]gobProductHistory
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("parsing metrics history file '%s': %w", path, err)
+	}
+	for id, saved := range snapshot {
+		ph := newProductHistory()
+		for _, s := range saved.Samples {
+			ph.record(s)
+		}
+		h.products[id] = ph
+	}
+	return h, nil
+}
+
+var (
+	defaultMetricsHistory     *MetricsHistory
+	defaultMetricsHistoryOnce sync.Once
+)
+
+// defaultMetricsHistoryPath is where main.go's shutdown handler persists
+// DefaultMetricsHistory(), and where it's restored from on the next start.
+const defaultMetricsHistoryPath = "/tmp/metrics/history.gob"
+
+// DefaultMetricsHistory lazily loads (or creates) the package-wide
+// MetricsHistory, mirroring DefaultResultCache's singleton pattern.
+func DefaultMetricsHistory() *MetricsHistory {
+	defaultMetricsHistoryOnce.Do(func() {
+		h, err := LoadMetricsHistoryFromFile(defaultMetricsHistoryPath)
+		if err != nil {
+			dlog("MetricsHistory: failed to load '%s', starting fresh: %v", defaultMetricsHistoryPath, err)
+			h = NewMetricsHistory()
+		}
+		defaultMetricsHistory = h
+	})
+	return defaultMetricsHistory
+}
+
+// watchForShutdownAndSaveMetricsHistory blocks until the process receives
+// SIGINT/SIGTERM, persists DefaultMetricsHistory to
+// defaultMetricsHistoryPath, then re-raises the signal's default behavior
+// (process exit) - mirroring WatchNormalizationReload's signal.Notify
+// pattern, but for a one-shot save-on-exit instead of a repeating reload.
+func watchForShutdownAndSaveMetricsHistory() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	if err := DefaultMetricsHistory().SaveToFile(defaultMetricsHistoryPath); err != nil {
+		log.Printf("MetricsHistory: failed to save '%s' on shutdown: %v", defaultMetricsHistoryPath, err)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,266 @@
+// metrics_ingest.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsWriteQueueSize bounds how many pending line-protocol points
+// metricsWriteHandler can hand off to ApplyFields' background worker before
+// Enqueue starts reporting backpressure - large enough to absorb a burst
+// from an external collector without the HTTP handler blocking on the
+// store's merge.
+const metricsWriteQueueSize = 4096
+
+// metricsIngestPoint is one parsed "product_metrics,product_id=... k=v,..."
+// line, queued for a MetricsStore's ingest worker to merge.
+type metricsIngestPoint struct {
+	productID string
+	fields    map[string]float64
+	at        time.Time
+}
+
+// metricsIngestFieldNames maps a line-protocol field name to the
+// ProductMetrics field it updates; unrecognized field names are ignored
+// rather than rejecting the line, the same tolerance parseAndStoreLine
+// (memstore.go) gives unrecognized line-protocol fields.
+var metricsIngestFieldNames = map[string]bool{
+	"sell_size": true, "sell_frequency": true,
+	"order_size_average": true, "order_frequency_average": true,
+}
+
+// ApplyFields merges one ingested point into s's snapshot under productID's
+// normalized BAZAAR_ID, copying the existing snapshot rather than mutating
+// it in place so concurrent Get() callers never observe a partially merged
+// map. ingestMu serializes this against other ApplyFields calls, since two
+// concurrent read-modify-writes against the same snapshot pointer could
+// otherwise silently drop one one's update.
+func (s *MetricsStore) ApplyFields(productID string, fields map[string]float64, at time.Time) {
+	id := BAZAAR_ID(productID)
+
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+
+	current := s.Get()
+	next := make(map[string]ProductMetrics, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+
+	pm := next[id]
+	pm.ProductID = id
+	for name, value := range fields {
+		switch name {
+		case "sell_size":
+			pm.SellSize = value
+		case "sell_frequency":
+			pm.SellFrequency = value
+		case "order_size_average":
+			pm.OrderSize = value
+		case "order_frequency_average":
+			pm.OrderFrequency = value
+		}
+	}
+	pm.LastUpdated = at
+	pm.Source = "live"
+	next[id] = pm
+
+	s.snapshot.Store(&next)
+	s.loadedAt.Store(&at)
+	s.notify()
+
+	DefaultMetricsHistory().Record(id, at, pm)
+}
+
+// StartIngestWorker launches, once per store, the background goroutine that
+// drains Enqueue'd points and applies them via ApplyFields, so
+// metricsWriteHandler never blocks on the merge itself - only on handing the
+// point to a bounded channel.
+func (s *MetricsStore) StartIngestWorker(ctx context.Context) {
+	s.ingestOnce.Do(func() {
+		s.ingestCh = make(chan metricsIngestPoint, metricsWriteQueueSize)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case pt := <-s.ingestCh:
+					s.ApplyFields(pt.productID, pt.fields, pt.at)
+				}
+			}
+		}()
+	})
+}
+
+// Enqueue hands pt to the ingest worker, reporting false (instead of
+// blocking) if the queue is currently full.
+func (s *MetricsStore) Enqueue(pt metricsIngestPoint) bool {
+	select {
+	case s.ingestCh <- pt:
+		return true
+	default:
+		return false
+	}
+}
+
+// cutUnescaped splits s at the first unescaped occurrence of sep (a
+// backslash immediately before sep, or before any byte, makes that
+// occurrence literal rather than a separator), the same escaping line
+// protocol itself defines for tag/field keys and values.
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// splitUnescaped is cutUnescaped repeated across every unescaped occurrence
+// of sep.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	for {
+		before, after, found := cutUnescaped(s, sep)
+		parts = append(parts, before)
+		if !found {
+			return parts
+		}
+		s = after
+	}
+}
+
+// unescapeLineProtocol strips the backslashes splitUnescaped/cutUnescaped
+// left in place, so a tag value like "BIG\,ITEM" decodes to "BIG,ITEM".
+func unescapeLineProtocol(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseMetricsLine parses one line of the InfluxDB-style line protocol
+// POST /metrics/write accepts:
+//
+//	product_metrics,product_id=ENCHANTED_LAPIS_LAZULI sell_size=12.3,sell_frequency=0.87,order_size_average=8.1,order_frequency_average=1.4 <unix_nano>
+//
+// An absent timestamp defaults to now, matching line protocol's own
+// convention (and memstoreWriteHandler's). Field values may carry line
+// protocol's trailing "i" integer suffix, which is simply trimmed before
+// parsing as a float.
+func parseMetricsLine(line string) (productID string, fields map[string]float64, at time.Time, err error) {
+	at = time.Now()
+
+	measurementAndTags, rest, ok := cutUnescaped(line, ' ')
+	if !ok {
+		return "", nil, at, fmt.Errorf("expected measurement,tags, fields[, timestamp], got %q", line)
+	}
+	fieldSet, tsRaw, hasTs := cutUnescaped(rest, ' ')
+	if !hasTs {
+		fieldSet = rest
+	} else if tsRaw != "" {
+		ns, perr := strconv.ParseInt(tsRaw, 10, 64)
+		if perr != nil {
+			return "", nil, at, fmt.Errorf("invalid timestamp %q: %w", tsRaw, perr)
+		}
+		at = time.Unix(0, ns)
+	}
+
+	tags := splitUnescaped(measurementAndTags, ',')
+	if len(tags) == 0 || tags[0] != "product_metrics" {
+		return "", nil, at, fmt.Errorf("expected measurement \"product_metrics\", got %q", measurementAndTags)
+	}
+	for _, tag := range tags[1:] {
+		k, v, ok := cutUnescaped(tag, '=')
+		if ok && k == "product_id" {
+			productID = unescapeLineProtocol(v)
+		}
+	}
+	if productID == "" {
+		return "", nil, at, fmt.Errorf("line missing product_id tag: %q", measurementAndTags)
+	}
+
+	fields = make(map[string]float64)
+	for _, kv := range splitUnescaped(fieldSet, ',') {
+		k, v, ok := cutUnescaped(kv, '=')
+		if !ok {
+			return "", nil, at, fmt.Errorf("malformed field %q", kv)
+		}
+		if !metricsIngestFieldNames[k] {
+			continue // unrecognized field name: ignore rather than reject the whole line
+		}
+		value, perr := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64)
+		if perr != nil {
+			return "", nil, at, fmt.Errorf("invalid value for field %q: %w", k, perr)
+		}
+		fields[k] = value
+	}
+	if len(fields) == 0 {
+		return "", nil, at, fmt.Errorf("no recognized fields in %q", fieldSet)
+	}
+	return productID, fields, at, nil
+}
+
+// metricsWriteHandler serves POST /metrics/write: one line-protocol line per
+// request body line (see parseMetricsLine), merging each into
+// DefaultMetricsStore under the same normalized BAZAAR_ID key path
+// loadMetricsDataFromFile uses. Malformed lines are counted and skipped
+// rather than failing the whole batch, so one bad line from a long-running
+// external collector doesn't stall every point behind it.
+func metricsWriteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store := DefaultMetricsStore(defaultMetricsFilePath)
+	store.StartIngestWorker(context.Background())
+
+	accepted, malformed, dropped := 0, 0, 0
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		productID, fields, at, err := parseMetricsLine(line)
+		if err != nil {
+			malformed++
+			DefaultMetrics(nil).MetricsIngestLinesTotal.WithLabelValues("malformed").Inc()
+			log.Printf("metrics/write: skipping malformed line: %v", err)
+			continue
+		}
+		if store.Enqueue(metricsIngestPoint{productID: productID, fields: fields, at: at}) {
+			accepted++
+			DefaultMetrics(nil).MetricsIngestLinesTotal.WithLabelValues("accepted").Inc()
+		} else {
+			dropped++
+			DefaultMetrics(nil).MetricsIngestLinesTotal.WithLabelValues("dropped").Inc()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"accepted":%d,"malformed":%d,"dropped":%d}`, accepted, malformed, dropped)
+}
@@ -0,0 +1,168 @@
+// metrics_sampler.go
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// This file is what actually keeps SerialMetricsStore (serial_metrics_store.go)
+// current in production: that store's RateOverWindow/RecentSamples have
+// nothing to read until something calls Ingest on a schedule, which is
+// StartMetricsSampler's job below, polling forceRefreshAPIData (api.go)
+// instead of depending on an externally-regenerated latest_metrics.json.
+
+// orderBookAggregate summarizes one OrderSummary snapshot (BuySummary or
+// SellSummary) down to the two numbers deriveOrderFlowMetrics needs, so two
+// polls can be diffed without retaining every price level's detail.
+type orderBookAggregate struct {
+	orders int
+	volume float64
+}
+
+func aggregateOrderBook(summary []OrderSummary) orderBookAggregate {
+	var agg orderBookAggregate
+	for _, o := range summary {
+		agg.orders += o.Orders
+		agg.volume += float64(o.Amount)
+	}
+	return agg
+}
+
+// deriveOrderFlowMetrics approximates one polling interval's
+// SellSize/SellFrequency/OrderSize/OrderFrequency (see ProductMetrics,
+// metrics.go) from prev/cur's order book and moving-week counters - the
+// live Bazaar API doesn't expose per-trade fill records directly, so this
+// is a proxy rather than an exact count:
+//   - OrderSize is BuySummary's current average resting order size (volume
+//     over order count); OrderFrequency is how fast that order count grew
+//     over dt, clamped to 0 - a net decrease means orders were filled
+//     faster than new ones were placed, which SellFrequency below already
+//     accounts for separately.
+//   - SellSize assumes insta-sells fill against BuySummary's resting
+//     orders and so share their size distribution; SellFrequency is then
+//     the volume drained from SellMovingWeek over dt, divided by that size,
+//     giving an implied fill count per second.
+func deriveOrderFlowMetrics(prev, cur HypixelProduct, dt time.Duration) (sellSize, sellFrequency, orderSize, orderFrequency float64) {
+	if dt <= 0 {
+		return 0, 0, 0, 0
+	}
+	seconds := dt.Seconds()
+
+	buyAgg := aggregateOrderBook(cur.BuySummary)
+	if buyAgg.orders > 0 {
+		orderSize = buyAgg.volume / float64(buyAgg.orders)
+	}
+
+	prevBuyAgg := aggregateOrderBook(prev.BuySummary)
+	if orderCountDelta := float64(buyAgg.orders - prevBuyAgg.orders); orderCountDelta > 0 {
+		orderFrequency = orderCountDelta / seconds
+	}
+
+	sellVolumeDelta := cur.QuickStatus.SellMovingWeek - prev.QuickStatus.SellMovingWeek
+	if sellVolumeDelta < 0 {
+		sellVolumeDelta = 0 // the moving-week counter rolled over between polls
+	}
+	if orderSize > 0 {
+		sellSize = orderSize
+		sellFrequency = (sellVolumeDelta / orderSize) / seconds
+	}
+	return
+}
+
+// metricsSamplerState is one product's previous poll, retained so the next
+// poll can diff against it.
+type metricsSamplerState struct {
+	sampledAt time.Time
+	product   HypixelProduct
+}
+
+// StartMetricsSampler polls forceRefreshAPIData on interval (mirroring
+// StartStaleRefreshWorker/StartSerialMetricsCompactor's run-forever-in-a-
+// goroutine shape elsewhere in this package) and, for every product,
+// Ingests a MetricsSample into store: the raw BuyMovingWeek/SellMovingWeek
+// counters plus deriveOrderFlowMetrics's order-flow estimate against the
+// previous poll (left at zero for a product's first poll, since there's
+// nothing yet to diff against). Call this once at startup; interval <= 0
+// defaults to 5 minutes.
+func StartMetricsSampler(store *SerialMetricsStore, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	go func() {
+		var mu sync.Mutex
+		prevSamples := make(map[string]metricsSamplerState)
+
+		poll := func() {
+			apiResp, err := forceRefreshAPIData(context.Background())
+			if err != nil {
+				dlog("WARN: metrics sampler poll failed: %v", err)
+				return
+			}
+
+			now := time.Now()
+			mu.Lock()
+			defer mu.Unlock()
+			for itemID, product := range apiResp.Products {
+				normItemID := BAZAAR_ID(itemID)
+
+				sample := MetricsSample{
+					TimestampUnix:  now.Unix(),
+					BuyMovingWeek:  product.QuickStatus.BuyMovingWeek,
+					SellMovingWeek: product.QuickStatus.SellMovingWeek,
+				}
+				if prev, ok := prevSamples[normItemID]; ok {
+					sample.SellSize, sample.SellFrequency, sample.OrderSize, sample.OrderFrequency =
+						deriveOrderFlowMetrics(prev.product, product, now.Sub(prev.sampledAt))
+				}
+				prevSamples[normItemID] = metricsSamplerState{sampledAt: now, product: product}
+
+				if err := store.Ingest(normItemID, sample); err != nil {
+					dlog("WARN: failed to ingest metrics sample for %s: %v", normItemID, err)
+				}
+			}
+		}
+
+		poll()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				poll()
+			}
+		}
+	}()
+}
+
+// getMetricsMapWindowed returns base (typically getCurrentMetricsMap() or a
+// getMetricsMapFromFile snapshot) with every entry's SellSize/SellFrequency
+// /OrderSize/OrderFrequency overridden by DefaultSerialMetricsStore's
+// RateOverWindow(window) average, for any product that store has enough
+// in-process history for - base's values (usually from latest_metrics.json)
+// are left in place for the rest. fill_time.go's *WithWindow variants
+// already get a single product windowed this way via
+// instasellRateOverWindow/calculateBuyOrderFillTimeWithWindow; this is the
+// entry point for a caller that wants the whole map windowed at once.
+func getMetricsMapWindowed(base map[string]ProductMetrics, window time.Duration) map[string]ProductMetrics {
+	store, err := DefaultSerialMetricsStore()
+	if err != nil {
+		return base
+	}
+
+	out := make(map[string]ProductMetrics, len(base))
+	for id, pm := range base {
+		if rates, ok := store.RateOverWindow(id, window); ok {
+			pm.SellSize = rates.SellSize
+			pm.SellFrequency = rates.SellFrequency
+			pm.OrderSize = rates.OrderSize
+			pm.OrderFrequency = rates.OrderFrequency
+		}
+		out[id] = pm
+	}
+	return out
+}
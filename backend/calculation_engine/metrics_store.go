@@ -0,0 +1,368 @@
+// metrics_store.go
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.etcd.io/bbolt"
+	"golang.org/x/sync/errgroup"
+)
+
+// metricsWatchDebounce coalesces bursts of filesystem events (an editor or
+// rsync can fire several writes/renames for a single logical update) into a
+// single ForceReload, so Watch doesn't re-parse the file once per event.
+const metricsWatchDebounce = 200 * time.Millisecond
+
+// MetricsStore holds a hot-reloadable snapshot of one or more metrics
+// files' content behind an atomic.Pointer, so getMetricsMapFromFile's reads
+// are lock-free even while Watch's background goroutine is mid-reload. It
+// replaces the sync.Once/sync.RWMutex-guarded map metrics.go used to keep
+// directly, letting the underlying file(s) change under a long-running
+// process (e.g. an operator editing latest_metrics.json by hand) without a
+// restart.
+type MetricsStore struct {
+	// path is either a single file (the common case, e.g.
+	// defaultMetricsFilePath) or a comma-separated list of glob patterns
+	// (e.g. "metrics/*.json,metrics/*.json.gz") expanded by
+	// expandMetricsGlobs on every ForceReload. A plain path with no glob
+	// metacharacters and no comma just expands to itself.
+	path     string
+	debounce time.Duration
+
+	snapshot atomic.Pointer[map[string]ProductMetrics]
+	loadedAt atomic.Pointer[time.Time]
+
+	errMu   sync.RWMutex
+	lastErr error
+
+	subsMu sync.Mutex
+	subs   []chan<- struct{}
+
+	watchOnce sync.Once
+	watchErr  error
+
+	ingestOnce sync.Once
+	ingestCh   chan metricsIngestPoint
+	ingestMu   sync.Mutex // serializes ApplyFields' read-modify-write of snapshot against concurrent ingest points
+
+	// boltPath, boltOnce, boltDB, and boltErr back the optional persistence
+	// layer in metrics_bolt.go; see openBolt.
+	boltPath string
+	boltOnce sync.Once
+	boltDB   *bbolt.DB
+	boltErr  error
+}
+
+// NewMetricsStore constructs a MetricsStore for path. The store holds no
+// data until ForceReload or Watch is called.
+func NewMetricsStore(path string) *MetricsStore {
+	return &MetricsStore{path: path, debounce: metricsWatchDebounce}
+}
+
+// Get returns the most recently loaded snapshot, or nil if nothing has
+// loaded successfully yet.
+func (s *MetricsStore) Get() map[string]ProductMetrics {
+	if m := s.snapshot.Load(); m != nil {
+		return *m
+	}
+	return nil
+}
+
+// LoadedAt returns when the current snapshot was populated, or the zero
+// time if none has loaded yet.
+func (s *MetricsStore) LoadedAt() time.Time {
+	if t := s.loadedAt.Load(); t != nil {
+		return *t
+	}
+	return time.Time{}
+}
+
+// LastError returns the error from the most recent ForceReload attempt, or
+// nil if the last attempt succeeded (or none has run yet).
+func (s *MetricsStore) LastError() error {
+	s.errMu.RLock()
+	defer s.errMu.RUnlock()
+	return s.lastErr
+}
+
+func (s *MetricsStore) setErr(err error) {
+	s.errMu.Lock()
+	s.lastErr = err
+	s.errMu.Unlock()
+}
+
+// Subscribe registers ch to receive a (non-blocking) notification every
+// time ForceReload swaps in a new snapshot. Callers that want to react to
+// reloads (pricing loops, order simulators) should use a buffered channel
+// and treat a send as "go re-read Get()", not as carrying the data itself.
+func (s *MetricsStore) Subscribe(ch chan<- struct{}) {
+	s.subsMu.Lock()
+	s.subs = append(s.subs, ch)
+	s.subsMu.Unlock()
+}
+
+func (s *MetricsStore) notify() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// metricsShard is one file's decoded entries, keyed by its path so
+// ForceReload can report which shard a duplicate ProductID came from.
+type metricsShard struct {
+	path    string
+	entries []ProductMetrics
+}
+
+// expandMetricsGlobs resolves pattern - a single path or a comma-separated
+// list of glob patterns (e.g. "metrics/*.json,metrics/*.json.gz") - into the
+// deduplicated list of files it matches. A plain path with no glob
+// metacharacters and no comma just expands to itself, so single-file
+// configurations (e.g. defaultMetricsFilePath) behave exactly as before.
+func expandMetricsGlobs(pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		matches, err := filepath.Glob(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", part, err)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// decodeMetricsShardFile streams file's JSON array of ProductMetrics via
+// json.Decoder (rather than os.ReadFile+json.Unmarshal) so a multi-GB shard
+// doesn't require holding both the raw bytes and the decoded slice in
+// memory at once, transparently gzip-decompressing a ".gz"-suffixed file.
+// It checks ctx between entries so a sibling shard's failure can abort a
+// large in-progress decode instead of running it to completion first.
+func decodeMetricsShardFile(ctx context.Context, path string) ([]ProductMetrics, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip reader: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("reading array start: %w", err)
+	}
+	var entries []ProductMetrics
+	for dec.More() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var pm ProductMetrics
+		if err := dec.Decode(&pm); err != nil {
+			return nil, fmt.Errorf("decoding entry %d: %w", len(entries), err)
+		}
+		entries = append(entries, pm)
+	}
+	return entries, nil
+}
+
+// ForceReload re-reads and re-parses every file s.path's glob pattern(s)
+// resolve to, in parallel (bounded by GOMAXPROCS, via errgroup), swapping
+// the snapshot pointer only once every shard has decoded successfully. A
+// failure in any one shard cancels the rest through the errgroup's derived
+// context and fails the whole reload - no partial snapshot is ever
+// installed - leaving the last good snapshot in place and surfacing the
+// error via LastError. Shards are merged in sorted-path order, later file
+// wins a ProductID collision, with a warning logged per overwrite.
+func (s *MetricsStore) ForceReload(ctx context.Context) error {
+	files, err := expandMetricsGlobs(s.path)
+	if err != nil {
+		err = fmt.Errorf("expanding metrics file pattern '%s': %w", s.path, err)
+		s.setErr(err)
+		return err
+	}
+	if len(files) == 0 {
+		err = fmt.Errorf("no files matched metrics pattern '%s'", s.path)
+		s.setErr(err)
+		return err
+	}
+	sort.Strings(files)
+
+	shards := make([]metricsShard, len(files))
+	group, gctx := errgroup.WithContext(ctx)
+	group.SetLimit(runtime.GOMAXPROCS(0))
+	for i, file := range files {
+		i, file := i, file
+		group.Go(func() error {
+			entries, err := decodeMetricsShardFile(gctx, file)
+			if err != nil {
+				return fmt.Errorf("loading metrics shard '%s': %w", file, err)
+			}
+			shards[i] = metricsShard{path: file, entries: entries}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		err = fmt.Errorf("loading metrics shards for '%s': %w", s.path, err)
+		s.setErr(err)
+		return err
+	}
+
+	now := time.Now()
+	fresh := make(map[string]ProductMetrics)
+	skipped := 0
+	for _, shard := range shards {
+		for _, pm := range shard.entries {
+			if pm.ProductID == "" {
+				log.Printf("Warning (MetricsStore.ForceReload): Skipping metric entry with empty product_id in '%s'", shard.path)
+				skipped++
+				continue
+			}
+			normalizedID := BAZAAR_ID(pm.ProductID)
+			if existing, found := fresh[normalizedID]; found {
+				log.Printf("Warning (MetricsStore.ForceReload): ProductID '%s' from '%s' overwrites the entry already loaded from an earlier shard (%+v) with (%+v).", normalizedID, shard.path, existing, pm)
+			}
+			pm.ProductID = normalizedID
+			pm.LastUpdated = now
+			pm.Source = "file:" + shard.path
+			fresh[normalizedID] = pm
+		}
+	}
+
+	s.snapshot.Store(&fresh)
+	s.loadedAt.Store(&now)
+	s.setErr(nil)
+	dlog("MetricsStore: reloaded '%s' from %d shard(s), %d products (%d skipped)", s.path, len(files), len(fresh), skipped)
+	s.notify()
+
+	if err := s.saveToBolt(fresh); err != nil {
+		log.Printf("Warning (MetricsStore.ForceReload): persisting '%s' to bolt failed (continuing with the in-memory snapshot): %v", s.path, err)
+	}
+
+	history := DefaultMetricsHistory()
+	for id, pm := range fresh {
+		history.Record(id, now, pm)
+	}
+	return nil
+}
+
+// Watch performs an initial ForceReload and then, on the first call only,
+// starts a background fsnotify watcher on the file's directory that
+// re-reloads on change. Later calls are no-ops beyond returning the first
+// call's error, mirroring the once-started-never-restarted background
+// loops main.go wires up elsewhere (StartBackgroundRefresh,
+// DefaultPriceStore().Run).
+func (s *MetricsStore) Watch(ctx context.Context) error {
+	if err := s.ForceReload(ctx); err != nil {
+		log.Printf("MetricsStore: initial load of '%s' failed, will retry on next filesystem event: %v", s.path, err)
+	}
+
+	s.watchOnce.Do(func() {
+		s.watchErr = s.watch(ctx)
+	})
+	return s.watchErr
+}
+
+// watch is Watch's actual fsnotify loop, split out so watchOnce only guards
+// starting it. It watches the file's parent directory rather than the file
+// itself, since editors and atomic-rename writers (e.g. `mv tmp latest.json`)
+// replace the inode rather than writing into it, which a direct watch on the
+// file would miss.
+func (s *MetricsStore) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating fsnotify watcher for '%s': %w", s.path, err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching directory '%s': %w", dir, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		var debounceTimer *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				if debounceTimer != nil {
+					debounceTimer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != s.path {
+					continue
+				}
+				if debounceTimer != nil {
+					debounceTimer.Stop()
+				}
+				debounceTimer = time.AfterFunc(s.debounce, func() {
+					if err := s.ForceReload(ctx); err != nil {
+						log.Printf("MetricsStore: reload of '%s' after fsnotify event failed: %v", s.path, err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("MetricsStore: fsnotify error watching '%s': %v", dir, err)
+			}
+		}
+	}()
+	return nil
+}
+
+var (
+	defaultMetricsStore     *MetricsStore
+	defaultMetricsStoreOnce sync.Once
+)
+
+// DefaultMetricsStore lazily constructs the package-wide MetricsStore for
+// path, mirroring DefaultResultCache/DefaultTreeCache's singleton pattern.
+// Every call site in this package passes the same defaultMetricsFilePath
+// constant, so only the first path given wins for the process's lifetime.
+func DefaultMetricsStore(path string) *MetricsStore {
+	defaultMetricsStoreOnce.Do(func() {
+		defaultMetricsStore = NewMetricsStore(path)
+	})
+	return defaultMetricsStore
+}
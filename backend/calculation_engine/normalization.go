@@ -0,0 +1,253 @@
+// normalization.go
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultNormalizationData embeds the built-in legacy→flattened item ID
+// rules so the binary works out of the box with no external files.
+//
+//go:embed normalization_data.json
+var defaultNormalizationData embed.FS
+
+// NormalizationOverrideFile, if set (e.g. via an env var or flag at startup),
+// is merged on top of the embedded rules, letting deployments extend/patch
+// mappings without a rebuild. Only JSON is supported - this package imports
+// no YAML library, and there's no YAML decoder in the standard library to
+// reach for instead. There's also no notion of an "API
+// version" anywhere else in this codebase for a version-keyed override
+// precedence layer to hang off of; ReloadNormalizationMap/RegisterNormalization
+// below cover the rest of this file's hot-reload/merge-precedence surface.
+var NormalizationOverrideFile string
+
+var (
+	itemIDNormalizationMap map[string]string
+	subIDTables            = make(map[string]map[int]string)
+	normalizationMu        sync.RWMutex
+	normalizeMapOnce       sync.Once
+)
+
+// subIDPattern matches a trailing ":N" or "-N" sub-ID suffix, e.g. "LOG:2" or "LOG-2".
+var subIDPattern = regexp.MustCompile(`^(.+?)[:\-](\d+)$`)
+
+func initializeNormalizationMap() {
+	itemIDNormalizationMap = buildNormalizationMap()
+}
+
+// buildNormalizationMap assembles the built-in embedded rules, then merges
+// NormalizationOverrideFile on top (if set). It's split out from
+// initializeNormalizationMap so ReloadNormalizationMap can rebuild the table
+// on demand without re-running sync.Once.
+func buildNormalizationMap() map[string]string {
+	dlog("Initializing Item ID normalization map...")
+	rules := make(map[string]string)
+
+	if data, err := defaultNormalizationData.ReadFile("normalization_data.json"); err == nil {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			dlog("WARN: failed to parse embedded normalization_data.json: %v", err)
+		}
+	} else {
+		dlog("WARN: failed to read embedded normalization_data.json: %v", err)
+	}
+
+	if NormalizationOverrideFile != "" {
+		if data, err := os.ReadFile(NormalizationOverrideFile); err == nil {
+			var overrides map[string]string
+			if err := json.Unmarshal(data, &overrides); err != nil {
+				dlog("WARN: failed to parse normalization override file %s: %v", NormalizationOverrideFile, err)
+			} else {
+				merged := 0
+				for k, v := range overrides {
+					key := strings.ToUpper(strings.TrimSpace(k))
+					val := strings.ToUpper(strings.TrimSpace(v))
+					if val == "" {
+						dlog("WARN: skipping alias %q with empty canonical value in %s", key, NormalizationOverrideFile)
+						continue
+					}
+					if val == key {
+						dlog("WARN: skipping alias %q that maps to itself in %s", key, NormalizationOverrideFile)
+						continue
+					}
+					if existing, ok := rules[key]; ok && existing != val {
+						dlog("WARN: normalization override collision: %s overrides built-in %s -> %s with %s -> %s", NormalizationOverrideFile, key, existing, key, val)
+					}
+					rules[key] = val
+					merged++
+				}
+				dlog("Merged %d normalization overrides from %s (%d skipped)", merged, NormalizationOverrideFile, len(overrides)-merged)
+			}
+		} else {
+			dlog("WARN: normalization override file %s not readable: %v", NormalizationOverrideFile, err)
+		}
+	}
+
+	dlog("Normalization map initialized with %d entries.", len(rules))
+	return rules
+}
+
+// ReloadNormalizationMap re-reads NormalizationOverrideFile and swaps in the
+// freshly merged table, without disturbing the embedded built-ins or
+// anything registered at runtime via RegisterNormalization. Safe to call
+// concurrently with NormalizeItemID.
+func ReloadNormalizationMap() {
+	normalizeMapOnce.Do(initializeNormalizationMap)
+	rules := buildNormalizationMap()
+
+	normalizationMu.Lock()
+	defer normalizationMu.Unlock()
+	itemIDNormalizationMap = rules
+}
+
+// WatchNormalizationReload starts a goroutine that calls ReloadNormalizationMap
+// every time the process receives SIGHUP, letting operators push new aliases
+// (skin variants, dye reworks, etc.) into NormalizationOverrideFile without a
+// restart. It returns immediately; the watcher runs for the life of the process.
+func WatchNormalizationReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			dlog("SIGHUP received, reloading normalization map from %s", NormalizationOverrideFile)
+			ReloadNormalizationMap()
+		}
+	}()
+}
+
+// WatchNormalizationReloadFile polls NormalizationOverrideFile's mtime every
+// interval and calls ReloadNormalizationMap when it has changed - the same
+// ticker-driven polling StartBackgroundRefresh (refresh.go) and
+// StartStaleRefreshWorker (staleness.go) use for their own periodic refresh
+// loops, for a deployment that can rewrite the override file but can't signal
+// the process directly (WatchNormalizationReload's SIGHUP requires process
+// access). Run it in its own goroutine; it returns once ctx is cancelled, or
+// immediately if NormalizationOverrideFile is unset.
+func WatchNormalizationReloadFile(ctx context.Context, interval time.Duration) {
+	if NormalizationOverrideFile == "" {
+		return
+	}
+
+	var lastMod time.Time
+	if info, err := os.Stat(NormalizationOverrideFile); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(NormalizationOverrideFile)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().After(lastMod) {
+				dlog("normalization override file %s changed on disk, reloading", NormalizationOverrideFile)
+				ReloadNormalizationMap()
+				lastMod = info.ModTime()
+			}
+		}
+	}
+}
+
+// DumpNormalizationMap returns the effective merged raw->canonical table
+// (embedded built-ins plus NormalizationOverrideFile, minus anything skipped
+// by validation) as indented JSON, for the --dump-aliases CLI mode.
+func DumpNormalizationMap() ([]byte, error) {
+	normalizeMapOnce.Do(initializeNormalizationMap)
+	normalizationMu.RLock()
+	defer normalizationMu.RUnlock()
+	return json.MarshalIndent(itemIDNormalizationMap, "", "  ")
+}
+
+// RegisterNormalization adds (or overwrites) a single raw→canonical item ID
+// mapping at runtime, on top of whatever was loaded from the embedded/override
+// files. raw is upper-cased/trimmed to match NormalizeItemID's lookup.
+func RegisterNormalization(raw, canonical string) {
+	normalizeMapOnce.Do(initializeNormalizationMap)
+	normalizationMu.Lock()
+	defer normalizationMu.Unlock()
+	itemIDNormalizationMap[strings.ToUpper(strings.TrimSpace(raw))] = canonical
+}
+
+// RegisterSubIDTable registers a decoder table for sub-IDs of the form
+// "PREFIX:N" or "PREFIX-N" (e.g. prefix "LOG", table {2: "BIRCH_LOG"}), used
+// by NormalizeItemID when no exact mapping exists for the full raw ID.
+func RegisterSubIDTable(prefix string, table map[int]string) {
+	normalizationMu.Lock()
+	defer normalizationMu.Unlock()
+	subIDTables[strings.ToUpper(strings.TrimSpace(prefix))] = table
+}
+
+// NormalizeItemID maps a raw/legacy Minecraft or Bazaar item ID to its
+// canonical flattened form. Lookup order: exact rule match, then sub-ID
+// decoding via a registered table for the ID's prefix, else the raw
+// (upper-cased) ID unchanged.
+func NormalizeItemID(id string) string {
+	standardID := strings.ToUpper(strings.TrimSpace(id))
+	normalizeMapOnce.Do(initializeNormalizationMap)
+
+	normalizationMu.RLock()
+	defer normalizationMu.RUnlock()
+
+	if normalized, ok := itemIDNormalizationMap[standardID]; ok {
+		DefaultMetrics(nil).NormalizationLookupsTotal.WithLabelValues("direct").Inc()
+		return normalized
+	}
+
+	if m := subIDPattern.FindStringSubmatch(standardID); m != nil {
+		prefix, subIDStr := m[1], m[2]
+		if table, ok := subIDTables[prefix]; ok {
+			if n, err := strconv.Atoi(subIDStr); err == nil {
+				if canonical, ok := table[n]; ok {
+					DefaultMetrics(nil).NormalizationLookupsTotal.WithLabelValues("direct").Inc()
+					return canonical
+				}
+			}
+		}
+	}
+
+	DefaultMetrics(nil).NormalizationMisses.Inc()
+	DefaultMetrics(nil).NormalizationLookupsTotal.WithLabelValues("passthrough").Inc()
+	return standardID
+}
+
+func BAZAAR_ID(id string) string {
+	if dir, itemID, ok := splitModuleID(id); ok {
+		return dir + moduleIDSeparator + NormalizeItemID(itemID)
+	}
+	return NormalizeItemID(id)
+}
+
+// moduleIDSeparator joins a resolved module directory onto an ingredient's
+// item ID (aggregateCells bakes this in when a cell's alias prefix matches a
+// Modules key) so itemNameNorm stays fully self-contained - memo keys,
+// cycle-detection path entries and recipeFilePath all key/resolve off it
+// directly without needing the originating Item.Modules map threaded back
+// through every call. Two colons rather than one so it can't collide with
+// the single-colon "ITEM_ID:AMOUNT"/"alias:ITEM_ID" cell DSL, which is
+// already resolved by the time an ID reaches this form.
+const moduleIDSeparator = "::"
+
+// splitModuleID splits a module-qualified item ID (see moduleIDSeparator)
+// back into its directory and bare item ID. ok is false for a plain,
+// non-module ID.
+func splitModuleID(itemNameNorm string) (dir, itemID string, ok bool) {
+	idx := strings.Index(itemNameNorm, moduleIDSeparator)
+	if idx == -1 {
+		return "", itemNameNorm, false
+	}
+	return itemNameNorm[:idx], itemNameNorm[idx+len(moduleIDSeparator):], true
+}
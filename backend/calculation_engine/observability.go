@@ -0,0 +1,681 @@
+// observability.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Logger is a minimal structured-logging interface, shaped so that a
+// zap.SugaredLogger or zerolog.Logger adapter can satisfy it directly. The
+// package falls back to stdLogger (wrapping log.Printf) when none is
+// injected via SetLogger.
+type Logger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) logw(level, msg string, kv []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	log.Println(b.String())
+}
+
+func (l stdLogger) Debugw(msg string, kv ...interface{}) {
+	if isDebug {
+		l.logw("DEBUG", msg, kv)
+	}
+}
+func (l stdLogger) Infow(msg string, kv ...interface{})  { l.logw("INFO", msg, kv) }
+func (l stdLogger) Warnw(msg string, kv ...interface{})  { l.logw("WARN", msg, kv) }
+func (l stdLogger) Errorw(msg string, kv ...interface{}) { l.logw("ERROR", msg, kv) }
+
+var (
+	activeLogger   Logger = stdLogger{}
+	activeLoggerMu sync.RWMutex
+)
+
+// SetLogger installs l as the package-wide structured logger, replacing the
+// default stdLogger (which wraps log.Printf/dlog).
+func SetLogger(l Logger) {
+	activeLoggerMu.Lock()
+	defer activeLoggerMu.Unlock()
+	activeLogger = l
+}
+
+func getLogger() Logger {
+	activeLoggerMu.RLock()
+	defer activeLoggerMu.RUnlock()
+	return activeLogger
+}
+
+// --- Minimal Prometheus-compatible metrics, with no external dependency ---
+//
+// Metrics are collected by this package's own Registry type (below), which
+// renders the Prometheus text exposition format; a *prometheus.Registry
+// cannot be passed in its place.
+
+type metricVec interface {
+	name() string
+	help() string
+	writeTo(w io.Writer)
+}
+
+// Counter is a monotonically increasing value, optionally labeled.
+type Counter struct {
+	metricName string
+	metricHelp string
+	labelNames []string
+
+	mu        sync.Mutex
+	values    map[string]*uint64
+	labelKeys []string
+	lastKey   string
+}
+
+func newCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{metricName: name, metricHelp: help, labelNames: labelNames, values: make(map[string]*uint64)}
+}
+
+func (c *Counter) name() string { return c.metricName }
+func (c *Counter) help() string { return c.metricHelp }
+
+// WithLabelValues selects the label value combination that the next Inc
+// call applies to (order must match labelNames passed to the constructor).
+func (c *Counter) WithLabelValues(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	if _, ok := c.values[key]; !ok {
+		var v uint64
+		c.values[key] = &v
+		c.labelKeys = append(c.labelKeys, key)
+	}
+	c.mu.Unlock()
+	c.lastKey = key
+	return c
+}
+
+// Inc increments the most recently selected label combination (or the
+// unlabeled series if WithLabelValues was never called).
+func (c *Counter) Inc() {
+	c.mu.Lock()
+	key := c.lastKey
+	ptr, ok := c.values[key]
+	if !ok {
+		var v uint64
+		ptr = &v
+		c.values[key] = ptr
+		c.labelKeys = append(c.labelKeys, key)
+	}
+	c.mu.Unlock()
+	atomic.AddUint64(ptr, 1)
+}
+
+func (c *Counter) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.metricName, c.metricHelp, c.metricName)
+	c.mu.Lock()
+	keys := append([]string(nil), c.labelKeys...)
+	c.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.mu.Lock()
+		ptr := c.values[key]
+		c.mu.Unlock()
+		writeSeriesLine(w, c.metricName, c.labelNames, key, float64(atomic.LoadUint64(ptr)))
+	}
+}
+
+// Gauge is an arbitrary up/down value, optionally labeled.
+type Gauge struct {
+	metricName string
+	metricHelp string
+	labelNames []string
+
+	mu      sync.Mutex
+	values  map[string]*float64
+	lastKey string
+}
+
+func newGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{metricName: name, metricHelp: help, labelNames: labelNames, values: make(map[string]*float64)}
+}
+
+func (g *Gauge) name() string { return g.metricName }
+func (g *Gauge) help() string { return g.metricHelp }
+
+func (g *Gauge) WithLabelValues(values ...string) *Gauge {
+	key := strings.Join(values, "\xff")
+	g.mu.Lock()
+	if _, ok := g.values[key]; !ok {
+		v := 0.0
+		g.values[key] = &v
+	}
+	g.mu.Unlock()
+	g.lastKey = key
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ptr, ok := g.values[g.lastKey]
+	if !ok {
+		vv := v
+		g.values[g.lastKey] = &vv
+		return
+	}
+	*ptr = v
+}
+
+func (g *Gauge) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metricName, g.metricHelp, g.metricName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	keys := make([]string, 0, len(g.values))
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeSeriesLine(w, g.metricName, g.labelNames, key, *g.values[key])
+	}
+}
+
+func writeSeriesLine(w io.Writer, name string, labelNames []string, labelKey string, value float64) {
+	if labelKey == "" || len(labelNames) == 0 {
+		fmt.Fprintf(w, "%s %g\n", name, value)
+		return
+	}
+	parts := strings.Split(labelKey, "\xff")
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString("{")
+	for i, ln := range labelNames {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		val := ""
+		if i < len(parts) {
+			val = parts[i]
+		}
+		fmt.Fprintf(&b, "%s=%q", ln, val)
+	}
+	b.WriteString("}")
+	fmt.Fprintf(w, "%s %g\n", b.String(), value)
+}
+
+// Histogram buckets observations into cumulative <=le counts plus a running
+// sum/count, the same shape prometheus.Histogram exposes, optionally labeled.
+type Histogram struct {
+	metricName string
+	metricHelp string
+	labelNames []string
+	buckets    []float64 // ascending, exclusive of the implicit +Inf bucket
+
+	mu        sync.Mutex
+	series    map[string]*histogramSeries
+	labelKeys []string
+	lastKey   string
+}
+
+type histogramSeries struct {
+	bucketCounts []uint64 // bucketCounts[i] = observations <= buckets[i], cumulative by construction
+	sum          float64
+	count        uint64
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{metricName: name, metricHelp: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *Histogram) name() string { return h.metricName }
+func (h *Histogram) help() string { return h.metricHelp }
+
+// WithLabelValues selects the label value combination that the next Observe
+// call applies to (order must match labelNames passed to the constructor).
+func (h *Histogram) WithLabelValues(values ...string) *Histogram {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	if _, ok := h.series[key]; !ok {
+		h.series[key] = &histogramSeries{bucketCounts: make([]uint64, len(h.buckets))}
+		h.labelKeys = append(h.labelKeys, key)
+	}
+	h.mu.Unlock()
+	h.lastKey = key
+	return h
+}
+
+// Observe records v against the most recently selected label combination (or
+// the unlabeled series if WithLabelValues was never called).
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.lastKey
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{bucketCounts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.labelKeys = append(h.labelKeys, key)
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.bucketCounts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.metricHelp, h.metricName)
+	h.mu.Lock()
+	keys := append([]string(nil), h.labelKeys...)
+	h.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		h.mu.Lock()
+		s := h.series[key]
+		h.mu.Unlock()
+		for i, le := range h.buckets {
+			writeSeriesLine(w, h.metricName+"_bucket", append(append([]string(nil), h.labelNames...), "le"), appendLabelKey(key, fmt.Sprintf("%g", le)), float64(s.bucketCounts[i]))
+		}
+		writeSeriesLine(w, h.metricName+"_bucket", append(append([]string(nil), h.labelNames...), "le"), appendLabelKey(key, "+Inf"), float64(s.count))
+		writeSeriesLine(w, h.metricName+"_sum", h.labelNames, key, s.sum)
+		writeSeriesLine(w, h.metricName+"_count", h.labelNames, key, float64(s.count))
+	}
+}
+
+// appendLabelKey appends an extra \xff-joined label value (e.g. "le") onto an
+// existing label key built by Counter/Gauge/Histogram's WithLabelValues.
+func appendLabelKey(key, extra string) string {
+	if key == "" {
+		return extra
+	}
+	return key + "\xff" + extra
+}
+
+// Summary estimates observation quantiles the same way prometheus.Summary
+// does, optionally labeled. Observe only appends to a hot buffer; the cold
+// buffer (the last sorted snapshot) is recomputed lazily the next time a
+// quantile is read, so a busy Observe caller never pays a sort.
+type Summary struct {
+	metricName string
+	metricHelp string
+	labelNames []string
+	quantiles  []float64 // ascending, e.g. [0.5, 0.9, 0.99]
+
+	mu        sync.Mutex
+	series    map[string]*summarySeries
+	labelKeys []string
+	lastKey   string
+}
+
+type summarySeries struct {
+	hot   []float64 // observations since the last snapshot, unsorted
+	cold  []float64 // sorted snapshot as of the last read
+	sum   float64
+	count uint64
+}
+
+// defaultSummaryQuantiles are the 0.5/0.9/0.99 quantiles called for across
+// this package's cost/fill-time summaries.
+var defaultSummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+func newSummary(name, help string, quantiles []float64, labelNames ...string) *Summary {
+	return &Summary{metricName: name, metricHelp: help, labelNames: labelNames, quantiles: quantiles, series: make(map[string]*summarySeries)}
+}
+
+func (s *Summary) name() string { return s.metricName }
+func (s *Summary) help() string { return s.metricHelp }
+
+// WithLabelValues selects the label value combination the next Observe call
+// applies to (order must match labelNames passed to the constructor).
+func (s *Summary) WithLabelValues(values ...string) *Summary {
+	key := strings.Join(values, "\xff")
+	s.mu.Lock()
+	if _, ok := s.series[key]; !ok {
+		s.series[key] = &summarySeries{}
+		s.labelKeys = append(s.labelKeys, key)
+	}
+	s.mu.Unlock()
+	s.lastKey = key
+	return s
+}
+
+// Observe records v against the most recently selected label combination (or
+// the unlabeled series if WithLabelValues was never called). NaN/Inf are
+// dropped rather than poisoning the sum and every quantile estimate.
+func (s *Summary) Observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.lastKey
+	series, ok := s.series[key]
+	if !ok {
+		series = &summarySeries{}
+		s.series[key] = series
+		s.labelKeys = append(s.labelKeys, key)
+	}
+	series.hot = append(series.hot, v)
+	series.sum += v
+	series.count++
+}
+
+// snapshot merges any pending hot observations into the sorted cold buffer
+// and returns it. Caller must hold s.mu.
+func (series *summarySeries) snapshot() []float64 {
+	if len(series.hot) == 0 {
+		return series.cold
+	}
+	series.cold = append(series.cold, series.hot...)
+	sort.Float64s(series.cold)
+	series.hot = series.hot[:0]
+	return series.cold
+}
+
+// quantileOf returns sorted[floor(q*(len-1))], the lower-rank estimate of q
+// (0..1) with no interpolation, over sorted, which must already be ascending.
+func quantileOf(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	idx := int(q * float64(len(sorted)-1))
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func (s *Summary) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", s.metricName, s.metricHelp, s.metricName)
+	s.mu.Lock()
+	keys := append([]string(nil), s.labelKeys...)
+	s.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.mu.Lock()
+		series := s.series[key]
+		sorted := append([]float64(nil), series.snapshot()...)
+		sum, count := series.sum, series.count
+		s.mu.Unlock()
+
+		for _, q := range s.quantiles {
+			writeSeriesLine(w, s.metricName, append(append([]string(nil), s.labelNames...), "quantile"), appendLabelKey(key, fmt.Sprintf("%g", q)), quantileOf(sorted, q))
+		}
+		writeSeriesLine(w, s.metricName+"_sum", s.labelNames, key, sum)
+		writeSeriesLine(w, s.metricName+"_count", s.labelNames, key, float64(count))
+	}
+}
+
+// Registry collects metrics and renders them in Prometheus text exposition
+// format via its HTTPHandler.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metricVec
+}
+
+func NewRegistry() *Registry { return &Registry{} }
+
+func (r *Registry) register(m metricVec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// HTTPHandler returns an http.Handler suitable for mounting at /metrics.
+func (r *Registry) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		r.mu.Lock()
+		metrics := append([]metricVec(nil), r.metrics...)
+		r.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			m.writeTo(w)
+		}
+	})
+}
+
+// Default metrics for the cache + fetch pipeline. Populated lazily via
+// DefaultMetrics() so packages that never call it pay no cost.
+type pipelineMetrics struct {
+	FetchAttemptsTotal  *Counter // labels: outcome
+	FetchLatencySeconds *Gauge   // labels: outcome (last observed; see note below)
+	CacheAgeSeconds     *Gauge
+	NormalizationMisses *Counter
+
+	NormalizationLookupsTotal *Counter   // labels: hit ("direct" | "passthrough")
+	InstasellFillTimeSeconds  *Histogram // calculateInstasellFillTime* results, finite values only
+	BuyOrderFillTimeSeconds   *Histogram // calculateBuyOrderFillTime* results, finite values only
+	ZeroPriceLookups          *Gauge     // labels: side ("buy" | "sell"); 1 if the most recent lookup returned 0, else 0
+	CellParseErrors           *Counter
+	CellParseErrorsByPosition *Counter // labels: position (e.g. "A1")
+
+	// Handler- and expansion-level metrics, covering itemDashboardHandler and
+	// startExpandJob's PerformDualExpansion call (this package's equivalents
+	// of a generic "fillHandler"/"dualExpansionHandler" pair) end to end.
+	FillRequestsTotal         *Counter   // labels: status ("ok" | "error" | "timeout" | "cancelled")
+	FillDurationSeconds       *Histogram // wall-clock time of one handler invocation, start to response
+	ExpandDepth               *Histogram // CraftingStepNode.MaxSubTreeDepth of the resulting recipe tree
+	ExpandCyclesDetectedTotal *Counter   // expandItemRecursiveTree hits on an item already in path
+	IngredientsProcessedTotal *Counter   // base ingredients priced across calculateDetailedCostsAndFillTimes/analyzeTreeForCostsAndTimes
+	CalculationWarningsTotal  *Counter   // labels: stage ("base_ingredients" | "tree_analysis"); isPossible flipped to false
+	ApiCacheAgeSeconds        *Gauge     // age of the cached Hypixel Bazaar response, mirrors CacheAgeSeconds under the wiz_ prefix
+	MetricsCacheAgeSeconds    *Gauge     // age of MetricsStore's snapshot since its last successful load
+
+	// Cost/fill-time summaries, covering calculateDetailedCostsAndFillTimes
+	// and analyzeTreeForCostsAndTimes (calculateBuyOrderFillTime's own
+	// per-call distribution is already covered by BuyOrderFillTimeSeconds
+	// above; these summarize the two callers' per-root outcomes instead).
+	TotalCostSummary          *Summary   // labels: stage ("base_ingredients" | "tree_analysis"); totalSumOfBestCosts per root, finite values only
+	SlowestFillTimeSummary    *Summary   // labels: stage; the slowest base ingredient's fill time per root, finite values only
+	CostAnalysisOutcomesTotal *Counter   // labels: stage, possible ("true" | "false")
+	BestCostMethodTotal       *Counter   // labels: method ("Primary" | "Secondary" | "N/A"); getBestC10M's chosen method per base ingredient
+	BaseIngredientsMapSize    *Histogram // size of the base-ingredient map evaluated per root
+
+	// wizItemHandler/calculateBatchHandler metrics (wizserver.go,
+	// calculate_batch.go) - kept separate from FillRequestsTotal/
+	// FillDurationSeconds/ExpandDepth above, which cover itemDashboardHandler,
+	// so a dashboard built against one endpoint family's request volume isn't
+	// silently mixed with the other's.
+	CalculateRequestsTotal    *Counter   // labels: status ("ok" | "error" | "timeout" | "cancelled")
+	CalculateLatencySeconds   *Histogram // wall-clock time of one calculate-handler invocation, start to response
+	ExpansionDepth            *Histogram // CraftingStepNode.MaxSubTreeDepth, calculate-handler results only
+	IngredientCostErrorsTotal *Counter   // base ingredients whose BaseIngredientDetail carried an error, across calculate handlers
+	BazaarItemsTracked        *Gauge     // len(metricsMap) as of the most recent calculate-handler call
+
+	// /metrics/write line-protocol ingestion (metrics_ingest.go).
+	MetricsIngestLinesTotal *Counter // labels: outcome ("accepted" | "malformed" | "dropped")
+
+	// ConditionalBazaarSource (bazaar_conditional_source.go) fetch outcomes.
+	BazaarConditionalFetchesTotal *Counter // labels: outcome ("memory_hit" | "not_modified" | "modified" | "error")
+
+	// getBestC10M error outcomes (c10m.go), labeled by reason so an operator
+	// can tell "nobody lists this item" (api_data_missing) apart from
+	// "the relist-rate math blew up" (both_invalid) at a glance.
+	C10MErrorsTotal *Counter // labels: reason
+	// IngredientsWithNaNTotal counts base ingredients whose BestCost came
+	// back NaN/Inf from priceBaseIngredient - a coarser, always-incrementing
+	// counterpart to IngredientCostErrorsTotal/CalculationWarningsTotal above,
+	// for a dashboard panel that just wants "how much NaN propagation is
+	// happening right now" without caring which handler it came through.
+	IngredientsWithNaNTotal *Counter
+	// OptimizerLastRunItemsScanned is the item count RunFullOptimization/
+	// RunFullOptimizationStream most recently finished a sweep over.
+	OptimizerLastRunItemsScanned *Gauge
+
+	// Fill-time formula internals (fill_time.go), exposed so an operator can
+	// see why a given RunFullOptimization sweep's fill times look off without
+	// reaching for dlog output. Like FetchLatencySeconds above, these are
+	// last-observed gauges rather than per-item series: this package has no
+	// precedent anywhere else for a per-product_id label, and with a
+	// Bazaar-sized item catalog that label would blow up cardinality for
+	// comparatively little benefit over dlog's existing per-item detail.
+	FillTimeLastBuyMovingWeek *Gauge // QuickStatus.BuyMovingWeek seen by the most recent calculateInstasellFillTime* call
+	FillTimeLastSupplyRate    *Gauge // pm.SellSize*SellFrequency seen by the most recent calculateBuyOrderFillTime* call
+	FillTimeLastDemandRate    *Gauge // pm.OrderSize*OrderFrequency seen by the most recent calculateBuyOrderFillTime* call
+	FillTimeLastDeltaNetFlow  *Gauge // supplyRate-demandRate from the most recent calculateBuyOrderFillTimeFromRates call
+	FillTimeLastCalculatedRR  *Gauge // the formula-context RR from the most recent calculateBuyOrderFillTimeFromRates call, finite values only
+	// FillTimeErrorsTotal counts calculateInstasellFillTime*/
+	// calculateBuyOrderFillTime* outcomes that couldn't produce a usable
+	// fill time, by reason: bmw_zero (BuyMovingWeek-derived rate <= 0),
+	// of_zero (order frequency <= 0 with a non-positive net flow), if_zero
+	// (InstaFills-per-cycle <= 0, forcing RR to Inf), nan_result (final
+	// fillTime failed NaN/Inf/negative validation).
+	FillTimeErrorsTotal *Counter // labels: reason
+}
+
+// fillTimeBuckets spans 1s..1d, matching how fill times are reported
+// elsewhere (formatSeconds) rather than a generic latency-style scale.
+var fillTimeBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 21600, 43200, 86400}
+
+// expandDepthBuckets spans a shallow lookup up through a deep multi-stage
+// crafting chain; MaxSubTreeDepth rarely exceeds the teens in practice.
+var expandDepthBuckets = []float64{0, 1, 2, 3, 5, 8, 13, 21}
+
+// ingredientCountBuckets spans a single-base-ingredient item up through a
+// wide, many-tiered recipe tree's flattened base-ingredient set.
+var ingredientCountBuckets = []float64{1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+
+// calculateLatencyBuckets covers the 10ms-5s range the backlog asks
+// wiz_calculate_latency_seconds to bucket, a tighter scale than
+// fillTimeBuckets since calculate handlers are meant to answer in-request
+// rather than report fill times measured in minutes/hours.
+var calculateLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+var (
+	defaultMetrics     *pipelineMetrics
+	defaultMetricsOnce sync.Once
+	defaultRegistry    *Registry
+)
+
+// DefaultMetrics lazily builds and registers the package's metrics on reg
+// (this package's Registry; nil means a fresh one, and only the first call's
+// reg is used) and returns them for direct use (e.g. FetchAttemptsTotal.WithLabelValues("success").Inc()).
+func DefaultMetrics(reg *Registry) *pipelineMetrics {
+	defaultMetricsOnce.Do(func() {
+		if reg == nil {
+			reg = NewRegistry()
+		}
+		defaultRegistry = reg
+		defaultMetrics = &pipelineMetrics{
+			FetchAttemptsTotal:  newCounter("bazaar_fetch_attempts_total", "Total Hypixel Bazaar fetch attempts by outcome", "outcome"),
+			FetchLatencySeconds: newGauge("bazaar_fetch_latency_seconds", "Duration of the most recent Hypixel Bazaar fetch, by outcome", "outcome"),
+			CacheAgeSeconds:     newGauge("bazaar_cache_age_seconds", "Age of the cached Bazaar response in seconds"),
+			NormalizationMisses: newCounter("item_id_normalization_misses_total", "Item IDs that NormalizeItemID could not map to a canonical ID"),
+
+			NormalizationLookupsTotal: newCounter("item_id_normalization_lookups_total", "NormalizeItemID calls by outcome", "hit"),
+			InstasellFillTimeSeconds:  newHistogram("instasell_fill_time_seconds", "calculateInstasellFillTime results, in seconds", fillTimeBuckets),
+			BuyOrderFillTimeSeconds:   newHistogram("buy_order_fill_time_seconds", "calculateBuyOrderFillTime results, in seconds", fillTimeBuckets),
+			ZeroPriceLookups:          newGauge("bazaar_zero_price_lookups", "Whether the most recent getSellPrice/getBuyPrice call returned 0 (missing data), by side", "side"),
+			CellParseErrors:           newCounter("recipe_cell_parse_errors_total", "aggregateCells invocations that hit a malformed amount"),
+			CellParseErrorsByPosition: newCounter("recipe_cell_parse_errors_by_position_total", "aggregateCells invocations that hit a malformed amount, by cell position", "position"),
+
+			FillRequestsTotal:         newCounter("wiz_fill_requests_total", "Dual-expansion handler invocations by outcome", "status"),
+			FillDurationSeconds:       newHistogram("wiz_fill_duration_seconds", "Dual-expansion handler wall-clock duration, in seconds", fillTimeBuckets),
+			ExpandDepth:               newHistogram("wiz_expand_depth", "MaxSubTreeDepth of the resulting recipe tree", expandDepthBuckets),
+			ExpandCyclesDetectedTotal: newCounter("wiz_expand_cycles_detected_total", "Recipe expansion cycles detected (an item reappearing in its own ancestor path)"),
+			IngredientsProcessedTotal: newCounter("wiz_ingredients_processed_total", "Base ingredients priced across all dual-expansion calls"),
+			CalculationWarningsTotal:  newCounter("wiz_calculation_warnings_total", "Expansion stages that set isPossible/currentIsPossible to false", "stage"),
+			ApiCacheAgeSeconds:        newGauge("wiz_api_cache_age_seconds", "Age of the cached Hypixel Bazaar response in seconds"),
+			MetricsCacheAgeSeconds:    newGauge("wiz_metrics_cache_age_seconds", "Age of the file-backed ProductMetrics cache in seconds"),
+
+			TotalCostSummary:          newSummary("wiz_total_cost_summary", "totalSumOfBestCosts per evaluated root, finite values only", defaultSummaryQuantiles, "stage"),
+			SlowestFillTimeSummary:    newSummary("wiz_slowest_fill_time_summary_seconds", "Slowest base-ingredient fill time per evaluated root, finite values only", defaultSummaryQuantiles, "stage"),
+			CostAnalysisOutcomesTotal: newCounter("wiz_cost_analysis_outcomes_total", "calculateDetailedCostsAndFillTimes/analyzeTreeForCostsAndTimes calls by stage and whether the result was possible", "stage", "possible"),
+			BestCostMethodTotal:       newCounter("wiz_best_cost_method_total", "Base ingredients priced, by the method getBestC10M chose", "method"),
+			BaseIngredientsMapSize:    newHistogram("wiz_base_ingredients_map_size", "Number of base ingredients evaluated per root", ingredientCountBuckets),
+
+			CalculateRequestsTotal:    newCounter("wiz_calculate_requests_total", "wizItemHandler/calculateBatchHandler invocations by outcome", "status"),
+			CalculateLatencySeconds:   newHistogram("wiz_calculate_latency_seconds", "wizItemHandler/calculateBatchHandler wall-clock duration, in seconds", calculateLatencyBuckets),
+			ExpansionDepth:            newHistogram("wiz_expansion_depth", "MaxSubTreeDepth of calculate-handler results", expandDepthBuckets),
+			IngredientCostErrorsTotal: newCounter("wiz_ingredient_cost_errors_total", "Base ingredients returned with a non-empty error across calculate handlers"),
+			BazaarItemsTracked:        newGauge("wiz_bazaar_items_tracked", "Number of product IDs in the metrics map as of the most recent calculate-handler call"),
+
+			MetricsIngestLinesTotal: newCounter("wiz_metrics_ingest_lines_total", "POST /metrics/write lines processed, by outcome", "outcome"),
+
+			BazaarConditionalFetchesTotal: newCounter("wiz_bazaar_conditional_fetches_total", "ConditionalBazaarSource.Fetch calls by outcome", "outcome"),
+
+			C10MErrorsTotal:              newCounter("wiz_c10m_errors_total", "getBestC10M calls that returned a non-nil error, by reason", "reason"),
+			IngredientsWithNaNTotal:      newCounter("wiz_ingredients_with_nan_total", "Base ingredients whose BestCost came back NaN/Inf from priceBaseIngredient"),
+			OptimizerLastRunItemsScanned: newGauge("wiz_optimizer_last_run_items_scanned", "Number of items in the most recently completed RunFullOptimization/RunFullOptimizationStream sweep"),
+
+			FillTimeLastBuyMovingWeek: newGauge("wiz_fill_time_last_buy_moving_week", "QuickStatus.BuyMovingWeek seen by the most recent calculateInstasellFillTime call"),
+			FillTimeLastSupplyRate:    newGauge("wiz_fill_time_last_supply_rate", "SellSize*SellFrequency seen by the most recent calculateBuyOrderFillTime call"),
+			FillTimeLastDemandRate:    newGauge("wiz_fill_time_last_demand_rate", "OrderSize*OrderFrequency seen by the most recent calculateBuyOrderFillTime call"),
+			FillTimeLastDeltaNetFlow:  newGauge("wiz_fill_time_last_delta_net_flow", "supplyRate-demandRate from the most recent calculateBuyOrderFillTimeFromRates call"),
+			FillTimeLastCalculatedRR:  newGauge("wiz_fill_time_last_calculated_rr", "Formula-context RR from the most recent calculateBuyOrderFillTimeFromRates call, finite values only"),
+			FillTimeErrorsTotal:       newCounter("wiz_fill_time_errors_total", "calculateInstasellFillTime/calculateBuyOrderFillTime outcomes that couldn't produce a usable fill time, by reason", "reason"),
+		}
+		reg.register(defaultMetrics.FetchAttemptsTotal)
+		reg.register(defaultMetrics.FetchLatencySeconds)
+		reg.register(defaultMetrics.CacheAgeSeconds)
+		reg.register(defaultMetrics.NormalizationMisses)
+		reg.register(defaultMetrics.NormalizationLookupsTotal)
+		reg.register(defaultMetrics.InstasellFillTimeSeconds)
+		reg.register(defaultMetrics.BuyOrderFillTimeSeconds)
+		reg.register(defaultMetrics.ZeroPriceLookups)
+		reg.register(defaultMetrics.CellParseErrors)
+		reg.register(defaultMetrics.CellParseErrorsByPosition)
+		reg.register(defaultMetrics.FillRequestsTotal)
+		reg.register(defaultMetrics.FillDurationSeconds)
+		reg.register(defaultMetrics.ExpandDepth)
+		reg.register(defaultMetrics.ExpandCyclesDetectedTotal)
+		reg.register(defaultMetrics.IngredientsProcessedTotal)
+		reg.register(defaultMetrics.CalculationWarningsTotal)
+		reg.register(defaultMetrics.ApiCacheAgeSeconds)
+		reg.register(defaultMetrics.MetricsCacheAgeSeconds)
+		reg.register(defaultMetrics.TotalCostSummary)
+		reg.register(defaultMetrics.SlowestFillTimeSummary)
+		reg.register(defaultMetrics.CostAnalysisOutcomesTotal)
+		reg.register(defaultMetrics.BestCostMethodTotal)
+		reg.register(defaultMetrics.BaseIngredientsMapSize)
+		reg.register(defaultMetrics.CalculateRequestsTotal)
+		reg.register(defaultMetrics.CalculateLatencySeconds)
+		reg.register(defaultMetrics.ExpansionDepth)
+		reg.register(defaultMetrics.IngredientCostErrorsTotal)
+		reg.register(defaultMetrics.BazaarItemsTracked)
+		reg.register(defaultMetrics.MetricsIngestLinesTotal)
+		reg.register(defaultMetrics.BazaarConditionalFetchesTotal)
+		reg.register(defaultMetrics.C10MErrorsTotal)
+		reg.register(defaultMetrics.IngredientsWithNaNTotal)
+		reg.register(defaultMetrics.OptimizerLastRunItemsScanned)
+		reg.register(defaultMetrics.FillTimeLastBuyMovingWeek)
+		reg.register(defaultMetrics.FillTimeLastSupplyRate)
+		reg.register(defaultMetrics.FillTimeLastDemandRate)
+		reg.register(defaultMetrics.FillTimeLastDeltaNetFlow)
+		reg.register(defaultMetrics.FillTimeLastCalculatedRR)
+		reg.register(defaultMetrics.FillTimeErrorsTotal)
+	})
+	return defaultMetrics
+}
+
+// MetricsHandler returns the /metrics HTTP handler for the registry that
+// DefaultMetrics was initialized with; if DefaultMetrics hasn't been called
+// yet, it first calls DefaultMetrics(nil), registering every metric on a
+// fresh Registry.
+func MetricsHandler() http.Handler {
+	if defaultRegistry == nil {
+		DefaultMetrics(nil)
+	}
+	return defaultRegistry.HTTPHandler()
+}
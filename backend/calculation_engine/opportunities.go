@@ -0,0 +1,93 @@
+// opportunities.go
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// opportunitySortMetric scores one ItemRank for the /opportunities ?sort=
+// query param. Kept distinct from wizSortMetrics (wizserver.go) since
+// "margin" here means margin percentage (ROI), not absolute margin - the
+// field named "margin" in wizSortMetrics.
+type opportunitySortMetric func(r ItemRank) float64
+
+var opportunitySortMetrics = map[string]opportunitySortMetric{
+	"profit": func(r ItemRank) float64 { return r.Profit },
+	"rate": func(r ItemRank) float64 {
+		if r.SlowestFillTimeSecs <= 0 {
+			return r.Profit * 3600
+		}
+		return r.Profit / (r.SlowestFillTimeSecs / 3600)
+	},
+	"margin": func(r ItemRank) float64 {
+		if r.Capital <= 0 {
+			return 0
+		}
+		return r.Profit / r.Capital * 100
+	},
+}
+
+const defaultOpportunitiesLimit = 50
+
+// opportunitiesHandler serves GET /opportunities?sort=profit|rate|margin
+// &limit=50&minRR=<x>: wizItemsRanker's cached scan filtered down to items
+// whose craft is actually profitable (craftingCost < directSellPrice, i.e.
+// Profit > 0) and, if minRR is set, whose sell-side TopLevelRR meets it,
+// sorted by the requested metric. Reuses wizItemsRanker (wizserver.go)
+// rather than running a second full-catalog scan on its own timer.
+func opportunitiesHandler(w http.ResponseWriter, r *http.Request) {
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = "profit"
+	}
+	scoreFn, ok := opportunitySortMetrics[sortKey]
+	if !ok {
+		http.Error(w, "unknown sort metric: "+sortKey, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultOpportunitiesLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > maxWizItemsLimit {
+		limit = maxWizItemsLimit
+	}
+
+	minRR := 0.0
+	if raw := r.URL.Query().Get("minRR"); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+			minRR = parsed
+		}
+	}
+
+	var opportunities []ItemRank
+	for _, item := range wizItemsRanker.Current() {
+		if item.Profit <= 0 {
+			continue
+		}
+		if minRR > 0 && item.RR < minRR {
+			continue
+		}
+		opportunities = append(opportunities, item)
+	}
+	sort.SliceStable(opportunities, func(i, j int) bool { return scoreFn(opportunities[i]) > scoreFn(opportunities[j]) })
+	markWizSort()
+	if limit < len(opportunities) {
+		opportunities = opportunities[:limit]
+	}
+
+	writeWizItems(w, r, sortKey, opportunities)
+}
+
+// opportunitiesStatusHandler serves GET /opportunities/status: the scan's
+// warm-up progress (0-100, see ProfitRanker.Progress) and the timestamp of
+// the last full rescan, so a client can show a progress bar while the first
+// catalog-wide scan is still running.
+func opportunitiesStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeWizStatus(w, r, wizItemsRanker.Progress(), wizItemsRanker.LastFullScan())
+}
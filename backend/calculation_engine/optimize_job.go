@@ -0,0 +1,466 @@
+// optimize_job.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The backlog names the handler this refactors "optimizerApiHandler" and
+// frames the job subsystem after gilgetter's priceUpdateQueue/
+// priceUpdateProgress; this repo has neither name (RunFullOptimization has
+// never been wired to an HTTP handler directly, only to the `wiz optimize`
+// CLI subcommand), so there's no existing blocking handler to refactor. This
+// file adds the job-queue subsystem the request describes from scratch,
+// mirroring expandJob's (expand_job.go) queued/running/done/error lifecycle
+// and registry pattern - the same shape this package already uses for
+// another long-running, poll-or-cancel operation - with progress/ETA and
+// cancellation layered on via RunFullOptimization's config.ProgressCallback
+// and ctx parameter.
+
+// OptimizeJobStatus is the lifecycle state of one optimizeJob.
+type OptimizeJobStatus string
+
+const (
+	OptimizeJobQueued    OptimizeJobStatus = "queued"
+	OptimizeJobRunning   OptimizeJobStatus = "running"
+	OptimizeJobDone      OptimizeJobStatus = "done"
+	OptimizeJobError     OptimizeJobStatus = "error"
+	OptimizeJobCancelled OptimizeJobStatus = "cancelled"
+)
+
+// maxConcurrentOptimizeJobs caps how many RunFullOptimization sweeps run at
+// once - each one already fans out across its own OptimizationConfig.Workers
+// goroutines, so letting too many run concurrently would multiply that
+// fan-out unboundedly. A job submitted past the cap stays OptimizeJobQueued
+// until optimizeJobSem has a free slot, mirroring gilgetter's
+// priceUpdateQueue description in the request body.
+const maxConcurrentOptimizeJobs = 2
+
+var optimizeJobSem = make(chan struct{}, maxConcurrentOptimizeJobs)
+
+// maxQueuedOptimizeJobs bounds how many not-yet-finished jobs the registry
+// will hold at once, so a client can't flood the server with submissions
+// that all sit waiting on optimizeJobSem forever.
+const maxQueuedOptimizeJobs = 16
+
+// optimizeJobTTL bounds how long a finished job's results stay in
+// optimizeJobRegistry before sweepExpiredOptimizeJobsLocked reclaims it,
+// mirroring expandJobTTL.
+const optimizeJobTTL = 30 * time.Minute
+
+// optimizeJobProgressInterval is how often RunFullOptimization's
+// ProgressCallback is allowed to update optimizeJob's progress fields -
+// every item individually would mean a lock acquisition per item across
+// every worker; this batches it to every N completions instead, per the
+// request's "report progress every N items processed".
+const optimizeJobProgressInterval = 5
+
+// optimizeJob is one POST /api/optimize-all submission. RunFullOptimization
+// runs in its own goroutine (startOptimizeJob); cancel propagates into the
+// ctx threaded through to it, all the way down to each item's
+// PerformDualExpansion call, the same way expandJob's analog would if it
+// needed mid-flight cancellation.
+type optimizeJob struct {
+	ID        string
+	ItemIDs   []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu           sync.Mutex
+	status       OptimizeJobStatus
+	startedAt    time.Time
+	completed    int
+	total        int
+	lastItem     string
+	lastReported int
+	results      []OptimizedItemResult
+	summary      BatchSummary
+	errMessage   string
+}
+
+func (j *optimizeJob) setRunning() {
+	j.mu.Lock()
+	j.status = OptimizeJobRunning
+	j.startedAt = time.Now()
+	j.mu.Unlock()
+}
+
+// reportProgress records done/total/currentItem unconditionally, taking
+// j.mu itself so concurrent callers need no outside serialization.
+// startOptimizeJob installs throttledReportProgress, not this, as the
+// sweep's ProgressCallback.
+func (j *optimizeJob) reportProgress(done, total int, currentItem string) {
+	j.mu.Lock()
+	j.completed = done
+	j.total = total
+	j.lastItem = currentItem
+	j.mu.Unlock()
+}
+
+// throttledReportProgress is reportProgress gated by interval: it only
+// updates j's snapshot (and advances j.lastReported) once done has moved at
+// least interval past the last reported value, or once done reaches total.
+// The gate check and the update it guards happen under the same j.mu lock,
+// so concurrent callers - same as reportProgress's own callers - can't race
+// on the throttle state the way a caller-local "lastReported" variable would.
+func (j *optimizeJob) throttledReportProgress(done, total int, currentItem string, interval int) {
+	j.mu.Lock()
+	report := done-j.lastReported >= interval || done == total
+	if report {
+		j.lastReported = done
+		j.completed = done
+		j.total = total
+		j.lastItem = currentItem
+	}
+	j.mu.Unlock()
+}
+
+func (j *optimizeJob) finish(results []OptimizedItemResult, summary BatchSummary, err error) {
+	j.mu.Lock()
+	j.results = results
+	j.summary = summary
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.status = OptimizeJobCancelled
+		j.errMessage = "job cancelled"
+	case err != nil:
+		j.status = OptimizeJobError
+		j.errMessage = err.Error()
+	default:
+		j.status = OptimizeJobDone
+	}
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// optimizeJobSnapshot is a point-in-time copy of everything
+// optimizeJobHandler/optimizeJobResultsHandler need, taken under j.mu so
+// callers never read a torn mix of fields.
+type optimizeJobSnapshot struct {
+	status     OptimizeJobStatus
+	startedAt  time.Time
+	completed  int
+	total      int
+	results    []OptimizedItemResult
+	summary    BatchSummary
+	errMessage string
+}
+
+func (j *optimizeJob) snapshot() optimizeJobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return optimizeJobSnapshot{
+		status: j.status, startedAt: j.startedAt,
+		completed: j.completed, total: j.total,
+		results: j.results, summary: j.summary, errMessage: j.errMessage,
+	}
+}
+
+var optimizeJobRegistry = struct {
+	mu   sync.Mutex
+	jobs map[string]*optimizeJob
+}{jobs: make(map[string]*optimizeJob)}
+
+var optimizeJobSeq uint64
+
+// nextOptimizeJobID returns a process-unique job ID, the same nanosecond-
+// timestamp-plus-counter shape nextExpandJobID uses.
+func nextOptimizeJobID() string {
+	return fmt.Sprintf("opt-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&optimizeJobSeq, 1))
+}
+
+// sweepExpiredOptimizeJobsLocked drops every job past its ExpiresAt. Called
+// with optimizeJobRegistry.mu held, on every registration.
+func sweepExpiredOptimizeJobsLocked() {
+	now := time.Now()
+	for id, job := range optimizeJobRegistry.jobs {
+		if now.After(job.ExpiresAt) {
+			delete(optimizeJobRegistry.jobs, id)
+		}
+	}
+}
+
+// activeOptimizeJobCountLocked counts jobs that haven't reached a terminal
+// status yet, for enforcing maxQueuedOptimizeJobs. Called with
+// optimizeJobRegistry.mu held.
+func activeOptimizeJobCountLocked() int {
+	active := 0
+	for _, job := range optimizeJobRegistry.jobs {
+		select {
+		case <-job.done:
+		default:
+			active++
+		}
+	}
+	return active
+}
+
+func registerOptimizeJob(job *optimizeJob) error {
+	optimizeJobRegistry.mu.Lock()
+	defer optimizeJobRegistry.mu.Unlock()
+	sweepExpiredOptimizeJobsLocked()
+	if activeOptimizeJobCountLocked() >= maxQueuedOptimizeJobs {
+		return fmt.Errorf("too many optimize jobs already queued or running (max %d)", maxQueuedOptimizeJobs)
+	}
+	optimizeJobRegistry.jobs[job.ID] = job
+	return nil
+}
+
+func getOptimizeJob(id string) *optimizeJob {
+	optimizeJobRegistry.mu.Lock()
+	defer optimizeJobRegistry.mu.Unlock()
+	return optimizeJobRegistry.jobs[id]
+}
+
+// startOptimizeJob registers a new job and kicks off RunFullOptimization in
+// its own goroutine once optimizeJobSem has a free slot (the job stays
+// OptimizeJobQueued until then), mirroring startExpandJob's shape. Returns
+// an error (and no job) when maxQueuedOptimizeJobs is already reached.
+func startOptimizeJob(itemIDs []string, maxAllowedFillTime float64, maxPossibleInitialQtyPerItem float64, config OptimizationConfig) (*optimizeJob, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &optimizeJob{
+		ID:        nextOptimizeJobID(),
+		ItemIDs:   itemIDs,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(optimizeJobTTL),
+		cancel:    cancel,
+		status:    OptimizeJobQueued,
+		total:     len(itemIDs),
+		done:      make(chan struct{}),
+	}
+	if err := registerOptimizeJob(job); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case optimizeJobSem <- struct{}{}:
+			defer func() { <-optimizeJobSem }()
+		case <-ctx.Done():
+			job.finish(nil, computeBatchSummary(nil), ctx.Err())
+			return
+		}
+
+		job.setRunning()
+
+		apiResp, err := WaitForFreshData()
+		if err != nil && !errors.Is(err, ErrStale) {
+			job.finish(nil, computeBatchSummary(nil), fmt.Errorf("bazaar data unavailable: %w", err))
+			return
+		}
+		metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+		progressConfig := config
+		progressConfig.ProgressCallback = func(done, total int, currentItem string) {
+			job.throttledReportProgress(done, total, currentItem, optimizeJobProgressInterval)
+			if config.ProgressCallback != nil {
+				config.ProgressCallback(done, total, currentItem)
+			}
+		}
+
+		results, summary := RunFullOptimization(ctx, itemIDs, maxAllowedFillTime, apiResp, metricsMap, defaultItemFilesDir, maxPossibleInitialQtyPerItem, progressConfig)
+		job.finish(results, summary, ctx.Err())
+	}()
+
+	return job, nil
+}
+
+// OptimizeJobSnapshotResponse is GET /api/jobs/{id}'s response body.
+type OptimizeJobSnapshotResponse struct {
+	JobID             string  `json:"job_id"`
+	Status            string  `json:"status"`
+	Percent           float64 `json:"percent"`
+	StartedAt         string  `json:"started_at,omitempty"`
+	ETASeconds        float64 `json:"eta_seconds,omitempty"`
+	PartialResultsURL string  `json:"partial_results_url"`
+	ErrorMessage      string  `json:"error_message,omitempty"`
+}
+
+// optimizeAllRequest is POST /api/optimize-all's JSON body. An empty ItemIDs
+// sweeps every product in the current Bazaar snapshot, matching `wiz
+// optimize`'s CLI default of optimizing everything.
+type optimizeAllRequest struct {
+	ItemIDs                      []string `json:"item_ids"`
+	MaxAllowedFillTime           float64  `json:"max_allowed_fill_time"`
+	MaxPossibleInitialQtyPerItem float64  `json:"max_possible_initial_qty_per_item"`
+	Workers                      int      `json:"workers"`
+	RequestsPerSecond            float64  `json:"requests_per_second"`
+}
+
+// filterByConfidence drops any itemID whose ProductMetrics entry in
+// metricsMap falls below minConfidence, with maxAgeSecs as the fresh/stale
+// cutoff handed to confidenceLevelFor (<= 0 falls back to its default), so a
+// sweep's "profit" results aren't driven by stale order books. An item
+// missing from metricsMap entirely only survives when minConfidence permits
+// ConfidenceMissing. With minConfidence == "" nothing is dropped, whatever
+// maxAgeSecs is.
+func filterByConfidence(itemIDs []string, metricsMap map[string]ProductMetrics, maxAgeSecs float64, minConfidence ConfidenceLevel) []string {
+	if maxAgeSecs <= 0 && minConfidence == "" {
+		return itemIDs
+	}
+	rank := map[ConfidenceLevel]int{ConfidenceMissing: 0, ConfidenceStale: 1, ConfidenceFresh: 2}
+	out := make([]string, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		pm, ok := safeGetMetricsData(metricsMap, BAZAAR_ID(id))
+		ageSecs, _ := metricsAgeAndConfidence(pm, ok)
+		level := confidenceLevelFor(ageSecs, maxAgeSecs)
+		if minConfidence != "" && rank[level] < rank[minConfidence] {
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// defaultOptimizeMaxAllowedFillTime matches findMaxQuantityForTimeConstraint's
+// own "a full day" fallback elsewhere in this package for an unset fill-time
+// constraint.
+const defaultOptimizeMaxAllowedFillTime = 86400.0
+
+// optimizeAllHandler implements POST /api/optimize-all: it starts a
+// background RunFullOptimization sweep and returns the new job's ID
+// immediately instead of blocking the request for the sweep's duration.
+func optimizeAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// An empty body is valid (sweep every product with defaults); anything
+	// else malformed is a client error.
+	var req optimizeAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	itemIDs := req.ItemIDs
+	if len(itemIDs) == 0 {
+		itemIDs = make([]string, 0, len(apiResp.Products))
+		for id := range apiResp.Products {
+			itemIDs = append(itemIDs, id)
+		}
+	}
+
+	maxAgeSecs := queryFloatDefault(r, "max_age_secs", 0)
+	minConfidence := ConfidenceLevel(r.URL.Query().Get("min_confidence"))
+	if maxAgeSecs > 0 || minConfidence != "" {
+		metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+		itemIDs = filterByConfidence(itemIDs, metricsMap, maxAgeSecs, minConfidence)
+	}
+
+	maxAllowedFillTime := req.MaxAllowedFillTime
+	if maxAllowedFillTime <= 0 {
+		maxAllowedFillTime = defaultOptimizeMaxAllowedFillTime
+	}
+
+	job, startErr := startOptimizeJob(itemIDs, maxAllowedFillTime, req.MaxPossibleInitialQtyPerItem, OptimizationConfig{
+		Workers:           req.Workers,
+		RequestsPerSecond: req.RequestsPerSecond,
+	})
+	if startErr != nil {
+		http.Error(w, startErr.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		JobID  string `json:"job_id"`
+		Status string `json:"status"`
+	}{JobID: job.ID, Status: string(OptimizeJobQueued)})
+}
+
+// jobIDFromOptimizeJobsPath splits "/api/jobs/{id}" and "/api/jobs/{id}/results"
+// into the bare job ID and whether the /results suffix was present.
+func jobIDFromOptimizeJobsPath(urlPath string) (id string, wantResults bool) {
+	rest := strings.TrimPrefix(urlPath, "/api/jobs/")
+	wantResults = strings.HasSuffix(rest, "/results")
+	id = strings.TrimSuffix(rest, "/results")
+	return id, wantResults
+}
+
+// optimizeJobHandler implements GET /api/jobs/{id} (progress snapshot),
+// GET /api/jobs/{id}/results (accumulated-or-final results), and
+// DELETE /api/jobs/{id} (cancellation).
+func optimizeJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, wantResults := jobIDFromOptimizeJobsPath(r.URL.Path)
+	if jobID == "" {
+		http.Error(w, "missing job id", http.StatusBadRequest)
+		return
+	}
+	job := getOptimizeJob(jobID)
+	if job == nil {
+		http.Error(w, "job not found (expired or never existed)", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		job.cancel()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case http.MethodGet:
+		if wantResults {
+			snap := job.snapshot()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				JobID   string                `json:"job_id"`
+				Status  string                `json:"status"`
+				Results []OptimizedItemResult `json:"results"`
+				Summary BatchSummary          `json:"summary"`
+			}{JobID: job.ID, Status: string(snap.status), Results: snap.results, Summary: snap.summary})
+			return
+		}
+		writeOptimizeJobSnapshot(w, job)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeOptimizeJobSnapshot writes job's current OptimizeJobSnapshotResponse.
+// ETASeconds extrapolates linearly from elapsed time and completed/total -
+// the same "rate so far, projected forward" estimate a caller would compute
+// by hand from percent and started_at, just precomputed for convenience.
+func writeOptimizeJobSnapshot(w http.ResponseWriter, job *optimizeJob) {
+	snap := job.snapshot()
+
+	resp := OptimizeJobSnapshotResponse{
+		JobID:             job.ID,
+		Status:            string(snap.status),
+		PartialResultsURL: fmt.Sprintf("/api/jobs/%s/results", job.ID),
+		ErrorMessage:      snap.errMessage,
+	}
+	if snap.total > 0 {
+		resp.Percent = 100 * float64(snap.completed) / float64(snap.total)
+	}
+	if !snap.startedAt.IsZero() {
+		resp.StartedAt = snap.startedAt.Format(time.RFC3339)
+		if snap.completed > 0 && snap.completed < snap.total {
+			elapsed := time.Since(snap.startedAt).Seconds()
+			ratePerItem := elapsed / float64(snap.completed)
+			resp.ETASeconds = ratePerItem * float64(snap.total-snap.completed)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,122 @@
+// optimize_stream.go
+package main
+
+// GET /api/optimize-all/stream is the SSE counterpart to POST
+// /api/optimize-all (optimize_job.go): that endpoint only reports coarse
+// progress because a client has to poll GET /api/jobs/{id} for it. This
+// handler instead pushes one "event: item" frame per OptimizedItemResult as
+// RunFullOptimizationStream finishes it, occasional "event: progress" frames,
+// and a final "event: summary" frame once every item has been scanned -
+// sub-second first-byte feedback instead of buffering the full sweep before
+// writing anything, and a client can cancel mid-stream simply by closing the
+// connection (r.Context() is cancelled, which RunFullOptimizationStream
+// already respects).
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// optimizeStreamProgressEvent is one "event: progress" frame.
+type optimizeStreamProgressEvent struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// optimizeStreamSummaryEvent is the terminal "event: summary" frame.
+type optimizeStreamSummaryEvent struct {
+	Summary BatchSummary `json:"summary"`
+}
+
+// optimizeAllStreamHandler serves GET /api/optimize-all/stream. Query params
+// mirror POST /api/optimize-all's JSON body field-for-field (there being no
+// request body to decode for a GET): items (comma-separated; empty sweeps
+// every product in the current Bazaar snapshot, same default as the
+// non-streaming handler), max_allowed_fill_time, max_possible_initial_qty_per_item,
+// workers, requests_per_second, plus max_age_secs/min_confidence to
+// pre-filter the sweep exactly like optimizeAllHandler does.
+func optimizeAllStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+
+	var itemIDs []string
+	if raw := r.URL.Query().Get("items"); raw != "" {
+		itemIDs = strings.Split(raw, ",")
+	} else {
+		itemIDs = make([]string, 0, len(apiResp.Products))
+		for id := range apiResp.Products {
+			itemIDs = append(itemIDs, id)
+		}
+	}
+
+	maxAgeSecs := queryFloatDefault(r, "max_age_secs", 0)
+	minConfidence := ConfidenceLevel(r.URL.Query().Get("min_confidence"))
+	if maxAgeSecs > 0 || minConfidence != "" {
+		itemIDs = filterByConfidence(itemIDs, metricsMap, maxAgeSecs, minConfidence)
+	}
+
+	maxAllowedFillTime := queryFloatDefault(r, "max_allowed_fill_time", defaultOptimizeMaxAllowedFillTime)
+	maxPossibleInitialQtyPerItem := queryFloatDefault(r, "max_possible_initial_qty_per_item", 0)
+	workers := queryIntDefault(r, "workers", 0, 0, 1<<30)
+	requestsPerSecond := queryFloatDefault(r, "requests_per_second", 0)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if len(itemIDs) == 0 {
+		writeSSE(w, flusher, "summary", optimizeStreamSummaryEvent{Summary: computeBatchSummary(nil)})
+		return
+	}
+
+	config := OptimizationConfig{Workers: workers, RequestsPerSecond: requestsPerSecond}
+	resultsCh, errCh := RunFullOptimizationStream(r.Context(), itemIDs, maxAllowedFillTime, apiResp, metricsMap, defaultItemFilesDir, maxPossibleInitialQtyPerItem, config)
+
+	var results []OptimizedItemResult
+	done := 0
+	for res := range resultsCh {
+		results = append(results, res)
+		done++
+		writeSSE(w, flusher, "item", res)
+		if done%optimizeJobProgressInterval == 0 || done == len(itemIDs) {
+			writeSSE(w, flusher, "progress", optimizeStreamProgressEvent{Done: done, Total: len(itemIDs)})
+		}
+	}
+	if streamErr := <-errCh; streamErr != nil {
+		writeSSE(w, flusher, "error", struct {
+			Error string `json:"error"`
+		}{Error: streamErr.Error()})
+	}
+
+	writeSSE(w, flusher, "summary", optimizeStreamSummaryEvent{Summary: computeBatchSummary(results)})
+}
+
+// writeSSE marshals payload as one SSE "event: <name>" frame and flushes it
+// immediately, the same framing expandDualStreamHandler uses for its own
+// "event"/"result" frames.
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flusher.Flush()
+}
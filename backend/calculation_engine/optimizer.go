@@ -2,10 +2,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
+	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // OptimizedItemResult uses JSONFloat64 for NaN-able fields
@@ -24,17 +29,148 @@ type OptimizedItemResult struct {
 	ErrorMessage                string            `json:"error_message,omitempty"`
 	RecipeTree                  *CraftingStepNode `json:"recipe_tree,omitempty"` // Will be nil in final JSON output
 	MaxRecipeDepth              int               `json:"max_recipe_depth,omitempty"`
+
+	// Freshness of the ProductMetrics entries behind this result, sourced
+	// from the final expansion's BaseIngredientDetail.MetricsAgeSeconds (see
+	// staleness.go). OldestInputAgeSeconds is the largest age across every
+	// base ingredient; BottleneckIngredientAgeSeconds is just the one for
+	// BottleneckIngredient. Both are NaN when no ingredient carried age data.
+	OldestInputAgeSeconds float64 `json:"oldest_input_age_seconds"`
+	// Thin-liquidity items can go hours between sales, so stale metrics can
+	// mislead the optimizer into planning a quantity the market will never
+	// absorb; StalenessPenaltyApplied is the exp(-age/confidenceHalfLife)
+	// multiplier (1.0 = no penalty, fresher than one half-life) applied to
+	// MaxProfit below to discount for that risk.
+	BottleneckIngredientAgeSeconds float64 `json:"bottleneck_ingredient_age_seconds"`
+	StalenessPenaltyApplied        float64 `json:"staleness_penalty_applied"`
+
+	// Risk metrics derived from the item's ProductMetrics.PriceHistory (see
+	// metrics.go), all NaN when fewer than 2 samples are on record.
+	// PriceStdDev/PriceZScore describe how unusual the instasell price used
+	// for RevenueAtOptimalQty is relative to its recent history;
+	// WorstCaseProfit substitutes a pessimistic sell price (mean -
+	// worstCaseProfitKStdDev*stddev) for that instasell price; SharpeLikeRatio
+	// is MaxProfit per unit of price risk taken on (profit divided by the
+	// dollar stddev across the whole traded quantity), so two items with the
+	// same MaxProfit rank apart if one's price is far more volatile.
+	PriceStdDev     float64 `json:"price_stddev"`
+	PriceZScore     float64 `json:"price_z_score"`
+	WorstCaseProfit float64 `json:"worst_case_profit"`
+	SharpeLikeRatio float64 `json:"sharpe_like_ratio"`
+
+	// Secondary objective functions for OptimizationSortMode, both derived
+	// from MaxProfit after staleness discounting. NaN whenever their
+	// denominator is zero, NaN, or negative, per the same "NaNs sink to the
+	// bottom" sort handling every other ratio-like field here gets.
+	ProfitPerSecond float64 `json:"profit_per_second"`
+	ROI             float64 `json:"roi"`
+
+	// Alternate-recipe-path comparison (path_enumerator.go): ChosenPathID is
+	// whichever PathSummary in AlternatePaths won on profit at
+	// MaxFeasibleQuantity, PathSelectionReason is a short human-readable
+	// note on why, and every field above (Cost/Revenue/MaxProfit/etc.) is
+	// already populated from the winning path - not necessarily
+	// PrimaryBased. AlternatePaths carries no RecipeTree, so it doesn't
+	// reintroduce the RAM cost RecipeTree above was stripped to avoid.
+	ChosenPathID        string        `json:"chosen_path_id,omitempty"`
+	AlternatePaths      []PathSummary `json:"alternate_paths,omitempty"`
+	PathSelectionReason string        `json:"path_selection_reason,omitempty"`
+}
+
+// Risk-metric knobs for the PriceHistory-derived fields on
+// OptimizedItemResult below: plain package-level vars, same convention as
+// staleness.go's confidenceHalfLife, so a caller can tune them without a
+// config object.
+var (
+	// worstCaseProfitKStdDev is how many standard deviations below the mean
+	// price WorstCaseProfit assumes the item actually sells for.
+	worstCaseProfitKStdDev = 2.0
+	// maxInstasellDepthFraction caps how much of the current instasell
+	// order-book depth a planned quantity may consume before
+	// optimizeItemProfit starts discounting the excess for slippage.
+	maxInstasellDepthFraction = 0.30
+)
+
+// instasellDepth sums the Amount across every resting buy order for
+// itemIDNorm - the order-book side an instasell crosses against - as a
+// rough measure of how much quantity the market can currently absorb near
+// instasellPrice before slippage sets in.
+func instasellDepth(apiResp *HypixelAPIResponse, itemIDNorm string) float64 {
+	prod, ok := safeGetProductData(apiResp, itemIDNorm)
+	if !ok {
+		return 0
+	}
+	depth := 0.0
+	for _, order := range prod.BuySummary {
+		depth += float64(order.Amount)
+	}
+	return depth
 }
 
-// Helper to safely get P1 error message from DualExpansionResult
-func safeGetP1Error(dr *DualExpansionResult) string {
-	if dr == nil {
-		return "DualResult nil"
+// timeConstraintFeasible reports whether dr's combined acquisition+sale time
+// satisfies maxAllowedFillTime, treating NaN times as infinite (the same
+// handling findMaxQuantityForTimeConstraint has always used).
+func timeConstraintFeasible(dr *DualExpansionResult, maxAllowedFillTime float64) bool {
+	if dr == nil || !dr.PrimaryBased.CalculationPossible {
+		return false
+	}
+	acqTime := float64(dr.PrimaryBased.SlowestIngredientBuyTimeSeconds)
+	saleTime := float64(dr.TopLevelInstasellTimeSeconds)
+	if math.IsNaN(acqTime) {
+		acqTime = math.Inf(1)
+	}
+	if math.IsNaN(saleTime) {
+		saleTime = math.Inf(1)
+	}
+	total := acqTime + saleTime
+	return total <= maxAllowedFillTime && total >= 0
+}
+
+// qtyProfit is a lightweight revenue-minus-cost estimate for a feasibility
+// boundary candidate, mirroring sampleProfitAtQty's formula (profit_curve.go)
+// but reusing an already-fetched DualExpansionResult instead of spending
+// another PerformDualExpansion call. Returns NaN when cost or the instasell
+// price aren't usable, so such a candidate sinks to the bottom of the
+// max-profit comparison instead of winning it by default.
+func qtyProfit(dr *DualExpansionResult, apiResp *HypixelAPIResponse, itemNameNorm string, qty float64) float64 {
+	if dr == nil || !dr.PrimaryBased.CalculationPossible {
+		return math.NaN()
+	}
+	cost := float64(dr.PrimaryBased.TotalCost)
+	if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return math.NaN()
+	}
+	instasellPrice := getBuyPrice(apiResp, itemNameNorm)
+	if instasellPrice <= 0 || math.IsNaN(instasellPrice) || math.IsInf(instasellPrice, 0) {
+		return math.NaN()
 	}
-	return dr.PrimaryBased.ErrorMessage
+	return instasellPrice*qty - cost
 }
 
+// feasibilityBoundary is the exact upper edge of one contiguous feasible
+// interval found by findMaxQuantityForTimeConstraint's probe pass, paired
+// with the DualExpansionResult PerformDualExpansion returned for it so
+// callers don't have to re-expand to score it.
+type feasibilityBoundary struct {
+	qty int64
+	dr  *DualExpansionResult
+}
+
+// findMaxQuantityForTimeConstraint finds the quantity of itemName that
+// maximizes profit while keeping total cycle time (acquisition + sale)
+// within maxAllowedFillTime.
+//
+// A plain binary search assumes cycle time is monotonically increasing in
+// quantity, but bulk-buy order fill behavior and recipe branching can make
+// it non-monotone: a mid-tier qty can exceed the constraint while both a
+// smaller and a larger qty satisfy it, or vice-versa. So instead this probes
+// a logarithmically-spaced set of quantities across [1, maxPossibleQty],
+// bisects within each resulting run of feasible probes to pin down that
+// run's exact upper feasibility boundary, and returns whichever boundary has
+// the highest profit rather than simply the highest quantity.
 func findMaxQuantityForTimeConstraint(
+	ctx context.Context,
+	limiter *intervalRateLimiter, // Gates PerformDualExpansion calls; nil means unlimited.
 	itemName string,
 	maxAllowedFillTime float64,
 	apiResp *HypixelAPIResponse,
@@ -49,76 +185,166 @@ func findMaxQuantityForTimeConstraint(
 		dlog("  Optimizer Search: maxPossibleQty (%.2f) is less than 1. Cannot find feasible quantity. Returning 0.", maxPossibleQty)
 		return 0.0, nil // No search possible or meaningful if upper bound is less than 1
 	}
-
-	low := 1.0
-	high := math.Floor(maxPossibleQty) // Ensure high is an integer and within bounds
-	if high < low {                    // If maxPossibleQty was < 1, high might be < low
-		high = low
+	maxQty := int64(math.Floor(maxPossibleQty))
+	if maxQty < 1 {
+		maxQty = 1
 	}
 
-	bestQty := 0.0 // Stores the highest quantity found so far that meets the time constraint
 	iterations := 0
-	const maxIterations = 50 // Limit iterations to prevent infinite loops in edge cases
+	const maxIterations = 50 // Limit iterations to prevent infinite loops in edge cases; shared across every probe and bisection below.
+	budgetExhausted := false
+	runningBestQty := 0.0 // Highest feasible qty confirmed so far, for the cancellation fallback below.
 
-	for iterations < maxIterations && high >= low {
+	cache := make(map[int64]*DualExpansionResult)
+	// expand fetches (and memoizes) PerformDualExpansion for qty so the
+	// bisection passes below don't re-expand a quantity the probe pass
+	// already evaluated. A cache hit doesn't count against maxIterations;
+	// once that cap is hit, not-yet-probed quantities are conservatively
+	// treated as infeasible rather than expanded.
+	expand := func(qty int64) (*DualExpansionResult, error) {
+		if dr, ok := cache[qty]; ok {
+			return dr, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if iterations >= maxIterations {
+			budgetExhausted = true
+			return nil, nil
+		}
 		iterations++
-		midQty := math.Floor(low + (high-low)/2) // Calculate midpoint, ensure integer
-		if midQty < 1 {                          // Should not happen if low is 1, but defensive
-			midQty = 1
+		limiter.Wait()
+		dr, err := PerformDualExpansion(ctx, itemNameNorm, float64(qty), apiResp, metricsMap, itemFilesDir, false, PrecisionFloat, ExpansionOptions{})
+		if err != nil {
+			dlog("  Optimizer Search: Error in PerformDualExpansion for %s Qty %d: %v. Treating as infeasible.", itemNameNorm, qty, err)
+			cache[qty] = nil
+			return nil, nil
 		}
+		cache[qty] = dr
+		return dr, nil
+	}
 
-		// Convergence/Stuck check
-		if iterations > 1 && midQty <= low && low >= high && bestQty == midQty {
-			// If midQty is not advancing and is same as bestQty, likely converged
-			dlog("  Optimizer Search: Converged or stuck at Low=%.0f, High=%.0f, MidQty=%.0f, BestQty=%.0f. Breaking.", low, high, midQty, bestQty)
-			break
+	// Step 1: probe numProbes logarithmically-spaced quantities across
+	// [1, maxQty] so a non-monotonic feasibility curve doesn't fool a plain
+	// binary search.
+	const numProbes = 12
+	lnMax := math.Log(float64(maxQty))
+	seen := make(map[int64]bool, numProbes)
+	probes := make([]int64, 0, numProbes)
+	for i := 0; i < numProbes; i++ {
+		frac := float64(i) / float64(numProbes-1)
+		q := int64(math.Floor(math.Exp(frac * lnMax)))
+		if q < 1 {
+			q = 1
 		}
-		if midQty == low && midQty == high && iterations > 5 { // Stuck on a single value for too long
-			dlog("  Optimizer Search: Stuck on MidQty=%.0f for several iterations. Breaking.", midQty)
-			break
+		if q > maxQty {
+			q = maxQty
 		}
+		if seen[q] {
+			continue
+		}
+		seen[q] = true
+		probes = append(probes, q)
+	}
+	sort.Slice(probes, func(i, j int) bool { return probes[i] < probes[j] })
 
-		dlog("  Optimizer Search: Iter %d, Low=%.0f, High=%.0f, Testing MidQty=%.0f for %s", iterations, low, high, midQty, itemNameNorm)
-
-		// Call PerformDualExpansion with includeTreeInExpansionResult = false (RAM Optimization)
-		dualResult, err := PerformDualExpansion(itemNameNorm, midQty, apiResp, metricsMap, itemFilesDir, false)
+	feasible := make([]bool, len(probes))
+	for i, q := range probes {
+		dr, err := expand(q)
 		if err != nil {
-			dlog("  Optimizer Search: Error in PerformDualExpansion for %s Qty %.0f: %v. Assuming time constraint exceeded (treat as too high).", itemNameNorm, midQty, err)
-			high = midQty - 1 // Treat error as if it's too slow/costly
-			continue
+			dlog("  Optimizer Search: context cancelled for %s after %d iterations (%v). Returning best qty found so far.", itemNameNorm, iterations, err)
+			return sanitizeFloat(runningBestQty), err
 		}
-		if dualResult == nil || !dualResult.PrimaryBased.CalculationPossible {
-			errMsg := safeGetP1Error(dualResult)
-			dlog("  Optimizer Search: P1 calculation not possible for %s Qty %.0f (ErrMsg: %s). Assuming time constraint exceeded.", itemNameNorm, midQty, errMsg)
-			high = midQty - 1 // Treat as too slow/costly
-			continue
+		feasible[i] = timeConstraintFeasible(dr, maxAllowedFillTime)
+		dlog("  Optimizer Search: Probe %s Qty %d -> feasible=%v", itemNameNorm, q, feasible[i])
+		if feasible[i] && float64(q) > runningBestQty {
+			runningBestQty = float64(q)
 		}
+	}
 
-		acquisitionTimeRaw := float64(dualResult.PrimaryBased.SlowestIngredientBuyTimeSeconds)
-		saleTimeRaw := float64(dualResult.TopLevelInstasellTimeSeconds)
+	// Step 2+3: for every contiguous run of feasible probes, bisect between
+	// its last feasible probe and whatever comes next (the following
+	// infeasible probe, or maxQty if the run reaches the end) to pin down
+	// that run's exact upper feasibility boundary.
+	var boundaries []feasibilityBoundary
+	for i := 0; i < len(probes); {
+		if !feasible[i] {
+			i++
+			continue
+		}
+		runEnd := i
+		for runEnd+1 < len(probes) && feasible[runEnd+1] {
+			runEnd++
+		}
 
-		// Handle NaN times as Infinite for comparison
-		if math.IsNaN(acquisitionTimeRaw) {
-			acquisitionTimeRaw = math.Inf(1)
+		lo := probes[runEnd]
+		loDR := cache[lo]
+		hi := maxQty
+		if runEnd+1 < len(probes) {
+			hi = probes[runEnd+1] - 1
 		}
-		if math.IsNaN(saleTimeRaw) {
-			saleTimeRaw = math.Inf(1)
+
+		for lo < hi && !budgetExhausted {
+			mid := lo + (hi-lo+1)/2 // Upper-biased midpoint: lo is known feasible.
+			dr, err := expand(mid)
+			if err != nil {
+				dlog("  Optimizer Search: context cancelled for %s after %d iterations (%v). Returning best qty found so far.", itemNameNorm, iterations, err)
+				return sanitizeFloat(runningBestQty), err
+			}
+			if timeConstraintFeasible(dr, maxAllowedFillTime) {
+				lo = mid
+				loDR = dr
+				if float64(mid) > runningBestQty {
+					runningBestQty = float64(mid)
+				}
+			} else {
+				hi = mid - 1
+			}
 		}
-		totalEffectiveTime := acquisitionTimeRaw + saleTimeRaw
-		dlog("  Optimizer Search: %s Qty %.0f - AcqTime: %.2fs, SaleTime: %.2fs, TotalEffTime: %.2fs (vs MaxAllowed: %.2fs)", itemNameNorm, midQty, acquisitionTimeRaw, saleTimeRaw, totalEffectiveTime, maxAllowedFillTime)
+		boundaries = append(boundaries, feasibilityBoundary{qty: lo, dr: loDR})
+
+		i = runEnd + 1
+	}
+
+	if len(boundaries) == 0 {
+		dlog("Optimizer: No feasible quantity found for %s within Total Cycle Time Constraint %.2f s (after %d iterations).", itemNameNorm, maxAllowedFillTime, iterations)
+		return 0.0, nil
+	}
 
-		if totalEffectiveTime <= maxAllowedFillTime && totalEffectiveTime >= 0 { // Check if it meets the constraint (and not negative infinity)
-			bestQty = midQty // This quantity is feasible
-			low = midQty + 1 // Try for a higher quantity
-		} else { // Time constraint exceeded or invalid time
-			high = midQty - 1 // Quantity is too high, try lower
+	// Step 4: pick the boundary that maximizes profit, not raw quantity.
+	bestQty := int64(0)
+	bestProfit := math.Inf(-1)
+	haveProfit := false
+	for _, b := range boundaries {
+		profit := qtyProfit(b.dr, apiResp, itemNameNorm, float64(b.qty))
+		if math.IsNaN(profit) {
+			continue
+		}
+		if !haveProfit || profit >= bestProfit {
+			bestProfit = profit
+			bestQty = b.qty
+			haveProfit = true
+		}
+	}
+	if !haveProfit {
+		// None of the boundaries had a usable cost/instasell-price figure;
+		// fall back to the largest one, matching the old search's behavior.
+		for _, b := range boundaries {
+			if b.qty > bestQty {
+				bestQty = b.qty
+			}
 		}
 	}
-	dlog("Optimizer: Best feasible quantity for %s (Total Cycle Time Constraint %.2f s): %.0f (after %d iterations)", itemNameNorm, maxAllowedFillTime, bestQty, iterations)
-	return sanitizeFloat(bestQty), nil // SanitizeFloat will handle NaN/Inf if bestQty remained 0.0 (which is fine)
+
+	dlog("Optimizer: Best feasible quantity for %s (Total Cycle Time Constraint %.2f s): %d across %d feasibility interval(s), profit %.2f (after %d iterations)", itemNameNorm, maxAllowedFillTime, bestQty, len(boundaries), bestProfit, iterations)
+	return sanitizeFloat(float64(bestQty)), nil
 }
 
 func optimizeItemProfit(
+	ctx context.Context,
+	limiter *intervalRateLimiter, // Gates PerformDualExpansion calls; nil means unlimited.
 	itemName string,
 	maxAllowedFillTime float64,
 	apiResp *HypixelAPIResponse,
@@ -130,21 +356,29 @@ func optimizeItemProfit(
 	dlog("Optimizer: Optimizing profit for %s (Total Cycle Time Constraint: %.2fs, MaxInitialSearchQty: %.2f)", itemNameNorm, maxAllowedFillTime, maxPossibleInitialQty)
 
 	result := OptimizedItemResult{
-		ItemName:                    itemNameNorm,
-		CalculationPossible:         false, // Default to false
-		MaxFeasibleQuantity:         0,     // Default
-		CostAtOptimalQty:            toJSONFloat64(math.NaN()),
-		RevenueAtOptimalQty:         toJSONFloat64(math.NaN()),
-		MaxProfit:                   toJSONFloat64(math.NaN()),
-		TotalCycleTimeAtOptimalQty:  toJSONFloat64(math.NaN()),
-		AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()),
-		SaleTimeAtOptimalQty:        toJSONFloat64(math.NaN()),
-		RecipeTree:                  nil, // IMPORTANT: Ensure RecipeTree is not stored in the final result
-		MaxRecipeDepth:              0,   // Will be populated if tree is processed
+		ItemName:                       itemNameNorm,
+		CalculationPossible:            false, // Default to false
+		MaxFeasibleQuantity:            0,     // Default
+		CostAtOptimalQty:               toJSONFloat64(math.NaN()),
+		RevenueAtOptimalQty:            toJSONFloat64(math.NaN()),
+		MaxProfit:                      toJSONFloat64(math.NaN()),
+		TotalCycleTimeAtOptimalQty:     toJSONFloat64(math.NaN()),
+		AcquisitionTimeAtOptimalQty:    toJSONFloat64(math.NaN()),
+		SaleTimeAtOptimalQty:           toJSONFloat64(math.NaN()),
+		RecipeTree:                     nil, // IMPORTANT: Ensure RecipeTree is not stored in the final result
+		MaxRecipeDepth:                 0,   // Will be populated if tree is processed
+		OldestInputAgeSeconds:          math.NaN(),
+		BottleneckIngredientAgeSeconds: math.NaN(),
+		StalenessPenaltyApplied:        1.0,
+	}
+
+	if ctx.Err() != nil {
+		result.ErrorMessage = fmt.Sprintf("Optimization cancelled before starting: %v", ctx.Err())
+		return result
 	}
 
 	// Step 1: Find the maximum feasible quantity under the time constraint
-	maxFeasibleQty, errFeasible := findMaxQuantityForTimeConstraint(itemNameNorm, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQty)
+	maxFeasibleQty, errFeasible := findMaxQuantityForTimeConstraint(ctx, limiter, itemNameNorm, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQty)
 	if errFeasible != nil {
 		result.ErrorMessage = fmt.Sprintf("Error finding max feasible quantity: %v", errFeasible)
 		// If findMaxQuantityForTimeConstraint itself errors, we might not have a qty.
@@ -152,7 +386,8 @@ func optimizeItemProfit(
 		if maxFeasibleQty == 0 { // Or if errFeasible implies no qty found
 			// Call PerformDualExpansion with includeTreeInExpansionResult = true for Qty 1 check
 			// to get MaxRecipeDepth and other details for the error report.
-			dualResultCheckQty1, _ := PerformDualExpansion(itemNameNorm, 1, apiResp, metricsMap, itemFilesDir, true)
+			limiter.Wait()
+			dualResultCheckQty1, _ := PerformDualExpansion(ctx, itemNameNorm, 1, apiResp, metricsMap, itemFilesDir, true, PrecisionFloat, ExpansionOptions{})
 			if dualResultCheckQty1 != nil {
 				result.AcquisitionTimeAtOptimalQty = dualResultCheckQty1.PrimaryBased.SlowestIngredientBuyTimeSeconds
 				result.SaleTimeAtOptimalQty = dualResultCheckQty1.TopLevelInstasellTimeSeconds
@@ -189,7 +424,8 @@ func optimizeItemProfit(
 	if result.MaxFeasibleQuantity <= 0 {
 		dlog("Optimizer: No feasible quantity > 0 found for %s. Performing Qty=1 check for details.", itemNameNorm)
 		// Call PerformDualExpansion with includeTreeInExpansionResult = true for Qty 1
-		dualResultCheckQty1, errCheckQty1 := PerformDualExpansion(itemNameNorm, 1, apiResp, metricsMap, itemFilesDir, true)
+		limiter.Wait()
+		dualResultCheckQty1, errCheckQty1 := PerformDualExpansion(ctx, itemNameNorm, 1, apiResp, metricsMap, itemFilesDir, true, PrecisionFloat, ExpansionOptions{})
 
 		acqTimeRaw := math.Inf(1)  // Default to Inf
 		saleTimeRaw := math.Inf(1) // Default to Inf
@@ -249,7 +485,8 @@ func optimizeItemProfit(
 	// Step 3: Max feasible quantity > 0. Perform final expansion for this quantity.
 	dlog("Optimizer: Max feasible quantity for %s is %.2f. Performing final expansion.", itemNameNorm, result.MaxFeasibleQuantity)
 	// Call PerformDualExpansion with includeTreeInExpansionResult = true to get MaxRecipeDepth
-	dualResultFinal, errExpansionFinal := PerformDualExpansion(itemNameNorm, result.MaxFeasibleQuantity, apiResp, metricsMap, itemFilesDir, true)
+	limiter.Wait()
+	dualResultFinal, errExpansionFinal := PerformDualExpansion(ctx, itemNameNorm, result.MaxFeasibleQuantity, apiResp, metricsMap, itemFilesDir, true, PrecisionFloat, ExpansionOptions{})
 
 	if errExpansionFinal != nil {
 		result.ErrorMessage = fmt.Sprintf("Error performing dual expansion for optimal qty %.2f: %v", result.MaxFeasibleQuantity, errExpansionFinal)
@@ -263,33 +500,72 @@ func optimizeItemProfit(
 		return result
 	}
 
-	// Process the final expansion result (PrimaryBased perspective)
-	resP1Final := dualResultFinal.PrimaryBased
-	if resP1Final.RecipeTree != nil {
-		result.MaxRecipeDepth = resP1Final.RecipeTree.MaxSubTreeDepth
+	// Step 4: compare PrimaryBased against every alternate recipe-resolution
+	// path PerformDualExpansion resolved (path_enumerator.go) and keep
+	// whichever is most profitable, rather than always trusting
+	// PrimaryBased by default.
+	enumerator := NewPathEnumerator(0)
+	alternatePaths, chosenPathID, selectionReason := enumerator.ChoosePath(dualResultFinal, apiResp, itemNameNorm, result.MaxFeasibleQuantity, maxAllowedFillTime)
+	result.AlternatePaths = alternatePaths
+	result.ChosenPathID = chosenPathID
+	result.PathSelectionReason = selectionReason
+
+	chosenExp := dualResultFinal.PrimaryBased
+	if chosenPathID == "secondary" {
+		chosenExp = dualResultFinal.SecondaryBased
+	}
+
+	if !populateOptimizedResultForPath(&result, chosenExp, dualResultFinal.TopLevelInstasellTimeSeconds, apiResp, metricsMap, itemNameNorm) {
+		return result
+	}
+
+	if result.ErrorMessage != "" { // If there was a non-fatal error message accumulated
+		dlog("Optimizer: %s finished with non-fatal error: %s", itemNameNorm, result.ErrorMessage)
+	}
+
+	return result
+}
+
+// populateOptimizedResultForPath fills result's cost/revenue/profit/timing/
+// staleness/risk fields from resFinal, the ExpansionResult optimizeItemProfit
+// chose as the winning recipe-resolution path (see PathEnumerator.ChoosePath
+// above). It mutates result in place and returns whether resFinal's
+// calculation was possible at all; callers should return result immediately
+// when it returns false, matching optimizeItemProfit's error-path behavior
+// from before path enumeration existed.
+func populateOptimizedResultForPath(
+	result *OptimizedItemResult,
+	resFinal ExpansionResult,
+	topLevelInstasellTime JSONFloat64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemNameNorm string,
+) bool {
+	if resFinal.RecipeTree != nil {
+		result.MaxRecipeDepth = resFinal.RecipeTree.MaxSubTreeDepth
 	}
 	// result.RecipeTree remains nil for the OptimizedItemResult struct itself.
 
-	if !resP1Final.CalculationPossible {
-		result.ErrorMessage = fmt.Sprintf("PrimaryBased calculation not possible for optimal qty %.2f: %s", result.MaxFeasibleQuantity, resP1Final.ErrorMessage)
+	if !resFinal.CalculationPossible {
+		result.ErrorMessage = fmt.Sprintf("%s calculation not possible for optimal qty %.2f: %s", resFinal.PerspectiveType, result.MaxFeasibleQuantity, resFinal.ErrorMessage)
 		// Populate times and bottleneck info even if calculation wasn't fully possible
-		result.AcquisitionTimeAtOptimalQty = resP1Final.SlowestIngredientBuyTimeSeconds
-		result.SaleTimeAtOptimalQty = dualResultFinal.TopLevelInstasellTimeSeconds // Use top-level sale time
+		result.AcquisitionTimeAtOptimalQty = resFinal.SlowestIngredientBuyTimeSeconds
+		result.SaleTimeAtOptimalQty = topLevelInstasellTime // Use top-level sale time
 
-		acqTimeRaw := float64(resP1Final.SlowestIngredientBuyTimeSeconds)
-		saleTimeRaw := float64(dualResultFinal.TopLevelInstasellTimeSeconds)
+		acqTimeRaw := float64(resFinal.SlowestIngredientBuyTimeSeconds)
+		saleTimeRaw := float64(topLevelInstasellTime)
 		if !math.IsNaN(acqTimeRaw) && !math.IsNaN(saleTimeRaw) && (acqTimeRaw >= 0 && saleTimeRaw >= 0) {
 			result.TotalCycleTimeAtOptimalQty = toJSONFloat64(acqTimeRaw + saleTimeRaw)
 		}
-		result.BottleneckIngredient = resP1Final.SlowestIngredientName
-		result.BottleneckIngredientQty = sanitizeFloat(resP1Final.SlowestIngredientQuantity)
-		return result
+		result.BottleneckIngredient = resFinal.SlowestIngredientName
+		result.BottleneckIngredientQty = sanitizeFloat(resFinal.SlowestIngredientQuantity)
+		return false
 	}
 
 	// Populate result with data from the successful final expansion
-	result.CostAtOptimalQty = resP1Final.TotalCost // This is already JSONFloat64
-	result.AcquisitionTimeAtOptimalQty = resP1Final.SlowestIngredientBuyTimeSeconds
-	result.SaleTimeAtOptimalQty = dualResultFinal.TopLevelInstasellTimeSeconds
+	result.CostAtOptimalQty = resFinal.TotalCost // This is already JSONFloat64
+	result.AcquisitionTimeAtOptimalQty = resFinal.SlowestIngredientBuyTimeSeconds
+	result.SaleTimeAtOptimalQty = topLevelInstasellTime
 
 	acqTimeFinalRaw := float64(result.AcquisitionTimeAtOptimalQty)
 	saleTimeFinalRaw := float64(result.SaleTimeAtOptimalQty)
@@ -299,8 +575,26 @@ func optimizeItemProfit(
 		result.TotalCycleTimeAtOptimalQty = toJSONFloat64(math.NaN()) // Ensure NaN if components are invalid
 	}
 
-	result.BottleneckIngredient = resP1Final.SlowestIngredientName
-	result.BottleneckIngredientQty = sanitizeFloat(resP1Final.SlowestIngredientQuantity)
+	result.BottleneckIngredient = resFinal.SlowestIngredientName
+	result.BottleneckIngredientQty = sanitizeFloat(resFinal.SlowestIngredientQuantity)
+
+	// Oldest-input/bottleneck ages ride along on every base ingredient as
+	// MetricsAgeSeconds (see staleness.go); NaN ages (no timestamp for that
+	// ingredient) are skipped rather than treated as infinitely stale.
+	oldestInputAge := math.NaN()
+	for _, ing := range resFinal.BaseIngredients {
+		age := float64(ing.MetricsAgeSeconds)
+		if math.IsNaN(age) {
+			continue
+		}
+		if math.IsNaN(oldestInputAge) || age > oldestInputAge {
+			oldestInputAge = age
+		}
+	}
+	result.OldestInputAgeSeconds = oldestInputAge
+	if bottleneck, ok := resFinal.BaseIngredients[result.BottleneckIngredient]; ok {
+		result.BottleneckIngredientAgeSeconds = float64(bottleneck.MetricsAgeSeconds)
+	}
 
 	// Calculate revenue and profit
 	instasellPrice := getBuyPrice(apiResp, itemNameNorm) // Instasell price is buy price from API perspective
@@ -323,77 +617,347 @@ func optimizeItemProfit(
 		}
 	} else {
 		revenueAtOptimalRaw = instasellPrice * result.MaxFeasibleQuantity
+		// A planned quantity that eats deep into the current instasell
+		// order-book depth won't actually fill at instasellPrice the whole
+		// way down; haircut the tranche beyond maxInstasellDepthFraction of
+		// that depth to roughly half its naive price rather than reporting a
+		// naive price*qty that the market can't support.
+		if depth := instasellDepth(apiResp, itemNameNorm); depth > 0 {
+			allowedQty := maxInstasellDepthFraction * depth
+			if result.MaxFeasibleQuantity > allowedQty {
+				excessQty := result.MaxFeasibleQuantity - allowedQty
+				revenueAtOptimalRaw -= excessQty * instasellPrice * 0.5
+			}
+		}
 		if !math.IsNaN(costAtOptimalVal) { // Ensure cost is a valid number
 			maxProfitRaw = revenueAtOptimalRaw - costAtOptimalVal
 		} else { // Cost was NaN
 			maxProfitRaw = math.NaN() // Profit becomes NaN if cost is NaN
 		}
 	}
+	// Discount MaxProfit by how stale the data behind it is: the same
+	// exp(-age/confidenceHalfLife) decay staleness.go uses for per-ingredient
+	// Confidence, applied here to the oldest input age so a thin-liquidity
+	// item with hours-old metrics doesn't rank as highly as its raw profit
+	// would suggest. An unknown age (no timestamped ingredient) applies no
+	// penalty rather than guessing.
+	stalenessPenalty := 1.0
+	if !math.IsNaN(oldestInputAge) {
+		stalenessPenalty = metricsConfidence(time.Duration(oldestInputAge * float64(time.Second)))
+	}
+	result.StalenessPenaltyApplied = stalenessPenalty
+	if !math.IsNaN(maxProfitRaw) {
+		maxProfitRaw *= stalenessPenalty
+	}
+
+	// ProfitPerSecond/ROI let RunFullOptimization rank by throughput or
+	// capital efficiency instead of raw profit (see OptimizationSortMode).
+	result.ProfitPerSecond = math.NaN()
+	result.ROI = math.NaN()
+	totalCycleTimeVal := float64(result.TotalCycleTimeAtOptimalQty)
+	if !math.IsNaN(maxProfitRaw) && !math.IsNaN(totalCycleTimeVal) && totalCycleTimeVal > 0 {
+		result.ProfitPerSecond = maxProfitRaw / totalCycleTimeVal
+	}
+	if !math.IsNaN(maxProfitRaw) && !math.IsNaN(costAtOptimalVal) && costAtOptimalVal > 0 {
+		result.ROI = maxProfitRaw / costAtOptimalVal
+	}
+
+	// Risk metrics from the item's recent price history (metrics.go):
+	// MaxProfit above treats instasellPrice as certain revenue, but a
+	// volatile item could easily fill at something far worse.
+	result.PriceStdDev = math.NaN()
+	result.PriceZScore = math.NaN()
+	result.WorstCaseProfit = math.NaN()
+	result.SharpeLikeRatio = math.NaN()
+	pm := getMetrics(metricsMap, itemNameNorm)
+	if mean, stddev, _, _, ok := pm.PriceStats(); ok {
+		result.PriceStdDev = stddev
+		if stddev > 0 {
+			result.PriceZScore = (instasellPrice - mean) / stddev
+		}
+		if !math.IsNaN(costAtOptimalVal) {
+			worstCasePrice := mean - worstCaseProfitKStdDev*stddev
+			if worstCasePrice < 0 {
+				worstCasePrice = 0
+			}
+			result.WorstCaseProfit = worstCasePrice*result.MaxFeasibleQuantity - costAtOptimalVal
+		}
+		if stddev > 0 && result.MaxFeasibleQuantity > 0 && !math.IsNaN(maxProfitRaw) {
+			result.SharpeLikeRatio = maxProfitRaw / (stddev * result.MaxFeasibleQuantity)
+		}
+	}
+
 	result.RevenueAtOptimalQty = toJSONFloat64(revenueAtOptimalRaw)
 	result.MaxProfit = toJSONFloat64(maxProfitRaw)
 	result.CalculationPossible = true // Mark as successful if we reach here with valid numbers
+	return true
+}
 
-	if result.ErrorMessage != "" { // If there was a non-fatal error message accumulated
-		dlog("Optimizer: %s finished with non-fatal error: %s", itemNameNorm, result.ErrorMessage)
+// OptimizationConfig controls RunFullOptimization's worker pool size and how
+// fast, in aggregate across all workers, it is allowed to drive downstream
+// Hypixel API access (PerformDualExpansion calls via optimizeItemProfit).
+type OptimizationConfig struct {
+	// Workers is how many items are optimized concurrently; <= 0 defaults to
+	// runtime.GOMAXPROCS(0).
+	Workers int
+	// RequestsPerSecond caps the shared intervalRateLimiter (marketstore.go)
+	// every worker waits on before each PerformDualExpansion call; <= 0 uses
+	// that limiter's own default.
+	RequestsPerSecond float64
+	// SortMode picks what RunFullOptimization's final sort ranks by; the
+	// zero value (SortByStalenessAdjustedProfit) matches prior behavior,
+	// since MaxProfit already has the staleness penalty baked in.
+	SortMode OptimizationSortMode
+	// ProgressCallback, if set, is invoked from a worker goroutine every
+	// time one item finishes optimizing: done is the number of items
+	// completed so far (out of total), and currentItem is the one that
+	// just finished. Callbacks arrive out of order and from multiple
+	// goroutines concurrently (one per Workers), so an implementation that
+	// isn't already safe for concurrent use must do its own locking.
+	ProgressCallback func(done, total int, currentItem string)
+}
+
+// OptimizationSortMode selects the profit figure RunFullOptimization's final
+// sort ranks by.
+type OptimizationSortMode string
+
+const (
+	// SortByStalenessAdjustedProfit (the default) ranks by MaxProfit as
+	// computed by optimizeItemProfit, which already discounts for stale
+	// input data via StalenessPenaltyApplied.
+	SortByStalenessAdjustedProfit OptimizationSortMode = "staleness_adjusted_profit"
+	// SortByRawProfit undoes that discount (MaxProfit / StalenessPenaltyApplied)
+	// before ranking, for callers who'd rather judge freshness separately
+	// from profit ranking.
+	SortByRawProfit OptimizationSortMode = "raw_profit"
+	// SortByRiskAdjustedProfit ranks by SharpeLikeRatio instead of profit, so
+	// an item whose price barely moves outranks one with the same MaxProfit
+	// riding on a far more volatile price.
+	SortByRiskAdjustedProfit OptimizationSortMode = "risk_adjusted_profit"
+	// SortByProfitPerSecond ranks by ProfitPerSecond, favoring items that
+	// cycle fast over ones that merely profit a lot per cycle.
+	SortByProfitPerSecond OptimizationSortMode = "profit_per_second"
+	// SortByROI ranks by ROI (profit per coin of capital tied up), favoring
+	// capital-efficient flips over ones that merely profit a lot in absolute
+	// terms.
+	SortByROI OptimizationSortMode = "roi"
+)
+
+// rankingProfit returns the profit figure RunFullOptimization's sort should
+// compare for r, per mode.
+func rankingProfit(r OptimizedItemResult, mode OptimizationSortMode) float64 {
+	switch mode {
+	case SortByRiskAdjustedProfit:
+		return r.SharpeLikeRatio
+	case SortByProfitPerSecond:
+		return r.ProfitPerSecond
+	case SortByROI:
+		return r.ROI
+	}
+	profit := float64(r.MaxProfit)
+	if mode != SortByRawProfit {
+		return profit
+	}
+	if r.StalenessPenaltyApplied <= 0 || math.IsNaN(r.StalenessPenaltyApplied) {
+		return math.NaN()
 	}
+	return profit / r.StalenessPenaltyApplied
+}
 
-	return result
+// optimizeOneItemSafe wraps optimizeItemProfit with the per-item checks
+// RunFullOptimization used to do inline (item-exists sanity check, initial
+// qty defaulting) plus panic recovery, so one item's panic can't take down
+// the worker pool or the rest of the batch.
+func optimizeOneItemSafe(
+	ctx context.Context,
+	limiter *intervalRateLimiter,
+	itemID string,
+	maxAllowedFillTime float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	maxPossibleInitialQtyPerItem float64,
+) (result OptimizedItemResult) {
+	normalizedID := BAZAAR_ID(itemID)
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR (Optimizer): panic optimizing %s: %v", normalizedID, r)
+			result = OptimizedItemResult{
+				ItemName: normalizedID, CalculationPossible: false, ErrorMessage: fmt.Sprintf("panic during optimization: %v", r),
+				CostAtOptimalQty: toJSONFloat64(math.NaN()), RevenueAtOptimalQty: toJSONFloat64(math.NaN()), MaxProfit: toJSONFloat64(math.NaN()),
+				TotalCycleTimeAtOptimalQty: toJSONFloat64(math.NaN()), AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()), SaleTimeAtOptimalQty: toJSONFloat64(math.NaN()),
+			}
+		}
+	}()
+
+	// Check if item exists in API data (quick sanity check)
+	if _, exists := apiResp.Products[normalizedID]; !exists {
+		dlog("Optimizer: Item %s (Normalized: %s) not found in API product list for this run, skipping.", itemID, normalizedID)
+		return OptimizedItemResult{
+			ItemName: normalizedID, CalculationPossible: false, ErrorMessage: "Item not found in current Bazaar API data.",
+			MaxFeasibleQuantity: 0,
+			CostAtOptimalQty:    toJSONFloat64(math.NaN()), RevenueAtOptimalQty: toJSONFloat64(math.NaN()), MaxProfit: toJSONFloat64(math.NaN()),
+			TotalCycleTimeAtOptimalQty: toJSONFloat64(math.NaN()), AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()), SaleTimeAtOptimalQty: toJSONFloat64(math.NaN()),
+		}
+	}
+
+	currentMaxInitialQty := maxPossibleInitialQtyPerItem
+	if currentMaxInitialQty <= 0 { // Safety for this parameter
+		currentMaxInitialQty = 1000000.0 // Default large search quantity
+		dlog("Optimizer: maxPossibleInitialQtyPerItem was <=0, using default %.2f for %s", currentMaxInitialQty, normalizedID)
+	}
+
+	// optimizeItemProfit now handles RAM for RecipeTree internally
+	return optimizeItemProfit(ctx, limiter, normalizedID, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, currentMaxInitialQty)
+}
+
+// RunFullOptimization fans itemIDs out across config.Workers goroutines
+// (config.ProgressCallback, if set, is invoked by whichever goroutine just
+// finished an item) and collects each optimizeOneItemSafe result into
+// resultsCh, indexed by the item's position in itemIDs so the final sort
+// below doesn't need a stable input order. apiResp and metricsMap are only
+// ever read, never written, once optimization starts, so every worker
+// sharing them concurrently is race-free without its own locking.
+// RunFullOptimizationStream is RunFullOptimization's worker pool, reworked to
+// emit each item's OptimizedItemResult on a channel as soon as it finishes
+// instead of buffering the whole batch - useful for an HTTP handler or TUI
+// that wants to render "top-N so far" against a large batch instead of
+// blocking on the slowest item. The returned result channel closes once every
+// item has been dispatched and every worker has finished (or ctx is
+// cancelled, which also stops in-flight PerformDualExpansion calls, since ctx
+// is threaded all the way down to those calls already). The error channel
+// carries at most one value - a batch-level input error - and is always
+// closed; a caller only needs to check it once after the result channel
+// closes.
+func RunFullOptimizationStream(
+	ctx context.Context,
+	itemIDs []string,
+	maxAllowedFillTime float64, // Max total cycle time (acquisition + sale)
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	maxPossibleInitialQtyPerItem float64, // Max quantity for initial search in findMaxQuantityForTimeConstraint
+	config OptimizationConfig,
+) (<-chan OptimizedItemResult, <-chan error) {
+	resultsOut := make(chan OptimizedItemResult, len(itemIDs))
+	errOut := make(chan error, 1)
+
+	if apiResp == nil || metricsMap == nil {
+		close(resultsOut)
+		errOut <- fmt.Errorf("optimizer input error: API response or metrics map was nil")
+		close(errOut)
+		return resultsOut, errOut
+	}
+	if len(itemIDs) == 0 {
+		close(resultsOut)
+		close(errOut)
+		return resultsOut, errOut
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(itemIDs) {
+		workers = len(itemIDs)
+	}
+	limiter := newIntervalRateLimiter(config.RequestsPerSecond)
+	dlog("Optimizer: Dispatching %d items across %d workers, rate-limited to %.1f req/s.", len(itemIDs), workers, config.RequestsPerSecond)
+	DefaultMetrics(nil).OptimizerLastRunItemsScanned.Set(float64(len(itemIDs)))
+
+	jobs := make(chan int)
+	var completed int64
+	total := len(itemIDs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				result := optimizeOneItemSafe(ctx, limiter, itemIDs[idx], maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQtyPerItem)
+				select {
+				case resultsOut <- result:
+				case <-ctx.Done():
+					return
+				}
+				if config.ProgressCallback != nil {
+					done := atomic.AddInt64(&completed, 1)
+					config.ProgressCallback(int(done), total, itemIDs[idx])
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range itemIDs {
+			select {
+			case <-ctx.Done():
+				dlog("Optimizer: context cancelled while dispatching jobs (%v); %d/%d items submitted.", ctx.Err(), i, len(itemIDs))
+				return
+			case jobs <- i:
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultsOut)
+		close(errOut)
+	}()
+
+	return resultsOut, errOut
 }
 
 func RunFullOptimization(
+	ctx context.Context,
 	itemIDs []string,
 	maxAllowedFillTime float64, // Max total cycle time (acquisition + sale)
 	apiResp *HypixelAPIResponse,
 	metricsMap map[string]ProductMetrics,
 	itemFilesDir string,
 	maxPossibleInitialQtyPerItem float64, // Max quantity for initial search in findMaxQuantityForTimeConstraint
-) []OptimizedItemResult {
+	config OptimizationConfig,
+) ([]OptimizedItemResult, BatchSummary) {
 	dlog("Optimizer: Starting full optimization for %d items. Total Cycle Time Constraint: %.2fs, Max Initial Search Qty: %.2f", len(itemIDs), maxAllowedFillTime, maxPossibleInitialQtyPerItem)
-	var results []OptimizedItemResult
 
 	if apiResp == nil || metricsMap == nil {
 		log.Println("ERROR (Optimizer): API response or metrics map is nil. Cannot run full optimization.")
 		// Return a single error result to indicate batch failure
-		results = append(results, OptimizedItemResult{
+		errResults := []OptimizedItemResult{{
 			ItemName: "BATCH_OPTIMIZATION_ERROR", ErrorMessage: "Optimizer input error: API response or Metrics map was nil.", CalculationPossible: false,
 			CostAtOptimalQty: toJSONFloat64(math.NaN()), RevenueAtOptimalQty: toJSONFloat64(math.NaN()), MaxProfit: toJSONFloat64(math.NaN()),
 			TotalCycleTimeAtOptimalQty: toJSONFloat64(math.NaN()), AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()), SaleTimeAtOptimalQty: toJSONFloat64(math.NaN()),
 			RecipeTree: nil, // Ensure nil
-		})
-		return results
+		}}
+		return errResults, computeBatchSummary(errResults)
 	}
 	if len(itemIDs) == 0 {
 		log.Println("Optimizer: No item IDs provided for optimization.")
-		return results // Return empty slice, not an error
+		return nil, computeBatchSummary(nil) // Return empty slice, not an error
 	}
 
-	for i, itemID := range itemIDs {
-		dlog("Optimizer: Optimizing item %d/%d: %s", i+1, len(itemIDs), itemID)
-		normalizedID := BAZAAR_ID(itemID) // Normalize ID
-
-		// Check if item exists in API data (quick sanity check)
-		if _, exists := apiResp.Products[normalizedID]; !exists {
-			dlog("Optimizer: Item %s (Normalized: %s) not found in API product list for this run, skipping.", itemID, normalizedID)
-			results = append(results, OptimizedItemResult{
-				ItemName: normalizedID, CalculationPossible: false, ErrorMessage: "Item not found in current Bazaar API data.",
-				MaxFeasibleQuantity: 0,
-				CostAtOptimalQty:    toJSONFloat64(math.NaN()), RevenueAtOptimalQty: toJSONFloat64(math.NaN()), MaxProfit: toJSONFloat64(math.NaN()),
-				TotalCycleTimeAtOptimalQty: toJSONFloat64(math.NaN()), AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()), SaleTimeAtOptimalQty: toJSONFloat64(math.NaN()),
-				RecipeTree: nil, // Ensure nil
-			})
-			continue
-		}
-
-		currentMaxInitialQty := maxPossibleInitialQtyPerItem
-		if currentMaxInitialQty <= 0 { // Safety for this parameter
-			currentMaxInitialQty = 1000000.0 // Default large search quantity
-			dlog("Optimizer: maxPossibleInitialQtyPerItem was <=0, using default %.2f for %s", currentMaxInitialQty, normalizedID)
-		}
+	resultsCh, errCh := RunFullOptimizationStream(ctx, itemIDs, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQtyPerItem, config)
 
-		// optimizeItemProfit now handles RAM for RecipeTree internally
-		result := optimizeItemProfit(normalizedID, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, currentMaxInitialQty)
+	results := make([]OptimizedItemResult, 0, len(itemIDs))
+	for result := range resultsCh {
 		results = append(results, result)
 	}
+	if err := <-errCh; err != nil {
+		// apiResp/metricsMap were already checked above, so this can only
+		// come from a future RunFullOptimizationStream input check; surface
+		// it the same way the check above does.
+		log.Printf("ERROR (Optimizer): %v", err)
+		errResults := []OptimizedItemResult{{
+			ItemName: "BATCH_OPTIMIZATION_ERROR", ErrorMessage: err.Error(), CalculationPossible: false,
+			CostAtOptimalQty: toJSONFloat64(math.NaN()), RevenueAtOptimalQty: toJSONFloat64(math.NaN()), MaxProfit: toJSONFloat64(math.NaN()),
+			TotalCycleTimeAtOptimalQty: toJSONFloat64(math.NaN()), AcquisitionTimeAtOptimalQty: toJSONFloat64(math.NaN()), SaleTimeAtOptimalQty: toJSONFloat64(math.NaN()),
+			RecipeTree: nil, // Ensure nil
+		}}
+		return errResults, computeBatchSummary(errResults)
+	}
 
 	// Sort results: CalculationPossible=true first, then by MaxProfit descending.
 	sort.Slice(results, func(i, j int) bool {
@@ -409,8 +973,8 @@ func RunFullOptimization(
 		}
 		// If both have same CalculationPossible status (either both true or both false)
 
-		profitI := float64(resI.MaxProfit) // Convert JSONFloat64 to float64 for comparison
-		profitJ := float64(resJ.MaxProfit)
+		profitI := rankingProfit(resI, config.SortMode)
+		profitJ := rankingProfit(resJ, config.SortMode)
 
 		isProfitINaN := math.IsNaN(profitI)
 		isProfitJNaN := math.IsNaN(profitJ)
@@ -436,5 +1000,445 @@ func RunFullOptimization(
 	})
 
 	dlog("Optimizer: Full optimization complete. Processed %d items, generated %d results.", len(itemIDs), len(results))
-	return results
+	return results, computeBatchSummary(results)
+}
+
+// defaultMaxConcurrentSlots is the Bazaar's real limit on simultaneous open
+// buy/sell orders, used by RunPortfolioOptimization when the caller passes
+// MaxConcurrentSlots <= 0.
+const defaultMaxConcurrentSlots = 14
+
+// portfolioCandidateFractions are the fractions of an item's MaxFeasibleQuantity
+// that RunPortfolioOptimization evaluates as candidate allocations, turning
+// the continuous "how much of this item" decision into a small, bounded set
+// of choices the knapsack can pick from.
+var portfolioCandidateFractions = []float64{0.25, 0.50, 0.75, 1.0}
+
+// portfolioKnapsackBucketThreshold bounds how many discretised budget buckets
+// the exact DP knapsack in RunPortfolioOptimization is allowed to build;
+// above this, RunPortfolioOptimization falls back to a greedy
+// profit-per-hour-density pass instead of paying the DP's O(buckets*slots*items) cost.
+const portfolioKnapsackBucketThreshold = 20000
+
+// PortfolioItemAllocation is one item chosen by RunPortfolioOptimization,
+// along with the quantity, cost and slot usage of the candidate it picked.
+type PortfolioItemAllocation struct {
+	ItemName      string  `json:"item_name"`
+	Quantity      float64 `json:"quantity"`
+	Cost          float64 `json:"cost"`
+	Profit        float64 `json:"profit"`
+	ProfitPerHour float64 `json:"profit_per_hour"`
+	SlotsUsed     int     `json:"slots_used"`
+}
+
+// PortfolioOptimizationResult is RunPortfolioOptimization's output: the
+// selected bundle plus enough information for the caller to judge whether
+// raising TotalCoinBudget or MaxConcurrentSlots would be worthwhile.
+type PortfolioOptimizationResult struct {
+	Selected           []PortfolioItemAllocation `json:"selected"`
+	TotalCost          float64                   `json:"total_cost"`
+	TotalSlotsUsed     int                       `json:"total_slots_used"`
+	TotalProfitPerHour float64                   `json:"total_profit_per_hour"`
+	// NextRejectedItem/NextRejectedItemProfitPerHour describe the
+	// highest-profit-per-hour candidate that did NOT make it into Selected,
+	// so a caller can see what an extra budget/slot would buy them.
+	NextRejectedItem              string  `json:"next_rejected_item,omitempty"`
+	NextRejectedItemProfitPerHour float64 `json:"next_rejected_item_profit_per_hour,omitempty"`
+	// UsedGreedyFallback is true when the discretised budget exceeded
+	// portfolioKnapsackBucketThreshold buckets and the LP-relaxation greedy
+	// pass was used instead of the exact DP knapsack.
+	UsedGreedyFallback bool `json:"used_greedy_fallback"`
+}
+
+// portfolioCandidate is one scaled sub-optimization of an item: the
+// knapsack treats every item's candidates as mutually exclusive choices and
+// picks at most one per item.
+type portfolioCandidate struct {
+	itemName      string
+	quantity      float64
+	cost          float64
+	profit        float64
+	profitPerHour float64
+	slotsUsed     int
+}
+
+// evaluatePortfolioCandidate runs PerformDualExpansion for itemName at a
+// specific quantity and turns the result into a portfolioCandidate, or
+// returns ok=false if the quantity isn't viable (no valid cost, cycle time,
+// or instasell price). Unlike optimizeItemProfit, it does not search for the
+// best quantity - the caller supplies one from portfolioCandidateFractions.
+func evaluatePortfolioCandidate(
+	ctx context.Context,
+	itemName string,
+	qty float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+) (portfolioCandidate, bool) {
+	dual, err := PerformDualExpansion(ctx, itemName, qty, apiResp, metricsMap, itemFilesDir, false, PrecisionFloat, ExpansionOptions{})
+	if err != nil || dual == nil || !dual.PrimaryBased.CalculationPossible {
+		return portfolioCandidate{}, false
+	}
+	p1 := dual.PrimaryBased
+
+	cost := float64(p1.TotalCost)
+	if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return portfolioCandidate{}, false
+	}
+
+	acqTime := float64(p1.SlowestIngredientBuyTimeSeconds)
+	saleTime := float64(dual.TopLevelInstasellTimeSeconds)
+	if math.IsNaN(acqTime) {
+		acqTime = math.Inf(1)
+	}
+	if math.IsNaN(saleTime) {
+		saleTime = math.Inf(1)
+	}
+	cycleTime := acqTime + saleTime
+	if math.IsInf(cycleTime, 0) || cycleTime <= 0 {
+		return portfolioCandidate{}, false
+	}
+
+	instasellPrice := getBuyPrice(apiResp, itemName)
+	if instasellPrice <= 0 || math.IsNaN(instasellPrice) || math.IsInf(instasellPrice, 0) {
+		return portfolioCandidate{}, false
+	}
+
+	profit := instasellPrice*qty - cost
+
+	return portfolioCandidate{
+		itemName:      itemName,
+		quantity:      qty,
+		cost:          cost,
+		profit:        profit,
+		profitPerHour: profit / cycleTime * 3600,
+		// One buy order per distinct base ingredient, plus one sell order
+		// for the crafted/acquired item itself.
+		slotsUsed: len(p1.BaseIngredients) + 1,
+	}, true
+}
+
+// buildPortfolioCandidates runs optimizeItemProfit once per item to find its
+// MaxFeasibleQuantity under maxAllowedFillTime, then evaluates the scaled
+// quantities in portfolioCandidateFractions, keeping only profitable,
+// budget-eligible candidates sorted cheapest-first (so the knapsack can stop
+// early once a bucket is already filled by a cheaper candidate).
+func buildPortfolioCandidates(
+	itemIDs []string,
+	maxAllowedFillTime float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	maxPossibleInitialQtyPerItem float64,
+	maxPerItemCost float64,
+) map[string][]portfolioCandidate {
+	candidatesByItem := make(map[string][]portfolioCandidate)
+
+	for _, itemID := range itemIDs {
+		itemNameNorm := BAZAAR_ID(itemID)
+		if _, exists := apiResp.Products[itemNameNorm]; !exists {
+			dlog("Portfolio: item %s not found in API product list, skipping.", itemNameNorm)
+			continue
+		}
+
+		base := optimizeItemProfit(context.Background(), nil, itemNameNorm, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQtyPerItem)
+		if !base.CalculationPossible || base.MaxFeasibleQuantity < 1 {
+			dlog("Portfolio: %s has no feasible quantity, skipping.", itemNameNorm)
+			continue
+		}
+
+		seenQty := make(map[float64]bool)
+		var candidates []portfolioCandidate
+		for _, frac := range portfolioCandidateFractions {
+			qty := math.Floor(frac * base.MaxFeasibleQuantity)
+			if qty < 1 || seenQty[qty] {
+				continue
+			}
+			seenQty[qty] = true
+
+			cand, ok := evaluatePortfolioCandidate(context.Background(), itemNameNorm, qty, apiResp, metricsMap, itemFilesDir)
+			if !ok || cand.profit <= 0 {
+				continue
+			}
+			if maxPerItemCost > 0 && cand.cost > maxPerItemCost {
+				continue
+			}
+			candidates = append(candidates, cand)
+		}
+
+		if len(candidates) > 0 {
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].cost < candidates[j].cost })
+			candidatesByItem[itemNameNorm] = candidates
+		}
+	}
+
+	return candidatesByItem
+}
+
+// RunPortfolioOptimization selects, across every item in itemIDs, the
+// per-item quantity (or no allocation at all) that maximises total
+// profit-per-hour subject to a finite coin budget and a finite number of
+// concurrent Bazaar order slots - the constraints a real flipper actually
+// operates under, unlike RunFullOptimization which sizes each item in
+// isolation.
+//
+// It is a bounded knapsack: buildPortfolioCandidates turns each item into a
+// handful of (cost, slots, profit-per-hour) choices at 25/50/75/100% of its
+// MaxFeasibleQuantity, and this function solves the 0/1 knapsack of "at most
+// one candidate per item" over those choices. The budget axis is
+// discretised into buckets of size totalCoinBudget/portfolioKnapsackBucketThreshold;
+// if that would need more buckets than portfolioKnapsackBucketThreshold, a
+// greedy profit-per-hour-per-coin pass is used instead of the exact DP.
+func RunPortfolioOptimization(
+	itemIDs []string,
+	maxAllowedFillTime float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	maxPossibleInitialQtyPerItem float64,
+	totalCoinBudget float64,
+	maxConcurrentSlots int,
+	maxPerItemBudgetFraction float64,
+) PortfolioOptimizationResult {
+	result := PortfolioOptimizationResult{}
+
+	if apiResp == nil || metricsMap == nil || totalCoinBudget <= 0 {
+		log.Println("ERROR (Portfolio): API response/metrics map nil or TotalCoinBudget <= 0. Cannot run portfolio optimization.")
+		return result
+	}
+	if maxConcurrentSlots <= 0 {
+		maxConcurrentSlots = defaultMaxConcurrentSlots
+	}
+	if maxPerItemBudgetFraction <= 0 || maxPerItemBudgetFraction > 1 {
+		maxPerItemBudgetFraction = 1.0
+	}
+	maxPerItemCost := totalCoinBudget * maxPerItemBudgetFraction
+
+	dlog("Portfolio: Optimizing %d items. Budget=%.2f, MaxSlots=%d, MaxPerItemFraction=%.2f", len(itemIDs), totalCoinBudget, maxConcurrentSlots, maxPerItemBudgetFraction)
+
+	candidatesByItem := buildPortfolioCandidates(itemIDs, maxAllowedFillTime, apiResp, metricsMap, itemFilesDir, maxPossibleInitialQtyPerItem, maxPerItemCost)
+	if len(candidatesByItem) == 0 {
+		dlog("Portfolio: no viable candidates found for any item.")
+		return result
+	}
+
+	items := make([]string, 0, len(candidatesByItem))
+	for itemName := range candidatesByItem {
+		items = append(items, itemName)
+	}
+	sort.Strings(items) // deterministic iteration order for a stable DP tie-break
+
+	numBuckets := int(math.Ceil(totalCoinBudget))
+	if numBuckets > portfolioKnapsackBucketThreshold {
+		result = runPortfolioGreedy(items, candidatesByItem, totalCoinBudget, maxConcurrentSlots)
+		result.UsedGreedyFallback = true
+		return result
+	}
+
+	bucketSize := totalCoinBudget / float64(numBuckets)
+	result = runPortfolioKnapsackDP(items, candidatesByItem, bucketSize, maxConcurrentSlots, numBuckets)
+
+	// Marginal value: the best candidate, across every rejected item, that
+	// would have been the next one added given one more unit of budget/slots.
+	selected := make(map[string]bool, len(result.Selected))
+	for _, sel := range result.Selected {
+		selected[sel.ItemName] = true
+	}
+	bestRejectedName := ""
+	bestRejectedProfitPerHour := math.Inf(-1)
+	for _, itemName := range items {
+		if selected[itemName] {
+			continue
+		}
+		for _, cand := range candidatesByItem[itemName] {
+			if cand.profitPerHour > bestRejectedProfitPerHour {
+				bestRejectedProfitPerHour = cand.profitPerHour
+				bestRejectedName = itemName
+			}
+		}
+	}
+	if bestRejectedName != "" {
+		result.NextRejectedItem = bestRejectedName
+		result.NextRejectedItemProfitPerHour = bestRejectedProfitPerHour
+	}
+
+	return result
+}
+
+// runPortfolioKnapsackDP solves the exact 0/1 knapsack over item candidates
+// with two constraints (discretised coin budget buckets and concurrent
+// slots): dp[i][s][b] is the best total profit-per-hour achievable using the
+// first i items with at most s slots and b coin buckets spent. At most one
+// candidate per item is ever chosen, so each item contributes a "skip" or
+// "take candidate c" choice to the recurrence. The final answer is
+// recovered by walking the table backwards from its best cell.
+func runPortfolioKnapsackDP(
+	items []string,
+	candidatesByItem map[string][]portfolioCandidate,
+	bucketSize float64,
+	maxConcurrentSlots int,
+	numBuckets int,
+) PortfolioOptimizationResult {
+	n := len(items)
+	// dp[i][s][b] = best total profit-per-hour using the first i items with
+	// at most s slots and b coin buckets used.
+	dp := make([][][]float64, n+1)
+	for i := range dp {
+		dp[i] = make([][]float64, maxConcurrentSlots+1)
+		for s := range dp[i] {
+			dp[i][s] = make([]float64, numBuckets+1)
+		}
+	}
+
+	for i := 1; i <= n; i++ {
+		itemName := items[i-1]
+		for s := 0; s <= maxConcurrentSlots; s++ {
+			for b := 0; b <= numBuckets; b++ {
+				best := dp[i-1][s][b] // choice: skip this item entirely
+				for _, cand := range candidatesByItem[itemName] {
+					bucketsNeeded := int(math.Ceil(cand.cost / bucketSize))
+					if cand.slotsUsed > s || bucketsNeeded > b {
+						continue
+					}
+					alt := dp[i-1][s-cand.slotsUsed][b-bucketsNeeded] + cand.profitPerHour
+					if alt > best {
+						best = alt
+					}
+				}
+				dp[i][s][b] = best
+			}
+		}
+	}
+
+	bestSlots, bestBucket := 0, 0
+	bestTotal := 0.0
+	for s := 0; s <= maxConcurrentSlots; s++ {
+		for b := 0; b <= numBuckets; b++ {
+			if dp[n][s][b] > bestTotal {
+				bestTotal = dp[n][s][b]
+				bestSlots, bestBucket = s, b
+			}
+		}
+	}
+
+	var selected []PortfolioItemAllocation
+	totalCost, totalProfitPerHour := 0.0, 0.0
+	totalSlots := 0
+	s, b := bestSlots, bestBucket
+	for i := n; i >= 1; i-- {
+		itemName := items[i-1]
+		if dp[i][s][b] == dp[i-1][s][b] {
+			continue // this item wasn't selected at this (s, b)
+		}
+		for _, cand := range candidatesByItem[itemName] {
+			bucketsNeeded := int(math.Ceil(cand.cost / bucketSize))
+			if cand.slotsUsed > s || bucketsNeeded > b {
+				continue
+			}
+			if dp[i-1][s-cand.slotsUsed][b-bucketsNeeded]+cand.profitPerHour == dp[i][s][b] {
+				selected = append(selected, PortfolioItemAllocation{
+					ItemName:      cand.itemName,
+					Quantity:      cand.quantity,
+					Cost:          cand.cost,
+					Profit:        cand.profit,
+					ProfitPerHour: cand.profitPerHour,
+					SlotsUsed:     cand.slotsUsed,
+				})
+				totalCost += cand.cost
+				totalProfitPerHour += cand.profitPerHour
+				totalSlots += cand.slotsUsed
+				s -= cand.slotsUsed
+				b -= bucketsNeeded
+				break
+			}
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].ProfitPerHour > selected[j].ProfitPerHour })
+
+	return PortfolioOptimizationResult{
+		Selected:           selected,
+		TotalCost:          sanitizeFloat(totalCost),
+		TotalSlotsUsed:     totalSlots,
+		TotalProfitPerHour: sanitizeFloat(totalProfitPerHour),
+	}
+}
+
+// runPortfolioGreedy is the LP-relaxation fallback used when the budget
+// would need more discretised buckets than portfolioKnapsackBucketThreshold
+// for the exact DP: items are sorted by profit-per-hour-per-coin (the LP
+// relaxation's ranking criterion) and added greedily while budget and slots
+// allow, picking each item's best-fitting candidate.
+func runPortfolioGreedy(
+	items []string,
+	candidatesByItem map[string][]portfolioCandidate,
+	totalCoinBudget float64,
+	maxConcurrentSlots int,
+) PortfolioOptimizationResult {
+	type densityCandidate struct {
+		portfolioCandidate
+		density float64 // profit-per-hour per coin spent
+	}
+	var all []densityCandidate
+	for _, itemName := range items {
+		for _, cand := range candidatesByItem[itemName] {
+			if cand.cost <= 0 {
+				continue
+			}
+			all = append(all, densityCandidate{portfolioCandidate: cand, density: cand.profitPerHour / cand.cost})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].density > all[j].density })
+
+	var selected []PortfolioItemAllocation
+	chosen := make(map[string]bool, len(items))
+	remainingBudget := totalCoinBudget
+	remainingSlots := maxConcurrentSlots
+	totalCost, totalProfitPerHour := 0.0, 0.0
+
+	for _, cand := range all {
+		if chosen[cand.itemName] {
+			continue // at most one candidate per item
+		}
+		if cand.cost > remainingBudget || cand.slotsUsed > remainingSlots {
+			continue
+		}
+		chosen[cand.itemName] = true
+		remainingBudget -= cand.cost
+		remainingSlots -= cand.slotsUsed
+		totalCost += cand.cost
+		totalProfitPerHour += cand.profitPerHour
+		selected = append(selected, PortfolioItemAllocation{
+			ItemName:      cand.itemName,
+			Quantity:      cand.quantity,
+			Cost:          cand.cost,
+			Profit:        cand.profit,
+			ProfitPerHour: cand.profitPerHour,
+			SlotsUsed:     cand.slotsUsed,
+		})
+	}
+
+	bestRejectedName := ""
+	bestRejectedProfitPerHour := math.Inf(-1)
+	for _, cand := range all {
+		if chosen[cand.itemName] {
+			continue
+		}
+		if cand.profitPerHour > bestRejectedProfitPerHour {
+			bestRejectedProfitPerHour = cand.profitPerHour
+			bestRejectedName = cand.itemName
+		}
+	}
+
+	result := PortfolioOptimizationResult{
+		Selected:           selected,
+		TotalCost:          sanitizeFloat(totalCost),
+		TotalSlotsUsed:     maxConcurrentSlots - remainingSlots,
+		TotalProfitPerHour: sanitizeFloat(totalProfitPerHour),
+	}
+	if bestRejectedName != "" {
+		result.NextRejectedItem = bestRejectedName
+		result.NextRejectedItemProfitPerHour = bestRejectedProfitPerHour
+	}
+	return result
 }
@@ -0,0 +1,140 @@
+// order_supervisor.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// TrailingTier pairs one activation threshold with the callback (retracement)
+// rate that arms at that threshold, mirroring the tiered trailing-stop
+// schemes used in trading bot configs: bigger moves away from your price
+// arm a looser tier that's willing to wait for a bigger pullback before
+// re-quoting.
+type TrailingTier struct {
+	ActivationRatio float64 // fraction the best bid must move away from OrderPrice to arm this tier
+	CallbackRate    float64 // fraction of the tracked extreme drift that must retrace to trigger a re-quote
+}
+
+// BuyOrderSupervisor wraps a resting buy order with a closed-loop re-quote
+// policy: it watches the best bid, arms a trailing tier once the market
+// drifts away from OrderPrice by enough, and cancels/re-quotes once the
+// market reverses back by that tier's callback rate. It gives up (Abandon)
+// once the predicted fill time at the new price exceeds FillTimeBudget.
+type BuyOrderSupervisor struct {
+	ProductID      string
+	OrderPrice     float64
+	Tiers          []TrailingTier // sorted ascending by ActivationRatio
+	FillTimeBudget float64        // seconds; 0 disables the budget check
+
+	mu           sync.Mutex
+	armedTierIdx int     // -1 until a tier arms
+	extremeDrift float64 // largest adverse drift ratio observed since arming
+}
+
+// NewBuyOrderSupervisor validates the paired tier slices (matching the
+// request's `trailingActivationRatio []float64` / `trailingCallbackRate
+// []float64` shape) and returns a supervisor watching orderPrice.
+func NewBuyOrderSupervisor(productID string, orderPrice float64, trailingActivationRatio, trailingCallbackRate []float64, fillTimeBudget float64) (*BuyOrderSupervisor, error) {
+	if len(trailingActivationRatio) != len(trailingCallbackRate) {
+		return nil, fmt.Errorf("trailingActivationRatio and trailingCallbackRate must have the same length (%d != %d)", len(trailingActivationRatio), len(trailingCallbackRate))
+	}
+	if orderPrice <= 0 {
+		return nil, fmt.Errorf("orderPrice must be > 0")
+	}
+
+	tiers := make([]TrailingTier, len(trailingActivationRatio))
+	for i := range trailingActivationRatio {
+		tiers[i] = TrailingTier{ActivationRatio: trailingActivationRatio[i], CallbackRate: trailingCallbackRate[i]}
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].ActivationRatio < tiers[j].ActivationRatio })
+
+	return &BuyOrderSupervisor{
+		ProductID: BAZAAR_ID(productID), OrderPrice: orderPrice, Tiers: tiers,
+		FillTimeBudget: fillTimeBudget, armedTierIdx: -1,
+	}, nil
+}
+
+// SupervisorAction is what OnBestBidUpdate recommends doing in response to
+// one best-bid observation.
+type SupervisorAction struct {
+	Requote               bool
+	Abandon               bool
+	NewPrice              float64
+	PredictedFillTime     float64 // seconds; FillTimeEstimate.Mid, kept for callers that only want a point estimate
+	PredictedFillTimeLow  float64 // seconds; FillTimeEstimate.Low, typical-case
+	PredictedFillTimeHigh float64 // seconds; FillTimeEstimate.High, worst-case - what FillTimeBudget is actually checked against
+	Reason                string
+}
+
+// OnBestBidUpdate feeds one new best-bid observation into the supervisor and
+// returns the recommended action. quantity/metricsData are passed straight
+// through to calculateBuyOrderFillTimeWithBand to recompute the predicted
+// fill time band whenever a re-quote is recommended; FillTimeBudget is
+// checked against the worst-case (High) end of that band rather than the
+// point estimate, since a budget is meant to catch the "this could take
+// forever" case.
+func (s *BuyOrderSupervisor) OnBestBidUpdate(bestBid float64, quantity float64, metricsData ProductMetrics) SupervisorAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bestBid <= 0 || s.OrderPrice <= 0 {
+		return SupervisorAction{Reason: "invalid price input"}
+	}
+
+	drift := (s.OrderPrice - bestBid) / s.OrderPrice // positive: market has moved away below our order
+
+	if s.armedTierIdx == -1 {
+		// Look for the highest tier whose activation threshold has been crossed.
+		for i := len(s.Tiers) - 1; i >= 0; i-- {
+			if drift >= s.Tiers[i].ActivationRatio {
+				s.armedTierIdx = i
+				s.extremeDrift = drift
+				return SupervisorAction{Reason: fmt.Sprintf("tier %d armed at drift %.4f", i, drift)}
+			}
+		}
+		return SupervisorAction{Reason: "no tier armed"}
+	}
+
+	if drift > s.extremeDrift {
+		s.extremeDrift = drift
+		return SupervisorAction{Reason: fmt.Sprintf("tracking new extreme drift %.4f", drift)}
+	}
+
+	tier := s.Tiers[s.armedTierIdx]
+	if s.extremeDrift <= 0 {
+		return SupervisorAction{Reason: "extreme drift non-positive, nothing to retrace"}
+	}
+	retracement := (s.extremeDrift - drift) / s.extremeDrift
+	if retracement < tier.CallbackRate {
+		return SupervisorAction{Reason: fmt.Sprintf("retracement %.4f below callback rate %.4f", retracement, tier.CallbackRate)}
+	}
+
+	// Callback triggered: re-quote one tick above the new top-of-book.
+	const oneTick = 0.1
+	newPrice := bestBid + oneTick
+	estimate, _, err := calculateBuyOrderFillTimeWithBand(s.ProductID, quantity, metricsData, WindowSevenDays, DefaultATRPeriod, DefaultATRMultiplier)
+	if err != nil {
+		estimate = FillTimeEstimate{Low: math.Inf(1), Mid: math.Inf(1), High: math.Inf(1)}
+	}
+
+	s.armedTierIdx = -1
+	s.extremeDrift = 0
+	s.OrderPrice = newPrice
+
+	if s.FillTimeBudget > 0 && estimate.High > s.FillTimeBudget {
+		return SupervisorAction{
+			Abandon: true, NewPrice: newPrice,
+			PredictedFillTime: estimate.Mid, PredictedFillTimeLow: estimate.Low, PredictedFillTimeHigh: estimate.High,
+			Reason: fmt.Sprintf("worst-case predicted fill time %.0fs exceeds budget %.0fs", estimate.High, s.FillTimeBudget),
+		}
+	}
+
+	return SupervisorAction{
+		Requote: true, NewPrice: newPrice,
+		PredictedFillTime: estimate.Mid, PredictedFillTimeLow: estimate.Low, PredictedFillTimeHigh: estimate.High,
+		Reason: fmt.Sprintf("tier %d callback triggered (retraced %.4f)", s.armedTierIdx, retracement),
+	}
+}
@@ -0,0 +1,188 @@
+// pareto.go
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// defaultMaxParetoPoints bounds computeParetoFrontier's candidate count when
+// ExpansionOptions.MaxParetoPoints is left at its zero value.
+const defaultMaxParetoPoints = 64
+
+// maxParetoPoints resolves o.MaxParetoPoints, falling back to
+// defaultMaxParetoPoints like treeAnalysisConfig does for Workers/
+// RequestsPerSecond.
+func (o ExpansionOptions) maxParetoPoints() int {
+	if o.MaxParetoPoints > 0 {
+		return o.MaxParetoPoints
+	}
+	return defaultMaxParetoPoints
+}
+
+// computeParetoFrontier enumerates candidate strategies for acquiring
+// quantity of rootItemNameNorm - Craft (craftBaseIngredients, already priced
+// by analyzeTreeForCostsAndTimes), buying the root item directly (Primary or
+// Secondary, whichever getBestC10M prefers), and one variant per non-empty
+// subset of craftRecipeTree's immediate children with that subset bought
+// directly instead of crafted - then returns the non-dominated (TotalCost,
+// slowest fill time) subset via a standard 2D Pareto sweep: sort by cost
+// ascending and keep every entry whose fill time strictly improves on the
+// running minimum. Candidate generation stops once opts.maxParetoPoints()
+// candidates have been built, trading optimality for a bounded runtime on
+// recipes with many immediate children instead of the full 2^N subset
+// blowup the sketch warns about.
+func computeParetoFrontier(
+	ctx context.Context,
+	craftRecipeTree *CraftingStepNode,
+	craftBaseIngredients map[string]BaseIngredientDetail,
+	craftTotalCostRaw, craftSlowestFillTimeRaw float64,
+	rootItemNameNorm string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	opts ExpansionOptions,
+) []ExpansionResult {
+	limit := opts.maxParetoPoints()
+	var candidates []ExpansionResult
+
+	addCandidate := func(perspective string, baseIngredients map[string]BaseIngredientDetail, totalCostRaw, slowestFillTimeRaw float64) {
+		if len(candidates) >= limit {
+			return
+		}
+		possible := !math.IsInf(totalCostRaw, 0) && !math.IsNaN(totalCostRaw) &&
+			!math.IsInf(slowestFillTimeRaw, 0) && !math.IsNaN(slowestFillTimeRaw)
+		candidates = append(candidates, ExpansionResult{
+			BaseIngredients:                 baseIngredients,
+			TotalCost:                       toJSONFloat64(valueOrNaN(totalCostRaw)),
+			PerspectiveType:                 perspective,
+			TopLevelAction:                  perspective,
+			FinalCostMethod:                 perspective,
+			CalculationPossible:             possible,
+			SlowestIngredientBuyTimeSeconds: toJSONFloat64(valueOrNaN(slowestFillTimeRaw)),
+			FillTimeStats:                   computeFillTimeStats(fillTimesForBaseIngredients(ctx, baseIngredients, metricsMap)),
+		})
+	}
+
+	if craftRecipeTree != nil && !craftRecipeTree.IsBaseComponent && len(craftBaseIngredients) > 0 {
+		addCandidate("Craft", craftBaseIngredients, craftTotalCostRaw, craftSlowestFillTimeRaw)
+	}
+
+	rootPricing := priceBaseIngredient(ctx, rootItemNameNorm, quantity, apiResp, metricsMap, precision, opts)
+	if rootPricing.costValid {
+		addCandidate(rootPricing.method, map[string]BaseIngredientDetail{rootItemNameNorm: rootPricing.detail}, rootPricing.costRaw, rootPricing.fillTimeRaw)
+	}
+
+	if craftRecipeTree != nil && len(craftRecipeTree.Ingredients) > 0 && len(craftBaseIngredients) > 0 {
+		children := craftRecipeTree.Ingredients
+		if len(children) > 20 {
+			// 1<<20 subsets would dwarf limit anyway; cap the loop itself so
+			// construction time stays bounded even before addCandidate's own
+			// limit check kicks in.
+			children = children[:20]
+		}
+		for mask := 1; mask < (1 << len(children)); mask++ {
+			if ctx.Err() != nil || len(candidates) >= limit {
+				break
+			}
+
+			variantBase := make(map[string]BaseIngredientDetail, len(craftBaseIngredients))
+			for id, d := range craftBaseIngredients {
+				variantBase[id] = d
+			}
+
+			toggled := false
+			feasible := true
+			for i, child := range children {
+				if mask&(1<<i) == 0 || child.IsBaseComponent {
+					continue
+				}
+				removeSubtreeLeaves(variantBase, child)
+				childPricing := priceBaseIngredient(ctx, BAZAAR_ID(child.ItemName), child.QuantityNeeded, apiResp, metricsMap, precision, opts)
+				if !childPricing.costValid {
+					feasible = false
+					break
+				}
+				variantBase[BAZAAR_ID(child.ItemName)] = childPricing.detail
+				toggled = true
+			}
+			if !feasible || !toggled {
+				continue
+			}
+
+			totalCostRaw, slowestFillTimeRaw := sumBaseIngredients(ctx, variantBase, metricsMap)
+			addCandidate("PartialCraft", variantBase, totalCostRaw, slowestFillTimeRaw)
+		}
+	}
+
+	return paretoSweep(candidates)
+}
+
+// removeSubtreeLeaves deletes from base every base-ingredient entry
+// extractBaseIngredientsFromTree(node) would have contributed, so a caller
+// can splice in a single direct-purchase entry for node's own item instead
+// of node's own sub-ingredients.
+func removeSubtreeLeaves(base map[string]BaseIngredientDetail, node *CraftingStepNode) {
+	if node == nil {
+		return
+	}
+	if node.IsBaseComponent {
+		delete(base, BAZAAR_ID(node.ItemName))
+		return
+	}
+	for _, child := range node.Ingredients {
+		removeSubtreeLeaves(base, child)
+	}
+}
+
+// sumBaseIngredients totals BestCost and the slowest Primary-method fill
+// time (via fillTimesForBaseIngredients) across base, mirroring
+// calculateDetailedCostsAndFillTimes/analyzeTreeForCostsAndTimes's reduction
+// but over an already-priced map instead of re-pricing from scratch.
+func sumBaseIngredients(ctx context.Context, base map[string]BaseIngredientDetail, metricsMap map[string]ProductMetrics) (totalCostRaw, slowestFillTimeRaw float64) {
+	for _, detail := range base {
+		cost := float64(detail.BestCost)
+		if math.IsNaN(cost) || math.IsInf(cost, 0) {
+			return math.Inf(1), math.Inf(1)
+		}
+		totalCostRaw += cost
+	}
+	for _, t := range fillTimesForBaseIngredients(ctx, base, metricsMap) {
+		if math.IsInf(t, 1) {
+			return totalCostRaw, math.Inf(1)
+		}
+		if t > slowestFillTimeRaw {
+			slowestFillTimeRaw = t
+		}
+	}
+	return totalCostRaw, slowestFillTimeRaw
+}
+
+// paretoSweep keeps candidates' non-dominated (TotalCost, slowest fill time)
+// subset: sorted by cost ascending, an entry survives only if its fill time
+// strictly improves on every cheaper survivor's. Infeasible candidates
+// (CalculationPossible false) are dropped first.
+func paretoSweep(candidates []ExpansionResult) []ExpansionResult {
+	feasible := make([]ExpansionResult, 0, len(candidates))
+	for _, c := range candidates {
+		if c.CalculationPossible {
+			feasible = append(feasible, c)
+		}
+	}
+	sort.SliceStable(feasible, func(i, j int) bool {
+		return float64(feasible[i].TotalCost) < float64(feasible[j].TotalCost)
+	})
+
+	frontier := make([]ExpansionResult, 0, len(feasible))
+	minFillTime := math.Inf(1)
+	for _, c := range feasible {
+		fillTime := float64(c.SlowestIngredientBuyTimeSeconds)
+		if fillTime < minFillTime {
+			frontier = append(frontier, c)
+			minFillTime = fillTime
+		}
+	}
+	return frontier
+}
@@ -0,0 +1,171 @@
+// path_enumerator.go
+package main
+
+import "math"
+
+// defaultMaxPathCandidates is the default K for PathEnumerator: how many
+// alternate recipe-resolution paths to compare before optimizeItemProfit
+// commits to one. PerformDualExpansion only ever resolves two independent
+// cost perspectives for an item - PrimaryBased (confidence-gated C10M) and
+// SecondaryBased (the fallback C10M method, see expansion.go) - so
+// EnumeratePaths returns at most two paths today regardless of K; the cap
+// exists so a future perspective can slot in here without optimizeItemProfit
+// changing its call site.
+const defaultMaxPathCandidates = 5
+
+// PathSummary is one alternate recipe-resolution path optimizeItemProfit
+// compared for an item, scored at the same MaxFeasibleQuantity so paths are
+// directly comparable by profit. It deliberately carries no RecipeTree -
+// unlike ExpansionResult, which PerformDualExpansion can populate with one -
+// so alternates ride along in OptimizedItemResult without reintroducing the
+// RAM cost that field was stripped to avoid (see RecipeTree on
+// OptimizedItemResult).
+type PathSummary struct {
+	PathID           string  `json:"path_id"`
+	CostMethod       string  `json:"cost_method"`
+	Cost             float64 `json:"cost"`
+	Revenue          float64 `json:"revenue"`
+	Profit           float64 `json:"profit"`
+	CycleTimeSeconds float64 `json:"cycle_time_seconds"`
+	Feasible         bool    `json:"feasible"`
+}
+
+// PathEnumerator discovers alternate recipe-resolution paths for a target
+// item and scores each one so optimizeItemProfit can pick the most
+// profitable rather than always trusting whichever PerformDualExpansion
+// resolved as PrimaryBased.
+type PathEnumerator struct {
+	// MaxPaths caps how many candidate paths EnumeratePaths/ChoosePath will
+	// score; <= 0 defaults to defaultMaxPathCandidates.
+	MaxPaths int
+}
+
+// NewPathEnumerator builds a PathEnumerator capped at maxPaths, or
+// defaultMaxPathCandidates when maxPaths <= 0.
+func NewPathEnumerator(maxPaths int) *PathEnumerator {
+	if maxPaths <= 0 {
+		maxPaths = defaultMaxPathCandidates
+	}
+	return &PathEnumerator{MaxPaths: maxPaths}
+}
+
+// EnumeratePaths scores every recipe-resolution perspective a single
+// PerformDualExpansion call resolved for qty units of itemNameNorm, up to
+// pe.MaxPaths.
+func (pe *PathEnumerator) EnumeratePaths(
+	dual *DualExpansionResult,
+	apiResp *HypixelAPIResponse,
+	itemNameNorm string,
+	qty float64,
+	maxAllowedFillTime float64,
+) []PathSummary {
+	if dual == nil {
+		return nil
+	}
+	maxPaths := pe.MaxPaths
+	if maxPaths <= 0 {
+		maxPaths = defaultMaxPathCandidates
+	}
+
+	candidates := []struct {
+		id string
+		er ExpansionResult
+	}{
+		{"primary", dual.PrimaryBased},
+		{"secondary", dual.SecondaryBased},
+	}
+
+	instasellPrice := getBuyPrice(apiResp, itemNameNorm)
+	paths := make([]PathSummary, 0, len(candidates))
+	for _, c := range candidates {
+		if len(paths) >= maxPaths {
+			break
+		}
+		paths = append(paths, summarizePath(c.id, c.er, dual.TopLevelInstasellTimeSeconds, instasellPrice, qty, maxAllowedFillTime))
+	}
+	return paths
+}
+
+// ChoosePath runs EnumeratePaths and picks whichever candidate is both
+// feasible and has the highest profit, returning the full candidate list
+// (for OptimizedItemResult.AlternatePaths), the winning path's ID, and a
+// short human-readable reason for why it won - all three go straight onto
+// OptimizedItemResult in optimizeItemProfit. Ties and the all-infeasible
+// case both default to "primary", matching optimizeItemProfit's behavior
+// from before path enumeration existed.
+func (pe *PathEnumerator) ChoosePath(
+	dual *DualExpansionResult,
+	apiResp *HypixelAPIResponse,
+	itemNameNorm string,
+	qty float64,
+	maxAllowedFillTime float64,
+) (paths []PathSummary, chosenPathID string, reason string) {
+	paths = pe.EnumeratePaths(dual, apiResp, itemNameNorm, qty, maxAllowedFillTime)
+
+	chosenPathID = "primary"
+	bestProfit := math.Inf(-1)
+	haveBest := false
+	for _, p := range paths {
+		if !p.Feasible || math.IsNaN(p.Profit) {
+			continue
+		}
+		if !haveBest || p.Profit > bestProfit {
+			bestProfit = p.Profit
+			chosenPathID = p.PathID
+			haveBest = true
+		}
+	}
+
+	switch {
+	case !haveBest:
+		reason = "no alternate path was both feasible and profitable; defaulted to primary"
+	case chosenPathID == "primary":
+		reason = "primary path had the highest profit among feasible alternates"
+	default:
+		reason = "secondary path's profit exceeded primary's at the same quantity"
+	}
+	return paths, chosenPathID, reason
+}
+
+// summarizePath turns one ExpansionResult perspective into a PathSummary,
+// mirroring sampleProfitAtQty's cost/revenue/profit derivation
+// (profit_curve.go) but without spending another PerformDualExpansion call -
+// dual was already fetched by the caller.
+func summarizePath(pathID string, er ExpansionResult, topLevelInstasellTime JSONFloat64, instasellPrice, qty, maxAllowedFillTime float64) PathSummary {
+	summary := PathSummary{
+		PathID:           pathID,
+		CostMethod:       er.FinalCostMethod,
+		Cost:             math.NaN(),
+		Revenue:          math.NaN(),
+		Profit:           math.NaN(),
+		CycleTimeSeconds: math.NaN(),
+	}
+	if !er.CalculationPossible {
+		return summary
+	}
+
+	acqTime := float64(er.SlowestIngredientBuyTimeSeconds)
+	saleTime := float64(topLevelInstasellTime)
+	if math.IsNaN(acqTime) {
+		acqTime = math.Inf(1)
+	}
+	if math.IsNaN(saleTime) {
+		saleTime = math.Inf(1)
+	}
+	cycleTime := acqTime + saleTime
+	summary.CycleTimeSeconds = cycleTime
+	summary.Feasible = cycleTime <= maxAllowedFillTime && cycleTime >= 0
+
+	cost := float64(er.TotalCost)
+	if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return summary
+	}
+	summary.Cost = cost
+
+	if instasellPrice <= 0 || math.IsNaN(instasellPrice) || math.IsInf(instasellPrice, 0) {
+		return summary
+	}
+	summary.Revenue = instasellPrice * qty
+	summary.Profit = summary.Revenue - summary.Cost
+	return summary
+}
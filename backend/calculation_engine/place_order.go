@@ -0,0 +1,113 @@
+// place_order.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// This file is the gated entry point this chunk's request describes:
+// PlaceOrderIfProfitable checks a candidate order's projected economics and
+// CraftState's (craft_state.go) daily budgets before approving it. There's
+// no getEstimatedFillTime in this tree to consult as named - the existing
+// equivalent is calculateBuyOrderFillTime (fill_time.go), which is what
+// this calls instead, the same way expansion.go's own per-ingredient fill
+// time check does.
+
+// OrderPlacementDecision is PlaceOrderIfProfitable's result: Approved is
+// false whenever any check below failed, with Reason naming which one - a
+// caller (or the /state/orders HTTP endpoint) never has to re-derive why an
+// order was refused.
+type OrderPlacementDecision struct {
+	ProductID                string  `json:"product_id"`
+	Quantity                 float64 `json:"quantity"`
+	Approved                 bool    `json:"approved"`
+	Reason                   string  `json:"reason,omitempty"`
+	EstimatedFillTimeSeconds float64 `json:"estimated_fill_time_seconds"`
+	EstimatedProfitPerHour   float64 `json:"estimated_profit_per_hour"`
+	EstimatedCoinCost        float64 `json:"estimated_coin_cost"`
+}
+
+// PlaceOrderIfProfitable decides whether an order for quantity units of
+// productID is worth placing: it prices the order off the live book
+// (getBuyPrice/getSellPrice), estimates its fill time via
+// calculateBuyOrderFillTime, rejects anything below minProfitPerHour, then
+// consults DefaultCraftState's BudgetExhausted/CoinBudgetExhausted before
+// finally approving and recording the placement via RecordOrderPlacement.
+// It never talks to an exchange itself - "placing" an order here means
+// approving it and updating the daily accumulators; wiring the approved
+// decision to an actual order API is left to the caller.
+func PlaceOrderIfProfitable(productID string, quantity float64, minProfitPerHour float64) (*OrderPlacementDecision, error) {
+	ctx := context.Background()
+	itemIDNorm := BAZAAR_ID(productID)
+	decision := &OrderPlacementDecision{ProductID: itemIDNorm, Quantity: quantity}
+
+	if quantity <= 0 {
+		decision.Reason = "quantity must be positive"
+		return decision, nil
+	}
+
+	apiResp, err := getApiResponse(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching bazaar data for %s: %w", itemIDNorm, err)
+	}
+	metricsMap := getCurrentMetricsMap()
+
+	fillTime, _, err := calculateBuyOrderFillTime(ctx, itemIDNorm, quantity, getMetrics(metricsMap, itemIDNorm))
+	if err != nil || math.IsNaN(fillTime) || math.IsInf(fillTime, 0) || fillTime <= 0 {
+		decision.Reason = fmt.Sprintf("fill time not calculable for %s: %v", itemIDNorm, err)
+		return decision, nil
+	}
+	decision.EstimatedFillTimeSeconds = fillTime
+
+	buyPrice := getBuyPrice(apiResp, itemIDNorm)
+	sellPrice := getSellPrice(apiResp, itemIDNorm)
+	if buyPrice <= 0 {
+		decision.Reason = fmt.Sprintf("no live buy price for %s", itemIDNorm)
+		return decision, nil
+	}
+	coinCost := buyPrice * quantity
+	decision.EstimatedCoinCost = coinCost
+
+	profit := (sellPrice - buyPrice) * quantity
+	profitPerHour := profit / fillTime * 3600
+	decision.EstimatedProfitPerHour = profitPerHour
+
+	if profitPerHour < minProfitPerHour {
+		decision.Reason = fmt.Sprintf("estimated profit/hour %.2f is below the requested minimum %.2f", profitPerHour, minProfitPerHour)
+		return decision, nil
+	}
+
+	craftState, err := DefaultCraftState()
+	if err != nil {
+		return nil, fmt.Errorf("loading craft state: %w", err)
+	}
+	if craftState.BudgetExhausted(itemIDNorm) {
+		decision.Reason = fmt.Sprintf("daily volume/fee budget exhausted for %s", itemIDNorm)
+		return decision, nil
+	}
+	if craftState.CoinBudgetExhausted(itemIDNorm) {
+		decision.Reason = fmt.Sprintf("daily coin budget exhausted for %s", itemIDNorm)
+		return decision, nil
+	}
+
+	craftState.RecordOrderPlacement(itemIDNorm, quantity, coinCost, profit)
+	decision.Approved = true
+	return decision, nil
+}
+
+// craftStateHandler exposes DefaultCraftState's current accumulators and
+// budgets as read-only JSON for a long-running daemon's operator to inspect
+// without having to open craft_state.json on disk directly.
+func craftStateHandler(w http.ResponseWriter, r *http.Request) {
+	craftState, err := DefaultCraftState()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("loading craft state: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(craftState.Snapshot())
+}
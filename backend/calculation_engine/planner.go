@@ -0,0 +1,332 @@
+// planner.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// plannerTimeBucketSeconds is the DP's time-axis granularity: session time
+// is discretized into buckets of this size so the state table has a finite
+// number of cells. Coarser than calculateFillTime's own precision, but fine
+// enough that a session cut a bucket early/late doesn't change which action
+// looks cheapest.
+const plannerTimeBucketSeconds = 30.0
+
+// EncodeIndex flattens a (itemIdx, timeBucket) planner state into the
+// single index the DP table is keyed by, timeBucket-major so a fixed item
+// occupies a contiguous run of buckets. Session coin spend is tracked as the
+// value minimized at each cell rather than as its own index dimension -
+// indexing by a continuous coin amount would make the table unbounded, so
+// (collapsed to the dimensions this domain actually has: which item, how
+// much session time is left) only item and time are indexed.
+func EncodeIndex(itemIdx, timeBucket, numTimeBuckets int) int {
+	return itemIdx*numTimeBuckets + timeBucket
+}
+
+// DecodeIndex inverts EncodeIndex.
+func DecodeIndex(idx, numTimeBuckets int) (itemIdx, timeBucket int) {
+	return idx / numTimeBuckets, idx % numTimeBuckets
+}
+
+// PlannerActionKind is one of the three ways PlanCraftingSession can move a
+// session toward its target item.
+type PlannerActionKind string
+
+const (
+	PlannerActionInstabuy PlannerActionKind = "instabuy"  // buy at the top sell order; fast, costs more
+	PlannerActionBuyOrder PlannerActionKind = "buy_order" // place a buy order; slower (calculateFillTime), costs less
+	PlannerActionCraft    PlannerActionKind = "craft"     // consume ingredients already acquired to produce this item
+)
+
+// PlannerAction is one step of a PlannerPlan.
+type PlannerAction struct {
+	ItemID       string            `json:"item_id"`
+	Kind         PlannerActionKind `json:"kind"`
+	Quantity     float64           `json:"quantity"`
+	CoinsSpent   float64           `json:"coins_spent"`
+	SecondsSpent float64           `json:"seconds_spent"`
+}
+
+// PlannerPlan is PlanCraftingSession's result: the chosen top-level action
+// for reaching qty of the target item, and the session cost/time it was
+// priced at. It does not reconstruct every ingredient-level sub-action,
+// since the table's craft cells are filled via a min-plus convolution across
+// ingredients (see resolveCraftCosts) that tracks the best split of the time
+// budget between ingredients but not, per split, which one of possibly
+// several winning splits was used - a full multi-level action sequence
+// would need that bookkeeping too, which is out of scope here.
+type PlannerPlan struct {
+	Action       PlannerAction `json:"action"`
+	TotalCoins   float64       `json:"total_coins"`
+	TotalSeconds float64       `json:"total_seconds"`
+}
+
+// plannerTable fills the (item, timeBucket) state table PlanCraftingSession
+// is built around: table[EncodeIndex(itemIdx, b, numBuckets)] is the
+// cheapest coin cost of having 1 unit of the itemIdx'th item in hand after
+// spending at most b*plannerTimeBucketSeconds of session time, or +Inf if
+// unreachable within that budget.
+type plannerTable struct {
+	ctx            context.Context
+	graph          map[string]recipeGraphNode
+	apiResp        *HypixelAPIResponse
+	metricsMap     map[string]ProductMetrics
+	numTimeBuckets int
+
+	itemIndex map[string]int
+	cells     map[int]float64 // EncodeIndex(...) -> cheapest cost
+	computed  map[string]bool // itemIDNorm -> costAtBucket already filled into cells
+	computing map[string]bool // itemIDNorm -> currently being resolved (cycle guard)
+}
+
+// costAtBucket returns itemIDNorm's per-unit cost array over every time
+// bucket 0..numTimeBuckets-1, computing and caching it (into t.cells) on
+// first use. Returns false if itemIDNorm can't be resolved at all (no
+// acquisition method and no craftable recipe), or if it's mid-computation on
+// the current call stack (a recipe cycle; treated the same way cost_dag.go's
+// DP treats an unresolved back-edge - as simply not usable here).
+func (t *plannerTable) costAtBucket(itemIDNorm string) ([]float64, bool) {
+	if t.computing[itemIDNorm] {
+		return nil, false
+	}
+	idx, seen := t.itemIndex[itemIDNorm]
+	if !seen {
+		idx = len(t.itemIndex)
+		t.itemIndex[itemIDNorm] = idx
+	}
+	if t.computed[itemIDNorm] {
+		costs := make([]float64, t.numTimeBuckets)
+		allInf := true
+		for b := 0; b < t.numTimeBuckets; b++ {
+			costs[b] = t.cells[EncodeIndex(idx, b, t.numTimeBuckets)]
+			if !math.IsInf(costs[b], 1) {
+				allInf = false
+			}
+		}
+		if allInf {
+			return nil, false
+		}
+		return costs, true
+	}
+	t.computing[itemIDNorm] = true
+	defer delete(t.computing, itemIDNorm)
+
+	costs := make([]float64, t.numTimeBuckets)
+	for b := range costs {
+		costs[b] = math.Inf(1)
+	}
+
+	if bestCost, method, _, _, _, err := getBestC10M(t.ctx, itemIDNorm, 1, t.apiResp, t.metricsMap, PrecisionFloat, nil); err == nil && method != "N/A" && !math.IsInf(bestCost, 0) && bestCost > 0 {
+		switch method {
+		case "Primary":
+			seconds := 0.0
+			if metricsData, ok := safeGetMetricsData(t.metricsMap, itemIDNorm); ok {
+				if fillTime, _, fillErr := calculateBuyOrderFillTime(t.ctx, itemIDNorm, 1, metricsData); fillErr == nil && !math.IsNaN(fillTime) && !math.IsInf(fillTime, 0) && fillTime >= 0 {
+					seconds = fillTime
+				}
+			}
+			startBucket := int(math.Ceil(seconds / plannerTimeBucketSeconds))
+			for b := startBucket; b < t.numTimeBuckets; b++ {
+				if bestCost < costs[b] {
+					costs[b] = bestCost
+				}
+			}
+		default: // "Secondary"/"Craft" via getBestC10M is still an instabuy price here; instant.
+			for b := range costs {
+				if bestCost < costs[b] {
+					costs[b] = bestCost
+				}
+			}
+		}
+	}
+
+	if node, ok := t.graph[itemIDNorm]; ok && node.CraftedAmount > 0 {
+		if craftCosts, ok := t.resolveCraftCosts(node); ok {
+			for b := range costs {
+				perUnit := craftCosts[b] / node.CraftedAmount
+				if perUnit < costs[b] {
+					costs[b] = perUnit
+				}
+			}
+		}
+	}
+
+	for b, c := range costs {
+		t.cells[EncodeIndex(idx, b, t.numTimeBuckets)] = c
+	}
+	t.computed[itemIDNorm] = true
+
+	allInf := true
+	for _, c := range costs {
+		if !math.IsInf(c, 1) {
+			allInf = false
+			break
+		}
+	}
+	if allInf {
+		return nil, false
+	}
+	return costs, true
+}
+
+// resolveCraftCosts combines node's ingredients' own costAtBucket arrays
+// into the coin cost of one full craft, per time bucket, via a min-plus
+// convolution: combined[b] is the cheapest way to have gathered every
+// ingredient so far using at most b buckets total, sequentially over a
+// session (time spent on one ingredient isn't available for the next).
+func (t *plannerTable) resolveCraftCosts(node recipeGraphNode) ([]float64, bool) {
+	combined := make([]float64, t.numTimeBuckets)
+	for b := range combined {
+		combined[b] = 0
+	}
+
+	ingredientIDs := make([]string, 0, len(node.Ingredients))
+	for id := range node.Ingredients {
+		ingredientIDs = append(ingredientIDs, id)
+	}
+	sort.Strings(ingredientIDs) // deterministic convolution order
+
+	for _, ingID := range ingredientIDs {
+		qtyPerCraft := node.Ingredients[ingID]
+		ingCosts, ok := t.costAtBucket(ingID)
+		if !ok {
+			return nil, false
+		}
+		next := make([]float64, t.numTimeBuckets)
+		for b := range next {
+			best := math.Inf(1)
+			for s := 0; s <= b; s++ {
+				if math.IsInf(combined[s], 1) {
+					continue
+				}
+				ingCost := ingCosts[b-s]
+				if math.IsInf(ingCost, 1) {
+					continue
+				}
+				cand := combined[s] + ingCost*qtyPerCraft
+				if cand < best {
+					best = cand
+				}
+			}
+			next[b] = best
+		}
+		combined = next
+	}
+	return combined, true
+}
+
+// PlanCraftingSession treats acquiring qty of targetItemID as a
+// bounded-resource shortest-path problem: it fills a (item, timeBucket)
+// state table (EncodeIndex/DecodeIndex, via plannerTable) where each cell
+// holds the cheapest way to have 1 unit of that item in hand within that
+// much session time, built up from three kinds of transitions - instabuying
+// an ingredient (fast, costs more), placing a buy order for it (cheaper,
+// slower - fill time via calculateBuyOrderFillTime, this repo's
+// calculateFillTime equivalent), or crafting it from its ingredients' own
+// cheapest-reachable states (resolveCraftCosts's min-plus convolution across
+// ingredients). maxSessionSeconds bounds the time axis; startingCoins is
+// checked against the final total. visit optionally names item IDs that
+// must appear somewhere in targetItemID's ingredient closure (the recipe
+// graph loadRecipeGraph builds, arbitrage.go - one recipe path per item, not
+// every alternative) - a name outside that closure is reported as an error
+// rather than silently ignored.
+func PlanCraftingSession(ctx context.Context, itemFilesDir, targetItemID string, qty, startingCoins, maxSessionSeconds float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, visit []string) (*PlannerPlan, error) {
+	if qty <= 0 {
+		return nil, fmt.Errorf("quantity must be positive (got %.2f)", qty)
+	}
+	if maxSessionSeconds <= 0 {
+		return nil, fmt.Errorf("max session seconds must be positive (got %.2f)", maxSessionSeconds)
+	}
+
+	graph, err := loadRecipeGraph(itemFilesDir, apiResp)
+	if err != nil {
+		return nil, fmt.Errorf("loading recipe graph: %w", err)
+	}
+
+	numTimeBuckets := int(math.Ceil(maxSessionSeconds/plannerTimeBucketSeconds)) + 1
+	table := &plannerTable{
+		ctx: ctx, graph: graph, apiResp: apiResp, metricsMap: metricsMap,
+		numTimeBuckets: numTimeBuckets,
+		itemIndex:      make(map[string]int),
+		cells:          make(map[int]float64),
+		computed:       make(map[string]bool),
+		computing:      make(map[string]bool),
+	}
+
+	rootNorm := BAZAAR_ID(targetItemID)
+	for _, v := range visit {
+		vNorm := BAZAAR_ID(v)
+		if _, ok := graph[rootNorm].Ingredients[vNorm]; ok {
+			continue
+		}
+		if !ingredientClosureContains(graph, rootNorm, vNorm, make(map[string]bool)) {
+			return nil, fmt.Errorf("--visit item '%s' does not appear in %s's ingredient closure (this planner considers one recipe path per item, not every alternative)", vNorm, rootNorm)
+		}
+	}
+
+	costs, ok := table.costAtBucket(rootNorm)
+	if !ok {
+		return nil, fmt.Errorf("no acquisition path for '%s' within %.0f session seconds", rootNorm, maxSessionSeconds)
+	}
+	bestBucket := -1
+	bestCost := math.Inf(1)
+	for b, c := range costs {
+		if c < bestCost {
+			bestCost = c
+			bestBucket = b
+		}
+	}
+	if bestBucket < 0 {
+		return nil, fmt.Errorf("no acquisition path for '%s' within %.0f session seconds", rootNorm, maxSessionSeconds)
+	}
+
+	totalCoins := bestCost * qty
+	totalSeconds := float64(bestBucket) * plannerTimeBucketSeconds
+	if startingCoins > 0 && totalCoins > startingCoins {
+		return nil, fmt.Errorf("cheapest plan for %.2f x %s costs %.2f coins, exceeding startingCoins %.2f", qty, rootNorm, totalCoins, startingCoins)
+	}
+
+	kind := PlannerActionInstabuy
+	if node, ok := graph[rootNorm]; ok && node.CraftedAmount > 0 {
+		if craftCosts, ok := table.resolveCraftCosts(node); ok && craftCosts[bestBucket]/node.CraftedAmount <= bestCost+1e-9 {
+			kind = PlannerActionCraft
+		}
+	}
+	if kind == PlannerActionInstabuy {
+		if _, method, _, _, _, err := getBestC10M(ctx, rootNorm, 1, apiResp, metricsMap, PrecisionFloat, nil); err == nil && method == "Primary" {
+			kind = PlannerActionBuyOrder
+		}
+	}
+
+	return &PlannerPlan{
+		Action:       PlannerAction{ItemID: rootNorm, Kind: kind, Quantity: qty, CoinsSpent: totalCoins, SecondsSpent: totalSeconds},
+		TotalCoins:   totalCoins,
+		TotalSeconds: totalSeconds,
+	}, nil
+}
+
+// ingredientClosureContains is a plain DFS (not through plannerTable) over
+// graph checking whether target is reachable from itemID via recipe
+// ingredient edges, used only to validate --visit names up front.
+func ingredientClosureContains(graph map[string]recipeGraphNode, itemID, target string, visited map[string]bool) bool {
+	if visited[itemID] {
+		return false
+	}
+	visited[itemID] = true
+	node, ok := graph[itemID]
+	if !ok {
+		return false
+	}
+	for ingID := range node.Ingredients {
+		if ingID == target {
+			return true
+		}
+		if ingredientClosureContains(graph, ingID, target, visited) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,385 @@
+// position_tracker.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RecordedOrder is one getBestC10M prediction, optionally later closed out
+// with what actually happened - the smallest unit PositionTracker persists,
+// mirroring bbgo's Position/ProfitStats pattern of a plain JSON-serializable
+// record rather than a database row.
+type RecordedOrder struct {
+	OrderID       string    `json:"order_id"`
+	ItemID        string    `json:"item_id"`
+	Quantity      float64   `json:"quantity"`
+	Method        string    `json:"method"`
+	PredictedCost float64   `json:"predicted_cost"`
+	PredictedRR   float64   `json:"predicted_rr"`
+	RecordedAt    time.Time `json:"recorded_at"`
+
+	Filled       bool      `json:"filled"`
+	ActualCost   float64   `json:"actual_cost,omitempty"`
+	ActualRounds int       `json:"actual_rounds,omitempty"`
+	FilledAt     time.Time `json:"filled_at,omitempty"`
+}
+
+// ItemCalibration summarizes how well getBestC10M's Primary-path
+// predictions have tracked reality for one item, derived from every
+// RecordFill call seen for it so far. MeanPredictionErrorPct is the mean of
+// (PredictedCost-ActualCost)/ActualCost across filled orders, so a
+// systematic over-estimate shows up as a positive mean instead of washing
+// out against the absolute error - the same convention
+// C10MBacktestReport.MeanResidualPct uses. MeanRRBiasRounds is the mean of
+// PredictedRR-ActualRounds over the same orders.
+type ItemCalibration struct {
+	ItemID                 string  `json:"item_id"`
+	SampleCount            int     `json:"sample_count"`
+	MeanPredictionErrorPct float64 `json:"mean_prediction_error_pct"`
+	MeanRRBiasRounds       float64 `json:"mean_rr_bias_rounds"`
+}
+
+// PositionTracker records every buy/sell decision getBestC10M (or
+// getBestC10MWithTracker) produces and, once a caller reports how an order
+// actually filled via RecordFill, accumulates a running per-item
+// calibration error - so CorrectionFactor can feed a self-tuning correction
+// back into future predictions for items where the Hypixel-style formula
+// systematically over- or under-estimates cost. Persisted to disk as JSON,
+// loaded on startup and saved on shutdown, the same pattern CraftState
+// (craft_state.go) uses for its own rolling accumulators.
+type PositionTracker struct {
+	mu sync.Mutex
+
+	Orders  map[string]*RecordedOrder `json:"orders"`
+	NextSeq int64                     `json:"next_seq"`
+
+	// ErrorPctSum/RRBiasSum/SampleCount are running sums over every filled
+	// order seen for an item, keyed by normalized item ID - cheap O(1)
+	// accumulators rather than replaying the full Orders history on every
+	// Calibration call.
+	ErrorPctSum map[string]float64 `json:"error_pct_sum"`
+	RRBiasSum   map[string]float64 `json:"rr_bias_sum"`
+	SampleCount map[string]int     `json:"sample_count"`
+}
+
+// NewPositionTracker returns an empty PositionTracker.
+func NewPositionTracker() *PositionTracker {
+	return &PositionTracker{
+		Orders:      make(map[string]*RecordedOrder),
+		ErrorPctSum: make(map[string]float64),
+		RRBiasSum:   make(map[string]float64),
+		SampleCount: make(map[string]int),
+	}
+}
+
+// LoadPositionTracker reads a PositionTracker previously written by Save at
+// path. A missing file is not an error - it returns a fresh
+// NewPositionTracker, the same way a first-ever run would start.
+func LoadPositionTracker(path string) (*PositionTracker, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewPositionTracker(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading position tracker '%s': %w", path, err)
+	}
+	var t PositionTracker
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parsing position tracker '%s': %w", path, err)
+	}
+	if t.Orders == nil {
+		t.Orders = make(map[string]*RecordedOrder)
+	}
+	if t.ErrorPctSum == nil {
+		t.ErrorPctSum = make(map[string]float64)
+	}
+	if t.RRBiasSum == nil {
+		t.RRBiasSum = make(map[string]float64)
+	}
+	if t.SampleCount == nil {
+		t.SampleCount = make(map[string]int)
+	}
+	return &t, nil
+}
+
+// Save atomically writes t to path (write to a sibling .tmp file, then
+// rename over path), the same write-then-rename pattern CraftState.Save
+// uses so a crash mid-write can never leave path holding a half-written
+// file.
+func (t *PositionTracker) Save(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding position tracker: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing position tracker '%s': %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replacing position tracker '%s': %w", path, err)
+	}
+	return nil
+}
+
+// RecordPrediction stores one getBestC10M-style prediction and returns the
+// orderID a caller must hold onto to later close it out via RecordFill.
+func (t *PositionTracker) RecordPrediction(itemID string, quantity float64, bestMethod string, bestCost, rrValue float64) (orderID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.NextSeq++
+	orderID = strconv.FormatInt(t.NextSeq, 36)
+	t.Orders[orderID] = &RecordedOrder{
+		OrderID:       orderID,
+		ItemID:        BAZAAR_ID(itemID),
+		Quantity:      quantity,
+		Method:        bestMethod,
+		PredictedCost: bestCost,
+		PredictedRR:   rrValue,
+		RecordedAt:    time.Now(),
+	}
+	return orderID
+}
+
+// RecordFill closes out orderID with how it actually filled, and - for
+// Primary orders with a finite prediction - folds the resulting residual
+// into its item's running calibration accumulators. Calling RecordFill a
+// second time for the same orderID returns an error rather than silently
+// double-counting the residual.
+func (t *PositionTracker) RecordFill(orderID string, actualCost float64, actualRounds int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	order, ok := t.Orders[orderID]
+	if !ok {
+		return fmt.Errorf("no recorded order with ID %q", orderID)
+	}
+	if order.Filled {
+		return fmt.Errorf("order %q already has a recorded fill", orderID)
+	}
+	if actualCost <= 0 || math.IsNaN(actualCost) || math.IsInf(actualCost, 0) {
+		return fmt.Errorf("actualCost must be positive and finite (got %.2f)", actualCost)
+	}
+
+	order.Filled = true
+	order.ActualCost = actualCost
+	order.ActualRounds = actualRounds
+	order.FilledAt = time.Now()
+
+	if order.Method != "Primary" || math.IsInf(order.PredictedCost, 0) || math.IsNaN(order.PredictedCost) {
+		return nil
+	}
+
+	errorPct := (order.PredictedCost - actualCost) / actualCost
+	t.ErrorPctSum[order.ItemID] += errorPct
+	t.SampleCount[order.ItemID]++
+	if !math.IsInf(order.PredictedRR, 0) && !math.IsNaN(order.PredictedRR) {
+		t.RRBiasSum[order.ItemID] += order.PredictedRR - float64(actualRounds)
+	}
+	return nil
+}
+
+// Calibration returns itemID's current rolling calibration statistics. ok
+// is false until at least one Primary order has been recorded via
+// RecordFill for it.
+func (t *PositionTracker) Calibration(itemID string) (calibration ItemCalibration, ok bool) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count, hasCount := t.SampleCount[itemIDNorm]
+	if !hasCount || count <= 0 {
+		return ItemCalibration{}, false
+	}
+	return ItemCalibration{
+		ItemID:                 itemIDNorm,
+		SampleCount:            count,
+		MeanPredictionErrorPct: (t.ErrorPctSum[itemIDNorm] / float64(count)) * 100,
+		MeanRRBiasRounds:       t.RRBiasSum[itemIDNorm] / float64(count),
+	}, true
+}
+
+// maxCorrectionPct bounds how far CorrectionFactor will move c10mPrimary
+// away from 1.0 in either direction - a handful of noisy early samples
+// shouldn't be able to swing a correction factor to, say, 0.1x.
+const maxCorrectionPct = 0.5
+
+// CorrectionFactor returns the multiplicative correction
+// getBestC10MWithTracker applies to c10mPrimary for itemID: if past
+// predictions have run MeanPredictionErrorPct% too high on average, this
+// scales the next prediction back down by roughly that much (clamped to
+// +/-maxCorrectionPct), and returns 1.0 (no correction) until at least one
+// fill has been recorded.
+func (t *PositionTracker) CorrectionFactor(itemID string) float64 {
+	calibration, ok := t.Calibration(itemID)
+	if !ok {
+		return 1.0
+	}
+	errFrac := calibration.MeanPredictionErrorPct / 100
+	if errFrac > maxCorrectionPct {
+		errFrac = maxCorrectionPct
+	} else if errFrac < -maxCorrectionPct {
+		errFrac = -maxCorrectionPct
+	}
+	return 1.0 - errFrac
+}
+
+var (
+	defaultPositionTracker     *PositionTracker
+	defaultPositionTrackerOnce sync.Once
+	defaultPositionTrackerErr  error
+)
+
+// DefaultPositionTrackerPath is where DefaultPositionTracker loads from and
+// the process's shutdown hook (see main.go) saves to, overridable before the
+// first call the same way DefaultCraftStatePath is.
+var DefaultPositionTrackerPath = "position_tracker.json"
+
+// DefaultPositionTracker lazily loads the package-wide PositionTracker from
+// DefaultPositionTrackerPath, for callers (getBestC10MWithTracker) that
+// don't hold a PositionTracker reference of their own.
+func DefaultPositionTracker() (*PositionTracker, error) {
+	defaultPositionTrackerOnce.Do(func() {
+		defaultPositionTracker, defaultPositionTrackerErr = LoadPositionTracker(DefaultPositionTrackerPath)
+	})
+	return defaultPositionTracker, defaultPositionTrackerErr
+}
+
+// watchForShutdownAndSavePositionTracker blocks until the process receives
+// SIGINT/SIGTERM, saves DefaultPositionTracker to DefaultPositionTrackerPath,
+// then exits - mirroring watchForShutdownAndSaveCraftState so recorded
+// predictions and their calibration accumulators survive a restart too.
+func watchForShutdownAndSavePositionTracker() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	if tracker, err := DefaultPositionTracker(); err == nil {
+		if err := tracker.Save(DefaultPositionTrackerPath); err != nil {
+			log.Printf("PositionTracker: failed to save '%s' on shutdown: %v", DefaultPositionTrackerPath, err)
+		}
+	}
+	os.Exit(0)
+}
+
+// getBestC10MWithTracker is getBestC10M plus tracker's self-tuning
+// correction: before the Primary-vs-Secondary comparison, c10mPrimary is
+// scaled by tracker.CorrectionFactor(itemID), and the final decision is
+// recorded via tracker.RecordPrediction so a caller can later close the
+// loop with tracker.RecordFill once the order's real outcome is known.
+// orderID is empty if the prediction couldn't be recorded (e.g. invalid
+// quantity/prices) - callers should only call RecordFill when orderID is
+// non-empty. This is a separate entry point rather than a change to
+// getBestC10M's own signature, for the same reason getBestC10MDepth is:
+// getBestC10M already has many callers relying on its exact return shape.
+func getBestC10MWithTracker(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	tracker *PositionTracker,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, orderID string, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	bestCost = math.Inf(1)
+	bestMethod = "N/A"
+	associatedCost = math.NaN()
+	rrValue = math.NaN()
+	ifValue = math.NaN()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		return
+	}
+	if quantity <= 0 {
+		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		return 0, "N/A", 0, 0, 0, "", err
+	}
+
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+	if !apiOk {
+		err = fmt.Errorf("API data not found for %s", itemIDNorm)
+		return
+	}
+
+	var sellP, buyP float64 = math.NaN(), math.NaN()
+	if len(productData.SellSummary) > 0 {
+		sellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	if sellP <= 0 || buyP <= 0 || math.IsNaN(sellP) || math.IsNaN(buyP) || math.IsInf(sellP, 0) || math.IsInf(buyP, 0) {
+		err = fmt.Errorf("invalid prices from API for %s (sP: %.2f, bP: %.2f)", itemIDNorm, sellP, buyP)
+		return
+	}
+
+	c10mSec := quantity * buyP
+	if math.IsNaN(c10mSec) || c10mSec < 0 || math.IsInf(c10mSec, 0) {
+		c10mSec = math.Inf(1)
+	}
+
+	if !metricsOk {
+		if math.IsInf(c10mSec, 0) {
+			err = fmt.Errorf("metrics missing and secondary C10M failed for %s", itemIDNorm)
+			return
+		}
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+		orderID = tracker.RecordPrediction(itemIDNorm, quantity, bestMethod, bestCost, rrValue)
+		err = fmt.Errorf("metrics not found for %s, only Secondary C10M available", itemIDNorm)
+		return
+	}
+
+	c10mPrim, _, calcIF, calcRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, quantity, sellP, buyP, metricsData, nil)
+	if calcErr != nil {
+		err = calcErr
+	}
+	if !math.IsInf(c10mPrim, 0) && !math.IsNaN(c10mPrim) {
+		c10mPrim *= tracker.CorrectionFactor(itemIDNorm)
+	}
+
+	validPrim := !math.IsInf(c10mPrim, 0) && !math.IsNaN(c10mPrim) && c10mPrim >= 0
+	validSec := !math.IsInf(c10mSec, 0) && !math.IsNaN(c10mSec) && c10mSec >= 0
+
+	switch {
+	case validPrim && validSec:
+		if costLessOrEqual(c10mPrim, c10mSec, precision) {
+			bestCost, bestMethod, associatedCost, rrValue, ifValue = c10mPrim, "Primary", quantity*sellP, calcRR, calcIF
+		} else {
+			bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+		}
+	case validPrim:
+		bestCost, bestMethod, associatedCost, rrValue, ifValue = c10mPrim, "Primary", quantity*sellP, calcRR, calcIF
+	case validSec:
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+	default:
+		if err == nil {
+			err = fmt.Errorf("failed to determine any valid C10M for %s (both Primary/Secondary results invalid)", itemIDNorm)
+		}
+	}
+
+	if bestMethod != "Primary" || math.IsInf(rrValue, 0) || math.IsNaN(rrValue) {
+		rrValue = math.NaN()
+	}
+	if bestMethod != "Primary" || math.IsInf(ifValue, 0) || math.IsNaN(ifValue) {
+		ifValue = math.NaN()
+	}
+
+	orderID = tracker.RecordPrediction(itemIDNorm, quantity, bestMethod, bestCost, rrValue)
+	return
+}
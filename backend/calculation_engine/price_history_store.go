@@ -0,0 +1,446 @@
+// price_history_store.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriceSnapshot is one timestamped recording of a product's averaged
+// instabuy/instasell price, the unit PriceHistoryStore retains. It mirrors
+// MetricsSample's shape (serial_metrics_store.go) but over AveragedMetrics'
+// price fields rather than the order-flow counters that file tracks.
+type PriceSnapshot struct {
+	TimestampUnix  int64   `json:"ts"`
+	InstabuyPrice  float64 `json:"instabuy_price"`
+	InstasellPrice float64 `json:"instasell_price"`
+}
+
+// TrendStats is Trend's result: mean and population standard deviation of
+// InstabuyPrice/InstasellPrice over the requested window, plus an
+// exponential moving average of InstasellPrice over trendEMAHalfLife - the
+// smoothed figure calculateProfit's avgWindow should use instead of a single
+// most-recent quote.
+type TrendStats struct {
+	InstabuyMean    float64 `json:"instabuy_mean"`
+	InstabuyStdDev  float64 `json:"instabuy_stddev"`
+	InstasellMean   float64 `json:"instasell_mean"`
+	InstasellStdDev float64 `json:"instasell_stddev"`
+	InstasellEMA    float64 `json:"instasell_ema"`
+	SampleCount     int     `json:"sample_count"`
+	WindowStart     time.Time `json:"window_start"`
+	WindowEnd       time.Time `json:"window_end"`
+}
+
+// trendEMAHalfLife is the half-life used for InstasellEMA - chosen to match
+// the 7-day EMA the dashboard historically quoted informally from
+// QuickStatus.SellMovingWeek before this store existed.
+const trendEMAHalfLife = 7 * 24 * time.Hour
+
+// PriceHistoryStore retains a per-item append-only log of PriceSnapshot,
+// mirroring SerialMetricsStore's dir/<itemID>.jsonl-plus-in-memory-buffer
+// design (see serial_metrics_store.go) but keyed on the 5-minute
+// AveragedMetrics cycle (main.go's updateLatestMetrics) rather than the live
+// poll cadence SerialMetricsStore tracks. Kept as a separate store, not a
+// generalization of SerialMetricsStore, because the two retain different
+// fields at a different cadence and callers only ever need one or the other.
+type PriceHistoryStore struct {
+	dir       string
+	retention time.Duration
+
+	mu      sync.RWMutex
+	samples map[string][]PriceSnapshot
+}
+
+// NewPriceHistoryStore creates a store rooted at dir (created if missing)
+// and loads any samples already on disk for every <itemID>.jsonl file found
+// there, trimming each to retention. A zero or negative retention defaults
+// to 30 days - AveragedMetrics snapshots are far smaller and less frequent
+// than SerialMetricsStore's, so a longer default retention is affordable.
+func NewPriceHistoryStore(dir string, retention time.Duration) (*PriceHistoryStore, error) {
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating price history dir %s: %w", dir, err)
+	}
+
+	s := &PriceHistoryStore{
+		dir:       dir,
+		retention: retention,
+		samples:   make(map[string][]PriceSnapshot),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading price history dir %s: %w", dir, err)
+	}
+	cutoff := time.Now().Add(-retention).Unix()
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		itemID := strings.TrimSuffix(entry.Name(), ".jsonl")
+		samples, err := loadPriceHistoryFile(filepath.Join(dir, entry.Name()), cutoff)
+		if err != nil {
+			dlog("WARN: failed to load price history file for %s: %v", itemID, err)
+			continue
+		}
+		if len(samples) > 0 {
+			s.samples[itemID] = samples
+		}
+	}
+	return s, nil
+}
+
+func loadPriceHistoryFile(path string, cutoff int64) ([]PriceSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []PriceSnapshot
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var sample PriceSnapshot
+		if err := json.Unmarshal(line, &sample); err != nil {
+			continue // tolerate a truncated trailing record from a killed process
+		}
+		if sample.TimestampUnix >= cutoff {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].TimestampUnix < samples[j].TimestampUnix })
+	return samples, scanner.Err()
+}
+
+func (s *PriceHistoryStore) itemFilePath(itemID string) string {
+	return filepath.Join(s.dir, itemID+".jsonl")
+}
+
+// Ingest appends sample to itemID's in-memory buffer (trimming anything
+// older than the retention window) and to its on-disk file. itemID is
+// expected already normalized (see IngestAveragedMetrics).
+func (s *PriceHistoryStore) Ingest(itemID string, sample PriceSnapshot) error {
+	s.mu.Lock()
+	cutoff := time.Now().Add(-s.retention).Unix()
+	buf := append(s.samples[itemID], sample)
+	trimmed := buf[:0:0]
+	for _, sm := range buf {
+		if sm.TimestampUnix >= cutoff {
+			trimmed = append(trimmed, sm)
+		}
+	}
+	s.samples[itemID] = trimmed
+	s.mu.Unlock()
+
+	f, err := os.OpenFile(s.itemFilePath(itemID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening price history file for %s: %w", itemID, err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(sample); err != nil {
+		return fmt.Errorf("encoding price history sample for %s: %w", itemID, err)
+	}
+	return nil
+}
+
+// IngestAveragedMetrics records one AveragedMetrics cycle (main.go's
+// updateLatestMetrics) as a PriceSnapshot per product, stamped with ts.
+// Ingest errors for individual products are logged rather than returned, so
+// one bad write doesn't abort the rest of the cycle.
+func (s *PriceHistoryStore) IngestAveragedMetrics(avg AveragedMetrics, ts time.Time) {
+	for productID, metric := range avg {
+		normItemID := BAZAAR_ID(productID)
+		err := s.Ingest(normItemID, PriceSnapshot{
+			TimestampUnix:  ts.Unix(),
+			InstabuyPrice:  metric.InstabuyPriceAverage,
+			InstasellPrice: metric.InstasellPriceAverage,
+		})
+		if err != nil {
+			dlog("WARN: failed to ingest price history for %s: %v", normItemID, err)
+		}
+	}
+}
+
+// History returns itemID's PriceSnapshots within the trailing window,
+// oldest first, backing the /history/{item} endpoint.
+func (s *PriceHistoryStore) History(itemID string, window time.Duration) []PriceSnapshot {
+	normItemID := BAZAAR_ID(itemID)
+	cutoff := time.Now().Add(-window).Unix()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := s.samples[normItemID]
+	in := make([]PriceSnapshot, 0, len(all))
+	for _, sm := range all {
+		if sm.TimestampUnix >= cutoff {
+			in = append(in, sm)
+		}
+	}
+	return in
+}
+
+// Trend computes TrendStats for itemID over the trailing window, backing
+// both /trend/{item} and calculateProfit's avgWindow smoothing. ok is false
+// when no samples fall within the window, meaning the caller should fall
+// back to the single most-recent quote.
+func (s *PriceHistoryStore) Trend(itemID string, window time.Duration) (stats TrendStats, ok bool) {
+	in := s.History(itemID, window)
+	if len(in) == 0 {
+		return TrendStats{}, false
+	}
+
+	buyStat := welfordPriceStats(pluck(in, func(sm PriceSnapshot) float64 { return sm.InstabuyPrice }))
+	sellStat := welfordPriceStats(pluck(in, func(sm PriceSnapshot) float64 { return sm.InstasellPrice }))
+
+	stats = TrendStats{
+		InstabuyMean:    buyStat.mean,
+		InstabuyStdDev:  buyStat.stddev,
+		InstasellMean:   sellStat.mean,
+		InstasellStdDev: sellStat.stddev,
+		InstasellEMA:    emaOverSamples(in, trendEMAHalfLife),
+		SampleCount:     len(in),
+		WindowStart:     time.Unix(in[0].TimestampUnix, 0),
+		WindowEnd:       time.Unix(in[len(in)-1].TimestampUnix, 0),
+	}
+	return stats, true
+}
+
+func pluck(samples []PriceSnapshot, field func(PriceSnapshot) float64) []float64 {
+	out := make([]float64, len(samples))
+	for i, sm := range samples {
+		out[i] = field(sm)
+	}
+	return out
+}
+
+type priceMeanStdDev struct {
+	mean   float64
+	stddev float64
+}
+
+// welfordPriceStats computes population mean/stddev with Welford's one-pass
+// algorithm, matching batch_summary.go's welfordStats (kept as a separate
+// copy here since that one returns a BatchStat with min/median/max this
+// caller doesn't need).
+func welfordPriceStats(values []float64) priceMeanStdDev {
+	if len(values) == 0 {
+		return priceMeanStdDev{mean: math.NaN(), stddev: math.NaN()}
+	}
+	mean := 0.0
+	m2 := 0.0
+	count := 0.0
+	for _, v := range values {
+		count++
+		delta := v - mean
+		mean += delta / count
+		m2 += delta * (v - mean)
+	}
+	stddev := 0.0
+	if count > 1 {
+		stddev = math.Sqrt(m2 / count)
+	}
+	return priceMeanStdDev{mean: mean, stddev: stddev}
+}
+
+// emaOverSamples computes an exponential moving average of InstasellPrice
+// across samples (oldest first, possibly irregularly spaced), decaying each
+// older sample's weight by halfLife - so a gap in the poll cadence decays
+// weight by elapsed wall-clock time rather than by sample count.
+func emaOverSamples(samples []PriceSnapshot, halfLife time.Duration) float64 {
+	if len(samples) == 0 {
+		return math.NaN()
+	}
+	decayPerSecond := math.Log(2) / halfLife.Seconds()
+
+	ema := samples[0].InstasellPrice
+	for i := 1; i < len(samples); i++ {
+		dt := float64(samples[i].TimestampUnix - samples[i-1].TimestampUnix)
+		if dt < 0 {
+			dt = 0
+		}
+		alpha := 1 - math.Exp(-decayPerSecond*dt)
+		ema += alpha * (samples[i].InstasellPrice - ema)
+	}
+	return ema
+}
+
+// smoothedInstasellPrice returns itemNameNorm's Trend.InstasellEMA over
+// avgWindow when DefaultPriceHistoryStore has enough history, falling back
+// to the live getBuyPrice(apiResp, itemNameNorm) quote (utils.go) when
+// avgWindow is zero or history is insufficient. This is the smoothing
+// calculateProfit-equivalent call sites should prefer over a raw live quote
+// once they're ready to take an avgWindow parameter of their own; it isn't
+// yet wired into PerformDualExpansion's default path, since every existing
+// caller there is built around the single-most-recent-quote contract
+// getBuyPrice/getSellPrice already provide, and retrofitting all of them is
+// a larger change than this store's addition.
+func smoothedInstasellPrice(apiResp *HypixelAPIResponse, itemNameNorm string, avgWindow time.Duration) float64 {
+	live := getBuyPrice(apiResp, itemNameNorm)
+	if avgWindow <= 0 {
+		return live
+	}
+	store, err := DefaultPriceHistoryStore()
+	if err != nil {
+		return live
+	}
+	stats, ok := store.Trend(itemNameNorm, avgWindow)
+	if !ok || math.IsNaN(stats.InstasellEMA) {
+		return live
+	}
+	return stats.InstasellEMA
+}
+
+// Compact rewrites every item's on-disk file trimmed to the in-memory
+// buffer's current contents, mirroring SerialMetricsStore.Compact.
+func (s *PriceHistoryStore) Compact() error {
+	s.mu.RLock()
+	snapshot := make(map[string][]PriceSnapshot, len(s.samples))
+	for itemID, samples := range s.samples {
+		snapshot[itemID] = append([]PriceSnapshot(nil), samples...)
+	}
+	s.mu.RUnlock()
+
+	var firstErr error
+	for itemID, samples := range snapshot {
+		tmpPath := s.itemFilePath(itemID) + ".tmp"
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("compacting %s: %w", itemID, err)
+			}
+			continue
+		}
+		enc := json.NewEncoder(f)
+		for _, sm := range samples {
+			if err := enc.Encode(sm); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("compacting %s: %w", itemID, err)
+			}
+		}
+		f.Close()
+		if err := os.Rename(tmpPath, s.itemFilePath(itemID)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("replacing compacted file for %s: %w", itemID, err)
+		}
+	}
+	return firstErr
+}
+
+// StartPriceHistoryCompactor runs store.Compact on a ticker until stop is
+// closed, mirroring StartSerialMetricsCompactor.
+func StartPriceHistoryCompactor(store *PriceHistoryStore, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := store.Compact(); err != nil {
+					dlog("WARN: price history compaction failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+var (
+	defaultPriceHistoryStore     *PriceHistoryStore
+	defaultPriceHistoryStoreOnce sync.Once
+	defaultPriceHistoryStoreErr  error
+)
+
+// PriceHistoryDir is where DefaultPriceHistoryStore roots its store,
+// overridable before the first call (matching SerialMetricsDir's pattern).
+var PriceHistoryDir = "/tmp/metrics/price_history"
+
+// DefaultPriceHistoryStore lazily constructs the package-wide store rooted
+// at PriceHistoryDir, for callers (historyHandler, trendHandler,
+// updateLatestMetrics) that don't hold a store reference of their own.
+func DefaultPriceHistoryStore() (*PriceHistoryStore, error) {
+	defaultPriceHistoryStoreOnce.Do(func() {
+		defaultPriceHistoryStore, defaultPriceHistoryStoreErr = NewPriceHistoryStore(PriceHistoryDir, 30*24*time.Hour)
+	})
+	return defaultPriceHistoryStore, defaultPriceHistoryStoreErr
+}
+
+// historyHandler exposes DefaultPriceHistoryStore's History over HTTP as
+// /history/{item}?window=24h (window one of NamedWindows' keys, default
+// "7d"; see serial_metrics_store.go).
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := strings.TrimPrefix(r.URL.Path, "/history/")
+	if itemID == "" {
+		http.Error(w, "missing item in path", http.StatusBadRequest)
+		return
+	}
+	window := windowFromName(r.URL.Query().Get("window"))
+
+	store, err := DefaultPriceHistoryStore()
+	if err != nil {
+		http.Error(w, "price history store unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	snapshots := store.History(itemID, window)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Item      string          `json:"item"`
+		Window    string          `json:"window"`
+		Snapshots []PriceSnapshot `json:"snapshots"`
+	}{
+		Item:      BAZAAR_ID(itemID),
+		Window:    window.String(),
+		Snapshots: snapshots,
+	})
+}
+
+// trendHandler exposes DefaultPriceHistoryStore's Trend over HTTP as
+// /trend/{item}?window=24h (window defaults to "7d", same as historyHandler).
+func trendHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := strings.TrimPrefix(r.URL.Path, "/trend/")
+	if itemID == "" {
+		http.Error(w, "missing item in path", http.StatusBadRequest)
+		return
+	}
+	window := windowFromName(r.URL.Query().Get("window"))
+
+	store, err := DefaultPriceHistoryStore()
+	if err != nil {
+		http.Error(w, "price history store unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, ok := store.Trend(itemID, window)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Item           string     `json:"item"`
+		Window         string     `json:"window"`
+		SufficientData bool       `json:"sufficient_data"`
+		Trend          TrendStats `json:"trend,omitempty"`
+	}{
+		Item:           BAZAAR_ID(itemID),
+		Window:         window.String(),
+		SufficientData: ok,
+		Trend:          stats,
+	})
+}
@@ -0,0 +1,177 @@
+// price_store.go
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PriceStore is a read-mostly facade that pairs a *HypixelAPIResponse with
+// the map[string]ProductMetrics that matches it, so a caller expanding one
+// recipe sees both halves of the same point-in-time refresh through a single
+// RLock instead of calling WaitForFreshData() and getMetricsMapFromFile()
+// separately and risking a Bazaar snapshot from one tick paired with a
+// metrics reload from the next. It does not replace
+// StartBackgroundRefresh/getBazaarCache/getMetricsMapFromFile/
+// PriceUpdateStatus (refresh.go) - it wraps them, the same way dashboard.go's
+// handlers already wrap the package-level accessors for one consistent read
+// (see its own comment at dashboard.go:80) rather than introducing a second,
+// competing refresh loop.
+type PriceStore struct {
+	metricsFilePath string
+
+	mu         sync.RWMutex
+	apiResp    *HypixelAPIResponse
+	metricsMap map[string]ProductMetrics
+}
+
+// NewPriceStore builds a PriceStore seeded from whatever Bazaar/metrics data
+// is already cached. Call Run in its own goroutine to keep it current:
+//
+//	store := NewPriceStore(defaultMetricsFilePath)
+//	go StartBackgroundRefresh(ctx, 30*time.Second, defaultMetricsFilePath)
+//	go store.Run(ctx, 30*time.Second)
+func NewPriceStore(metricsFilePath string) *PriceStore {
+	s := &PriceStore{metricsFilePath: metricsFilePath}
+	if resp, err := WaitForFreshData(); err == nil || errors.Is(err, ErrStale) {
+		s.apiResp = resp
+	}
+	if mm, err := getMetricsMapFromFile(metricsFilePath); err == nil {
+		s.metricsMap = mm
+	}
+	return s
+}
+
+// Run keeps s current until ctx is cancelled: it subscribes to every Bazaar
+// snapshot StartBackgroundRefresh publishes (refresh.go's Subscribe) and
+// re-reads the metrics file on the same interval StartBackgroundRefresh
+// reloads it on, so the two halves of a Snapshot stay within one tick of
+// each other without s driving its own duplicate fetch/reload cycle.
+func (s *PriceStore) Run(ctx context.Context, metricsPollInterval time.Duration) {
+	updates := Subscribe()
+	defer Unsubscribe(updates)
+
+	ticker := time.NewTicker(metricsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case resp, ok := <-updates:
+			if !ok {
+				return
+			}
+			s.mu.Lock()
+			s.apiResp = resp
+			s.mu.Unlock()
+		case <-ticker.C:
+			mm, err := getMetricsMapFromFile(s.metricsFilePath)
+			if err != nil {
+				dlog("PriceStore.Run: metrics reload failed: %v", err)
+				continue
+			}
+			s.mu.Lock()
+			s.metricsMap = mm
+			s.mu.Unlock()
+		}
+	}
+}
+
+// StartedAt, LastFullUpdateTime, and Progress report StartBackgroundRefresh's
+// most recent cycle (refresh.go's PriceUpdateStatus), so an HTTP/CLI caller
+// holding a PriceStore doesn't also need a direct line to the refresh loop's
+// own package-level state.
+func (s *PriceStore) StartedAt() time.Time {
+	start, _, _ := PriceUpdateStatus()
+	return start
+}
+
+func (s *PriceStore) LastFullUpdateTime() time.Time {
+	_, last, _ := PriceUpdateStatus()
+	return last
+}
+
+func (s *PriceStore) Progress() int {
+	_, _, pct := PriceUpdateStatus()
+	return pct
+}
+
+// Snapshot returns s's current Bazaar response and metrics map as of one
+// consistent RLock, for a caller (recipe expansion, dashboards) that needs
+// both and wants them from the same instant rather than two separate reads.
+func (s *PriceStore) Snapshot() (*HypixelAPIResponse, map[string]ProductMetrics) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.apiResp, s.metricsMap
+}
+
+// GetSellPrice, GetBuyPrice, and GetMetrics are PriceStore-backed
+// equivalents of the package-level getSellPrice/getBuyPrice/getMetrics
+// (utils.go), reading both inputs from one Snapshot instead of requiring the
+// caller to source an apiResp/metricsMap pair itself. The package-level
+// functions remain as-is; most of this package's recipe-evaluation call
+// sites already thread an explicit apiResp/metricsMap pair through deep call
+// chains (expansion.go, tree_builder.go, optimizer.go), and rewriting every
+// one of those onto a single global PriceStore would trade an explicit,
+// already-working data flow for an implicit one. These methods exist for
+// new callers (e.g. calculate_stream.go's per-tick recompute) that want a
+// coherent snapshot without re-deriving it themselves.
+func (s *PriceStore) GetSellPrice(itemIDNorm string) float64 {
+	apiResp, _ := s.Snapshot()
+	return getSellPrice(apiResp, itemIDNorm)
+}
+
+func (s *PriceStore) GetBuyPrice(itemIDNorm string) float64 {
+	apiResp, _ := s.Snapshot()
+	return getBuyPrice(apiResp, itemIDNorm)
+}
+
+func (s *PriceStore) GetMetrics(itemIDNorm string) ProductMetrics {
+	_, metricsMap := s.Snapshot()
+	return getMetrics(metricsMap, itemIDNorm)
+}
+
+// Query returns every product in s's current snapshot whose ProductMetrics
+// labels (labels.go) satisfy selector, letting a caller (e.g. aggregateCells
+// filtering ingredients down to "only NPC-sourced") select a market segment
+// by tag instead of hardcoding ID prefixes. A product with no ProductMetrics
+// entry, or whose Labels is empty, matches only the empty selector.
+func (s *PriceStore) Query(selector Selector) []HypixelProduct {
+	apiResp, metricsMap := s.Snapshot()
+	if apiResp == nil {
+		return nil
+	}
+
+	var matches []HypixelProduct
+	for id, product := range apiResp.Products {
+		var labelSets []map[string]string
+		if pm, ok := safeGetMetricsData(metricsMap, id); ok {
+			labelSets = pm.Labels
+		}
+		if selector.MatchesAny(labelSets) {
+			matches = append(matches, product)
+		}
+	}
+	return matches
+}
+
+// defaultPriceStoreOnce/defaultPriceStoreInst lazily construct the
+// package-wide PriceStore on first use, the same sync.Once pattern
+// DefaultMetrics (observability.go) uses, rather than doing NewPriceStore's
+// file/cache read as a package-level var initializer.
+var (
+	defaultPriceStoreOnce sync.Once
+	defaultPriceStoreInst *PriceStore
+)
+
+// DefaultPriceStore returns the package-wide PriceStore, constructing it on
+// first call. main starts its Run loop alongside StartBackgroundRefresh.
+func DefaultPriceStore() *PriceStore {
+	defaultPriceStoreOnce.Do(func() {
+		defaultPriceStoreInst = NewPriceStore(defaultMetricsFilePath)
+	})
+	return defaultPriceStoreInst
+}
@@ -0,0 +1,269 @@
+// profit_curve.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ProfitCurveSample is one quantity probed by ComputeProfitCurve's search,
+// alongside the cost/revenue/profit and timing PerformDualExpansion reported
+// for it. Feasible is false when PrimaryBased calculation wasn't possible at
+// this quantity (e.g. insufficient liquidity), in which case Cost/Revenue/
+// Profit/AcqTime/SaleTime/CycleTime are all 0 rather than meaningful values.
+type ProfitCurveSample struct {
+	Qty       float64 `json:"qty"`
+	Cost      float64 `json:"cost"`
+	Revenue   float64 `json:"revenue"`
+	Profit    float64 `json:"profit"`
+	AcqTime   float64 `json:"acq_time_seconds"`
+	SaleTime  float64 `json:"sale_time_seconds"`
+	CycleTime float64 `json:"cycle_time_seconds"`
+	Feasible  bool    `json:"feasible"`
+}
+
+// TimeCapQuantity is the quantity/cost/revenue/profit ComputeProfitCurve
+// estimates a caller could run at a given cycle-time cap, derived from the
+// two probed samples bracketing that cap. Interpolated is true when the cap
+// fell strictly between two probes rather than landing on one exactly.
+type TimeCapQuantity struct {
+	TimeCapSeconds float64 `json:"time_cap_seconds"`
+	Qty            float64 `json:"qty"`
+	Cost           float64 `json:"cost"`
+	Revenue        float64 `json:"revenue"`
+	Profit         float64 `json:"profit"`
+	Interpolated   bool    `json:"interpolated"`
+}
+
+// ItemProfitCurve is ComputeProfitCurve's output: every quantity it probed
+// while searching for the largest time cap requested, plus the interpolated
+// outcome at each individual time cap and the true best sample on the curve.
+type ItemProfitCurve struct {
+	ItemName            string              `json:"item_name"`
+	Samples             []ProfitCurveSample `json:"samples"`
+	TimeCapQuantities   []TimeCapQuantity   `json:"time_cap_quantities"`
+	MaxFeasibleQuantity float64             `json:"max_feasible_quantity"`
+	// BestQty/MaxProfit/BestProfitPerHour are the argmax over every feasible
+	// sample by profit-per-hour, which need not be the sample at
+	// MaxFeasibleQuantity: NonMonotonicProfit is true when depth-of-book price
+	// impact makes profit-per-hour decrease somewhere as quantity increases.
+	BestQty             float64 `json:"best_qty"`
+	MaxProfit           float64 `json:"max_profit"`
+	BestProfitPerHour   float64 `json:"best_profit_per_hour"`
+	NonMonotonicProfit  bool    `json:"non_monotonic_profit"`
+	CalculationPossible bool    `json:"calculation_possible"`
+	ErrorMessage        string  `json:"error_message,omitempty"`
+}
+
+// sampleProfitAtQty runs PerformDualExpansion once for qty and turns it into
+// a ProfitCurveSample, mirroring evaluatePortfolioCandidate's cost/revenue/
+// cycle-time derivation but keeping the sample (with Feasible=false) instead
+// of discarding it when the quantity isn't viable, so ComputeProfitCurve can
+// still plot it.
+func sampleProfitAtQty(
+	itemNameNorm string,
+	qty float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+) ProfitCurveSample {
+	sample := ProfitCurveSample{Qty: qty}
+
+	dual, err := PerformDualExpansion(context.Background(), itemNameNorm, qty, apiResp, metricsMap, itemFilesDir, false, PrecisionFloat, ExpansionOptions{})
+	if err != nil || dual == nil || !dual.PrimaryBased.CalculationPossible {
+		return sample
+	}
+	p1 := dual.PrimaryBased
+
+	cost := float64(p1.TotalCost)
+	if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return sample
+	}
+
+	acqTime := float64(p1.SlowestIngredientBuyTimeSeconds)
+	saleTime := float64(dual.TopLevelInstasellTimeSeconds)
+	if math.IsNaN(acqTime) {
+		acqTime = math.Inf(1)
+	}
+	if math.IsNaN(saleTime) {
+		saleTime = math.Inf(1)
+	}
+	cycleTime := acqTime + saleTime
+	if math.IsInf(cycleTime, 0) || cycleTime <= 0 {
+		return sample
+	}
+
+	instasellPrice := getBuyPrice(apiResp, itemNameNorm)
+	if instasellPrice <= 0 || math.IsNaN(instasellPrice) || math.IsInf(instasellPrice, 0) {
+		return sample
+	}
+
+	revenue := instasellPrice * qty
+	sample.Cost = cost
+	sample.Revenue = revenue
+	sample.Profit = revenue - cost
+	sample.AcqTime = acqTime
+	sample.SaleTime = saleTime
+	sample.CycleTime = cycleTime
+	sample.Feasible = true
+	return sample
+}
+
+// ComputeProfitCurve runs the same binary search as
+// findMaxQuantityForTimeConstraint, targeting the largest value in timeCaps,
+// but - unlike that function - keeps every probed quantity as a
+// ProfitCurveSample instead of throwing away all but the final answer. It
+// then augments the curve with the quantity/cost/revenue/profit achievable at
+// each individually requested time cap, interpolating between adjacent
+// probes when a cap doesn't land on one exactly.
+func ComputeProfitCurve(
+	itemName string,
+	timeCaps []float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	itemFilesDir string,
+	maxPossibleQty float64,
+) *ItemProfitCurve {
+	itemNameNorm := BAZAAR_ID(itemName)
+	curve := &ItemProfitCurve{ItemName: itemNameNorm}
+
+	if len(timeCaps) == 0 {
+		curve.ErrorMessage = "ComputeProfitCurve requires at least one time cap"
+		return curve
+	}
+	maxTimeCap := timeCaps[0]
+	for _, tc := range timeCaps[1:] {
+		if tc > maxTimeCap {
+			maxTimeCap = tc
+		}
+	}
+
+	if maxPossibleQty < 1.0 {
+		curve.ErrorMessage = fmt.Sprintf("maxPossibleQty (%.2f) is less than 1", maxPossibleQty)
+		return curve
+	}
+
+	low := 1.0
+	high := math.Floor(maxPossibleQty)
+	if high < low {
+		high = low
+	}
+
+	bestQty := 0.0
+	probedQty := make(map[float64]bool)
+	iterations := 0
+	const maxIterations = 50
+
+	for iterations < maxIterations && high >= low {
+		iterations++
+		midQty := math.Floor(low + (high-low)/2)
+		if midQty < 1 {
+			midQty = 1
+		}
+
+		if iterations > 1 && midQty <= low && low >= high && bestQty == midQty {
+			dlog("  ProfitCurve Search: Converged or stuck at Low=%.0f, High=%.0f, MidQty=%.0f, BestQty=%.0f. Breaking.", low, high, midQty, bestQty)
+			break
+		}
+		if midQty == low && midQty == high && iterations > 5 {
+			dlog("  ProfitCurve Search: Stuck on MidQty=%.0f for several iterations. Breaking.", midQty)
+			break
+		}
+
+		sample := sampleProfitAtQty(itemNameNorm, midQty, apiResp, metricsMap, itemFilesDir)
+		if !probedQty[midQty] {
+			probedQty[midQty] = true
+			curve.Samples = append(curve.Samples, sample)
+		}
+
+		if sample.Feasible && sample.CycleTime <= maxTimeCap {
+			bestQty = midQty
+			low = midQty + 1
+		} else {
+			high = midQty - 1
+		}
+	}
+
+	sort.Slice(curve.Samples, func(i, j int) bool { return curve.Samples[i].Qty < curve.Samples[j].Qty })
+	curve.MaxFeasibleQuantity = sanitizeFloat(bestQty)
+	curve.CalculationPossible = len(curve.Samples) > 0
+	if !curve.CalculationPossible {
+		curve.ErrorMessage = fmt.Sprintf("no samples collected for %s", itemNameNorm)
+		return curve
+	}
+
+	feasibleSamples := make([]ProfitCurveSample, 0, len(curve.Samples))
+	for _, s := range curve.Samples {
+		if s.Feasible {
+			feasibleSamples = append(feasibleSamples, s)
+		}
+	}
+
+	for _, tc := range timeCaps {
+		curve.TimeCapQuantities = append(curve.TimeCapQuantities, interpolateTimeCap(feasibleSamples, tc))
+	}
+
+	// True argmax over the curve by profit-per-hour: this can land on a
+	// smaller quantity than MaxFeasibleQuantity when depth-of-book price
+	// impact makes profit-per-hour decrease somewhere as quantity increases.
+	bestProfitPerHour := math.Inf(-1)
+	prevProfitPerHour := math.Inf(-1)
+	for _, s := range feasibleSamples {
+		if s.CycleTime <= 0 {
+			continue
+		}
+		profitPerHour := s.Profit / s.CycleTime * 3600
+		if profitPerHour < prevProfitPerHour {
+			curve.NonMonotonicProfit = true
+		}
+		prevProfitPerHour = profitPerHour
+		if profitPerHour > bestProfitPerHour {
+			bestProfitPerHour = profitPerHour
+			curve.BestQty = s.Qty
+			curve.MaxProfit = s.Profit
+			curve.BestProfitPerHour = profitPerHour
+		}
+	}
+
+	return curve
+}
+
+// interpolateTimeCap finds the largest feasible sample (samples sorted
+// ascending by Qty) whose CycleTime is within capSeconds, then linearly
+// interpolates against the next sample above it - assuming CycleTime rises
+// roughly monotonically with Qty, true for the common case even though
+// depth-of-book effects can make profit itself non-monotonic. Returns a zero
+// TimeCapQuantity if even the smallest sample exceeds the cap.
+func interpolateTimeCap(samples []ProfitCurveSample, capSeconds float64) TimeCapQuantity {
+	result := TimeCapQuantity{TimeCapSeconds: capSeconds}
+	if len(samples) == 0 || samples[0].CycleTime > capSeconds {
+		return result
+	}
+
+	below := samples[0]
+	for i := 1; i < len(samples); i++ {
+		if samples[i].CycleTime > capSeconds {
+			above := samples[i]
+			if above.CycleTime == below.CycleTime {
+				break
+			}
+			frac := (capSeconds - below.CycleTime) / (above.CycleTime - below.CycleTime)
+			result.Qty = below.Qty + frac*(above.Qty-below.Qty)
+			result.Cost = below.Cost + frac*(above.Cost-below.Cost)
+			result.Revenue = below.Revenue + frac*(above.Revenue-below.Revenue)
+			result.Profit = below.Profit + frac*(above.Profit-below.Profit)
+			result.Interpolated = true
+			return result
+		}
+		below = samples[i]
+	}
+
+	// Every probed sample satisfies the cap; report the largest one probed.
+	result.Qty = below.Qty
+	result.Cost = below.Cost
+	result.Revenue = below.Revenue
+	result.Profit = below.Profit
+	return result
+}
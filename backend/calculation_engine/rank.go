@@ -0,0 +1,359 @@
+// rank.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProfitMetric selects how RankItemsByProfit scores each item.
+type ProfitMetric string
+
+const (
+	// MetricMargin is the flat margin: the chosen sell-side TopLevelCost
+	// minus the craft TotalCost.
+	MetricMargin ProfitMetric = "margin"
+	// MetricMarginPerSecond divides margin by the time capital is tied up:
+	// the slowest ingredient's buy-order fill time plus the top-level
+	// instasell time.
+	MetricMarginPerSecond ProfitMetric = "margin_per_second"
+	// MetricSharpe divides margin by the stddev of recent observed margins
+	// for the item (rollingMarginWindow), a Sharpe-like reward-per-unit-risk
+	// score; items with no history yet fall back to plain margin.
+	MetricSharpe ProfitMetric = "sharpe"
+)
+
+// rollingMarginWindowSize bounds how many recent margin observations
+// RankItemsByProfit keeps per item for the Sharpe-like metric.
+const rollingMarginWindowSize = 20
+
+// ItemRank is one item's current standing in the profitability ranking.
+type ItemRank struct {
+	ItemID     string  `json:"item_id"`
+	Profit     float64 `json:"profit"`
+	Delta      float64 `json:"delta"` // top-level SellSize*SellFrequency - OrderSize*OrderFrequency
+	Capital    float64 `json:"capital"`
+	Rank       int     `json:"rank"`
+	LastRank   int     `json:"last_rank"`
+	RankDelta  int     `json:"rank_delta"` // LastRank - Rank; positive means the item moved up
+	LastProfit float64 `json:"last_profit"`
+	// SlowestFillTimeSecs is the craft's slowest base ingredient's buy-order
+	// fill time (ExpansionResult.SlowestIngredientBuyTimeSeconds), kept
+	// alongside Profit/Capital so a caller can sort by it directly (see
+	// wizserver.go's "slowestFillTime" sort metric) without re-running the
+	// expansion MetricMarginPerSecond already paid for internally.
+	SlowestFillTimeSecs float64 `json:"slowest_fill_time_secs"`
+	// RR is the sell-side perspective's TopLevelRR (ExpansionResult.TopLevelRR):
+	// how many times the instasell order is expected to need relisting to
+	// fully fill. Carried here so /opportunities can gate on ?minRR=
+	// without re-running PerformDualExpansion per request.
+	RR float64 `json:"rr"`
+}
+
+// RankFilter narrows RankItemsByProfit's output.
+type RankFilter struct {
+	// RequirePositiveDelta, if true, keeps only items whose top-level Delta
+	// is positive (more sell pressure than buy pressure, so instasells fill).
+	RequirePositiveDelta bool
+	// MinCapital, if > 0, drops items whose craft requires less capital than
+	// this to run.
+	MinCapital float64
+}
+
+// itemMarginHistory is the rolling window of recent margin observations for
+// one item, used by MetricSharpe.
+type itemMarginHistory struct {
+	samples []float64 // bounded ring, oldest first
+}
+
+func (h *itemMarginHistory) push(v float64) {
+	h.samples = append(h.samples, v)
+	if len(h.samples) > rollingMarginWindowSize {
+		h.samples = h.samples[len(h.samples)-rollingMarginWindowSize:]
+	}
+}
+
+func (h *itemMarginHistory) stdDev() float64 {
+	n := len(h.samples)
+	if n < 2 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range h.samples {
+		sum += v
+	}
+	mean := sum / float64(n)
+	var sqDiffSum float64
+	for _, v := range h.samples {
+		d := v - mean
+		sqDiffSum += d * d
+	}
+	return math.Sqrt(sqDiffSum / float64(n))
+}
+
+// ProfitRanker periodically re-runs RankItemsByProfit over every recipe file
+// in ItemFilesDir and keeps the sorted result cached, modeled on
+// StartStaleRefreshWorker's ticker-driven refresh loop: a caller asks for the
+// current ranking via Current() rather than blocking on a fresh computation.
+type ProfitRanker struct {
+	ItemFilesDir string
+	Metric       ProfitMetric
+	Quantity     float64
+	Filter       RankFilter
+
+	mu           sync.RWMutex
+	ranks        []ItemRank
+	history      map[string]*itemMarginHistory
+	progressPct  int
+	lastFullScan time.Time
+}
+
+// NewProfitRanker constructs a ProfitRanker with the given config; quantity
+// is the batch size passed to PerformDualExpansion for every item (1 if <= 0).
+func NewProfitRanker(itemFilesDir string, metric ProfitMetric, quantity float64, filter RankFilter) *ProfitRanker {
+	if quantity <= 0 {
+		quantity = 1
+	}
+	return &ProfitRanker{
+		ItemFilesDir: itemFilesDir,
+		Metric:       metric,
+		Quantity:     quantity,
+		Filter:       filter,
+		history:      make(map[string]*itemMarginHistory),
+	}
+}
+
+// Current returns the most recently computed ranking, most profitable first.
+func (pr *ProfitRanker) Current() []ItemRank {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	out := make([]ItemRank, len(pr.ranks))
+	copy(out, pr.ranks)
+	return out
+}
+
+// Refresh recomputes the ranking once, updating LastRank/RankDelta/LastProfit
+// against the previously cached ranking before replacing it. Progress() rises
+// from 0 to 100 over the course of the scan so a caller warming up the first
+// Refresh can show a progress bar, and LastFullScan() advances to now once it
+// completes.
+func (pr *ProfitRanker) Refresh(apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) error {
+	pr.setProgress(0)
+	fresh, err := rankItemsByProfit(pr.ItemFilesDir, apiResp, metricsMap, pr.Quantity, pr.Metric, pr.Filter, pr.history, pr.setProgress)
+	if err != nil {
+		return err
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	prior := make(map[string]ItemRank, len(pr.ranks))
+	for _, r := range pr.ranks {
+		prior[r.ItemID] = r
+	}
+	for i := range fresh {
+		if prev, ok := prior[fresh[i].ItemID]; ok {
+			fresh[i].LastRank = prev.Rank
+			fresh[i].LastProfit = prev.Profit
+			fresh[i].RankDelta = prev.Rank - fresh[i].Rank
+		} else {
+			fresh[i].LastRank = fresh[i].Rank
+			fresh[i].LastProfit = fresh[i].Profit
+			fresh[i].RankDelta = 0
+		}
+	}
+	pr.ranks = fresh
+	pr.progressPct = 100
+	pr.lastFullScan = time.Now()
+	return nil
+}
+
+// setProgress updates pr's 0-100 scan progress; safe to call from within
+// rankItemsByProfit's loop, which runs on the same goroutine as Refresh.
+func (pr *ProfitRanker) setProgress(pct int) {
+	pr.mu.Lock()
+	pr.progressPct = pct
+	pr.mu.Unlock()
+}
+
+// Progress reports the current scan's completion percentage (0-100; 100 from
+// the end of one Refresh until the next one starts overwriting it via
+// setProgress(0)).
+func (pr *ProfitRanker) Progress() int {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.progressPct
+}
+
+// LastFullScan reports when Refresh last completed successfully.
+func (pr *ProfitRanker) LastFullScan() time.Time {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.lastFullScan
+}
+
+// Start runs Refresh immediately and then every interval until ctx is
+// cancelled; run it in its own goroutine, like StartStaleRefreshWorker.
+func (pr *ProfitRanker) Start(ctx context.Context, interval time.Duration, apiResp func() (*HypixelAPIResponse, error), metricsMap func() map[string]ProductMetrics) {
+	runOnce := func() {
+		resp, err := apiResp()
+		if err != nil {
+			dlog("ProfitRanker: failed to get bazaar data: %v", err)
+			return
+		}
+		if err := pr.Refresh(resp, metricsMap()); err != nil {
+			dlog("ProfitRanker: refresh failed: %v", err)
+		}
+	}
+
+	runOnce()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runOnce()
+		}
+	}
+}
+
+// WriteNDJSON streams the current ranking to w as newline-delimited JSON
+// (one ItemRank object per line), for callers that want to handle large
+// result sets without buffering the whole slice as one JSON array.
+func (pr *ProfitRanker) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, r := range pr.Current() {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RankItemsByProfit runs PerformDualExpansion once for every item with a
+// recipe file in itemFilesDir and returns them sorted most-profitable-first
+// by metric. It does not retain any rolling margin history across calls; use
+// a ProfitRanker for MetricSharpe to be meaningful across repeated calls.
+func RankItemsByProfit(itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, quantity float64, metric ProfitMetric, filter RankFilter) ([]ItemRank, error) {
+	if quantity <= 0 {
+		quantity = 1
+	}
+	return rankItemsByProfit(itemFilesDir, apiResp, metricsMap, quantity, metric, filter, make(map[string]*itemMarginHistory), nil)
+}
+
+// rankItemsByProfit runs PerformDualExpansion once per recipe file in
+// itemFilesDir. onProgress, if non-nil, is called with the scan's 0-100
+// completion percentage as each item finishes, so a caller like
+// ProfitRanker.Refresh can expose warm-up progress; it is always called once
+// with 100 just before returning, even on an empty itemFilesDir.
+func rankItemsByProfit(itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, quantity float64, metric ProfitMetric, filter RankFilter, history map[string]*itemMarginHistory, onProgress func(pct int)) ([]ItemRank, error) {
+	entries, err := os.ReadDir(itemFilesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipeFiles []os.DirEntry
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			recipeFiles = append(recipeFiles, entry)
+		}
+	}
+
+	reportProgress := func(done int) {
+		if onProgress == nil {
+			return
+		}
+		if len(recipeFiles) == 0 {
+			onProgress(100)
+			return
+		}
+		onProgress(done * 100 / len(recipeFiles))
+	}
+
+	var ranks []ItemRank
+	for i, entry := range recipeFiles {
+		func() {
+			defer reportProgress(i + 1)
+
+			itemID := BAZAAR_ID(strings.TrimSuffix(entry.Name(), ".json"))
+
+			dual, expErr := PerformDualExpansion(context.Background(), itemID, quantity, apiResp, metricsMap, itemFilesDir, false, PrecisionFloat, ExpansionOptions{})
+			if expErr != nil || dual == nil {
+				return
+			}
+
+			sell := dual.PrimaryBased
+			if !sell.CalculationPossible || math.IsNaN(float64(sell.TopLevelCost)) {
+				sell = dual.SecondaryBased
+			}
+			craft := dual.SecondaryBased
+			if !sell.CalculationPossible || math.IsNaN(float64(sell.TopLevelCost)) || !craft.CalculationPossible || math.IsNaN(float64(craft.TotalCost)) {
+				return
+			}
+
+			margin := float64(sell.TopLevelCost) - float64(craft.TotalCost)
+
+			metricsP := getMetrics(metricsMap, itemID)
+			delta := math.NaN()
+			if metricsP.ProductID != "" {
+				delta = metricsP.SellSize*metricsP.SellFrequency - metricsP.OrderSize*metricsP.OrderFrequency
+			}
+
+			if filter.RequirePositiveDelta && !(delta > 0) {
+				return
+			}
+			if filter.MinCapital > 0 && float64(craft.TotalCost) < filter.MinCapital {
+				return
+			}
+
+			h, ok := history[itemID]
+			if !ok {
+				h = &itemMarginHistory{}
+				history[itemID] = h
+			}
+			h.push(margin)
+
+			profit := margin
+			switch metric {
+			case MetricMarginPerSecond:
+				denom := float64(craft.SlowestIngredientBuyTimeSeconds) + float64(dual.TopLevelInstasellTimeSeconds)
+				if denom > 0 && !math.IsNaN(denom) && !math.IsInf(denom, 0) {
+					profit = margin / denom
+				}
+			case MetricSharpe:
+				if sd := h.stdDev(); sd > 0 {
+					profit = margin / sd
+				}
+			}
+
+			if math.IsNaN(profit) || math.IsInf(profit, 0) {
+				return
+			}
+
+			ranks = append(ranks, ItemRank{
+				ItemID:              itemID,
+				Profit:              profit,
+				Delta:               delta,
+				Capital:             float64(craft.TotalCost),
+				SlowestFillTimeSecs: float64(craft.SlowestIngredientBuyTimeSeconds),
+				RR:                  float64(sell.TopLevelRR),
+			})
+		}()
+	}
+	reportProgress(len(recipeFiles))
+
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i].Profit > ranks[j].Profit })
+	for i := range ranks {
+		ranks[i].Rank = i + 1
+	}
+	return ranks, nil
+}
+
@@ -0,0 +1,239 @@
+// rebalance.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Holdings is the user's current inventory, loaded from a JSON file: itemID
+// -> quantity held.
+type Holdings map[string]float64
+
+// TargetWeights is itemID -> desired fraction of total portfolio coin value.
+// PlanRebalance normalizes these to sum to 1 before comparing against actual
+// holdings, so callers don't need to pre-normalize.
+type TargetWeights map[string]float64
+
+// RebalanceOrder is one suggested buy or sell in a rebalance plan, sized to
+// close (up to maxAmount of) the gap between an item's current and target
+// portfolio value.
+type RebalanceOrder struct {
+	ItemID       string  `json:"item_id"`
+	Side         string  `json:"side"` // "buy" or "sell"
+	Quantity     float64 `json:"quantity"`
+	CoinValue    float64 `json:"coin_value"`
+	CurrentDrift float64 `json:"current_drift"` // currentWeight - targetWeight, before this order
+}
+
+// RebalancePlan is PlanRebalance's output: the orders needed to move
+// holdings toward TargetWeights, plus the portfolio value they were sized
+// against.
+type RebalancePlan struct {
+	TotalValue float64          `json:"total_value"`
+	Orders     []RebalanceOrder `json:"orders"`
+}
+
+// loadHoldings reads a JSON file shaped like {"item_id": quantity, ...} into
+// a Holdings map.
+func loadHoldings(path string) (Holdings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading holdings file '%s': %w", path, err)
+	}
+	var holdings Holdings
+	if err := json.Unmarshal(data, &holdings); err != nil {
+		return nil, fmt.Errorf("parsing holdings file '%s': %w", path, err)
+	}
+	return holdings, nil
+}
+
+// normalizeWeights returns weights scaled so they sum to 1. Items with a
+// non-positive weight are dropped, since a zero/negative target share has no
+// meaningful normalized fraction.
+func normalizeWeights(weights TargetWeights) TargetWeights {
+	sum := 0.0
+	for _, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+	}
+	out := make(TargetWeights, len(weights))
+	if sum <= 0 {
+		return out
+	}
+	for id, w := range weights {
+		if w > 0 {
+			out[id] = w / sum
+		}
+	}
+	return out
+}
+
+// PlanRebalance compares holdings' current coin value (each item valued at
+// getSellPrice, i.e. what liquidating it would fetch) against weights
+// (normalized to sum to 1) and emits buy/sell orders closing the gap. An
+// item is skipped when its drift - the fractional difference between its
+// current and target share of TotalValue - is within threshold, or when
+// ignoreLocked marks it as having an active order the caller doesn't want
+// touched. Each order's coin value is capped at maxAmount (<=0 means
+// unbounded); quantities are derived by pricing the acquisition side via
+// getBestC10M for buys and getSellPrice for sells. Orders are returned
+// largest-drift-first so the most out-of-balance positions are handled
+// first.
+func PlanRebalance(ctx context.Context, holdings Holdings, weights TargetWeights, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, threshold, maxAmount float64, ignoreLocked map[string]bool) (RebalancePlan, error) {
+	normWeights := normalizeWeights(weights)
+
+	currentValue := make(map[string]float64, len(holdings)+len(normWeights))
+	totalValue := 0.0
+	for itemID, qty := range holdings {
+		if qty <= 0 {
+			continue
+		}
+		itemIDNorm := BAZAAR_ID(itemID)
+		price := getSellPrice(apiResp, itemIDNorm)
+		value := qty * price
+		currentValue[itemIDNorm] = value
+		totalValue += value
+	}
+	for itemID := range normWeights {
+		itemIDNorm := BAZAAR_ID(itemID)
+		if _, ok := currentValue[itemIDNorm]; !ok {
+			currentValue[itemIDNorm] = 0
+		}
+	}
+
+	plan := RebalancePlan{TotalValue: totalValue}
+	if totalValue <= 0 {
+		return plan, nil
+	}
+
+	itemIDs := make([]string, 0, len(currentValue))
+	for id := range currentValue {
+		itemIDs = append(itemIDs, id)
+	}
+	sort.Strings(itemIDs)
+
+	for _, itemID := range itemIDs {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return plan, ctxErr
+		}
+		if ignoreLocked != nil && ignoreLocked[itemID] {
+			continue
+		}
+
+		curVal := currentValue[itemID]
+		targetVal := normWeights[BAZAAR_ID(itemID)] * totalValue
+		currentWeight := curVal / totalValue
+		targetWeight := targetVal / totalValue
+		drift := currentWeight - targetWeight
+		if math.Abs(drift) < threshold {
+			continue
+		}
+
+		delta := targetVal - curVal // positive: underweight, needs a buy
+		coinValue := math.Abs(delta)
+		if maxAmount > 0 && coinValue > maxAmount {
+			coinValue = maxAmount
+		}
+
+		var quantity float64
+		if delta > 0 {
+			unitPrice := getSellPriceOrBuyPrice(apiResp, itemID)
+			if unitPrice <= 0 {
+				continue
+			}
+			qtyEstimate := coinValue / unitPrice
+			bestCost, method, _, _, _, err := getBestC10M(ctx, itemID, qtyEstimate, apiResp, metricsMap, PrecisionFloat, nil)
+			if err != nil || method == "N/A" || math.IsInf(bestCost, 0) || bestCost <= 0 {
+				continue // no viable acquisition method right now; skip rather than emit a bogus order
+			}
+			// Re-derive quantity from the actual priced cost rather than the
+			// unitPrice estimate, so a thin order book's premium is reflected.
+			quantity = qtyEstimate * (coinValue / bestCost)
+			plan.Orders = append(plan.Orders, RebalanceOrder{ItemID: itemID, Side: "buy", Quantity: quantity, CoinValue: coinValue, CurrentDrift: drift})
+		} else {
+			price := getSellPrice(apiResp, itemID)
+			if price <= 0 {
+				continue
+			}
+			quantity = coinValue / price
+			plan.Orders = append(plan.Orders, RebalanceOrder{ItemID: itemID, Side: "sell", Quantity: quantity, CoinValue: coinValue, CurrentDrift: drift})
+		}
+	}
+
+	sort.SliceStable(plan.Orders, func(i, j int) bool {
+		return math.Abs(plan.Orders[i].CurrentDrift) > math.Abs(plan.Orders[j].CurrentDrift)
+	})
+	return plan, nil
+}
+
+// getSellPriceOrBuyPrice is a unit-price estimate used only to convert a
+// target coin delta into an approximate quantity before pricing the real
+// acquisition cost via getBestC10M; it prefers the sell-side price (what
+// PlanRebalance values holdings at) and falls back to the buy side so a
+// thinly-traded item (sell orders but no recent buy orders, or vice versa)
+// still gets a usable estimate.
+func getSellPriceOrBuyPrice(apiResp *HypixelAPIResponse, itemIDNorm string) float64 {
+	if p := getSellPrice(apiResp, itemIDNorm); p > 0 {
+		return p
+	}
+	return getBuyPrice(apiResp, itemIDNorm)
+}
+
+// RunRebalanceCLI implements the `rebalance` CLI subcommand: it loads
+// holdings and target weights from JSON files, fetches live Bazaar/metrics
+// data, runs PlanRebalance, and either prints the plan (dryRun, or always -
+// this package has no order-placement integration, so every run is
+// effectively a dry run) as JSON to stdout.
+func RunRebalanceCLI(holdingsPath, weightsPath string, threshold, maxAmount float64, ignoreLockedPath string) error {
+	holdings, err := loadHoldings(holdingsPath)
+	if err != nil {
+		return err
+	}
+	weightsData, err := os.ReadFile(weightsPath)
+	if err != nil {
+		return fmt.Errorf("reading target weights file '%s': %w", weightsPath, err)
+	}
+	var weights TargetWeights
+	if err := json.Unmarshal(weightsData, &weights); err != nil {
+		return fmt.Errorf("parsing target weights file '%s': %w", weightsPath, err)
+	}
+
+	ignoreLocked := make(map[string]bool)
+	if ignoreLockedPath != "" {
+		data, err := os.ReadFile(ignoreLockedPath)
+		if err != nil {
+			return fmt.Errorf("reading ignore-locked file '%s': %w", ignoreLockedPath, err)
+		}
+		var ids []string
+		if err := json.Unmarshal(data, &ids); err != nil {
+			return fmt.Errorf("parsing ignore-locked file '%s': %w", ignoreLockedPath, err)
+		}
+		for _, id := range ids {
+			ignoreLocked[BAZAAR_ID(id)] = true
+		}
+	}
+
+	apiResp, err := getApiResponse(context.Background())
+	if err != nil {
+		return fmt.Errorf("fetching bazaar data for rebalance plan: %w", err)
+	}
+	metricsMap, err := getMetricsMapFromFile(defaultMetricsFilePath)
+	if err != nil {
+		dlog("RunRebalanceCLI: failed to load metrics map from '%s': %v", defaultMetricsFilePath, err)
+	}
+
+	plan, err := PlanRebalance(context.Background(), holdings, weights, apiResp, metricsMap, threshold, maxAmount, ignoreLocked)
+	if err != nil {
+		return fmt.Errorf("planning rebalance: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
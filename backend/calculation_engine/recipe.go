@@ -1,5 +1,7 @@
 package main
 
+import "path/filepath"
+
 // Item struct definitions remain here as they describe the recipe file format.
 
 type Recipe struct {
@@ -14,6 +16,20 @@ type Recipe struct {
 	C2    string `json:"C2"`
 	C3    string `json:"C3"`
 	Count int    `json:"count"`
+
+	// Rating is an optional curator-assigned preference score (higher is
+	// more preferred) for RecipeSelectionHighestRated to compare variants
+	// by, for items whose recipe file author wants to express "use this one
+	// when in doubt" independent of bazaar cost.
+	Rating float64 `json:"rating,omitempty"`
+	// Source labels where this variant comes from (e.g. "craft", "forge",
+	// "npc") - RecipeSelectionPreferNPC checks this for "npc" rather than
+	// inferring it from cell contents.
+	Source string `json:"source,omitempty"`
+	// Tags marks a variant with arbitrary labels a caller can filter on via
+	// ExcludeRecipeTags, e.g. "slayer" for a recipe gated behind a slayer
+	// requirement that a given expansion run wants to skip entirely.
+	Tags []string `json:"tags,omitempty"`
 }
 
 type SingleRecipe struct {
@@ -34,6 +50,37 @@ type Item struct {
 	Name    string       `json:"name,omitempty"` // Optional: if your JSON has a display name
 	Recipe  SingleRecipe `json:"recipe"`
 	Recipes []Recipe     `json:"recipes"`
+
+	// Modules maps an alias to the directory (relative to the owning
+	// itemFilesDir, or absolute) of another recipe module, so a cell can
+	// reference "alias:ITEM_ID" instead of duplicating that item's file
+	// under this module. Only a prefix that exactly matches one of these
+	// keys is treated as an alias by aggregateCells - anything else keeps
+	// meaning "ITEM_ID:AMOUNT" as before.
+	Modules map[string]string `json:"modules,omitempty"`
+
+	// SubRecipes are extra named recipes carried alongside Recipe/Recipes
+	// for composite crafts (reforges, essence upgrades) that don't warrant
+	// their own top-level item file. A cell referencing "sub:NAME[:AMOUNT]"
+	// is resolved by aggregateCells into NAME's own ingredients scaled by
+	// AMOUNT, inline, rather than being expanded as a separate tree node.
+	SubRecipes map[string]SingleRecipe `json:"sub_recipes,omitempty"`
+}
+
+// cellsOf maps a SingleRecipe's fixed grid fields onto the positional
+// A1..C3 cell map aggregateCells/chosenRecipeCellsFor operate on.
+func cellsOf(r SingleRecipe) map[string]string {
+	return map[string]string{"A1": r.A1, "A2": r.A2, "A3": r.A3, "B1": r.B1, "B2": r.B2, "B3": r.B3, "C1": r.C1, "C2": r.C2, "C3": r.C3}
+}
+
+// recipeFilePath resolves itemNameNorm's recipe JSON path, following a
+// module qualifier (see splitModuleID) to that module's own directory
+// instead of itemFilesDir when present.
+func recipeFilePath(itemFilesDir, itemNameNorm string) string {
+	if dir, itemID, ok := splitModuleID(itemNameNorm); ok {
+		return filepath.Join(dir, itemID+".json")
+	}
+	return filepath.Join(itemFilesDir, itemNameNorm+".json")
 }
 
 // ItemStep is used for cycle detection path tracking.
@@ -0,0 +1,200 @@
+// recipe_expansion.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// CraftTree is a memoized alternative to CraftingStepNode's output: rather
+// than re-walking the recipe file tree for every repeated ingredient,
+// ExpandRecipe caches each (normalizedID, qty) combination's flattened
+// ingredient map so shared sub-ingredients across branches are only read
+// and aggregated once per quantity.
+type CraftTree struct {
+	ItemName        string                `json:"item_name"`
+	QuantityNeeded  float64               `json:"quantity_needed"`
+	IsBaseComponent bool                  `json:"is_base_component"`
+	BestCost        float64               `json:"best_cost"`
+	Method          string                `json:"method"`
+	Ingredients     map[string]*CraftTree `json:"ingredients,omitempty"`
+	ErrorMessage    string                `json:"error_message,omitempty"`
+}
+
+// expansionMemoKey identifies one (normalizedID, qty) expansion result.
+type expansionMemoKey struct {
+	itemID string
+	qty    float64
+}
+
+// expansionMemo caches flattened ingredient maps produced by aggregateCells
+// for a given item+quantity, so a DAG-shaped recipe graph (the same
+// ingredient required by several siblings) is only expanded once per
+// ExpandRecipe call.
+type expansionMemo struct {
+	cache map[expansionMemoKey]map[string]float64
+}
+
+func newExpansionMemo() *expansionMemo {
+	return &expansionMemo{cache: make(map[expansionMemoKey]map[string]float64)}
+}
+
+// flattenedIngredients returns the per-craft ingredient map for itemID
+// scaled to qty, reading and parsing the recipe file at most once per
+// (itemID, qty) pair for the lifetime of the memo. apiResp is only used to
+// pick a winner for any interchangeable-ingredient ("ITEM_A|ITEM_B") cells.
+func (m *expansionMemo) flattenedIngredients(ctx context.Context, itemID string, qty float64, itemFilesDir string, apiResp *HypixelAPIResponse) (map[string]float64, bool, error) {
+	key := expansionMemoKey{itemID: itemID, qty: qty}
+	if cached, ok := m.cache[key]; ok {
+		return cached, true, nil
+	}
+
+	filePath := recipeFilePath(itemFilesDir, itemID)
+	itemData, hasFile, err := loadItemFileCached(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hasFile {
+		m.cache[key] = nil
+		return nil, false, nil
+	}
+
+	cells, craftedAmount, hasRecipe := chosenRecipeCellsFor(itemData)
+	if !hasRecipe {
+		m.cache[key] = nil
+		return nil, false, nil
+	}
+
+	specs, aggErr := aggregateCells(ctx, cells, itemData.Modules, itemData.SubRecipes)
+	if aggErr != nil {
+		return nil, false, fmt.Errorf("parsing recipe cells for '%s': %w", itemID, aggErr)
+	}
+	perCraft := resolveIngredientSpecs(specs, apiResp)
+
+	numCrafts := math.Ceil(qty / craftedAmount)
+	scaled := make(map[string]float64, len(perCraft))
+	for ing, amtPerCraft := range perCraft {
+		scaled[ing] = snapQuantity(amtPerCraft * numCrafts)
+	}
+	m.cache[key] = scaled
+	return scaled, true, nil
+}
+
+// chosenRecipeCellsFor picks the recipe cells to use for an Item, mirroring
+// the Recipes[0]-then-Recipe fallback used by expandItemRecursiveTree.
+func chosenRecipeCellsFor(itemData Item) (cells map[string]string, craftedAmount float64, ok bool) {
+	craftedAmount = 1.0
+	if len(itemData.Recipes) > 0 {
+		r := itemData.Recipes[0]
+		candidate := map[string]string{"A1": r.A1, "A2": r.A2, "A3": r.A3, "B1": r.B1, "B2": r.B2, "B3": r.B3, "C1": r.C1, "C2": r.C2, "C3": r.C3}
+		for _, v := range candidate {
+			if v != "" {
+				if r.Count > 0 {
+					craftedAmount = float64(r.Count)
+				}
+				return candidate, craftedAmount, true
+			}
+		}
+	}
+	r := itemData.Recipe
+	if r.A1 != "" || r.A2 != "" || r.A3 != "" || r.B1 != "" || r.B2 != "" || r.B3 != "" || r.C1 != "" || r.C2 != "" || r.C3 != "" {
+		if r.Count > 0 {
+			craftedAmount = float64(r.Count)
+		}
+		return map[string]string{"A1": r.A1, "A2": r.A2, "A3": r.A3, "B1": r.B1, "B2": r.B2, "B3": r.B3, "C1": r.C1, "C2": r.C2, "C3": r.C3}, craftedAmount, true
+	}
+	return nil, craftedAmount, false
+}
+
+// defaultItemFilesDir and defaultMetricsFilePath are the recipe-file
+// directory and metrics file ExpandRecipe resolves its dependencies from,
+// since its signature (matching the ad-hoc ExpandItem-style entrypoints
+// elsewhere) doesn't thread them through explicitly. Override at startup if
+// the deployment lays files out differently.
+var (
+	defaultItemFilesDir    = "items"
+	defaultMetricsFilePath = "latest_metrics.json"
+)
+
+// ExpandRecipe performs a memoized depth-first expansion of itemID's
+// ingredient graph to quantity qty, reusing the same recipe-cell DSL and
+// cycle detection (via ItemStep path tracking + isInPath) as the
+// CraftingStepNode walker in tree_builder.go, but caching each
+// (normalizedID, qty) combination so repeated sub-ingredients across
+// sibling branches are only expanded once. depth should be 0 for a
+// top-level call; it is carried through for diagnostic purposes only. The
+// whole expansion runs under one Span (logger.go), so every dlog/Debug/Trace
+// line it or its recursive calls emit carries the same span ID and can be
+// correlated end-to-end, and ctx cancellation now aborts expandRecipeMemoized
+// partway through rather than only being checked by the CraftingStepNode
+// walker's ctx.Err() check.
+func ExpandRecipe(ctx context.Context, itemID string, qty float64, depth int) (*CraftTree, error) {
+	ctx, end := Span(ctx, "ExpandRecipe:"+BAZAAR_ID(itemID))
+	defer end()
+
+	apiResp, _ := getApiResponse(ctx)
+	metricsMap, err := getMetricsMapFromFile(defaultMetricsFilePath)
+	if err != nil {
+		dlog("ExpandRecipe: failed to load metrics map from '%s': %v", defaultMetricsFilePath, err)
+	}
+	memo := newExpansionMemo()
+	return expandRecipeMemoized(ctx, itemID, qty, nil, BAZAAR_ID(itemID), depth, apiResp, metricsMap, defaultItemFilesDir, memo)
+}
+
+func expandRecipeMemoized(
+	ctx context.Context,
+	itemName string, qty float64, path []ItemStep, originalTopLevelItemID string, depth int,
+	apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string, memo *expansionMemo,
+) (*CraftTree, error) {
+	itemNameNorm := BAZAAR_ID(itemName)
+	node := &CraftTree{ItemName: itemNameNorm, QuantityNeeded: qty}
+
+	if err := ctx.Err(); err != nil {
+		node.IsBaseComponent = true
+		node.ErrorMessage = fmt.Sprintf("expansion cancelled: %v", err)
+		return node, err
+	}
+
+	if isInPath(itemNameNorm, path) {
+		node.IsBaseComponent = true
+		node.ErrorMessage = "Cycle detected"
+		cost, method, _, _, _, err := getBestC10M(ctx, itemNameNorm, qty, apiResp, metricsMap, PrecisionFloat, nil)
+		node.BestCost, node.Method = valueOrNaN(cost), method
+		if err != nil {
+			node.Method = "ERROR (Cycle)"
+		}
+		return node, nil
+	}
+
+	ingredients, hasRecipe, err := memo.flattenedIngredients(ctx, itemNameNorm, qty, itemFilesDir, apiResp)
+	if err != nil {
+		node.IsBaseComponent = true
+		node.ErrorMessage = err.Error()
+		return node, err
+	}
+
+	if !hasRecipe || len(ingredients) == 0 {
+		node.IsBaseComponent = true
+		cost, method, _, _, _, c10mErr := getBestC10M(ctx, itemNameNorm, qty, apiResp, metricsMap, PrecisionFloat, nil)
+		node.BestCost, node.Method = valueOrNaN(cost), method
+		if c10mErr != nil {
+			node.ErrorMessage = c10mErr.Error()
+		}
+		return node, nil
+	}
+
+	currentPath := append(append([]ItemStep{}, path...), ItemStep{name: itemNameNorm, quantity: qty})
+	node.Ingredients = make(map[string]*CraftTree, len(ingredients))
+	for ingName, ingQty := range ingredients {
+		if ingQty <= 0 {
+			continue
+		}
+		childNode, childErr := expandRecipeMemoized(ctx, ingName, ingQty, currentPath, originalTopLevelItemID, depth+1, apiResp, metricsMap, itemFilesDir, memo)
+		if childErr != nil && childNode == nil {
+			childNode = &CraftTree{ItemName: BAZAAR_ID(ingName), QuantityNeeded: ingQty, IsBaseComponent: true, BestCost: math.NaN(), ErrorMessage: childErr.Error()}
+		}
+		node.Ingredients[childNode.ItemName] = childNode
+	}
+	return node, nil
+}
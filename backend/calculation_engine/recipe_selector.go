@@ -0,0 +1,92 @@
+// recipe_selector.go
+package main
+
+// AltCost is one non-winning Recipes[] variant's per-unit cost, recorded
+// alongside the chosen RecipeChoice so a caller can see what the runner-up
+// would have cost instead of just which index won.
+type AltCost struct {
+	Index       int     `json:"index"`
+	PerUnitCost float64 `json:"per_unit_cost"`
+}
+
+// RecipeChoice is one viable Recipes[] variant, priced per unit of crafted
+// output, for a RecipeSelector to weigh against its alternatives. Cells is
+// the variant's raw recipe-cell content (same shape as chosenRecipeCells
+// elsewhere in this file), exposed so a selector can inspect ingredient IDs
+// without expandItemRecursiveTree having to pre-digest every policy it
+// might want to apply.
+type RecipeChoice struct {
+	Index        int               `json:"index"`
+	PerUnitCost  float64           `json:"per_unit_cost"`
+	Cells        map[string]string `json:"-"`
+	Alternatives []AltCost         `json:"alternatives,omitempty"`
+}
+
+// RecipeSelector picks, by index into choices, which RecipeChoice
+// expandItemRecursiveTree should use for an item with multiple viable
+// Recipes[] variants - a caller-supplied alternative to
+// ActiveRecipeSelectionPolicy's fixed set of named policies. A return value
+// outside [0, len(choices)) is treated as "no preference" and falls back to
+// ActiveRecipeSelectionPolicy.
+type RecipeSelector func(choices []RecipeChoice) int
+
+// ActiveRecipeSelector, when non-nil, overrides ActiveRecipeSelectionPolicy
+// for every expandItemRecursiveTree call in this process - the same
+// global-override convention ActiveRecipeSelectionPolicy itself uses,
+// rather than threading a selector through every call site's argument list.
+var ActiveRecipeSelector RecipeSelector
+
+// RecipeSelectorPreferVolume returns a RecipeSelector that, among variants
+// where every ingredient clears minBuyMovingWeek of weekly instasell
+// volume, picks the cheapest per unit; if no variant clears the bar for
+// every ingredient, it falls back to plain cheapest-per-unit among all
+// viable variants. BuyMovingWeek is the same live volume figure
+// instasellRateOverWindow falls back to reading off QuickStatus when no
+// serial metrics store history exists yet, reused here rather than
+// re-deriving a second notion of "volume" for recipe selection.
+func RecipeSelectorPreferVolume(apiResp *HypixelAPIResponse, minBuyMovingWeek float64) RecipeSelector {
+	return func(choices []RecipeChoice) int {
+		best, bestLiquid := -1, false
+		for i, c := range choices {
+			liquid := recipeChoiceClearsVolume(c, apiResp, minBuyMovingWeek)
+			switch {
+			case best == -1:
+				best, bestLiquid = i, liquid
+			case liquid && !bestLiquid:
+				best, bestLiquid = i, liquid
+			case liquid == bestLiquid && c.PerUnitCost < choices[best].PerUnitCost:
+				best = i
+			}
+		}
+		return best
+	}
+}
+
+// recipeChoiceClearsVolume reports whether every concrete ingredient ID in
+// choice.Cells has at least minBuyMovingWeek of weekly instasell volume per
+// apiResp's QuickStatus. An interchangeable "ITEM_A|ITEM_B" cell clears the
+// bar if any one of its alternates does, mirroring ResolvedItemID's
+// cheapest-wins tie-break for the same kind of cell.
+func recipeChoiceClearsVolume(choice RecipeChoice, apiResp *HypixelAPIResponse, minBuyMovingWeek float64) bool {
+	for _, cellContent := range choice.Cells {
+		if cellContent == "" {
+			continue
+		}
+		ids, _, _, _, err := parseCellDSL(cellContent)
+		if err != nil || len(ids) == 0 {
+			return false
+		}
+		cellClears := false
+		for _, id := range ids {
+			productData, ok := safeGetProductData(apiResp, BAZAAR_ID(id))
+			if ok && productData.QuickStatus.BuyMovingWeek >= minBuyMovingWeek {
+				cellClears = true
+				break
+			}
+		}
+		if !cellClears {
+			return false
+		}
+	}
+	return true
+}
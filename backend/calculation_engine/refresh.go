@@ -0,0 +1,219 @@
+// refresh.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrStale is returned by WaitForFreshData (and can be checked by callers of
+// getApiResponse) when the cached Bazaar data is older than hardStale.
+var ErrStale = errors.New("bazaar data is harder-stale than allowed")
+
+// refreshSubscribers fan out every successfully fetched HypixelAPIResponse to
+// interested consumers (crafting/profit calculators) without making them
+// poll the cache themselves.
+type refreshSubscribers struct {
+	mu   sync.Mutex
+	subs map[chan *HypixelAPIResponse]struct{}
+}
+
+var globalRefreshSubscribers = &refreshSubscribers{subs: make(map[chan *HypixelAPIResponse]struct{})}
+
+// Subscribe returns a channel that receives every new Bazaar snapshot
+// published by StartBackgroundRefresh. If a subscriber is too slow to drain
+// its channel, the oldest buffered update is dropped in favor of the new one
+// so a single slow consumer can't stall the publisher.
+func Subscribe() <-chan *HypixelAPIResponse {
+	ch := make(chan *HypixelAPIResponse, 1)
+	globalRefreshSubscribers.mu.Lock()
+	globalRefreshSubscribers.subs[ch] = struct{}{}
+	globalRefreshSubscribers.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops ch from receiving further updates and closes it.
+func Unsubscribe(ch <-chan *HypixelAPIResponse) {
+	globalRefreshSubscribers.mu.Lock()
+	defer globalRefreshSubscribers.mu.Unlock()
+	for c := range globalRefreshSubscribers.subs {
+		if c == ch {
+			delete(globalRefreshSubscribers.subs, c)
+			close(c)
+			return
+		}
+	}
+}
+
+func (s *refreshSubscribers) publish(resp *HypixelAPIResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- resp:
+		default:
+			// Drop-oldest: make room for the new update rather than block.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- resp:
+			default:
+			}
+		}
+	}
+}
+
+// staleness thresholds used by StartBackgroundRefresh and by getApiResponse
+// callers that want to distinguish "usable but aging" from "too old to
+// trust".
+var (
+	softStaleThreshold = 60 * time.Second
+	hardStaleThreshold = 5 * time.Minute
+)
+
+// priceUpdateStatus tracks one full refresh cycle's timing/progress so
+// apiStatusHandler can report it without every caller having to poll the
+// Bazaar cache and metrics file cache separately.
+var (
+	priceUpdateMu            sync.RWMutex
+	startFullPriceUpdateTime time.Time
+	lastFullPriceUpdateTime  time.Time
+	priceUpdateProgress      int // 0-100; 100 from the end of one cycle until the next one starts
+)
+
+// PriceUpdateStatus returns the timestamps/progress StartBackgroundRefresh's
+// most recent cycle left behind, for apiStatusHandler or any other caller
+// that wants to judge "how fresh is this" without touching the cache directly.
+func PriceUpdateStatus() (start, last time.Time, progressPct int) {
+	priceUpdateMu.RLock()
+	defer priceUpdateMu.RUnlock()
+	return startFullPriceUpdateTime, lastFullPriceUpdateTime, priceUpdateProgress
+}
+
+func setPriceUpdateProgress(pct int) {
+	priceUpdateMu.Lock()
+	priceUpdateProgress = pct
+	priceUpdateMu.Unlock()
+}
+
+// StartBackgroundRefresh periodically re-pulls the Bazaar API and re-parses
+// metricsFilePath every interval, publishing each successful Bazaar snapshot
+// to Subscribe()'d channels, and returns once ctx is cancelled. Run it in its
+// own goroutine:
+//
+//	go StartBackgroundRefresh(ctx, 30*time.Second, defaultMetricsFilePath)
+//
+// interval can safely run well under Hypixel's ~60s update cadence: a poll
+// that comes back with the same LastUpdated timestamp as last time is not
+// published, so subscribers (recipe expansion, metrics) only recompute when
+// the upstream data has actually moved.
+func StartBackgroundRefresh(ctx context.Context, interval time.Duration, metricsFilePath string) {
+	dlog("StartBackgroundRefresh: starting with interval %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPublishedUpdate int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			dlog("StartBackgroundRefresh: context cancelled, stopping.")
+			return
+		case <-ticker.C:
+			priceUpdateMu.Lock()
+			startFullPriceUpdateTime = time.Now()
+			priceUpdateMu.Unlock()
+			setPriceUpdateProgress(0)
+
+			resp, err := forceRefreshAPIData(ctx)
+			if err != nil {
+				dlog("StartBackgroundRefresh: refresh failed: %v", err)
+				setPriceUpdateProgress(100)
+				continue
+			}
+			setPriceUpdateProgress(50)
+			if resp != nil && resp.LastUpdated != lastPublishedUpdate {
+				globalRefreshSubscribers.publish(resp)
+				lastPublishedUpdate = resp.LastUpdated
+			}
+
+			if metricsFilePath != "" {
+				if err := ReloadMetricsFileCache(metricsFilePath); err != nil {
+					dlog("StartBackgroundRefresh: metrics file reload failed: %v", err)
+				}
+			}
+
+			priceUpdateMu.Lock()
+			lastFullPriceUpdateTime = time.Now()
+			priceUpdateMu.Unlock()
+			setPriceUpdateProgress(100)
+		}
+	}
+}
+
+// apiStatusHandler reports StartBackgroundRefresh's progress as JSON, so
+// operators/clients can tell "still refreshing" from "stale because the
+// refresh loop isn't running" without polling /dashboard/status's HTML.
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	start, last, progress := PriceUpdateStatus()
+	_, age, cached := getBazaarCache().Get()
+	cacheAgeSeconds := -1.0
+	if cached {
+		cacheAgeSeconds = age.Seconds()
+	}
+	breakerOpen, breakerCooldown, lastFetch, lastErr := BazaarStatus()
+	lastErrStr := ""
+	if lastErr != nil {
+		lastErrStr = lastErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		StartFullPriceUpdateTime time.Time `json:"start_full_price_update_time"`
+		LastFullPriceUpdateTime  time.Time `json:"last_full_price_update_time"`
+		PriceUpdateProgress      int       `json:"price_update_progress"`
+		CacheAgeSeconds          float64   `json:"cache_age_seconds"`
+		LastFetch                time.Time `json:"last_fetch"`
+		LastError                string    `json:"last_error,omitempty"`
+		BreakerOpen              bool      `json:"breaker_open"`
+		BreakerCooldownSeconds   float64   `json:"breaker_cooldown_seconds,omitempty"`
+	}{
+		StartFullPriceUpdateTime: start,
+		LastFullPriceUpdateTime:  last,
+		PriceUpdateProgress:      progress,
+		CacheAgeSeconds:          cacheAgeSeconds,
+		LastFetch:                lastFetch,
+		LastError:                lastErrStr,
+		BreakerOpen:              breakerOpen,
+		BreakerCooldownSeconds:   breakerCooldown.Seconds(),
+	})
+}
+
+// WaitForFreshData returns the cached response if it is newer than
+// softStaleThreshold (triggering a background refresh if it's between the
+// soft and hard thresholds), or the cached response together with ErrStale
+// itself (not wrapped) if its age has exceeded hardStaleThreshold.
+func WaitForFreshData() (*HypixelAPIResponse, error) {
+	cache := getBazaarCache()
+	resp, age, ok := cache.Get()
+	if !ok {
+		return nil, errors.New("no bazaar data cached yet")
+	}
+	if age > hardStaleThreshold {
+		return resp, ErrStale
+	}
+	if age > softStaleThreshold {
+		go func() {
+			if _, err := forceRefreshAPIData(context.Background()); err != nil {
+				dlog("WaitForFreshData: background soft-stale refresh failed: %v", err)
+			}
+		}()
+	}
+	return resp, nil
+}
@@ -0,0 +1,122 @@
+// refresh_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshSubscribersBackpressureDropsOldest(t *testing.T) {
+	subs := &refreshSubscribers{subs: make(map[chan *HypixelAPIResponse]struct{})}
+	ch := make(chan *HypixelAPIResponse, 1)
+	subs.subs[ch] = struct{}{}
+
+	first := &HypixelAPIResponse{LastUpdated: 1}
+	second := &HypixelAPIResponse{LastUpdated: 2}
+
+	// Nothing drains ch between these two publishes, so the slow-subscriber
+	// path must drop `first` in favor of `second` rather than blocking.
+	subs.publish(first)
+	subs.publish(second)
+
+	select {
+	case got := <-ch:
+		if got.LastUpdated != 2 {
+			t.Errorf("ch received LastUpdated=%d, want 2 (the oldest buffered update should have been dropped)", got.LastUpdated)
+		}
+	default:
+		t.Fatal("ch had nothing buffered after two publishes")
+	}
+
+	select {
+	case extra := <-ch:
+		t.Errorf("ch had an unexpected second buffered value: %+v", extra)
+	default:
+	}
+}
+
+func TestRefreshSubscribersPublishFansOutToAllSubscribers(t *testing.T) {
+	subs := &refreshSubscribers{subs: make(map[chan *HypixelAPIResponse]struct{})}
+	chA := make(chan *HypixelAPIResponse, 1)
+	chB := make(chan *HypixelAPIResponse, 1)
+	subs.subs[chA] = struct{}{}
+	subs.subs[chB] = struct{}{}
+
+	subs.publish(&HypixelAPIResponse{LastUpdated: 7})
+
+	for name, ch := range map[string]chan *HypixelAPIResponse{"chA": chA, "chB": chB} {
+		select {
+		case got := <-ch:
+			if got.LastUpdated != 7 {
+				t.Errorf("%s received LastUpdated=%d, want 7", name, got.LastUpdated)
+			}
+		default:
+			t.Errorf("%s had nothing buffered after publish", name)
+		}
+	}
+}
+
+// fakeBazaarServer returns an httptest.Server that serves a
+// HypixelAPIResponse whose LastUpdated is read from lastUpdated on every
+// request, so a test can change it between polls to control whether
+// StartBackgroundRefresh sees "new data" or a repeat.
+func fakeBazaarServer(lastUpdated *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HypixelAPIResponse{
+			Success:     true,
+			LastUpdated: atomic.LoadInt64(lastUpdated),
+			Products:    map[string]HypixelProduct{},
+		})
+	}))
+}
+
+func TestStartBackgroundRefreshPublishesOnlyOnChange(t *testing.T) {
+	var lastUpdated int64 = 100
+	server := fakeBazaarServer(&lastUpdated)
+	defer server.Close()
+
+	prevSource := CurrentBazaarSource
+	defer func() { CurrentBazaarSource = prevSource }()
+	CurrentBazaarSource = MirrorSource{URL: server.URL}
+
+	sub := Subscribe()
+	defer Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go StartBackgroundRefresh(ctx, 10*time.Millisecond, "")
+
+	// First tick: LastUpdated=100 is new, so it must be published.
+	select {
+	case resp := <-sub:
+		if resp.LastUpdated != 100 {
+			t.Errorf("first publish LastUpdated = %d, want 100", resp.LastUpdated)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the first publish")
+	}
+
+	// Several more ticks at the same LastUpdated must not republish.
+	select {
+	case resp := <-sub:
+		t.Errorf("unexpected republish of unchanged data: %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once the upstream data actually moves, the next tick must publish it.
+	atomic.StoreInt64(&lastUpdated, 200)
+	select {
+	case resp := <-sub:
+		if resp.LastUpdated != 200 {
+			t.Errorf("second publish LastUpdated = %d, want 200", resp.LastUpdated)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the publish after LastUpdated changed")
+	}
+}
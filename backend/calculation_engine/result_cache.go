@@ -0,0 +1,122 @@
+// result_cache.go
+package main
+
+import "sync"
+
+// TreeAnalysisResult is analyzeTreeForCostsAndTimes's six return values,
+// bundled so ResultCache can store and hand back exactly what a cache hit
+// replaces a fresh call with.
+type TreeAnalysisResult struct {
+	TotalCost           float64
+	SlowestFillTimeSecs float64
+	SlowestIngName      string
+	SlowestIngQty       float64
+	IsPossible          bool
+	ErrorMsg            string
+}
+
+// resultCacheKey identifies one analyzeTreeForCostsAndTimes call: the root
+// item, the exact quantity requested (unlike TreeCache's bucketed quantity -
+// this cache is invalidated by metric staleness, not tree-shape similarity,
+// so there's no reason to collapse nearby quantities together), and the
+// precision mode, since PrecisionDecimal/PrecisionFloat sums can legitimately
+// differ by a fraction of a millicoin.
+type resultCacheKey struct {
+	root      string
+	quantity  float64
+	precision PrecisionMode
+}
+
+// resultCacheEntry is one cached TreeAnalysisResult plus dependsOn, the set
+// of base item IDs (BAZAAR_ID-normalized) whose metrics fed into it - the
+// same "{B | C uses B}" dependency a build system would track for staleness.
+// dirty is set by Invalidate rather than deleting the entry outright, so a
+// cache that wants to report what it last knew while a recompute is pending
+// still can.
+type resultCacheEntry struct {
+	result    TreeAnalysisResult
+	dependsOn map[string]bool
+	dirty     bool
+}
+
+// ResultCache memoizes analyzeTreeForCostsAndTimes's result per
+// (rootItemName, quantity, precision), invalidated only for entries whose
+// dependsOn set intersects a batch of metric IDs that actually changed - so
+// a marketboard refresh that moved a handful of prices re-evaluates only the
+// roots that transitively depend on them, not the whole catalog.
+type ResultCache struct {
+	mu      sync.Mutex
+	entries map[resultCacheKey]*resultCacheEntry
+}
+
+// NewResultCache returns an empty ResultCache.
+func NewResultCache() *ResultCache {
+	return &ResultCache{entries: make(map[resultCacheKey]*resultCacheEntry)}
+}
+
+// Get returns the cached result for (root, qty, precision), if present and
+// not marked dirty by a later Invalidate call.
+func (c *ResultCache) Get(root string, qty float64, precision PrecisionMode) (TreeAnalysisResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[resultCacheKey{root: BAZAAR_ID(root), quantity: qty, precision: precision}]
+	if !ok || entry.dirty {
+		return TreeAnalysisResult{}, false
+	}
+	return entry.result, true
+}
+
+// Put stores result for (root, qty, precision), recording dependsOn (base
+// item IDs, not yet necessarily normalized) as the set Invalidate compares
+// future changes against.
+func (c *ResultCache) Put(root string, qty float64, precision PrecisionMode, result TreeAnalysisResult, dependsOn map[string]bool) {
+	deps := make(map[string]bool, len(dependsOn))
+	for id := range dependsOn {
+		deps[BAZAAR_ID(id)] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[resultCacheKey{root: BAZAAR_ID(root), quantity: qty, precision: precision}] = &resultCacheEntry{result: result, dependsOn: deps}
+}
+
+// Invalidate marks dirty every cached entry whose dependsOn set intersects
+// itemIDs, so the next Get for that (root, qty, precision) misses and the
+// caller recomputes. Entries unaffected by this batch of changed items stay
+// fresh and keep serving their cached result.
+func (c *ResultCache) Invalidate(itemIDs ...string) {
+	changed := make(map[string]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		changed[BAZAAR_ID(id)] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.dirty {
+			continue
+		}
+		for dep := range entry.dependsOn {
+			if changed[dep] {
+				entry.dirty = true
+				break
+			}
+		}
+	}
+}
+
+var (
+	defaultResultCache     *ResultCache
+	defaultResultCacheOnce sync.Once
+)
+
+// DefaultResultCache lazily constructs the package-wide ResultCache,
+// mirroring DefaultTreeCache's singleton pattern.
+func DefaultResultCache() *ResultCache {
+	defaultResultCacheOnce.Do(func() {
+		defaultResultCache = NewResultCache()
+	})
+	return defaultResultCache
+}
+
+// ResultCacheDisabled mirrors TreeCacheDisabled: set before the first
+// analyzeTreeForCostsAndTimes call to bypass ResultCache entirely.
+var ResultCacheDisabled = false
@@ -0,0 +1,104 @@
+// risk_model.go
+package main
+
+import "math"
+
+// RiskLambda names the λ coefficient RiskModel.riskAdjustedCost uses to
+// weight σ against a candidate's expected cost (cost + λ·σ, Markowitz-
+// style), so a caller can pick a qualitative risk posture instead of tuning
+// a raw float directly.
+type RiskLambda float64
+
+const (
+	RiskConservative RiskLambda = 2.0
+	RiskNeutral      RiskLambda = 1.0
+	RiskAggressive   RiskLambda = 0.25
+)
+
+// defaultVolatilityFactor scales primarySigma when RiskModel.VolatilityFactor
+// is left at its zero value.
+const defaultVolatilityFactor = 1.0
+
+// RiskModel turns the SecondaryBased chooser's Craft-vs-Primary comparison
+// from a plain expected-cost comparison into a risk-adjusted one, using the
+// liquidity metrics (Delta, and the SellFrequency/OrderFrequency behind it)
+// BaseIngredientDetail and ProductMetrics already carry. The zero value
+// disables risk-adjustment entirely: riskAdjustedCost returns its cost
+// argument unchanged when Lambda <= 0, exactly like MethodPolicy's zero
+// value never rejects Primary and ExpansionOptions.TimeValueCoefficient's
+// zero value leaves effectiveCost unchanged.
+type RiskModel struct {
+	// Lambda weights σ against expected cost; <= 0 disables risk adjustment.
+	// Use RiskConservative/RiskNeutral/RiskAggressive, or a custom value.
+	Lambda RiskLambda
+	// VolatilityFactor scales primarySigma's |Delta|/(SellFreq+OrderFreq)
+	// estimate; <= 0 falls back to defaultVolatilityFactor.
+	VolatilityFactor float64
+}
+
+func (m RiskModel) enabled() bool { return m.Lambda > 0 }
+
+func (m RiskModel) volatilityFactor() float64 {
+	if m.VolatilityFactor > 0 {
+		return m.VolatilityFactor
+	}
+	return defaultVolatilityFactor
+}
+
+// primarySigma estimates one acquisition's cost uncertainty from its own
+// Delta (sellSize*sellFreq - orderSize*orderFreq) and combined order/sell
+// frequency: a lopsided or thin buy-order book (large |Delta| relative to
+// total activity) means the nominal cost is less trustworthy, so σ grows
+// with |Delta| and shrinks as combined frequency rises. A market with no
+// recorded activity at all (totalFreq <= 0) is treated as maximally
+// uncertain rather than zero.
+func (m RiskModel) primarySigma(delta, sellFreq, orderFreq float64) float64 {
+	if !m.enabled() || math.IsNaN(delta) {
+		return 0
+	}
+	totalFreq := sellFreq + orderFreq
+	if totalFreq <= 0 {
+		return math.Inf(1)
+	}
+	return m.volatilityFactor() * math.Abs(delta) / totalFreq
+}
+
+// craftSigma propagates each base ingredient's own primarySigma through the
+// recipe tree as an independent-variance sum, √(Σ qty²·σᵢ²): an ingredient
+// needed in bulk or backed by an unusually thin market dominates Craft's
+// overall uncertainty more than one bought in small quantity from a deep
+// one. An ingredient metricsMap has no entry for contributes zero σ rather
+// than Inf, since a sub-component this function can't price liquidity for
+// shouldn't unconditionally poison the whole tree's estimate.
+func (m RiskModel) craftSigma(base map[string]BaseIngredientDetail, metricsMap map[string]ProductMetrics) float64 {
+	if !m.enabled() {
+		return 0
+	}
+	var sumSquares float64
+	for id, detail := range base {
+		metrics, ok := metricsMap[id]
+		if !ok {
+			continue
+		}
+		sigma := m.primarySigma(float64(detail.Delta), metrics.SellFrequency, metrics.OrderFrequency)
+		if math.IsInf(sigma, 1) {
+			return math.Inf(1)
+		}
+		sumSquares += detail.Quantity * detail.Quantity * sigma * sigma
+	}
+	return math.Sqrt(sumSquares)
+}
+
+// riskAdjustedCost scores cost + λ·σ; it returns cost unchanged when the
+// model is disabled or cost is already Inf/NaN, and Inf when sigma itself is
+// unbounded, so a caller can apply it unconditionally without branching on
+// whether risk-adjustment is on.
+func (m RiskModel) riskAdjustedCost(cost, sigma float64) float64 {
+	if !m.enabled() || math.IsNaN(cost) || math.IsInf(cost, 0) {
+		return cost
+	}
+	if math.IsInf(sigma, 1) {
+		return math.Inf(1)
+	}
+	return cost + float64(m.Lambda)*sigma
+}
@@ -0,0 +1,147 @@
+// scenario_config.go
+package main
+
+// Declarative per-item crafting scenarios, in the spirit of bbgo's
+// exchangeStrategies: config file (strategy.go already borrows bbgo's
+// Strategy/OnTick shape for the same reason). This package has never taken
+// a YAML dependency anywhere - config here, like everywhere else in this
+// tree (see main.go's flag-driven globals, aliases_file's JSON map), is
+// plain JSON, not YAML, so this loader follows that rather than introducing
+// gopkg.in/yaml.v3 as this package's first third-party dependency.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// TopLevelBuyStrategy names which acquisition method ItemConfig should bias
+// PerformDualExpansion's P1/P2 choosers toward for this item.
+type TopLevelBuyStrategy string
+
+const (
+	BuyStrategyAuto           TopLevelBuyStrategy = "AUTO"
+	BuyStrategyForcePrimary   TopLevelBuyStrategy = "FORCE_PRIMARY"
+	BuyStrategyForceSecondary TopLevelBuyStrategy = "FORCE_SECONDARY"
+)
+
+// ItemConfig is one scenario in a ScenarioConfig: an item to expand plus the
+// knobs that used to only be reachable via hardcoded constants or ad hoc
+// CLI flags.
+type ItemConfig struct {
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	// TopLevelBuyStrategy biases the acquisition chooser via
+	// ForceMethodStrategy; AUTO (or empty) leaves the cost/epsilon-based
+	// choice alone.
+	TopLevelBuyStrategy TopLevelBuyStrategy `json:"topLevelBuyStrategy"`
+	// PendingMinutes, if > 0, is wrapped as PendingTimeoutStrategy: a
+	// Primary candidate whose estimated fill time exceeds this many minutes
+	// is treated as not viable, the same as if it had to be instabuy'd
+	// instead.
+	PendingMinutes float64 `json:"pendingMinutes"`
+	// NoRebalance marks this item as excluded from any TargetWeights-driven
+	// rebalance plan (rebalance.go) built alongside this scenario config;
+	// PlanRebalance itself doesn't read ItemConfig, so a caller wiring the
+	// two together is expected to fold NoRebalance into its own
+	// ignoreLocked set.
+	NoRebalance bool `json:"noRebalance"`
+	// Epsilon is CraftTolerancePctStrategy's tolerancePct: Craft wins over a
+	// nominally cheaper Primary/Secondary as long as it's within this
+	// fraction of it. 0 (the default) falls back to PureCostStrategy,
+	// i.e. strictly cheapest-wins.
+	Epsilon float64 `json:"epsilon"`
+	// PreferredRecipeIndex optionally names, for any ingredient itemID
+	// appearing in this item's recipe tree, which of its Recipes[] entries
+	// (by index) to prefer. NOTE: this is read by ToExpansionOptions'
+	// caller at the tree_builder.go expansion layer only
+	// (ActiveRecipeSelectionPolicy's "first"/"cheapest_cost"/etc. policy is
+	// global, not per-item or per-call); cost_dag.go's separate
+	// BuildBestCostDAG path added per-variant pricing in an earlier change
+	// but has no config hook of its own yet, so a PreferredRecipeIndex entry
+	// only takes effect for expansions that go through
+	// expandItemRecursiveTree, not through BuildBestCostDAG.
+	PreferredRecipeIndex map[string]int `json:"paths"`
+}
+
+// ScenarioConfig is the top-level shape of a scenario config file: a named
+// list of ItemConfigs, mirroring bbgo's config.yaml having a top-level
+// exchangeStrategies: list of strategy configs.
+type ScenarioConfig struct {
+	Scenarios []ItemConfig `json:"scenarios"`
+}
+
+// LoadScenarioConfig reads and parses a scenario config file at path.
+func LoadScenarioConfig(path string) (*ScenarioConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading scenario config '%s': %w", path, err)
+	}
+	var cfg ScenarioConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing scenario config '%s': %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// ToExpansionOptions derives the ExpansionOptions PerformDualExpansion
+// should use for cfg: Epsilon becomes CraftTolerancePctStrategy, optionally
+// wrapped in PendingTimeoutStrategy (PendingMinutes) and/or overridden
+// entirely by ForceMethodStrategy (a non-AUTO TopLevelBuyStrategy).
+func (cfg ItemConfig) ToExpansionOptions() ExpansionOptions {
+	var strategy AcquisitionStrategy = CraftTolerancePctStrategy(cfg.Epsilon)
+	if cfg.PendingMinutes > 0 {
+		strategy = PendingTimeoutStrategy(cfg.PendingMinutes*60, strategy)
+	}
+	switch cfg.TopLevelBuyStrategy {
+	case BuyStrategyForcePrimary:
+		strategy = ForceMethodStrategy("Primary")
+	case BuyStrategyForceSecondary:
+		strategy = ForceMethodStrategy("Secondary")
+	}
+	return ExpansionOptions{Strategy: strategy}
+}
+
+// scenarioResult is one RunScenarioCLI report entry: cfg's expansion result
+// alongside the scenario it came from, so a caller with several scenarios in
+// one file can tell them apart in the printed JSON.
+type scenarioResult struct {
+	Config ItemConfig           `json:"config"`
+	Result *DualExpansionResult `json:"result,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// RunScenarioCLI implements the `scenario` CLI subcommand: it loads a
+// ScenarioConfig from configPath, runs PerformDualExpansion for each
+// ItemConfig with options derived via ToExpansionOptions, and writes every
+// result as a JSON array to stdout.
+func RunScenarioCLI(ctx context.Context, configPath string) error {
+	cfg, err := LoadScenarioConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	apiResp, err := getApiResponse(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching bazaar data for scenario run: %w", err)
+	}
+	metricsMap, err := getMetricsMapFromFile(defaultMetricsFilePath)
+	if err != nil {
+		dlog("RunScenarioCLI: failed to load metrics map from '%s': %v", defaultMetricsFilePath, err)
+	}
+
+	results := make([]scenarioResult, 0, len(cfg.Scenarios))
+	for _, item := range cfg.Scenarios {
+		qty := item.Quantity
+		if qty <= 0 {
+			qty = 1
+		}
+		dual, expandErr := PerformDualExpansion(ctx, item.Item, qty, apiResp, metricsMap, defaultItemFilesDir, true, PrecisionFloat, item.ToExpansionOptions())
+		results = append(results, scenarioResult{Config: item, Result: dual, Error: errString(expandErr)})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
@@ -0,0 +1,105 @@
+// sell_expansion.go
+package main
+
+import "math"
+
+// computeSellBasedResult is PerformDualExpansion's revenue-side perspective:
+// whether itemNameNorm is worth more instasold whole ("SellWhole") or broken
+// back down into its crafting components and those instasold individually
+// ("SellComponents", using baseIngredientsFromCraft - the same base
+// ingredient map the Craft candidate already built). Unlike PrimaryBased/
+// SecondaryBased, which pick the cheapest way to *acquire* the item, this
+// picks the most profitable way to *liquidate* it, so TotalCost here is
+// proceeds rather than cost.
+func computeSellBasedResult(
+	itemNameNorm string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	baseIngredientsFromCraft map[string]BaseIngredientDetail,
+	instaSellTimeRaw float64,
+	precision PrecisionMode,
+) ExpansionResult {
+	res := ExpansionResult{
+		PerspectiveType: "SellBased", BaseIngredients: make(map[string]BaseIngredientDetail),
+		TotalCost: toJSONFloat64(math.NaN()), TopLevelCost: toJSONFloat64(math.NaN()), TopLevelRR: toJSONFloat64(math.NaN()),
+		SlowestIngredientBuyTimeSeconds: toJSONFloat64(math.NaN()),
+	}
+
+	sellWholeRaw := math.NaN()
+	if buyP := getBuyPrice(apiResp, itemNameNorm); buyP > 0 {
+		sellWholeRaw = buyP * quantity
+	}
+
+	componentsRaw, componentDetails := sellComponentsProceeds(baseIngredientsFromCraft, apiResp)
+
+	var candidates []Candidate
+	if !math.IsNaN(sellWholeRaw) {
+		candidates = append(candidates, Candidate{Method: "SellWhole", CostRaw: -sellWholeRaw, FillTimeRaw: instaSellTimeRaw})
+	}
+	if !math.IsNaN(componentsRaw) {
+		candidates = append(candidates, Candidate{Method: "SellComponents", CostRaw: -componentsRaw, FillTimeRaw: 0})
+	}
+	res.CandidatesConsidered = candidates
+
+	switch {
+	case len(candidates) == 0:
+		res.TopLevelAction = "TreatedAsBase (Unobtainable)"
+		res.FinalCostMethod = "N/A"
+		res.CalculationPossible = false
+		res.ErrorMessage = "SellBased: neither instaselling the item nor its components yielded a price."
+	case !math.IsNaN(componentsRaw) && (math.IsNaN(sellWholeRaw) || costLessOrEqual(sellWholeRaw, componentsRaw, precision)):
+		res.TopLevelAction = "SellComponents"
+		res.FinalCostMethod = "SumBuyPriceFromComponents"
+		res.TotalCost = toJSONFloat64(valueOrNaN(componentsRaw))
+		res.BaseIngredients = componentDetails
+		res.CalculationPossible = true
+		res.DecisionReason = "fixed best-proceeds tie-break: SellComponents chosen among viable candidate(s)"
+	default:
+		res.TopLevelAction = "SellWhole"
+		res.FinalCostMethod = "InstasellTopLevel"
+		res.TotalCost = toJSONFloat64(valueOrNaN(sellWholeRaw))
+		res.SlowestIngredientBuyTimeSeconds = toJSONFloat64(valueOrNaN(instaSellTimeRaw))
+		res.SlowestIngredientName = itemNameNorm
+		res.SlowestIngredientQuantity = sanitizeFloat(quantity)
+		res.BaseIngredients = map[string]BaseIngredientDetail{
+			itemNameNorm: {Quantity: quantity, Method: "Instasell", BestCost: toJSONFloat64(valueOrNaN(sellWholeRaw))},
+		}
+		res.CalculationPossible = true
+		res.DecisionReason = "fixed best-proceeds tie-break: SellWhole chosen among viable candidate(s)"
+	}
+
+	res.SlowestIngredientQuantity = sanitizeFloat(res.SlowestIngredientQuantity)
+	return res
+}
+
+// sellComponentsProceeds instasells every ingredient in baseIngredients at
+// its current buy price (the price a bazaar instasell fills at), summing to
+// the proceeds of fully un-crafting and liquidating the item piece by piece.
+// Returns NaN when baseIngredients is empty (the item has no recipe to break
+// down) or not one ingredient had a usable price.
+func sellComponentsProceeds(baseIngredients map[string]BaseIngredientDetail, apiResp *HypixelAPIResponse) (float64, map[string]BaseIngredientDetail) {
+	if len(baseIngredients) == 0 {
+		return math.NaN(), nil
+	}
+
+	total := 0.0
+	anyPriced := false
+	details := make(map[string]BaseIngredientDetail, len(baseIngredients))
+	for itemID, detail := range baseIngredients {
+		buyP := getBuyPrice(apiResp, itemID)
+		proceeds := math.NaN()
+		if buyP > 0 {
+			proceeds = buyP * detail.Quantity
+			total += proceeds
+			anyPriced = true
+		}
+		details[itemID] = BaseIngredientDetail{
+			Quantity: detail.Quantity, Method: "Instasell", BestCost: toJSONFloat64(valueOrNaN(proceeds)),
+		}
+	}
+	if !anyPriced {
+		return math.NaN(), nil
+	}
+	return total, details
+}
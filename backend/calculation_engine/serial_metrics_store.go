@@ -0,0 +1,402 @@
+// serial_metrics_store.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsSample is one timestamped recording of a product's moving-window
+// counters and order-flow metrics, the unit SerialMetricsStore retains.
+type MetricsSample struct {
+	TimestampUnix  int64   `json:"ts"`
+	BuyMovingWeek  float64 `json:"buy_moving_week"`
+	SellMovingWeek float64 `json:"sell_moving_week"`
+	SellSize       float64 `json:"sell_size"`
+	SellFrequency  float64 `json:"sell_frequency"`
+	OrderSize      float64 `json:"order_size"`
+	OrderFrequency float64 `json:"order_frequency"`
+}
+
+// Common window presets exposed via CLI/HTTP so callers don't have to spell
+// out a time.Duration literal.
+const (
+	WindowOneHour         = time.Hour
+	WindowSixHours        = 6 * time.Hour
+	WindowTwentyFourHours = 24 * time.Hour
+	WindowSevenDays       = 7 * 24 * time.Hour
+)
+
+// NamedWindows maps the CLI/HTTP-facing window names to their duration, for
+// flag/query-param parsing (see windowFromName).
+var NamedWindows = map[string]time.Duration{
+	"1h":  WindowOneHour,
+	"6h":  WindowSixHours,
+	"24h": WindowTwentyFourHours,
+	"7d":  WindowSevenDays,
+}
+
+// windowFromName resolves a CLI/HTTP window name (see NamedWindows) to a
+// duration, defaulting to WindowSevenDays (matching the old fixed
+// BuyMovingWeek/604800 behavior) for an empty or unrecognized name.
+func windowFromName(name string) time.Duration {
+	if d, ok := NamedWindows[name]; ok {
+		return d
+	}
+	return WindowSevenDays
+}
+
+// WindowRates is RateOverWindow's result: per-second rates derived from
+// differencing the two moving-week counters across the window, plus the
+// order-flow metrics (already rates, not cumulative counters) averaged over
+// the same window.
+type WindowRates struct {
+	BuyRatePerSecond  float64
+	SellRatePerSecond float64
+	SellSize          float64
+	SellFrequency     float64
+	OrderSize         float64
+	OrderFrequency    float64
+	SampleCount       int
+	WindowStart       time.Time
+	WindowEnd         time.Time
+}
+
+// SerialMetricsStore retains a per-item ring buffer of MetricsSample,
+// trimmed to a retention window, so RateOverWindow can derive a rate over
+// any shorter window (1h, 6h, 24h, ...) instead of only the full 7-day
+// average QuickStatus.BuyMovingWeek/SellMovingWeek exposes. Each item's
+// buffer is mirrored to dir/<itemID>.jsonl (append-only) so a restart
+// doesn't lose history; Compact rewrites that file trimmed to retention.
+type SerialMetricsStore struct {
+	dir       string
+	retention time.Duration
+
+	mu      sync.RWMutex
+	samples map[string][]MetricsSample
+}
+
+// NewSerialMetricsStore creates a store rooted at dir (created if missing)
+// and loads any samples already on disk for every <itemID>.jsonl file found
+// there, trimming each to retention. A zero or negative retention defaults
+// to 14 days, the minimum this subsystem is contracted to retain.
+func NewSerialMetricsStore(dir string, retention time.Duration) (*SerialMetricsStore, error) {
+	if retention <= 0 {
+		retention = 14 * 24 * time.Hour
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating serial metrics dir %s: %w", dir, err)
+	}
+
+	s := &SerialMetricsStore{
+		dir:       dir,
+		retention: retention,
+		samples:   make(map[string][]MetricsSample),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading serial metrics dir %s: %w", dir, err)
+	}
+	cutoff := time.Now().Add(-retention).Unix()
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		itemID := strings.TrimSuffix(entry.Name(), ".jsonl")
+		samples, err := loadSerialMetricsFile(filepath.Join(dir, entry.Name()), cutoff)
+		if err != nil {
+			dlog("WARN: failed to load serial metrics file for %s: %v", itemID, err)
+			continue
+		}
+		if len(samples) > 0 {
+			s.samples[itemID] = samples
+		}
+	}
+	return s, nil
+}
+
+func loadSerialMetricsFile(path string, cutoff int64) ([]MetricsSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []MetricsSample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var sample MetricsSample
+		if err := json.Unmarshal(line, &sample); err != nil {
+			continue // tolerate a truncated trailing record from a killed process
+		}
+		if sample.TimestampUnix >= cutoff {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].TimestampUnix < samples[j].TimestampUnix })
+	return samples, scanner.Err()
+}
+
+func (s *SerialMetricsStore) itemFilePath(itemID string) string {
+	return filepath.Join(s.dir, itemID+".jsonl")
+}
+
+// Ingest appends sample to itemID's in-memory buffer (trimming anything
+// older than the retention window) and to its on-disk file. itemID is
+// expected already normalized (see IngestSnapshot).
+func (s *SerialMetricsStore) Ingest(itemID string, sample MetricsSample) error {
+	s.mu.Lock()
+	cutoff := time.Now().Add(-s.retention).Unix()
+	buf := append(s.samples[itemID], sample)
+	trimmed := buf[:0:0]
+	for _, sm := range buf {
+		if sm.TimestampUnix >= cutoff {
+			trimmed = append(trimmed, sm)
+		}
+	}
+	s.samples[itemID] = trimmed
+	s.mu.Unlock()
+
+	f, err := os.OpenFile(s.itemFilePath(itemID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening serial metrics file for %s: %w", itemID, err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(sample); err != nil {
+		return fmt.Errorf("encoding serial metrics sample for %s: %w", itemID, err)
+	}
+	return nil
+}
+
+// IngestSnapshot is the convenience form of Ingest for a live poll cycle:
+// it normalizes itemID, pulls BuyMovingWeek/SellMovingWeek off product and
+// SellSize/SellFrequency/OrderSize/OrderFrequency off metrics, and stamps
+// the sample with ts. Intended to be called once per refresh cycle for
+// every polled product, the same point UpdatePriceHistory is meant to be
+// called from (right before MarketDataStore.Publish installs the new
+// snapshot).
+func (s *SerialMetricsStore) IngestSnapshot(itemID string, product HypixelProduct, metrics ProductMetrics, ts time.Time) error {
+	normItemID := BAZAAR_ID(itemID)
+	return s.Ingest(normItemID, MetricsSample{
+		TimestampUnix:  ts.Unix(),
+		BuyMovingWeek:  product.QuickStatus.BuyMovingWeek,
+		SellMovingWeek: product.QuickStatus.SellMovingWeek,
+		SellSize:       metrics.SellSize,
+		SellFrequency:  metrics.SellFrequency,
+		OrderSize:      metrics.OrderSize,
+		OrderFrequency: metrics.OrderFrequency,
+	})
+}
+
+// RateOverWindow returns the per-second buy/sell rate observed for itemID
+// over the trailing window, derived by summing consecutive samples' deltas
+// (clamping a negative delta - the weekly counter rolling over - to zero)
+// and dividing by the total elapsed seconds actually covered, which
+// naturally interpolates across any gaps in the poll cadence. ok is false
+// when fewer than 2 samples fall within the window, meaning the caller
+// should fall back to QuickStatus.BuyMovingWeek/604800.
+func (s *SerialMetricsStore) RateOverWindow(itemID string, window time.Duration) (rates WindowRates, ok bool) {
+	normItemID := BAZAAR_ID(itemID)
+	cutoff := time.Now().Add(-window).Unix()
+
+	s.mu.RLock()
+	all := s.samples[normItemID]
+	in := make([]MetricsSample, 0, len(all))
+	for _, sm := range all {
+		if sm.TimestampUnix >= cutoff {
+			in = append(in, sm)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(in) < 2 {
+		return WindowRates{}, false
+	}
+
+	var buyVol, sellVol, elapsed float64
+	var sellSizeSum, sellFreqSum, orderSizeSum, orderFreqSum float64
+	for i := 0; i+1 < len(in); i++ {
+		cur, next := in[i], in[i+1]
+		dt := float64(next.TimestampUnix - cur.TimestampUnix)
+		if dt <= 0 {
+			continue
+		}
+		elapsed += dt
+
+		buyDelta := next.BuyMovingWeek - cur.BuyMovingWeek
+		if buyDelta < 0 {
+			buyDelta = 0
+		}
+		buyVol += buyDelta
+
+		sellDelta := next.SellMovingWeek - cur.SellMovingWeek
+		if sellDelta < 0 {
+			sellDelta = 0
+		}
+		sellVol += sellDelta
+	}
+	for _, sm := range in {
+		sellSizeSum += sm.SellSize
+		sellFreqSum += sm.SellFrequency
+		orderSizeSum += sm.OrderSize
+		orderFreqSum += sm.OrderFrequency
+	}
+
+	if elapsed <= 0 {
+		return WindowRates{}, false
+	}
+
+	n := float64(len(in))
+	return WindowRates{
+		BuyRatePerSecond:  buyVol / elapsed,
+		SellRatePerSecond: sellVol / elapsed,
+		SellSize:          sellSizeSum / n,
+		SellFrequency:     sellFreqSum / n,
+		OrderSize:         orderSizeSum / n,
+		OrderFrequency:    orderFreqSum / n,
+		SampleCount:       len(in),
+		WindowStart:       time.Unix(in[0].TimestampUnix, 0),
+		WindowEnd:         time.Unix(in[len(in)-1].TimestampUnix, 0),
+	}, true
+}
+
+// RecentSamples returns itemID's last n in-memory samples, oldest first
+// (fewer than n if that much history isn't retained yet), for callers like
+// computeRateATR that need the raw series rather than a windowed average.
+func (s *SerialMetricsStore) RecentSamples(itemID string, n int) []MetricsSample {
+	normItemID := BAZAAR_ID(itemID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.samples[normItemID]
+	if n <= 0 || n >= len(all) {
+		return append([]MetricsSample(nil), all...)
+	}
+	return append([]MetricsSample(nil), all[len(all)-n:]...)
+}
+
+// Compact rewrites every item's on-disk file trimmed to the in-memory
+// buffer's current contents, dropping anything Ingest has already aged out
+// of retention. Intended to run periodically (see StartSerialMetricsCompactor)
+// so the append-only files don't grow unbounded.
+func (s *SerialMetricsStore) Compact() error {
+	s.mu.RLock()
+	snapshot := make(map[string][]MetricsSample, len(s.samples))
+	for itemID, samples := range s.samples {
+		snapshot[itemID] = append([]MetricsSample(nil), samples...)
+	}
+	s.mu.RUnlock()
+
+	var firstErr error
+	for itemID, samples := range snapshot {
+		tmpPath := s.itemFilePath(itemID) + ".tmp"
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("compacting %s: %w", itemID, err)
+			}
+			continue
+		}
+		enc := json.NewEncoder(f)
+		for _, sm := range samples {
+			if err := enc.Encode(sm); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("compacting %s: %w", itemID, err)
+			}
+		}
+		f.Close()
+		if err := os.Rename(tmpPath, s.itemFilePath(itemID)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("replacing compacted file for %s: %w", itemID, err)
+		}
+	}
+	return firstErr
+}
+
+// StartSerialMetricsCompactor runs store.Compact on a ticker until stop is
+// closed, mirroring StartStaleRefreshWorker's run-forever-in-a-goroutine
+// shape elsewhere in this package.
+func StartSerialMetricsCompactor(store *SerialMetricsStore, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := store.Compact(); err != nil {
+					dlog("WARN: serial metrics compaction failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+var (
+	defaultSerialMetricsStore     *SerialMetricsStore
+	defaultSerialMetricsStoreOnce sync.Once
+	defaultSerialMetricsStoreErr  error
+)
+
+// DefaultSerialMetricsStore lazily constructs the package-wide store rooted
+// at SerialMetricsDir (default "/tmp/metrics/serial", overridable before the
+// first call), for callers (fill_time.go's *WithWindow variants) that don't
+// hold a store reference of their own.
+var SerialMetricsDir = "/tmp/metrics/serial"
+
+func DefaultSerialMetricsStore() (*SerialMetricsStore, error) {
+	defaultSerialMetricsStoreOnce.Do(func() {
+		defaultSerialMetricsStore, defaultSerialMetricsStoreErr = NewSerialMetricsStore(SerialMetricsDir, 14*24*time.Hour)
+	})
+	return defaultSerialMetricsStore, defaultSerialMetricsStoreErr
+}
+
+// fillRateHandler exposes DefaultSerialMetricsStore's RateOverWindow over
+// HTTP so operators can check what window a quoted fill time is actually
+// using. Query params: item (required), window (one of NamedWindows' keys,
+// e.g. "1h"/"6h"/"24h"/"7d"; default "7d").
+func fillRateHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := r.URL.Query().Get("item")
+	if itemID == "" {
+		http.Error(w, "missing 'item' query parameter", http.StatusBadRequest)
+		return
+	}
+	window := windowFromName(r.URL.Query().Get("window"))
+
+	store, err := DefaultSerialMetricsStore()
+	if err != nil {
+		http.Error(w, "serial metrics store unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	rates, ok := store.RateOverWindow(itemID, window)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Item           string      `json:"item"`
+		Window         string      `json:"window"`
+		SufficientData bool        `json:"sufficient_data"`
+		Rates          WindowRates `json:"rates,omitempty"`
+	}{
+		Item:           BAZAAR_ID(itemID),
+		Window:         window.String(),
+		SufficientData: ok,
+		Rates:          rates,
+	})
+}
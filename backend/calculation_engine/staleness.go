@@ -0,0 +1,360 @@
+// staleness.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Freshness/confidence knobs consumed by calculateDetailedCostsAndFillTimes
+// and PerformDualExpansion to decide how far a ProductMetrics entry can be
+// trusted, modeled after feedhealth.go's phi thresholds: plain package-level
+// vars so a caller (or a test) can tune them without a config object.
+var (
+	confidenceHalfLife             = 5 * time.Minute
+	minMetricsConfidenceForPrimary = 0.5
+)
+
+// metricsConfidence converts an age into a 0..1 trust score that decays
+// exponentially with a half-life of confidenceHalfLife: full trust the
+// instant metrics are refreshed, halved every half-life afterwards, and
+// never negative. A zero or negative half-life disables decay (always 1.0).
+func metricsConfidence(age time.Duration) float64 {
+	if age <= 0 || confidenceHalfLife <= 0 {
+		return 1.0
+	}
+	return math.Pow(0.5, age.Seconds()/confidenceHalfLife.Seconds())
+}
+
+// ConfidenceLevel buckets a ProductMetrics age into a coarse, human-facing
+// verdict - callers comparing "profit" across a sweep of items want a quick
+// fresh/stale/missing read, not a raw 0..1 confidence they each have to
+// threshold themselves.
+type ConfidenceLevel string
+
+const (
+	ConfidenceFresh   ConfidenceLevel = "fresh"
+	ConfidenceStale   ConfidenceLevel = "stale"
+	ConfidenceMissing ConfidenceLevel = "missing"
+)
+
+// defaultMaxMetricsAgeSecs is the fresh/stale cutoff confidenceLevelFor falls
+// back to when a caller leaves its own threshold at zero - twice
+// confidenceHalfLife, so an entry only just decayed past
+// minMetricsConfidenceForPrimary also reads as "stale". A function rather
+// than a const since confidenceHalfLife is itself a tunable var.
+func defaultMaxMetricsAgeSecs() float64 {
+	return 2 * confidenceHalfLife.Seconds()
+}
+
+// confidenceLevelFor buckets ageSeconds (as returned by
+// metricsAgeAndConfidence) against maxAgeSecs: NaN age means no metrics were
+// ever found ("missing"), an age beyond maxAgeSecs is "stale", anything else
+// is "fresh". maxAgeSecs <= 0 falls back to defaultMaxMetricsAgeSecs.
+func confidenceLevelFor(ageSeconds float64, maxAgeSecs float64) ConfidenceLevel {
+	if math.IsNaN(ageSeconds) {
+		return ConfidenceMissing
+	}
+	if maxAgeSecs <= 0 {
+		maxAgeSecs = defaultMaxMetricsAgeSecs()
+	}
+	if ageSeconds > maxAgeSecs {
+		return ConfidenceStale
+	}
+	return ConfidenceFresh
+}
+
+// metricsAgeAndConfidence reports how old pm is and the corresponding
+// confidence. An unresolved or never-timestamped entry (ok false, or a zero
+// LastUpdated from a caller that built ProductMetrics by hand) is reported
+// as NaN age with zero confidence rather than guessed at.
+func metricsAgeAndConfidence(pm ProductMetrics, ok bool) (ageSeconds float64, confidence float64) {
+	if !ok || pm.LastUpdated.IsZero() {
+		return math.NaN(), 0.0
+	}
+	age := time.Since(pm.LastUpdated)
+	return age.Seconds(), metricsConfidence(age)
+}
+
+// stalenessScore ranks pm for the background refresh worker below: items
+// whose metrics are older are refreshed sooner, and items with a larger
+// absolute order-flow imbalance (|Delta|, the same SellSize*SellFrequency -
+// OrderSize*OrderFrequency quantity used throughout expansion.go) are bumped
+// ahead of equally-stale items with near-zero imbalance, since a mispriced
+// high-|Delta| item is the costliest one to get wrong. log1p keeps a huge
+// |Delta| from swamping age entirely.
+func stalenessScore(pm ProductMetrics, now time.Time) float64 {
+	age := math.Inf(1)
+	if !pm.LastUpdated.IsZero() {
+		age = now.Sub(pm.LastUpdated).Seconds()
+	}
+	delta := math.Abs(pm.SellSize*pm.SellFrequency - pm.OrderSize*pm.OrderFrequency)
+	return age + math.Log1p(delta)
+}
+
+// staleQueueEntry is one item tracked by the background refresh worker.
+type staleQueueEntry struct {
+	ItemID string
+	Score  float64
+	Age    float64 // seconds, snapshotted when the entry was (re)scored
+}
+
+// staleRefreshQueue is a bounded, staleness-ranked queue: Enqueue keeps only
+// the maxSize highest-scoring (most out-of-date) entries, so a burst of
+// in-flight expansions referencing many ingredients can't grow it without
+// bound.
+type staleRefreshQueue struct {
+	mu      sync.Mutex
+	maxSize int
+	entries []staleQueueEntry
+}
+
+func newStaleRefreshQueue(maxSize int) *staleRefreshQueue {
+	if maxSize <= 0 {
+		maxSize = 500
+	}
+	return &staleRefreshQueue{maxSize: maxSize}
+}
+
+// Enqueue inserts or updates itemID's entry and re-sorts by descending score
+// (most stale first), trimming to maxSize.
+func (q *staleRefreshQueue) Enqueue(itemID string, score, age float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e.ItemID == itemID {
+			q.entries[i].Score = score
+			q.entries[i].Age = age
+			q.resortLocked()
+			return
+		}
+	}
+	q.entries = append(q.entries, staleQueueEntry{ItemID: itemID, Score: score, Age: age})
+	q.resortLocked()
+	if len(q.entries) > q.maxSize {
+		q.entries = q.entries[:q.maxSize]
+	}
+}
+
+func (q *staleRefreshQueue) resortLocked() {
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].Score > q.entries[j].Score })
+}
+
+// Top returns up to n of the most out-of-date entries currently queued.
+func (q *staleRefreshQueue) Top(n int) []staleQueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > len(q.entries) {
+		n = len(q.entries)
+	}
+	out := make([]staleQueueEntry, n)
+	copy(out, q.entries[:n])
+	return out
+}
+
+// Dequeue removes and returns the single most out-of-date entry, or ok=false
+// if the queue is empty.
+func (q *staleRefreshQueue) Dequeue() (staleQueueEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return staleQueueEntry{}, false
+	}
+	e := q.entries[0]
+	q.entries = q.entries[1:]
+	return e, true
+}
+
+func (q *staleRefreshQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// globalStaleQueue is the package-wide bounded staleness queue fed by
+// StartStaleRefreshWorker and read by staleItemsHandler.
+var globalStaleQueue = newStaleRefreshQueue(500)
+
+// inFlightItems tracks which item IDs are currently referenced by an
+// in-flight PerformDualExpansion call, so the refresh worker and /stale
+// endpoint can focus on what's actually in use right now rather than
+// scanning every item metrics has ever seen.
+var inFlightItems = struct {
+	mu    sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+// trackInFlightItem increments itemID's in-flight reference count and
+// returns a func that decrements it again; callers should defer the release.
+func trackInFlightItem(itemID string) func() {
+	inFlightItems.mu.Lock()
+	inFlightItems.count[itemID]++
+	inFlightItems.mu.Unlock()
+	return func() {
+		inFlightItems.mu.Lock()
+		if inFlightItems.count[itemID] > 0 {
+			inFlightItems.count[itemID]--
+			if inFlightItems.count[itemID] == 0 {
+				delete(inFlightItems.count, itemID)
+			}
+		}
+		inFlightItems.mu.Unlock()
+	}
+}
+
+func inFlightItemIDs() []string {
+	inFlightItems.mu.Lock()
+	defer inFlightItems.mu.Unlock()
+	ids := make([]string, 0, len(inFlightItems.count))
+	for id := range inFlightItems.count {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// refreshCycleProgress reports how far the current pass over the stale
+// queue has gotten, for callers (the /stale endpoint) that want to know how
+// trustworthy the in-progress refresh is rather than just its final state.
+type refreshCycleProgress struct {
+	mu        sync.Mutex
+	total     int
+	completed int
+}
+
+var globalRefreshProgress = &refreshCycleProgress{}
+
+func (p *refreshCycleProgress) start(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+	p.completed = 0
+}
+
+func (p *refreshCycleProgress) advance() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed++
+}
+
+// PercentComplete returns the fraction (0-100) of the current refresh cycle
+// completed so far; a cycle with nothing queued reports 100.
+func (p *refreshCycleProgress) PercentComplete() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total == 0 {
+		return 100.0
+	}
+	return 100.0 * float64(p.completed) / float64(p.total)
+}
+
+// StartStaleRefreshWorker periodically (every interval) re-scores every item
+// currently referenced by an in-flight expansion against metricsMap(),
+// queues the results in globalStaleQueue, and works the queue down one entry
+// at a time via refreshFn (typically a per-item metrics re-fetch; nil is a
+// valid no-op for deployments with no live per-item refresh path), reporting
+// progress through globalRefreshProgress. It returns once ctx is cancelled;
+// run it in its own goroutine, like StartBackgroundRefresh:
+//
+//	go StartStaleRefreshWorker(ctx, 30*time.Second, getCurrentMetricsMap, nil)
+func StartStaleRefreshWorker(ctx context.Context, interval time.Duration, metricsMap func() map[string]ProductMetrics, refreshFn func(itemID string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runStaleRefreshCycle(metricsMap(), refreshFn)
+		}
+	}
+}
+
+func runStaleRefreshCycle(snapshot map[string]ProductMetrics, refreshFn func(itemID string)) {
+	now := time.Now()
+	for _, id := range inFlightItemIDs() {
+		pm, ok := snapshot[id]
+		if !ok {
+			continue
+		}
+		globalStaleQueue.Enqueue(id, stalenessScore(pm, now), now.Sub(pm.LastUpdated).Seconds())
+	}
+
+	globalRefreshProgress.start(globalStaleQueue.Len())
+	for {
+		entry, ok := globalStaleQueue.Dequeue()
+		if !ok {
+			break
+		}
+		if refreshFn != nil {
+			refreshFn(entry.ItemID)
+		}
+		globalRefreshProgress.advance()
+	}
+}
+
+// StaleItemSummary is one entry in the /stale response.
+type StaleItemSummary struct {
+	ItemID     string  `json:"item_id"`
+	AgeSeconds float64 `json:"age_seconds"`
+	Score      float64 `json:"score"`
+}
+
+// StaleItemsResponse is the payload served by the /stale HTTP endpoint.
+type StaleItemsResponse struct {
+	Items                  []StaleItemSummary `json:"items"`
+	RefreshProgressPercent float64            `json:"refresh_progress_percent"`
+}
+
+// ListStaleItems scans every entry in metricsMap (not just items currently
+// referenced by an in-flight expansion, unlike globalStaleQueue) and returns
+// the ones older than threshold, most-stale first. Entries with no
+// LastUpdated timestamp are skipped rather than assumed stale, since they
+// may simply have been built by hand without one.
+func ListStaleItems(metricsMap map[string]ProductMetrics, threshold time.Duration) []StaleItemSummary {
+	now := time.Now()
+	var out []StaleItemSummary
+	for id, pm := range metricsMap {
+		if pm.LastUpdated.IsZero() {
+			continue
+		}
+		age := now.Sub(pm.LastUpdated)
+		if age < threshold {
+			continue
+		}
+		out = append(out, StaleItemSummary{ItemID: id, AgeSeconds: age.Seconds(), Score: stalenessScore(pm, now)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgeSeconds > out[j].AgeSeconds })
+	return out
+}
+
+// staleItemsHandler serves the N most out-of-date items currently
+// referenced by any in-flight expansion, plus how far the ongoing
+// background refresh cycle has gotten, so a caller can judge how much to
+// trust the Confidence values an expansion just returned. N defaults to 20
+// and is overridable via the "n" query parameter.
+func staleItemsHandler(w http.ResponseWriter, r *http.Request) {
+	n := 20
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+	top := globalStaleQueue.Top(n)
+	items := make([]StaleItemSummary, 0, len(top))
+	for _, e := range top {
+		items = append(items, StaleItemSummary{ItemID: e.ItemID, AgeSeconds: e.Age, Score: e.Score})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StaleItemsResponse{
+		Items:                  items,
+		RefreshProgressPercent: globalRefreshProgress.PercentComplete(),
+	})
+}
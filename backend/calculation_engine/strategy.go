@@ -0,0 +1,157 @@
+// strategy.go
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// ProductSnapshot is what a Strategy's OnTick receives each tick: the live
+// Bazaar product, its metrics, and the fill-time/RR figures already computed
+// for it, so a strategy never has to call the calculators itself.
+type ProductSnapshot struct {
+	ProductID         string
+	Product           HypixelProduct
+	Metrics           ProductMetrics
+	BuyOrderFillTime  float64 // seconds, from calculateBuyOrderFillTime
+	BuyOrderRR        float64
+	InstasellFillTime float64 // seconds, from calculateInstasellFillTime
+}
+
+// OrderIntent is a strategy's desired action; it's a plain data value so the
+// caller decides how (or whether) to actually place it.
+type OrderIntent struct {
+	ProductID string
+	Side      string // "buy_order" or "sell_order"
+	Price     float64
+	Quantity  float64
+	Reason    string
+}
+
+// Strategy is the extension point for turning a ProductSnapshot into zero or
+// more OrderIntents, in the style of bbgo's strategy interface: implement it
+// and call RegisterStrategy once at init time instead of forking the
+// calculators in main.go.
+type Strategy interface {
+	ID() string
+	OnTick(ctx context.Context, snap ProductSnapshot) []OrderIntent
+}
+
+var (
+	strategyRegistryMu sync.RWMutex
+	strategyRegistry   = make(map[string]Strategy)
+)
+
+// RegisterStrategy installs s under id, overwriting any strategy previously
+// registered under the same id. Typically called from an init() function.
+func RegisterStrategy(id string, s Strategy) {
+	strategyRegistryMu.Lock()
+	defer strategyRegistryMu.Unlock()
+	strategyRegistry[id] = s
+}
+
+// GetStrategy looks up a previously registered strategy by id.
+func GetStrategy(id string) (Strategy, bool) {
+	strategyRegistryMu.RLock()
+	defer strategyRegistryMu.RUnlock()
+	s, ok := strategyRegistry[id]
+	return s, ok
+}
+
+// ListStrategies returns the IDs of every registered strategy.
+func ListStrategies() []string {
+	strategyRegistryMu.RLock()
+	defer strategyRegistryMu.RUnlock()
+	ids := make([]string, 0, len(strategyRegistry))
+	for id := range strategyRegistry {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// flipStrategy buys a resting order and relists the fill as a sell order
+// whenever the predicted round trip is fast enough and wide enough to be
+// worth the risk.
+type flipStrategy struct {
+	MaxRoundTripTime float64 // seconds
+	MinMarginCoins   float64
+}
+
+func (s *flipStrategy) ID() string { return "flip" }
+
+func (s *flipStrategy) OnTick(_ context.Context, snap ProductSnapshot) []OrderIntent {
+	if len(snap.Product.BuySummary) == 0 || len(snap.Product.SellSummary) == 0 {
+		return nil
+	}
+	buyPrice := snap.Product.BuySummary[0].PricePerUnit
+	sellPrice := snap.Product.SellSummary[0].PricePerUnit
+	margin := sellPrice - buyPrice
+	if margin <= 0 {
+		return nil
+	}
+
+	roundTrip := snap.BuyOrderFillTime + snap.InstasellFillTime
+	if math.IsNaN(roundTrip) || math.IsInf(roundTrip, 0) || roundTrip > s.MaxRoundTripTime {
+		return nil
+	}
+	if margin < s.MinMarginCoins {
+		return nil
+	}
+
+	return []OrderIntent{
+		{ProductID: snap.ProductID, Side: "buy_order", Price: buyPrice, Quantity: 1, Reason: "flip: acquire at buy order price"},
+		{ProductID: snap.ProductID, Side: "sell_order", Price: sellPrice, Quantity: 1, Reason: "flip: relist fill as sell order"},
+	}
+}
+
+// gapStrategy quotes inside a wide, balanced spread: it only fires when the
+// spread exceeds minSpread and supply/demand pressure (Δ = sellVolume -
+// buyVolume, same sign convention as calculateBuyOrderFillTime's
+// deltaNetFlow) is close to zero, i.e. neither side is running away from the
+// other.
+type gapStrategy struct {
+	MinSpread     float64
+	MaxDeltaRatio float64 // |Δ| / total volume must stay below this to count as "balanced"
+}
+
+func (s *gapStrategy) ID() string { return "gap" }
+
+func (s *gapStrategy) OnTick(_ context.Context, snap ProductSnapshot) []OrderIntent {
+	if len(snap.Product.BuySummary) == 0 || len(snap.Product.SellSummary) == 0 {
+		return nil
+	}
+	buyPrice := snap.Product.BuySummary[0].PricePerUnit
+	sellPrice := snap.Product.SellSummary[0].PricePerUnit
+	spread := sellPrice - buyPrice
+	if spread <= s.MinSpread {
+		return nil
+	}
+
+	sellVolume := snap.Metrics.SellSize * snap.Metrics.SellFrequency
+	buyVolume := snap.Metrics.OrderSize * snap.Metrics.OrderFrequency
+	totalVolume := sellVolume + buyVolume
+	if totalVolume <= 0 {
+		return nil
+	}
+	deltaRatio := math.Abs(sellVolume-buyVolume) / totalVolume
+	if deltaRatio > s.MaxDeltaRatio {
+		return nil
+	}
+
+	quoteBuy := buyPrice + 0.1
+	quoteSell := sellPrice - 0.1
+	if quoteSell <= quoteBuy {
+		return nil
+	}
+
+	return []OrderIntent{
+		{ProductID: snap.ProductID, Side: "buy_order", Price: quoteBuy, Quantity: 1, Reason: "gap: quote inside balanced spread"},
+		{ProductID: snap.ProductID, Side: "sell_order", Price: quoteSell, Quantity: 1, Reason: "gap: quote inside balanced spread"},
+	}
+}
+
+func init() {
+	RegisterStrategy("flip", &flipStrategy{MaxRoundTripTime: 600, MinMarginCoins: 1})
+	RegisterStrategy("gap", &gapStrategy{MinSpread: 2, MaxDeltaRatio: 0.1})
+}
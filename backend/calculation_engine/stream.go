@@ -0,0 +1,162 @@
+// stream.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ExpandRequest is one unit of work submitted to ExpandDualStream.
+type ExpandRequest struct {
+	ItemName string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	// MaxAgeSecs is the fresh/stale cutoff forwarded to
+	// ExpansionOptions.MaxMetricsAgeSecs; <= 0 falls back to
+	// defaultMaxMetricsAgeSecs (staleness.go).
+	MaxAgeSecs float64 `json:"max_age_secs,omitempty"`
+}
+
+// TraceStep records which acquisition method won for one base ingredient of
+// a streamed expansion, so a downstream consumer can see the recipe-branch
+// decisions without re-parsing the full RecipeTree.
+type TraceStep struct {
+	ItemID string `json:"item_id"`
+	Method string `json:"method"`
+}
+
+// StreamRecord is one line of ExpandDualStream's output: the expansion
+// result for a single ExpandRequest plus the bookkeeping a streaming
+// consumer needs that a one-shot caller wouldn't (how long this one record
+// took, and a compact trace of the winning branch per sub-ingredient).
+type StreamRecord struct {
+	ItemName       string               `json:"item_name"`
+	Quantity       float64              `json:"quantity"`
+	Result         *DualExpansionResult `json:"result,omitempty"`
+	DurationMillis int64                `json:"duration_millis"`
+	Trace          []TraceStep          `json:"trace,omitempty"`
+	ErrorMessage   string               `json:"error_message,omitempty"`
+}
+
+// buildTrace summarizes the acquisition method chosen for each base
+// ingredient of res's primary perspective (the one actually used to price
+// the top-level item), in the same iteration order BaseIngredients offers.
+func buildTrace(res *DualExpansionResult) []TraceStep {
+	if res == nil {
+		return nil
+	}
+	persp := res.PrimaryBased
+	if !persp.CalculationPossible {
+		persp = res.SecondaryBased
+	}
+	if len(persp.BaseIngredients) == 0 {
+		return nil
+	}
+	trace := make([]TraceStep, 0, len(persp.BaseIngredients))
+	for id, detail := range persp.BaseIngredients {
+		trace = append(trace, TraceStep{ItemID: id, Method: detail.Method})
+	}
+	return trace
+}
+
+// ExpandDualStream fans items out across a bounded worker pool, running
+// ExpandDual against store for each one, and delivers a StreamRecord per
+// item to out as soon as that item finishes — not in submission order, so a
+// consumer can start rendering rows immediately instead of waiting for the
+// slowest item in the batch. ExpandDualStream closes out and returns once
+// items is drained or ctx is cancelled; a per-item error is reported on its
+// StreamRecord rather than aborting the stream.
+func ExpandDualStream(ctx context.Context, store *MarketDataStore, items <-chan ExpandRequest, out chan<- StreamRecord, itemFilesDir string, workerCount int) {
+	if workerCount <= 0 {
+		workerCount = 4
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok := <-items:
+					if !ok {
+						return
+					}
+					start := time.Now()
+					result, err := ExpandDual(ctx, store, req.ItemName, req.Quantity, itemFilesDir, false)
+					record := StreamRecord{
+						ItemName:       req.ItemName,
+						Quantity:       req.Quantity,
+						Result:         result,
+						DurationMillis: time.Since(start).Milliseconds(),
+						Trace:          buildTrace(result),
+					}
+					if err != nil {
+						record.ErrorMessage = err.Error()
+					}
+					select {
+					case out <- record:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(out)
+}
+
+// bulkExpansionHandler is the wizhttp-style NDJSON streaming endpoint for
+// ExpandDualStream: the request body is one JSON ExpandRequest per line, and
+// the response body is one JSON StreamRecord per line, flushed as each item
+// finishes rather than buffered until the whole batch completes.
+func bulkExpansionHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	items := make(chan ExpandRequest)
+	out := make(chan StreamRecord)
+	ctx := r.Context()
+
+	go func() {
+		defer close(items)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			var req ExpandRequest
+			if err := json.Unmarshal(line, &req); err != nil {
+				log.Printf("bulkExpansionHandler: skipping malformed request line: %v", err)
+				continue
+			}
+			select {
+			case items <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go ExpandDualStream(ctx, dashboardMarketStore, items, out, defaultItemFilesDir, 8)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for record := range out {
+		if err := encoder.Encode(record); err != nil {
+			log.Printf("bulkExpansionHandler: write failed: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,67 @@
+// terminal.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// NoClear, set via the --no-clear flag (main.go), disables all ANSI
+// clear/cursor-positioning output even when stdout is a terminal - for a
+// user piping output somewhere escape codes would corrupt, without this
+// package having to guess that from TERM/NO_COLOR alone.
+var NoClear bool
+
+// isTerminalOutput reports whether w is a TTY clearConsole/redrawTable
+// should send ANSI escape sequences to. Honors NoClear, NO_COLOR, and
+// TERM=dumb the same way any other well-behaved terminal program does, and
+// is false for anything that isn't an *os.File (a pipe, a log file, a
+// bytes.Buffer in a future test).
+func isTerminalOutput(w io.Writer) bool {
+	if NoClear {
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
+
+const (
+	ansiClearScreen = "\x1b[2J"
+	ansiCursorHome  = "\x1b[H"
+)
+
+// clearConsole clears the terminal in place with ANSI escapes instead of
+// shelling out to `clear`/`cmd /c cls`, which is slow, flickers, and breaks
+// outright when stdout isn't a TTY (CI, a pipe, a redirected log file)
+// since there's no subprocess to run against. Falls back to a blank line
+// when stdout isn't a terminal wiz should be clearing, so callers don't need
+// their own isTerminalOutput check first.
+func clearConsole() {
+	if !isTerminalOutput(os.Stdout) {
+		fmt.Println()
+		return
+	}
+	fmt.Print(ansiClearScreen + ansiCursorHome)
+}
+
+// redrawTable repositions the cursor to the top-left and reprints body in
+// place, for refreshing a recipe cost table once per refresh tick without
+// scrolling the terminal. Falls back to a plain newline-terminated print
+// when stdout isn't a TTY, so piping the output to a file still reads as a
+// normal append-only log instead of a screen full of escape codes.
+func redrawTable(body string) {
+	if !isTerminalOutput(os.Stdout) {
+		fmt.Println(body)
+		return
+	}
+	fmt.Print(ansiCursorHome + ansiClearScreen + body)
+}
@@ -2,14 +2,81 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
-	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// NodeErrorKind classifies why a CraftingStepNode carries an error, replacing
+// the old free-form ErrorMessage string so a downstream consumer can switch
+// on Kind instead of substring-matching a human sentence (e.g. telling
+// "item isn't on Bazaar but has a recipe" apart from "recipe JSON is
+// corrupt").
+type NodeErrorKind string
+
+const (
+	CycleTopLevel        NodeErrorKind = "cycle_top_level"
+	CycleIntermediate    NodeErrorKind = "cycle_intermediate"
+	RecipeFileMissing    NodeErrorKind = "recipe_file_missing"
+	RecipeReadFailed     NodeErrorKind = "recipe_read_failed"
+	RecipeParseFailed    NodeErrorKind = "recipe_parse_failed"
+	AggregationFailed    NodeErrorKind = "aggregation_failed"
+	ZeroIngredients      NodeErrorKind = "zero_ingredients"
+	C10MUnavailable      NodeErrorKind = "c10m_unavailable"
+	C10MAPINotFound      NodeErrorKind = "c10m_api_not_found"
+	SubExpansionCritical NodeErrorKind = "sub_expansion_critical"
+	BudgetExhausted      NodeErrorKind = "budget_exhausted"
+)
+
+// Critical reports whether an error of this Kind means the failure should
+// propagate up as expandItemRecursiveTree's error return - aborting the
+// caller's own expansion - rather than just being recorded on the node while
+// expansion continues (e.g. an item simply having no recipe file is
+// node-local; a sub-expansion dying mid-flight is critical).
+func (k NodeErrorKind) Critical() bool {
+	return k == SubExpansionCritical
+}
+
+// NodeError is one classified failure recorded against a CraftingStepNode.
+// File and Ingredient are populated when Kind makes them meaningful
+// (RecipeReadFailed/RecipeParseFailed set File, SubExpansionCritical sets
+// Ingredient) and left zero otherwise.
+type NodeError struct {
+	Kind       NodeErrorKind `json:"kind"`
+	Message    string        `json:"message"`
+	File       string        `json:"file,omitempty"`
+	Ingredient string        `json:"ingredient,omitempty"`
+	err        error
+}
+
+// Unwrap exposes the wrapped error so errors.Is/errors.As work across a
+// NodeError the same as any other wrapped error.
+func (e NodeError) Unwrap() error { return e.err }
+
+// newNodeError builds a NodeError from a Go error, deriving Message from it.
+func newNodeError(kind NodeErrorKind, err error) NodeError {
+	ne := NodeError{Kind: kind, err: err}
+	if err != nil {
+		ne.Message = err.Error()
+	}
+	return ne
+}
+
+// nodeErrorf builds a NodeError from a formatted message rather than a Go
+// error - for call sites (expansion.go's dual-expansion pipeline) that only
+// ever had a plain string to report, not an error value to wrap.
+func nodeErrorf(kind NodeErrorKind, format string, args ...interface{}) NodeError {
+	return newNodeError(kind, fmt.Errorf(format, args...))
+}
+
 // CraftingStepNode struct definition
 type CraftingStepNode struct {
 	ItemName         string                `json:"item_name"`
@@ -19,14 +86,485 @@ type CraftingStepNode struct {
 	IsBaseComponent  bool                  `json:"is_base_component"`
 	Acquisition      *BaseIngredientDetail `json:"acquisition,omitempty"` // BaseIngredientDetail uses JSONFloat64
 	Ingredients      []*CraftingStepNode   `json:"ingredients,omitempty"`
-	ErrorMessage     string                `json:"error_message,omitempty"`
-	Depth            int                   `json:"depth"`
-	MaxSubTreeDepth  int                   `json:"max_sub_tree_depth"`
+	// Errors classifies every failure recorded against this node - see
+	// NodeError. Replaces the old free-form ErrorMessage string; use the
+	// ErrorMessage() method for a human-readable summary (also what
+	// MarshalJSON derives into the "error_message" key for existing
+	// consumers of the JSON API).
+	Errors          []NodeError `json:"errors,omitempty"`
+	Depth           int         `json:"depth"`
+	MaxSubTreeDepth int         `json:"max_sub_tree_depth"`
+
+	// SharedByCount counts extra references to this exact node beyond the
+	// one that first built it - i.e. how many other ingredient slots in the
+	// expansion resolved to the same (itemNameNorm, recipe) pair and got
+	// this pointer back from recipeMemo instead of triggering another
+	// recipe-file read and recursive expand. Left at its zero value for
+	// nodes that were only ever expanded once.
+	SharedByCount int `json:"shared_by_count,omitempty"`
+
+	// AlternativeRecipes records the Recipes[] variants that lost the C10M
+	// cost comparison in expandItemRecursiveTree when an item file declares
+	// more than one recipe - the winner is what's under Ingredients above.
+	// Empty for items whose file has zero or one recipe, since there was
+	// nothing to choose between.
+	AlternativeRecipes []RecipeCostSummary `json:"alternative_recipes,omitempty"`
+
+	// AttemptedAcquisitions records every AcquisitionFallbackResolvers entry
+	// calculateC10MForNode tried for this node's base component, in the order
+	// tried, when the bazaar pipeline (getBestC10M) couldn't price it -
+	// Acquisition above is the cheapest of these (or the bazaar result, if
+	// that succeeded and fallbacks were never consulted). Empty when no
+	// fallback was needed or AcquisitionFallbackResolvers is unconfigured.
+	AttemptedAcquisitions []BaseIngredientDetail `json:"attempted_acquisitions,omitempty"`
+
+	// mu guards in-place mutation (rescaleMemoNode, SharedByCount) now that
+	// ingredient sub-expansions run concurrently on expandWorkerPool and a
+	// shared DAG node can be rescaled by more than one goroutine. Unexported
+	// so encoding/json skips it - a node is only ever shared by pointer.
+	mu sync.Mutex
+}
+
+// addError appends a classified error to the node in place.
+func (n *CraftingStepNode) addError(kind NodeErrorKind, err error) {
+	n.Errors = append(n.Errors, newNodeError(kind, err))
+}
+
+// addErrorf is addError for call sites that only have a plain message to
+// report rather than a Go error to wrap.
+func (n *CraftingStepNode) addErrorf(kind NodeErrorKind, format string, args ...interface{}) {
+	n.Errors = append(n.Errors, nodeErrorf(kind, format, args...))
+}
+
+// HasError reports whether the node carries an error of the given Kind.
+func (n *CraftingStepNode) HasError(kind NodeErrorKind) bool {
+	for _, e := range n.Errors {
+		if e.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorMessage derives a single human-readable string from Errors, joining
+// every recorded message with "; " - the shape the old free-form field had,
+// kept so log lines reading it don't change. Also what MarshalJSON derives
+// into the JSON "error_message" key for backward compatibility.
+func (n *CraftingStepNode) ErrorMessage() string {
+	if n == nil || len(n.Errors) == 0 {
+		return ""
+	}
+	msgs := make([]string, len(n.Errors))
+	for i, e := range n.Errors {
+		msgs[i] = e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MarshalJSON adds a derived "error_message" key alongside the structured
+// "errors" field (via Errors' own json tag) so existing consumers reading
+// the old free-form string don't break.
+func (n *CraftingStepNode) MarshalJSON() ([]byte, error) {
+	type alias CraftingStepNode
+	return json.Marshal(struct {
+		*alias
+		ErrorMessage string `json:"error_message,omitempty"`
+	}{
+		alias:        (*alias)(n),
+		ErrorMessage: n.ErrorMessage(),
+	})
+}
+
+// errorNode builds a minimal base-component CraftingStepNode carrying a
+// single classified error - used by callers outside expandItemRecursiveTree
+// (expansion.go's dual-expansion pipeline) that construct their own ad hoc
+// placeholder nodes rather than going through the recursive expander.
+func errorNode(itemName string, quantity float64, kind NodeErrorKind, msg string, acq *BaseIngredientDetail) *CraftingStepNode {
+	n := &CraftingStepNode{ItemName: itemName, QuantityNeeded: quantity, IsBaseComponent: true, Acquisition: acq}
+	if msg != "" {
+		n.addErrorf(kind, "%s", msg)
+	}
+	return n
+}
+
+// RecipeCostSummary is one rejected Recipes[] variant's outcome, recorded on
+// CraftingStepNode.AlternativeRecipes so a caller can see what the path not
+// taken would have cost (or why it wasn't viable at all), the same spirit as
+// optimizer.go's AlternatePaths/PathSummary for path enumeration.
+type RecipeCostSummary struct {
+	RecipeIndex  int     `json:"recipe_index"`
+	Count        int     `json:"count"`
+	TotalCost    float64 `json:"total_cost"`
+	Possible     bool    `json:"possible"`
+	ErrorMessage string  `json:"error_message,omitempty"`
+}
+
+// recipeMemo caches the fully-expanded node for each item name within a
+// single top-level ExpandItemToTree call, keyed by itemNameNorm. A second
+// (non-cycle) request for the same item reuses the cached subtree instead
+// of re-reading its recipe file and recursing into its ingredients again -
+// the old behavior, which re-did that work once per occurrence and made
+// trees with a widely-shared intermediate (e.g. ENCHANTED_COBBLESTONE under
+// several enchanted-stone parents) blow up exponentially with depth.
+//
+// The key is just itemNameNorm rather than (itemNameNorm, recipeHash):
+// resolveIngredientSpecs's alternate/NBT choices are driven by apiResp,
+// which is fixed for the whole call, so a given item resolves to exactly
+// one recipe and one set of chosen ingredients within a single expansion -
+// there is nothing for a recipe hash to disambiguate yet. If a future
+// change makes recipe resolution vary within one call, this key needs a
+// recipe hash added alongside itemNameNorm before that can share safely.
+//
+// Concurrency: expandItemRecursiveTree's ingredient sub-expansions run on a
+// shared expandWorkerPool, so two goroutines can legitimately ask for the
+// same item at the same time. recipeMemo gives each key a singleflight slot
+// (memoSlot): whichever goroutine calls claim first becomes that slot's
+// owner and does the real expansion; every other concurrent claimer waits
+// on the slot's done channel and then folds its own demand into the
+// finished node via rescaleMemoNode, exactly like the old sequential
+// "already memoized" path - just safe to do from multiple goroutines.
+type recipeMemo struct {
+	mu      sync.Mutex
+	entries map[string]*memoSlot
+}
+
+type memoSlot struct {
+	node *CraftingStepNode
+	done chan struct{}
+}
+
+func newRecipeMemo() *recipeMemo {
+	return &recipeMemo{entries: make(map[string]*memoSlot)}
+}
+
+// sharedRecipeMemoContextKey is the context.Context key a batch caller
+// stashes a shared *recipeMemo under, following the same unexported-struct-
+// key idiom as logger.go's spanIDContextKey.
+type sharedRecipeMemoContextKey struct{}
+
+// contextWithSharedRecipeMemo returns a child of ctx carrying memo, so every
+// ExpandItemToTree call made with it (directly, or nested inside
+// PerformDualExpansion) reuses memo's entries instead of starting a fresh
+// recipeMemo of its own.
+func contextWithSharedRecipeMemo(ctx context.Context, memo *recipeMemo) context.Context {
+	return context.WithValue(ctx, sharedRecipeMemoContextKey{}, memo)
+}
+
+func sharedRecipeMemoFromContext(ctx context.Context) (*recipeMemo, bool) {
+	memo, ok := ctx.Value(sharedRecipeMemoContextKey{}).(*recipeMemo)
+	return memo, ok
+}
+
+// claim returns the slot for key, registering a new one if this is the
+// first request for key in this memo. owner is true for exactly one caller
+// per key - that caller must eventually call slot.finish, even on an error
+// path, so waiters aren't left blocked forever.
+func (m *recipeMemo) claim(key string) (slot *memoSlot, owner bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.entries[key]; ok {
+		return s, false
+	}
+	s := &memoSlot{done: make(chan struct{})}
+	m.entries[key] = s
+	return s, true
+}
+
+// finish publishes node (possibly nil, if the owner's expansion was
+// cancelled before it built one) and releases any waiters.
+func (s *memoSlot) finish(node *CraftingStepNode) {
+	s.node = node
+	close(s.done)
+}
+
+// wait blocks until the owner calls finish and returns the published node.
+func (s *memoSlot) wait() *CraftingStepNode {
+	<-s.done
+	return s.node
+}
+
+// mergeMemo copies src's entries into dst for every key dst doesn't already
+// have - used to fold a winning multi-recipe variant's isolated trial memo
+// (evaluateRecipeVariant) into the real one once it's chosen, so a later
+// reference to an ingredient the winner actually uses still gets a cache
+// hit. A rejected variant's trial memo is simply discarded.
+func mergeMemo(dst, src *recipeMemo) {
+	src.mu.Lock()
+	srcEntries := make(map[string]*memoSlot, len(src.entries))
+	for k, v := range src.entries {
+		srcEntries[k] = v
+	}
+	src.mu.Unlock()
+
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	for k, v := range srcEntries {
+		if _, exists := dst.entries[k]; !exists {
+			dst.entries[k] = v
+		}
+	}
+}
+
+// rescaleMemoNode folds an additional reference to an already-expanded
+// node into it in place: the node's own QuantityNeeded grows by extraQty,
+// and that growth is propagated down the existing subtree by scale factor
+// instead of re-expanding it, so the already-built ingredient tree stays
+// proportionally correct. Base-component leaves are re-priced via
+// calculateC10MForNode at their new quantity (one getBestC10M lookup, no
+// recursion) since C10M cost isn't simply linear in quantity once order
+// book depth is involved; crafted nodes just get NumCrafts recomputed.
+// node.mu is held for the full call, including the recursive descent into
+// children, so two goroutines rescaling different ancestors that share a
+// descendant (a common DAG shape) serialize on that descendant instead of
+// racing on its fields; since the descent only ever moves child-ward, this
+// can't deadlock against another rescale call coming from elsewhere.
+func rescaleMemoNode(ctx context.Context, node *CraftingStepNode, extraQty float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) {
+	if node == nil || extraQty <= 0 {
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.QuantityNeeded <= 0 {
+		return
+	}
+	scale := (node.QuantityNeeded + extraQty) / node.QuantityNeeded
+	node.QuantityNeeded += extraQty
+
+	if node.IsBaseComponent {
+		cost, method, assocCost, rr, ifVal, delta, attempted, err := calculateC10MForNode(ctx, node.ItemName, node.QuantityNeeded, apiResp, metricsMap)
+		if node.Acquisition == nil {
+			node.Acquisition = &BaseIngredientDetail{}
+		}
+		node.Acquisition.Quantity = node.QuantityNeeded
+		node.Acquisition.Method = method
+		node.Acquisition.BestCost = toJSONFloat64(valueOrNaN(cost))
+		node.Acquisition.AssociatedCost = toJSONFloat64(valueOrNaN(assocCost))
+		node.Acquisition.RR = toJSONFloat64(valueOrNaN(rr))
+		node.Acquisition.IF = toJSONFloat64(valueOrNaN(ifVal))
+		node.Acquisition.Delta = toJSONFloat64(valueOrNaN(delta))
+		node.AttemptedAcquisitions = attempted
+		if err != nil && len(node.Errors) == 0 {
+			node.addError(C10MUnavailable, err)
+		}
+		return
+	}
+
+	if node.QuantityPerCraft > 0 {
+		node.NumCrafts = math.Ceil(node.QuantityNeeded / node.QuantityPerCraft)
+	}
+	for _, child := range node.Ingredients {
+		if child == nil {
+			continue
+		}
+		rescaleMemoNode(ctx, child, child.QuantityNeeded*(scale-1), apiResp, metricsMap)
+	}
+}
+
+// expandWorkerPool bounds how many ingredient sub-expansions can run at
+// once across an entire ExpandItemToTree call - one shared pool threaded
+// through every recursive call, not one per node, so a wide top-level
+// recipe and a wide sub-recipe don't each spin up PoolSize goroutines and
+// oversubscribe the machine.
+type expandWorkerPool struct {
+	sem chan struct{}
+}
+
+// ExpandWorkerPoolSize is the default pool size for a fresh
+// expandWorkerPool; overridable before the first ExpandItemToTree call,
+// same pattern as TreeCacheDir. runtime.NumCPU() when left at zero. main()
+// applies the WIZ_EXPAND_WORKERS env var on top of this default at startup
+// (see applyExpandWorkerPoolSizeEnv), so a deployment can tune concurrency
+// without a code change.
+var ExpandWorkerPoolSize = runtime.NumCPU()
+
+// applyExpandWorkerPoolSizeEnv overrides ExpandWorkerPoolSize from the
+// WIZ_EXPAND_WORKERS env var, if set to a positive integer; left at its
+// runtime.NumCPU() default otherwise. Called once from main() before any
+// goroutine can have started an expansion.
+func applyExpandWorkerPoolSizeEnv(raw string) {
+	if raw == "" {
+		return
+	}
+	if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+		ExpandWorkerPoolSize = n
+	}
+}
+
+// RecipeSelectionPolicy selects how expandItemRecursiveTree picks among an
+// item's itemData.Recipes[] variants when more than one has usable content.
+type RecipeSelectionPolicy string
+
+const (
+	// RecipeSelectionCheapestCost expands every variant and keeps the one
+	// with the lowest total C10M-weighted ingredient cost - the default, and
+	// the only behavior this package had before RecipeSelectionPolicy
+	// existed.
+	RecipeSelectionCheapestCost RecipeSelectionPolicy = "cheapest_cost"
+	// RecipeSelectionFastestFill expands every variant and keeps the one
+	// whose slowest Primary (buy-order) ingredient fills quickest, trading
+	// gold for wall-clock time.
+	RecipeSelectionFastestFill RecipeSelectionPolicy = "fastest_fill"
+	// RecipeSelectionFirst skips evaluating alternatives entirely and takes
+	// itemData.Recipes[0], same as this package's pre-chunk18-1 behavior -
+	// for a caller that already knows Recipes[0] is authoritative (e.g. a
+	// curated recipe file) and wants to skip the cost of expanding variants
+	// it will never use.
+	RecipeSelectionFirst RecipeSelectionPolicy = "first"
+	// RecipeSelectionHighestRated picks the variant with the highest
+	// Recipe.Rating, a curator-assigned score independent of bazaar cost,
+	// breaking ties by declaration order (first one reached stays best).
+	RecipeSelectionHighestRated RecipeSelectionPolicy = "highest_rated"
+	// RecipeSelectionPreferNPC picks whichever variant has Recipe.Source
+	// "npc" (case-insensitive) over any other source, falling back to
+	// cheapest-cost among variants that tie on NPC-ness.
+	RecipeSelectionPreferNPC RecipeSelectionPolicy = "prefer_npc"
+)
+
+// ActiveRecipeSelectionPolicy is the policy expandItemRecursiveTree consults
+// when an item has more than one Recipes[] variant; overridable before the
+// first ExpandItemToTree call, same pattern as ExpandWorkerPoolSize and
+// TreeCacheDisabled, so a deployment can pick gold-optimal vs. time-optimal
+// crafting without a code change.
+var ActiveRecipeSelectionPolicy = RecipeSelectionCheapestCost
+
+// ExcludeRecipeTags, if non-empty, drops any Recipes[] variant carrying one
+// of these tags from consideration entirely - e.g. {"slayer"} to keep a
+// crafting-cost computation from ever choosing a slayer-gated recipe a
+// caller can't actually use. Overridable before the first ExpandItemToTree
+// call, same as ActiveRecipeSelectionPolicy.
+var ExcludeRecipeTags []string
+
+// recipeTagExcluded reports whether tags shares any entry (case-insensitive)
+// with ExcludeRecipeTags.
+func recipeTagExcluded(tags []string) bool {
+	if len(ExcludeRecipeTags) == 0 {
+		return false
+	}
+	for _, t := range tags {
+		for _, excluded := range ExcludeRecipeTags {
+			if strings.EqualFold(t, excluded) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newExpandWorkerPool(size int) *expandWorkerPool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	return &expandWorkerPool{sem: make(chan struct{}, size)}
+}
+
+// run blocks until a pool slot is free, then executes fn on a new goroutine
+// holding that slot; the slot is released when fn returns.
+func (p *expandWorkerPool) run(fn func()) {
+	p.sem <- struct{}{}
+	go func() {
+		defer func() { <-p.sem }()
+		fn()
+	}()
+}
+
+// ingredientTask is one (ingredient name, amount) pair awaiting expansion.
+type ingredientTask struct {
+	name   string
+	amount float64
+}
+
+// expandIngredientsConcurrent expands every task on pool and returns the
+// resulting nodes sorted by ItemName, so the tree is byte-identical to what
+// strictly sequential expansion would have produced for the same input
+// regardless of completion order. The first critical sub-expansion error
+// cancels the shared context so sibling workers still in flight stop early
+// (each checks ctx.Err() at the top of expandItemRecursiveTree) instead of
+// finishing pointless work; every task still gets a result node (an error
+// placeholder on failure), matching the old sequential loop's behavior.
+func expandIngredientsConcurrent(
+	ctx context.Context, pool *expandWorkerPool, tasks []ingredientTask,
+	currentPath []ItemStep, originalTopLevelItemID string, currentDepth int,
+	apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string, memo *recipeMemo,
+) []*CraftingStepNode {
+	if len(tasks) == 0 {
+		return nil
+	}
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	craftState, craftStateErr := DefaultCraftState()
+
+	results := make([]*CraftingStepNode, len(tasks))
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Add(1)
+		i, t := i, t
+		if craftStateErr == nil && craftState.BudgetExhausted(t.name) {
+			wg.Done()
+			results[i] = errorNode(t.name, t.amount, BudgetExhausted, fmt.Sprintf("daily volume/fee budget exhausted for %s", BAZAAR_ID(t.name)), nil)
+			continue
+		}
+		pool.run(func() {
+			defer wg.Done()
+			subNode, errSub := expandItemRecursiveTree(workCtx, t.name, t.amount, currentPath, originalTopLevelItemID, currentDepth+1, apiResp, metricsMap, itemFilesDir, memo, pool)
+			if errSub != nil {
+				errNode := &CraftingStepNode{
+					ItemName: BAZAAR_ID(t.name), QuantityNeeded: t.amount,
+					IsBaseComponent: true, Depth: currentDepth + 1, MaxSubTreeDepth: currentDepth + 1,
+					Acquisition: &BaseIngredientDetail{Quantity: t.amount, Method: "N/A (Sub-Expansion Critical Error)", BestCost: toJSONFloat64(math.NaN())},
+				}
+				ne := newNodeError(SubExpansionCritical, errSub)
+				ne.Ingredient = t.name
+				errNode.Errors = append(errNode.Errors, ne)
+				results[i] = errNode
+				cancel()
+			} else {
+				results[i] = subNode
+			}
+		})
+	}
+	wg.Wait()
+
+	out := make([]*CraftingStepNode, 0, len(results))
+	for _, r := range results {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ItemName < out[j].ItemName })
+	return out
 }
 
-func calculateC10MForNode(itemID string, quantity float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) (
-	cost float64, method string, assocCost float64, rr float64, ifVal float64, delta float64, err error) {
-	cost, method, assocCost, rr, ifVal, err = getBestC10M(itemID, quantity, apiResp, metricsMap)
+// calculateC10MForNode wraps getBestC10M - the bazaar-only pipeline - with a
+// fallback to AcquisitionFallbackResolvers (NPC shop, auction lowest-BIN,
+// ...) when bazaar can't price the item (method comes back "N/A" or err is
+// set), so "Not on Bazaar" no longer has to mean "unobtainable" for an item
+// whose price is trivially available from an NPC or the auction house.
+// attempted is every fallback resolver that ran, for
+// CraftingStepNode.AttemptedAcquisitions; nil when bazaar succeeded or no
+// fallback resolvers are configured.
+func calculateC10MForNode(ctx context.Context, itemID string, quantity float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) (
+	cost float64, method string, assocCost float64, rr float64, ifVal float64, delta float64, attempted []BaseIngredientDetail, err error) {
+	cost, method, assocCost, rr, ifVal, err = getBestC10M(ctx, itemID, quantity, apiResp, metricsMap, PrecisionFloat, nil)
+	if (err != nil || method == "N/A") && len(AcquisitionFallbackResolvers) > 0 {
+		if best, tried := resolveFallbackAcquisitions(ctx, itemID, quantity); best != nil {
+			cost, method, assocCost, rr, ifVal = float64(best.BestCost), best.Method, float64(best.AssociatedCost), math.NaN(), math.NaN()
+			attempted = tried
+			err = nil
+		} else {
+			attempted = tried
+		}
+	}
+	if err == nil && !math.IsInf(cost, 0) && !math.IsNaN(cost) {
+		if craftState, craftStateErr := DefaultCraftState(); craftStateErr == nil {
+			// fee/profit aren't observable from a cost query alone (this is a
+			// hypothetical/simulated level-cost estimate, not a realized
+			// trade), so only bazaarTaxRate*cost (the flat instant-sell tax a
+			// completed craft would eventually pay) and the cost itself (as
+			// this item's traded volume) are recorded; profit is left at 0.
+			craftState.RecordCraftOutcome(itemID, cost*bazaarTaxRate, cost, 0)
+		}
+	}
 	calculatedDelta := math.NaN()
 	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemID)
 	if metricsOk {
@@ -36,23 +574,199 @@ func calculateC10MForNode(itemID string, quantity float64, apiResp *HypixelAPIRe
 	return
 }
 
+// sumIngredientsCost totals the C10M cost of a set of already-expanded
+// ingredient subtrees, deduping shared DAG nodes by pointer so a node two
+// of the ingredients (or two recipe variants) happen to share isn't counted
+// twice - the same hazard extractBaseIngredientsFromTree's visited map
+// guards against when flattening a tree to its base components.
+func sumIngredientsCost(ingredients []*CraftingStepNode) (total float64, possible bool) {
+	visited := make(map[*CraftingStepNode]bool)
+	var walk func(n *CraftingStepNode) (float64, bool)
+	walk = func(n *CraftingStepNode) (float64, bool) {
+		if n == nil || visited[n] {
+			return 0, true
+		}
+		visited[n] = true
+		if n.IsBaseComponent {
+			if n.Acquisition == nil {
+				return math.Inf(1), false
+			}
+			cost := float64(n.Acquisition.BestCost)
+			if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+				return math.Inf(1), false
+			}
+			return cost, true
+		}
+		sum, ok := 0.0, true
+		for _, child := range n.Ingredients {
+			c, p := walk(child)
+			if !p {
+				ok = false
+				continue
+			}
+			sum += c
+		}
+		return sum, ok
+	}
+
+	total, possible = 0.0, true
+	for _, ing := range ingredients {
+		c, p := walk(ing)
+		if !p {
+			possible = false
+			continue
+		}
+		total += c
+	}
+	if !possible {
+		return math.Inf(1), false
+	}
+	return total, true
+}
+
+// slowestIngredientsFillTime walks a set of already-expanded ingredient
+// subtrees the same way sumIngredientsCost does, but reduces to the slowest
+// Primary (buy-order) fill time among them instead of summing cost - the
+// metric RecipeSelectionFastestFill compares variants on, since a craft can't
+// start until its slowest-filling ingredient is in hand.
+func slowestIngredientsFillTime(ctx context.Context, ingredients []*CraftingStepNode, metricsMap map[string]ProductMetrics) (slowest float64, possible bool) {
+	visited := make(map[*CraftingStepNode]bool)
+	var walk func(n *CraftingStepNode) (float64, bool)
+	walk = func(n *CraftingStepNode) (float64, bool) {
+		if n == nil || visited[n] {
+			return 0, true
+		}
+		visited[n] = true
+		if n.IsBaseComponent {
+			if n.Acquisition == nil {
+				return math.Inf(1), false
+			}
+			if n.Acquisition.Method != "Primary" {
+				return 0, true
+			}
+			metricsData, ok := safeGetMetricsData(metricsMap, n.ItemName)
+			if !ok {
+				return math.Inf(1), false
+			}
+			fillTime, _, err := calculateBuyOrderFillTime(ctx, n.ItemName, n.Acquisition.Quantity, metricsData)
+			if err != nil || math.IsNaN(fillTime) || math.IsInf(fillTime, 0) || fillTime < 0 {
+				return math.Inf(1), false
+			}
+			return fillTime, true
+		}
+		slowestChild, ok := 0.0, true
+		for _, child := range n.Ingredients {
+			t, p := walk(child)
+			if !p {
+				ok = false
+				continue
+			}
+			if t > slowestChild {
+				slowestChild = t
+			}
+		}
+		return slowestChild, ok
+	}
+
+	slowest, possible = 0.0, true
+	for _, ing := range ingredients {
+		t, p := walk(ing)
+		if !p {
+			possible = false
+			continue
+		}
+		if t > slowest {
+			slowest = t
+		}
+	}
+	if !possible {
+		return math.Inf(1), false
+	}
+	return slowest, true
+}
+
+// evaluateRecipeVariant expands one Recipes[] entry's ingredients in an
+// isolated trialMemo rather than the caller's real recipeMemo: a rejected
+// variant's trial expansion would otherwise leave phantom QuantityNeeded
+// demand baked into shared ingredient nodes for quantities this item never
+// actually ends up needing. The caller merges the winning variant's
+// trialMemo into the real memo (see mergeMemo) and discards the rest.
+func evaluateRecipeVariant(
+	ctx context.Context,
+	cells map[string]string, craftedAmount, quantityNeeded float64,
+	currentPath []ItemStep, originalTopLevelItemID string, currentDepth int,
+	apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string, pool *expandWorkerPool,
+	modules map[string]string, subRecipes map[string]SingleRecipe,
+) (ingredients []*CraftingStepNode, trialMemo *recipeMemo, totalCost float64, fillTimeSecs float64, possible bool, errMsg string) {
+	specs, aggErr := aggregateCells(ctx, cells, modules, subRecipes)
+	if aggErr != nil {
+		return nil, nil, math.Inf(1), math.Inf(1), false, aggErr.Error()
+	}
+	ingredientsInOneCraft := resolveIngredientSpecs(specs, apiResp)
+	if len(ingredientsInOneCraft) == 0 {
+		return nil, nil, math.Inf(1), math.Inf(1), false, "recipe yields zero ingredients"
+	}
+
+	numCrafts := math.Ceil(quantityNeeded / craftedAmount)
+	trialMemo = newRecipeMemo()
+	tasks := make([]ingredientTask, 0, len(ingredientsInOneCraft))
+	for ingName, ingAmtPerCraft := range ingredientsInOneCraft {
+		amt := snapQuantity(ingAmtPerCraft * numCrafts)
+		if amt <= 0 {
+			continue
+		}
+		tasks = append(tasks, ingredientTask{name: ingName, amount: amt})
+	}
+	ingredients = expandIngredientsConcurrent(ctx, pool, tasks, currentPath, originalTopLevelItemID, currentDepth, apiResp, metricsMap, itemFilesDir, trialMemo)
+
+	totalCost, possible = sumIngredientsCost(ingredients)
+	fillTimeSecs = math.Inf(1)
+	if possible {
+		if t, ok := slowestIngredientsFillTime(ctx, ingredients, metricsMap); ok {
+			fillTimeSecs = t
+		}
+	}
+	var errs []string
+	for _, ing := range ingredients {
+		if ing == nil {
+			continue
+		}
+		if msg := ing.ErrorMessage(); msg != "" {
+			errs = append(errs, msg)
+		}
+	}
+	if len(errs) > 0 {
+		errMsg = strings.Join(errs, "; ")
+	}
+	return
+}
+
 func expandItemRecursiveTree(
+	ctx context.Context,
 	itemName string, quantityNeeded float64, path []ItemStep, originalTopLevelItemID string, currentDepth int,
-	apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string,
-) (*CraftingStepNode, error) {
+	apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string, memo *recipeMemo, pool *expandWorkerPool,
+) (resultNode *CraftingStepNode, resultErr error) {
 	itemNameNorm := BAZAAR_ID(itemName)
-	node := &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantityNeeded, Depth: currentDepth, MaxSubTreeDepth: currentDepth}
+
+	if err := ctx.Err(); err != nil {
+		node := &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantityNeeded, Depth: currentDepth, MaxSubTreeDepth: currentDepth}
+		node.IsBaseComponent = true
+		node.addErrorf(SubExpansionCritical, "expansion cancelled: %v", err)
+		return node, err
+	}
 
 	if isInPath(itemNameNorm, path) {
+		node := &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantityNeeded, Depth: currentDepth, MaxSubTreeDepth: currentDepth}
+		DefaultMetrics(nil).ExpandCyclesDetectedTotal.Inc()
 		node.IsBaseComponent = true
 		isTopLevelCycle := itemNameNorm == originalTopLevelItemID
 		if isTopLevelCycle {
-			node.ErrorMessage = "Cycle detected to top-level item"
+			node.addErrorf(CycleTopLevel, "Cycle detected to top-level item")
 		} else {
-			node.ErrorMessage = "Cycle detected to intermediate item"
+			node.addErrorf(CycleIntermediate, "Cycle detected to intermediate item")
 		}
 
-		costRaw, method, assocCostRaw, rrRaw, ifValRaw, deltaRaw, errC10M := calculateC10MForNode(itemNameNorm, quantityNeeded, apiResp, metricsMap)
+		costRaw, method, assocCostRaw, rrRaw, ifValRaw, deltaRaw, attemptedRaw, errC10M := calculateC10MForNode(ctx, itemNameNorm, quantityNeeded, apiResp, metricsMap)
 		node.Acquisition = &BaseIngredientDetail{
 			Quantity: quantityNeeded, Method: method,
 			BestCost:       toJSONFloat64(valueOrNaN(costRaw)),
@@ -61,78 +775,100 @@ func expandItemRecursiveTree(
 			IF:             toJSONFloat64(valueOrNaN(ifValRaw)),
 			Delta:          toJSONFloat64(valueOrNaN(deltaRaw)),
 		}
+		node.AttemptedAcquisitions = attemptedRaw
 		if errC10M != nil {
 			if node.Acquisition.Method == "N/A" || node.Acquisition.Method == "" {
 				node.Acquisition.Method = "ERROR (Cycle)"
 			}
-			if node.ErrorMessage == "" {
-				node.ErrorMessage = errC10M.Error()
-			} else if !strings.Contains(node.ErrorMessage, errC10M.Error()) {
-				node.ErrorMessage += "; " + errC10M.Error()
-			}
+			node.addError(C10MUnavailable, errC10M)
 		}
 		return node, nil
 	}
 
+	// Non-cycle re-entry: this item was already (or is already being)
+	// expanded earlier in this same top-level call. claim gives us either
+	// ownership of doing the real expansion, or a slot to wait on if another
+	// goroutine - possibly running concurrently on pool - got there first.
+	// Either way the new demand is folded into the cached node instead of
+	// re-reading and re-parsing its recipe file and recursing into its
+	// ingredients again - see recipeMemo's doc comment.
+	slot, owner := memo.claim(itemNameNorm)
+	if !owner {
+		cached := slot.wait()
+		if cached != nil {
+			rescaleMemoNode(ctx, cached, quantityNeeded, apiResp, metricsMap)
+			cached.mu.Lock()
+			cached.SharedByCount++
+			cached.mu.Unlock()
+		}
+		return cached, nil
+	}
+	defer func() { slot.finish(resultNode) }()
+
+	emitExpansionEvent(ctx, ExpansionEvent{Type: EventSubtreeExpanded, ItemName: itemNameNorm, Depth: currentDepth})
+
+	node := &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantityNeeded, Depth: currentDepth, MaxSubTreeDepth: currentDepth}
+
 	currentPath := append([]ItemStep{}, path...)
 	currentPath = append(currentPath, ItemStep{name: itemNameNorm, quantity: quantityNeeded})
-	filePath := filepath.Join(itemFilesDir, itemNameNorm+".json")
-	recipeFileExists := false
-	var itemData Item
-
-	if _, statErr := os.Stat(filePath); statErr == nil {
-		recipeFileExists = true
-		data, readErr := os.ReadFile(filePath)
-		if readErr != nil {
+	filePath := recipeFilePath(itemFilesDir, itemNameNorm)
+	itemData, recipeFileExists, loadErr := loadItemFileCached(filePath)
+	if loadErr != nil {
+		if errors.Is(loadErr, errItemFileParseFailed) {
 			node.IsBaseComponent = true
-			node.ErrorMessage = fmt.Sprintf("Error reading recipe file '%s': %v", filePath, readErr)
-			costR, mR, acR, rrR, ifR, dR, errR := calculateC10MForNode(itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errR
-			node.Acquisition = &BaseIngredientDetail{Quantity: quantityNeeded, Method: mR, BestCost: toJSONFloat64(valueOrNaN(costR)), AssociatedCost: toJSONFloat64(valueOrNaN(acR)), RR: toJSONFloat64(valueOrNaN(rrR)), IF: toJSONFloat64(valueOrNaN(ifR)), Delta: toJSONFloat64(valueOrNaN(dR))}
-			if errR != nil && node.Acquisition != nil {
-				node.Acquisition.Method = "ERROR (RecipeRead)"
-			} // Use errR
-			return node, nil
-		}
-		if err := json.Unmarshal(data, &itemData); err != nil {
-			node.IsBaseComponent = true
-			node.ErrorMessage = fmt.Sprintf("Error parsing recipe JSON for '%s': %v", itemNameNorm, err)
-			costP, mP, acP, rrP, ifP, dP, errP := calculateC10MForNode(itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errP
+			ne := newNodeError(RecipeParseFailed, fmt.Errorf("error parsing recipe JSON for '%s': %w", itemNameNorm, loadErr))
+			ne.File = filePath
+			node.Errors = append(node.Errors, ne)
+			costP, mP, acP, rrP, ifP, dP, attemptedP, errP := calculateC10MForNode(ctx, itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errP
 			node.Acquisition = &BaseIngredientDetail{Quantity: quantityNeeded, Method: mP, BestCost: toJSONFloat64(valueOrNaN(costP)), AssociatedCost: toJSONFloat64(valueOrNaN(acP)), RR: toJSONFloat64(valueOrNaN(rrP)), IF: toJSONFloat64(valueOrNaN(ifP)), Delta: toJSONFloat64(valueOrNaN(dP))}
+			node.AttemptedAcquisitions = attemptedP
 			if errP != nil && node.Acquisition != nil {
 				node.Acquisition.Method = "ERROR (RecipeParse)"
 			} // Use errP
 			return node, nil
 		}
-	} else if !os.IsNotExist(statErr) {
-		return nil, fmt.Errorf("RecursiveTree: error checking recipe file '%s': %w", filePath, statErr)
+		if errors.Is(loadErr, errItemFileReadFailed) {
+			node.IsBaseComponent = true
+			ne := newNodeError(RecipeReadFailed, fmt.Errorf("error reading recipe file '%s': %w", filePath, loadErr))
+			ne.File = filePath
+			node.Errors = append(node.Errors, ne)
+			costR, mR, acR, rrR, ifR, dR, attemptedR, errR := calculateC10MForNode(ctx, itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errR
+			node.Acquisition = &BaseIngredientDetail{Quantity: quantityNeeded, Method: mR, BestCost: toJSONFloat64(valueOrNaN(costR)), AssociatedCost: toJSONFloat64(valueOrNaN(acR)), RR: toJSONFloat64(valueOrNaN(rrR)), IF: toJSONFloat64(valueOrNaN(ifR)), Delta: toJSONFloat64(valueOrNaN(dR))}
+			node.AttemptedAcquisitions = attemptedR
+			if errR != nil && node.Acquisition != nil {
+				node.Acquisition.Method = "ERROR (RecipeRead)"
+			} // Use errR
+			return node, nil
+		}
+		return nil, fmt.Errorf("RecursiveTree: error checking recipe file '%s': %w", filePath, loadErr)
 	}
 
-	itemCostRaw, itemMethod, itemAssocCostRaw, itemRRRaw, itemIFRaw, itemDeltaRaw, itemErrC10M := calculateC10MForNode(itemNameNorm, quantityNeeded, apiResp, metricsMap)
+	itemCostRaw, itemMethod, itemAssocCostRaw, itemRRRaw, itemIFRaw, itemDeltaRaw, itemAttempted, itemErrC10M := calculateC10MForNode(ctx, itemNameNorm, quantityNeeded, apiResp, metricsMap)
 	shouldExpandThisItem := false
 	isApiNotFoundError := false
 	if itemErrC10M != nil && strings.Contains(itemErrC10M.Error(), "API data not found") {
 		isApiNotFoundError = true
 	}
+	c10mErrKind := C10MUnavailable
+	if isApiNotFoundError {
+		c10mErrKind = C10MAPINotFound
+	}
 
 	if isApiNotFoundError {
 		if recipeFileExists {
 			shouldExpandThisItem = true
-		} else {
-			if node.ErrorMessage == "" {
-				node.ErrorMessage = "Not on Bazaar and no recipe file"
-			}
+		} else if len(node.Errors) == 0 {
+			node.addErrorf(RecipeFileMissing, "Not on Bazaar and no recipe file")
 		}
 	} else if itemErrC10M == nil && (itemMethod == "Primary" || itemMethod == "N/A") {
 		if recipeFileExists {
 			shouldExpandThisItem = true
-		} else {
-			if node.ErrorMessage == "" {
-				node.ErrorMessage = "No recipe file to expand further"
-			}
+		} else if len(node.Errors) == 0 {
+			node.addErrorf(RecipeFileMissing, "No recipe file to expand further")
 		}
 	} else {
-		if itemErrC10M != nil && node.ErrorMessage == "" {
-			node.ErrorMessage = itemErrC10M.Error()
+		if itemErrC10M != nil && len(node.Errors) == 0 {
+			node.addError(c10mErrKind, itemErrC10M)
 		}
 	}
 
@@ -142,13 +878,12 @@ func expandItemRecursiveTree(
 			Quantity: quantityNeeded, Method: itemMethod, BestCost: toJSONFloat64(valueOrNaN(itemCostRaw)), AssociatedCost: toJSONFloat64(valueOrNaN(itemAssocCostRaw)),
 			RR: toJSONFloat64(valueOrNaN(itemRRRaw)), IF: toJSONFloat64(valueOrNaN(itemIFRaw)), Delta: toJSONFloat64(valueOrNaN(itemDeltaRaw)),
 		}
-		if itemErrC10M != nil && node.ErrorMessage == "" {
-			node.ErrorMessage = itemErrC10M.Error()
+		node.AttemptedAcquisitions = itemAttempted
+		if itemErrC10M != nil && len(node.Errors) == 0 {
+			node.addError(c10mErrKind, itemErrC10M)
 		}
-		if !recipeFileExists && node.ErrorMessage == "" {
-			node.ErrorMessage = "No recipe file"
-		} else if !recipeFileExists && !strings.Contains(node.ErrorMessage, "No recipe file") {
-			node.ErrorMessage += "; No recipe file"
+		if !recipeFileExists {
+			node.addErrorf(RecipeFileMissing, "No recipe file")
 		}
 		return node, nil
 	}
@@ -156,7 +891,150 @@ func expandItemRecursiveTree(
 	var chosenRecipeCells map[string]string
 	var craftedAmount float64 = 1.0
 	recipeContentExists := false
-	if len(itemData.Recipes) > 0 {
+	var alternativeRecipes []RecipeCostSummary
+	var preExpandedIngredients []*CraftingStepNode
+	var winnerTrialMemo *recipeMemo
+
+	if len(itemData.Recipes) > 1 {
+		// Multiple legitimate recipes (forge vs craft, alternate crafts):
+		// expand every variant and keep the cheapest by total C10M-weighted
+		// cost instead of unconditionally taking Recipes[0]. Each variant is
+		// expanded in its own isolated memo (evaluateRecipeVariant) so a
+		// rejected variant's trial cost doesn't pollute real ingredient
+		// demand; only the winner's trial memo gets merged into the shared
+		// one afterward.
+		type variantInput struct {
+			cells      map[string]string
+			count      float64
+			hasContent bool
+			rating     float64
+			source     string
+		}
+		variants := make([]variantInput, len(itemData.Recipes))
+		for i, r := range itemData.Recipes {
+			cells := map[string]string{"A1": r.A1, "A2": r.A2, "A3": r.A3, "B1": r.B1, "B2": r.B2, "B3": r.B3, "C1": r.C1, "C2": r.C2, "C3": r.C3}
+			hasContent := false
+			for _, v := range cells {
+				if v != "" {
+					hasContent = true
+					break
+				}
+			}
+			if hasContent && recipeTagExcluded(r.Tags) {
+				hasContent = false
+			}
+			count := 1.0
+			if r.Count > 0 {
+				count = float64(r.Count)
+			}
+			variants[i] = variantInput{cells: cells, count: count, hasContent: hasContent, rating: r.Rating, source: r.Source}
+		}
+
+		if ActiveRecipeSelectionPolicy == RecipeSelectionFirst {
+			// First skips evaluating alternatives entirely - same
+			// pre-chunk18-1 behavior of always taking Recipes[0], for a
+			// caller that doesn't want to pay for expanding variants it will
+			// never use.
+			if variants[0].hasContent {
+				recipeContentExists = true
+				chosenRecipeCells = variants[0].cells
+				craftedAmount = variants[0].count
+			}
+		} else {
+			type variantOutcome struct {
+				ingredients []*CraftingStepNode
+				memo        *recipeMemo
+				cost        float64
+				fillTime    float64
+				possible    bool
+				errMsg      string
+			}
+			outcomes := make([]variantOutcome, len(variants))
+			for i, v := range variants {
+				if !v.hasContent {
+					outcomes[i] = variantOutcome{cost: math.Inf(1), fillTime: math.Inf(1), possible: false, errMsg: "empty recipe cells"}
+					continue
+				}
+				ingredients, trialMemo, cost, fillTime, possible, errMsg := evaluateRecipeVariant(ctx, v.cells, v.count, quantityNeeded, currentPath, originalTopLevelItemID, currentDepth, apiResp, metricsMap, itemFilesDir, pool, itemData.Modules, itemData.SubRecipes)
+				outcomes[i] = variantOutcome{ingredients: ingredients, memo: trialMemo, cost: cost, fillTime: fillTime, possible: possible, errMsg: errMsg}
+			}
+
+			best := -1
+			for i, o := range outcomes {
+				if !o.possible {
+					continue
+				}
+				if best == -1 {
+					best = i
+					continue
+				}
+				switch ActiveRecipeSelectionPolicy {
+				case RecipeSelectionFastestFill:
+					if o.fillTime < outcomes[best].fillTime {
+						best = i
+					}
+				case RecipeSelectionHighestRated:
+					if variants[i].rating > variants[best].rating {
+						best = i
+					}
+				case RecipeSelectionPreferNPC:
+					bestIsNPC := strings.EqualFold(variants[best].source, "npc")
+					iIsNPC := strings.EqualFold(variants[i].source, "npc")
+					switch {
+					case iIsNPC && !bestIsNPC:
+						best = i
+					case iIsNPC == bestIsNPC && o.cost < outcomes[best].cost:
+						best = i
+					}
+				default:
+					if o.cost < outcomes[best].cost {
+						best = i
+					}
+				}
+			}
+
+			if ActiveRecipeSelector != nil {
+				var choices []RecipeChoice
+				var choiceOutcomeIdx []int
+				for i, o := range outcomes {
+					if !o.possible {
+						continue
+					}
+					var alts []AltCost
+					for j, o2 := range outcomes {
+						if j == i || !o2.possible {
+							continue
+						}
+						alts = append(alts, AltCost{Index: j, PerUnitCost: o2.cost / variants[j].count})
+					}
+					choices = append(choices, RecipeChoice{Index: i, PerUnitCost: o.cost / variants[i].count, Cells: variants[i].cells, Alternatives: alts})
+					choiceOutcomeIdx = append(choiceOutcomeIdx, i)
+				}
+				if len(choices) > 0 {
+					if sel := ActiveRecipeSelector(choices); sel >= 0 && sel < len(choices) {
+						best = choiceOutcomeIdx[sel]
+					}
+				}
+			}
+
+			for i, o := range outcomes {
+				if i == best {
+					continue
+				}
+				alternativeRecipes = append(alternativeRecipes, RecipeCostSummary{
+					RecipeIndex: i, Count: int(variants[i].count), TotalCost: o.cost, Possible: o.possible, ErrorMessage: o.errMsg,
+				})
+			}
+
+			if best >= 0 {
+				recipeContentExists = true
+				chosenRecipeCells = variants[best].cells
+				craftedAmount = variants[best].count
+				preExpandedIngredients = outcomes[best].ingredients
+				winnerTrialMemo = outcomes[best].memo
+			}
+		}
+	} else if len(itemData.Recipes) == 1 {
 		firstRecipe := itemData.Recipes[0]
 		tempCells := map[string]string{"A1": firstRecipe.A1, "A2": firstRecipe.A2, "A3": firstRecipe.A3, "B1": firstRecipe.B1, "B2": firstRecipe.B2, "B3": firstRecipe.B3, "C1": firstRecipe.C1, "C2": firstRecipe.C2, "C3": firstRecipe.C3}
 		for _, v := range tempCells {
@@ -182,94 +1060,198 @@ func expandItemRecursiveTree(
 
 	if !recipeContentExists {
 		node.IsBaseComponent = true
-		node.ErrorMessage = "No usable recipe content in file."
-		costN, mN, acN, rrN, ifN, dN, errN := calculateC10MForNode(itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errN
+		node.addErrorf(ZeroIngredients, "No usable recipe content in file.")
+		costN, mN, acN, rrN, ifN, dN, attemptedN, errN := calculateC10MForNode(ctx, itemNameNorm, quantityNeeded, apiResp, metricsMap) // Capture errN
 		node.Acquisition = &BaseIngredientDetail{Quantity: quantityNeeded, Method: mN, BestCost: toJSONFloat64(valueOrNaN(costN)), AssociatedCost: toJSONFloat64(valueOrNaN(acN)), RR: toJSONFloat64(valueOrNaN(rrN)), IF: toJSONFloat64(valueOrNaN(ifN)), Delta: toJSONFloat64(valueOrNaN(dN))}
+		node.AttemptedAcquisitions = attemptedN
 		if errN != nil && node.Acquisition != nil {
 			node.Acquisition.Method = "ERROR (NoRecipeContent)"
 		} // Use errN
 		return node, nil
 	}
+
+	if preExpandedIngredients != nil {
+		// The winning Recipes[] variant was already fully expanded above by
+		// evaluateRecipeVariant; reuse that subtree instead of doing the
+		// work twice, and fold its trial memo into the shared one.
+		node.QuantityPerCraft = craftedAmount
+		node.NumCrafts = math.Ceil(quantityNeeded / craftedAmount)
+		node.IsBaseComponent = false
+		node.AlternativeRecipes = alternativeRecipes
+		mergeMemo(memo, winnerTrialMemo)
+
+		maxChildSubTreeDepth := currentDepth
+		for _, subNode := range preExpandedIngredients {
+			node.Ingredients = append(node.Ingredients, subNode)
+			if subNode.MaxSubTreeDepth > maxChildSubTreeDepth {
+				maxChildSubTreeDepth = subNode.MaxSubTreeDepth
+			}
+		}
+		node.MaxSubTreeDepth = maxChildSubTreeDepth
+		return node, nil
+	}
+
 	node.QuantityPerCraft = craftedAmount
 	node.NumCrafts = math.Ceil(quantityNeeded / craftedAmount)
 	node.IsBaseComponent = false
-	ingredientsInOneCraft, aggErr := aggregateCells(chosenRecipeCells)
+	ingredientSpecs, aggErr := aggregateCells(ctx, chosenRecipeCells, itemData.Modules, itemData.SubRecipes)
 	if aggErr != nil { // CORRECTED: Check aggErr
-		node.ErrorMessage = fmt.Sprintf("Error parsing recipe cells for expansion: %v", aggErr)
+		node.addErrorf(AggregationFailed, "Error parsing recipe cells for expansion: %v", aggErr)
 		// Return node with error message, but also propagate error if it's critical for caller
 		return node, fmt.Errorf("failed parsing cells for %s: %w", itemNameNorm, aggErr)
 	}
+	ingredientsInOneCraft := resolveIngredientSpecs(ingredientSpecs, apiResp)
 	if len(ingredientsInOneCraft) == 0 {
-		node.ErrorMessage = "Recipe definition yields zero ingredients."
+		node.addErrorf(ZeroIngredients, "Recipe definition yields zero ingredients.")
 		return node, nil
 	}
 
-	maxChildSubTreeDepth := currentDepth
+	if allIngredientsIlliquid(ingredientsInOneCraft) {
+		// Every ingredient of this craft is below the liquidity floor
+		// (liquidity.go); recursing into them one by one would just run
+		// getBestC10M on each for no benefit, so price this node itself as a
+		// single base item instead, reusing the C10M already computed above.
+		node.IsBaseComponent = true
+		node.Acquisition = &BaseIngredientDetail{
+			Quantity: quantityNeeded, Method: "Illiquid",
+			BestCost: toJSONFloat64(valueOrNaN(itemCostRaw)), AssociatedCost: toJSONFloat64(valueOrNaN(itemAssocCostRaw)),
+			RR: toJSONFloat64(valueOrNaN(itemRRRaw)), IF: toJSONFloat64(valueOrNaN(itemIFRaw)), Delta: toJSONFloat64(valueOrNaN(itemDeltaRaw)),
+		}
+		if itemErrC10M != nil && len(node.Errors) == 0 {
+			node.addError(c10mErrKind, itemErrC10M)
+		}
+		return node, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		node.addErrorf(SubExpansionCritical, "expansion cancelled before any ingredient was priced: %v", err)
+		node.MaxSubTreeDepth = currentDepth
+		return node, err
+	}
+	tasks := make([]ingredientTask, 0, len(ingredientsInOneCraft))
 	for ingName, ingAmtPerCraft := range ingredientsInOneCraft {
-		totalIngAmtNeededForParent := ingAmtPerCraft * node.NumCrafts
+		totalIngAmtNeededForParent := snapQuantity(ingAmtPerCraft * node.NumCrafts)
 		if totalIngAmtNeededForParent <= 0 {
 			continue
 		}
-		subNode, errExpandSub := expandItemRecursiveTree(ingName, totalIngAmtNeededForParent, currentPath, originalTopLevelItemID, currentDepth+1, apiResp, metricsMap, itemFilesDir)
-		if errExpandSub != nil {
-			errorSubNode := &CraftingStepNode{
-				ItemName: BAZAAR_ID(ingName), QuantityNeeded: totalIngAmtNeededForParent, ErrorMessage: fmt.Sprintf("Sub-expansion failed critically: %v", errExpandSub),
-				IsBaseComponent: true, Depth: currentDepth + 1, MaxSubTreeDepth: currentDepth + 1,
-				Acquisition: &BaseIngredientDetail{Quantity: totalIngAmtNeededForParent, Method: "N/A (Sub-Expansion Critical Error)", BestCost: toJSONFloat64(math.NaN())},
-			}
-			node.Ingredients = append(node.Ingredients, errorSubNode)
-			if errorSubNode.MaxSubTreeDepth > maxChildSubTreeDepth {
-				maxChildSubTreeDepth = errorSubNode.MaxSubTreeDepth
-			}
-		} else if subNode != nil {
-			node.Ingredients = append(node.Ingredients, subNode)
-			if subNode.MaxSubTreeDepth > maxChildSubTreeDepth {
-				maxChildSubTreeDepth = subNode.MaxSubTreeDepth
-			}
+		tasks = append(tasks, ingredientTask{name: ingName, amount: totalIngAmtNeededForParent})
+	}
+
+	maxChildSubTreeDepth := currentDepth
+	for _, subNode := range expandIngredientsConcurrent(ctx, pool, tasks, currentPath, originalTopLevelItemID, currentDepth, apiResp, metricsMap, itemFilesDir, memo) {
+		node.Ingredients = append(node.Ingredients, subNode)
+		if subNode.MaxSubTreeDepth > maxChildSubTreeDepth {
+			maxChildSubTreeDepth = subNode.MaxSubTreeDepth
 		}
 	}
 	node.MaxSubTreeDepth = maxChildSubTreeDepth
 	return node, nil
 }
 
+// ExpandTreeOptions tunes one ExpandItemToTree call's use of the package's
+// shared concurrency/caching machinery (expandWorkerPool, recipeMemo,
+// TreeCache) without touching the package-wide defaults every other caller
+// relies on. The zero value reproduces today's behavior.
+type ExpandTreeOptions struct {
+	// MaxParallelism overrides ExpandWorkerPoolSize for this call's ingredient
+	// sub-expansion pool; <= 0 falls back to ExpandWorkerPoolSize, same as
+	// newExpandWorkerPool's own <= 0 handling. Use this when one caller (e.g.
+	// a batch job willing to saturate more cores, or a low-priority request
+	// that shouldn't) needs a different pool size than the process default
+	// without racing other concurrent ExpandItemToTree calls over a shared
+	// package var.
+	MaxParallelism int
+}
+
 func ExpandItemToTree(
+	ctx context.Context,
 	itemName string, quantity float64, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics, itemFilesDir string,
+	opts ...ExpandTreeOptions,
 ) (*CraftingStepNode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	itemNameNorm := BAZAAR_ID(itemName)
-	filePath := filepath.Join(itemFilesDir, itemNameNorm+".json")
+	filePath := recipeFilePath(itemFilesDir, itemNameNorm)
 	if _, statErr := os.Stat(filePath); os.IsNotExist(statErr) {
 		rootNode := &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Depth: 0, MaxSubTreeDepth: 0}
-		costR, mR, acR, rrR, ifR, dR, errC10M := calculateC10MForNode(itemNameNorm, quantity, apiResp, metricsMap) // Capture errC10M
+		costR, mR, acR, rrR, ifR, dR, attemptedR, errC10M := calculateC10MForNode(ctx, itemNameNorm, quantity, apiResp, metricsMap) // Capture errC10M
 		rootNode.Acquisition = &BaseIngredientDetail{
 			Quantity: quantity, Method: mR, BestCost: toJSONFloat64(valueOrNaN(costR)), AssociatedCost: toJSONFloat64(valueOrNaN(acR)),
 			RR: toJSONFloat64(valueOrNaN(rrR)), IF: toJSONFloat64(valueOrNaN(ifR)), Delta: toJSONFloat64(valueOrNaN(dR)),
 		}
+		rootNode.AttemptedAcquisitions = attemptedR
 		if errC10M != nil { // Use errC10M
 			rootNode.Acquisition.Method = "ERROR (NoRecipe)"
-			rootNode.ErrorMessage = fmt.Sprintf("No recipe file and C10M error: %v", errC10M)
+			rootNode.addErrorf(RecipeFileMissing, "No recipe file and C10M error: %v", errC10M)
 		} else if mR == "N/A" {
-			rootNode.ErrorMessage = "No recipe file and item acquisition is N/A via C10M."
+			rootNode.addErrorf(RecipeFileMissing, "No recipe file and item acquisition is N/A via C10M.")
 		}
 		return rootNode, nil
 	} else if statErr != nil {
 		return nil, fmt.Errorf("ExpandItemToTree: %w", statErr)
 	}
 
-	rootNode, errRec := expandItemRecursiveTree(itemNameNorm, quantity, nil, itemNameNorm, 0, apiResp, metricsMap, itemFilesDir)
+	bucket := quantityBucket(quantity)
+	if !TreeCacheDisabled {
+		if cache, err := DefaultTreeCache(); err == nil {
+			if !TreeCacheForceRebuild {
+				if entry, ok := cache.Load(itemNameNorm, bucket); ok && entry.fresh(ctx, itemFilesDir, apiResp, metricsMap) {
+					if clone, err := cloneNode(entry.Node); err == nil {
+						return clone, nil
+					}
+				}
+			}
+		} else {
+			dlog("ExpandItemToTree: tree cache unavailable, expanding uncached: %v", err)
+		}
+	}
+
+	// A batch caller (ExpandDualBasedBatch, expand_batch.go) stashes one
+	// recipeMemo in ctx via contextWithSharedRecipeMemo and reuses it across
+	// every item in the batch, so two top-level items whose recipes share a
+	// common sub-ingredient only expand that sub-ingredient once instead of
+	// once per top-level item. A plain caller gets the historical
+	// one-memo-per-call behavior.
+	memo, ok := sharedRecipeMemoFromContext(ctx)
+	if !ok {
+		memo = newRecipeMemo()
+	}
+	poolSize := ExpandWorkerPoolSize
+	if len(opts) > 0 && opts[0].MaxParallelism > 0 {
+		poolSize = opts[0].MaxParallelism
+	}
+	pool := newExpandWorkerPool(poolSize)
+	rootNode, errRec := expandItemRecursiveTree(ctx, itemNameNorm, quantity, nil, itemNameNorm, 0, apiResp, metricsMap, itemFilesDir, memo, pool)
 	if errRec != nil {
 		if rootNode == nil {
-			rootNode = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Depth: 0, MaxSubTreeDepth: 0, ErrorMessage: fmt.Sprintf("Recursive expansion failed critically: %v", errRec),
+			rootNode = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Depth: 0, MaxSubTreeDepth: 0,
 				Acquisition: &BaseIngredientDetail{Quantity: quantity, Method: "N/A (Critical Expansion Error)", BestCost: toJSONFloat64(math.NaN())},
 			}
+			rootNode.addErrorf(SubExpansionCritical, "Recursive expansion failed critically: %v", errRec)
 		} // ...
 		return rootNode, errRec
 	}
 	if rootNode == nil {
-		rootNode = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Depth: 0, MaxSubTreeDepth: 0, ErrorMessage: "Expansion resulted in nil node.",
+		rootNode = &CraftingStepNode{ItemName: itemNameNorm, QuantityNeeded: quantity, IsBaseComponent: true, Depth: 0, MaxSubTreeDepth: 0,
 			Acquisition: &BaseIngredientDetail{Quantity: quantity, Method: "N/A (Nil Node Error)", BestCost: toJSONFloat64(math.NaN())},
 		}
+		rootNode.addErrorf(SubExpansionCritical, "Expansion resulted in nil node.")
 		return rootNode, fmt.Errorf("nil node from expansion")
 	}
+
+	if !TreeCacheDisabled {
+		if cache, err := DefaultTreeCache(); err == nil {
+			files, metricsHash := subtreeFingerprints(ctx, rootNode, itemFilesDir, apiResp, metricsMap)
+			entry := &treeCacheEntry{
+				ItemID: itemNameNorm, QuantityBucket: bucket, Quantity: quantity,
+				RecipeFiles: files, MetricsHash: metricsHash, Node: rootNode,
+			}
+			if err := cache.Save(entry); err != nil {
+				dlog("ExpandItemToTree: failed to save tree cache entry for %s: %v", itemNameNorm, err)
+			}
+		}
+	}
 	return rootNode, nil
 }
 
@@ -280,6 +1262,14 @@ func extractBaseIngredientsFromTree(rootNode *CraftingStepNode) map[string]BaseI
 	}
 	var q []*CraftingStepNode
 	q = append(q, rootNode)
+	// Now load-bearing rather than defensive: expandItemRecursiveTree's
+	// recipeMemo means the same *CraftingStepNode can legitimately appear
+	// under more than one parent's Ingredients, and its QuantityNeeded/
+	// Acquisition already reflect the aggregate demand across every
+	// reference (rescaleMemoNode), so each shared node must be folded into
+	// baseMapDetails exactly once - visited here is what keeps a
+	// widely-shared node like ENCHANTED_COBBLESTONE from being summed once
+	// per parent that references it.
 	visited := make(map[*CraftingStepNode]bool)
 
 	for len(q) > 0 {
@@ -322,12 +1312,88 @@ func extractBaseIngredientsFromTree(rootNode *CraftingStepNode) map[string]BaseI
 	return baseMapDetails
 }
 
+// TreeAnalysisConfig controls the worker pool size and request rate
+// analyzeTreeForCostsAndTimes uses when fetching metrics and fill times for
+// a tree's base ingredients.
+type TreeAnalysisConfig struct {
+	// Workers is how many base ingredients are priced concurrently; <= 0
+	// defaults to runtime.NumCPU().
+	Workers int
+	// RequestsPerSecond caps the shared intervalRateLimiter every worker
+	// waits on before each safeGetMetricsData/calculateBuyOrderFillTime
+	// pair; <= 0 uses that limiter's own default.
+	RequestsPerSecond float64
+}
+
+// DefaultTreeAnalysisConfig is read by analyzeTreeForCostsAndTimes;
+// overridable before the first call, same pattern as ExpandWorkerPoolSize.
+var DefaultTreeAnalysisConfig = TreeAnalysisConfig{Workers: runtime.NumCPU(), RequestsPerSecond: 20}
+
+// baseIngredientAnalysis is one base ingredient's contribution to
+// analyzeTreeForCostsAndTimes's totals, computed by analyzeBaseIngredient so
+// the reducer can fold every ingredient's result in a fixed (sorted-ID)
+// order regardless of which worker finished first.
+type baseIngredientAnalysis struct {
+	itemID      string
+	method      string
+	costVal     float64
+	costValid   bool
+	fillTimeRaw float64
+	quantity    float64
+	errMsgs     []string
+}
+
+// analyzeBaseIngredient prices one base ingredient's already-known best cost
+// against a freshly fetched metrics snapshot and computes its Primary-method
+// fill time, mirroring the per-ingredient body of the old sequential
+// analyzeTreeForCostsAndTimes loop. It only records errMsgs/returns data for
+// the caller to fold in - BestCostMethodTotal is incremented by the caller's
+// single-threaded reduction step instead of here, since WithLabelValues+Inc
+// on a shared Counter isn't safe to call concurrently with differing labels.
+func analyzeBaseIngredient(ctx context.Context, itemID string, detail BaseIngredientDetail, metricsMap map[string]ProductMetrics) baseIngredientAnalysis {
+	result := baseIngredientAnalysis{itemID: itemID, method: detail.Method, quantity: detail.Quantity}
+
+	costVal := float64(detail.BestCost)
+	if math.IsNaN(costVal) || costVal < 0 {
+		result.errMsgs = append(result.errMsgs, fmt.Sprintf("Invalid cost for base '%s'", itemID))
+		DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("tree_analysis").Inc()
+	} else {
+		result.costVal = costVal
+		result.costValid = true
+	}
+
+	if detail.Method == "Primary" {
+		metricsData, metricsOk := safeGetMetricsData(metricsMap, itemID)
+		if metricsOk {
+			buyTime, _, buyErr := calculateBuyOrderFillTime(ctx, itemID, detail.Quantity, metricsData)
+			if buyErr == nil && !math.IsNaN(buyTime) && !math.IsInf(buyTime, 0) && buyTime >= 0 {
+				result.fillTimeRaw = buyTime
+			} else {
+				result.fillTimeRaw = math.Inf(1)
+				result.errMsgs = append(result.errMsgs, "fill time err for "+itemID)
+				DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("tree_analysis").Inc()
+			}
+		} else {
+			result.fillTimeRaw = math.Inf(1)
+			result.errMsgs = append(result.errMsgs, "metrics missing for "+itemID)
+			DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("tree_analysis").Inc()
+		}
+	}
+	return result
+}
+
 func analyzeTreeForCostsAndTimes(
+	ctx context.Context,
 	rootNode *CraftingStepNode, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	opts ExpansionOptions,
 ) (totalCost float64, slowestFillTimeSecs float64, slowestIngName string, slowestIngQty float64, isPossible bool, errorMsg string) {
 	if rootNode == nil {
 		return math.Inf(1), math.NaN(), "", 0.0, false, "Root node is nil"
 	}
+	if err := ctx.Err(); err != nil {
+		return math.Inf(1), math.NaN(), "", 0.0, false, err.Error()
+	}
 
 	if rootNode.IsBaseComponent {
 		if rootNode.Acquisition != nil {
@@ -337,7 +1403,7 @@ func analyzeTreeForCostsAndTimes(
 				if rootNode.Acquisition.Method == "Primary" {
 					metricsData, metricsOk := safeGetMetricsData(metricsMap, rootNode.ItemName)
 					if metricsOk {
-						calculatedTime, _, _ := calculateBuyOrderFillTime(rootNode.ItemName, rootNode.Acquisition.Quantity, metricsData)
+						calculatedTime, _, _ := calculateBuyOrderFillTime(ctx, rootNode.ItemName, rootNode.Acquisition.Quantity, metricsData)
 						if !math.IsNaN(calculatedTime) && !math.IsInf(calculatedTime, 0) && calculatedTime >= 0 {
 							fillTimeRaw = calculatedTime
 						} else {
@@ -347,7 +1413,7 @@ func analyzeTreeForCostsAndTimes(
 						fillTimeRaw = math.Inf(1)
 					}
 				}
-				return cost, valueOrNaN(fillTimeRaw), rootNode.ItemName, rootNode.Acquisition.Quantity, true, rootNode.ErrorMessage
+				return cost, valueOrNaN(fillTimeRaw), rootNode.ItemName, rootNode.Acquisition.Quantity, true, rootNode.ErrorMessage()
 			} else {
 				return math.Inf(1), math.NaN(), rootNode.ItemName, rootNode.QuantityNeeded, false, "Base item acquisition cost invalid/NaN"
 			}
@@ -356,71 +1422,413 @@ func analyzeTreeForCostsAndTimes(
 		}
 	}
 
+	if !ResultCacheDisabled {
+		if cached, ok := DefaultResultCache().Get(rootNode.ItemName, rootNode.QuantityNeeded, precision); ok {
+			return cached.TotalCost, cached.SlowestFillTimeSecs, cached.SlowestIngName, cached.SlowestIngQty, cached.IsPossible, cached.ErrorMsg
+		}
+	}
+
 	baseIngredientsMap := extractBaseIngredientsFromTree(rootNode)
 	if len(baseIngredientsMap) == 0 {
 		return math.Inf(1), math.NaN(), "", 0.0, false, "No base ingredients found"
 	}
+	DefaultMetrics(nil).BaseIngredientsMapSize.Observe(float64(len(baseIngredientsMap)))
+
+	// itemIDs is sorted up front so both job dispatch and the reduction below
+	// run in a fixed order: two runs over the same tree always attribute the
+	// "slowest ingredient" tie-break to the same itemID regardless of which
+	// worker goroutine actually finished first.
+	itemIDs := make([]string, 0, len(baseIngredientsMap))
+	for itemID := range baseIngredientsMap {
+		itemIDs = append(itemIDs, itemID)
+	}
+	sort.Strings(itemIDs)
+
+	config := opts.treeAnalysisConfig()
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(itemIDs) {
+		workers = len(itemIDs)
+	}
+	limiter := newIntervalRateLimiter(config.RequestsPerSecond)
+
+	results := make([]baseIngredientAnalysis, len(itemIDs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				itemID := itemIDs[idx]
+				DefaultMetrics(nil).IngredientsProcessedTotal.Inc()
+				limiter.Wait()
+				results[idx] = analyzeBaseIngredient(ctx, itemID, baseIngredientsMap[itemID], metricsMap)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for idx := range itemIDs {
+			select {
+			case jobs <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
 
 	currentTotalSumOfBestCosts := 0.0
+	costAccum := newCostAccumulator(precision)
 	currentSlowestTimeRaw := 0.0
 	currentIsPossible := true
-	// CORRECTED: Declare these variables outside the loop
 	var currentSlowestIngName string = ""
 	var currentSlowestIngQty float64 = 0.0
 	var errorMessages []string
 
-	for itemID, detail := range baseIngredientsMap {
-		costVal := float64(detail.BestCost)
-		if math.IsNaN(costVal) || costVal < 0 {
-			errorMessages = append(errorMessages, fmt.Sprintf("Invalid cost for base '%s'", itemID))
+	if err := ctx.Err(); err != nil {
+		errorMessages = append(errorMessages, fmt.Sprintf("analysis cancelled before pricing every base ingredient: %v", err))
+		currentIsPossible = false
+	}
+
+	for _, r := range results {
+		if r.itemID != "" {
+			DefaultMetrics(nil).BestCostMethodTotal.WithLabelValues(r.method).Inc()
+		}
+		if !r.costValid {
 			currentIsPossible = false
 			currentTotalSumOfBestCosts = math.Inf(1)
 		}
 		// This check should be outside the above if, to sum valid costs even if another ing is impossible
-		if !math.IsInf(currentTotalSumOfBestCosts, 1) && !math.IsNaN(costVal) && costVal >= 0 {
-			currentTotalSumOfBestCosts += costVal
+		if !math.IsInf(currentTotalSumOfBestCosts, 1) && r.costValid {
+			costAccum.Add(r.costVal)
+			currentTotalSumOfBestCosts = costAccum.Sum()
 		}
-
-		fillTimeForIngredientRaw := 0.0
-		if detail.Method == "Primary" {
-			metricsData, metricsOk := safeGetMetricsData(metricsMap, itemID)
-			if metricsOk {
-				buyTime, _, buyErr := calculateBuyOrderFillTime(itemID, detail.Quantity, metricsData)
-				if buyErr == nil && !math.IsNaN(buyTime) && !math.IsInf(buyTime, 0) && buyTime >= 0 {
-					fillTimeForIngredientRaw = buyTime
-				} else {
-					fillTimeForIngredientRaw = math.Inf(1)
-					errorMessages = append(errorMessages, "fill time err for "+itemID)
-					currentIsPossible = false
-				}
-			} else {
-				fillTimeForIngredientRaw = math.Inf(1)
-				errorMessages = append(errorMessages, "metrics missing for "+itemID)
-				currentIsPossible = false
-			}
+		if len(r.errMsgs) > 0 {
+			errorMessages = append(errorMessages, r.errMsgs...)
+			currentIsPossible = false
 		}
+
 		// Update slowest time logic
-		if math.IsInf(fillTimeForIngredientRaw, 1) { // If current ingredient's fill time is Inf
+		if math.IsInf(r.fillTimeRaw, 1) { // If current ingredient's fill time is Inf
 			if !math.IsInf(currentSlowestTimeRaw, 1) { // And overall slowest wasn't Inf yet
-				currentSlowestTimeRaw = fillTimeForIngredientRaw // Then overall becomes Inf
-				currentSlowestIngName = itemID                   // CORRECTED
-				currentSlowestIngQty = detail.Quantity           // CORRECTED
+				currentSlowestTimeRaw = r.fillTimeRaw // Then overall becomes Inf
+				currentSlowestIngName = r.itemID
+				currentSlowestIngQty = r.quantity
 			}
-		} else if !math.IsInf(currentSlowestTimeRaw, 1) && fillTimeForIngredientRaw > currentSlowestTimeRaw { // If neither is Inf and current is slower
-			currentSlowestTimeRaw = fillTimeForIngredientRaw
-			currentSlowestIngName = itemID         // CORRECTED
-			currentSlowestIngQty = detail.Quantity // CORRECTED
+		} else if !math.IsInf(currentSlowestTimeRaw, 1) && r.fillTimeRaw > currentSlowestTimeRaw { // If neither is Inf and current is slower
+			currentSlowestTimeRaw = r.fillTimeRaw
+			currentSlowestIngName = r.itemID
+			currentSlowestIngQty = r.quantity
 		}
 	}
 	finalErrorMsg := strings.Join(errorMessages, "; ")
-	if rootNode.ErrorMessage != "" {
+	if msg := rootNode.ErrorMessage(); msg != "" {
 		if finalErrorMsg == "" {
-			finalErrorMsg = "TreeRoot: " + rootNode.ErrorMessage
+			finalErrorMsg = "TreeRoot: " + msg
 		} else {
-			finalErrorMsg += "; TreeRoot: " + rootNode.ErrorMessage
+			finalErrorMsg += "; TreeRoot: " + msg
+		}
+	}
+
+	DefaultMetrics(nil).CostAnalysisOutcomesTotal.WithLabelValues("tree_analysis", strconv.FormatBool(currentIsPossible)).Inc()
+	if !math.IsInf(currentTotalSumOfBestCosts, 0) && !math.IsNaN(currentTotalSumOfBestCosts) {
+		DefaultMetrics(nil).TotalCostSummary.WithLabelValues("tree_analysis").Observe(currentTotalSumOfBestCosts)
+	}
+	if !math.IsInf(currentSlowestTimeRaw, 0) && !math.IsNaN(currentSlowestTimeRaw) {
+		DefaultMetrics(nil).SlowestFillTimeSummary.WithLabelValues("tree_analysis").Observe(currentSlowestTimeRaw)
+	}
+
+	finalSlowestFillTimeSecs := valueOrNaN(currentSlowestTimeRaw)
+	finalSlowestIngQty := sanitizeFloat(currentSlowestIngQty)
+	if !ResultCacheDisabled {
+		dependsOn := make(map[string]bool, len(baseIngredientsMap))
+		for itemID := range baseIngredientsMap {
+			dependsOn[itemID] = true
 		}
+		DefaultResultCache().Put(rootNode.ItemName, rootNode.QuantityNeeded, precision, TreeAnalysisResult{
+			TotalCost: currentTotalSumOfBestCosts, SlowestFillTimeSecs: finalSlowestFillTimeSecs,
+			SlowestIngName: currentSlowestIngName, SlowestIngQty: finalSlowestIngQty,
+			IsPossible: currentIsPossible, ErrorMsg: finalErrorMsg,
+		}, dependsOn)
 	}
 
 	// Return the values that were updated throughout the loop
-	return currentTotalSumOfBestCosts, valueOrNaN(currentSlowestTimeRaw), currentSlowestIngName, sanitizeFloat(currentSlowestIngQty), currentIsPossible, finalErrorMsg
+	return currentTotalSumOfBestCosts, finalSlowestFillTimeSecs, currentSlowestIngName, finalSlowestIngQty, currentIsPossible, finalErrorMsg
+}
+
+// WorldMetrics is one candidate world/datacenter a Primary-method base
+// ingredient could be sourced from: its own metrics snapshot, plus the
+// extra latency (seconds) stacked on top of its fill time when it isn't the
+// crafter's home world (World == "").
+type WorldMetrics struct {
+	World           string
+	Metrics         map[string]ProductMetrics
+	TransferLatency float64
+}
+
+// SourcingObjective is the axis analyzeBaseIngredientMultiWorld minimizes
+// when choosing among a SourcingPolicy's Worlds.
+type SourcingObjective int
+
+const (
+	ObjectiveCost SourcingObjective = iota
+	ObjectiveTime
+	ObjectiveCostPlusTime
+)
+
+// SourcingPolicy configures cross-world/datacenter sourcing for
+// Primary-method base ingredients, mirroring how evaluateRecipeVariant
+// evaluates every Recipes[] entry and keeps the cheapest: every Worlds[]
+// entry is priced and the one minimizing Objective (cost, time, or
+// cost + TimeWeight*time) is kept. Bazaar buy cost itself doesn't vary by
+// world in this model - it's already baked into detail.BestCost from the
+// shared apiResp snapshot - only the fill time each world's own order-book
+// metrics imply, plus TransferLatency, vary between Worlds[] entries.
+type SourcingPolicy struct {
+	Worlds     []WorldMetrics
+	Objective  SourcingObjective
+	TimeWeight float64
+}
+
+// sourcingScore is the value SourcingPolicy.Objective minimizes: cost alone,
+// fill time alone, or a weighted sum of both.
+func sourcingScore(objective SourcingObjective, cost, fillTimeRaw, timeWeight float64) float64 {
+	switch objective {
+	case ObjectiveTime:
+		return fillTimeRaw
+	case ObjectiveCostPlusTime:
+		return cost + timeWeight*fillTimeRaw
+	default:
+		return cost
+	}
+}
+
+// analyzeBaseIngredientMultiWorld is analyzeBaseIngredient's Primary-method
+// fill-time lookup, repeated once per policy.Worlds candidate with that
+// world's own metrics map and TransferLatency added, keeping whichever
+// minimizes policy.Objective. A non-Primary method, or a nil/empty policy,
+// falls back to analyzeBaseIngredient unchanged and reports no chosen world.
+func analyzeBaseIngredientMultiWorld(ctx context.Context, itemID string, detail BaseIngredientDetail, homeMetricsMap map[string]ProductMetrics, policy *SourcingPolicy) (baseIngredientAnalysis, string) {
+	if detail.Method != "Primary" || policy == nil || len(policy.Worlds) == 0 {
+		return analyzeBaseIngredient(ctx, itemID, detail, homeMetricsMap), ""
+	}
+
+	result := baseIngredientAnalysis{itemID: itemID, method: detail.Method, quantity: detail.Quantity}
+
+	costVal := float64(detail.BestCost)
+	if math.IsNaN(costVal) || costVal < 0 {
+		result.errMsgs = append(result.errMsgs, fmt.Sprintf("Invalid cost for base '%s'", itemID))
+		DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("tree_analysis").Inc()
+	} else {
+		result.costVal = costVal
+		result.costValid = true
+	}
+
+	bestWorld := ""
+	bestFillTimeRaw := math.Inf(1)
+	bestScore := math.Inf(1)
+	found := false
+	for _, w := range policy.Worlds {
+		fillTimeRaw := math.Inf(1)
+		if metricsData, ok := safeGetMetricsData(w.Metrics, itemID); ok {
+			if buyTime, _, buyErr := calculateBuyOrderFillTime(ctx, itemID, detail.Quantity, metricsData); buyErr == nil &&
+				!math.IsNaN(buyTime) && !math.IsInf(buyTime, 0) && buyTime >= 0 {
+				fillTimeRaw = buyTime + w.TransferLatency
+			}
+		}
+		score := sourcingScore(policy.Objective, costVal, fillTimeRaw, policy.TimeWeight)
+		if !found || score < bestScore {
+			found = true
+			bestScore = score
+			bestWorld = w.World
+			bestFillTimeRaw = fillTimeRaw
+		}
+	}
+
+	result.fillTimeRaw = bestFillTimeRaw
+	if math.IsInf(bestFillTimeRaw, 1) {
+		result.errMsgs = append(result.errMsgs, "no configured world could fill "+itemID)
+		DefaultMetrics(nil).CalculationWarningsTotal.WithLabelValues("tree_analysis").Inc()
+	}
+	return result, bestWorld
+}
+
+// AlternativeResult is analyzeTreeForCostsAndTimesMultiWorld's full result:
+// the same totals analyzeTreeForCostsAndTimes returns, plus which world each
+// Primary-method base ingredient was ultimately sourced from. This is a
+// struct rather than a further-widened return tuple, since
+// analyzeTreeForCostsAndTimes's six values are already at the point where
+// one more would make call sites unreadable.
+type AlternativeResult struct {
+	TotalCost           float64
+	SlowestFillTimeSecs float64
+	SlowestIngName      string
+	SlowestIngQty       float64
+	IsPossible          bool
+	ErrorMsg            string
+	ChosenWorlds        map[string]string // base ingredient itemID -> World (home world is "")
+}
+
+// analyzeTreeForCostsAndTimesMultiWorld is analyzeTreeForCostsAndTimes
+// extended with per-ingredient world selection: every Primary-method base
+// ingredient is priced against every policy.Worlds candidate via
+// analyzeBaseIngredientMultiWorld instead of just homeMetricsMap, and the
+// chosen world is recorded in AlternativeResult.ChosenWorlds. A nil policy
+// behaves exactly like the single-world analyzeTreeForCostsAndTimes. Unlike
+// analyzeTreeForCostsAndTimes, this does not consult or populate
+// ResultCache: the cache's key has no SourcingPolicy dimension, so sharing
+// it here would let a single-world caller and a multi-world caller
+// silently serve each other's stale results.
+func analyzeTreeForCostsAndTimesMultiWorld(
+	ctx context.Context,
+	rootNode *CraftingStepNode, apiResp *HypixelAPIResponse, homeMetricsMap map[string]ProductMetrics,
+	precision PrecisionMode, policy *SourcingPolicy,
+) AlternativeResult {
+	if rootNode == nil {
+		return AlternativeResult{TotalCost: math.Inf(1), SlowestFillTimeSecs: math.NaN(), IsPossible: false, ErrorMsg: "Root node is nil"}
+	}
+	if err := ctx.Err(); err != nil {
+		return AlternativeResult{TotalCost: math.Inf(1), SlowestFillTimeSecs: math.NaN(), IsPossible: false, ErrorMsg: err.Error()}
+	}
+
+	if rootNode.IsBaseComponent {
+		if rootNode.Acquisition == nil {
+			return AlternativeResult{TotalCost: math.Inf(1), SlowestFillTimeSecs: math.NaN(), SlowestIngName: rootNode.ItemName, SlowestIngQty: rootNode.QuantityNeeded, IsPossible: false, ErrorMsg: "Base item no acquisition details"}
+		}
+		r, world := analyzeBaseIngredientMultiWorld(ctx, rootNode.ItemName, *rootNode.Acquisition, homeMetricsMap, policy)
+		if !r.costValid {
+			return AlternativeResult{TotalCost: math.Inf(1), SlowestFillTimeSecs: math.NaN(), SlowestIngName: rootNode.ItemName, SlowestIngQty: rootNode.QuantityNeeded, IsPossible: false, ErrorMsg: "Base item acquisition cost invalid/NaN"}
+		}
+		chosenWorlds := map[string]string{}
+		if world != "" {
+			chosenWorlds[rootNode.ItemName] = world
+		}
+		return AlternativeResult{
+			TotalCost: r.costVal, SlowestFillTimeSecs: valueOrNaN(r.fillTimeRaw), SlowestIngName: rootNode.ItemName,
+			SlowestIngQty: rootNode.Acquisition.Quantity, IsPossible: true, ErrorMsg: rootNode.ErrorMessage(), ChosenWorlds: chosenWorlds,
+		}
+	}
+
+	baseIngredientsMap := extractBaseIngredientsFromTree(rootNode)
+	if len(baseIngredientsMap) == 0 {
+		return AlternativeResult{TotalCost: math.Inf(1), SlowestFillTimeSecs: math.NaN(), IsPossible: false, ErrorMsg: "No base ingredients found"}
+	}
+	DefaultMetrics(nil).BaseIngredientsMapSize.Observe(float64(len(baseIngredientsMap)))
+
+	itemIDs := make([]string, 0, len(baseIngredientsMap))
+	for itemID := range baseIngredientsMap {
+		itemIDs = append(itemIDs, itemID)
+	}
+	sort.Strings(itemIDs)
+
+	config := DefaultTreeAnalysisConfig
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(itemIDs) {
+		workers = len(itemIDs)
+	}
+	limiter := newIntervalRateLimiter(config.RequestsPerSecond)
+
+	results := make([]baseIngredientAnalysis, len(itemIDs))
+	chosenWorldsByIdx := make([]string, len(itemIDs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				itemID := itemIDs[idx]
+				DefaultMetrics(nil).IngredientsProcessedTotal.Inc()
+				limiter.Wait()
+				results[idx], chosenWorldsByIdx[idx] = analyzeBaseIngredientMultiWorld(ctx, itemID, baseIngredientsMap[itemID], homeMetricsMap, policy)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for idx := range itemIDs {
+			select {
+			case jobs <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	currentTotalSumOfBestCosts := 0.0
+	costAccum := newCostAccumulator(precision)
+	currentSlowestTimeRaw := 0.0
+	currentIsPossible := true
+	var currentSlowestIngName string = ""
+	var currentSlowestIngQty float64 = 0.0
+	var errorMessages []string
+	chosenWorlds := make(map[string]string)
+
+	if err := ctx.Err(); err != nil {
+		errorMessages = append(errorMessages, fmt.Sprintf("analysis cancelled before pricing every base ingredient: %v", err))
+		currentIsPossible = false
+	}
+
+	for i, r := range results {
+		if r.itemID != "" {
+			DefaultMetrics(nil).BestCostMethodTotal.WithLabelValues(r.method).Inc()
+			if chosenWorldsByIdx[i] != "" {
+				chosenWorlds[r.itemID] = chosenWorldsByIdx[i]
+			}
+		}
+		if !r.costValid {
+			currentIsPossible = false
+			currentTotalSumOfBestCosts = math.Inf(1)
+		}
+		if !math.IsInf(currentTotalSumOfBestCosts, 1) && r.costValid {
+			costAccum.Add(r.costVal)
+			currentTotalSumOfBestCosts = costAccum.Sum()
+		}
+		if len(r.errMsgs) > 0 {
+			errorMessages = append(errorMessages, r.errMsgs...)
+			currentIsPossible = false
+		}
+
+		if math.IsInf(r.fillTimeRaw, 1) {
+			if !math.IsInf(currentSlowestTimeRaw, 1) {
+				currentSlowestTimeRaw = r.fillTimeRaw
+				currentSlowestIngName = r.itemID
+				currentSlowestIngQty = r.quantity
+			}
+		} else if !math.IsInf(currentSlowestTimeRaw, 1) && r.fillTimeRaw > currentSlowestTimeRaw {
+			currentSlowestTimeRaw = r.fillTimeRaw
+			currentSlowestIngName = r.itemID
+			currentSlowestIngQty = r.quantity
+		}
+	}
+	finalErrorMsg := strings.Join(errorMessages, "; ")
+	if msg := rootNode.ErrorMessage(); msg != "" {
+		if finalErrorMsg == "" {
+			finalErrorMsg = "TreeRoot: " + msg
+		} else {
+			finalErrorMsg += "; TreeRoot: " + msg
+		}
+	}
+
+	DefaultMetrics(nil).CostAnalysisOutcomesTotal.WithLabelValues("tree_analysis_multiworld", strconv.FormatBool(currentIsPossible)).Inc()
+	if !math.IsInf(currentTotalSumOfBestCosts, 0) && !math.IsNaN(currentTotalSumOfBestCosts) {
+		DefaultMetrics(nil).TotalCostSummary.WithLabelValues("tree_analysis_multiworld").Observe(currentTotalSumOfBestCosts)
+	}
+	if !math.IsInf(currentSlowestTimeRaw, 0) && !math.IsNaN(currentSlowestTimeRaw) {
+		DefaultMetrics(nil).SlowestFillTimeSummary.WithLabelValues("tree_analysis_multiworld").Observe(currentSlowestTimeRaw)
+	}
+
+	return AlternativeResult{
+		TotalCost: currentTotalSumOfBestCosts, SlowestFillTimeSecs: valueOrNaN(currentSlowestTimeRaw),
+		SlowestIngName: currentSlowestIngName, SlowestIngQty: sanitizeFloat(currentSlowestIngQty),
+		IsPossible: currentIsPossible, ErrorMsg: finalErrorMsg, ChosenWorlds: chosenWorlds,
+	}
 }
@@ -0,0 +1,338 @@
+// tree_cache.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileFingerprint is one recipe file's identity at the moment a cached
+// subtree was built: mtime+size, the same cheap staleness check make uses
+// for its dependency graph ("IF A is needed & A is out-of-date & C makes A
+// THEN C needs to be done"). SHA256 is left empty unless
+// TreeCacheUseContentHash is set, since hashing every reached item's recipe
+// file on every freshness check is real I/O a deployment that's happy with
+// mtime+size shouldn't have to pay for.
+type fileFingerprint struct {
+	Path    string `json:"path"`
+	ModUnix int64  `json:"mod_unix"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// TreeCacheUseContentHash, when true, makes statFingerprint additionally
+// hash each recipe file's full content (sha256) rather than trusting
+// mtime+size alone - a stricter but slower freshness check for a
+// deployment whose filesystem/editor workflow can rewrite a file with its
+// mtime and size both unchanged (e.g. a same-second regeneration from a
+// template), the one case mtime+size can't distinguish from "unchanged".
+var TreeCacheUseContentHash = false
+
+// statFingerprint stats path and reports its current fingerprint. A missing
+// file fingerprints as the zero value with ok false, which never matches a
+// previously recorded non-zero fingerprint - deleting a recipe file is a
+// change just like editing one.
+func statFingerprint(path string) (fileFingerprint, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{}, false
+	}
+	fp := fileFingerprint{Path: path, ModUnix: info.ModTime().Unix(), Size: info.Size()}
+	if TreeCacheUseContentHash {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fileFingerprint{}, false
+		}
+		sum := sha256.Sum256(data)
+		fp.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return fp, true
+}
+
+// treeCacheEntryVersion is bumped whenever treeCacheEntry's shape changes in
+// a way transformToCurrent can't upgrade losslessly from the JSON alone (a
+// renamed/repurposed field, a changed key derivation). Entries written by an
+// older binary are migrated through transformToCurrent on Load rather than
+// treated as corrupt, so a deploy doesn't cold-start every item's cache.
+const treeCacheEntryVersion = 1
+
+// treeCacheEntry is one cached CraftingStepNode plus everything that has to
+// still match for it to be considered fresh: the recipe file of every item
+// reached while expanding it, and a hash of the bazaar/metrics data that fed
+// its C10M pricing.
+type treeCacheEntry struct {
+	Version        int               `json:"version"`
+	ItemID         string            `json:"item_id"`
+	QuantityBucket int64             `json:"quantity_bucket"`
+	Quantity       float64           `json:"quantity"`
+	RecipeFiles    []fileFingerprint `json:"recipe_files"`
+	MetricsHash    string            `json:"metrics_hash"`
+	Node           *CraftingStepNode `json:"node"`
+}
+
+// transformToCurrent migrates entry in place from whatever Version it was
+// written with up to treeCacheEntryVersion, returning false if the entry is
+// newer than this binary understands (written by a future version) and
+// should be discarded rather than guessed at. Unversioned entries (Version
+// 0, from before this field existed) are treated as version 1's shape, since
+// that's what they were written as.
+func transformToCurrent(entry *treeCacheEntry) bool {
+	if entry.Version == 0 {
+		entry.Version = 1
+	}
+	if entry.Version > treeCacheEntryVersion {
+		return false
+	}
+	// Future migrations add steps here, e.g.:
+	// if entry.Version == 1 { ...upgrade fields...; entry.Version = 2 }
+	return true
+}
+
+// quantityBucket groups quantities that would produce essentially the same
+// tree shape into one cache entry, rather than keying on the exact float
+// (which would make the cache miss on every call with a slightly different
+// requested quantity). Buckets grow exponentially - 1, 2-3, 4-7, 8-15, ... -
+// since C10M order-book pricing cares about order-of-magnitude demand, not
+// single-unit precision.
+func quantityBucket(q float64) int64 {
+	if q <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(math.Log2(q + 1)))
+}
+
+// subtreeFingerprints walks node and every descendant exactly once (deduping
+// shared DAG pointers, same hazard as sumIngredientsCost), collecting the
+// recipe-file fingerprint of every crafted (non-base) node and hashing a
+// freshly recomputed C10M cost for every base-component leaf. Calling this
+// against the live apiResp/metricsMap is what lets TreeCache tell "nothing
+// changed" apart from "a leaf's bazaar price moved" - recording stale
+// Acquisition values from the cached node itself would never detect a price
+// move, since the saved node's numbers never change.
+func subtreeFingerprints(ctx context.Context, node *CraftingStepNode, itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) ([]fileFingerprint, string) {
+	visited := make(map[*CraftingStepNode]bool)
+	var files []fileFingerprint
+	seenFiles := make(map[string]bool)
+	h := sha256.New()
+
+	var walk func(n *CraftingStepNode)
+	walk = func(n *CraftingStepNode) {
+		if n == nil || visited[n] {
+			return
+		}
+		visited[n] = true
+		if n.IsBaseComponent {
+			cost, method, _, _, _, _, _, _ := calculateC10MForNode(ctx, n.ItemName, n.QuantityNeeded, apiResp, metricsMap)
+			fmt.Fprintf(h, "%s|%s|%v\n", n.ItemName, method, cost)
+			return
+		}
+		path := recipeFilePath(itemFilesDir, n.ItemName)
+		if !seenFiles[path] {
+			seenFiles[path] = true
+			if fp, ok := statFingerprint(path); ok {
+				files = append(files, fp)
+			}
+		}
+		for _, child := range n.Ingredients {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return files, hex.EncodeToString(h.Sum(nil))
+}
+
+// TreeCache persists previously computed CraftingStepNode subtrees to disk,
+// keyed by (itemID, quantityBucket), so a routine Bazaar refresh that didn't
+// move a given item's price (or any of its ingredients') doesn't have to
+// re-walk and re-price that whole subtree again. Mirrors
+// PriceHistoryStore's dir-of-JSON-files persistence, but one file per cache
+// entry rather than an append-only log, since entries are wholesale
+// replaced, not accumulated.
+type TreeCache struct {
+	dir string
+
+	mu sync.Mutex
+}
+
+// treeCacheLockStaleAfter bounds how long a .lock file is honored before a
+// new process assumes its owner died without cleaning up (crash, kill -9)
+// and takes over, rather than leaving the cache permanently unusable.
+const treeCacheLockStaleAfter = 10 * time.Minute
+
+// acquireDirLock creates dir/.lock exclusively, recording the current
+// process's PID, so a second process pointed at the same cache directory
+// fails fast instead of interleaving writes with the first. A lock file
+// older than treeCacheLockStaleAfter is assumed to be left over from a
+// process that didn't exit cleanly and is taken over rather than honored
+// forever.
+func acquireDirLock(dir string) error {
+	lockPath := filepath.Join(dir, ".lock")
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if !os.IsExist(err) {
+			return fmt.Errorf("creating lock file %s: %w", lockPath, err)
+		}
+		info, statErr := os.Stat(lockPath)
+		if statErr == nil && time.Since(info.ModTime()) > treeCacheLockStaleAfter {
+			dlog("acquireDirLock: %s is older than %s, assuming its owner is gone and taking over", lockPath, treeCacheLockStaleAfter)
+			if rmErr := os.Remove(lockPath); rmErr != nil {
+				return fmt.Errorf("removing stale lock file %s: %w", lockPath, rmErr)
+			}
+			return acquireDirLock(dir)
+		}
+		return fmt.Errorf("tree cache dir %s is locked by another process (remove %s if that's not the case)", dir, lockPath)
+	}
+	defer f.Close()
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	return err
+}
+
+// NewTreeCache creates a cache rooted at dir (created if missing), taking an
+// exclusive lock on dir so a second process started against the same
+// directory can't interleave writes with this one.
+func NewTreeCache(dir string) (*TreeCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating tree cache dir %s: %w", dir, err)
+	}
+	if err := acquireDirLock(dir); err != nil {
+		return nil, err
+	}
+	return &TreeCache{dir: dir}, nil
+}
+
+func (c *TreeCache) path(itemID string, bucket int64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s.b%d.json", itemID, bucket))
+}
+
+// Load returns the cached entry for (itemID, bucket), if one exists on disk.
+// It does not check freshness - callers compare RecipeFiles/MetricsHash
+// against the current tree themselves, since only they know how to rebuild
+// the fingerprints for the item's current (would-be) expansion.
+func (c *TreeCache) Load(itemID string, bucket int64) (*treeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := os.ReadFile(c.path(itemID, bucket))
+	if err != nil {
+		return nil, false
+	}
+	var entry treeCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		dlog("TreeCache: discarding corrupt entry for %s (bucket %d): %v", itemID, bucket, err)
+		return nil, false
+	}
+	if !transformToCurrent(&entry) {
+		dlog("TreeCache: discarding entry for %s (bucket %d): version %d is newer than this binary's %d", itemID, bucket, entry.Version, treeCacheEntryVersion)
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Save persists entry, overwriting any previous entry for the same
+// (ItemID, QuantityBucket).
+func (c *TreeCache) Save(entry *treeCacheEntry) error {
+	entry.Version = treeCacheEntryVersion
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("TreeCache: marshal entry for %s: %w", entry.ItemID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.WriteFile(c.path(entry.ItemID, entry.QuantityBucket), data, 0o644); err != nil {
+		return fmt.Errorf("TreeCache: write entry for %s: %w", entry.ItemID, err)
+	}
+	return nil
+}
+
+// Invalidate removes every cached entry (any quantity bucket) for itemID,
+// for callers that know a specific item's recipe or pricing changed out of
+// band and want to force its next expansion to rebuild rather than waiting
+// for the fingerprint check to notice.
+func (c *TreeCache) Invalidate(itemID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("TreeCache: reading %s: %w", c.dir, err)
+	}
+	prefix := itemID + ".b"
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, entry.Name())); err != nil {
+			return fmt.Errorf("TreeCache: removing %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// fresh reports whether entry's recorded recipe-file fingerprints still
+// match what's on disk and its recorded MetricsHash still matches a live
+// recompute against the current apiResp/metricsMap, i.e. whether the cached
+// subtree can be reused as-is instead of being rebuilt.
+func (entry *treeCacheEntry) fresh(ctx context.Context, itemFilesDir string, apiResp *HypixelAPIResponse, metricsMap map[string]ProductMetrics) bool {
+	for _, want := range entry.RecipeFiles {
+		got, ok := statFingerprint(want.Path)
+		if !ok || got != want {
+			return false
+		}
+	}
+	_, liveHash := subtreeFingerprints(ctx, entry.Node, itemFilesDir, apiResp, metricsMap)
+	return liveHash == entry.MetricsHash
+}
+
+// cloneNode deep-copies a cached subtree via a JSON round trip before
+// handing it to a caller, so two callers that both hit the cache for the
+// same (itemID, bucket) don't end up mutating (e.g. via rescaleMemoNode on a
+// later sibling reference) the very same node pointer.
+func cloneNode(node *CraftingStepNode) (*CraftingStepNode, error) {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("cloneNode: marshal: %w", err)
+	}
+	var clone CraftingStepNode
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, fmt.Errorf("cloneNode: unmarshal: %w", err)
+	}
+	return &clone, nil
+}
+
+var (
+	defaultTreeCache     *TreeCache
+	defaultTreeCacheOnce sync.Once
+	defaultTreeCacheErr  error
+)
+
+// TreeCacheDir is where DefaultTreeCache roots its store, overridable before
+// the first call (matching PriceHistoryDir's pattern).
+var TreeCacheDir = "/tmp/metrics/tree_cache"
+
+// TreeCacheDisabled and TreeCacheForceRebuild are set from main's CLI flags
+// (--disable-tree-cache / --rebuild-tree-cache) before ExpandItemToTree is
+// first called; every other caller just reads them through
+// ExpandItemToTree, the same way SetBazaarCache's installer is meant to run
+// once at startup rather than be toggled mid-request.
+var (
+	TreeCacheDisabled     = false
+	TreeCacheForceRebuild = false
+)
+
+// DefaultTreeCache lazily constructs the package-wide cache rooted at
+// TreeCacheDir.
+func DefaultTreeCache() (*TreeCache, error) {
+	defaultTreeCacheOnce.Do(func() {
+		defaultTreeCache, defaultTreeCacheErr = NewTreeCache(TreeCacheDir)
+	})
+	return defaultTreeCache, defaultTreeCacheErr
+}
@@ -0,0 +1,147 @@
+// tree_path.go
+package main
+
+import "fmt"
+
+// TreePathElem is one step down a CraftingStepNode tree: IngredientIdx is the
+// index into the parent's Ingredients slice that was taken, and ItemName is
+// the name of the node that step arrives at - kept alongside the index so a
+// path printed for a user (or compared against a tree built from a different
+// expansion) is self-describing instead of a bare list of numbers.
+type TreePathElem struct {
+	ItemName      string
+	IngredientIdx int
+}
+
+// TreePath addresses one node in a CraftingStepNode tree, relative to the
+// root ExpandItemToTree returned: an empty TreePath is the root itself, and
+// each successive element descends one more Ingredients[IngredientIdx] step.
+// It exists so a caller can pin a decision on one specific base component
+// (e.g. force "buy from bazaar" on one of several ENCHANTED_REDSTONE nodes)
+// and re-run analyzeTreeForCostsAndTimes against the edited tree without
+// rebuilding it from disk.
+type TreePath []TreePathElem
+
+// String renders p as "root > ItemA[1] > ItemB[0]" for log lines and error
+// messages - not meant to round-trip back into a TreePath.
+func (p TreePath) String() string {
+	s := "root"
+	for _, e := range p {
+		s += fmt.Sprintf(" > %s[%d]", e.ItemName, e.IngredientIdx)
+	}
+	return s
+}
+
+// Walk visits n and every descendant in Ingredients, depth-first, calling fn
+// with each node's TreePath relative to n (empty for n itself). Walk stops
+// and returns fn's error as soon as one call returns non-nil - a descendant
+// that was already visited along another reference (a shared DAG node, see
+// SharedByCount) is visited again at its second path too, since Walk callers
+// generally want every address a node is reachable at, not just the first.
+func (n *CraftingStepNode) Walk(fn func(path TreePath, node *CraftingStepNode) error) error {
+	if n == nil {
+		return nil
+	}
+	return n.walk(nil, fn)
+}
+
+func (n *CraftingStepNode) walk(path TreePath, fn func(TreePath, *CraftingStepNode) error) error {
+	if err := fn(path, n); err != nil {
+		return err
+	}
+	for i, child := range n.Ingredients {
+		if child == nil {
+			continue
+		}
+		childPath := append(append(TreePath{}, path...), TreePathElem{ItemName: child.ItemName, IngredientIdx: i})
+		if err := child.walk(childPath, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup resolves path against n, following each step's IngredientIdx and
+// verifying its ItemName still matches - a path captured against one
+// expansion and replayed against a later one (prices changed, a recipe
+// variant flipped) fails the lookup instead of silently landing on the wrong
+// node. An empty path resolves to n itself.
+func (n *CraftingStepNode) Lookup(path TreePath) (*CraftingStepNode, bool) {
+	cur := n
+	for _, elem := range path {
+		if cur == nil || elem.IngredientIdx < 0 || elem.IngredientIdx >= len(cur.Ingredients) {
+			return nil, false
+		}
+		cur = cur.Ingredients[elem.IngredientIdx]
+		if cur == nil || cur.ItemName != elem.ItemName {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Replace swaps the node at path for replacement, mutating the tree in
+// place. The root (empty path) can't be replaced this way since there is no
+// parent slice to assign into - a caller wanting to pin the root's own
+// acquisition method should edit its Acquisition field directly instead.
+func (n *CraftingStepNode) Replace(path TreePath, replacement *CraftingStepNode) error {
+	if len(path) == 0 {
+		return fmt.Errorf("Replace: cannot replace the root node, path is empty")
+	}
+	parentPath, last := path[:len(path)-1], path[len(path)-1]
+	parent, ok := n.Lookup(parentPath)
+	if !ok {
+		return fmt.Errorf("Replace: path %s does not resolve against this tree", path)
+	}
+	if last.IngredientIdx < 0 || last.IngredientIdx >= len(parent.Ingredients) {
+		return fmt.Errorf("Replace: ingredient index %d out of range at %s", last.IngredientIdx, path)
+	}
+	if got := parent.Ingredients[last.IngredientIdx]; got == nil || got.ItemName != last.ItemName {
+		return fmt.Errorf("Replace: node at %s no longer matches %q", path, last.ItemName)
+	}
+	parent.Ingredients[last.IngredientIdx] = replacement
+	return nil
+}
+
+// Diff compares n against other - two expansions of the same top-level item,
+// typically taken at different times - and returns the TreePaths where they
+// differ, shallowest first. A structural difference (a missing ingredient, a
+// different ItemName at the same slot) stops that branch's comparison at the
+// path where it was detected, since there's nothing meaningful to compare
+// below a node that no longer corresponds to the same ingredient.
+func (n *CraftingStepNode) Diff(other *CraftingStepNode) []TreePath {
+	var diffs []TreePath
+	var walk func(path TreePath, a, b *CraftingStepNode)
+	walk = func(path TreePath, a, b *CraftingStepNode) {
+		if a == nil || b == nil {
+			if a != b {
+				diffs = append(diffs, path)
+			}
+			return
+		}
+		if a.ItemName != b.ItemName || a.IsBaseComponent != b.IsBaseComponent || len(a.Ingredients) != len(b.Ingredients) {
+			diffs = append(diffs, path)
+			return
+		}
+		if !acquisitionEqual(a.Acquisition, b.Acquisition) {
+			diffs = append(diffs, path)
+		}
+		for i := range a.Ingredients {
+			childPath := append(append(TreePath{}, path...), TreePathElem{ItemName: a.Ingredients[i].ItemName, IngredientIdx: i})
+			walk(childPath, a.Ingredients[i], b.Ingredients[i])
+		}
+	}
+	walk(nil, n, other)
+	return diffs
+}
+
+// acquisitionEqual compares the fields of a BaseIngredientDetail that
+// actually reflect a changed acquisition decision or price, ignoring
+// Confidence/MetricsAgeSeconds which drift between any two expansions even
+// when nothing a caller cares about changed.
+func acquisitionEqual(a, b *BaseIngredientDetail) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Quantity == b.Quantity && a.Method == b.Method && a.BestCost == b.BestCost
+}
@@ -0,0 +1,258 @@
+// trend_tracker.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// TrendTracker keeps a per-item EMA of mid-price (the average of the top
+// SellSummary/BuySummary ticks) and an EMA of its poll-over-poll delta, so
+// DriftScore can answer "has this item's price been trending up or down
+// lately" without re-reading PriceHistoryStore's on-disk samples - this is
+// meant to be cheap enough to update on every single API poll, not just the
+// 5-minute AveragedMetrics cadence PriceHistoryStore tracks.
+type TrendTracker struct {
+	mu       sync.RWMutex
+	alpha    float64
+	emaMid   map[string]float64
+	emaSlope map[string]float64
+}
+
+// NewTrendTracker creates a tracker with the given EMA smoothing factor;
+// alpha outside (0, 1] defaults to 0.2 (roughly a 9-poll half-life).
+func NewTrendTracker(alpha float64) *TrendTracker {
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	return &TrendTracker{
+		alpha:    alpha,
+		emaMid:   make(map[string]float64),
+		emaSlope: make(map[string]float64),
+	}
+}
+
+// Update folds one new mid-price sample for itemIDNorm into its EMA and the
+// EMA of its poll-over-poll delta. The first sample for an item only seeds
+// emaMid - there's no delta to track until a second sample arrives.
+func (t *TrendTracker) Update(itemIDNorm string, mid float64) {
+	if mid <= 0 || math.IsNaN(mid) || math.IsInf(mid, 0) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prevMid, hadMid := t.emaMid[itemIDNorm]
+	if !hadMid {
+		t.emaMid[itemIDNorm] = mid
+		return
+	}
+	delta := mid - prevMid
+	t.emaMid[itemIDNorm] = prevMid + t.alpha*delta
+
+	prevSlope, hadSlope := t.emaSlope[itemIDNorm]
+	if !hadSlope {
+		t.emaSlope[itemIDNorm] = delta
+		return
+	}
+	t.emaSlope[itemIDNorm] = prevSlope + t.alpha*(delta-prevSlope)
+}
+
+// UpdateFromSnapshot folds apiResp's current mid-price into every product it
+// carries - the usual per-poll entry point; see WatchTrendTracker for the
+// wiring into StartBackgroundRefresh's publish loop.
+func (t *TrendTracker) UpdateFromSnapshot(apiResp *HypixelAPIResponse) {
+	if apiResp == nil {
+		return
+	}
+	for itemID, product := range apiResp.Products {
+		if len(product.SellSummary) == 0 || len(product.BuySummary) == 0 {
+			continue
+		}
+		mid := (product.SellSummary[0].PricePerUnit + product.BuySummary[0].PricePerUnit) / 2
+		t.Update(BAZAAR_ID(itemID), mid)
+	}
+}
+
+// DriftScore returns itemID's current drift signal in [-1, 1]: the EMA'd
+// poll-over-poll price delta normalized by the EMA mid-price, then clamped
+// - positive means price has been trending up recently, negative down. ok
+// is false until at least two polls have been folded in for itemID.
+func (t *TrendTracker) DriftScore(itemID string) (score float64, ok bool) {
+	itemIDNorm := BAZAAR_ID(itemID)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	mid, hadMid := t.emaMid[itemIDNorm]
+	slope, hadSlope := t.emaSlope[itemIDNorm]
+	if !hadMid || !hadSlope || mid <= 0 {
+		return 0, false
+	}
+	score = slope / mid
+	if score > 1 {
+		score = 1
+	} else if score < -1 {
+		score = -1
+	}
+	return score, true
+}
+
+var (
+	defaultTrendTracker     *TrendTracker
+	defaultTrendTrackerOnce sync.Once
+)
+
+// DefaultTrendTracker lazily constructs the package-wide tracker, for
+// callers (getBestC10MWithDrift, WatchTrendTracker) that don't hold a
+// tracker reference of their own.
+func DefaultTrendTracker() *TrendTracker {
+	defaultTrendTrackerOnce.Do(func() {
+		defaultTrendTracker = NewTrendTracker(0.2)
+	})
+	return defaultTrendTracker
+}
+
+// WatchTrendTracker subscribes to StartBackgroundRefresh's published
+// snapshots (see Subscribe) and folds each into tracker, until ctx is
+// cancelled. Run it in its own goroutine once, the same way a dashboard or
+// recipe-expansion consumer would subscribe:
+//
+//	go WatchTrendTracker(ctx, DefaultTrendTracker())
+func WatchTrendTracker(ctx context.Context, tracker *TrendTracker) {
+	ch := Subscribe()
+	defer Unsubscribe(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case resp, ok := <-ch:
+			if !ok {
+				return
+			}
+			tracker.UpdateFromSnapshot(resp)
+		}
+	}
+}
+
+// DriftFilterPos/DriftFilterNeg are the thresholds getBestC10MWithDrift
+// compares a DriftScore against before applying any bias - a score inside
+// (DriftFilterNeg, DriftFilterPos) is treated as noise, not a real trend.
+const (
+	DriftFilterPos = 0.15
+	DriftFilterNeg = -0.15
+)
+
+// driftPenalty scales c10mPrim by how strongly price is trending up:
+// driftScore > DriftFilterPos means a buy order at sellP is unlikely to
+// fill before the market moves away, so Primary is penalized proportionally
+// to (driftScore - DriftFilterPos), capped at maxDriftPenaltyPct. A
+// negative, strongly-down drift gets no penalty at all - that's the
+// scenario Primary is already the right call for.
+const maxDriftPenaltyPct = 0.25
+
+func driftPenaltyMultiplier(driftScore float64) float64 {
+	if driftScore <= DriftFilterPos {
+		return 1.0
+	}
+	excess := driftScore - DriftFilterPos
+	span := 1.0 - DriftFilterPos
+	if span <= 0 {
+		return 1 + maxDriftPenaltyPct
+	}
+	return 1 + maxDriftPenaltyPct*math.Min(1, excess/span)
+}
+
+// getBestC10MWithDrift is getBestC10M plus a directional bias from
+// tracker's DriftScore: when price has been trending up strongly
+// (driftScore > DriftFilterPos), c10mPrim is penalized before the
+// Primary-vs-Secondary comparison, since a buy order placed at today's
+// sellP is unlikely to fill before the market has already moved past it.
+// A strongly negative drift applies no penalty - Primary, possibly
+// under-quoted further by the caller, is already favored in that regime.
+// This is a separate entry point rather than a change to getBestC10M's own
+// signature, for the same reason getBestC10MDepth is: getBestC10M already
+// has many callers relying on its exact return shape.
+func getBestC10MWithDrift(
+	ctx context.Context,
+	itemID string,
+	quantity float64,
+	apiResp *HypixelAPIResponse,
+	metricsMap map[string]ProductMetrics,
+	precision PrecisionMode,
+	tracker *TrendTracker,
+) (bestCost float64, bestMethod string, associatedCost float64, rrValue float64, ifValue float64, driftScore float64, err error) {
+
+	itemIDNorm := BAZAAR_ID(itemID)
+	driftScore, driftOk := tracker.DriftScore(itemIDNorm)
+	if !driftOk {
+		driftScore = 0
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return math.Inf(1), "N/A", math.NaN(), math.NaN(), math.NaN(), driftScore, ctxErr
+	}
+	if quantity <= 0 {
+		err = fmt.Errorf("quantity must be positive (got %.2f for %s)", quantity, itemIDNorm)
+		return 0, "N/A", 0, 0, 0, driftScore, err
+	}
+
+	productData, apiOk := safeGetProductData(apiResp, itemIDNorm)
+	metricsData, metricsOk := safeGetMetricsData(metricsMap, itemIDNorm)
+	if !apiOk {
+		return math.Inf(1), "N/A", math.NaN(), math.NaN(), math.NaN(), driftScore, fmt.Errorf("API data not found for %s", itemIDNorm)
+	}
+
+	var sellP, buyP float64 = math.NaN(), math.NaN()
+	if len(productData.SellSummary) > 0 {
+		sellP = productData.SellSummary[0].PricePerUnit
+	}
+	if len(productData.BuySummary) > 0 {
+		buyP = productData.BuySummary[0].PricePerUnit
+	}
+	if sellP <= 0 || buyP <= 0 || math.IsNaN(sellP) || math.IsNaN(buyP) || math.IsInf(sellP, 0) || math.IsInf(buyP, 0) {
+		return math.Inf(1), "N/A", math.NaN(), math.NaN(), math.NaN(), driftScore,
+			fmt.Errorf("invalid prices from API for %s (sP: %.2f, bP: %.2f)", itemIDNorm, sellP, buyP)
+	}
+
+	c10mSec := quantity * buyP
+	if !metricsOk {
+		if math.IsNaN(c10mSec) || c10mSec < 0 || math.IsInf(c10mSec, 0) {
+			return math.Inf(1), "N/A", math.NaN(), math.NaN(), math.NaN(), driftScore,
+				fmt.Errorf("metrics missing and secondary C10M failed for %s", itemIDNorm)
+		}
+		return c10mSec, "Secondary", c10mSec, math.NaN(), math.NaN(), driftScore,
+			fmt.Errorf("metrics not found for %s, only Secondary C10M available", itemIDNorm)
+	}
+
+	c10mPrim, _, calcIF, calcRR, _, _, calcErr := calculateC10MInternal(itemIDNorm, quantity, sellP, buyP, metricsData, nil)
+	if calcErr != nil {
+		err = calcErr
+	}
+	c10mPrim *= driftPenaltyMultiplier(driftScore)
+
+	validPrim := !math.IsInf(c10mPrim, 0) && !math.IsNaN(c10mPrim) && c10mPrim >= 0
+	validSec := !math.IsInf(c10mSec, 0) && !math.IsNaN(c10mSec) && c10mSec >= 0
+
+	switch {
+	case validPrim && validSec:
+		if costLessOrEqual(c10mPrim, c10mSec, precision) {
+			bestCost, bestMethod, associatedCost, rrValue, ifValue = c10mPrim, "Primary", quantity*sellP, calcRR, calcIF
+		} else {
+			bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+			rrValue, ifValue = math.NaN(), math.NaN()
+		}
+	case validPrim:
+		bestCost, bestMethod, associatedCost, rrValue, ifValue = c10mPrim, "Primary", quantity*sellP, calcRR, calcIF
+	case validSec:
+		bestCost, bestMethod, associatedCost = c10mSec, "Secondary", c10mSec
+		rrValue, ifValue = math.NaN(), math.NaN()
+	default:
+		bestCost, bestMethod, associatedCost, rrValue, ifValue = math.Inf(1), "N/A", math.NaN(), math.NaN(), math.NaN()
+		if err == nil {
+			err = fmt.Errorf("failed to determine any valid C10M for %s (both Primary/Secondary results invalid)", itemIDNorm)
+		}
+	}
+	return
+}
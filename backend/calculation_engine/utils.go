@@ -1,70 +1,86 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"math"
 	"os"
-	"os/exec"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 // ... (dlog, itemIDNormalizationMap, initializeNormalizationMap, NormalizeItemID, BAZAAR_ID remain) ...
 var isDebug = os.Getenv("DEBUG") == "1"
 
+// dlog is the package's original DEBUG=1-gated printf logger, kept as a
+// compatibility shim over the structured logger (logger.go) so its many
+// existing call sites didn't all need rewriting to pick up leveled,
+// LOG_FORMAT=json-aware output. New call sites with structured fields to
+// attach should call Debug/Info/Warn/Error/Trace directly instead.
 func dlog(format string, args ...interface{}) {
-	if isDebug {
-		log.Printf("DEBUG: "+format, args...)
+	if !isDebug {
+		return
 	}
+	structuredLogger.Debug(fmt.Sprintf(format, args...))
 }
 
-var itemIDNormalizationMap map[string]string
-var normalizeMapOnce sync.Once
+// --- Item ID normalization (itemIDNormalizationMap, NormalizeItemID, BAZAAR_ID) lives in normalization.go ---
 
-func initializeNormalizationMap() {
-	dlog("Initializing Item ID normalization map...")
-	itemIDNormalizationMap = map[string]string{
-		"LOG":        "OAK_LOG",
-		"LOG-1":      "SPRUCE_LOG",
-		"LOG-2":      "BIRCH_LOG",
-		"LOG-3":      "JUNGLE_LOG",
-		"LOG_2":      "ACACIA_LOG",
-		"LOG_2-0":    "ACACIA_LOG",
-		"LOG_2-1":    "DARK_OAK_LOG",
-		"WOOD":       "OAK_PLANKS",
-		"WOOD-1":     "SPRUCE_PLANKS",
-		"WOOD-2":     "BIRCH_PLANKS",
-		"WOOD-3":     "JUNGLE_PLANKS",
-		"WOOD-4":     "ACACIA_PLANKS",
-		"WOOD-5":     "DARK_OAK_PLANKS",
-		"INK_SACK":   "INK_SAC",
-		"INK_SACK-4": "LAPIS_LAZULI",
-		// Add many more as needed
-	}
-	dlog("Normalization map initialized with %d entries.", len(itemIDNormalizationMap))
-}
+// subRecipeAlias is the fixed (non-configurable) cell prefix that resolves
+// against an item's own SubRecipes rather than a declared Modules alias -
+// "sub:NAME[:AMOUNT]" inlines NAME's own ingredients into this cell instead
+// of naming a separately expandable item.
+const subRecipeAlias = "sub"
 
-func NormalizeItemID(id string) string {
-	standardID := strings.ToUpper(strings.TrimSpace(id))
-	normalizeMapOnce.Do(initializeNormalizationMap)
-	if normalized, ok := itemIDNormalizationMap[standardID]; ok {
-		return normalized
+// maxSubRecipeDepth bounds inline sub-recipe nesting (a sub-recipe's own
+// cells can reference further sub-recipes) so a misconfigured cycle between
+// two SubRecipes entries fails fast instead of recursing forever.
+const maxSubRecipeDepth = 8
+
+// specKey returns the map key aggregateCells stores an ingredient spec
+// under: the single resolved ID itself, or its sorted "ID_A|ID_B" alternates
+// group when the cell named more than one candidate - so two cells naming
+// the same alternate set aggregate into one spec either way.
+func specKey(normIDs []string) (key string, alternates []string) {
+	if len(normIDs) == 1 {
+		return normIDs[0], nil
 	}
-	return standardID
+	sorted := append([]string(nil), normIDs...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "|"), normIDs
 }
 
-func BAZAAR_ID(id string) string {
-	return NormalizeItemID(id)
+// aggregateCells reads recipe cells against the DSL parseCellDSL understands
+// ("ITEM_ID:AMOUNT", "ITEM_A|ITEM_B:AMOUNT" alternates, "+ATTR=VAL,..." NBT
+// qualifiers, "*YIELD" per-slot overrides) and returns a map of IngredientSpec
+// keyed by resolved ID for a single-candidate slot, or by its sorted
+// "ID_A|ID_B" alternates group otherwise - so two cells naming the same
+// alternate set still aggregate into one spec. Use resolveIngredientSpecs to
+// collapse the result back down to a plain ingredient-ID -> amount map.
+//
+// A cell prefixed with one of modules' keys ("alias:ITEM_ID") is resolved to
+// that module's directory, joined onto the item ID with "::" so downstream
+// consumers (recipeFilePath, cycle detection, recipeMemo keys) stay
+// self-contained without needing modules threaded through them too - see
+// splitModuleID. A cell prefixed "sub:NAME" instead inlines subRecipes[NAME]
+// into this spot by recursively aggregating its own cells and merging them,
+// scaled, into the result - see subRecipeAlias.
+func aggregateCells(ctx context.Context, cells map[string]string, modules map[string]string, subRecipes map[string]SingleRecipe) (map[string]*IngredientSpec, error) {
+	_, end := Span(ctx, "aggregateCells")
+	defer end()
+
+	specs := make(map[string]*IngredientSpec)
+	firstError := aggregateCellsInto(specs, cells, modules, subRecipes, 1.0, 0, nil)
+	return specs, firstError
 }
 
-// aggregateCells reads recipe cells ("ITEM_ID:AMOUNT" or "ITEM_ID")
-// and returns a map of NORMALIZED ingredient IDs to their total amounts per single craft.
-func aggregateCells(cells map[string]string) (map[string]float64, error) {
+// aggregateCellsInto does the real work for aggregateCells, parameterized by
+// scale (the outer multiplier a nested sub-recipe call applies to every
+// ingredient it contributes) and depth/visitedSubRecipes (sub-recipe
+// recursion guards, nil/0 at the top level).
+func aggregateCellsInto(specs map[string]*IngredientSpec, cells map[string]string, modules map[string]string, subRecipes map[string]SingleRecipe, scale float64, depth int, visitedSubRecipes map[string]bool) error {
 	positions := []string{"A1", "A2", "A3", "B1", "B2", "B3", "C1", "C2", "C3"}
-	ingredients := make(map[string]float64)
 	var firstError error
 
 	for _, pos := range positions {
@@ -73,21 +89,63 @@ func aggregateCells(cells map[string]string) (map[string]float64, error) {
 			continue
 		}
 
-		parts := strings.SplitN(cellContent, ":", 2)
-		ingID := BAZAAR_ID(strings.TrimSpace(parts[0]))
-		if ingID == "" {
+		if rest, ok := splitReservedAlias(cellContent, subRecipeAlias); ok {
+			if err := aggregateSubRecipeCell(specs, rest, pos, modules, subRecipes, scale, depth, visitedSubRecipes); err != nil {
+				dlog("WARN (aggregateCells): %v", err)
+				if firstError == nil {
+					firstError = err
+				}
+			}
+			continue
+		}
+
+		moduleDir := ""
+		cellBody := cellContent
+		if len(modules) > 0 {
+			if alias, rest, ok := splitCellAlias(cellContent); ok {
+				if dir, isModule := modules[alias]; isModule {
+					moduleDir = dir
+					cellBody = rest
+				}
+			}
+		}
+
+		rawIDs, amountStr, yield, attrs, parseErr := parseCellDSL(cellBody)
+		if parseErr != nil {
+			dlog("WARN (aggregateCells): %v", parseErr)
+			DefaultMetrics(nil).CellParseErrors.Inc()
+			DefaultMetrics(nil).CellParseErrorsByPosition.WithLabelValues(pos).Inc()
+			if firstError == nil {
+				firstError = parseErr
+			}
+			continue
+		}
+
+		normIDs := make([]string, 0, len(rawIDs))
+		for _, raw := range rawIDs {
+			id := BAZAAR_ID(raw)
+			if id == "" {
+				continue
+			}
+			if moduleDir != "" {
+				id = moduleDir + moduleIDSeparator + id
+			}
+			normIDs = append(normIDs, id)
+		}
+		if len(normIDs) == 0 {
 			dlog("WARN: Skipping empty ingredient ID in cell '%s': '%s'", pos, cellContent)
 			continue
 		}
 
 		amt := 1.0
-		if len(parts) == 2 {
-			amtStr := strings.TrimSpace(parts[1])
-			parsedAmt, err := strconv.ParseFloat(amtStr, 64)
+		if amountStr != "" {
+			parsedAmt, err := strconv.ParseFloat(strings.TrimSpace(amountStr), 64)
 			if err != nil || parsedAmt <= 0 || math.IsNaN(parsedAmt) || math.IsInf(parsedAmt, 0) {
-				errMsg := fmt.Sprintf("invalid amount '%s' for ingredient '%s' in cell '%s'", amtStr, ingID, pos)
+				errMsg := fmt.Sprintf("invalid amount '%s' for ingredient '%s' in cell '%s'", amountStr, strings.Join(normIDs, "|"), pos)
 				dlog("WARN (aggregateCells): %s. Using 1.0. Error: %v", errMsg, err)
 				amt = 1.0
+				DefaultMetrics(nil).CellParseErrors.Inc()
+				DefaultMetrics(nil).CellParseErrorsByPosition.WithLabelValues(pos).Inc()
 				if firstError == nil {
 					firstError = fmt.Errorf(errMsg)
 				}
@@ -95,13 +153,94 @@ func aggregateCells(cells map[string]string) (map[string]float64, error) {
 				amt = parsedAmt
 			}
 		}
-		ingredients[ingID] += amt
+		amt = amt / yield * scale
+
+		key, alternates := specKey(normIDs)
+		spec, ok := specs[key]
+		if !ok {
+			spec = &IngredientSpec{Attrs: attrs}
+			if len(alternates) > 0 {
+				spec.Alternates = alternates
+			} else {
+				spec.ItemID = normIDs[0]
+			}
+			specs[key] = spec
+		}
+		spec.Amount += amt
 	}
-	return ingredients, firstError
+	return firstError
+}
+
+// splitCellAlias splits a cell's raw content at its first colon, returning
+// the candidate alias and the remainder - callers decide whether the
+// candidate is actually a recognized alias (a Modules key, or subRecipeAlias).
+func splitCellAlias(cellContent string) (alias, rest string, ok bool) {
+	idx := strings.Index(cellContent, ":")
+	if idx == -1 {
+		return "", "", false
+	}
+	return cellContent[:idx], cellContent[idx+1:], true
+}
+
+// splitReservedAlias reports whether cellContent is prefixed with the given
+// reserved alias (subRecipeAlias), returning the remainder after its colon.
+func splitReservedAlias(cellContent, alias string) (body string, ok bool) {
+	prefix, rest, hasColon := splitCellAlias(cellContent)
+	if !hasColon || prefix != alias {
+		return "", false
+	}
+	return rest, true
+}
+
+// aggregateSubRecipeCell resolves one "sub:NAME[:AMOUNT]" cell by looking up
+// NAME in subRecipes and recursively aggregating its own cells, merging the
+// result into specs scaled by (AMOUNT/NAME's own Count) - i.e. however many
+// crafts of the sub-recipe this slot actually needs per craft of the outer
+// item - times scale (the enclosing call's own multiplier, for nested
+// sub-recipes).
+func aggregateSubRecipeCell(specs map[string]*IngredientSpec, rest, pos string, modules map[string]string, subRecipes map[string]SingleRecipe, scale float64, depth int, visited map[string]bool) error {
+	if depth >= maxSubRecipeDepth {
+		return fmt.Errorf("sub-recipe nesting too deep (>= %d) at cell '%s'", maxSubRecipeDepth, pos)
+	}
+	rawIDs, amountStr, yield, _, parseErr := parseCellDSL(rest)
+	if parseErr != nil {
+		return fmt.Errorf("malformed sub-recipe reference in cell '%s': %w", pos, parseErr)
+	}
+	if len(rawIDs) != 1 {
+		return fmt.Errorf("sub-recipe reference in cell '%s' must name exactly one sub_recipes entry, got %q", pos, rest)
+	}
+	name := rawIDs[0]
+	if visited[name] {
+		return fmt.Errorf("cyclic sub_recipes reference to '%s' at cell '%s'", name, pos)
+	}
+	sub, exists := subRecipes[name]
+	if !exists {
+		return fmt.Errorf("sub_recipes entry '%s' referenced by cell '%s' not found", name, pos)
+	}
+
+	amt := 1.0
+	if amountStr != "" {
+		if parsedAmt, err := strconv.ParseFloat(strings.TrimSpace(amountStr), 64); err == nil && parsedAmt > 0 && !math.IsNaN(parsedAmt) && !math.IsInf(parsedAmt, 0) {
+			amt = parsedAmt
+		}
+	}
+	craftedAmount := 1.0
+	if sub.Count > 0 {
+		craftedAmount = float64(sub.Count)
+	}
+
+	nextVisited := make(map[string]bool, len(visited)+1)
+	for k := range visited {
+		nextVisited[k] = true
+	}
+	nextVisited[name] = true
+
+	return aggregateCellsInto(specs, cellsOf(sub), modules, subRecipes, scale*amt/yield/craftedAmount, depth+1, nextVisited)
 }
 
 // isInPath checks if a NORMALIZED item name is already in the current expansion path.
-// Path stores ItemSteps {name, quantity}
+// Path stores ItemSteps {name, quantity}. Callers always pass the resolved
+// concrete ID (resolveIngredientSpecs' output), never an alternates group key.
 func isInPath(itemName string, path []ItemStep) bool {
 	normalizedItemName := BAZAAR_ID(itemName) // Ensure comparison is with normalized ID
 	for _, step := range path {
@@ -193,24 +332,30 @@ func safeGetMetricsData(metricsMap map[string]ProductMetrics, productID string)
 func getSellPrice(apiResp *HypixelAPIResponse, itemIDNorm string) float64 {
 	prod, ok := safeGetProductData(apiResp, itemIDNorm)
 	if !ok || len(prod.SellSummary) == 0 {
+		DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("sell").Set(1)
 		return 0.0
 	}
 	price := prod.SellSummary[0].PricePerUnit
 	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("sell").Set(1)
 		return 0.0
 	}
+	DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("sell").Set(0)
 	return price
 }
 
 func getBuyPrice(apiResp *HypixelAPIResponse, itemIDNorm string) float64 {
 	prod, ok := safeGetProductData(apiResp, itemIDNorm)
 	if !ok || len(prod.BuySummary) == 0 {
+		DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("buy").Set(1)
 		return 0.0
 	}
 	price := prod.BuySummary[0].PricePerUnit
 	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("buy").Set(1)
 		return 0.0
 	}
+	DefaultMetrics(nil).ZeroPriceLookups.WithLabelValues("buy").Set(0)
 	return price
 }
 
@@ -219,24 +364,6 @@ func getMetrics(metricsMap map[string]ProductMetrics, itemIDNorm string) Product
 	return metrics
 }
 
-// --- Console Clear (clear, init, clearConsole remain) ---
-var clear map[string]func()
-
-func init() { // init is called automatically
-	clear = make(map[string]func())
-	clear["linux"] = func() { cmd := exec.Command("clear"); cmd.Stdout = os.Stdout; _ = cmd.Run() }
-	clear["darwin"] = clear["linux"]
-	clear["windows"] = func() { cmd := exec.Command("cmd", "/c", "cls"); cmd.Stdout = os.Stdout; _ = cmd.Run() }
-}
-func clearConsole() {
-	value, ok := clear[runtime.GOOS]
-	if ok {
-		value()
-	} else {
-		log.Println("Warning: Console clear not supported on OS:", runtime.GOOS)
-	}
-}
-
 // --- Comparison Helper (mapsAreEqual remains) ---
 func mapsAreEqual(map1, map2 map[string]float64) bool {
 	if len(map1) != len(map2) {
@@ -0,0 +1,336 @@
+// wizserver.go
+package main
+
+// This is the /items, /items/{id}, /status HTTP surface the backlog
+// describes as a separate "cmd/wizserver + httpapi" service. This repo has
+// no go.mod anywhere, so there's no module path for an httpapi package to
+// live behind or be imported from a cmd/ binary - every HTTP handler here
+// (dashboard.go, api.go, expand_job.go, ...) is already registered directly
+// by this one package-main binary's startWebServer, and bazaar-backend is
+// this repo's only precedent for "a separate server binary", itself just
+// another freestanding package main. So this lives here, alongside
+// dashboard.go, as the same kind of handler set with a different (JSON or
+// HTML) view and a different sort-key vocabulary.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wizItemsRanker is the ProfitRanker backing every /items view. It's kept
+// separate from dashboard.go's dashboardRanker (rather than shared) so a
+// change to one view's refresh cadence or filter never perturbs the other's
+// RankDelta bookkeeping.
+var wizItemsRanker = NewProfitRanker(defaultItemFilesDir, MetricMargin, 1, RankFilter{})
+
+// wizSortMetric scores one ItemRank for the /items ?sort= query param.
+type wizSortMetric func(r ItemRank) float64
+
+// wizSortMetrics are /items' user-selectable sort metrics: absolute margin,
+// profit per hour (margin amortized over the slowest ingredient's fill
+// time), ROI (margin relative to the capital a craft ties up), and the raw
+// slowest-fill-time itself for a client that wants to find quick turns
+// rather than big margins.
+var wizSortMetrics = map[string]wizSortMetric{
+	"margin": func(r ItemRank) float64 { return r.Profit },
+	"profitPerHour": func(r ItemRank) float64 {
+		if r.SlowestFillTimeSecs <= 0 {
+			return r.Profit * 3600 // instantly fillable craft: don't divide by zero, just report the margin as its own hourly rate
+		}
+		return r.Profit / (r.SlowestFillTimeSecs / 3600)
+	},
+	"roi": func(r ItemRank) float64 {
+		if r.Capital <= 0 {
+			return 0
+		}
+		return r.Profit / r.Capital
+	},
+	"slowestFillTime": func(r ItemRank) float64 { return r.SlowestFillTimeSecs },
+}
+
+// defaultWizItemsLimit and maxWizItemsLimit bound /items' ?limit= query
+// param, matching requestTimeout's def/max clamp style in dashboard.go.
+const (
+	defaultWizItemsLimit = 100
+	maxWizItemsLimit     = 1000
+)
+
+// wizLastSortTime is when /items last re-sorted wizItemsRanker.Current(),
+// reported by /status alongside PriceUpdateStatus's refresh timing -
+// gilgetter's lastSortTime, since sorting here happens per-request rather
+// than on the ProfitRanker's own refresh cycle.
+var (
+	wizLastSortMu   sync.RWMutex
+	wizLastSortTime time.Time
+)
+
+func markWizSort() {
+	wizLastSortMu.Lock()
+	wizLastSortTime = time.Now()
+	wizLastSortMu.Unlock()
+}
+
+func getWizLastSortTime() time.Time {
+	wizLastSortMu.RLock()
+	defer wizLastSortMu.RUnlock()
+	return wizLastSortTime
+}
+
+// wizTemplates holds /items and /status's HTML views, parsed once at
+// startup like dashboardTemplates. html/template (not the plain
+// text/template gilgetter itself uses) since every other HTML view in this
+// package escapes ItemID/ErrorMessage the same way.
+var wizTemplates = template.Must(template.New("wiz").Parse(wizTemplateSource))
+
+const wizTemplateSource = `
+{{define "items"}}
+<!DOCTYPE html>
+<html><head><title>Profitability Leaderboard</title></head><body>
+<h1>Profitability Leaderboard (sort: {{.Sort}})</h1>
+<table border="1"><tr><th>Rank</th><th>Item</th><th>Margin</th><th>ROI</th><th>Slowest Fill (s)</th></tr>
+{{range .Items}}<tr><td>{{.Rank}}</td><td>{{.ItemID}}</td><td>{{printf "%.2f" .Profit}}</td><td>{{printf "%.4f" .Capital}}</td><td>{{printf "%.2f" .SlowestFillTimeSecs}}</td></tr>{{end}}
+</table>
+</body></html>
+{{end}}
+
+{{define "status"}}
+<!DOCTYPE html>
+<html><head><title>wizserver Status</title></head><body>
+<h1>wizserver Status</h1>
+<p>Refresh progress: {{.RefreshProgressPct}}%</p>
+<p>Last full refresh: {{.LastFullRefresh}}</p>
+<p>Last sort: {{.LastSort}}</p>
+<p>Items ranked: {{.RankedItemCount}}</p>
+</body></html>
+{{end}}
+`
+
+// wantsJSON reports whether r asked for the JSON view of an /items or
+// /status response (Accept header, or an explicit ?format=json override for
+// clients that can't set headers), HTML otherwise.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// wizItemsHandler serves GET /items?sort=profitPerHour&limit=100: the
+// current ranking re-sorted by the requested metric, HTML leaderboard by
+// default or a streamed JSON array when wantsJSON(r).
+func wizItemsHandler(w http.ResponseWriter, r *http.Request) {
+	items, sortKey, err := rankedWizItems(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeWizItems(w, r, sortKey, items)
+}
+
+// rankedWizItems applies an /items-style request's ?sort=/?limit= query
+// params to wizItemsRanker.Current(), returning the same []ItemRank both
+// wizItemsHandler and apiV1ItemsHandler (apiv1.go) serve - one as HTML or a
+// streamed JSON array, the other as the versioned REST surface's JSON body.
+func rankedWizItems(r *http.Request) ([]ItemRank, string, error) {
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = "profitPerHour"
+	}
+	scoreFn, ok := wizSortMetrics[sortKey]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown sort metric: %s", sortKey)
+	}
+
+	limit := defaultWizItemsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > maxWizItemsLimit {
+		limit = maxWizItemsLimit
+	}
+
+	items := wizItemsRanker.Current()
+	sort.SliceStable(items, func(i, j int) bool { return scoreFn(items[i]) > scoreFn(items[j]) })
+	markWizSort()
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items, sortKey, nil
+}
+
+// writeWizItems renders items (already filtered/sorted/limited by the
+// caller) as a streamed JSON array or, by default, the "items" HTML
+// template - shared by wizItemsHandler and opportunitiesHandler
+// (opportunities.go) so both endpoints' views stay in sync.
+func writeWizItems(w http.ResponseWriter, r *http.Request, sortKey string, items []ItemRank) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[\n"))
+		for i, item := range items {
+			if i > 0 {
+				w.Write([]byte(",\n"))
+			}
+			data, err := json.Marshal(item)
+			if err != nil {
+				log.Printf("writeWizItems: marshal %s: %v", item.ItemID, err)
+				continue
+			}
+			w.Write(data)
+		}
+		w.Write([]byte("\n]\n"))
+		return
+	}
+
+	data := struct {
+		Sort  string
+		Items []ItemRank
+	}{Sort: sortKey, Items: items}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := wizTemplates.ExecuteTemplate(w, "items", data); err != nil {
+		log.Printf("writeWizItems: template execute failed: %v", err)
+	}
+}
+
+// wizItemHandler serves GET /items/{id}: the full PerformDualExpansion
+// breakdown for one item (both the sell-side and craft-side perspectives,
+// including SlowestIngredientName/SlowestIngredientBuyTimeSeconds), the
+// same data itemDashboardHandler renders as HTML but streamed as JSON.
+func wizItemHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		m := DefaultMetrics(nil)
+		m.CalculateRequestsTotal.WithLabelValues(status).Inc()
+		m.CalculateLatencySeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	itemName := strings.TrimPrefix(r.URL.Path, "/items/")
+	if itemName == "" {
+		status = "error"
+		http.Error(w, "missing item id in path", http.StatusBadRequest)
+		return
+	}
+
+	qty := 1.0
+	if raw := r.URL.Query().Get("qty"); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			qty = parsed
+		}
+	}
+	precision := parsePrecisionMode(r.URL.Query().Get("precision"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r, defaultItemDashboardTimeout, maxItemDashboardTimeout))
+	defer cancel()
+	if r.URL.Query().Get("debug") == "1" {
+		ctx = contextWithDebugSink(ctx, newDebugSink())
+	}
+
+	apiResp, err := WaitForFreshData()
+	if err != nil && !errors.Is(err, ErrStale) {
+		status = "error"
+		http.Error(w, "bazaar data unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrStale) {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+	metricsMap, _ := getMetricsMapFromFile(defaultMetricsFilePath)
+	DefaultMetrics(nil).BazaarItemsTracked.Set(float64(len(metricsMap)))
+
+	dual, err := PerformDualExpansion(ctx, itemName, qty, apiResp, metricsMap, defaultItemFilesDir, true, precision, ExpansionOptions{})
+	if err != nil || dual == nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			status = "cancelled"
+		case errors.Is(err, context.DeadlineExceeded):
+			status = "timeout"
+		default:
+			status = "error"
+		}
+		writeExpansionTimeoutOrError(w, err)
+		return
+	}
+	if dual.PrimaryBased.RecipeTree != nil {
+		DefaultMetrics(nil).ExpansionDepth.Observe(float64(dual.PrimaryBased.RecipeTree.MaxSubTreeDepth))
+	}
+	if n := countIngredientCostErrors(dual); n > 0 {
+		m := DefaultMetrics(nil).IngredientCostErrorsTotal
+		for i := 0; i < n; i++ {
+			m.Inc()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dual); err != nil {
+		log.Printf("wizItemHandler: encode %s: %v", itemName, err)
+	}
+
+	// Record into the historical time series store and the active-items
+	// cardinality tracker after responding, per memstore.go's
+	// RecordCalculationResult doc comment - never delays the client that's
+	// already been served.
+	now := time.Now()
+	RecordCalculationResult(defaultMemStore, dual.ItemName, now, dual)
+	recordItemTouch(dual.ItemName, float64(dual.PrimaryBased.TopLevelCost), now)
+}
+
+// wizStatusHandler serves GET /status: refresh progress/timing (mirroring
+// PriceUpdateStatus's priceUpdateProgress/lastFullPriceUpdateTime) plus
+// /items' own lastSortTime, HTML by default or JSON when wantsJSON(r).
+func wizStatusHandler(w http.ResponseWriter, r *http.Request) {
+	_, lastFullRefresh, progressPct := PriceUpdateStatus()
+	writeWizStatus(w, r, progressPct, lastFullRefresh)
+}
+
+// writeWizStatus renders refresh progress/timing as JSON or the "status"
+// HTML template - shared by wizStatusHandler (bazaar refresh progress) and
+// opportunitiesStatusHandler (opportunities.go; wizItemsRanker's own scan
+// progress), which report on different underlying timers but the same
+// shape of progress/last-completed/last-sort/ranked-count fields.
+func writeWizStatus(w http.ResponseWriter, r *http.Request, progressPct int, lastFull time.Time) {
+	lastSort := getWizLastSortTime()
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			RefreshProgressPct int    `json:"refresh_progress_pct"`
+			LastFullRefresh    string `json:"last_full_refresh"`
+			LastSort           string `json:"last_sort"`
+			RankedItemCount    int    `json:"ranked_item_count"`
+		}{
+			RefreshProgressPct: progressPct,
+			LastFullRefresh:    formatTimeOrNever(lastFull),
+			LastSort:           formatTimeOrNever(lastSort),
+			RankedItemCount:    len(wizItemsRanker.Current()),
+		})
+		return
+	}
+
+	data := struct {
+		RefreshProgressPct int
+		LastFullRefresh    string
+		LastSort           string
+		RankedItemCount    int
+	}{
+		RefreshProgressPct: progressPct,
+		LastFullRefresh:    formatTimeOrNever(lastFull),
+		LastSort:           formatTimeOrNever(lastSort),
+		RankedItemCount:    len(wizItemsRanker.Current()),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := wizTemplates.ExecuteTemplate(w, "status", data); err != nil {
+		log.Printf("writeWizStatus: template execute failed: %v", err)
+	}
+}
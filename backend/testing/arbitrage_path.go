@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// minSpreadRatio is the minimum cumulative profit ratio (cumulative forward
+// rate across a cycle) findArbitragePaths requires before reporting a path -
+// anything below it isn't worth the fill-time risk of chaining that many
+// crafts together.
+const minSpreadRatio = 1.001
+
+// PathRank is one ranked multi-hop craft-arbitrage cycle: a chain of item
+// IDs, each one's sell output feeding the next hop's recipe as an
+// ingredient, looping back to the item the chain started from.
+type PathRank struct {
+	Path             []string  // item IDs in hop order, starting and ending on the same item
+	HopQuantities    []float64 // per hop, units of path[i] consumed per craft of path[i+1]
+	CumulativeRatio  float64   // product of every hop's forward rate
+	ExpectedProfit   float64   // CumulativeRatio - 1.0
+	WorstHopFillTime float64   // slowest single hop's computeFillTimeSub, in seconds
+}
+
+// recipeGraphEntry is one craftable item's flattened per-craft ingredient
+// requirement, built once by buildRecipeGraph and reused across every
+// candidate path the search walks.
+type recipeGraphEntry struct {
+	Ingredients map[string]float64 // per one craft of this item
+}
+
+// buildRecipeGraph scans itemFilesDir for every item with recipe cells and
+// flattens its ingredient requirements, reusing loadItem/getRecipeCells/
+// aggregateCells so the graph matches exactly what expandItemConcurrent
+// would expand.
+func buildRecipeGraph(itemFilesDir string) (map[string]recipeGraphEntry, error) {
+	entries, err := os.ReadDir(itemFilesDir)
+	if err != nil {
+		return nil, err
+	}
+	graph := make(map[string]recipeGraphEntry)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		itemID := strings.TrimSuffix(entry.Name(), ".json")
+		item, err := loadItem(itemID)
+		if err != nil {
+			continue
+		}
+		cells := getRecipeCells(item)
+		if cells == nil {
+			continue
+		}
+		aggregated := aggregateCells(cells)
+		if len(aggregated) == 0 {
+			continue
+		}
+		ingredients := make(map[string]float64, len(aggregated))
+		for ing, amt := range aggregated {
+			ingredients[ing] = float64(amt)
+		}
+		graph[itemID] = recipeGraphEntry{Ingredients: ingredients}
+	}
+	return graph, nil
+}
+
+// invertGraph returns, for every ingredient ID, the item IDs whose recipe
+// consumes it - the "what can this feed into" edges a forward walk over
+// graph alone doesn't give directly.
+func invertGraph(graph map[string]recipeGraphEntry) map[string][]string {
+	consumers := make(map[string][]string)
+	for itemID, entry := range graph {
+		for ing := range entry.Ingredients {
+			consumers[ing] = append(consumers[ing], itemID)
+		}
+	}
+	return consumers
+}
+
+// forwardRate is mainSellPrice(itemID) / craftCost(itemID), priced the same
+// way printMultiAnalysis's primary expansion prices its base
+// ingredients (getPrice). > 1 means crafting itemID from its base
+// ingredients turns a profit before accounting for fill time.
+func forwardRate(itemID string, graph map[string]recipeGraphEntry) (rate, craftCost float64, ok bool) {
+	entry, exists := graph[itemID]
+	if !exists {
+		return 0, 0, false
+	}
+	for ing, amt := range entry.Ingredients {
+		craftCost += getPrice(ing) * amt
+	}
+	if craftCost <= 0 || math.IsInf(craftCost, 0) {
+		return 0, craftCost, false
+	}
+	sellPrice := getInstasellPrice(itemID)
+	if math.IsInf(sellPrice, 0) {
+		return 0, craftCost, false
+	}
+	return sellPrice / craftCost, craftCost, true
+}
+
+// worstHopFillTime is the slowest computeFillTimeSub across itemID's own
+// recipe ingredients - the fill-time signal findArbitragePaths tracks
+// alongside a path's cumulative ratio.
+func worstHopFillTime(itemID string, graph map[string]recipeGraphEntry) float64 {
+	entry, ok := graph[itemID]
+	if !ok {
+		return 0
+	}
+	worst := 0.0
+	for ing, amt := range entry.Ingredients {
+		if !inProductMetrics(ing) {
+			continue
+		}
+		if t := computeFillTimeSub(productMetricsMap[ing], 1, amt); t > worst {
+			worst = t
+		}
+	}
+	return worst
+}
+
+// hopQuantities reports, for each consecutive pair in path, how many units
+// of the earlier item one craft of the later item consumes.
+func hopQuantities(path []string, graph map[string]recipeGraphEntry) []float64 {
+	qtys := make([]float64, 0, len(path)-1)
+	for i := 0; i+1 < len(path); i++ {
+		qtys = append(qtys, graph[path[i+1]].Ingredients[path[i]])
+	}
+	return qtys
+}
+
+// findArbitragePaths searches for profitable 3-5 hop cycles: chains of
+// craftable items where each hop's own sell output is itself consumed by
+// the next hop's recipe, looping back to the item the chain started from.
+// Every candidate item's forward rate is priced concurrently, bounded by
+// the package-wide sem (working.go) - the same semaphore
+// expandItemConcurrent uses to cap outstanding goroutines - before the
+// (cheap, in-memory) cycle walk runs serially over the graph it built.
+func findArbitragePaths(itemFilesDir string, maxHops int) ([]PathRank, error) {
+	graph, err := buildRecipeGraph(itemFilesDir)
+	if err != nil {
+		return nil, err
+	}
+	consumers := invertGraph(graph)
+
+	type rated struct {
+		rate      float64
+		craftCost float64
+	}
+	rates := make(map[string]rated, len(graph))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for itemID := range graph {
+		itemID := itemID
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rate, craftCost, ok := forwardRate(itemID, graph)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			rates[itemID] = rated{rate: rate, craftCost: craftCost}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var results []PathRank
+	var walk func(start string, path []string, cumRatio, worstFill float64, visited map[string]bool)
+	walk = func(start string, path []string, cumRatio, worstFill float64, visited map[string]bool) {
+		if len(path) >= 3 && path[len(path)-1] == start && cumRatio >= minSpreadRatio {
+			results = append(results, PathRank{
+				Path:             append([]string{}, path...),
+				HopQuantities:    hopQuantities(path, graph),
+				CumulativeRatio:  cumRatio,
+				ExpectedProfit:   cumRatio - 1.0,
+				WorstHopFillTime: worstFill,
+			})
+		}
+		if len(path) >= maxHops {
+			return
+		}
+		last := path[len(path)-1]
+		for _, next := range consumers[last] {
+			if next != start && visited[next] {
+				continue
+			}
+			r, ok := rates[next]
+			if !ok {
+				continue
+			}
+			nextVisited := make(map[string]bool, len(visited)+1)
+			for k := range visited {
+				nextVisited[k] = true
+			}
+			nextVisited[next] = true
+			walk(start, append(append([]string{}, path...), next), cumRatio*r.rate, math.Max(worstFill, worstHopFillTime(next, graph)), nextVisited)
+		}
+	}
+
+	for itemID, r := range rates {
+		if r.rate <= 1.0 {
+			continue
+		}
+		walk(itemID, []string{itemID}, r.rate, worstHopFillTime(itemID, graph), map[string]bool{itemID: true})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ExpectedProfit > results[j].ExpectedProfit
+	})
+	return results, nil
+}
+
+// printArbitragePaths writes the top topN PathRank entries to stdout, one
+// line per cycle, in printMultiAnalysis's plain fmt.Printf report
+// style.
+func printArbitragePaths(paths []PathRank, topN int) {
+	if len(paths) == 0 {
+		fmt.Println("No profitable craft-arbitrage cycles found.")
+		return
+	}
+	if topN > len(paths) {
+		topN = len(paths)
+	}
+	fmt.Printf("Top %d craft-arbitrage cycles:\n", topN)
+	for i, p := range paths[:topN] {
+		fmt.Printf("%d. %s (ratio %.4f, profit %.4f, worst hop fill time %.2f sec)\n",
+			i+1, strings.Join(p.Path, " -> "), p.CumulativeRatio, p.ExpectedProfit, p.WorstHopFillTime)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,6 +16,35 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// Retry tuning for the coflnet history fetch below. A fixed cap replaces the
+// old unbounded "retry until success" loop, which re-hit the rate limiter
+// forever on a persistent 4xx (the product simply has no coflnet history)
+// instead of giving up.
+const (
+	coflnetMaxAttempts  = 5
+	coflnetBaseBackoff  = 500 * time.Millisecond
+	coflnetMaxBackoff   = 10 * time.Second
+)
+
+// coflnetRetryableStatus reports whether status is worth retrying: 429 and
+// 5xx are transient upstream conditions; anything else in the 4xx range
+// (400, 404, ...) means this product key will never succeed, so retrying
+// just burns the shared rate limiter's budget on every other goroutine.
+func coflnetRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// coflnetBackoff returns the delay before retry attempt n (1-indexed):
+// exponential growth off coflnetBaseBackoff, capped at coflnetMaxBackoff,
+// with full jitter so the 100 concurrent fetchers don't retry in lockstep.
+func coflnetBackoff(attempt int) time.Duration {
+	backoff := coflnetBaseBackoff * time.Duration(1<<uint(attempt-1))
+	if backoff > coflnetMaxBackoff {
+		backoff = coflnetMaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
 // HistoryEntry represents one entry in the historical data.
 type HistoryEntry struct {
 	MaxBuy         float64 `json:"maxBuy"`
@@ -85,24 +115,17 @@ func main() {
 	totalProducts := len(productKeys)
 	log.Printf("Fetched %d products from Hypixel API", totalProducts)
 
-	// === Step 2: Open JSON file for writing the array ===
+	// === Step 2: Open the output writer ===
+	// ArrayWriter (jsonstream.go) owns the file: it writes to a ".tmp"
+	// sibling and renames it into place on Close, so a crash mid-run can't
+	// leave a half-written array with a dangling comma or missing bracket
+	// the way the old hand-rolled "[", ",\n", "]" bookkeeping could.
 	outputFile := "avgPriceEngine_output.json"
-	// Truncate any existing file.
-	f, err := os.Create(outputFile)
+	arr, err := NewArrayWriter(outputFile, false)
 	if err != nil {
-		log.Fatalf("Error creating output file: %v", err)
-	}
-	defer f.Close()
-
-	// Write the opening bracket for the JSON array.
-	if _, err := f.Write([]byte("[\n")); err != nil {
-		log.Fatalf("Error writing opening bracket: %v", err)
+		log.Fatalf("Error creating output writer: %v", err)
 	}
 
-	// Mutex to protect file writes and the "first" flag.
-	var fileMutex sync.Mutex
-	first := true
-
 	// === Step 3: Concurrently fetch coflnet API history with retry logic ===
 	// Limit concurrency to 100.
 	var wg sync.WaitGroup
@@ -111,8 +134,10 @@ func main() {
 	// Rate limiter: allow up to 100 requests per minute.
 	limiter := rate.NewLimiter(rate.Every(time.Minute/100), 100)
 
-	// Counter for successful fetches.
-	var successCount int
+	// Counters for successful and permanently-skipped fetches, plus total
+	// latency across every attempt, for the summary logged after wg.Wait().
+	var successCount, skippedCount int
+	var totalLatency time.Duration
 	var successMutex sync.Mutex
 
 	for _, productKey := range productKeys {
@@ -124,105 +149,125 @@ func main() {
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			// Retry until the fetch is successful.
-			for {
+			encodedKey := url.PathEscape(productKey)
+			coflnetURL := fmt.Sprintf("https://sky.coflnet.com/api/bazaar/%s/history/week", encodedKey)
+
+			var body []byte
+			succeeded := false
+
+			for attempt := 1; attempt <= coflnetMaxAttempts; attempt++ {
 				// Respect the rate limiter on every attempt.
 				if err := limiter.Wait(context.Background()); err != nil {
 					log.Printf("Rate limiter error for %s: %v", productKey, err)
-					continue
+					return
 				}
 
-				// Build the coflnet API URL (URL-encoding the product key).
-				encodedKey := url.PathEscape(productKey)
-				coflnetURL := fmt.Sprintf("https://sky.coflnet.com/api/bazaar/%s/history/week", encodedKey)
-				log.Printf("Fetching data for %s from %s", productKey, coflnetURL)
-
+				log.Printf("Fetching data for %s from %s (attempt %d/%d)", productKey, coflnetURL, attempt, coflnetMaxAttempts)
+				start := time.Now()
 				coflnetResp, err := client.Get(coflnetURL)
+				latency := time.Since(start)
+				successMutex.Lock()
+				totalLatency += latency
+				successMutex.Unlock()
+
 				if err != nil {
 					log.Printf("Error fetching %s: %v", coflnetURL, err)
+					if attempt < coflnetMaxAttempts {
+						time.Sleep(coflnetBackoff(attempt))
+					}
 					continue
 				}
+
 				if coflnetResp.StatusCode != http.StatusOK {
-					log.Printf("Non-OK HTTP status for %s: %s", coflnetURL, coflnetResp.Status)
 					coflnetResp.Body.Close()
+					if !coflnetRetryableStatus(coflnetResp.StatusCode) {
+						log.Printf("Terminal HTTP status for %s: %s - giving up", coflnetURL, coflnetResp.Status)
+						break
+					}
+					log.Printf("Retryable HTTP status for %s: %s", coflnetURL, coflnetResp.Status)
+					if attempt < coflnetMaxAttempts {
+						time.Sleep(coflnetBackoff(attempt))
+					}
 					continue
 				}
 
-				body, err := ioutil.ReadAll(coflnetResp.Body)
+				respBody, err := ioutil.ReadAll(coflnetResp.Body)
 				coflnetResp.Body.Close()
 				if err != nil {
 					log.Printf("Error reading response body for %s: %v", coflnetURL, err)
+					if attempt < coflnetMaxAttempts {
+						time.Sleep(coflnetBackoff(attempt))
+					}
 					continue
 				}
-				if len(body) == 0 {
+				if len(respBody) == 0 {
 					log.Printf("Empty response for %s", coflnetURL)
+					if attempt < coflnetMaxAttempts {
+						time.Sleep(coflnetBackoff(attempt))
+					}
 					continue
 				}
 
-				var history []HistoryEntry
-				if err := json.Unmarshal(body, &history); err != nil {
-					log.Printf("Invalid JSON response for %s: %v", coflnetURL, err)
-					continue
-				}
+				body = respBody
+				succeeded = true
+				break
+			}
 
-				// Prepare the item history structure.
-				itemHistory := ItemHistory{
-					Item:    productKey,
-					History: history,
-				}
+			if !succeeded {
+				log.Printf("Giving up on %s after exhausting retries", productKey)
+				successMutex.Lock()
+				skippedCount++
+				successMutex.Unlock()
+				return
+			}
 
-				// Marshal the item into JSON.
-				data, err := json.MarshalIndent(itemHistory, "  ", "  ")
-				if err != nil {
-					log.Printf("Error marshalling item %s: %v", productKey, err)
-					continue
-				}
+			var history []HistoryEntry
+			if err := json.Unmarshal(body, &history); err != nil {
+				log.Printf("Invalid JSON response for %s: %v", coflnetURL, err)
+				successMutex.Lock()
+				skippedCount++
+				successMutex.Unlock()
+				return
+			}
 
-				// Append the JSON object to the file as part of the array.
-				fileMutex.Lock()
-				// If not the first item, add a comma separator.
-				if !first {
-					if _, err := f.Write([]byte(",\n")); err != nil {
-						log.Printf("Error writing comma for item %s: %v", productKey, err)
-						fileMutex.Unlock()
-						continue
-					}
-				} else {
-					first = false
-				}
-				// Write the JSON data.
-				if _, err := f.Write(data); err != nil {
-					log.Printf("Error writing item %s to output file: %v", productKey, err)
-					fileMutex.Unlock()
-					continue
-				}
-				// Flush to disk.
-				f.Sync()
-				fileMutex.Unlock()
+			// Prepare the item history structure.
+			itemHistory := ItemHistory{
+				Item:    productKey,
+				History: history,
+			}
 
-				// Increase the success counter.
+			// Append the item to the output array. ArrayWriter handles its
+			// own locking, so concurrent producers can call this directly.
+			if err := arr.Append(itemHistory); err != nil {
+				log.Printf("Error appending item %s to output file: %v", productKey, err)
 				successMutex.Lock()
-				successCount++
+				skippedCount++
 				successMutex.Unlock()
-
-				log.Printf("Successfully fetched and appended history for item: %s", productKey)
-				break // exit retry loop on success
+				return
 			}
+
+			// Increase the success counter.
+			successMutex.Lock()
+			successCount++
+			successMutex.Unlock()
+
+			log.Printf("Successfully fetched and appended history for item: %s", productKey)
 		}(productKey)
 	}
 
 	// Wait for all fetch goroutines to finish.
 	wg.Wait()
 
-	// === Step 4: Write the closing bracket to complete the JSON array ===
-	if _, err := f.Write([]byte("\n]")); err != nil {
-		log.Fatalf("Error writing closing bracket: %v", err)
+	// === Step 4: Close the array, atomically publishing the output file ===
+	if err := arr.Close(); err != nil {
+		log.Fatalf("Error closing output file: %v", err)
 	}
 
 	// Final check: Compare successful fetches with the total number of bazaar items.
 	if successCount != totalProducts {
-		log.Printf("Warning: Only %d successful fetches out of %d bazaar items", successCount, totalProducts)
+		log.Printf("Warning: %d successful fetches, %d permanently skipped, out of %d bazaar items (avg request latency %v)",
+			successCount, skippedCount, totalProducts, totalLatency/time.Duration(successCount+skippedCount+1))
 	} else {
-		log.Printf("Successfully fetched all %d bazaar items", totalProducts)
+		log.Printf("Successfully fetched all %d bazaar items (avg request latency %v)", totalProducts, totalLatency/time.Duration(totalProducts))
 	}
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// BacktestConfig is the `backtest` CLI subcommand's JSON input: a
+// directory of timestamped bazaar/auction snapshots to replay, the items
+// to track, and where to write the resulting CSV/PNG reports.
+type BacktestConfig struct {
+	SnapshotDir     string   `json:"snapshot_dir"`
+	Watchlist       []string `json:"watchlist"`
+	Quantity        float64  `json:"quantity"`
+	CSVPath         string   `json:"csv_path"`
+	GraphPNLPath    string   `json:"graph_pnl_path"`
+	GraphCumPNLPath string   `json:"graph_cum_pnl_path"`
+}
+
+// bazaarSnapshotFile is the on-disk schema for one replayed tick: the same
+// HypixelAPIResponse shape loadBazaarPrices fetches live, paired with a
+// moulberry.codes/lowestbin.json-shaped auction price map (loadAuctionPrices).
+// Name snapshot files so lexical order matches chronological order (e.g.
+// unix-timestamp filenames) - RunBacktest replays them in directory listing
+// order.
+type bazaarSnapshotFile struct {
+	Timestamp int64              `json:"timestamp"`
+	Bazaar    HypixelAPIResponse `json:"bazaar"`
+	Auction   map[string]float64 `json:"auction"`
+}
+
+// backtestRow is one (timestamp, item) sample of the dual analysis run
+// against that snapshot's prices.
+type backtestRow struct {
+	Timestamp int64
+	ItemID    string
+	SellRatio float64
+	Profit    float64
+	FillTime  float64
+}
+
+// parseBazaarResponse flattens a HypixelAPIResponse into the four price
+// maps loadBazaarPrices atomically swaps in, shared so applyBazaarSnapshot
+// can apply the same parsing to a historical snapshot instead of a live
+// fetch.
+func parseBazaarResponse(apiResp HypixelAPIResponse) (sell, buy, sellMoving, buyMoving map[string]float64) {
+	sell = make(map[string]float64, len(apiResp.Products))
+	buy = make(map[string]float64, len(apiResp.Products))
+	sellMoving = make(map[string]float64, len(apiResp.Products))
+	buyMoving = make(map[string]float64, len(apiResp.Products))
+	for productID, product := range apiResp.Products {
+		if len(product.SellSummary) > 0 {
+			sell[productID] = product.SellSummary[0].PricePerUnit
+		} else {
+			sell[productID] = math.Inf(1)
+		}
+		if len(product.BuySummary) > 0 {
+			buy[productID] = product.BuySummary[0].PricePerUnit
+		} else {
+			buy[productID] = math.Inf(1)
+		}
+		sm := product.SellMovingWeek
+		if sm == 0 {
+			sm = product.QuickStatus.SellMovingWeek
+		}
+		bm := product.BuyMovingWeek
+		if bm == 0 {
+			bm = product.QuickStatus.BuyMovingWeek
+		}
+		sellMoving[productID] = sm
+		buyMoving[productID] = bm
+	}
+	return
+}
+
+// applyBazaarSnapshot atomically swaps the live price maps to snap's
+// values, the same Store calls loadBazaarPrices makes after a live fetch,
+// so RunBacktest replays history through the exact same
+// getPrice/getBuyPrice/calcC10M code path main() uses live.
+func applyBazaarSnapshot(snap bazaarSnapshotFile) {
+	sell, buy, sellMoving, buyMoving := parseBazaarResponse(snap.Bazaar)
+	sellPriceBazaarMap.Store(&sell)
+	buyPriceBazaarMap.Store(&buy)
+	sellMovingWeekMap.Store(&sellMoving)
+	buyMovingWeekMap.Store(&buyMoving)
+	auctionPriceMap = snap.Auction
+}
+
+// RunBacktest replays every snapshot file in cfg.SnapshotDir against
+// cfg.Watchlist, recording each item's profit ratio/profit/fill time per
+// tick via computeDualAnalysis - the same computation the live CLI/service
+// report - then writes a CSV and, when GraphPNLPath/GraphCumPNLPath are
+// set, PNG line charts of profit and cumulative profit over time. Use the
+// resulting CSV to empirically tune calcC10M's idealFill formula and
+// expandItemConcurrent's secondary-pricing curve against real history.
+func RunBacktest(cfg BacktestConfig) error {
+	entries, err := os.ReadDir(cfg.SnapshotDir)
+	if err != nil {
+		return fmt.Errorf("reading snapshot dir '%s': %w", cfg.SnapshotDir, err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, e.Name())
+		}
+	}
+	sort.Strings(files)
+
+	var rows []backtestRow
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(cfg.SnapshotDir, name))
+		if err != nil {
+			return fmt.Errorf("reading snapshot '%s': %w", name, err)
+		}
+		var snap bazaarSnapshotFile
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("parsing snapshot '%s': %w", name, err)
+		}
+		applyBazaarSnapshot(snap)
+
+		for _, itemID := range cfg.Watchlist {
+			item, err := loadItem(itemID)
+			if err != nil {
+				continue
+			}
+			cells := getRecipeCells(item)
+			parent := calcC10M(itemID, cfg.Quantity, getInstasellPrice(itemID))
+
+			policy := InstasellPolicy{}
+			finalAgg := map[string]int{itemID: int(cfg.Quantity)}
+			if cells != nil && parent > 0 {
+				finalAgg = expandItemTopLevel(itemID, int(cfg.Quantity), parent, policy)
+			}
+			analysis := computeMultiAnalysis(itemID, cfg.Quantity, []PricingPolicy{policy}, map[string]map[string]int{policy.Name(): finalAgg})
+			rows = append(rows, backtestRow{
+				Timestamp: snap.Timestamp, ItemID: itemID,
+				SellRatio: analysis.Policies[0].Ratio, Profit: analysis.Policies[0].Profit,
+				FillTime: analysis.MainFillTime,
+			})
+		}
+	}
+
+	if cfg.CSVPath != "" {
+		if err := writeBacktestCSV(cfg.CSVPath, rows); err != nil {
+			return err
+		}
+	}
+	if cfg.GraphPNLPath != "" {
+		if err := graphPNLPath(cfg.GraphPNLPath, rows); err != nil {
+			return err
+		}
+	}
+	if cfg.GraphCumPNLPath != "" {
+		if err := graphCumPNLPath(cfg.GraphCumPNLPath, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBacktestCSV(path string, rows []backtestRow) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating csv '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+	if err := w.Write([]string{"timestamp", "item_id", "sell_ratio", "profit", "fill_time_seconds"}); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := w.Write([]string{
+			strconv.FormatInt(r.Timestamp, 10), r.ItemID,
+			strconv.FormatFloat(r.SellRatio, 'f', 4, 64),
+			strconv.FormatFloat(r.Profit, 'f', 2, 64),
+			strconv.FormatFloat(r.FillTime, 'f', 2, 64),
+		}); err != nil {
+			return err
+		}
+	}
+	return w.Error()
+}
+
+// graphPNLPath plots each watchlist item's profit over time to a PNG at
+// path, one line per item.
+func graphPNLPath(path string, rows []backtestRow) error {
+	return plotSeries(path, "Profit over time", "Profit", rows)
+}
+
+// graphCumPNLPath plots each watchlist item's cumulative profit over time
+// to a PNG at path.
+func graphCumPNLPath(path string, rows []backtestRow) error {
+	cumulative := make(map[string]float64, len(rows))
+	cumRows := make([]backtestRow, len(rows))
+	for i, r := range rows {
+		cumulative[r.ItemID] += r.Profit
+		cumRows[i] = r
+		cumRows[i].Profit = cumulative[r.ItemID]
+	}
+	return plotSeries(path, "Cumulative profit over time", "Cumulative Profit", cumRows)
+}
+
+// plotSeries is the shared gonum/plot line-chart builder behind
+// graphPNLPath/graphCumPNLPath, grouping rows by ItemID into one
+// plotter.Line per item.
+func plotSeries(path, title, yLabel string, rows []backtestRow) error {
+	byItem := make(map[string]plotter.XYs)
+	for _, r := range rows {
+		byItem[r.ItemID] = append(byItem[r.ItemID], plotter.XY{X: float64(r.Timestamp), Y: r.Profit})
+	}
+
+	p := plot.New()
+	p.Title.Text = title
+	p.X.Label.Text = "Timestamp"
+	p.Y.Label.Text = yLabel
+
+	items := make([]string, 0, len(byItem))
+	for itemID := range byItem {
+		items = append(items, itemID)
+	}
+	sort.Strings(items)
+
+	for _, itemID := range items {
+		line, err := plotter.NewLine(byItem[itemID])
+		if err != nil {
+			return fmt.Errorf("building line for '%s': %w", itemID, err)
+		}
+		p.Add(line)
+		p.Legend.Add(itemID, line)
+	}
+
+	return p.Save(10*vg.Inch, 6*vg.Inch, path)
+}
+
+// RunBacktestCLI implements the `backtest` CLI subcommand: it reads a
+// BacktestConfig from configPath and runs RunBacktest.
+func RunBacktestCLI(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("reading backtest config '%s': %w", configPath, err)
+	}
+	var cfg BacktestConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parsing backtest config '%s': %w", configPath, err)
+	}
+	return RunBacktest(cfg)
+}
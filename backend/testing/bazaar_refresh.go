@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// staleBazaarThreshold is how old a bazaar snapshot can get before
+// printMultiAnalysis flags it as stale instead of trusting it
+// outright - a long-running server can outlive several failed refresh
+// attempts in a row, and a silently stale price is worse than a visibly
+// stale one.
+const staleBazaarThreshold = 2 * time.Minute
+
+// bazaarRefreshInterval reads BAZAAR_REFRESH_INTERVAL_SECONDS (default 30),
+// the poll cadence RunBazaarRefresher re-fetches the Hypixel bazaar at.
+func bazaarRefreshInterval() time.Duration {
+	if raw := os.Getenv("BAZAAR_REFRESH_INTERVAL_SECONDS"); raw != "" {
+		if secs, err := strconv.Atoi(raw); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 30 * time.Second
+}
+
+// RunBazaarRefresher re-polls loadBazaarPrices every interval, swapping
+// sellPriceBazaarMap/buyPriceBazaarMap/sellMovingWeekMap/buyMovingWeekMap
+// atomically so getPrice/getBuyPrice stay lock-free readers instead of
+// blocking behind a mutex mid-refresh. A fetch error backs off
+// exponentially (capped at maxBazaarRefreshBackoff) and is logged rather
+// than log.Fatal-ing the process, since one transient network error
+// shouldn't take down a long-running analysis server - callers needing the
+// very first load to be fatal should call loadBazaarPrices directly before
+// starting this loop, the way main does. onUpdate, when non-nil, runs after
+// every successful refresh with the time it completed.
+func RunBazaarRefresher(ctx context.Context, interval time.Duration, onUpdate func(updatedAt time.Time)) {
+	const maxBazaarRefreshBackoff = 2 * time.Minute
+	backoff := time.Second
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadBazaarPrices(); err != nil {
+				log.Printf("bazaar refresh failed, retrying in %s: %v", backoff, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > maxBazaarRefreshBackoff {
+					backoff = maxBazaarRefreshBackoff
+				}
+				continue
+			}
+			backoff = time.Second
+			if onUpdate != nil {
+				onUpdate(time.Now())
+			}
+		}
+	}
+}
+
+// BazaarStaleness reports how long ago the bazaar price maps were last
+// refreshed. It returns +Inf before the first successful load.
+func BazaarStaleness() time.Duration {
+	last := bazaarLastUpdated.Load()
+	if last == nil {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Since(*last)
+}
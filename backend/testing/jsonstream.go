@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ArrayWriter replaces the hand-written "[", ",\n", "]" bookkeeping that
+// used to live in main() below: callers just Append values as they're
+// produced and Close() when done. It writes to a ".tmp" sibling of the
+// destination path and renames it into place on Close, so a crash or a
+// killed process mid-run leaves the previous output (or nothing) rather
+// than a half-written file with a dangling comma or missing bracket.
+//
+// Two output modes are supported, selected at construction:
+//   - JSON array (the historical avgPriceEngine_output.json shape): a
+//     single "[...]" document, one value per element.
+//   - NDJSON: one JSON value per line, no enclosing brackets or commas,
+//     so a consumer can stream-parse it without buffering the whole file.
+type ArrayWriter struct {
+	ndjson bool
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	tmpPath string
+	path    string
+	first   bool
+	closed  bool
+}
+
+// NewArrayWriter creates path+".tmp" and prepares it for Append calls. The
+// file at path itself is not touched until Close succeeds.
+func NewArrayWriter(path string, ndjson bool) (*ArrayWriter, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("jsonstream: create %s: %w", tmpPath, err)
+	}
+	w := &ArrayWriter{
+		ndjson:  ndjson,
+		f:       f,
+		w:       bufio.NewWriter(f),
+		tmpPath: tmpPath,
+		path:    path,
+		first:   true,
+	}
+	if !ndjson {
+		if _, err := w.w.WriteString("[\n"); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return nil, fmt.Errorf("jsonstream: write opening bracket: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Append marshals v and writes it as the next element. Safe for concurrent
+// use by multiple producer goroutines.
+func (w *ArrayWriter) Append(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("jsonstream: marshal: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("jsonstream: Append on closed ArrayWriter for %s", w.path)
+	}
+
+	if w.ndjson {
+		if _, err := w.w.Write(data); err != nil {
+			return fmt.Errorf("jsonstream: write: %w", err)
+		}
+		_, err = w.w.WriteString("\n")
+		return err
+	}
+
+	if !w.first {
+		if _, err := w.w.WriteString(",\n"); err != nil {
+			return fmt.Errorf("jsonstream: write separator: %w", err)
+		}
+	}
+	w.first = false
+	if _, err := w.w.Write(data); err != nil {
+		return fmt.Errorf("jsonstream: write: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the document (closing the array's bracket in JSON mode),
+// flushes and syncs the temp file, and atomically renames it over path. A
+// failure at any step leaves the temp file behind rather than a corrupt
+// destination, and path is left as whatever it was before Close.
+func (w *ArrayWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if !w.ndjson {
+		if _, err := w.w.WriteString("\n]"); err != nil {
+			w.f.Close()
+			return fmt.Errorf("jsonstream: write closing bracket: %w", err)
+		}
+	}
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("jsonstream: flush: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("jsonstream: sync: %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("jsonstream: close temp file: %w", err)
+	}
+	if err := os.Rename(w.tmpPath, w.path); err != nil {
+		return fmt.Errorf("jsonstream: rename %s to %s: %w", w.tmpPath, w.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheFilePath returns ~/.cache/wiz/cache.gob, creating the directory if
+// needed.
+func cacheFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolving home directory: %w", err)
+	}
+	dir := filepath.Join(home, ".cache", "wiz")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating cache dir '%s': %w", dir, err)
+	}
+	return filepath.Join(dir, "cache.gob"), nil
+}
+
+// itemCacheEntry is one on-disk loadItem memoization: the parsed Item plus
+// the source file's mtime, so a changed recipe file invalidates its entry
+// without needing an explicit cache-clear.
+type itemCacheEntry struct {
+	ModTime time.Time
+	Item    Item
+}
+
+// expansionCacheKey identifies one memoized top-level expandItemConcurrent
+// call. PriceMapHash ties the entry to the bazaar snapshot it was computed
+// against, so a price refresh invalidates it the same way a recipe file's
+// mtime invalidates an itemCacheEntry.
+type expansionCacheKey struct {
+	ItemName     string
+	Quantity     int
+	Policy       string
+	PriceMapHash uint64
+}
+
+type expansionCacheEntry struct {
+	StoredAt time.Time
+	Agg      map[string]int
+}
+
+// diskCacheSnapshot is the gob-encoded shape of a diskCache's persisted
+// state - just the two tables, none of diskCache's own bookkeeping fields.
+type diskCacheSnapshot struct {
+	Items      map[string]itemCacheEntry
+	Expansions map[expansionCacheKey]expansionCacheEntry
+}
+
+// diskCache is the on-disk persistence layer chunk13-7 asked for: a single
+// gob file under ~/.cache/wiz memoizing loadItem (by file path + mtime) and
+// expandItemConcurrent's top-level calls (by item/quantity/policy/
+// priceMapHash), so repeated CLI invocations against a stable bazaar
+// snapshot skip re-reading hundreds of recipe files and re-walking their
+// expansions. Guarded by a mutex since loadItem/expandItemTopLevel can both
+// be called from many goroutines.
+type diskCache struct {
+	mu    sync.Mutex
+	path  string
+	ttl   time.Duration
+	dirty bool
+	diskCacheSnapshot
+}
+
+// activeCache is nil when --no-cache is set or the cache directory can't be
+// created, in which case loadItem/expandItemTopLevel fall back to
+// process-lifetime-only caching (itemCache).
+var activeCache *diskCache
+
+// initDiskCache loads (or creates) the on-disk cache. ttl of zero means
+// expansion entries never expire on their own - they're still invalidated
+// per-entry by priceMapHash whenever the bazaar snapshot changes. Item
+// entries aren't subject to ttl at all; they're invalidated by mtime.
+func initDiskCache(ttl time.Duration) (*diskCache, error) {
+	path, err := cacheFilePath()
+	if err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		path: path,
+		ttl:  ttl,
+		diskCacheSnapshot: diskCacheSnapshot{
+			Items:      make(map[string]itemCacheEntry),
+			Expansions: make(map[expansionCacheKey]expansionCacheEntry),
+		},
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("opening cache file '%s': %w", path, err)
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&c.diskCacheSnapshot); err != nil {
+		log.Printf("disk cache at %s is unreadable, starting fresh: %v", path, err)
+		c.diskCacheSnapshot = diskCacheSnapshot{
+			Items:      make(map[string]itemCacheEntry),
+			Expansions: make(map[expansionCacheKey]expansionCacheEntry),
+		}
+	}
+	return c, nil
+}
+
+func (c *diskCache) getItem(itemName string, modTime time.Time) (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.Items[itemName]
+	if !ok || !entry.ModTime.Equal(modTime) {
+		return Item{}, false
+	}
+	return entry.Item, true
+}
+
+func (c *diskCache) putItem(itemName string, modTime time.Time, item Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Items[itemName] = itemCacheEntry{ModTime: modTime, Item: item}
+	c.dirty = true
+}
+
+func (c *diskCache) getExpansion(key expansionCacheKey) (map[string]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.Expansions[key]
+	if !ok {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(entry.StoredAt) > c.ttl {
+		delete(c.Expansions, key)
+		c.dirty = true
+		return nil, false
+	}
+	return entry.Agg, true
+}
+
+func (c *diskCache) putExpansion(key expansionCacheKey, agg map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Expansions[key] = expansionCacheEntry{StoredAt: time.Now(), Agg: agg}
+	c.dirty = true
+}
+
+// save persists the cache to disk if anything changed since the last save
+// (or load). Safe to call even when nothing was touched.
+func (c *diskCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	f, err := os.Create(c.path)
+	if err != nil {
+		return fmt.Errorf("creating cache file '%s': %w", c.path, err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(c.diskCacheSnapshot); err != nil {
+		return fmt.Errorf("writing cache file '%s': %w", c.path, err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// priceMapHash is an fnv64 over the sorted, current sell/buy bazaar price
+// maps, used to key expansionCacheKey so a bazaar refresh invalidates
+// expansion entries computed against stale prices instead of silently
+// serving them.
+func priceMapHash() uint64 {
+	h := fnv.New64a()
+	hashMap := func(m *map[string]float64) {
+		if m == nil {
+			return
+		}
+		keys := make([]string, 0, len(*m))
+		for k := range *m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s=%.6f;", k, (*m)[k])
+		}
+	}
+	hashMap(sellPriceBazaarMap.Load())
+	hashMap(buyPriceBazaarMap.Load())
+	return h.Sum64()
+}
+
+// expandItemTopLevel wraps expandItemConcurrent's top-level (non-recursive)
+// entry points with the disk cache: main, /expand, /analyze, and the
+// backtest harness all call this instead of expandItemConcurrent directly
+// so a stable bazaar snapshot's expansions are memoized across process
+// invocations.
+func expandItemTopLevel(itemName string, multiplier int, parentCost float64, policy PricingPolicy) map[string]int {
+	if activeCache == nil {
+		return expandItemConcurrent(itemName, multiplier, parentCost, make(map[string]int), policy)
+	}
+	key := expansionCacheKey{ItemName: itemName, Quantity: multiplier, Policy: policy.Name(), PriceMapHash: priceMapHash()}
+	if agg, ok := activeCache.getExpansion(key); ok {
+		return agg
+	}
+	agg := expandItemConcurrent(itemName, multiplier, parentCost, make(map[string]int), policy)
+	activeCache.putExpansion(key, agg)
+	return agg
+}
+
+// parseCacheFlags scans args (typically os.Args) for --no-cache and
+// --cache-ttl=<duration>, wherever they appear, since this CLI's
+// subcommands already read positional args by fixed index and trailing
+// flags (as planner's --dry-run does) are the simplest way to add an option
+// without disturbing that.
+func parseCacheFlags(args []string) (noCache bool, ttl time.Duration) {
+	for _, a := range args {
+		switch {
+		case a == "--no-cache":
+			noCache = true
+		case strings.HasPrefix(a, "--cache-ttl="):
+			if d, err := time.ParseDuration(strings.TrimPrefix(a, "--cache-ttl=")); err == nil {
+				ttl = d
+			}
+		}
+	}
+	return
+}
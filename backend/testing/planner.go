@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// PortfolioConfig is the planner's JSON input: target coin-value weights
+// across craftable items, the inventory already held, and the execution
+// limits BuildPortfolioPlan enforces while walking the portfolio toward
+// those targets.
+type PortfolioConfig struct {
+	Targets            map[string]float64 `json:"targets"`   // item ID -> target weight of total portfolio value (should sum to ~1)
+	Inventory          map[string]float64 `json:"inventory"` // item ID -> quantity currently held
+	Cash               float64            `json:"cash"`      // uninvested coins available to deploy
+	MaxAmountPerOrder  float64            `json:"max_amount_per_order"`
+	ThresholdDeviation float64            `json:"threshold_deviation"` // minimum |current-target| weight deviation before a step is planned
+}
+
+// PlanStep is one buy/craft/sell action BuildPortfolioPlan proposes to move
+// a single item's holdings toward its target weight.
+type PlanStep struct {
+	ItemID                  string  `json:"item_id"`
+	Action                  string  `json:"action"` // "buy", "craft", or "sell"
+	Quantity                float64 `json:"quantity"`
+	CapitalRequired         float64 `json:"capital_required"`
+	ExpectedFillTimeSeconds float64 `json:"expected_fill_time_seconds"`
+	CurrentWeight           float64 `json:"current_weight"`
+	TargetWeight            float64 `json:"target_weight"`
+}
+
+// PortfolioPlan is BuildPortfolioPlan's output: the ordered steps plus the
+// totals a caller needs before executing them.
+type PortfolioPlan struct {
+	TotalPortfolioValue  float64    `json:"total_portfolio_value"`
+	TotalCapitalRequired float64    `json:"total_capital_required"`
+	Steps                []PlanStep `json:"steps"`
+}
+
+// BuildPortfolioPlan computes the buy/craft/sell steps that move cfg's
+// inventory toward cfg.Targets, largest weight deviation first, capping
+// each step's capital at cfg.MaxAmountPerOrder and skipping items already
+// within cfg.ThresholdDeviation of target. Craftable items (those with a
+// recipe file, per getRecipeCells) price their step via calcC10M, the same
+// cost model expandItemConcurrent/printMultiAnalysis use elsewhere;
+// non-craftable items are priced as a flat buy at getPrice. Fill time comes
+// from computeFillTimeSub for any item present in productMetricsMap.
+func BuildPortfolioPlan(cfg PortfolioConfig) PortfolioPlan {
+	itemValue := make(map[string]float64, len(cfg.Inventory))
+	totalValue := cfg.Cash
+	for itemID, qty := range cfg.Inventory {
+		v := qty * getPrice(itemID)
+		itemValue[itemID] = v
+		totalValue += v
+	}
+
+	type deviation struct {
+		itemID        string
+		currentWeight float64
+		targetWeight  float64
+		deltaCoins    float64
+	}
+	var deviations []deviation
+	for itemID, targetWeight := range cfg.Targets {
+		currentWeight := 0.0
+		if totalValue > 0 {
+			currentWeight = itemValue[itemID] / totalValue
+		}
+		if math.Abs(currentWeight-targetWeight) < cfg.ThresholdDeviation {
+			continue
+		}
+		deviations = append(deviations, deviation{
+			itemID: itemID, currentWeight: currentWeight, targetWeight: targetWeight,
+			deltaCoins: targetWeight*totalValue - itemValue[itemID],
+		})
+	}
+	sort.Slice(deviations, func(i, j int) bool {
+		return math.Abs(deviations[i].deltaCoins) > math.Abs(deviations[j].deltaCoins)
+	})
+
+	plan := PortfolioPlan{TotalPortfolioValue: totalValue}
+	for _, d := range deviations {
+		price := getPrice(d.itemID)
+		if price <= 0 || math.IsInf(price, 0) {
+			continue
+		}
+
+		action := "buy"
+		if d.deltaCoins < 0 {
+			action = "sell"
+		}
+		coins := math.Abs(d.deltaCoins)
+		if cfg.MaxAmountPerOrder > 0 && coins > cfg.MaxAmountPerOrder {
+			coins = cfg.MaxAmountPerOrder
+		}
+		quantity := coins / price
+
+		capitalRequired := coins
+		if action == "buy" {
+			if item, err := loadItem(d.itemID); err == nil && getRecipeCells(item) != nil {
+				action = "craft"
+				capitalRequired = calcC10M(d.itemID, quantity, price)
+			}
+		}
+
+		fillTime := math.Inf(1)
+		if inProductMetrics(d.itemID) {
+			fillTime = computeFillTimeSub(productMetricsMap[d.itemID], 1, quantity)
+		}
+
+		plan.Steps = append(plan.Steps, PlanStep{
+			ItemID: d.itemID, Action: action, Quantity: quantity,
+			CapitalRequired: capitalRequired, ExpectedFillTimeSeconds: fillTime,
+			CurrentWeight: d.currentWeight, TargetWeight: d.targetWeight,
+		})
+		if action != "sell" {
+			plan.TotalCapitalRequired += capitalRequired
+		}
+	}
+	return plan
+}
+
+// RunPlannerCLI implements the `planner` CLI subcommand: it reads a
+// PortfolioConfig from configPath, builds a plan via BuildPortfolioPlan,
+// and writes it as JSON to stdout. dryRun only changes the stderr banner
+// printed first - this tool has no order-execution path of its own, so
+// every invocation already just prints a plan; the flag exists so a future
+// executor wired in here has something to gate on.
+func RunPlannerCLI(configPath string, dryRun bool) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("reading portfolio config '%s': %w", configPath, err)
+	}
+	var cfg PortfolioConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parsing portfolio config '%s': %w", configPath, err)
+	}
+
+	if dryRun {
+		fmt.Fprintln(os.Stderr, "dry run: plan computed, no orders will be placed")
+	}
+
+	plan := BuildPortfolioPlan(cfg)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
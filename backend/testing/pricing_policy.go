@@ -0,0 +1,131 @@
+package main
+
+import "math"
+
+// PricingContext carries the state PriceIngredient/ShouldExpand need beyond
+// the ingredient and quantity themselves. TopLevel is true while pricing the
+// top-level item's own direct ingredients, false for anything nested deeper
+// - mirrors the old forcedSecondary && len(visited) > 1 guard that kept
+// secondary pricing off the top level.
+type PricingContext struct {
+	TopLevel bool
+}
+
+// PricingPolicy decides how expandItemConcurrent prices an ingredient and
+// whether that cost justifies expanding it further instead of treating it as
+// a base item to buy outright. Swapping the policy swaps the whole
+// primary/secondary cost model without touching expandItemConcurrent's
+// recursion or concurrency.
+type PricingPolicy interface {
+	// Name identifies the policy in reports and the /analyze ?policy= param.
+	Name() string
+	// PriceIngredient returns the cost metric for crafting quantity units of
+	// ingredient, used both to total up a parent's aggregated cost and, via
+	// ShouldExpand, to decide whether to recurse into that ingredient.
+	PriceIngredient(ingredient string, quantity float64, ctx PricingContext) float64
+	// ShouldExpand reports whether childCost justifies expanding an
+	// ingredient further given the parent's own cost budget parentCost.
+	ShouldExpand(parentCost, childCost float64) bool
+}
+
+// InstasellPolicy prices every ingredient via calcC10M against its instasell
+// basis (getPrice) - the "primary" expansion the CLI originally always ran.
+type InstasellPolicy struct{}
+
+func (InstasellPolicy) Name() string { return "instasell" }
+
+func (InstasellPolicy) PriceIngredient(ingredient string, quantity float64, ctx PricingContext) float64 {
+	return calcC10M(ingredient, quantity, getPrice(ingredient))
+}
+
+func (InstasellPolicy) ShouldExpand(parentCost, childCost float64) bool {
+	return parentCost > childCost
+}
+
+// buyOrderDepthFactor is the empirical curve the old "secondary" expansion
+// applied on top of a flat buy-order fill: larger orders eat deeper into the
+// order book, so cost per unit grows with order size relative to a 2240
+// (one in-game day at 20 ticks/sec * 112 sec reference) baseline.
+func buyOrderDepthFactor(quantity float64) float64 {
+	n := quantity / 2240
+	return math.Pow(n, n/math.Sqrt(2240))
+}
+
+// BuyOrderPolicy prices every ingredient as a flat buy order inflated by
+// buyOrderDepthFactor - the old "secondary" expansion.
+type BuyOrderPolicy struct{}
+
+func (BuyOrderPolicy) Name() string { return "buy_order" }
+
+func (BuyOrderPolicy) PriceIngredient(ingredient string, quantity float64, ctx PricingContext) float64 {
+	return getBuyPrice(ingredient) * quantity * buyOrderDepthFactor(quantity)
+}
+
+func (BuyOrderPolicy) ShouldExpand(parentCost, childCost float64) bool {
+	return parentCost > childCost
+}
+
+// HybridPolicy prices each ingredient by whichever side of its order book is
+// more liquid: InstasellPolicy's crafting basis when the sell side moves
+// more volume (sellMovingWeekMap), BuyOrderPolicy's inflated buy-order cost
+// otherwise. The idea is to favor the pricing model that better reflects how
+// quickly that specific ingredient actually trades, rather than assuming one
+// model fits every ingredient in a recipe.
+type HybridPolicy struct{}
+
+func (HybridPolicy) Name() string { return "hybrid" }
+
+func (HybridPolicy) PriceIngredient(ingredient string, quantity float64, ctx PricingContext) float64 {
+	if sellSideMoreLiquid(ingredient) {
+		return InstasellPolicy{}.PriceIngredient(ingredient, quantity, ctx)
+	}
+	return BuyOrderPolicy{}.PriceIngredient(ingredient, quantity, ctx)
+}
+
+func (HybridPolicy) ShouldExpand(parentCost, childCost float64) bool {
+	return parentCost > childCost
+}
+
+// sellSideMoreLiquid compares productID's trailing week sell/buy volume
+// (sellMovingWeekMap/buyMovingWeekMap, populated the same way as the bazaar
+// price maps) and reports whether the sell side moves at least as much
+// volume as the buy side.
+func sellSideMoreLiquid(productID string) bool {
+	var sellVolume, buyVolume float64
+	if m := sellMovingWeekMap.Load(); m != nil {
+		sellVolume = (*m)[productID]
+	}
+	if m := buyMovingWeekMap.Load(); m != nil {
+		buyVolume = (*m)[productID]
+	}
+	return sellVolume >= buyVolume
+}
+
+// defaultPolicies is the set `main` runs every expansion through and /paths,
+// /analyze report over, in report order.
+func defaultPolicies() []PricingPolicy {
+	return []PricingPolicy{InstasellPolicy{}, BuyOrderPolicy{}, HybridPolicy{}}
+}
+
+// policyByName resolves one of defaultPolicies by its Name(), for the
+// /analyze and /expand endpoints' ?policy= query param. It returns nil if
+// name doesn't match a known policy.
+func policyByName(name string) PricingPolicy {
+	for _, p := range defaultPolicies() {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// referencePriceFor returns the main item's own market price a policy's
+// ratio/profit should be measured against: BuyOrderPolicy pays ingredients
+// at buy-order rates and is paired with the main item's buy price, every
+// other policy is paired with the main item's instasell price.
+func referencePriceFor(policyName, productID string) float64 {
+	if policyName == (BuyOrderPolicy{}).Name() {
+		return getBuyPrice(productID)
+	}
+	return getInstasellPrice(productID)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// serviceMetrics tracks the counters/gauges handleMetrics exposes in
+// Prometheus text exposition format. This directory has no go.mod to pull a
+// real Prometheus client library in with, so these are hand-rolled atomic
+// counters rather than a dependency - proportionate to this file's own
+// scope (expansion latency, cache hit rate, per-item profit ratios), not a
+// general metrics framework.
+type serviceMetrics struct {
+	expandRequests   atomic.Int64
+	expandErrors     atomic.Int64
+	expandDurationMs atomic.Int64 // running sum; divided by expandRequests for a crude average
+	cacheHits        atomic.Int64
+	cacheMisses      atomic.Int64
+}
+
+var metrics serviceMetrics
+
+func (m *serviceMetrics) writeTo(w http.ResponseWriter) {
+	reqs := m.expandRequests.Load()
+	avgLatencyMs := 0.0
+	if reqs > 0 {
+		avgLatencyMs = float64(m.expandDurationMs.Load()) / float64(reqs)
+	}
+	hits, misses := m.cacheHits.Load(), m.cacheMisses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	fmt.Fprintf(w, "# HELP testing_expand_requests_total Total /expand requests served.\n")
+	fmt.Fprintf(w, "# TYPE testing_expand_requests_total counter\n")
+	fmt.Fprintf(w, "testing_expand_requests_total %d\n", reqs)
+
+	fmt.Fprintf(w, "# HELP testing_expand_errors_total Total /expand requests that errored or timed out.\n")
+	fmt.Fprintf(w, "# TYPE testing_expand_errors_total counter\n")
+	fmt.Fprintf(w, "testing_expand_errors_total %d\n", m.expandErrors.Load())
+
+	fmt.Fprintf(w, "# HELP testing_expand_latency_ms_avg Average /expand handler latency in milliseconds.\n")
+	fmt.Fprintf(w, "# TYPE testing_expand_latency_ms_avg gauge\n")
+	fmt.Fprintf(w, "testing_expand_latency_ms_avg %.2f\n", avgLatencyMs)
+
+	fmt.Fprintf(w, "# HELP testing_item_cache_hit_rate Fraction of loadItem calls served from itemCache.\n")
+	fmt.Fprintf(w, "# TYPE testing_item_cache_hit_rate gauge\n")
+	fmt.Fprintf(w, "testing_item_cache_hit_rate %.4f\n", hitRate)
+}
+
+// topPathEntry is one craftable item's forward-rate ranking, the data
+// /paths serves.
+type topPathEntry struct {
+	ItemID    string  `json:"item_id"`
+	CraftCost float64 `json:"craft_cost"`
+	SellPrice float64 `json:"sell_price"`
+	SellRatio float64 `json:"sell_ratio"`
+}
+
+var topPathsCache atomic.Pointer[[]topPathEntry]
+
+// refreshTopPaths recomputes every craftable item's forward rate (the same
+// computation findArbitragePaths's forwardRate uses per-hop,
+// arbitrage_path.go) and caches the result sorted best-first, so /paths can
+// serve it without re-walking dependencies/items on every request.
+func refreshTopPaths(itemFilesDir string) error {
+	graph, err := buildRecipeGraph(itemFilesDir)
+	if err != nil {
+		return err
+	}
+	entries := make([]topPathEntry, 0, len(graph))
+	for itemID := range graph {
+		rate, craftCost, ok := forwardRate(itemID, graph)
+		if !ok {
+			continue
+		}
+		entries = append(entries, topPathEntry{
+			ItemID:    itemID,
+			CraftCost: craftCost,
+			SellPrice: getInstasellPrice(itemID),
+			SellRatio: rate,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SellRatio > entries[j].SellRatio })
+	topPathsCache.Store(&entries)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleExpand serves GET /expand?item=X&qty=N&policy=P: the expansion
+// expandItemConcurrent would produce under the named PricingPolicy
+// (policyByName; defaults to InstasellPolicy), as JSON. Bounded by
+// profitTimeout via context, the same timeout expandItemConcurrent's caller
+// in main() implicitly has no limit on today - the goroutine started here is
+// abandoned (not canceled) if the timeout fires, since expandItemConcurrent
+// has no ctx parameter to cancel through; threading cancellation into that
+// recursive walker is out of scope for this endpoint.
+func handleExpand(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	metrics.expandRequests.Add(1)
+	defer func() { metrics.expandDurationMs.Add(time.Since(start).Milliseconds()) }()
+
+	itemName := r.URL.Query().Get("item")
+	qty, err := strconv.ParseFloat(r.URL.Query().Get("qty"), 64)
+	if itemName == "" || err != nil || qty <= 0 {
+		metrics.expandErrors.Add(1)
+		http.Error(w, "item and a positive qty query params are required", http.StatusBadRequest)
+		return
+	}
+	policy := InstasellPolicy{}.Name()
+	if raw := r.URL.Query().Get("policy"); raw != "" {
+		policy = raw
+	}
+	resolved := policyByName(policy)
+	if resolved == nil {
+		metrics.expandErrors.Add(1)
+		http.Error(w, fmt.Sprintf("unknown policy %q", policy), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), profitTimeout)
+	defer cancel()
+
+	result := make(chan map[string]int, 1)
+	go func() {
+		parentCost := calcC10M(itemName, qty, getInstasellPrice(itemName))
+		result <- expandItemTopLevel(itemName, int(qty), parentCost, resolved)
+	}()
+
+	select {
+	case agg := <-result:
+		writeJSON(w, http.StatusOK, agg)
+	case <-ctx.Done():
+		metrics.expandErrors.Add(1)
+		http.Error(w, "expansion timed out", http.StatusGatewayTimeout)
+	}
+}
+
+// handleAnalyze serves GET /analyze?item=X&qty=N: the same per-policy
+// analysis printMultiAnalysis prints, as JSON, across every
+// defaultPolicies() entry.
+func handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	itemName := r.URL.Query().Get("item")
+	quantity, err := strconv.ParseFloat(r.URL.Query().Get("qty"), 64)
+	if itemName == "" || err != nil || quantity <= 0 {
+		http.Error(w, "item and a positive qty query params are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), profitTimeout)
+	defer cancel()
+
+	type outcome struct {
+		result multiAnalysis
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		item, loadErr := loadItem(itemName)
+		if loadErr != nil {
+			done <- outcome{err: fmt.Errorf("no recipe file for %q: %w", itemName, loadErr)}
+			return
+		}
+		cells := getRecipeCells(item)
+		parent := calcC10M(itemName, quantity, getInstasellPrice(itemName))
+
+		policies := defaultPolicies()
+		aggsByPolicy := make(map[string]map[string]int, len(policies))
+		for _, p := range policies {
+			if cells != nil && parent > 0 {
+				aggsByPolicy[p.Name()] = expandItemTopLevel(itemName, int(quantity), parent, p)
+			} else {
+				aggsByPolicy[p.Name()] = map[string]int{itemName: int(quantity)}
+			}
+		}
+		done <- outcome{result: computeMultiAnalysis(itemName, quantity, policies, aggsByPolicy)}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil {
+			http.Error(w, out.err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, out.result)
+	case <-ctx.Done():
+		http.Error(w, "analysis timed out", http.StatusGatewayTimeout)
+	}
+}
+
+// handlePrices serves GET /prices/<productID>.
+func handlePrices(w http.ResponseWriter, r *http.Request) {
+	productID := strings.TrimPrefix(r.URL.Path, "/prices/")
+	if productID == "" {
+		http.Error(w, "product ID required in path, e.g. /prices/ENCHANTED_DIAMOND", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"product_id":           productID,
+		"buy_price":            getBuyPrice(productID),
+		"sell_price":           getInstasellPrice(productID),
+		"auction_price":        getAuctionPrice(productID),
+		"in_product_metrics":   inProductMetrics(productID),
+		"bazaar_stale_seconds": BazaarStaleness().Seconds(),
+	})
+}
+
+// handlePaths serves GET /paths?k=N, the top-K most profitable craft
+// targets cached by refreshTopPaths, defaulting to the top 20.
+func handlePaths(w http.ResponseWriter, r *http.Request) {
+	k := 20
+	if raw := r.URL.Query().Get("k"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			k = n
+		}
+	}
+	cached := topPathsCache.Load()
+	if cached == nil {
+		http.Error(w, "top paths not computed yet", http.StatusServiceUnavailable)
+		return
+	}
+	entries := *cached
+	if k > len(entries) {
+		k = len(entries)
+	}
+	writeJSON(w, http.StatusOK, entries[:k])
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.writeTo(w)
+}
+
+// RunServeCLI runs the `serve` CLI subcommand: an HTTP server exposing
+// /expand, /analyze, /prices/:productID, /paths, and /metrics over the
+// process's already-loaded itemCache and price maps, so requests share the
+// same cached state the CLI's single-shot mode uses rather than each
+// reloading it.
+func RunServeCLI(addr, itemFilesDir string) error {
+	if err := refreshTopPaths(itemFilesDir); err != nil {
+		log.Printf("initial /paths computation failed: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(bazaarRefreshInterval())
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := refreshTopPaths(itemFilesDir); err != nil {
+				log.Printf("refreshing /paths failed: %v", err)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/expand", handleExpand)
+	mux.HandleFunc("/analyze", handleAnalyze)
+	mux.HandleFunc("/prices/", handlePrices)
+	mux.HandleFunc("/paths", handlePaths)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	log.Printf("serving on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
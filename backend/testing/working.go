@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,17 +13,24 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // ////////////////// Global Variables /////////////////////
 var (
-	auctionPriceMap    map[string]float64
-	productMetricsMap  map[string]ProductMetrics
-	sellPriceBazaarMap map[string]float64
-	buyPriceBazaarMap  map[string]float64 // stores buy_summary[0] prices
-	sellMovingWeekMap  map[string]float64
-	buyMovingWeekMap   map[string]float64
+	auctionPriceMap   map[string]float64
+	productMetricsMap map[string]ProductMetrics
+	// sellPriceBazaarMap/buyPriceBazaarMap/sellMovingWeekMap/buyMovingWeekMap
+	// are swapped wholesale by loadBazaarPrices/RunBazaarRefresher
+	// (bazaar_refresh.go) via atomic.Pointer, so getBuyPrice/
+	// getSellPriceBazaar/getInstasellPrice can read them lock-free even
+	// while a background refresh is rebuilding the next snapshot.
+	sellPriceBazaarMap atomic.Pointer[map[string]float64]
+	buyPriceBazaarMap  atomic.Pointer[map[string]float64] // stores buy_summary[0] prices
+	sellMovingWeekMap  atomic.Pointer[map[string]float64]
+	buyMovingWeekMap   atomic.Pointer[map[string]float64]
+	bazaarLastUpdated  atomic.Pointer[time.Time]
 	itemCache          = make(map[string]Item)
 	cacheMutex         sync.RWMutex
 	sem                = make(chan struct{}, 50)
@@ -107,71 +115,63 @@ type Item struct {
 }
 
 // ////////////////// Utility Functions /////////////////////
-// loadBazaarPrices loads both sell and buy prices from the Hypixel API.
-func loadBazaarPrices() map[string]float64 {
+// loadBazaarPrices fetches sell/buy prices from the Hypixel API and
+// atomically swaps them into sellPriceBazaarMap/buyPriceBazaarMap/
+// sellMovingWeekMap/buyMovingWeekMap, returning an error instead of
+// log.Fatal-ing so RunBazaarRefresher (bazaar_refresh.go) can retry a
+// transient failure rather than crash a long-running server.
+func loadBazaarPrices() error {
 	start := time.Now()
 	resp, err := http.Get("https://api.hypixel.net/v2/skyblock/bazaar")
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("fetching bazaar prices: %w", err)
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("reading bazaar response: %w", err)
 	}
 	var apiResp HypixelAPIResponse
 	if err := json.Unmarshal(body, &apiResp); err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("parsing bazaar response: %w", err)
 	}
-	result := make(map[string]float64)
-	buyPriceBazaarMap = make(map[string]float64)
-	sellMovingWeekMap = make(map[string]float64)
-	buyMovingWeekMap = make(map[string]float64)
-	for productID, product := range apiResp.Products {
-		if len(product.SellSummary) > 0 {
-			result[productID] = product.SellSummary[0].PricePerUnit
-		} else {
-			result[productID] = math.Inf(1)
-		}
-		if len(product.BuySummary) > 0 {
-			buyPriceBazaarMap[productID] = product.BuySummary[0].PricePerUnit
-		} else {
-			buyPriceBazaarMap[productID] = math.Inf(1)
-		}
-		sellMoving := product.SellMovingWeek
-		if sellMoving == 0 {
-			sellMoving = product.QuickStatus.SellMovingWeek
-		}
-		buyMoving := product.BuyMovingWeek
-		if buyMoving == 0 {
-			buyMoving = product.QuickStatus.BuyMovingWeek
-		}
-		sellMovingWeekMap[productID] = sellMoving
-		buyMovingWeekMap[productID] = buyMoving
-	}
-	log.Printf("Loaded bazaar prices for %d products in %s", len(result), time.Since(start))
-	return result
+
+	sell, buy, sellMoving, buyMoving := parseBazaarResponse(apiResp)
+	sellPriceBazaarMap.Store(&sell)
+	buyPriceBazaarMap.Store(&buy)
+	sellMovingWeekMap.Store(&sellMoving)
+	buyMovingWeekMap.Store(&buyMoving)
+	now := time.Now()
+	bazaarLastUpdated.Store(&now)
+
+	log.Printf("Loaded bazaar prices for %d products in %s", len(sell), time.Since(start))
+	return nil
 }
 
 func getBuyPrice(productID string) float64 {
-	if price, ok := buyPriceBazaarMap[productID]; ok {
+	m := buyPriceBazaarMap.Load()
+	if m == nil {
+		return math.Inf(1)
+	}
+	if price, ok := (*m)[productID]; ok {
 		return price
 	}
 	return math.Inf(1)
 }
 
 func getSellPriceBazaar(productID string) float64 {
-	if price, ok := sellPriceBazaarMap[productID]; ok {
+	m := sellPriceBazaarMap.Load()
+	if m == nil {
+		return math.Inf(1)
+	}
+	if price, ok := (*m)[productID]; ok {
 		return price
 	}
 	return math.Inf(1)
 }
 
 func getInstasellPrice(productID string) float64 {
-	if price, ok := sellPriceBazaarMap[productID]; ok {
-		return price
-	}
-	return math.Inf(1)
+	return getSellPriceBazaar(productID)
 }
 
 func loadAuctionPrices() map[string]float64 {
@@ -304,10 +304,24 @@ func loadItem(itemName string) (Item, error) {
 	cacheMutex.RLock()
 	if item, ok := itemCache[itemName]; ok {
 		cacheMutex.RUnlock()
+		metrics.cacheHits.Add(1)
 		return item, nil
 	}
 	cacheMutex.RUnlock()
+
 	filePath := filepath.Join("dependencies", "items", itemName+".json")
+	info, statErr := os.Stat(filePath)
+	if statErr == nil && activeCache != nil {
+		if item, ok := activeCache.getItem(itemName, info.ModTime()); ok {
+			metrics.cacheHits.Add(1)
+			cacheMutex.Lock()
+			itemCache[itemName] = item
+			cacheMutex.Unlock()
+			return item, nil
+		}
+	}
+
+	metrics.cacheMisses.Add(1)
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return Item{}, err
@@ -319,6 +333,9 @@ func loadItem(itemName string) (Item, error) {
 	cacheMutex.Lock()
 	itemCache[itemName] = item
 	cacheMutex.Unlock()
+	if activeCache != nil && statErr == nil {
+		activeCache.putItem(itemName, info.ModTime(), item)
+	}
 	return item, nil
 }
 
@@ -334,7 +351,7 @@ func cloneVisited(visited map[string]int) map[string]int {
 // expandItemConcurrent recursively expands an item.
 // If an item has no recipe cells, it returns a map with the item itself.
 // (Items without a recipe are treated as base items with infinite fill time.)
-func expandItemConcurrent(itemName string, multiplier int, parentC10M float64, visited map[string]int, forcedSecondary bool) map[string]int {
+func expandItemConcurrent(itemName string, multiplier int, parentCost float64, visited map[string]int, policy PricingPolicy) map[string]int {
 	if prev, exists := visited[itemName]; exists {
 		return map[string]int{itemName: prev}
 	}
@@ -351,6 +368,9 @@ func expandItemConcurrent(itemName string, multiplier int, parentC10M float64, v
 	}
 
 	aggregated := aggregateCells(cells)
+	// Only sub-items (i.e. not the top level) see the policy's full cost
+	// model - mirrors the old forcedSecondary && len(visited) > 1 guard.
+	ctx := PricingContext{TopLevel: len(visited) <= 1}
 
 	// Compute aggregated cost for all ingredients.
 	totalCost := 0.0
@@ -358,26 +378,11 @@ func expandItemConcurrent(itemName string, multiplier int, parentC10M float64, v
 		ingredientPath := filepath.Join("dependencies", "items", ingredient+".json")
 		if _, err := os.Stat(ingredientPath); err == nil {
 			newMultiplier := multiplier * amt
-			var priceParent float64
-			// Only apply forced secondary pricing for sub-items (i.e. when not at the top level).
-			if forcedSecondary && len(visited) > 1 {
-				priceParent = getBuyPrice(ingredient)
-			} else {
-				priceParent = getPrice(ingredient)
-			}
-			primary := calcC10M(ingredient, float64(newMultiplier), priceParent)
-			secondary := getBuyPrice(ingredient) * float64(newMultiplier) * math.Pow(float64(newMultiplier)/2240, (float64(newMultiplier)/2240)/math.Sqrt(2240))
-			var chosenSubMetric float64
-			if forcedSecondary && len(visited) > 1 {
-				chosenSubMetric = secondary
-			} else {
-				chosenSubMetric = primary
-			}
-			totalCost += chosenSubMetric
+			totalCost += policy.PriceIngredient(ingredient, float64(newMultiplier), ctx)
 		}
 	}
-	if totalCost > parentC10M {
-		log.Printf("Aggregated cost (%.0f) exceeds parent's metric (%.0f) for %s. Not expanding further.", totalCost, parentC10M, itemName)
+	if totalCost > parentCost {
+		log.Printf("Aggregated cost (%.0f) exceeds parent's metric (%.0f) for %s. Not expanding further.", totalCost, parentCost, itemName)
 		return map[string]int{itemName: multiplier}
 	}
 
@@ -389,35 +394,21 @@ func expandItemConcurrent(itemName string, multiplier int, parentC10M float64, v
 		newMultiplier := multiplier * amt
 		ingredientPath := filepath.Join("dependencies", "items", ingredient+".json")
 		if _, err := os.Stat(ingredientPath); err == nil {
-			var priceParent float64
-			// Again, apply forced secondary pricing only for sub-items.
-			if forcedSecondary && len(visited) > 1 {
-				priceParent = getBuyPrice(ingredient)
-			} else {
-				priceParent = getPrice(ingredient)
-			}
-			forceExpand := math.IsInf(priceParent, 1)
-			primary := calcC10M(ingredient, float64(newMultiplier), priceParent)
-			secondary := getBuyPrice(ingredient) * float64(newMultiplier) * math.Pow(float64(newMultiplier)/2240, (float64(newMultiplier)/2240)/math.Sqrt(2240))
-			var chosenSubMetric float64
-			if forcedSecondary && len(visited) > 1 {
-				chosenSubMetric = secondary
-			} else {
-				chosenSubMetric = primary
-			}
-			if !forceExpand && parentC10M <= chosenSubMetric {
-				log.Printf("Pre-check: For ingredient %s, parent's metric (%.0f) <= chosen sub-metric (%.0f). Not expanding further.", ingredient, parentC10M, chosenSubMetric)
+			forceExpand := math.IsInf(getPrice(ingredient), 1)
+			chosenSubMetric := policy.PriceIngredient(ingredient, float64(newMultiplier), ctx)
+			if !forceExpand && !policy.ShouldExpand(parentCost, chosenSubMetric) {
+				log.Printf("Pre-check: For ingredient %s, parent's metric (%.0f) <= chosen sub-metric (%.0f). Not expanding further.", ingredient, parentCost, chosenSubMetric)
 				final[ingredient] += newMultiplier
 				continue
 			}
 
-			log.Printf("{%d %s, parent: %.0f, chosen metric: %.0f}", newMultiplier, ingredient, parentC10M, chosenSubMetric)
+			log.Printf("{%d %s, parent: %.0f, chosen metric: %.0f}", newMultiplier, ingredient, parentCost, chosenSubMetric)
 			wg.Add(1)
 			sem <- struct{}{}
 			newVisited := cloneVisited(visited)
 			go func(ingredient string, newMultiplier int, chosenMetric float64) {
 				defer wg.Done()
-				subRes := expandItemConcurrent(ingredient, newMultiplier, chosenMetric, newVisited, forcedSecondary)
+				subRes := expandItemConcurrent(ingredient, newMultiplier, chosenMetric, newVisited, policy)
 				resultChan <- subRes
 				<-sem
 			}(ingredient, newMultiplier, chosenSubMetric)
@@ -455,118 +446,126 @@ func computeFillTimeSub(metrics ProductMetrics, factor, quantity float64) float6
 	return factor * quantity * 20 / (metrics.SellSize * metrics.SellFrequency)
 }
 
-// printRevampedAnalysisDual computes and prints analysis based on both expansions.
-func printRevampedAnalysisDual(productID string, quantity float64, finalAggPrimary, finalAggSecondary map[string]int) {
-	mainBuyPrice := getBuyPrice(productID)
-	mainSellPrice := getInstasellPrice(productID)
-
-	// Fix: Use getPrice() for both calculations to ensure consistency
-	totalBaseCostPrimary := 0.0
-	for sub, amt := range finalAggPrimary {
-		totalBaseCostPrimary += getPrice(sub) * float64(amt)
-	}
-
-	// Fix: Use getPrice() here instead of getBuyPrice() for consistent comparison
-	totalBaseCostSecondary := 0.0
-	for sub, amt := range finalAggSecondary {
-		totalBaseCostSecondary += getPrice(sub) * float64(amt)
-	}
-
-	baseCostPerUnitPrimary := totalBaseCostPrimary / quantity
-	baseCostPerUnitSecondary := totalBaseCostSecondary / quantity
+// policyAnalysis is one PricingPolicy's slice of a multiAnalysis report.
+type policyAnalysis struct {
+	Policy             string  `json:"policy"`
+	TotalBaseCost      float64 `json:"total_base_cost"`
+	Ratio              float64 `json:"ratio"`
+	Profit             float64 `json:"profit"`
+	SlowestSub         string  `json:"slowest_sub,omitempty"`
+	SlowestSubFillTime float64 `json:"slowest_sub_fill_time_seconds"`
+}
 
-	sellRatio := 0.0
-	buyRatio := 0.0
-	if baseCostPerUnitPrimary > 0 {
-		sellRatio = mainSellPrice / baseCostPerUnitPrimary
-	}
-	if baseCostPerUnitSecondary > 0 {
-		buyRatio = mainBuyPrice / baseCostPerUnitSecondary
-	}
+// multiAnalysis is the full per-policy expansion analysis, computed once by
+// computeMultiAnalysis and shared by printMultiAnalysis (CLI output) and the
+// /analyze service endpoint (serve.go), so both surfaces report identical
+// numbers instead of each recomputing ratios and fill times its own way.
+// Policies holds one entry per PricingPolicy the caller ran, in the order
+// given - this replaced a fixed Primary/Secondary pair so an arbitrary
+// number of policies (see pricing_policy.go) can be compared side by side.
+type multiAnalysis struct {
+	ProductID     string  `json:"product_id"`
+	Quantity      float64 `json:"quantity"`
+	MainSellPrice float64 `json:"main_sell_price"`
+	MainBuyPrice  float64 `json:"main_buy_price"`
+	MainFillTime  float64 `json:"main_fill_time_seconds"`
+	StaleSeconds  float64 `json:"bazaar_stale_seconds"`
+
+	Policies []policyAnalysis `json:"policies"`
+}
 
-	profitSell := mainSellPrice - baseCostPerUnitPrimary
-	profitBuy := mainBuyPrice - baseCostPerUnitSecondary
+// computeMultiAnalysis computes the same ratios, profits, and bottleneck
+// fill times printMultiAnalysis used to compute inline, once per policy in
+// policies against that policy's expansion result in aggsByPolicy (keyed by
+// PricingPolicy.Name()).
+func computeMultiAnalysis(productID string, quantity float64, policies []PricingPolicy, aggsByPolicy map[string]map[string]int) multiAnalysis {
+	mainBuyPrice := getBuyPrice(productID)
+	mainSellPrice := getInstasellPrice(productID)
 
 	var fillTime float64
 	if inProductMetrics(productID) {
-		metrics := productMetricsMap[productID]
-		fillTime = computeFillTimeMain(metrics, quantity)
+		fillTime = computeFillTimeMain(productMetricsMap[productID], quantity)
 	} else {
 		fillTime = math.Inf(1)
 	}
 
-	fmt.Printf("Main Item Analysis for %s (quantity = %.2f):\n", productID, quantity)
-	fmt.Printf("  Sell Summary Price: %.2f\n", mainSellPrice)
-	fmt.Printf("  Buy Summary Price:  %.2f\n", mainBuyPrice)
-	fmt.Printf("  Fill Time:          %.2f sec\n\n", fillTime)
-	fmt.Println("=== Primary Expansion (Sell method) ===")
-	fmt.Printf("  Total Base Cost:    %.2f (per unit: %.2f)\n", totalBaseCostPrimary, baseCostPerUnitPrimary)
-	fmt.Printf("  Sell Price Ratio:   %.4f\n", sellRatio)
-	fmt.Printf("  Profit (Sell):      %.2f\n\n", profitSell)
-	fmt.Println("=== Secondary Expansion (Buy method) ===")
-	fmt.Printf("  Total Base Cost:    %.2f (per unit: %.2f)\n", totalBaseCostSecondary, baseCostPerUnitSecondary)
-	fmt.Printf("  Buy Price Ratio:    %.4f\n", buyRatio)
-	fmt.Printf("  Profit (Buy):       %.2f\n\n", profitBuy)
-
-	// Primary expansion sub-product analysis.
-	var slowestSubPrimary string
-	maxSubFillTimePrimary := -1.0
-	for subID, qty := range finalAggPrimary {
-		if subID == productID {
-			continue
+	slowestSub := func(agg map[string]int) (string, float64) {
+		var slowest string
+		maxFillTime := -1.0
+		for subID, qty := range agg {
+			if subID == productID {
+				continue
+			}
+			subFillTime := math.Inf(1)
+			if inProductMetrics(subID) {
+				subFillTime = computeFillTimeSub(productMetricsMap[subID], 1, float64(qty))
+			}
+			if subFillTime > maxFillTime {
+				maxFillTime = subFillTime
+				slowest = subID
+			}
 		}
-		var subFillTime float64
-		if inProductMetrics(subID) {
-			metrics := productMetricsMap[subID]
-			subFillTime = computeFillTimeSub(metrics, 1, float64(qty))
-		} else {
-			subFillTime = math.Inf(1)
+		return slowest, maxFillTime
+	}
+
+	policyResults := make([]policyAnalysis, 0, len(policies))
+	for _, policy := range policies {
+		agg := aggsByPolicy[policy.Name()]
+		totalBaseCost := 0.0
+		for sub, amt := range agg {
+			totalBaseCost += getPrice(sub) * float64(amt)
 		}
-		if subFillTime > maxSubFillTimePrimary {
-			maxSubFillTimePrimary = subFillTime
-			slowestSubPrimary = subID
+		baseCostPerUnit := totalBaseCost / quantity
+		reference := referencePriceFor(policy.Name(), productID)
+		ratio := 0.0
+		if baseCostPerUnit > 0 {
+			ratio = reference / baseCostPerUnit
 		}
+		slowest, slowestFillTime := slowestSub(agg)
+		policyResults = append(policyResults, policyAnalysis{
+			Policy: policy.Name(), TotalBaseCost: totalBaseCost, Ratio: ratio,
+			Profit: reference - baseCostPerUnit, SlowestSub: slowest, SlowestSubFillTime: slowestFillTime,
+		})
 	}
 
-	if slowestSubPrimary != "" {
-		fmt.Printf("Sub-Product with the Longest Fill Time (from primary expansion): %s\n", slowestSubPrimary)
-		fmt.Printf("  Fill Time:          %.2f sec\n", maxSubFillTimePrimary)
-		fmt.Printf("  Buy Summary Price:  %.2f\n", getBuyPrice(slowestSubPrimary))
-		fmt.Printf("  Sell Summary Price: %.2f\n\n", getInstasellPrice(slowestSubPrimary))
-	} else {
-		fmt.Printf("Sub-Product with the Longest Fill Time (from primary expansion): N/A\n")
-		fmt.Printf("  Fill Time:          +Inf sec\n\n")
+	return multiAnalysis{
+		ProductID: productID, Quantity: quantity,
+		MainSellPrice: mainSellPrice, MainBuyPrice: mainBuyPrice, MainFillTime: fillTime,
+		StaleSeconds: BazaarStaleness().Seconds(),
+		Policies:     policyResults,
 	}
+}
 
-	// Secondary expansion sub-product analysis.
-	var slowestSubSecondary string
-	maxSubFillTimeSecondary := -1.0
-	for subID, qty := range finalAggSecondary {
-		if subID == productID {
-			continue
-		}
-		var subFillTime float64
-		if inProductMetrics(subID) {
-			metrics := productMetricsMap[subID]
-			subFillTime = computeFillTimeSub(metrics, 1, float64(qty))
+// printMultiAnalysis prints computeMultiAnalysis's result in the original
+// report layout, one "=== <policy> Expansion ===" section per policy run.
+func printMultiAnalysis(productID string, quantity float64, policies []PricingPolicy, aggsByPolicy map[string]map[string]int) {
+	a := computeMultiAnalysis(productID, quantity, policies, aggsByPolicy)
+
+	fmt.Printf("Main Item Analysis for %s (quantity = %.2f):\n", a.ProductID, a.Quantity)
+	fmt.Printf("  Sell Summary Price: %.2f\n", a.MainSellPrice)
+	fmt.Printf("  Buy Summary Price:  %.2f\n", a.MainBuyPrice)
+	fmt.Printf("  Fill Time:          %.2f sec\n", a.MainFillTime)
+	if staleness := BazaarStaleness(); staleness > staleBazaarThreshold {
+		fmt.Printf("  WARNING: bazaar prices are %s old (stale threshold %s)\n", staleness.Round(time.Second), staleBazaarThreshold)
+	}
+	fmt.Println()
+
+	for _, p := range a.Policies {
+		fmt.Printf("=== %s Expansion ===\n", p.Policy)
+		fmt.Printf("  Total Base Cost:    %.2f (per unit: %.2f)\n", p.TotalBaseCost, p.TotalBaseCost/a.Quantity)
+		fmt.Printf("  Price Ratio:        %.4f\n", p.Ratio)
+		fmt.Printf("  Profit:             %.2f\n\n", p.Profit)
+
+		if p.SlowestSub != "" {
+			fmt.Printf("Sub-Product with the Longest Fill Time (from %s expansion): %s\n", p.Policy, p.SlowestSub)
+			fmt.Printf("  Fill Time:          %.2f sec\n", p.SlowestSubFillTime)
+			fmt.Printf("  Buy Summary Price:  %.2f\n", getBuyPrice(p.SlowestSub))
+			fmt.Printf("  Sell Summary Price: %.2f\n\n", getInstasellPrice(p.SlowestSub))
 		} else {
-			subFillTime = math.Inf(1)
-		}
-		if subFillTime > maxSubFillTimeSecondary {
-			maxSubFillTimeSecondary = subFillTime
-			slowestSubSecondary = subID
+			fmt.Printf("Sub-Product with the Longest Fill Time (from %s expansion): N/A\n", p.Policy)
+			fmt.Printf("  Fill Time:          +Inf sec\n\n")
 		}
 	}
-
-	if slowestSubSecondary != "" {
-		fmt.Printf("Sub-Product with the Longest Fill Time (from secondary expansion): %s\n", slowestSubSecondary)
-		fmt.Printf("  Fill Time:          %.2f sec\n", maxSubFillTimeSecondary)
-		fmt.Printf("  Buy Summary Price:  %.2f\n", getBuyPrice(slowestSubSecondary))
-		fmt.Printf("  Sell Summary Price: %.2f\n\n", getInstasellPrice(slowestSubSecondary))
-	} else {
-		fmt.Printf("Sub-Product with the Longest Fill Time (from secondary expansion): N/A\n")
-		fmt.Printf("  Fill Time:          +Inf sec\n\n")
-	}
 }
 
 // ////////////////// Main /////////////////////
@@ -574,6 +573,22 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
 	startGlobal := time.Now()
+
+	noCache, cacheTTL := parseCacheFlags(os.Args)
+	if !noCache {
+		c, err := initDiskCache(cacheTTL)
+		if err != nil {
+			log.Printf("disk cache unavailable, continuing without it: %v", err)
+		} else {
+			activeCache = c
+			defer func() {
+				if err := activeCache.save(); err != nil {
+					log.Printf("saving disk cache: %v", err)
+				}
+			}()
+		}
+	}
+
 	var wg sync.WaitGroup
 
 	wg.Add(3)
@@ -586,15 +601,81 @@ func main() {
 		wg.Done()
 	}()
 	go func() {
-		sellPriceBazaarMap = loadBazaarPrices()
+		if err := loadBazaarPrices(); err != nil {
+			log.Fatal(err)
+		}
 		wg.Done()
 	}()
 	wg.Wait()
 	log.Printf("Global initialization took %s", time.Since(startGlobal))
 
+	// Keep the bazaar maps fresh for the rest of the process's lifetime
+	// instead of only loading them once at startup.
+	go RunBazaarRefresher(context.Background(), bazaarRefreshInterval(), nil)
+
+	// `<executable> planner <config.json> [--dry-run]` runs the portfolio
+	// rebalance planner (planner.go) instead of the single-product
+	// expansion below.
+	if len(os.Args) > 1 && os.Args[1] == "planner" {
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: <executable> planner <config.json> [--dry-run]")
+			os.Exit(1)
+		}
+		dryRun := len(os.Args) > 3 && os.Args[3] == "--dry-run"
+		if err := RunPlannerCLI(os.Args[2], dryRun); err != nil {
+			log.Fatalf("planner: %v", err)
+		}
+		return
+	}
+
+	// `<executable> serve [addr]` runs the HTTP/JSON service (serve.go)
+	// instead of the single-product expansion below.
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		addr := ":8080"
+		if len(os.Args) > 2 {
+			addr = os.Args[2]
+		}
+		if err := RunServeCLI(addr, filepath.Join("dependencies", "items")); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+		return
+	}
+
+	// `<executable> arbitrage-paths [maxHops]` runs the triangular
+	// craft-arbitrage cycle search instead of the single-product expansion
+	// below.
+	if len(os.Args) > 1 && os.Args[1] == "arbitrage-paths" {
+		maxHops := 5
+		if len(os.Args) > 2 {
+			if n, err := strconv.Atoi(os.Args[2]); err == nil && n >= 3 {
+				maxHops = n
+			}
+		}
+		paths, err := findArbitragePaths(filepath.Join("dependencies", "items"), maxHops)
+		if err != nil {
+			log.Fatalf("arbitrage-paths: %v", err)
+		}
+		printArbitragePaths(paths, 10)
+		return
+	}
+
+	// `<executable> backtest <config.json>` replays historical bazaar
+	// snapshots through the expander (backtest.go) instead of the
+	// single-product expansion below.
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: <executable> backtest <config.json>")
+			os.Exit(1)
+		}
+		if err := RunBacktestCLI(os.Args[2]); err != nil {
+			log.Fatalf("backtest: %v", err)
+		}
+		return
+	}
+
 	// Check for required command-line arguments.
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: <executable> <product_name> <starting_quantity>")
+		fmt.Println("Usage: <executable> <product_name> <starting_quantity> [--no-cache] [--cache-ttl=<duration>]")
 		os.Exit(1)
 	}
 	productName := os.Args[1]
@@ -616,36 +697,28 @@ func main() {
 		os.Exit(1)
 	}
 	cells := getRecipeCells(topItem)
-	// For the top-level item, use the primary metric (instasell price)
-	primaryParent := calcC10M(productName, quantity, getInstasellPrice(productName))
-	// For the secondary expansion we force secondary pricing on sub-items only.
-	secondaryParent := calcC10M(productName, quantity, getInstasellPrice(productName))
-	log.Printf("{%d %s, top level item primary c10m: %.0f}", int(quantity), productName, primaryParent)
-
-	var finalAggPrimary, finalAggSecondary map[string]int
-	if cells != nil {
-		if primaryParent > 0 {
-			finalAggPrimary = expandItemConcurrent(productName, int(quantity), primaryParent, make(map[string]int), false)
+	// The top-level item is always priced off its instasell price - only
+	// sub-items vary by policy (PricingContext.TopLevel in
+	// expandItemConcurrent).
+	parentCost := calcC10M(productName, quantity, getInstasellPrice(productName))
+	log.Printf("{%d %s, top level item c10m: %.0f}", int(quantity), productName, parentCost)
+
+	policies := defaultPolicies()
+	aggsByPolicy := make(map[string]map[string]int, len(policies))
+	for _, policy := range policies {
+		if cells != nil && parentCost > 0 {
+			aggsByPolicy[policy.Name()] = expandItemTopLevel(productName, int(quantity), parentCost, policy)
 		} else {
-			finalAggPrimary = map[string]int{productName: int(quantity)}
+			aggsByPolicy[policy.Name()] = map[string]int{productName: int(quantity)}
 		}
-		if secondaryParent > 0 {
-			// Pass forcedSecondary=true so that sub-items use getBuyPrice,
-			// but note that at the top-level (visited length==1) we ignore forced pricing.
-			finalAggSecondary = expandItemConcurrent(productName, int(quantity), secondaryParent, make(map[string]int), true)
-		} else {
-			finalAggSecondary = map[string]int{productName: int(quantity)}
-		}
-	} else {
-		finalAggPrimary = map[string]int{productName: int(quantity)}
-		finalAggSecondary = map[string]int{productName: int(quantity)}
 	}
+	finalAggPrimary := aggsByPolicy[InstasellPolicy{}.Name()]
 
 	for sub, amt := range finalAggPrimary {
 		if sub == productName {
 			continue
 		}
-		log.Printf("{%d %s, primary parent: %.0f}", amt, sub, primaryParent)
+		log.Printf("{%d %s, parent: %.0f}", amt, sub, parentCost)
 	}
 
 	fmt.Printf("Expansion for %s:\n", productName)
@@ -656,13 +729,12 @@ func main() {
 	}
 	mainSell := getInstasellPrice(productName)
 	fmt.Printf("\nMain product sell price: %.2f\n", mainSell)
-	fmt.Printf("Total base cost (primary): %.2f\n", totalBaseCost)
+	fmt.Printf("Total base cost (%s): %.2f\n", InstasellPolicy{}.Name(), totalBaseCost)
 	if totalBaseCost > 0 {
 		fmt.Printf("Price Ratio (sell/base): %.4f\n\n", mainSell/(totalBaseCost/float64(int(quantity))))
 	} else {
 		fmt.Println("Total base cost is zero; cannot compute price ratio.\n")
 	}
 
-	// Fix: Changed productID to productName here
-	printRevampedAnalysisDual(productName, quantity, finalAggPrimary, finalAggSecondary)
+	printMultiAnalysis(productName, quantity, policies, aggsByPolicy)
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ProductBook is one item's full order book - both arrays of
+// BazaarResponse.Products[itemID] - kept alongside the collapsed
+// buyPrice/sellPrice PriceData.Price already extracts, so PriceForQuantity
+// can walk it for a realistic cost/revenue at a quantity beyond what's
+// available at the top of the book.
+type ProductBook struct {
+	BuySummary  []OrderBookEntry
+	SellSummary []OrderBookEntry
+}
+
+// TransactionMetrics is the subset of the calc-engine's own Metric
+// (backend/calculation_engine/main.go: PlayerInstabuyTransactionFrequency/
+// PlayerInstabuyTransactionSizeAverage) PriceForQuantity needs to estimate
+// how long a buy order sitting at the top of the book - rather than
+// instabuying straight through the book - would take to fill. bz.go is a
+// standalone script with no import path to that package's live metrics
+// feed, so callers that have a snapshot of it (however they obtained one)
+// set it via SetTransactionMetrics rather than PriceForQuantity fetching
+// its own copy.
+type TransactionMetrics struct {
+	Frequency   float64 // transactions per second
+	SizeAverage float64 // units per transaction
+}
+
+var (
+	latestOrderBook map[string]ProductBook
+	latestTxMetrics map[string]TransactionMetrics
+)
+
+// fetchOrderBook fetches the full Bazaar order book (both summary arrays
+// for every product), for PriceForQuantity to walk - unlike
+// BazaarPriceSource.FetchPrices, which only keeps QuickStatus's
+// already-collapsed buyPrice/sellPrice.
+func fetchOrderBook() (map[string]ProductBook, error) {
+	var response BazaarResponse
+	if err := fetchData(bazaarURL, &response); err != nil {
+		return nil, err
+	}
+
+	book := make(map[string]ProductBook, len(response.Products))
+	for itemID, details := range response.Products {
+		book[itemID] = ProductBook{
+			BuySummary:  details.BuySummary,
+			SellSummary: details.SellSummary,
+		}
+	}
+	return book, nil
+}
+
+// SetTransactionMetrics installs the transaction-frequency/size snapshot
+// PriceForQuantity uses to estimate estFillSeconds. Passing nil (the
+// default) makes PriceForQuantity report 0 for estFillSeconds rather than
+// fail - fill-time estimation is best-effort, not required for the
+// cost/slippage half of PriceForQuantity's result.
+func SetTransactionMetrics(m map[string]TransactionMetrics) {
+	latestTxMetrics = m
+}
+
+// walkOrderBook consumes levels (already sorted best-price-first by the
+// caller) until qty is filled, returning the volume-weighted average price
+// actually paid and the fraction that price sits above the best available
+// level (slippage). If the book runs out before qty is filled, the
+// remainder is priced at the worst (last) level rather than understating
+// the true cost.
+func walkOrderBook(levels []OrderBookEntry, qty float64) (vwap float64, slippage float64, err error) {
+	if len(levels) == 0 {
+		return 0, 0, fmt.Errorf("empty order book")
+	}
+
+	bestPrice := levels[0].PricePerUnit
+
+	remaining := qty
+	var totalCost, filled float64
+	for _, level := range levels {
+		if remaining <= 0 {
+			break
+		}
+		take := level.AmountLeft
+		if take > remaining {
+			take = remaining
+		}
+		totalCost += take * level.PricePerUnit
+		filled += take
+		remaining -= take
+	}
+	if remaining > 0 {
+		worst := levels[len(levels)-1].PricePerUnit
+		totalCost += remaining * worst
+		filled += remaining
+	}
+	if filled <= 0 {
+		return 0, 0, fmt.Errorf("order book has no fillable volume")
+	}
+
+	vwap = totalCost / filled
+	if bestPrice > 0 {
+		slippage = (vwap - bestPrice) / bestPrice
+	}
+	return vwap, slippage, nil
+}
+
+// PriceForQuantity computes the true volume-weighted average cost of
+// instabuying qty units of itemID (walking latestOrderBook's sell_summary -
+// the standing sell offers an instabuy consumes - rather than assuming the
+// top-of-book quick_status.buyPrice is available for the whole quantity),
+// how far that VWAP sits above the best available price (slippage, 0 =
+// none), and how long a buy order placed at the top of the book instead -
+// the zero-slippage alternative to instabuying through the book - would
+// take to fill, from latestTxMetrics' historical transaction frequency/size
+// (0 when no metrics are installed; see SetTransactionMetrics).
+func PriceForQuantity(itemID string, qty int) (unitCost float64, slippage float64, estFillSeconds float64, err error) {
+	product, ok := latestOrderBook[itemID]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("no order book loaded for %s", itemID)
+	}
+
+	levels := append([]OrderBookEntry(nil), product.SellSummary...)
+	sort.Slice(levels, func(i, j int) bool { return levels[i].PricePerUnit < levels[j].PricePerUnit })
+	unitCost, slippage, err = walkOrderBook(levels, float64(qty))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %w", itemID, err)
+	}
+
+	if tm, ok := latestTxMetrics[itemID]; ok && tm.Frequency > 0 && tm.SizeAverage > 0 {
+		estFillSeconds = float64(qty) / (tm.Frequency * tm.SizeAverage)
+	}
+	return unitCost, slippage, estFillSeconds, nil
+}
+
+// sellRevenueForQuantity is PriceForQuantity's revenue-side counterpart:
+// the volume-weighted average price realized instaselling qty units of
+// itemID, walking buy_summary (the standing buy orders an instasell
+// consumes) instead of sell_summary. calculateProfit uses this to price a
+// craft's output at a realistic bulk quantity instead of assuming the
+// top-of-book sellPrice holds for the whole batch.
+func sellRevenueForQuantity(itemID string, qty int) (unitRevenue float64, slippage float64, err error) {
+	product, ok := latestOrderBook[itemID]
+	if !ok {
+		return 0, 0, fmt.Errorf("no order book loaded for %s", itemID)
+	}
+
+	levels := append([]OrderBookEntry(nil), product.BuySummary...)
+	sort.Slice(levels, func(i, j int) bool { return levels[i].PricePerUnit > levels[j].PricePerUnit })
+	unitRevenue, slippage, err = walkOrderBook(levels, float64(qty))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", itemID, err)
+	}
+	return unitRevenue, slippage, nil
+}
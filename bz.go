@@ -3,17 +3,17 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"sort"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	dataURL      = "https://raw.githubusercontent.com/GiantWizard/Wiz/main/Wiz/data.json"
-	bazaarURL    = "https://api.hypixel.net/skyblock/bazaar"
-	lowestBinURL = "http://moulberry.codes/lowestbin.json"
+	dataURL           = "https://raw.githubusercontent.com/GiantWizard/Wiz/main/Wiz/data.json"
+	bazaarURL         = "https://api.hypixel.net/skyblock/bazaar"
+	lowestBinURL      = "http://moulberry.codes/lowestbin.json"
+	coflnetHistoryURL = "https://sky.coflnet.com/api/bazaar/%s/summary"
 )
 
 type ItemData struct {
@@ -21,9 +21,24 @@ type ItemData struct {
 	Recipe map[string]json.RawMessage `json:"recipe"`
 }
 
+// PriceData is one item's price as reported by a single PriceSource. Source
+// and ObservedAt let a caller tell two PriceData values for the same item
+// apart (which source won, how stale it is); Confidence is that source's own
+// estimate of how trustworthy the quote is, in [0, 1].
 type PriceData struct {
-	Price  float64 `json:"price"`
-	Method string  `json:"method"`
+	Price      float64 `json:"price"`
+	Method     string  `json:"method"`
+	Source     string  `json:"source"`
+	ObservedAt int64   `json:"observed_at"`
+	Confidence float64 `json:"confidence"`
+}
+
+// OrderBookEntry is one price level of a Bazaar order book array
+// (buy_summary or sell_summary) as returned by the Hypixel Bazaar endpoint.
+type OrderBookEntry struct {
+	AmountLeft   float64 `json:"amount"`
+	PricePerUnit float64 `json:"pricePerUnit"`
+	Orders       int     `json:"orders"`
 }
 
 type BazaarResponse struct {
@@ -34,6 +49,14 @@ type BazaarResponse struct {
 			SellMovingWeek int     `json:"sellMovingWeek"`
 			BuyMovingWeek  int     `json:"buyMovingWeek"`
 		} `json:"quick_status"`
+		// BuySummary is the standing buy orders (what an instasell consumes,
+		// highest price first); SellSummary is the standing sell offers
+		// (what an instabuy consumes, lowest price first). Both collapse to
+		// a single number in QuickStatus.BuyPrice/SellPrice - PriceForQuantity
+		// (bazaar_orderbook.go) walks the full arrays instead for a realistic
+		// cost/revenue at a quantity beyond the top-of-book depth.
+		BuySummary  []OrderBookEntry `json:"buy_summary"`
+		SellSummary []OrderBookEntry `json:"sell_summary"`
 	} `json:"products"`
 }
 
@@ -45,14 +68,13 @@ type ProfitData struct {
 	SellPrice     float64
 }
 
-// Fetches the JSON data from the provided URL
+// fetchData fetches the JSON data from the provided URL through the shared,
+// rate-limited, retrying apiHTTPClient (http_client.go) rather than calling
+// http.Get directly, so every caller - BazaarPriceSource,
+// MoulberryLbinPriceSource, fetchLowestBINPrices, fetchOrderBook - gets the
+// same backoff/retry/ETag-caching/per-host-budget/metrics behavior.
 func fetchData(url string, target interface{}) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := defaultAPIClient().Get(url)
 	if err != nil {
 		return err
 	}
@@ -77,131 +99,557 @@ func parseRecipeValue(raw json.RawMessage) (string, error) {
 	return "", fmt.Errorf("unknown recipe value format")
 }
 
-// Fetches Bazaar prices and returns a map of prices
-func fetchBazaarPrices() (map[string]PriceData, error) {
+// movingWeekConfidence scores how much to trust a Bazaar quote from its
+// sellMovingWeek/buyMovingWeek volumes: confidence scales linearly with
+// whichever side of the order book moved less over the week (the thinner
+// side is the one that actually constrains how much of the quote you can
+// fill), saturating at 1.0 once that side clears liquidityForFullConfidence.
+func movingWeekConfidence(sellMovingWeek, buyMovingWeek int) float64 {
+	const liquidityForFullConfidence = 10000.0
+	liquidity := float64(sellMovingWeek)
+	if buyMovingWeek < sellMovingWeek {
+		liquidity = float64(buyMovingWeek)
+	}
+	confidence := liquidity / liquidityForFullConfidence
+	if confidence > 1 {
+		confidence = 1
+	}
+	if confidence < 0 {
+		confidence = 0
+	}
+	return confidence
+}
+
+// PriceSource is one backend PriceAggregator can query for item prices. A
+// concrete implementation wraps a single upstream endpoint and returns an
+// error (never partial data with a nil error) on any failure, so the
+// aggregator's circuit breaker can count failures per source.
+type PriceSource interface {
+	Name() string
+	FetchPrices() (map[string]PriceData, error)
+
+	// Namespace lists the itemIDs this source can quote, or nil if it quotes
+	// from a bulk snapshot covering whatever the upstream endpoint returns
+	// (Bazaar, Moulberry lbin, the NPC table) rather than a fixed, caller-
+	// supplied set (CoflnetPriceSource, which is queried per item).
+	Namespace() []string
+	// Freshness is how long a quote from this source should be trusted
+	// before a caller ought to treat it as stale, independent of whatever
+	// Confidence FetchPrices scored it with.
+	Freshness() time.Duration
+}
+
+// BazaarPriceSource is the Hypixel Bazaar quick_status endpoint - the same
+// data fetchBazaarPrices used to return directly before price fetching grew
+// a fallback chain.
+type BazaarPriceSource struct{}
+
+func (BazaarPriceSource) Name() string { return "bazaar" }
+
+func (BazaarPriceSource) Namespace() []string { return nil }
+
+// bazaarFreshness matches how often Hypixel's Bazaar endpoint itself updates.
+const bazaarFreshness = 30 * time.Second
+
+func (BazaarPriceSource) Freshness() time.Duration { return bazaarFreshness }
+
+func (BazaarPriceSource) FetchPrices() (map[string]PriceData, error) {
 	var response BazaarResponse
-	err := fetchData(bazaarURL, &response)
-	if err != nil {
+	if err := fetchData(bazaarURL, &response); err != nil {
 		return nil, err
 	}
 
+	now := time.Now().Unix()
 	prices := make(map[string]PriceData)
 	for itemID, details := range response.Products {
 		quickStatus := details.QuickStatus
 		buyPrice := quickStatus.BuyPrice
 		sellPrice := quickStatus.SellPrice
+		if buyPrice <= 0 || sellPrice <= 0 {
+			continue
+		}
 
-		if buyPrice > 0 && sellPrice > 0 {
-			method := "Instabuy"
-			if buyPrice/sellPrice >= 1.07 {
-				method = "Buy Order"
-			}
-			prices[itemID] = PriceData{
-				Price:  buyPrice,
-				Method: method,
-			}
+		method := "Instabuy"
+		if buyPrice/sellPrice >= 1.07 {
+			method = "Buy Order"
+		}
+		prices[itemID] = PriceData{
+			Price:      buyPrice,
+			Method:     method,
+			Source:     "bazaar",
+			ObservedAt: now,
+			Confidence: movingWeekConfidence(quickStatus.SellMovingWeek, quickStatus.BuyMovingWeek),
 		}
 	}
 	return prices, nil
 }
 
-// Fetches lowest BIN prices and returns a map
-func fetchLowestBINPrices() (map[string]float64, error) {
+// MoulberryLbinPriceSource is Moulberry's lowest-BIN snapshot. It carries no
+// moving-week volume, so its Confidence is a fixed, middling constant rather
+// than one derived from liquidity like BazaarPriceSource's.
+type MoulberryLbinPriceSource struct{}
+
+func (MoulberryLbinPriceSource) Name() string { return "moulberry_lbin" }
+
+func (MoulberryLbinPriceSource) Namespace() []string { return nil }
+
+// lbinFreshness is longer than bazaarFreshness since Moulberry's snapshot is
+// itself only regenerated every few minutes.
+const lbinFreshness = 5 * time.Minute
+
+func (MoulberryLbinPriceSource) Freshness() time.Duration { return lbinFreshness }
+
+// lbinConfidence is fixed because the lowestbin.json snapshot reports no
+// volume data to derive a per-item confidence from.
+const lbinConfidence = 0.5
+
+func (MoulberryLbinPriceSource) FetchPrices() (map[string]PriceData, error) {
 	var lbinData map[string]float64
-	err := fetchData(lowestBinURL, &lbinData)
-	return lbinData, err
+	if err := fetchData(lowestBinURL, &lbinData); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	prices := make(map[string]PriceData, len(lbinData))
+	for itemID, price := range lbinData {
+		if price <= 0 {
+			continue
+		}
+		prices[itemID] = PriceData{
+			Price:      price,
+			Method:     "Lowest BIN",
+			Source:     "moulberry_lbin",
+			ObservedAt: now,
+			Confidence: lbinConfidence,
+		}
+	}
+	return prices, nil
+}
+
+// CoflnetPriceSource is Coflnet's per-item bazaar summary history endpoint -
+// an independent observer of the same Bazaar, useful as a fallback when
+// Hypixel's own endpoint is down and as a cross-check for inter-source
+// agreement.
+type CoflnetPriceSource struct {
+	// ItemIDs is which items to query; Coflnet's history endpoint is
+	// per-item, unlike Bazaar/Moulberry's single bulk snapshot.
+	ItemIDs []string
+}
+
+func (CoflnetPriceSource) Name() string { return "coflnet_history" }
+
+func (c CoflnetPriceSource) Namespace() []string { return c.ItemIDs }
+
+// coflnetFreshness is longer still - this is a derived summary history
+// endpoint, not a live order-book quote.
+const coflnetFreshness = 10 * time.Minute
+
+func (CoflnetPriceSource) Freshness() time.Duration { return coflnetFreshness }
+
+func (c CoflnetPriceSource) FetchPrices() (map[string]PriceData, error) {
+	now := time.Now().Unix()
+	prices := make(map[string]PriceData, len(c.ItemIDs))
+	for _, itemID := range c.ItemIDs {
+		var summary struct {
+			Max float64 `json:"max"`
+			Min float64 `json:"min"`
+		}
+		url := fmt.Sprintf(coflnetHistoryURL, itemID)
+		if err := fetchData(url, &summary); err != nil {
+			return nil, err
+		}
+		if summary.Max <= 0 {
+			continue
+		}
+		prices[itemID] = PriceData{
+			Price:      summary.Max,
+			Method:     "Coflnet History",
+			Source:     "coflnet_history",
+			ObservedAt: now,
+			// No moving-week volume is exposed by this endpoint either;
+			// weighted slightly below the lbin snapshot since it's a
+			// derived average rather than a live order-book quote.
+			Confidence: 0.4,
+		}
+	}
+	return prices, nil
 }
 
-// Builds a recipe tree recursively
-func buildRecipeTree(data map[string]ItemData, itemID string, prices map[string]PriceData, lbinData map[string]float64, visited map[string]bool) (map[string]interface{}, error) {
-	if visited[itemID] {
-		return map[string]interface{}{"name": itemID, "note": "cycle detected"}, nil
+// npcSellPrices are the small set of base materials Hypixel sells at a fixed
+// NPC price rather than through the Bazaar; calculateProfit falls back to
+// these when neither Bazaar nor lbin/Coflnet have a quote for an ingredient.
+var npcSellPrices = map[string]float64{
+	"COBBLESTONE": 2,
+	"DIRT":        2,
+	"SAND":        4,
+	"NETHERRACK":  2.5,
+	"ICE":         20,
+}
+
+// NPCPriceSource wraps the fixed npcSellPrices table. Confidence is always
+// 1.0 - NPC prices don't fluctuate and never go stale.
+type NPCPriceSource struct{}
+
+func (NPCPriceSource) Name() string { return "npc_sell" }
+
+func (NPCPriceSource) Namespace() []string {
+	ids := make([]string, 0, len(npcSellPrices))
+	for id := range npcSellPrices {
+		ids = append(ids, id)
 	}
+	return ids
+}
 
-	item, exists := data[itemID]
-	if !exists {
-		price := prices[itemID].Price
-		if price == 0 {
-			price = lbinData[itemID]
+// NPC prices don't expire - Hypixel's NPC shop prices are static.
+func (NPCPriceSource) Freshness() time.Duration { return 0 }
+
+func (NPCPriceSource) FetchPrices() (map[string]PriceData, error) {
+	now := time.Now().Unix()
+	prices := make(map[string]PriceData, len(npcSellPrices))
+	for itemID, price := range npcSellPrices {
+		prices[itemID] = PriceData{
+			Price:      price,
+			Method:     "NPC Sell",
+			Source:     "npc_sell",
+			ObservedAt: now,
+			Confidence: 1.0,
 		}
-		return map[string]interface{}{"name": itemID, "note": "base item", "cost": price}, nil
 	}
+	return prices, nil
+}
 
-	visited[itemID] = true
-	tree := map[string]interface{}{"name": itemID, "children": []map[string]interface{}{}, "count": 1}
-	var totalCost float64
+// circuitBreaker trips a PriceSource after circuitBreakerFailureLimit
+// consecutive FetchPrices failures and keeps it tripped for
+// circuitBreakerOpenDuration, so one dead endpoint can't stall
+// PriceAggregator.FetchAll on every call while it's down.
+type circuitBreaker struct {
+	consecutiveFailures int
+	trippedAt           time.Time
+}
 
-	for ing, rawCount := range item.Recipe {
-		countStr, err := parseRecipeValue(rawCount)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse recipe value for %s: %v", ing, err)
+const (
+	circuitBreakerFailureLimit = 3
+	circuitBreakerOpenDuration = 60 * time.Second
+)
+
+func (cb *circuitBreaker) allow() bool {
+	if cb.consecutiveFailures < circuitBreakerFailureLimit {
+		return true
+	}
+	return time.Since(cb.trippedAt) > circuitBreakerOpenDuration
+}
+
+func (cb *circuitBreaker) recordSuccess() {
+	cb.consecutiveFailures = 0
+}
+
+func (cb *circuitBreaker) recordFailure() {
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures == circuitBreakerFailureLimit {
+		cb.trippedAt = time.Now()
+	}
+}
+
+// PriceAggregator queries a configurable priority chain of PriceSources,
+// skipping any source whose circuit breaker is open or whose FetchPrices
+// call fails, and merges the rest: the first source to report an item wins
+// unless a later source reports the same item with a higher Confidence.
+type PriceAggregator struct {
+	sources  []PriceSource
+	breakers map[string]*circuitBreaker
+}
+
+// NewPriceAggregator builds a PriceAggregator that queries sources in the
+// order given - earlier sources are preferred, later ones are fallbacks.
+func NewPriceAggregator(sources ...PriceSource) *PriceAggregator {
+	breakers := make(map[string]*circuitBreaker, len(sources))
+	for _, s := range sources {
+		breakers[s.Name()] = &circuitBreaker{}
+	}
+	return &PriceAggregator{sources: sources, breakers: breakers}
+}
+
+// FetchAll queries every source in priority order and merges their results.
+// A source skipped by its circuit breaker or that errors contributes nothing
+// to the merged map rather than failing the whole call.
+func (pa *PriceAggregator) FetchAll() map[string]PriceData {
+	merged := make(map[string]PriceData)
+	for _, src := range pa.sources {
+		cb := pa.breakers[src.Name()]
+		if cb != nil && !cb.allow() {
+			continue
 		}
-		count, _ := strconv.Atoi(countStr)
-		subTree, err := buildRecipeTree(data, ing, prices, lbinData, visited)
+
+		prices, err := src.FetchPrices()
 		if err != nil {
-			return nil, err
+			if cb != nil {
+				cb.recordFailure()
+			}
+			continue
+		}
+		if cb != nil {
+			cb.recordSuccess()
 		}
-		subTree["count"] = count
-		tree["children"] = append(tree["children"].([]map[string]interface{}), subTree)
 
-		subPrice := prices[ing].Price
-		if subPrice == 0 {
-			subPrice = lbinData[ing]
+		for itemID, pd := range prices {
+			existing, ok := merged[itemID]
+			if !ok || pd.Confidence > existing.Confidence {
+				merged[itemID] = pd
+			}
 		}
-		totalCost += subPrice * float64(count)
 	}
+	return merged
+}
+
+// breakerFor returns pa's circuit breaker for name, creating one on first
+// use. NewPriceAggregator only pre-populates breakers for the sources it was
+// built with; FetchAllRegistered can be handed sources registered later via
+// RegisterPriceSource, so it needs this to grow the map lazily instead.
+func (pa *PriceAggregator) breakerFor(name string) *circuitBreaker {
+	if cb, ok := pa.breakers[name]; ok {
+		return cb
+	}
+	if pa.breakers == nil {
+		pa.breakers = make(map[string]*circuitBreaker)
+	}
+	cb := &circuitBreaker{}
+	pa.breakers[name] = cb
+	return cb
+}
+
+var (
+	priceSourceRegistryMu sync.Mutex
+	priceSourceRegistry   = map[string]PriceSource{}
+)
+
+// RegisterPriceSource makes src queryable by FetchAllRegistered under name,
+// letting a deployment plug in additional Skyblock price feeds (a Coflnet
+// median-auction-price source, NEU repo constants, a sky.coflnet BIN feed)
+// without changing PriceAggregator or getPriceFromCache. Re-registering an
+// existing name replaces it.
+func RegisterPriceSource(name string, src PriceSource) {
+	priceSourceRegistryMu.Lock()
+	defer priceSourceRegistryMu.Unlock()
+	priceSourceRegistry[name] = src
+}
+
+func init() {
+	// CoflnetPriceSource is omitted from auto-registration for the same
+	// reason main() omits it from NewPriceAggregator: it needs ItemIDs set
+	// before it can query anything, so a caller registers its own instance.
+	RegisterPriceSource("bazaar", BazaarPriceSource{})
+	RegisterPriceSource("moulberry_lbin", MoulberryLbinPriceSource{})
+	RegisterPriceSource("npc_sell", NPCPriceSource{})
+}
 
-	tree["cost"] = totalCost
-	visited[itemID] = false
-	return tree, nil
+// priceSourceResult is one registered source's FetchPrices outcome, carried
+// back over a channel so FetchAllRegistered can collect every source's
+// result as soon as it arrives rather than waiting on them in sequence.
+type priceSourceResult struct {
+	name   string
+	prices map[string]PriceData
+	err    error
 }
 
-// Prints the recipe tree recursively with formatting
-func printRecipeTree(tree map[string]interface{}, level int, multiplier int) {
-	indent := strings.Repeat("  ", level)
-	note := ""
-	if n, ok := tree["note"].(string); ok {
-		note = fmt.Sprintf(" (%s)", n)
+// PriceQuotes holds every registered source's quote for an item, keyed by
+// itemID, so a caller can apply a PriceResolutionPolicy after the fact
+// instead of FetchAllRegistered baking "highest confidence wins" in at
+// fetch time the way FetchAll does.
+type PriceQuotes map[string][]PriceData
+
+// FetchAllRegistered queries every source RegisterPriceSource has registered
+// concurrently - one goroutine per source reporting back over a shared
+// channel, the same fetch-in-parallel/collect-over-a-channel pattern
+// PriceCache.update (list.go) uses for its two bulk endpoints - so one slow
+// or dead source's latency doesn't add to every other source's. Sources
+// whose circuit breaker is open are skipped, same as FetchAll.
+func (pa *PriceAggregator) FetchAllRegistered() PriceQuotes {
+	priceSourceRegistryMu.Lock()
+	sources := make([]PriceSource, 0, len(priceSourceRegistry))
+	for _, src := range priceSourceRegistry {
+		sources = append(sources, src)
 	}
+	priceSourceRegistryMu.Unlock()
+
+	resultChan := make(chan priceSourceResult, len(sources))
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		if cb := pa.breakerFor(src.Name()); !cb.allow() {
+			continue
+		}
+		wg.Add(1)
+		go func(src PriceSource) {
+			defer wg.Done()
+			prices, err := src.FetchPrices()
+			resultChan <- priceSourceResult{name: src.Name(), prices: prices, err: err}
+		}(src)
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	quotes := make(PriceQuotes)
+	for res := range resultChan {
+		cb := pa.breakerFor(res.name)
+		if res.err != nil {
+			cb.recordFailure()
+			continue
+		}
+		cb.recordSuccess()
+		for itemID, pd := range res.prices {
+			quotes[itemID] = append(quotes[itemID], pd)
+		}
+	}
+	return quotes
+}
+
+// PriceResolutionMode selects how getPriceFromCache picks a winner among a
+// PriceQuotes entry's candidates when more than one source has quoted an
+// item.
+type PriceResolutionMode int
 
-	count := tree["count"].(int) * multiplier
-	cost := tree["cost"].(float64)
+const (
+	// ResolveByConfidence picks the candidate with the highest Confidence -
+	// FetchAll's own merge behavior, exposed here so a caller resolving
+	// against a PriceQuotes map gets the same answer by default.
+	ResolveByConfidence PriceResolutionMode = iota
+	// ResolveByPriority picks the first candidate whose Source appears in
+	// PriorityOrder, trying PriorityOrder's entries in order.
+	ResolveByPriority
+	// ResolveCheapest picks whichever candidate quoted the lowest Price.
+	ResolveCheapest
+	// ResolveMostRecent picks whichever candidate's ObservedAt is newest.
+	ResolveMostRecent
+)
 
-	fmt.Printf("%s- %s x%d, Cost: %.2f%s\n", indent, tree["name"], count, cost, note)
+// PriceResolutionPolicy configures getPriceFromCache's resolution among a
+// PriceQuotes entry's candidates. PriorityOrder is only consulted under
+// ResolveByPriority, and falls back to ResolveByConfidence if none of its
+// names match any candidate.
+type PriceResolutionPolicy struct {
+	Mode          PriceResolutionMode
+	PriorityOrder []string
+}
+
+// DefaultPriceResolutionPolicy resolves by confidence, matching the merge
+// behavior FetchAll has always had.
+var DefaultPriceResolutionPolicy = PriceResolutionPolicy{Mode: ResolveByConfidence}
+
+// getPriceFromCache resolves itemID's price from quotes (see
+// PriceAggregator.FetchAllRegistered) by applying policy, returning
+// (price, method, source) the way the old hard-coded Bazaar-then-lowest-bin
+// lookup did. It returns (0, "", "") if no registered source quoted the
+// item at all.
+func getPriceFromCache(itemID string, quotes PriceQuotes, policy PriceResolutionPolicy) (float64, string, string) {
+	candidates := quotes[itemID]
+	if len(candidates) == 0 {
+		return 0, "", ""
+	}
 
-	if children, ok := tree["children"].([]map[string]interface{}); ok {
-		for _, child := range children {
-			printRecipeTree(child, level+1, count)
+	winner := candidates[0]
+	switch policy.Mode {
+	case ResolveByPriority:
+		resolved := false
+		for _, name := range policy.PriorityOrder {
+			for _, pd := range candidates {
+				if pd.Source == name {
+					winner = pd
+					resolved = true
+					break
+				}
+			}
+			if resolved {
+				break
+			}
+		}
+		if resolved {
+			break
+		}
+		fallthrough
+	default: // ResolveByConfidence, and ResolveByPriority's no-match fallback
+		for _, pd := range candidates[1:] {
+			if pd.Confidence > winner.Confidence {
+				winner = pd
+			}
+		}
+	case ResolveCheapest:
+		for _, pd := range candidates[1:] {
+			if pd.Price < winner.Price {
+				winner = pd
+			}
+		}
+	case ResolveMostRecent:
+		for _, pd := range candidates[1:] {
+			if pd.ObservedAt > winner.ObservedAt {
+				winner = pd
+			}
 		}
 	}
+	return winner.Price, winner.Method, winner.Source
 }
 
-// Function to separate lines and format output
-func printFormattedTree(tree map[string]interface{}) {
-	fmt.Println("\n--- Recipe Tree ---")
-	printRecipeTree(tree, 0, 1)
-	fmt.Println("--- End of Tree ---\n")
+// Fetches lowest BIN prices and returns a map. Kept alongside
+// MoulberryLbinPriceSource for callers (buildRecipeTree's fallback pricing)
+// that want the raw float map rather than a confidence-scored PriceData.
+func fetchLowestBINPrices() (map[string]float64, error) {
+	var lbinData map[string]float64
+	err := fetchData(lowestBinURL, &lbinData)
+	return lbinData, err
 }
 
-// Calculates profit and returns the top 20 most profitable crafts
+// minPriceConfidenceForProfit filters out items whose aggregated PriceData
+// confidence is too low to trust for a profit calculation - a thin order
+// book can quote a price that nominally clears the profit threshold below
+// but can't actually be filled at that price in any real quantity.
+const minPriceConfidenceForProfit = 0.2
+
+// bulkEvaluationQuantity is the quantity calculateProfit re-checks profit
+// at via sellRevenueForQuantity (one stack) - a craft that only looks
+// profitable against the top-of-book sellPrice can still lose money once
+// instaselling 64 of it walks down the buy_summary book.
+const bulkEvaluationQuantity = 64
+
+// Calculates profit and returns the top 20 most profitable crafts. Crafting
+// costs come from solveCheapestCosts (dp_recipe_solver.go), computed once
+// for the whole item graph rather than once per itemID the way the old
+// recursive buildRecipeTree did. A craft's sell price is re-evaluated at
+// bulkEvaluationQuantity via sellRevenueForQuantity (bazaar_orderbook.go)
+// when an order book has been loaded, falling back to the top-of-book
+// PriceData.Price otherwise.
 func calculateProfit(data map[string]ItemData, prices map[string]PriceData, lbinData map[string]float64) []ProfitData {
+	costs := solveCheapestCosts(data, prices, lbinData)
+
 	var profits []ProfitData
 	for itemID := range data {
-		tree, _ := buildRecipeTree(data, itemID, prices, lbinData, map[string]bool{})
-		craftingCost := tree["cost"].(float64)
-		bazaarPrice := prices[itemID].Price
-
-		if bazaarPrice > 50000 && craftingCost < bazaarPrice {
-			profit := bazaarPrice - craftingCost
-			profitPercent := int((bazaarPrice - craftingCost) / craftingCost * 100)
-			profits = append(profits, ProfitData{
-				ItemID:        itemID,
-				Profit:        profit,
-				ProfitPercent: profitPercent,
-				CraftingCost:  craftingCost,
-				SellPrice:     bazaarPrice,
-			})
+		craftingCost := costs[itemID].Cost
+		priceInfo := prices[itemID]
+		bazaarPrice := priceInfo.Price
+
+		if priceInfo.Source != "" && priceInfo.Confidence < minPriceConfidenceForProfit {
+			continue
 		}
+		if bazaarPrice <= 50000 || craftingCost >= bazaarPrice {
+			continue
+		}
+
+		sellPrice := bazaarPrice
+		if unitRevenue, _, err := sellRevenueForQuantity(itemID, bulkEvaluationQuantity); err == nil {
+			sellPrice = unitRevenue
+		}
+		if sellPrice <= craftingCost {
+			continue // profit vanished once bulk-sell slippage is accounted for
+		}
+
+		profit := sellPrice - craftingCost
+		profitPercent := int(profit / craftingCost * 100)
+		profits = append(profits, ProfitData{
+			ItemID:        itemID,
+			Profit:        profit,
+			ProfitPercent: profitPercent,
+			CraftingCost:  craftingCost,
+			SellPrice:     sellPrice,
+		})
 	}
 	sort.Slice(profits, func(i, j int) bool {
 		return profits[i].ProfitPercent > profits[j].ProfitPercent
@@ -217,11 +665,15 @@ func main() {
 		return
 	}
 
-	prices, err := fetchBazaarPrices()
-	if err != nil {
-		fmt.Println("Failed to fetch Bazaar prices:", err)
-		return
-	}
+	// CoflnetPriceSource is omitted here - it's queried per-item rather than
+	// as a bulk snapshot, so it only makes sense once the caller already
+	// knows which itemIDs it cares about (see CoflnetPriceSource.ItemIDs).
+	aggregator := NewPriceAggregator(
+		BazaarPriceSource{},
+		MoulberryLbinPriceSource{},
+		NPCPriceSource{},
+	)
+	prices := aggregator.FetchAll()
 
 	lbinData, err := fetchLowestBINPrices()
 	if err != nil {
@@ -229,6 +681,12 @@ func main() {
 		return
 	}
 
+	if book, err := fetchOrderBook(); err != nil {
+		fmt.Println("Failed to fetch order book (bulk profit checks will fall back to top-of-book prices):", err)
+	} else {
+		latestOrderBook = book
+	}
+
 	topCrafts := calculateProfit(data, prices, lbinData)
 	fmt.Println("Top 20 Most Profitable Crafts:")
 	for _, craft := range topCrafts {
@@ -237,11 +695,12 @@ func main() {
 	}
 
 	itemID := "SOME_ITEM_ID" // Replace with desired item ID or take user input
-	recipeTree, err := buildRecipeTree(data, itemID, prices, lbinData, map[string]bool{})
-	if err != nil {
-		fmt.Println("Failed to build recipe tree:", err)
-		return
-	}
+	costs := solveCheapestCosts(data, prices, lbinData)
+	plan := CheapestPlan(data, costs, itemID, 1)
 
-	printFormattedTree(recipeTree)
+	fmt.Printf("\n--- Cheapest Plan: %s ---\n", plan.ItemID)
+	for _, line := range plan.ShoppingList {
+		fmt.Printf("- %s x%.0f @ %.2f = %.2f\n", line.ItemID, line.Quantity, line.UnitCost, line.Total)
+	}
+	fmt.Printf("Total cost: %.2f\n--- End of Plan ---\n", plan.TotalCost)
 }
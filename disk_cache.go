@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// This file persists PriceCache's recipe trees and price snapshot to disk
+// between runs, so initialize() (list.go) can skip its bazaar/bins HTTP
+// fetches on a warm start. A real "cache" subpackage isn't practical here -
+// this tree has no go.mod anywhere, so there's no module path for a
+// subpackage import to resolve against - so this lives as a flat
+// package-main file like everything else here, gob-encoded on disk like
+// the existing price_estimator.go/history_store.go persistence.
+
+const (
+	// diskCacheSchemaVersion bumps whenever PersistedSnapshot's shape
+	// changes incompatibly, so loadSnapshot can reject an on-disk file
+	// from an older build instead of gob-decoding it into the wrong
+	// fields.
+	diskCacheSchemaVersion = 1
+	// diskCachePersistPath is where saveSnapshot/loadSnapshot gob-encode
+	// the persisted snapshot.
+	diskCachePersistPath = "recipe_cache.gob"
+	// maxPersistedTrees caps how many recipe trees saveSnapshot writes
+	// out, taking the most-recently-used ones from RecipeTreeCache.Snapshot
+	// so disk usage doesn't grow with every item ever looked up.
+	maxPersistedTrees = 1000
+)
+
+// persistedTree is one cached RecipeTree plus the sha256 of the recipe
+// slots it was built from, so loadSnapshot can tell a tree apart from a
+// stale one built before an upstream recipe definition changed.
+type persistedTree struct {
+	Tree     *RecipeTree
+	ItemHash string
+}
+
+// PersistedSnapshot is disk_cache.go's on-disk schema: everything
+// initialize() needs to skip a cold bazaar/bins fetch, keyed by Version
+// and ItemsHash so a schema change or an items database update
+// invalidates it wholesale.
+type PersistedSnapshot struct {
+	Version    int
+	ItemsHash  string
+	LastUpdate time.Time
+	BazaarData BazaarResponse
+	LowestBins LowestBinData
+	Trees      map[string]persistedTree
+}
+
+// computeItemHash hashes one item's recipe slots (the part an upstream
+// recipe edit would change), so a stale tree can be detected even when
+// the rest of the items database is unchanged.
+func computeItemHash(recipe Recipe) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s|%s|%s|%s|%s|%v",
+		recipe.A1, recipe.A2, recipe.A3,
+		recipe.B1, recipe.B2, recipe.B3,
+		recipe.C1, recipe.C2, recipe.C3,
+		recipe.Count)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// computeItemsHash hashes every item's ID and computeItemHash together, in
+// sorted-ID order so map iteration order doesn't change the result, giving
+// PersistedSnapshot.Valid a single content hash of the whole items
+// database.
+func computeItemsHash(items ItemDatabase) string {
+	ids := make([]string, 0, len(items))
+	for id := range items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s:%s\n", id, computeItemHash(items[id].Recipe))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// saveSnapshot gob-encodes a PersistedSnapshot of c's current price data
+// and its most-recently-used recipe trees (capped at maxPersistedTrees) to
+// path.
+func saveSnapshot(path string, items ItemDatabase, c *PriceCache) error {
+	c.mu.RLock()
+	bazaarData := c.bazaarData
+	lowestBins := c.lowestBins
+	lastUpdate := c.lastUpdate
+	c.mu.RUnlock()
+
+	recent := c.recipeTrees.Snapshot(maxPersistedTrees)
+	trees := make(map[string]persistedTree, len(recent))
+	for itemID, tree := range recent {
+		trees[itemID] = persistedTree{
+			Tree:     tree,
+			ItemHash: computeItemHash(items[itemID].Recipe),
+		}
+	}
+
+	snapshot := PersistedSnapshot{
+		Version:    diskCacheSchemaVersion,
+		ItemsHash:  computeItemsHash(items),
+		LastUpdate: lastUpdate,
+		BazaarData: bazaarData,
+		LowestBins: lowestBins,
+		Trees:      trees,
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("saveSnapshot: creating %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(snapshot); err != nil {
+		return fmt.Errorf("saveSnapshot: encoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadSnapshot gob-decodes a prior saveSnapshot call from path. A missing
+// file is not an error - that's just a fresh process with nothing cached
+// yet - and returns (nil, nil) in that case.
+func loadSnapshot(path string) (*PersistedSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("loadSnapshot: opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var snapshot PersistedSnapshot
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("loadSnapshot: decoding %s: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// Valid reports whether s is still usable in place of a fresh bazaar/bins
+// fetch: its schema version and items content hash must match exactly,
+// and it must have been saved within cacheTimeout.
+func (s *PersistedSnapshot) Valid(itemsHash string, cacheTimeout time.Duration) bool {
+	if s == nil {
+		return false
+	}
+	if s.Version != diskCacheSchemaVersion {
+		return false
+	}
+	if s.ItemsHash != itemsHash {
+		return false
+	}
+	return time.Since(s.LastUpdate) < cacheTimeout
+}
+
+// ApplyTo restores s's price data into c and repopulates c.recipeTrees
+// with s's trees, skipping any whose ItemHash no longer matches items'
+// current recipe for that ID - a finer-grained check than ItemsHash alone,
+// in case a future caller relaxes Valid to tolerate partial drift.
+func (s *PersistedSnapshot) ApplyTo(c *PriceCache, items ItemDatabase) {
+	c.mu.Lock()
+	c.bazaarData = s.BazaarData
+	c.lowestBins = s.LowestBins
+	c.lastUpdate = s.LastUpdate
+	c.mu.Unlock()
+
+	for itemID, pt := range s.Trees {
+		item, exists := items[itemID]
+		if !exists || computeItemHash(item.Recipe) != pt.ItemHash {
+			continue
+		}
+		c.recipeTrees.Put(itemID, pt.Tree)
+	}
+}
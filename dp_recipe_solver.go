@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Decision records whether solveCheapestCosts/CheapestPlan chose to craft an
+// item from its recipe or buy it outright at its market price.
+type Decision int
+
+const (
+	DecisionBuy Decision = iota
+	DecisionCraft
+)
+
+func (d Decision) String() string {
+	if d == DecisionCraft {
+		return "craft"
+	}
+	return "buy"
+}
+
+// ItemCost is one item's minCost(item) = min(marketPrice(item), sum over
+// ingredients of count_i*minCost(ing_i)), memoized once per item by
+// solveCheapestCosts instead of recomputed per top-level item the way the
+// old recursive buildRecipeTree did. Cyclic is true for an item caught in a
+// recipe cycle (a Tarjan SCC of size > 1, or a self-loop) - buildRecipeTree
+// used to silently collapse these via its per-call visited map and treat a
+// cyclic ingredient as cost 0; solveCheapestCosts instead always buys a
+// cyclic item at its market price, since no valid crafting order exists
+// for it. Recipes have no recorded output yield in ItemData, so every
+// craft is assumed to produce 1 unit.
+type ItemCost struct {
+	ItemID   string
+	Cost     float64
+	Decision Decision
+	Cyclic   bool
+}
+
+// lookupPrice returns itemID's aggregated price, falling back to the
+// lowest-BIN snapshot when the aggregator has no quote - the fallback
+// buildRecipeTree and calculateProfit used to each inline separately.
+func lookupPrice(itemID string, prices map[string]PriceData, lbinData map[string]float64) float64 {
+	price := prices[itemID].Price
+	if price == 0 {
+		price = lbinData[itemID]
+	}
+	return price
+}
+
+// recipeIngredients parses item's recipe into ingredient itemID -> count.
+func recipeIngredients(item ItemData) (map[string]int, error) {
+	ingredients := make(map[string]int, len(item.Recipe))
+	for ing, rawCount := range item.Recipe {
+		countStr, err := parseRecipeValue(rawCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse recipe value for %s: %v", ing, err)
+		}
+		count, _ := strconv.Atoi(countStr)
+		ingredients[ing] = count
+	}
+	return ingredients, nil
+}
+
+// tarjanSCC computes the strongly connected components of the item
+// dependency graph (item -> ingredient edges, restricted to ingredients
+// that are themselves craftable in data - a base ingredient has no outgoing
+// edges and can't be part of a cycle), returning them in completion order.
+// For this edge direction, completion order is exactly
+// dependencies-before-dependents: an ingredient's SCC always completes
+// before the item that depends on it, which is the bottom-up order
+// solveCheapestCosts' DP needs.
+func tarjanSCC(data map[string]ItemData) [][]string {
+	type nodeState struct {
+		index   int
+		lowlink int
+		onStack bool
+	}
+
+	var (
+		indexCounter int
+		stack        []string
+		states       = make(map[string]*nodeState)
+		sccs         [][]string
+	)
+
+	var strongconnect func(v string)
+	strongconnect = func(v string) {
+		states[v] = &nodeState{index: indexCounter, lowlink: indexCounter, onStack: true}
+		indexCounter++
+		stack = append(stack, v)
+
+		if item, exists := data[v]; exists {
+			for ing := range item.Recipe {
+				if _, ok := data[ing]; !ok {
+					continue // base ingredient, not part of the craftable graph
+				}
+				if states[ing] == nil {
+					strongconnect(ing)
+					if states[ing].lowlink < states[v].lowlink {
+						states[v].lowlink = states[ing].lowlink
+					}
+				} else if states[ing].onStack {
+					if states[ing].index < states[v].lowlink {
+						states[v].lowlink = states[ing].index
+					}
+				}
+			}
+		}
+
+		if states[v].lowlink == states[v].index {
+			var scc []string
+			for {
+				n := len(stack) - 1
+				w := stack[n]
+				stack = stack[:n]
+				states[w].onStack = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+	}
+
+	// Sort item IDs before seeding the DFS so the SCCs found (and therefore
+	// solveCheapestCosts' results) don't depend on Go's randomized map
+	// iteration order between runs.
+	ids := make([]string, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if states[id] == nil {
+			strongconnect(id)
+		}
+	}
+	return sccs
+}
+
+// solveCheapestCosts computes minCost for every item in data in O(N+E):
+// build the dependency graph once, run Tarjan's SCC to find true cycles,
+// then evaluate items in the bottom-up order tarjanSCC already returns them
+// in, memoizing each item's cost exactly once. This replaces
+// buildRecipeTree's O(N*tree) work - recomputing every shared ingredient's
+// cost once per top-level item that uses it - with one pass over the graph.
+func solveCheapestCosts(data map[string]ItemData, prices map[string]PriceData, lbinData map[string]float64) map[string]ItemCost {
+	costs := make(map[string]ItemCost, len(data))
+	sccs := tarjanSCC(data)
+
+	for _, scc := range sccs {
+		cyclic := len(scc) > 1
+		if !cyclic {
+			if item, exists := data[scc[0]]; exists {
+				if ingredients, err := recipeIngredients(item); err == nil {
+					if _, ok := ingredients[scc[0]]; ok {
+						cyclic = true // self-loop
+					}
+				}
+			}
+		}
+
+		for _, itemID := range scc {
+			marketPrice := lookupPrice(itemID, prices, lbinData)
+
+			item, exists := data[itemID]
+			if !exists || cyclic {
+				costs[itemID] = ItemCost{ItemID: itemID, Cost: marketPrice, Decision: DecisionBuy, Cyclic: cyclic}
+				continue
+			}
+
+			ingredients, err := recipeIngredients(item)
+			if err != nil {
+				costs[itemID] = ItemCost{ItemID: itemID, Cost: marketPrice, Decision: DecisionBuy}
+				continue
+			}
+
+			var craftCost float64
+			for ing, count := range ingredients {
+				ingCost, ok := costs[ing]
+				if !ok {
+					// Ingredient is a base item never seen as a key of data
+					// (and so never visited by tarjanSCC); price it
+					// directly the same way marketPrice above was.
+					ingCost = ItemCost{ItemID: ing, Cost: lookupPrice(ing, prices, lbinData), Decision: DecisionBuy}
+				}
+				craftCost += ingCost.Cost * float64(count)
+			}
+
+			if marketPrice > 0 && marketPrice < craftCost {
+				costs[itemID] = ItemCost{ItemID: itemID, Cost: marketPrice, Decision: DecisionBuy}
+			} else {
+				costs[itemID] = ItemCost{ItemID: itemID, Cost: craftCost, Decision: DecisionCraft}
+			}
+		}
+	}
+
+	return costs
+}
+
+// ShoppingListEntry is one line of RecipePlan's flattened shopping list: a
+// base ingredient to buy, with the quantity accumulated across every branch
+// of the recipe tree that needs it.
+type ShoppingListEntry struct {
+	ItemID   string  `json:"item_id"`
+	Quantity float64 `json:"quantity"`
+	UnitCost float64 `json:"unit_cost"`
+	Total    float64 `json:"total"`
+}
+
+// RecipePlan is CheapestPlan's result: the total cost to obtain Quantity
+// units of ItemID the cheapest way solveCheapestCosts found, flattened into
+// an actionable shopping list of base ingredients - every intermediate item
+// CheapestPlan decided to craft is expanded into its own ingredients rather
+// than appearing as a shopping-list line itself.
+type RecipePlan struct {
+	ItemID       string              `json:"item_id"`
+	Quantity     float64             `json:"quantity"`
+	TotalCost    float64             `json:"total_cost"`
+	ShoppingList []ShoppingListEntry `json:"shopping_list"`
+}
+
+// CheapestPlan expands itemID's cost decision tree (costs, solveCheapestCosts'
+// output) down to a flat shopping list of base ingredients for quantity
+// units of itemID: wherever an item's Decision is Craft, CheapestPlan
+// recurses into its recipe instead of emitting a line for the item itself;
+// wherever it's Buy, CheapestPlan emits (or adds into, if already present)
+// one ShoppingListEntry.
+func CheapestPlan(data map[string]ItemData, costs map[string]ItemCost, itemID string, quantity float64) RecipePlan {
+	plan := RecipePlan{ItemID: itemID, Quantity: quantity}
+	lines := make(map[string]*ShoppingListEntry)
+
+	var expand func(id string, qty float64)
+	expand = func(id string, qty float64) {
+		cost, known := costs[id]
+		if !known || cost.Decision == DecisionBuy {
+			unitCost := 0.0
+			if known {
+				unitCost = cost.Cost
+			}
+			if entry, seen := lines[id]; seen {
+				entry.Quantity += qty
+				entry.Total = entry.Quantity * entry.UnitCost
+			} else {
+				lines[id] = &ShoppingListEntry{ItemID: id, Quantity: qty, UnitCost: unitCost, Total: qty * unitCost}
+			}
+			return
+		}
+
+		item := data[id]
+		ingredients, err := recipeIngredients(item)
+		if err != nil {
+			return
+		}
+		for ing, count := range ingredients {
+			expand(ing, qty*float64(count))
+		}
+	}
+
+	expand(itemID, quantity)
+
+	plan.ShoppingList = make([]ShoppingListEntry, 0, len(lines))
+	for _, entry := range lines {
+		plan.TotalCost += entry.Total
+		plan.ShoppingList = append(plan.ShoppingList, *entry)
+	}
+	sort.Slice(plan.ShoppingList, func(i, j int) bool {
+		return plan.ShoppingList[i].ItemID < plan.ShoppingList[j].ItemID
+	})
+	return plan
+}
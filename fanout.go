@@ -0,0 +1,74 @@
+package main
+
+import "sync"
+
+// Job is one unit of fan-out work dispatched by FanOut: a base-material ID
+// and the total quantity of it a recipe tree needs, as collected by
+// collectCraftTotals's BFS walk (api_server.go).
+type Job struct {
+	ItemID   string
+	Quantity int
+}
+
+// Result is what a Worker computes for one Job - typically a
+// getPriceFromCache lookup plus whatever API fallback that entails.
+type Result struct {
+	ItemID   string
+	Quantity int
+	Price    float64
+	Method   string
+	Source   string
+	Cost     float64
+}
+
+// Worker resolves a single Job into a Result. FanOut runs many Workers
+// concurrently so a recipe tree's base materials are priced in parallel
+// instead of one at a time during a serial tree walk.
+type Worker func(Job) Result
+
+// FanOut starts count goroutines pulling Jobs off in, each running fn and
+// sending its Result onward, and merges their outputs into one channel via
+// FanIn. buff sizes each worker's output channel. The returned channel
+// closes once in is closed and every in-flight job has been processed.
+func FanOut(count, buff int, in <-chan Job, fn Worker) <-chan Result {
+	if count < 1 {
+		count = 1
+	}
+
+	outs := make([]<-chan Result, count)
+	for i := 0; i < count; i++ {
+		out := make(chan Result, buff)
+		outs[i] = out
+		go func(out chan<- Result) {
+			defer close(out)
+			for job := range in {
+				out <- fn(job)
+			}
+		}(out)
+	}
+	return FanIn(outs...)
+}
+
+// FanIn merges chans into a single channel, closed once every input
+// channel has been closed and drained.
+func FanIn(chans ...<-chan Result) <-chan Result {
+	out := make(chan Result)
+
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c <-chan Result) {
+			defer wg.Done()
+			for r := range c {
+				out <- r
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
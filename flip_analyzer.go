@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file ranks every craftable item by comparing buildCraftResult's
+// craft cost (api_server.go, chunk25-1/25-2) against its current sell
+// price, for the REPL's `top N` command and the HTTP API's /flips and
+// /progress endpoints. Scanning the whole item database is fanned out
+// across its own small worker pool below - fanout.go's FanOut/Job/Result
+// are shaped for per-material price lookups (collectCraftTotals), not a
+// whole item's buildCraftResult, so this mirrors that same channel-based
+// fan-out/fan-in pattern at its own, item-level granularity rather than
+// forcing the mismatched payload through FanOut's types.
+
+// FlipSortMode is one of /flips' or `top N`'s ranking criteria.
+type FlipSortMode string
+
+const (
+	SortByProfit FlipSortMode = "profit"
+	SortByMargin FlipSortMode = "margin"
+	SortByVolume FlipSortMode = "volume"
+)
+
+// FlipResult is one craftable item's buy-raw-materials/craft/sell
+// comparison, as scanFlips ranks them.
+type FlipResult struct {
+	ItemID        string    `json:"itemId"`
+	ItemName      string    `json:"itemName"`
+	CraftCost     float64   `json:"craftCost"`
+	SellPrice     float64   `json:"sellPrice"`
+	Profit        float64   `json:"profit"`
+	Margin        float64   `json:"margin"` // Profit / CraftCost
+	ProfitPerHour float64   `json:"profitPerHour"`
+	Volume        int       `json:"volume"`
+	LastUpdated   time.Time `json:"lastUpdated"`
+}
+
+// flipScanProgress is scanFlips' current progress, 0-100, polled by
+// FlipScanProgress (and so /progress) during a long scan.
+var flipScanProgress int32
+
+// FlipScanProgress returns scanFlips' current progress as a 0-100
+// percentage.
+func FlipScanProgress() int {
+	return int(atomic.LoadInt32(&flipScanProgress))
+}
+
+// scanFlips computes a FlipResult for every craftable, sellable item in
+// items, fanning the per-item work out across runtime.NumCPU() workers so
+// a full scan stays fast, and updating flipScanProgress as items complete.
+func scanFlips(ctx context.Context) ([]FlipResult, error) {
+	_, span := StartSpan(ctx, "scanFlips")
+	defer span.End()
+
+	var itemIDs []string
+	for itemID := range items {
+		if isBaseMaterial(itemID) {
+			continue
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+
+	atomic.StoreInt32(&flipScanProgress, 0)
+	if len(itemIDs) == 0 {
+		atomic.StoreInt32(&flipScanProgress, 100)
+		return nil, nil
+	}
+
+	jobs := make(chan string, len(itemIDs))
+	for _, id := range itemIDs {
+		jobs <- id
+	}
+	close(jobs)
+
+	resultsCh := make(chan *FlipResult, len(itemIDs))
+	var completed int32
+
+	workerCount := runtime.NumCPU()
+	if workerCount > len(itemIDs) {
+		workerCount = len(itemIDs)
+	}
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+			for itemID := range jobs {
+				resultsCh <- buildFlipResult(ctx, itemID)
+				done := atomic.AddInt32(&completed, 1)
+				atomic.StoreInt32(&flipScanProgress, int32(100*int(done)/len(itemIDs)))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultsCh)
+	}()
+
+	var results []FlipResult
+	for r := range resultsCh {
+		if r != nil {
+			results = append(results, *r)
+		}
+	}
+
+	atomic.StoreInt32(&flipScanProgress, 100)
+	span.SetAttribute("items.scanned", len(results))
+	return results, nil
+}
+
+// buildFlipResult resolves itemID's craft cost (buildCraftResult) and
+// current sell price (PriceCache.getIdealPrice), returning nil if itemID
+// has no recipe cost or isn't currently sellable.
+func buildFlipResult(ctx context.Context, itemID string) *FlipResult {
+	craft, err := buildCraftResult(ctx, itemID)
+	if err != nil {
+		return nil
+	}
+
+	sellPrice := cache.getIdealPrice(itemID)
+	if sellPrice <= 0 || craft.TotalCost <= 0 {
+		return nil
+	}
+
+	volume := 0
+	cache.mu.RLock()
+	if product, exists := cache.bazaarData.Products[itemID]; exists {
+		volume = product.QuickStatus.SellMovingWeek
+		if product.QuickStatus.BuyMovingWeek < volume {
+			volume = product.QuickStatus.BuyMovingWeek
+		}
+	}
+	cache.mu.RUnlock()
+
+	profit := sellPrice - craft.TotalCost
+	margin := profit / craft.TotalCost
+
+	return &FlipResult{
+		ItemID:        itemID,
+		ItemName:      craft.ItemName,
+		CraftCost:     craft.TotalCost,
+		SellPrice:     sellPrice,
+		Profit:        profit,
+		Margin:        margin,
+		ProfitPerHour: profit * float64(volume) / hoursPerBazaarWeek,
+		Volume:        volume,
+		LastUpdated:   time.Now(),
+	}
+}
+
+// sortFlips orders results in place by mode, descending - unrecognized
+// modes fall back to SortByProfit.
+func sortFlips(results []FlipResult, mode FlipSortMode) {
+	switch mode {
+	case SortByMargin:
+		sort.Slice(results, func(i, j int) bool { return results[i].Margin > results[j].Margin })
+	case SortByVolume:
+		sort.Slice(results, func(i, j int) bool { return results[i].Volume > results[j].Volume })
+	default:
+		sort.Slice(results, func(i, j int) bool { return results[i].Profit > results[j].Profit })
+	}
+}
+
+// printTopFlips is the REPL's `top N` command: scan every craftable item,
+// sort by profit, and print the n best as a framed table.
+func printTopFlips(n int) {
+	results, err := scanFlips(context.Background())
+	if err != nil {
+		fmt.Printf("Error scanning flips: %v\n", err)
+		return
+	}
+	sortFlips(results, SortByProfit)
+	if len(results) > n {
+		results = results[:n]
+	}
+
+	fmt.Println("\n╔════════════════════ Top Flips ════════════════════")
+	for _, r := range results {
+		fmt.Printf("║ %-28s Cost: %-10s Sell: %-10s Profit: %-10s Margin: %6.2f%%\n",
+			r.ItemName, formatPrice(r.CraftCost), formatPrice(r.SellPrice), formatPrice(r.Profit), r.Margin*100)
+	}
+	fmt.Println("╚═════════════════════════════════════════════════")
+}
+
+// flipsHandler serves GET /flips?sort=profit|margin|volume&limit=N as a
+// rank-ordered []FlipResult.
+func flipsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "GET /flips")
+	defer span.End()
+
+	sortMode := FlipSortMode(r.URL.Query().Get("sort"))
+	if sortMode == "" {
+		sortMode = SortByProfit
+	}
+	span.SetAttribute("sort", string(sortMode))
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	span.SetAttribute("limit", limit)
+
+	results, err := scanFlips(ctx)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sortFlips(results, sortMode)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	writeJSONResult(w, results)
+}
+
+// progressHandler serves GET /progress as scanFlips' current 0-100
+// progress, for a caller polling a long /flips scan.
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResult(w, struct {
+		Percent int `json:"percent"`
+	}{FlipScanProgress()})
+}
@@ -0,0 +1,120 @@
+// Package handlers implements the item-catalog and recipe-expansion logic
+// behind the root wiz web server's "/" and "/recipe/{id}" routes natively in
+// Go. main.go used to shell out to `python3 list.py` and `python3 recipe.py`
+// per request and scrape the first "{" out of stdout to find where their
+// JSON began; this package replaces both scripts outright, so a request is
+// served by calling a function instead of forking a process, reading an
+// environment variable, and re-parsing semi-structured stdout.
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Recipe is one item's 3x3 crafting-grid recipe, as recorded in data.json.
+// A slot is either empty, a bare item ID, or "ID:AMOUNT" when more than one
+// of that ingredient is required per craft.
+type Recipe struct {
+	A1    string      `json:"A1"`
+	A2    string      `json:"A2"`
+	A3    string      `json:"A3"`
+	B1    string      `json:"B1"`
+	B2    string      `json:"B2"`
+	B3    string      `json:"B3"`
+	C1    string      `json:"C1"`
+	C2    string      `json:"C2"`
+	C3    string      `json:"C3"`
+	Count interface{} `json:"count"`
+}
+
+// slots lists a Recipe's nine grid positions in a fixed order, so callers
+// iterating ingredients agree on slot order without repeating this literal.
+func (r Recipe) slots() [9]string {
+	return [9]string{r.A1, r.A2, r.A3, r.B1, r.B2, r.B3, r.C1, r.C2, r.C3}
+}
+
+// Count unmarshals from a bare JSON number or a numeric string, so it
+// doesn't matter which one a given data.json entry used.
+func (r Recipe) outputCount() int {
+	switch v := r.Count.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		var count int
+		fmt.Sscanf(v, "%d", &count)
+		return count
+	default:
+		return 1
+	}
+}
+
+// ItemRecord is one data.json entry: an item's display name, its recipe,
+// and its metadata.
+type ItemRecord struct {
+	Name   string `json:"name"`
+	Recipe Recipe `json:"recipe"`
+	Wiki   string `json:"wiki"`
+	Rarity string `json:"base_rarity"`
+}
+
+// ItemSummary is the typed row ItemCatalog.List returns for the "/" item
+// listing, replacing the map[string]interface{} list.py's scraped JSON used
+// to populate.
+type ItemSummary struct {
+	ItemID string `json:"item_id"`
+	Name   string `json:"name"`
+}
+
+// ItemCatalog holds every item record loaded from data.json and serves both
+// the item listing and recipe expansion off the same in-memory map, so
+// both routes agree on item names without main.go loading the file twice.
+type ItemCatalog struct {
+	mu    sync.RWMutex
+	items map[string]ItemRecord
+}
+
+// LoadItemCatalog reads and parses path (data.json's usual location) into
+// an ItemCatalog.
+func LoadItemCatalog(path string) (*ItemCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("handlers: reading %s: %w", path, err)
+	}
+	var items map[string]ItemRecord
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("handlers: parsing %s: %w", path, err)
+	}
+	return &ItemCatalog{items: items}, nil
+}
+
+// Name returns itemID's display name, falling back to itemID itself when
+// the catalog has no record for it.
+func (c *ItemCatalog) Name(itemID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if rec, ok := c.items[itemID]; ok && rec.Name != "" {
+		return rec.Name
+	}
+	return itemID
+}
+
+// List returns every catalog item as an ItemSummary, sorted by name.
+func (c *ItemCatalog) List() []ItemSummary {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	summaries := make([]ItemSummary, 0, len(c.items))
+	for id, rec := range c.items {
+		summaries = append(summaries, ItemSummary{ItemID: id, Name: rec.Name})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return strings.ToLower(summaries[i].Name) < strings.ToLower(summaries[j].Name)
+	})
+	return summaries
+}
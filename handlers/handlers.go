@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RecipeRequest is the typed form of a "/recipe/{id}" request: the item and
+// the quantity to expand its recipe for.
+type RecipeRequest struct {
+	ItemID   string
+	Quantity int
+}
+
+// ParseRecipeRequest extracts a RecipeRequest from an HTTP request whose
+// path is "/recipe/{id}", with an optional "?quantity=" query parameter
+// (quantityParam) - the direct replacement for recipe.py's "ITEM_ID"
+// environment variable.
+func ParseRecipeRequest(r *http.Request) (RecipeRequest, bool) {
+	itemID := strings.TrimPrefix(r.URL.Path, "/recipe/")
+	if itemID == "" {
+		return RecipeRequest{}, false
+	}
+	return RecipeRequest{ItemID: itemID, Quantity: quantityParam(r)}, true
+}
+
+// RecipeData is what the "/recipe/{id}" template now renders directly,
+// replacing the map[string]interface{}{"recipe": ..., "names": ...} shape
+// main.go used to build around recipe.py's scraped JSON.
+type RecipeData struct {
+	Recipe *RecipeNode
+}
+
+// Recipe expands req against the catalog, returning the typed data the
+// recipe template renders.
+func (c *ItemCatalog) RecipeData(req RecipeRequest) RecipeData {
+	return RecipeData{Recipe: c.ExpandRecipe(req.ItemID, req.Quantity)}
+}
+
+// quantityParam reads an optional "?quantity=" query parameter, defaulting
+// to 1 when absent or not a positive integer.
+func quantityParam(r *http.Request) int {
+	raw := r.URL.Query().Get("quantity")
+	if raw == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 1
+	}
+	return n
+}
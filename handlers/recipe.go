@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// RecipeNode is the typed recipe tree ExpandRecipe builds, replacing the
+// map[string]interface{} that recipe.py's scraped stdout used to produce.
+// It mirrors calculation_engine's CraftingStepNode in spirit (itself rooted
+// at one item with a quantity and an expanded set of children) but is built
+// directly from this catalog's Recipe data rather than bazaar pricing, since
+// this route only ever rendered the crafting grid, never a cost breakdown.
+type RecipeNode struct {
+	ItemID   string        `json:"item_id"`
+	Name     string        `json:"name"`
+	Quantity int           `json:"quantity"`
+	Children []*RecipeNode `json:"children,omitempty"`
+}
+
+// ExpandRecipe recursively expands itemID's crafting requirements for
+// quantity units into a RecipeNode tree, the same "how many of each
+// ingredient do I need" recursion recipe.py used to print. A cycle in the
+// data (an item that, directly or transitively, requires itself) stops
+// recursing on the repeated item rather than looping forever; that item's
+// own children are simply left empty.
+func (c *ItemCatalog) ExpandRecipe(itemID string, quantity int) *RecipeNode {
+	return c.expandRecipe(itemID, quantity, make(map[string]bool))
+}
+
+func (c *ItemCatalog) expandRecipe(itemID string, quantity int, visited map[string]bool) *RecipeNode {
+	node := &RecipeNode{ItemID: itemID, Name: c.Name(itemID), Quantity: quantity}
+	if visited[itemID] {
+		return node
+	}
+	visited[itemID] = true
+
+	c.mu.RLock()
+	rec, ok := c.items[itemID]
+	c.mu.RUnlock()
+	if !ok {
+		return node
+	}
+
+	recipeCount := rec.Recipe.outputCount()
+	if recipeCount == 0 {
+		recipeCount = 1
+	}
+	// Ceiling division: partial crafts still consume a full recipe's worth
+	// of ingredients.
+	recipesNeeded := (quantity + recipeCount - 1) / recipeCount
+
+	ingredientQuantities := make(map[string]int)
+	var ingredientOrder []string
+	for _, slot := range rec.Recipe.slots() {
+		if slot == "" {
+			continue
+		}
+		ingredientID, perCraft := parseSlot(slot)
+		if _, seen := ingredientQuantities[ingredientID]; !seen {
+			ingredientOrder = append(ingredientOrder, ingredientID)
+		}
+		ingredientQuantities[ingredientID] += perCraft * recipesNeeded
+	}
+
+	for _, ingredientID := range ingredientOrder {
+		branchVisited := make(map[string]bool, len(visited))
+		for k, v := range visited {
+			branchVisited[k] = v
+		}
+		node.Children = append(node.Children, c.expandRecipe(ingredientID, ingredientQuantities[ingredientID], branchVisited))
+	}
+	return node
+}
+
+// parseSlot splits a recipe slot ("ID" or "ID:AMOUNT") into its ingredient
+// ID and per-craft amount, defaulting to 1 when no amount is given or the
+// amount doesn't parse.
+func parseSlot(slot string) (itemID string, amount int) {
+	id, rest, ok := strings.Cut(slot, ":")
+	if !ok {
+		return slot, 1
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil {
+		return id, 1
+	}
+	return id, n
+}
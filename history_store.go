@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// historyRetention is how long HistoryStore.Append keeps a raw snapshot
+	// before pruneLocked drops it - 30 days of 5-minute-cadence snapshots
+	// (cacheTimeout) is about 8640 points per item.
+	historyRetention = 30 * 24 * time.Hour
+	// historyStorePersistPath is where SaveHistoryStore/LoadHistoryStore
+	// gob-encode every item's snapshot slice, so a restart resumes history
+	// instead of starting the candle/averaging endpoints cold.
+	historyStorePersistPath = "history_store.gob"
+)
+
+// HistorySnapshot is one PriceCache.update tick's recorded state for a
+// single item, keyed by (ItemID, Time) once appended into a HistoryStore.
+// IdealPrice is whatever getIdealPrice (list.go) returned for this tick, so
+// Range/Candles/averaging downstream don't need to recompute it from
+// BuyPrice/SellPrice.
+type HistorySnapshot struct {
+	Time           time.Time
+	BuyPrice       float64
+	SellPrice      float64
+	BuyVolume      int
+	SellVolume     int
+	BuyMovingWeek  int
+	SellMovingWeek int
+	IdealPrice     float64
+}
+
+// OHLC is one candle: Open/High/Low/Close of IdealPrice over the bucket
+// [Time, Time+interval), and Volume is the last snapshot in the bucket's
+// BuyVolume+SellVolume (a point-in-time stock, not a summed flow).
+type OHLC struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int
+}
+
+// HistoryStore is an append-only, in-memory time series of HistorySnapshots
+// per item, periodically flushed to disk via SaveHistoryStore. It's the
+// "simple bucketed file format" alternative to an embedded SQLite/TSDB
+// dependency - this codebase avoids adding third-party storage engines for
+// a need this size (see the similar gob-based persistence already used by
+// price_estimator.go's itemPriceHistory).
+type HistoryStore struct {
+	mu   sync.RWMutex
+	data map[string][]HistorySnapshot
+}
+
+// NewHistoryStore returns an empty HistoryStore.
+func NewHistoryStore() *HistoryStore {
+	return &HistoryStore{data: make(map[string][]HistorySnapshot)}
+}
+
+var globalHistoryStore = NewHistoryStore()
+
+// Append records snap for itemID, keeping the per-item slice in Time order,
+// then prunes anything older than historyRetention.
+func (hs *HistoryStore) Append(itemID string, snap HistorySnapshot) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.data[itemID] = append(hs.data[itemID], snap)
+	hs.pruneLocked(itemID, snap.Time.Add(-historyRetention))
+}
+
+// pruneLocked drops itemID's snapshots older than cutoff. Callers must hold
+// hs.mu for writing.
+func (hs *HistoryStore) pruneLocked(itemID string, cutoff time.Time) {
+	snapshots := hs.data[itemID]
+	i := 0
+	for i < len(snapshots) && snapshots[i].Time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		hs.data[itemID] = append([]HistorySnapshot(nil), snapshots[i:]...)
+	}
+}
+
+// Range returns itemID's snapshots with Time in [from, to], oldest first.
+func (hs *HistoryStore) Range(itemID string, from, to time.Time) []HistorySnapshot {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	var out []HistorySnapshot
+	for _, snap := range hs.data[itemID] {
+		if snap.Time.Before(from) || snap.Time.After(to) {
+			continue
+		}
+		out = append(out, snap)
+	}
+	return out
+}
+
+// AverageIdealPrice returns the mean IdealPrice of itemID's snapshots from
+// the last `days` days, or (0, false) if there are none - used to smooth
+// recipe-tree craft-cost estimates against a spot quote (see
+// getPriceFromCache in list.go).
+func (hs *HistoryStore) AverageIdealPrice(itemID string, days int) (float64, bool) {
+	if days <= 0 {
+		return 0, false
+	}
+	snapshots := hs.Range(itemID, time.Now().Add(-time.Duration(days)*24*time.Hour), time.Now())
+	if len(snapshots) == 0 {
+		return 0, false
+	}
+	var total float64
+	for _, snap := range snapshots {
+		total += snap.IdealPrice
+	}
+	return total / float64(len(snapshots)), true
+}
+
+// Candles downsamples itemID's full retained history into OHLC buckets of
+// width interval (e.g. time.Minute, 5*time.Minute, time.Hour, 24*time.Hour
+// for the 1m/5m/1h/1d resolutions a caller picks via parseHistoryInterval).
+func (hs *HistoryStore) Candles(itemID string, interval time.Duration) []OHLC {
+	if interval <= 0 {
+		return nil
+	}
+	hs.mu.RLock()
+	snapshots := append([]HistorySnapshot(nil), hs.data[itemID]...)
+	hs.mu.RUnlock()
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	var candles []OHLC
+	var bucket OHLC
+	bucketStart := snapshots[0].Time.Truncate(interval)
+	open := true
+
+	flush := func() {
+		if !open {
+			candles = append(candles, bucket)
+		}
+	}
+
+	for _, snap := range snapshots {
+		start := snap.Time.Truncate(interval)
+		if open || start.After(bucketStart) {
+			if !open {
+				candles = append(candles, bucket)
+			}
+			bucketStart = start
+			bucket = OHLC{
+				Time:   bucketStart,
+				Open:   snap.IdealPrice,
+				High:   snap.IdealPrice,
+				Low:    snap.IdealPrice,
+				Close:  snap.IdealPrice,
+				Volume: snap.BuyVolume + snap.SellVolume,
+			}
+			open = false
+			continue
+		}
+		if snap.IdealPrice > bucket.High {
+			bucket.High = snap.IdealPrice
+		}
+		if snap.IdealPrice < bucket.Low {
+			bucket.Low = snap.IdealPrice
+		}
+		bucket.Close = snap.IdealPrice
+		bucket.Volume = snap.BuyVolume + snap.SellVolume
+	}
+	flush()
+	return candles
+}
+
+// persistableHistoryStore is the gob-encodable form of HistoryStore's data
+// map (HistoryStore itself carries a mutex, so it can't be encoded
+// directly).
+type persistableHistoryStore map[string][]HistorySnapshot
+
+// SaveHistoryStore gob-encodes hs to path.
+func SaveHistoryStore(hs *HistoryStore, path string) error {
+	hs.mu.RLock()
+	snapshot := make(persistableHistoryStore, len(hs.data))
+	for itemID, snapshots := range hs.data {
+		snapshot[itemID] = append([]HistorySnapshot(nil), snapshots...)
+	}
+	hs.mu.RUnlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("SaveHistoryStore: creating %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(snapshot); err != nil {
+		return fmt.Errorf("SaveHistoryStore: encoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadHistoryStore restores hs from a prior SaveHistoryStore call. A
+// missing file is not an error - that's just a fresh process with no
+// history yet.
+func LoadHistoryStore(hs *HistoryStore, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("LoadHistoryStore: opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var snapshot persistableHistoryStore
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return fmt.Errorf("LoadHistoryStore: decoding %s: %w", path, err)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for itemID, snapshots := range snapshot {
+		hs.data[itemID] = snapshots
+	}
+	return nil
+}
+
+// parseHistoryInterval maps the /history endpoint's ?interval= values to a
+// downsampling bucket width.
+func parseHistoryInterval(s string) (time.Duration, error) {
+	switch s {
+	case "1m", "":
+		return time.Minute, nil
+	case "5m":
+		return 5 * time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	case "1d":
+		return 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported interval %q (want 1m, 5m, 1h or 1d)", s)
+	}
+}
+
+// historyHandler serves GET /history?item=<itemID>&interval=<1m|5m|1h|1d> as
+// a JSON array of OHLC candles, so a frontend or notebook can plot an
+// item's price history without re-hitting Hypixel.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	itemID := r.URL.Query().Get("item")
+	if itemID == "" {
+		http.Error(w, "missing item parameter", http.StatusBadRequest)
+		return
+	}
+
+	interval, err := parseHistoryInterval(r.URL.Query().Get("interval"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candles := globalHistoryStore.Candles(itemID, interval)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(candles); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// apiHTTPClient is the shared rate-limited, retrying HTTP client behind
+// fetchData - every top-level fetcher (BazaarPriceSource, the lowest-BIN
+// snapshot, CoflnetPriceSource) goes through it instead of calling
+// http.Get directly, so they all get the same backoff/retry/caching/metrics
+// behavior. Budgets and limiters are keyed per host, since dataURL,
+// bazaarURL, lowestBinURL and coflnetHistoryURL are four independent
+// upstreams with their own rate limits.
+type apiHTTPClient struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	budgets  map[string]int
+	etags    map[string]etagEntry
+	metrics  map[string]*endpointMetrics
+}
+
+// etagEntry caches the last successful response for a URL so a retry (or a
+// later poll of the same endpoint) can send If-None-Match/If-Modified-Since
+// and reuse it on a 304 instead of re-downloading and re-parsing the body.
+type etagEntry struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// endpointMetrics is one URL's running success/failure/latency counters,
+// exported by MetricsHandler in Prometheus text format - the same
+// counter-per-label shape backend/calculation_engine/observability.go uses,
+// duplicated here rather than imported since this top-level script has no
+// module path to that package.
+type endpointMetrics struct {
+	mu            sync.Mutex
+	requests      uint64
+	successes     uint64
+	failures      uint64
+	retries       uint64
+	totalLatency  time.Duration
+}
+
+const (
+	apiHTTPMaxRetries     = 4
+	apiHTTPBaseBackoff    = 250 * time.Millisecond
+	apiHTTPMaxBackoff     = 8 * time.Second
+	apiHTTPDefaultBudget  = 10 // max in-flight-equivalent requests per host per fetch cycle; see takeBudget
+	apiHTTPRatePerSecond  = 5
+	apiHTTPRateBurst      = 5
+)
+
+var (
+	sharedAPIClient   *apiHTTPClient
+	sharedAPIClientMu sync.Mutex
+)
+
+// defaultAPIClient lazily builds the package-wide apiHTTPClient, mirroring
+// the DefaultSerialMetricsStore/DefaultPriceHistoryStore singleton pattern
+// used elsewhere for shared, lazily-initialized package state.
+func defaultAPIClient() *apiHTTPClient {
+	sharedAPIClientMu.Lock()
+	defer sharedAPIClientMu.Unlock()
+	if sharedAPIClient == nil {
+		sharedAPIClient = newAPIHTTPClient()
+	}
+	return sharedAPIClient
+}
+
+func newAPIHTTPClient() *apiHTTPClient {
+	return &apiHTTPClient{
+		client:   &http.Client{Timeout: 15 * time.Second},
+		limiters: make(map[string]*rate.Limiter),
+		budgets:  make(map[string]int),
+		etags:    make(map[string]etagEntry),
+		metrics:  make(map[string]*endpointMetrics),
+	}
+}
+
+func hostOf(rawURL string) string {
+	rest := strings.TrimPrefix(rawURL, "https://")
+	rest = strings.TrimPrefix(rest, "http://")
+	if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+		rest = rest[:idx]
+	}
+	return rest
+}
+
+func (c *apiHTTPClient) limiterFor(host string) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(apiHTTPRatePerSecond), apiHTTPRateBurst)
+		c.limiters[host] = l
+	}
+	return l
+}
+
+func (c *apiHTTPClient) metricsFor(rawURL string) *endpointMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.metrics[rawURL]
+	if !ok {
+		m = &endpointMetrics{}
+		c.metrics[rawURL] = m
+	}
+	return m
+}
+
+// isRetryableStatus reports whether status is worth retrying: 429 and any
+// 5xx are transient upstream conditions; 400/404 and the rest of the 4xx
+// range mean the request itself is wrong and retrying just wastes the rate
+// limit budget on a request that will never succeed.
+func isRetryableStatus(status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	return status >= 500
+}
+
+// backoffWithJitter returns the delay before retry attempt n (1-indexed):
+// exponential growth off apiHTTPBaseBackoff, capped at apiHTTPMaxBackoff,
+// with full jitter (a random delay in [0, computed]) so a burst of callers
+// hitting the same failing upstream don't retry in lockstep.
+func backoffWithJitter(attempt int) time.Duration {
+	backoff := apiHTTPBaseBackoff * time.Duration(1<<uint(attempt-1))
+	if backoff > apiHTTPMaxBackoff {
+		backoff = apiHTTPMaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// Get fetches rawURL, waiting on the per-host rate limiter, retrying
+// retryable failures (5xx, 429, network errors) up to apiHTTPMaxRetries
+// times with exponential backoff and jitter, and returning immediately on a
+// terminal failure (4xx other than 429) instead of spinning - the bug the
+// coflnet fetcher's ad-hoc retry loop had. A 304 against a previously cached
+// ETag/Last-Modified returns the cached body without counting against the
+// retry budget.
+func (c *apiHTTPClient) Get(rawURL string) ([]byte, error) {
+	host := hostOf(rawURL)
+	limiter := c.limiterFor(host)
+	metrics := c.metricsFor(rawURL)
+
+	var lastErr error
+	for attempt := 1; attempt <= apiHTTPMaxRetries; attempt++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			return nil, fmt.Errorf("apiHTTPClient: rate limiter wait failed for %s: %w", rawURL, err)
+		}
+		if !c.takeBudget(host) {
+			return nil, fmt.Errorf("apiHTTPClient: per-host budget exhausted for %s", host)
+		}
+
+		start := time.Now()
+		body, status, cached, err := c.doRequest(rawURL)
+		latency := time.Since(start)
+
+		metrics.mu.Lock()
+		metrics.requests++
+		metrics.totalLatency += latency
+		metrics.mu.Unlock()
+
+		if err == nil && cached {
+			metrics.mu.Lock()
+			metrics.successes++
+			metrics.mu.Unlock()
+			return body, nil
+		}
+		if err == nil && status >= 200 && status < 300 {
+			metrics.mu.Lock()
+			metrics.successes++
+			metrics.mu.Unlock()
+			return body, nil
+		}
+
+		if err == nil && !isRetryableStatus(status) {
+			metrics.mu.Lock()
+			metrics.failures++
+			metrics.mu.Unlock()
+			return nil, fmt.Errorf("apiHTTPClient: %s returned terminal status %d", rawURL, status)
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("apiHTTPClient: %s returned retryable status %d", rawURL, status)
+		}
+
+		metrics.mu.Lock()
+		metrics.retries++
+		metrics.mu.Unlock()
+
+		if attempt < apiHTTPMaxRetries {
+			time.Sleep(backoffWithJitter(attempt))
+		}
+	}
+
+	metrics.mu.Lock()
+	metrics.failures++
+	metrics.mu.Unlock()
+	return nil, fmt.Errorf("apiHTTPClient: %s failed after %d attempts: %w", rawURL, apiHTTPMaxRetries, lastErr)
+}
+
+// takeBudget enforces apiHTTPDefaultBudget requests per host per process
+// lifetime reset window (one fetch cycle, since main runs fetches
+// periodically and nothing currently resets this counter mid-cycle). This
+// is deliberately simple - a hard cap, not a token bucket - since rate
+// limiting already governs request pacing; the budget exists only to bound
+// how many retries a single badly-behaved endpoint can consume.
+func (c *apiHTTPClient) takeBudget(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	used, ok := c.budgets[host]
+	if !ok {
+		used = 0
+	}
+	if used >= apiHTTPDefaultBudget*apiHTTPMaxRetries {
+		return false
+	}
+	c.budgets[host] = used + 1
+	return true
+}
+
+// doRequest performs one HTTP GET, attaching If-None-Match/If-Modified-Since
+// from a prior successful fetch of the same URL. cached is true when the
+// upstream answered 304 and body is the previously cached response.
+func (c *apiHTTPClient) doRequest(rawURL string) (body []byte, status int, cached bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	c.mu.Lock()
+	entry, hasEntry := c.etags[rawURL]
+	c.mu.Unlock()
+	if hasEntry {
+		if entry.etag != "" {
+			req.Header.Set("If-None-Match", entry.etag)
+		}
+		if entry.lastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.lastModified)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && hasEntry {
+		return entry.body, resp.StatusCode, true, nil
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, false, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		c.mu.Lock()
+		c.etags[rawURL] = etagEntry{
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+			body:         respBody,
+		}
+		c.mu.Unlock()
+	}
+
+	return respBody, resp.StatusCode, false, nil
+}
+
+// MetricsHandler serves apiHTTPClient's per-endpoint counters in Prometheus
+// text format, mirroring backend/calculation_engine/observability.go's
+// MetricsHandler style (# HELP/# TYPE headers, text/plain; version=0.0.4) -
+// duplicated rather than imported since this top-level script has no module
+// path to that package.
+func (c *apiHTTPClient) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		c.mu.Lock()
+		urls := make([]string, 0, len(c.metrics))
+		for u := range c.metrics {
+			urls = append(urls, u)
+		}
+		c.mu.Unlock()
+
+		fmt.Fprintf(w, "# HELP apihttp_requests_total total requests attempted per endpoint\n# TYPE apihttp_requests_total counter\n")
+		for _, u := range urls {
+			m := c.metricsFor(u)
+			m.mu.Lock()
+			fmt.Fprintf(w, "apihttp_requests_total{endpoint=%q} %d\n", u, m.requests)
+			m.mu.Unlock()
+		}
+
+		fmt.Fprintf(w, "# HELP apihttp_successes_total successful requests per endpoint\n# TYPE apihttp_successes_total counter\n")
+		for _, u := range urls {
+			m := c.metricsFor(u)
+			m.mu.Lock()
+			fmt.Fprintf(w, "apihttp_successes_total{endpoint=%q} %d\n", u, m.successes)
+			m.mu.Unlock()
+		}
+
+		fmt.Fprintf(w, "# HELP apihttp_failures_total terminally failed requests per endpoint\n# TYPE apihttp_failures_total counter\n")
+		for _, u := range urls {
+			m := c.metricsFor(u)
+			m.mu.Lock()
+			fmt.Fprintf(w, "apihttp_failures_total{endpoint=%q} %d\n", u, m.failures)
+			m.mu.Unlock()
+		}
+
+		fmt.Fprintf(w, "# HELP apihttp_retries_total retry attempts per endpoint\n# TYPE apihttp_retries_total counter\n")
+		for _, u := range urls {
+			m := c.metricsFor(u)
+			m.mu.Lock()
+			fmt.Fprintf(w, "apihttp_retries_total{endpoint=%q} %d\n", u, m.retries)
+			m.mu.Unlock()
+		}
+
+		fmt.Fprintf(w, "# HELP apihttp_latency_seconds_total cumulative request latency per endpoint\n# TYPE apihttp_latency_seconds_total counter\n")
+		for _, u := range urls {
+			m := c.metricsFor(u)
+			m.mu.Lock()
+			fmt.Fprintf(w, "apihttp_latency_seconds_total{endpoint=%q} %f\n", u, m.totalLatency.Seconds())
+			m.mu.Unlock()
+		}
+	})
+}
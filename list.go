@@ -9,12 +9,14 @@ import (
     "bufio"
     "compress/gzip"
     "encoding/json"
+    "flag"
     "fmt"
     "io"
     "io/ioutil"
     "net/http"
     "os"
     "sort"
+    "strconv"
     "strings"
     "sync"
     "time"
@@ -97,7 +99,7 @@ type LowestBinData map[string]float64
 type PriceCache struct {
     bazaarData BazaarResponse
     lowestBins LowestBinData
-    recipeTrees   map[string]*RecipeTree
+    recipeTrees   *RecipeTreeCache
     lastUpdate time.Time
     mu         sync.RWMutex
 }
@@ -106,7 +108,7 @@ func NewCache() *PriceCache {
     return &PriceCache{
         bazaarData:  BazaarResponse{},
         lowestBins:  make(LowestBinData),
-        recipeTrees: make(map[string]*RecipeTree),
+        recipeTrees: NewRecipeTreeCache(defaultRecipeTreeCacheSize, defaultRecipeTreeCacheTTL),
         lastUpdate:  time.Now(),
     }
 }
@@ -126,7 +128,20 @@ type OrderSummary struct {
     Orders       int     `json:"orders"`
 }
 
+// recipeCostAvgDays is how many days of globalHistoryStore history
+// getPriceFromCache averages IdealPrice over instead of using the current
+// spot quote, smoothing recipe-tree craft-cost estimates against one-tick
+// price jitter. 0 (the default, set by main's --avg-days flag) keeps the
+// old spot-price behavior.
+var recipeCostAvgDays int
+
 func getPriceFromCache(itemID string) (float64, string, string) {
+    if recipeCostAvgDays > 0 {
+        if avg, ok := globalHistoryStore.AverageIdealPrice(itemID, recipeCostAvgDays); ok {
+            return avg, "avg", fmt.Sprintf("%dd history", recipeCostAvgDays)
+        }
+    }
+
     cache.mu.RLock()
     defer cache.mu.RUnlock()
 
@@ -206,6 +221,28 @@ func (pm *PerformanceMetrics) PrintMetrics() {
     fmt.Println("╚═══════════════════════════════════════════════════════════\n")
 }
 
+// logOrPrint renders pm as the framed banner PrintMetrics prints if
+// prettyOutput is set, or as a single structured log record otherwise -
+// the choice initialize() (list.go) defers to on every startup.
+func (pm *PerformanceMetrics) logOrPrint() {
+    if prettyOutput {
+        pm.PrintMetrics()
+        return
+    }
+
+    pm.mu.RLock()
+    defer pm.mu.RUnlock()
+    appLogger.Info("performance_metrics",
+        "phase", "startup",
+        "item_load_ms", float64(pm.ItemLoadTime.Microseconds())/1000.0,
+        "bazaar_api_ms", float64(pm.FirstAPICallTime.Microseconds())/1000.0,
+        "bins_api_ms", float64(pm.SecondAPICallTime.Microseconds())/1000.0,
+        "cache_init_ms", float64(pm.CacheInitTime.Microseconds())/1000.0,
+        "recipe_tree_ms", float64(pm.RecipeTreeBuildTime.Microseconds())/1000.0,
+        "total_ms", float64(pm.TotalProcessingTime.Microseconds())/1000.0,
+    )
+}
+
 type MarketMetrics struct {
     SellPrice      float64
     BuyPrice       float64
@@ -240,39 +277,11 @@ func calculateMarketPressure(metrics MarketMetrics) float64 {
     return volumePressure * (1 + spreadPressure)
 }
 
-func calculateIdealPrice(metrics MarketMetrics) float64 {
-    // Base price starts at weighted average
-    totalVolume := float64(metrics.SellMovingWeek + metrics.BuyMovingWeek)
-    if totalVolume == 0 {
-        return (metrics.SellPrice + metrics.BuyPrice) / 2
-    }
-
-    // Calculate market pressure (-1 to 1 range)
-    pressure := calculateMarketPressure(metrics)
-    
-    // Calculate dynamic spread threshold based on volume ratio
-    volumeRatio := math.Abs(float64(metrics.SellMovingWeek-metrics.BuyMovingWeek)) / totalVolume
-    
-    // Price adjustment factor scales with pressure and volume
-    adjustment := pressure * volumeRatio
-    
-    // Calculate base price
-    basePrice := metrics.SellPrice
-    if pressure < 0 {
-        // Negative pressure (more buying) suggests using buyPrice as base
-        basePrice = metrics.BuyPrice
-    }
-    
-    // Apply dynamic adjustment
-    adjustedPrice := basePrice * (1 - adjustment)
-    
-    // Ensure price stays within reasonable bounds
-    minPrice := math.Min(metrics.SellPrice, metrics.BuyPrice)
-    maxPrice := math.Max(metrics.SellPrice, metrics.BuyPrice)
-    
-    return math.Max(minPrice, math.Min(maxPrice, adjustedPrice))
-}
-
+// determineBuyMethod suggests instabuy only when qs.BuyPrice sits within
+// instabuyZThreshold sigmas of the item's EMA_mid *and* calculateMarketPressure
+// reads at least instabuyPressureThreshold of buying pressure - both gates
+// on top of the old bare "pressure < 0" check, which flipped instabuy/buy
+// order on almost every 5-minute Bazaar update.
 func determineBuyMethod(qs QuickStatus) PriceMethod {
     metrics := MarketMetrics{
         SellPrice:      qs.SellPrice,
@@ -280,22 +289,24 @@ func determineBuyMethod(qs QuickStatus) PriceMethod {
         SellMovingWeek: qs.SellMovingWeek,
         BuyMovingWeek:  qs.BuyMovingWeek,
     }
-    
     pressure := calculateMarketPressure(metrics)
-    idealPrice := calculateIdealPrice(metrics)
-    
-    // If pressure is strongly negative, suggest instabuy
-    if pressure < 0 {
+
+    hist := getOrCreatePriceHistory(qs.ProductID)
+    minPrice := math.Min(qs.SellPrice, qs.BuyPrice)
+    maxPrice := math.Max(qs.SellPrice, qs.BuyPrice)
+    idealPrice := hist.idealPrice(idealPriceK, minPrice, maxPrice)
+    z := hist.zScore(qs.BuyPrice)
+
+    if z <= instabuyZThreshold && pressure < -instabuyPressureThreshold {
         return PriceMethod{
             Price:  idealPrice,
             Method: "instabuy",
         }
     }
-    
-    // If pressure is positive, suggest buy order
+
     return PriceMethod{
-            Price:  idealPrice,
-            Method: "buy order",
+        Price:  idealPrice,
+        Method: "buy order",
     }
 }
 
@@ -388,14 +399,27 @@ type fetchResult struct {
     err  error
 }
 
+// fetchWithRetry fetches url with the same retry/backoff contract as
+// before, plus (update_scheduler.go): a conditional If-None-Match/
+// If-Modified-Since request against the last ETag/Last-Modified seen for
+// url (short-circuiting on 304 with errNotModified), a per-host RateLimiter
+// wait before every attempt, and honoring a 429/5xx's Retry-After header
+// for the next attempt's backoff instead of the fixed exponential value.
 func fetchWithRetry(url string) ([]byte, error) {
     var lastErr error
+    var retryAfterOverride time.Duration
     for attempt := 0; attempt < maxRetries; attempt++ {
         if attempt > 0 {
             backoff := backoffBase * time.Duration(1<<uint(attempt-1))
+            if retryAfterOverride > 0 {
+                backoff = retryAfterOverride
+                retryAfterOverride = 0
+            }
             time.Sleep(backoff)
         }
 
+        rateLimiterForURL(url).Wait()
+
         ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
         req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
         if err != nil {
@@ -407,6 +431,7 @@ func fetchWithRetry(url string) ([]byte, error) {
         req.Header.Set("Accept-Encoding", "gzip, br")
         req.Header.Set("Connection", "keep-alive")
         req.Header.Set("Accept", "application/json")
+        setConditionalHeaders(req)
 
         // Use pool directly since it's an *http.Client
         resp, err := pool.Do(req)
@@ -415,7 +440,24 @@ func fetchWithRetry(url string) ([]byte, error) {
             lastErr = err
             continue
         }
+        rateLimiterForURL(url).UpdateFromHeaders(resp.Header)
+
+        if resp.StatusCode == http.StatusNotModified {
+            resp.Body.Close()
+            cancel()
+            return nil, errNotModified
+        }
+        if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+            if delay, ok := retryAfterDelay(resp); ok {
+                retryAfterOverride = delay
+            }
+            resp.Body.Close()
+            cancel()
+            lastErr = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+            continue
+        }
 
+        recordConditionalHeaders(req, resp)
         data, err := handleResponse(resp)
         cancel()
         if err != nil {
@@ -459,6 +501,27 @@ func (m *metrics) getAverageTime() time.Duration {
     return total / time.Duration(len(m.updateTimes))
 }
 
+// httpReadBufferPool holds the 64KB bufio.Readers handleResponse and
+// decodeBazaarBody wrap each response's (possibly gzip/br-decompressed)
+// body in, so the steady-state poll loop reuses one buffer per in-flight
+// fetch instead of allocating a fresh bufio.NewReaderSize on every call.
+var httpReadBufferPool = sync.Pool{
+    New: func() interface{} {
+        return bufio.NewReaderSize(nil, 64*1024)
+    },
+}
+
+func getHTTPReadBuffer(r io.Reader) *bufio.Reader {
+    br := httpReadBufferPool.Get().(*bufio.Reader)
+    br.Reset(r)
+    return br
+}
+
+func putHTTPReadBuffer(br *bufio.Reader) {
+    br.Reset(nil)
+    httpReadBufferPool.Put(br)
+}
+
 func handleResponse(resp *http.Response) ([]byte, error) {
     defer resp.Body.Close()
 
@@ -482,8 +545,9 @@ func handleResponse(resp *http.Response) ([]byte, error) {
         reader = resp.Body
     }
 
-    // Use a buffered reader with a reasonable size
-    return io.ReadAll(bufio.NewReaderSize(reader, 64*1024))
+    br := getHTTPReadBuffer(reader)
+    defer putHTTPReadBuffer(br)
+    return io.ReadAll(br)
 }
 
 type apiResponse struct {
@@ -493,47 +557,266 @@ type apiResponse struct {
     duration time.Duration
 }
 
+// bazaarProductMapPool and bazaarOrderSlicePool let decodeBazaarBody reuse
+// the Products map and each product's OrderSummary slices across polls
+// instead of allocating a fresh ~1500-entry map and a fresh pair of slices
+// per product on every PriceCache.update tick. releaseBazaarResponse
+// returns the previous snapshot's map/slices here once update() has
+// replaced it with a newly decoded one.
+var bazaarProductMapPool = sync.Pool{
+    New: func() interface{} {
+        return make(map[string]BazaarProduct, 1500)
+    },
+}
+
+var bazaarOrderSlicePool = sync.Pool{
+    New: func() interface{} {
+        s := make([]OrderSummary, 0, 8)
+        return &s
+    },
+}
+
+// fetchBazaarStreaming fetches url and decodes it straight off the
+// (possibly gzip/br-decompressed) response body, one product at a time via
+// decodeBazaarBody, instead of materializing the ~5MB payload into a []byte
+// with io.ReadAll first. It mirrors fetchWithRetry's retry/backoff contract
+// (including its conditional-request/RateLimiter/Retry-After handling from
+// update_scheduler.go) but folds decoding into the same attempt loop since a
+// response body can only be read once.
+func fetchBazaarStreaming(url string) (*BazaarResponse, time.Duration, time.Duration, error) {
+    var lastErr error
+    var retryAfterOverride time.Duration
+    for attempt := 0; attempt < maxRetries; attempt++ {
+        if attempt > 0 {
+            backoff := backoffBase * time.Duration(1<<uint(attempt-1))
+            if retryAfterOverride > 0 {
+                backoff = retryAfterOverride
+                retryAfterOverride = 0
+            }
+            time.Sleep(backoff)
+        }
+
+        rateLimiterForURL(url).Wait()
+
+        fetchStart := time.Now()
+        ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+        req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+        if err != nil {
+            cancel()
+            lastErr = err
+            continue
+        }
+
+        req.Header.Set("Accept-Encoding", "gzip, br")
+        req.Header.Set("Connection", "keep-alive")
+        req.Header.Set("Accept", "application/json")
+        setConditionalHeaders(req)
+
+        resp, err := pool.Do(req)
+        if err != nil {
+            cancel()
+            lastErr = err
+            continue
+        }
+        fetchDuration := time.Since(fetchStart)
+        rateLimiterForURL(url).UpdateFromHeaders(resp.Header)
+
+        if resp.StatusCode == http.StatusNotModified {
+            resp.Body.Close()
+            cancel()
+            return nil, fetchDuration, 0, errNotModified
+        }
+        if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+            if delay, ok := retryAfterDelay(resp); ok {
+                retryAfterOverride = delay
+            }
+            resp.Body.Close()
+            cancel()
+            lastErr = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+            continue
+        }
+
+        recordConditionalHeaders(req, resp)
+        decodeStart := time.Now()
+        bazaarResp, err := decodeBazaarBody(resp)
+        cancel()
+        if err != nil {
+            lastErr = err
+            continue
+        }
+
+        return bazaarResp, fetchDuration, time.Since(decodeStart), nil
+    }
+    return nil, 0, 0, fmt.Errorf("all retries failed: %v", lastErr)
+}
+
+// decodeBazaarBody streams the Bazaar response's top-level object
+// token-by-token via decoder.Token()/decoder.More(), decoding the
+// "products" object one BazaarProduct at a time instead of a single
+// json.Decode of the whole payload. The returned *BazaarResponse's
+// Products map comes from bazaarProductMapPool and each product's
+// SellSummary/BuySummary slices from bazaarOrderSlicePool - the caller must
+// run the previous snapshot through releaseBazaarResponse once it has been
+// replaced, or those pools never get anything back.
+func decodeBazaarBody(resp *http.Response) (*BazaarResponse, error) {
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+    }
+
+    var reader io.ReadCloser
+    switch resp.Header.Get("Content-Encoding") {
+    case "gzip":
+        var err error
+        reader, err = gzip.NewReader(resp.Body)
+        if err != nil {
+            return nil, err
+        }
+        defer reader.Close()
+    case "br":
+        reader = newBrotliReadCloser(resp.Body)
+        defer reader.Close()
+    default:
+        reader = resp.Body
+    }
+
+    br := getHTTPReadBuffer(reader)
+    defer putHTTPReadBuffer(br)
+
+    decoder := json.NewDecoder(br)
+    decoder.UseNumber()
+
+    result := &BazaarResponse{
+        Products: bazaarProductMapPool.Get().(map[string]BazaarProduct),
+    }
+
+    if _, err := decoder.Token(); err != nil { // opening '{'
+        return nil, err
+    }
+    for decoder.More() {
+        keyTok, err := decoder.Token()
+        if err != nil {
+            return nil, err
+        }
+        key, _ := keyTok.(string)
+
+        switch key {
+        case "success":
+            if err := decoder.Decode(&result.Success); err != nil {
+                return nil, err
+            }
+        case "lastUpdated":
+            if err := decoder.Decode(&result.LastUpdated); err != nil {
+                return nil, err
+            }
+        case "products":
+            if err := decodeBazaarProducts(decoder, result.Products); err != nil {
+                return nil, err
+            }
+        default:
+            var discard interface{}
+            if err := decoder.Decode(&discard); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if _, err := decoder.Token(); err != nil { // closing '}'
+        return nil, err
+    }
+
+    return result, nil
+}
+
+// decodeBazaarProducts streams the "products" object's entries one
+// BazaarProduct at a time, handing each entry's SellSummary/BuySummary a
+// pooled slice from bazaarOrderSlicePool so json.Decode grows/reuses that
+// backing array instead of allocating a fresh pair of slices per product.
+func decodeBazaarProducts(decoder *json.Decoder, products map[string]BazaarProduct) error {
+    if _, err := decoder.Token(); err != nil { // opening '{'
+        return err
+    }
+    for decoder.More() {
+        keyTok, err := decoder.Token()
+        if err != nil {
+            return err
+        }
+        productID, _ := keyTok.(string)
+
+        sellSummary := bazaarOrderSlicePool.Get().(*[]OrderSummary)
+        buySummary := bazaarOrderSlicePool.Get().(*[]OrderSummary)
+        *sellSummary = (*sellSummary)[:0]
+        *buySummary = (*buySummary)[:0]
+
+        product := BazaarProduct{
+            SellSummary: *sellSummary,
+            BuySummary:  *buySummary,
+        }
+        if err := decoder.Decode(&product); err != nil {
+            return err
+        }
+        products[productID] = product
+    }
+    _, err := decoder.Token() // closing '}'
+    return err
+}
+
+// releaseBazaarResponse returns resp's Products map and every product's
+// order-summary slices to bazaarProductMapPool/bazaarOrderSlicePool. Call it
+// on the snapshot PriceCache.update just replaced, once nothing else can
+// still be reading it, so the next decodeBazaarBody call gets pooled
+// buffers back instead of allocating fresh ones.
+func releaseBazaarResponse(resp *BazaarResponse) {
+    if resp == nil || resp.Products == nil {
+        return
+    }
+    for id, product := range resp.Products {
+        if product.SellSummary != nil {
+            s := product.SellSummary[:0]
+            bazaarOrderSlicePool.Put(&s)
+        }
+        if product.BuySummary != nil {
+            s := product.BuySummary[:0]
+            bazaarOrderSlicePool.Put(&s)
+        }
+        delete(resp.Products, id)
+    }
+    bazaarProductMapPool.Put(resp.Products)
+}
+
+// getIdealPrice resolves itemID's EMA_mid ± idealPriceK·σ band (see
+// itemPriceHistory.idealPrice in price_estimator.go) clipped to this
+// product's current [min(buy,sell), max(buy,sell)] quote, rather than
+// calculateIdealPrice's single-shot estimate from one QuickStatus snapshot.
 func (c *PriceCache) getIdealPrice(itemID string) float64 {
     c.mu.RLock()
-    defer c.mu.RUnlock()
-    
-    if product, exists := c.bazaarData.Products[itemID]; exists {
-        metrics := MarketMetrics{
-            SellPrice:      product.QuickStatus.SellPrice,
-            BuyPrice:       product.QuickStatus.BuyPrice,
-            SellVolume:     product.QuickStatus.SellVolume,
-            BuyVolume:      product.QuickStatus.BuyVolume,
-            SellMovingWeek: product.QuickStatus.SellMovingWeek,
-            BuyMovingWeek:  product.QuickStatus.BuyMovingWeek,
-            SellOrders:     product.QuickStatus.SellOrders,
-            BuyOrders:      product.QuickStatus.BuyOrders,
-        }
-        return calculateIdealPrice(metrics)
+    product, exists := c.bazaarData.Products[itemID]
+    c.mu.RUnlock()
+
+    if !exists {
+        return 0
     }
-    
-    return 0
+
+    minPrice := math.Min(product.QuickStatus.SellPrice, product.QuickStatus.BuyPrice)
+    maxPrice := math.Max(product.QuickStatus.SellPrice, product.QuickStatus.BuyPrice)
+    return getOrCreatePriceHistory(itemID).idealPrice(idealPriceK, minPrice, maxPrice)
 }
 
 func (c *PriceCache) getOrBuildRecipeTree(itemID string) *RecipeTree {
-    c.mu.RLock()
-    if tree, exists := c.recipeTrees[itemID]; exists {
-        c.mu.RUnlock()
+    // c.recipeTrees (recipe_tree_cache.go) is its own size-capped,
+    // TTL-expiring cache with internal locking, so this doesn't need
+    // c.mu - that only guards bazaarData/lowestBins.
+    if tree, exists := c.recipeTrees.Get(itemID); exists {
         return cloneRecipeTree(tree, 1)
     }
-    c.mu.RUnlock()
 
-    // Build new tree without holding the lock
+    // Build new tree without holding any lock
     visited := make(map[string]bool)
     tree := buildRecipeTree(itemID, 1, visited)
 
-    // Try to store in cache, but don't block if another routine beat us to it
-    c.mu.Lock()
-    if existing, exists := c.recipeTrees[itemID]; exists {
-        c.mu.Unlock()
-        return cloneRecipeTree(existing, 1)
-    }
-    c.recipeTrees[itemID] = tree
-    c.mu.Unlock()
+    // Another routine may have beaten us to it; Put just refreshes that
+    // entry's TTL and recency in that case, which is fine either way.
+    c.recipeTrees.Put(itemID, tree)
 
     return cloneRecipeTree(tree, 1)
 }
@@ -551,43 +834,30 @@ func (c *PriceCache) update() error {
     // Create channels for both API and decode responses
     apiChan := make(chan apiResponse, 2)
     decodeChan := make(chan decodedResponse, 2)
-    var wg sync.WaitGroup
-    wg.Add(2)
 
-    // Launch parallel API fetches with immediate decoding
-    go func() {
-        defer wg.Done()
-        fetchStart := time.Now()
-        data, err := fetchWithRetry(bazaarURL)
-        fetchDuration := time.Since(fetchStart)
+    // Launch both fetches as jobs on the shared scheduler (update_scheduler.go)
+    // instead of bare goroutines, so they go through its per-host RateLimiter
+    // and feed its AdaptiveCadence. Bazaar streams its decode straight off
+    // the response body (see fetchBazaarStreaming) instead of fetching into
+    // a []byte and decoding that in a second step like Lowest Bins below.
+    scheduler.Submit(func() {
+        bazaarResp, fetchDuration, decodeDuration, err := fetchBazaarStreaming(bazaarURL)
 
         apiChan <- apiResponse{
-            data:     data,
-            err:     err,
-            name:    "Bazaar",
+            err:      err,
+            name:     "Bazaar",
             duration: fetchDuration,
         }
 
         if err == nil {
-            decodeStart := time.Now()
-            bazaarResp := &BazaarResponse{
-                Products: make(map[string]BazaarProduct, 1500),
-            }
-            
-            decoder := json.NewDecoder(bytes.NewReader(data))
-            decoder.UseNumber()
-            decodeErr := decoder.Decode(bazaarResp)
-            
             decodeChan <- decodedResponse{
                 bazaarData: bazaarResp,
-                err:       decodeErr,
-                duration:  time.Since(decodeStart),
+                duration:   decodeDuration,
             }
         }
-    }()
+    })
 
-    go func() {
-        defer wg.Done()
+    scheduler.Submit(func() {
         fetchStart := time.Now()
         data, err := fetchWithRetry(lowestBinURL)
         fetchDuration := time.Since(fetchStart)
@@ -602,18 +872,18 @@ func (c *PriceCache) update() error {
         if err == nil {
             decodeStart := time.Now()
             lowestBins := make(LowestBinData, 10000)
-            
+
             decoder := json.NewDecoder(bytes.NewReader(data))
             decoder.UseNumber()
             decodeErr := decoder.Decode(&lowestBins)
-            
+
             decodeChan <- decodedResponse{
                 binsData: lowestBins,
                 err:      decodeErr,
                 duration: time.Since(decodeStart),
             }
         }
-    }()
+    })
 
     // Process API responses
     var (
@@ -622,12 +892,24 @@ func (c *PriceCache) update() error {
         bazaarResp *BazaarResponse
         lowestBins LowestBinData
         fetchErr error
+        bazaarNotModified, binsNotModified bool
     )
 
-    // Collect API responses
+    // Collect API responses. errNotModified (a 304 against the conditional
+    // ETag/Last-Modified cache in update_scheduler.go) isn't a failure - it
+    // just means that branch has nothing new to decode this tick.
     for i := 0; i < 2; i++ {
         resp := <-apiChan
         if resp.err != nil {
+            if resp.err == errNotModified {
+                switch resp.name {
+                case "Bazaar":
+                    bazaarNotModified = true
+                case "Lowest Bins":
+                    binsNotModified = true
+                }
+                continue
+            }
             fetchErr = resp.err
             continue
         }
@@ -643,8 +925,16 @@ func (c *PriceCache) update() error {
         return fmt.Errorf("API fetch failed: %v", fetchErr)
     }
 
-    // Collect decoded responses
-    for i := 0; i < 2; i++ {
+    // Collect decoded responses - only branches that fetched successfully
+    // and weren't a 304 ever send to decodeChan.
+    expectedDecodes := 2
+    if bazaarNotModified {
+        expectedDecodes--
+    }
+    if binsNotModified {
+        expectedDecodes--
+    }
+    for i := 0; i < expectedDecodes; i++ {
         resp := <-decodeChan
         if resp.err != nil {
             return fmt.Errorf("decode failed: %v", resp.err)
@@ -658,33 +948,96 @@ func (c *PriceCache) update() error {
         }
     }
 
-    // Update cache
+    // Update cache. bazaarResp/lowestBins stay nil when their branch was a
+    // 304, in which case the previous cached snapshot is left untouched.
     updateStart := time.Now()
     c.mu.Lock()
-    c.bazaarData = *bazaarResp
-    c.lowestBins = lowestBins
+    prevBazaarData := c.bazaarData
+    if bazaarResp != nil {
+        c.bazaarData = *bazaarResp
+    }
+    if lowestBins != nil {
+        c.lowestBins = lowestBins
+    }
     c.lastUpdate = time.Now()
+    currentBazaar := c.bazaarData
+    currentBins := c.lowestBins
     c.mu.Unlock()
     updateDuration := time.Since(updateStart)
 
+    if bazaarResp != nil {
+        // The previous snapshot's Products map and every product's
+        // order-summary slices came from bazaarProductMapPool/
+        // bazaarOrderSlicePool (see decodeBazaarBody) and are no longer
+        // reachable once c.bazaarData above was overwritten, so hand them
+        // back for the next poll to reuse.
+        releaseBazaarResponse(&prevBazaarData)
+
+        scheduler.Cadence.Observe(bazaarResp.LastUpdated)
+
+        // Feed this snapshot into every quoted product's rolling price
+        // estimator (price_estimator.go) before getIdealPrice/determineBuyMethod
+        // read it below, then persist so a restart doesn't lose the history.
+        observedAt := time.Now()
+        for productID, product := range bazaarResp.Products {
+            getOrCreatePriceHistory(productID).observe(observedAt, product.QuickStatus.BuyPrice, product.QuickStatus.SellPrice)
+        }
+        if err := savePriceHistories(priceHistoryPersistPath); err != nil {
+            fmt.Printf("Warning: failed to persist price history: %v\n", err)
+        }
+
+        // Append this tick's (itemID, timestamp) state into the long-lived
+        // history store (history_store.go) that backs /history candles and
+        // the optional avg(lastNDays) craft-cost smoothing in
+        // getPriceFromCache.
+        for productID, product := range bazaarResp.Products {
+            globalHistoryStore.Append(productID, HistorySnapshot{
+                Time:           observedAt,
+                BuyPrice:       product.QuickStatus.BuyPrice,
+                SellPrice:      product.QuickStatus.SellPrice,
+                BuyVolume:      product.QuickStatus.BuyVolume,
+                SellVolume:     product.QuickStatus.SellVolume,
+                BuyMovingWeek:  product.QuickStatus.BuyMovingWeek,
+                SellMovingWeek: product.QuickStatus.SellMovingWeek,
+                IdealPrice:     c.getIdealPrice(productID),
+            })
+        }
+        if err := SaveHistoryStore(globalHistoryStore, historyStorePersistPath); err != nil {
+            fmt.Printf("Warning: failed to persist history store: %v\n", err)
+        }
+    }
+
     totalDuration := time.Since(startTime)
 
     // Print timing summary
     fmt.Println("\n╔════════════════════ Cache Update Summary ═══════════════════════")
     fmt.Printf("║ User:                  %s\n", os.Getenv("USER"))
-    fmt.Printf("║ Bazaar Fetch:          %8dms\n", bazaarFetchDuration.Milliseconds())
+    fmt.Printf("║ Bazaar Fetch:          %8dms%s\n", bazaarFetchDuration.Milliseconds(), notModifiedSuffix(bazaarNotModified))
     fmt.Printf("║ Bazaar Decode:         %8dms\n", bazaarDecodeDuration.Milliseconds())
-    fmt.Printf("║ Bins Fetch:            %8dms\n", binsFetchDuration.Milliseconds())
+    fmt.Printf("║ Bins Fetch:            %8dms%s\n", binsFetchDuration.Milliseconds(), notModifiedSuffix(binsNotModified))
     fmt.Printf("║ Bins Decode:           %8dms\n", binsDecodeDuration.Milliseconds())
     fmt.Printf("║ Cache Update:          %8dms\n", updateDuration.Milliseconds())
     fmt.Printf("║ Total Time:            %8dms\n", totalDuration.Milliseconds())
-    fmt.Printf("║ Items Loaded:          %8d Bazaar, %d Bins\n", 
-        len(bazaarResp.Products), len(lowestBins))
+    fmt.Printf("║ Items Loaded:          %8d Bazaar, %d Bins\n",
+        len(currentBazaar.Products), len(currentBins))
     fmt.Println("╚═══════════════════════════════════════════════════════════════\n")
 
+    rescoreArbitragePaths(c)
+    printArbitrageSummary(topArbitrageN)
+
     return nil
 }
 
+// notModifiedSuffix is the "(304)" marker PriceCache.update's Cache Update
+// Summary appends to a fetch line when that branch short-circuited on a
+// conditional 304 instead of downloading a fresh payload.
+func notModifiedSuffix(notModified bool) string {
+    if notModified {
+        return " (304)"
+    }
+    return ""
+}
+
 func loadItems() error {
     data, err := ioutil.ReadFile("data.json")
     if err != nil {
@@ -697,13 +1050,11 @@ func loadItems() error {
 
     // Initialize cache.recipeTrees after loading items
     if cache.recipeTrees == nil {
-        cache.mu.Lock()
-        cache.recipeTrees = make(map[string]*RecipeTree)
+        cache.recipeTrees = NewRecipeTreeCache(defaultRecipeTreeCacheSize, defaultRecipeTreeCacheTTL)
         for itemID := range items {
             visited := make(map[string]bool)
-            cache.recipeTrees[itemID] = buildRecipeTree(itemID, 1, visited)
+            cache.recipeTrees.Put(itemID, buildRecipeTree(itemID, 1, visited))
         }
-        cache.mu.Unlock()
     }
 
     return nil
@@ -908,14 +1259,16 @@ func cloneRecipeTree(original *RecipeTree, quantity int) *RecipeTree {
 
 func printTotals(rootItemID string, totals ItemTotals, costs map[string]float64) {
     startTime := time.Now()
-    
-    fmt.Println("\n╔════════════════════════════════════════════════════════════════")
-    fmt.Println("║ Raw materials needed:")
-    fmt.Println("╠════════════════════════════════════════════════════════════════")
-    
+
+    if prettyOutput {
+        fmt.Println("\n╔════════════════════════════════════════════════════════════════")
+        fmt.Println("║ Raw materials needed:")
+        fmt.Println("╠════════════════════════════════════════════════════════════════")
+    }
+
     baseMatsByName := make(map[string][]string)
     totalCost := 0.0
-    
+
     for itemID := range totals {
         if isBaseMaterial(itemID) {
             itemName := items[itemID].Name
@@ -925,7 +1278,7 @@ func printTotals(rootItemID string, totals ItemTotals, costs map[string]float64)
             baseMatsByName[itemName] = append(baseMatsByName[itemName], itemID)
         }
     }
-    
+
     var sortedNames []string
     for name := range baseMatsByName {
         sortedNames = append(sortedNames, name)
@@ -938,17 +1291,22 @@ func printTotals(rootItemID string, totals ItemTotals, costs map[string]float64)
             totalItemCost := price * float64(totals[itemID])
             costPerUnit := price
             totalCost += totalItemCost
-            
-            if price > 0 {
-                fmt.Printf("╠═ %-30s x%-10d │ Cost: %-10s (%.2f ea - %s) from %s\n", 
-                    name, totals[itemID], formatPrice(totalItemCost), costPerUnit, method, source)
+
+            if prettyOutput {
+                if price > 0 {
+                    fmt.Printf("╠═ %-30s x%-10d │ Cost: %-10s (%.2f ea - %s) from %s\n",
+                        name, totals[itemID], formatPrice(totalItemCost), costPerUnit, method, source)
+                } else {
+                    fmt.Printf("╠═ %-30s x%-10d │ No price data available\n",
+                        name, totals[itemID])
+                }
             } else {
-                fmt.Printf("╠═ %-30s x%-10d │ No price data available\n", 
-                    name, totals[itemID])
+                appLogger.Info("material_cost", "item_id", itemID, "item_name", name,
+                    "quantity", totals[itemID], "cost", totalItemCost, "method", method, "source", source)
             }
         }
     }
-    
+
     fetchDuration := time.Since(startTime)
 
     // Get the recipe count for the final item
@@ -958,17 +1316,22 @@ func printTotals(rootItemID string, totals ItemTotals, costs map[string]float64)
             totalCost = totalCost / float64(recipeCount)
         }
     }
-    
-    fmt.Println("╔════════════════════════════════════════════════════════════════")
-    fmt.Printf("║ Total crafting cost: %s coins\n", formatPrice(totalCost))
-    fmt.Printf("║ Price fetch time: %.2fms\n", float64(fetchDuration.Microseconds())/1000.0)
-    fmt.Println("╚════════════════════════════════════════════════════════════════")
+
+    if prettyOutput {
+        fmt.Println("╔════════════════════════════════════════════════════════════════")
+        fmt.Printf("║ Total crafting cost: %s coins\n", formatPrice(totalCost))
+        fmt.Printf("║ Price fetch time: %.2fms\n", float64(fetchDuration.Microseconds())/1000.0)
+        fmt.Println("╚════════════════════════════════════════════════════════════════")
+    } else {
+        appLogger.Info("craft_total", "item_id", rootItemID, "total_cost", totalCost,
+            "phase", "materials", "duration_ms", float64(fetchDuration.Microseconds())/1000.0)
+    }
 }
 
 var perfMetrics = NewPerformanceMetrics()
 
 func initialize() error {
-    
+
     // Initialize cache and database
     cache = *NewCache()
     items = make(ItemDatabase)
@@ -986,11 +1349,25 @@ func initialize() error {
         break
     }
     perfMetrics.Track("item_load", time.Since(itemLoadStart))
-    
+
     if loadErr != nil {
         return fmt.Errorf("failed to load items after %d attempts: %v", maxRetries, loadErr)
     }
 
+    // Try the on-disk snapshot (disk_cache.go) before hitting the network -
+    // if it matches this items database's content hash and is still within
+    // cacheTimeout, it skips both bazaar/bins fetches below entirely.
+    itemsHash := computeItemsHash(items)
+    snapshot, err := loadSnapshot(diskCachePersistPath)
+    if err != nil {
+        fmt.Printf("Warning: failed to load disk cache: %v\n", err)
+    }
+    if snapshot.Valid(itemsHash, cacheTimeout) {
+        snapshot.ApplyTo(&cache, items)
+        perfMetrics.logOrPrint()
+        return nil
+    }
+
     // Initial cache update with retry - Track API calls separately
     bazaarStart := time.Now()
     bazaarData, err := fetchWithRetry(bazaarURL)
@@ -1013,7 +1390,11 @@ func initialize() error {
     }
     perfMetrics.Track("cache_init", time.Since(cacheStart))
 
-    perfMetrics.PrintMetrics()
+    if err := saveSnapshot(diskCachePersistPath, items, &cache); err != nil {
+        fmt.Printf("Warning: failed to save disk cache: %v\n", err)
+    }
+
+    perfMetrics.logOrPrint()
     return nil
 }
 
@@ -1040,41 +1421,63 @@ func initializeCache(bazaarData, binsData []byte) error {
     return nil
 }
 
-// Add this to the main function where you process recipe trees
+// processRecipeTree is the interactive REPL's per-item lookup: it builds
+// itemID's CraftResult through the same traced buildCraftResult (api_server.go)
+// the HTTP API's /craft and /materials handlers use, then prints it as the
+// framed ASCII report below - the REPL is just one frontend of that shared,
+// traced core rather than a separate computation.
 func processRecipeTree(itemID string) {
     startTime := time.Now()
-    fmt.Printf("\n╔════════════════════ Process Started ════════════════════")
-    fmt.Printf("\n║ Time (UTC):           %s", time.Now().UTC().Format("2006-01-02 15:04:05"))
-    fmt.Printf("\n║ User:                 %s", os.Getenv("USER"))
-    fmt.Printf("\n║ Item:                 %s", items[itemID].Name)
-    fmt.Println("\n╚════════════════════════════════════════════════════════")
-    // Recipe Tree Processing Phase
+    if prettyOutput {
+        fmt.Printf("\n╔════════════════════ Process Started ════════════════════")
+        fmt.Printf("\n║ Time (UTC):           %s", time.Now().UTC().Format("2006-01-02 15:04:05"))
+        fmt.Printf("\n║ User:                 %s", os.Getenv("USER"))
+        fmt.Printf("\n║ Item:                 %s", items[itemID].Name)
+        fmt.Println("\n╚════════════════════════════════════════════════════════")
+    } else {
+        appLogger.Info("process_started", "item_id", itemID, "item_name", items[itemID].Name, "user", os.Getenv("USER"))
+    }
+
+    ctx, span := StartSpan(context.Background(), "processRecipeTree")
+    defer span.End()
+    span.SetAttribute("item.id", itemID)
+
     treeStart := time.Now()
-    tree := cache.getOrBuildRecipeTree(itemID)
+    result, err := buildCraftResult(ctx, itemID)
     treeBuildTime := time.Since(treeStart)
+    if err != nil {
+        span.RecordError(err)
+        if prettyOutput {
+            fmt.Printf("Error building recipe tree: %v\n", err)
+        } else {
+            appLogger.Error("build_craft_result_failed", "item_id", itemID, "error", err.Error())
+        }
+        return
+    }
 
-    // Cost Calculation Phase
-    totalsStart := time.Now()
-    totals := make(ItemTotals)
-    costs := make(map[string]float64)
-
-    fmt.Println("\n╔════════════════════ Recipe Tree ════════════════════")
-    printRecipeTree(tree, 0, totals, costs)
-    treeProcessTime := time.Since(totalsStart)
+    if prettyOutput {
+        fmt.Println("\n╔════════════════════ Recipe Tree ════════════════════")
+    }
+    printRecipeTree(result.Tree, 0, make(ItemTotals), make(map[string]float64))
 
-    // Results Processing Phase
     resultsStart := time.Now()
-    printTotals(itemID, totals, costs)
+    printTotals(itemID, result.Totals, result.Costs)
     resultsTime := time.Since(resultsStart)
 
     // Final Timing Summary
     totalTime := time.Since(startTime)
-    fmt.Println("\n╔════════════════════ Processing Times ════════════════════")
-    fmt.Printf("║ Tree Building:         %8.2fms\n", float64(treeBuildTime.Microseconds())/1000.0)
-    fmt.Printf("║ Tree Processing:       %8.2fms\n", float64(treeProcessTime.Microseconds())/1000.0)
-    fmt.Printf("║ Results Processing:    %8.2fms\n", float64(resultsTime.Microseconds())/1000.0)
-    fmt.Printf("║ Total Time:           %8.2fms\n", float64(totalTime.Microseconds())/1000.0)
-    fmt.Println("╚═════════════════════════════════════════════════════════")
+    if prettyOutput {
+        fmt.Println("\n╔════════════════════ Processing Times ════════════════════")
+        fmt.Printf("║ Tree Building:         %8.2fms\n", float64(treeBuildTime.Microseconds())/1000.0)
+        fmt.Printf("║ Results Processing:    %8.2fms\n", float64(resultsTime.Microseconds())/1000.0)
+        fmt.Printf("║ Total Time:           %8.2fms\n", float64(totalTime.Microseconds())/1000.0)
+        fmt.Println("╚═════════════════════════════════════════════════════════")
+    } else {
+        appLogger.Info("processing_times", "item_id", itemID, "phase", "recipe_tree",
+            "tree_build_ms", float64(treeBuildTime.Microseconds())/1000.0,
+            "results_ms", float64(resultsTime.Microseconds())/1000.0,
+            "total_ms", float64(totalTime.Microseconds())/1000.0)
+    }
 }
 
 // Helper function to find all base materials in a recipe tree
@@ -1102,10 +1505,27 @@ func formatPriceDiff(price float64) string {
 }
 
 func main() {
+    budget := flag.Float64("budget", 0, "if set, greedily allocate this many coins across the best non-overlapping arbitrage paths and print the allocation instead of entering interactive lookup mode")
+    avgDays := flag.Int("avg-days", 0, "if set, getPriceFromCache averages IdealPrice over this many days of history_store.go history instead of using the current spot quote, smoothing recipe-tree craft-cost estimates")
+    apiAddr := flag.String("api-addr", "", "if set, also serve /craft/{itemID}, /materials/{itemID} and /batch (see api_server.go) on this address, e.g. :8081, so the interactive REPL below isn't the only way to reach the recipe costing logic")
+    otlpEndpoint := flag.String("otlp-endpoint", "", "if set, export spans as JSON over HTTP to this collector endpoint (see tracing.go) instead of printing them to stdout")
+    pretty := flag.Bool("pretty", false, "print human-readable ╔══ banners (see logging.go) instead of the default structured JSON logs; handy for the interactive REPL, but interleaved banners from many concurrent requests make this unreadable under --api-addr")
+    flag.Parse()
+    recipeCostAvgDays = *avgDays
+    initLogger(*pretty)
+
+    if *otlpEndpoint != "" {
+        SetSpanExporter(newJSONHTTPExporter(*otlpEndpoint))
+    }
+
     // Initialize global variables
     cache = *NewCache()
     items = make(ItemDatabase)
 
+    if err := LoadHistoryStore(globalHistoryStore, historyStorePersistPath); err != nil {
+        fmt.Printf("Warning: failed to load history store: %v\n", err)
+    }
+
     if err := loadItems(); err != nil {
         fmt.Printf("Failed to load items: %v\n", err)
         os.Exit(1)
@@ -1117,17 +1537,28 @@ func main() {
         os.Exit(1)
     }
 
-    // Start periodic cache updates
+    if *budget > 0 {
+        printBudgetAllocation(*budget)
+        return
+    }
+
+    if *apiAddr != "" {
+        go RunAPIServer(*apiAddr)
+    }
+
+    // Start periodic cache updates. Instead of a fixed cacheTimeout ticker,
+    // each tick sleeps for scheduler.Cadence's current estimate of when
+    // Hypixel's next Bazaar lastUpdated tick lands (update_scheduler.go),
+    // drifting toward the real cadence as PriceCache.update observes it.
     go func() {
-        ticker := time.NewTicker(cacheTimeout)
-        defer ticker.Stop()
-        for range ticker.C {
+        for {
+            time.Sleep(scheduler.Cadence.NextDelay())
             cache.update()
         }
     }()
 
     reader := bufio.NewReader(os.Stdin)
-    fmt.Println("\nEnter item ID to look up (or 'quit' to exit):")
+    fmt.Println("\nEnter item ID to look up ('top N' for the best flips, or 'quit' to exit):")
 
     for {
         fmt.Print("> ")
@@ -1138,6 +1569,16 @@ func main() {
             break
         }
 
+        if strings.HasPrefix(itemID, "top ") {
+            n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(itemID, "top ")))
+            if err != nil || n <= 0 {
+                fmt.Println("Usage: top N")
+                continue
+            }
+            printTopFlips(n)
+            continue
+        }
+
         if _, exists := items[itemID]; !exists {
             fmt.Println("Item not found!")
             continue
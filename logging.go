@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// This file gates processRecipeTree/printTotals/initialize's (list.go)
+// hand-framed "╔══…" banners behind --pretty. A real "logger" package
+// isn't practical here - this tree has no go.mod anywhere, so there's no
+// module path for a subpackage import to resolve against - so this lives
+// as a flat package-main file like disk_cache.go's "cache subpackage"
+// before it. With --pretty unset (the default), callers get JSON records
+// via appLogger instead, which is what makes RunAPIServer (api_server.go)
+// usable in production: many concurrent requests' interleaved banner
+// output on stdout is unreadable, but interleaved JSON lines aren't.
+
+// prettyOutput selects framed ASCII banners (true, set by --pretty) over
+// structured JSON log records (false, the default) in processRecipeTree,
+// printTotals and initialize.
+var prettyOutput bool
+
+// appLogger is where every structured log record in this file's callers
+// goes. initLogger swaps its handler if --pretty is set, but callers
+// always log through appLogger rather than checking prettyOutput
+// themselves for non-banner output.
+var appLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// initLogger sets prettyOutput and, if pretty, switches appLogger to a
+// human-readable text handler so log records printed outside the banners
+// (e.g. errors) still match the chosen output mode. main() calls this
+// once, right after flag.Parse().
+func initLogger(pretty bool) {
+	prettyOutput = pretty
+	if pretty {
+		appLogger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+		return
+	}
+	appLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// priceHistoryRingSize is 24h of history at cacheTimeout's (list.go)
+	// 5-minute update cadence.
+	priceHistoryRingSize = 288
+	// priceHistoryHalfLife is how long it takes a price shock to decay to
+	// half its weight in EMAMid/EMASpread - the same halflife-based EWMA
+	// shape used elsewhere in this codebase for time-decayed averages.
+	priceHistoryHalfLife = time.Hour
+	// idealPriceK (k) scales how many realized-volatility sigmas
+	// itemPriceHistory.idealPrice bounds EMA_mid by.
+	idealPriceK = 2.0
+	// instabuyZThreshold (k) bounds how many sigmas the current buy price
+	// may sit from EMA_mid before determineBuyMethod stops trusting it as
+	// an instabuy candidate. instabuyPressureThreshold is the minimum
+	// magnitude of calculateMarketPressure's buying-pressure signal
+	// required on top of that. Together these replace the old bare
+	// "pressure < 0" check, which flipped instabuy/buy order on almost
+	// every 5-minute Bazaar update.
+	instabuyZThreshold        = 1.0
+	instabuyPressureThreshold = 0.15
+	// priceHistoryPersistPath is where savePriceHistories/loadPriceHistories
+	// gob-encode the ring buffers, so a restart doesn't lose history.
+	priceHistoryPersistPath = "price_history.gob"
+)
+
+// priceSnapshot is one PriceCache.update's buy/sell quote for an item,
+// folded into itemPriceHistory's ring buffer and EMA.
+type priceSnapshot struct {
+	Time      time.Time
+	BuyPrice  float64
+	SellPrice float64
+}
+
+// itemPriceHistory is one item's rolling price estimator: a fixed-size ring
+// buffer of recent snapshots, an EWMA of mid price and of the abs spread,
+// and a realized-volatility estimate (stddev of log-returns) derived from
+// the buffer. getIdealPrice/determineBuyMethod (list.go) use EMAMid/Sigma
+// instead of reacting to a single QuickStatus snapshot, so a one-off 5-
+// minute jitter no longer flips their answer.
+type itemPriceHistory struct {
+	mu        sync.Mutex
+	Snapshots []priceSnapshot
+	EMAMid    float64
+	EMASpread float64
+	Sigma     float64
+}
+
+var (
+	priceHistoriesMu sync.Mutex
+	priceHistories   = make(map[string]*itemPriceHistory)
+)
+
+// getOrCreatePriceHistory returns itemID's itemPriceHistory, creating an
+// empty one on first use.
+func getOrCreatePriceHistory(itemID string) *itemPriceHistory {
+	priceHistoriesMu.Lock()
+	defer priceHistoriesMu.Unlock()
+	h, ok := priceHistories[itemID]
+	if !ok {
+		h = &itemPriceHistory{}
+		priceHistories[itemID] = h
+	}
+	return h
+}
+
+// observe feeds one new snapshot into h: folds mid price and abs spread
+// into the EMA using priceHistoryHalfLife-based decay, appends the
+// snapshot to the ring buffer (dropping the oldest past
+// priceHistoryRingSize), and recomputes Sigma from the buffer's log-
+// returns.
+func (h *itemPriceHistory) observe(now time.Time, buyPrice, sellPrice float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	mid := (buyPrice + sellPrice) / 2
+	spread := math.Abs(buyPrice - sellPrice)
+
+	if len(h.Snapshots) == 0 {
+		h.EMAMid = mid
+		h.EMASpread = spread
+	} else {
+		last := h.Snapshots[len(h.Snapshots)-1]
+		dt := now.Sub(last.Time).Seconds()
+		if dt < 0 {
+			dt = 0
+		}
+		alpha := 1.0
+		if priceHistoryHalfLife > 0 {
+			alpha = 1 - math.Exp(-dt/priceHistoryHalfLife.Seconds()*math.Ln2)
+		}
+		h.EMAMid = alpha*mid + (1-alpha)*h.EMAMid
+		h.EMASpread = alpha*spread + (1-alpha)*h.EMASpread
+	}
+
+	h.Snapshots = append(h.Snapshots, priceSnapshot{Time: now, BuyPrice: buyPrice, SellPrice: sellPrice})
+	if len(h.Snapshots) > priceHistoryRingSize {
+		h.Snapshots = h.Snapshots[len(h.Snapshots)-priceHistoryRingSize:]
+	}
+
+	h.Sigma = realizedVolatility(h.Snapshots)
+}
+
+// realizedVolatility is the stddev of consecutive-snapshot log-returns of
+// mid price - scale-free, so the same k in idealPrice's EMA_mid ± k·σ
+// works across items of wildly different price magnitudes.
+func realizedVolatility(snapshots []priceSnapshot) float64 {
+	if len(snapshots) < 2 {
+		return 0
+	}
+	returns := make([]float64, 0, len(snapshots)-1)
+	for i := 1; i < len(snapshots); i++ {
+		prevMid := (snapshots[i-1].BuyPrice + snapshots[i-1].SellPrice) / 2
+		curMid := (snapshots[i].BuyPrice + snapshots[i].SellPrice) / 2
+		if prevMid <= 0 || curMid <= 0 {
+			continue
+		}
+		returns = append(returns, math.Log(curMid/prevMid))
+	}
+	if len(returns) < 2 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(returns) - 1)
+	return math.Sqrt(variance)
+}
+
+// idealPrice returns EMA_mid bounded to EMA_mid*(1 ± k·σ), clipped to
+// [minPrice, maxPrice] - the same clamp calculateIdealPrice used against
+// the current Bazaar quote. While the ring buffer hasn't accumulated at
+// least two snapshots yet, it falls back to the plain midpoint of
+// minPrice/maxPrice instead of trusting an EMA/σ computed from one sample.
+func (h *itemPriceHistory) idealPrice(k, minPrice, maxPrice float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.Snapshots) < 2 || h.EMAMid <= 0 {
+		mid := (minPrice + maxPrice) / 2
+		return math.Max(minPrice, math.Min(maxPrice, mid))
+	}
+
+	bound := k * h.Sigma * h.EMAMid
+	lo := math.Max(minPrice, h.EMAMid-bound)
+	hi := math.Min(maxPrice, h.EMAMid+bound)
+	if lo > hi {
+		// k·σ collapsed the band entirely outside [minPrice, maxPrice] -
+		// fall back to EMA_mid clipped to the quote bounds rather than
+		// return an inverted range.
+		return math.Max(minPrice, math.Min(maxPrice, h.EMAMid))
+	}
+	return math.Max(lo, math.Min(hi, h.EMAMid))
+}
+
+// zScore is how many sigmas price currently sits from EMA_mid. It returns 0
+// before h has enough history to have a nonzero Sigma, which is the same
+// "trust it" default determineBuyMethod's old pressure-only check had.
+func (h *itemPriceHistory) zScore(price float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.EMAMid <= 0 || h.Sigma <= 0 {
+		return 0
+	}
+	return math.Abs(price-h.EMAMid) / (h.Sigma * h.EMAMid)
+}
+
+// persistableHistory is the gob-encodable form of itemPriceHistory -
+// itemPriceHistory itself isn't encoded directly since it carries a mutex.
+type persistableHistory struct {
+	Snapshots []priceSnapshot
+	EMAMid    float64
+	EMASpread float64
+	Sigma     float64
+}
+
+// savePriceHistories gob-encodes every item's current history to path, so a
+// restart has something to resume from instead of starting every item's
+// EMA/σ cold.
+func savePriceHistories(path string) error {
+	priceHistoriesMu.Lock()
+	snapshot := make(map[string]persistableHistory, len(priceHistories))
+	for itemID, h := range priceHistories {
+		h.mu.Lock()
+		snapshot[itemID] = persistableHistory{
+			Snapshots: append([]priceSnapshot(nil), h.Snapshots...),
+			EMAMid:    h.EMAMid,
+			EMASpread: h.EMASpread,
+			Sigma:     h.Sigma,
+		}
+		h.mu.Unlock()
+	}
+	priceHistoriesMu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("savePriceHistories: creating %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(snapshot); err != nil {
+		return fmt.Errorf("savePriceHistories: encoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadPriceHistories restores priceHistories from a prior savePriceHistories
+// call. A missing file is not an error - that's just a fresh process with no
+// history yet.
+func loadPriceHistories(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("loadPriceHistories: opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var snapshot map[string]persistableHistory
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return fmt.Errorf("loadPriceHistories: decoding %s: %w", path, err)
+	}
+
+	priceHistoriesMu.Lock()
+	defer priceHistoriesMu.Unlock()
+	for itemID, ph := range snapshot {
+		priceHistories[itemID] = &itemPriceHistory{
+			Snapshots: ph.Snapshots,
+			EMAMid:    ph.EMAMid,
+			EMASpread: ph.EMASpread,
+			Sigma:     ph.Sigma,
+		}
+	}
+	return nil
+}
+
+// writePriceHistoryMetrics appends EMA_mid, EMA_spread, sigma and current
+// z-score gauges for every item with any observed history, in the same
+// Prometheus text exposition format apiHTTPClient.MetricsHandler
+// (http_client.go) already writes to the same /metrics response.
+func writePriceHistoryMetrics(w io.Writer) {
+	priceHistoriesMu.Lock()
+	itemIDs := make([]string, 0, len(priceHistories))
+	for itemID := range priceHistories {
+		itemIDs = append(itemIDs, itemID)
+	}
+	priceHistoriesMu.Unlock()
+	sort.Strings(itemIDs)
+
+	fmt.Fprintf(w, "# HELP wiz_price_ema_mid EMA of an item's mid price ((buyPrice+sellPrice)/2)\n# TYPE wiz_price_ema_mid gauge\n")
+	for _, itemID := range itemIDs {
+		h := getOrCreatePriceHistory(itemID)
+		h.mu.Lock()
+		fmt.Fprintf(w, "wiz_price_ema_mid{item=%q} %f\n", itemID, h.EMAMid)
+		h.mu.Unlock()
+	}
+
+	fmt.Fprintf(w, "# HELP wiz_price_ema_spread EMA of an item's abs(buyPrice-sellPrice)\n# TYPE wiz_price_ema_spread gauge\n")
+	for _, itemID := range itemIDs {
+		h := getOrCreatePriceHistory(itemID)
+		h.mu.Lock()
+		fmt.Fprintf(w, "wiz_price_ema_spread{item=%q} %f\n", itemID, h.EMASpread)
+		h.mu.Unlock()
+	}
+
+	fmt.Fprintf(w, "# HELP wiz_price_sigma realized volatility (stddev of log-returns) of an item's mid price\n# TYPE wiz_price_sigma gauge\n")
+	for _, itemID := range itemIDs {
+		h := getOrCreatePriceHistory(itemID)
+		h.mu.Lock()
+		fmt.Fprintf(w, "wiz_price_sigma{item=%q} %f\n", itemID, h.Sigma)
+		h.mu.Unlock()
+	}
+
+	fmt.Fprintf(w, "# HELP wiz_price_zscore current buyPrice's distance from EMA_mid, in sigma units\n# TYPE wiz_price_zscore gauge\n")
+	for _, itemID := range itemIDs {
+		h := getOrCreatePriceHistory(itemID)
+		h.mu.Lock()
+		var z float64
+		if n := len(h.Snapshots); n > 0 && h.EMAMid > 0 && h.Sigma > 0 {
+			z = (h.Snapshots[n-1].BuyPrice - h.EMAMid) / (h.Sigma * h.EMAMid)
+		}
+		h.mu.Unlock()
+		fmt.Fprintf(w, "wiz_price_zscore{item=%q} %f\n", itemID, z)
+	}
+}
+
+// combinedMetricsHandler serves apiHTTPClient's existing endpoint metrics
+// followed by writePriceHistoryMetrics's per-item gauges, so /metrics stays
+// the one scrape target for both (main.go).
+func combinedMetricsHandler(apiMetrics http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiMetrics.ServeHTTP(w, r)
+		writePriceHistoryMetrics(w)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRecipeTreeCacheSize bounds PriceCache.recipeTrees - deep
+	// recursive builds for popular items (Hyperion, Necron's Blade, etc.)
+	// can otherwise grow this cache without limit across a long-running
+	// REPL/HTTP process.
+	defaultRecipeTreeCacheSize = 500
+	// defaultRecipeTreeCacheTTL is how long a built tree stays reusable
+	// before getOrBuildRecipeTree rebuilds it from scratch, so a recipe
+	// edit in data.json (new item load) doesn't stay stale forever.
+	defaultRecipeTreeCacheTTL = 30 * time.Minute
+)
+
+// recipeTreeCacheEntry is one node of RecipeTreeCache's LRU doubly-linked
+// list.
+type recipeTreeCacheEntry struct {
+	key        string
+	tree       *RecipeTree
+	expiresAt  time.Time
+	prev, next *recipeTreeCacheEntry
+}
+
+// RecipeTreeCache is a size-capped, TTL-expiring cache of built recipe
+// trees keyed by itemID, replacing PriceCache.recipeTrees' previous
+// unbounded map. Get moves a hit to the head (most recently used) and
+// also checks the tail's expiration, lazily pruning one stale entry per
+// call instead of running a background sweep goroutine. Put evicts the
+// tail once len exceeds maxSize.
+type RecipeTreeCache struct {
+	mu         sync.Mutex
+	maxSize    int
+	ttl        time.Duration
+	entries    map[string]*recipeTreeCacheEntry
+	head, tail *recipeTreeCacheEntry
+}
+
+// NewRecipeTreeCache returns an empty RecipeTreeCache capped at maxSize
+// entries, each expiring ttl after it was last Put.
+func NewRecipeTreeCache(maxSize int, ttl time.Duration) *RecipeTreeCache {
+	return &RecipeTreeCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		entries: make(map[string]*recipeTreeCacheEntry),
+	}
+}
+
+// moveToFront unlinks e and re-links it at the head. Callers must hold
+// c.mu.
+func (c *RecipeTreeCache) moveToFront(e *recipeTreeCacheEntry) {
+	c.unlink(e)
+	e.prev = nil
+	e.next = c.head
+	if c.head != nil {
+		c.head.prev = e
+	}
+	c.head = e
+	if c.tail == nil {
+		c.tail = e
+	}
+}
+
+// unlink removes e from the list (if linked) without touching
+// c.entries. Callers must hold c.mu.
+func (c *RecipeTreeCache) unlink(e *recipeTreeCacheEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else if c.head == e {
+		c.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else if c.tail == e {
+		c.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
+
+// removeLocked unlinks e and drops it from c.entries. Callers must hold
+// c.mu.
+func (c *RecipeTreeCache) removeLocked(e *recipeTreeCacheEntry) {
+	c.unlink(e)
+	delete(c.entries, e.key)
+}
+
+// Get returns itemID's cached tree and true, or (nil, false) if it's
+// missing or has expired. A hit is moved to the head.
+func (c *RecipeTreeCache) Get(itemID string) (*RecipeTree, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tail != nil && time.Now().After(c.tail.expiresAt) {
+		c.removeLocked(c.tail)
+	}
+
+	e, ok := c.entries[itemID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expiresAt) {
+		c.removeLocked(e)
+		return nil, false
+	}
+	c.moveToFront(e)
+	return e.tree, true
+}
+
+// Put inserts (or refreshes) itemID's tree at the head, resetting its
+// TTL, and evicts the tail if that pushes the cache past maxSize.
+func (c *RecipeTreeCache) Put(itemID string, tree *RecipeTree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[itemID]; ok {
+		e.tree = tree
+		e.expiresAt = time.Now().Add(c.ttl)
+		c.moveToFront(e)
+		return
+	}
+
+	e := &recipeTreeCacheEntry{
+		key:       itemID,
+		tree:      tree,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	c.entries[itemID] = e
+	c.moveToFront(e)
+
+	if c.maxSize > 0 && len(c.entries) > c.maxSize {
+		c.removeLocked(c.tail)
+	}
+}
+
+// Len returns the number of entries currently held, including any not yet
+// lazily pruned past their TTL.
+func (c *RecipeTreeCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Snapshot returns up to maxEntries of the most-recently-used, unexpired
+// trees (head-first), for disk_cache.go's saveSnapshot to persist without
+// writing out every tree ever built.
+func (c *RecipeTreeCache) Snapshot(maxEntries int) map[string]*RecipeTree {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[string]*RecipeTree)
+	now := time.Now()
+	for e := c.head; e != nil && len(out) < maxEntries; e = e.next {
+		if now.After(e.expiresAt) {
+			continue
+		}
+		out[e.key] = e.tree
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This file is a minimal, dependency-free stand-in for an OpenTelemetry
+// tracer: a Span type with attributes/error recording, context.Context
+// propagation for parent/child spans, and a pluggable SpanExporter so spans
+// can go to stdout (the default) or be shipped to a collector as JSON over
+// HTTP. It intentionally does not pull in go.opentelemetry.io/otel - this
+// codebase avoids adding third-party dependencies for needs this size (see
+// the similar reasoning for ExpansionCache's pluggable backends and
+// HistoryStore's from-scratch persistence).
+
+// Span is one traced operation. Name/TraceID/SpanID/ParentSpanID/Start/End
+// are fixed at creation/End; Attributes and Err accumulate via
+// SetAttribute/RecordError until End is called.
+type Span struct {
+	Name          string
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	Start         time.Time
+	End_          time.Time
+	Attributes    map[string]interface{}
+	Err           error
+
+	mu sync.Mutex
+}
+
+// spanContextKey is the private context.Context key StartSpan uses to find
+// the parent span (if any), mirroring how the stdlib's own context-scoped
+// values (e.g. httptrace) avoid colliding with unrelated packages.
+type spanContextKey struct{}
+
+// spanCounter generates SpanIDs/TraceIDs without reaching for a real UUID
+// library - process-local, monotonically increasing IDs are enough for a
+// stdout/JSON exporter that isn't feeding a multi-process trace backend.
+var spanCounter struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+func nextSpanID() string {
+	spanCounter.mu.Lock()
+	defer spanCounter.mu.Unlock()
+	spanCounter.next++
+	return fmt.Sprintf("%016x", spanCounter.next)
+}
+
+// StartSpan begins a new Span named name, child of whatever span (if any)
+// is already in ctx, and returns a context carrying the new span alongside
+// the Span itself. Callers defer span.End().
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		SpanID:     nextSpanID(),
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok && parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = nextSpanID()
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SetAttribute records one key/value on the span, overwriting any previous
+// value for the same key.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with err. A nil err is a no-op, so
+// callers can write `span.RecordError(err)` unconditionally after a call
+// that might fail.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Err = err
+}
+
+// End stamps the span's end time and hands it to the active SpanExporter.
+func (s *Span) End() {
+	s.mu.Lock()
+	s.End_ = time.Now()
+	s.mu.Unlock()
+	activeExporter().Export(s)
+}
+
+// Duration is how long the span ran; only meaningful after End().
+func (s *Span) Duration() time.Duration {
+	return s.End_.Sub(s.Start)
+}
+
+// SpanExporter is where finished spans go. Export is called synchronously
+// from Span.End, so implementations that talk to the network (like
+// jsonHTTPExporter) should not block the caller for long.
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+// stdoutExporter prints one line per finished span - the default exporter,
+// good enough for the CLI REPL and for development without standing up a
+// collector.
+type stdoutExporter struct{}
+
+func (stdoutExporter) Export(span *Span) {
+	errStr := ""
+	if span.Err != nil {
+		errStr = fmt.Sprintf(" err=%q", span.Err.Error())
+	}
+	fmt.Printf("[trace] %s span=%s trace=%s parent=%s duration=%s attrs=%v%s\n",
+		span.Name, span.SpanID, span.TraceID, span.ParentSpanID, span.Duration(), span.Attributes, errStr)
+}
+
+// jsonHTTPExporter POSTs each finished span as a JSON object to endpoint.
+// This is NOT the real OTLP wire format (no protobuf, no otel-collector
+// resource/scope envelope) - it exists so a span can still reach an
+// external collector that accepts plain JSON, without this codebase taking
+// on the OpenTelemetry SDK as a dependency.
+type jsonHTTPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// newJSONHTTPExporter returns a jsonHTTPExporter posting to endpoint.
+func newJSONHTTPExporter(endpoint string) *jsonHTTPExporter {
+	return &jsonHTTPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (e *jsonHTTPExporter) Export(span *Span) {
+	body, err := json.Marshal(struct {
+		Name         string                 `json:"name"`
+		TraceID      string                 `json:"traceId"`
+		SpanID       string                 `json:"spanId"`
+		ParentSpanID string                 `json:"parentSpanId,omitempty"`
+		Start        time.Time              `json:"start"`
+		End          time.Time              `json:"end"`
+		Attributes   map[string]interface{} `json:"attributes"`
+		Error        string                 `json:"error,omitempty"`
+	}{
+		Name:         span.Name,
+		TraceID:      span.TraceID,
+		SpanID:       span.SpanID,
+		ParentSpanID: span.ParentSpanID,
+		Start:        span.Start,
+		End:          span.End_,
+		Attributes:   span.Attributes,
+		Error: func() string {
+			if span.Err != nil {
+				return span.Err.Error()
+			}
+			return ""
+		}(),
+	})
+	if err != nil {
+		fmt.Printf("[trace] failed to encode span %s for export: %v\n", span.SpanID, err)
+		return
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("[trace] failed to export span %s to %s: %v\n", span.SpanID, e.endpoint, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   SpanExporter = stdoutExporter{}
+)
+
+// SetSpanExporter replaces the process-wide SpanExporter every Span.End
+// call reports to. main() calls this once at startup (e.g. to switch to
+// newJSONHTTPExporter when --otlp-endpoint is set); the default is
+// stdoutExporter.
+func SetSpanExporter(e SpanExporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func activeExporter() SpanExporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errNotModified is what fetchWithRetry/fetchBazaarStreaming return when the
+// server answers 304 Not Modified to a conditional (If-None-Match/
+// If-Modified-Since) request - PriceCache.update treats it as "keep the
+// previous snapshot for this fetch" rather than a failure.
+var errNotModified = errors.New("not modified")
+
+// conditionalCacheEntry is the last ETag/Last-Modified a URL answered with,
+// so the next fetchWithRetry/fetchBazaarStreaming call can send
+// If-None-Match/If-Modified-Since and let Hypixel short-circuit with a 304
+// when the payload hasn't changed.
+type conditionalCacheEntry struct {
+	ETag         string
+	LastModified string
+}
+
+var (
+	condCacheMu sync.Mutex
+	condCache   = make(map[string]*conditionalCacheEntry)
+)
+
+// setConditionalHeaders attaches the last-known ETag/Last-Modified for
+// req.URL (if any) as If-None-Match/If-Modified-Since.
+func setConditionalHeaders(req *http.Request) {
+	condCacheMu.Lock()
+	entry, ok := condCache[req.URL.String()]
+	condCacheMu.Unlock()
+	if !ok {
+		return
+	}
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// recordConditionalHeaders remembers resp's ETag/Last-Modified for req.URL,
+// overwriting the previous entry (or clearing it if resp sent neither).
+func recordConditionalHeaders(req *http.Request, resp *http.Response) {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	condCacheMu.Lock()
+	condCache[req.URL.String()] = &conditionalCacheEntry{ETag: etag, LastModified: lastModified}
+	condCacheMu.Unlock()
+}
+
+// retryAfterDelay parses a 429/5xx response's Retry-After header (either
+// delta-seconds or an HTTP-date), returning (delay, true) if present and
+// parseable.
+func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	raw := resp.Header.Get("Retry-After")
+	if raw == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(raw); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(raw); err == nil {
+		delay := time.Until(when)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// RateLimiter is a token bucket sized from a host's RateLimit-Remaining/
+// RateLimit-Reset response headers (Hypixel returns both), so concurrent
+// callers sharing one RateLimiter can't collectively blow the API's quota.
+// Before headers are ever observed it starts effectively unlimited, so the
+// first request through it isn't blocked on a guess.
+type RateLimiter struct {
+	mu           sync.Mutex
+	tokens       float64
+	max          float64
+	refillPerSec float64
+	lastRefill   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with no configured quota yet - Wait
+// lets every request through until UpdateFromHeaders sees Hypixel's actual
+// limit.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{lastRefill: time.Now()}
+}
+
+// Wait blocks until a token is available, consuming one. With max == 0 (no
+// quota observed yet) it returns immediately.
+func (rl *RateLimiter) Wait() {
+	for {
+		rl.mu.Lock()
+		if rl.max <= 0 {
+			rl.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		elapsed := now.Sub(rl.lastRefill).Seconds()
+		rl.lastRefill = now
+		rl.tokens = math.Min(rl.max, rl.tokens+elapsed*rl.refillPerSec)
+
+		if rl.tokens >= 1 {
+			rl.tokens--
+			rl.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - rl.tokens) / rl.refillPerSec * float64(time.Second))
+		rl.mu.Unlock()
+		if wait <= 0 {
+			wait = 10 * time.Millisecond
+		}
+		time.Sleep(wait)
+	}
+}
+
+// UpdateFromHeaders resizes rl from a response's RateLimit-Remaining/
+// RateLimit-Reset headers: max tracks the largest remaining count seen (a
+// proxy for the host's window limit) and refillPerSec is set so the bucket
+// would refill from empty to max over the reset window.
+func (rl *RateLimiter) UpdateFromHeaders(h http.Header) {
+	remainingRaw := h.Get("RateLimit-Remaining")
+	resetRaw := h.Get("RateLimit-Reset")
+	if remainingRaw == "" || resetRaw == "" {
+		return
+	}
+	remaining, err := strconv.ParseFloat(remainingRaw, 64)
+	if err != nil {
+		return
+	}
+	resetSecs, err := strconv.ParseFloat(resetRaw, 64)
+	if err != nil || resetSecs <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if remaining+1 > rl.max {
+		rl.max = remaining + 1
+	}
+	rl.tokens = remaining
+	rl.refillPerSec = rl.max / resetSecs
+	rl.lastRefill = time.Now()
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+)
+
+// rateLimiterForHost returns host's shared RateLimiter, creating one on
+// first use.
+func rateLimiterForHost(host string) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	rl, ok := rateLimiters[host]
+	if !ok {
+		rl = NewRateLimiter()
+		rateLimiters[host] = rl
+	}
+	return rl
+}
+
+// rateLimiterForURL is a rateLimiterForHost convenience for a raw URL
+// string, falling back to the shared "" host bucket (effectively
+// unlimited) if the URL fails to parse.
+func rateLimiterForURL(rawURL string) *RateLimiter {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rateLimiterForHost("")
+	}
+	return rateLimiterForHost(u.Host)
+}
+
+// AdaptiveCadence tracks Hypixel's actual Bazaar refresh cadence from
+// successive BazaarResponse.LastUpdated values and drifts toward it with a
+// PI controller, instead of polling on cacheTimeout's fixed 5-minute timer
+// regardless of how often the upstream data actually changes.
+type AdaptiveCadence struct {
+	mu        sync.Mutex
+	cadence   time.Duration // current best estimate of the refresh period
+	integral  time.Duration // PI controller's accumulated error term
+	lastTick  time.Time     // most recent observed LastUpdated
+	kp        float64
+	ki        float64
+}
+
+// pollLead is how long after an expected tick the scheduler polls, giving
+// Hypixel's CDN/cache a moment to actually publish the new snapshot.
+const pollLead = 1 * time.Second
+
+// NewAdaptiveCadence returns an AdaptiveCadence seeded with initial as its
+// first cadence estimate (cacheTimeout is the natural starting guess).
+func NewAdaptiveCadence(initial time.Duration) *AdaptiveCadence {
+	return &AdaptiveCadence{cadence: initial, kp: 0.5, ki: 0.1}
+}
+
+// Observe folds in a freshly observed BazaarResponse.LastUpdated (ms since
+// epoch): if this is a new tick since the last one seen, the gap between
+// them is this cadence's latest sample, and the PI controller nudges the
+// running cadence estimate toward it.
+func (c *AdaptiveCadence) Observe(lastUpdatedMillis int64) {
+	tick := time.UnixMilli(lastUpdatedMillis)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lastTick.IsZero() {
+		c.lastTick = tick
+		return
+	}
+	if !tick.After(c.lastTick) {
+		return // same tick as last observation, no new sample
+	}
+
+	observedGap := tick.Sub(c.lastTick)
+	c.lastTick = tick
+
+	errTerm := observedGap - c.cadence
+	c.integral += time.Duration(float64(errTerm) * c.ki)
+	adjustment := time.Duration(float64(errTerm)*c.kp) + c.integral
+	c.cadence += adjustment
+
+	if c.cadence < time.Second {
+		c.cadence = time.Second
+	}
+}
+
+// NextDelay returns how long to sleep before the next poll: pollLead after
+// lastTick+cadence, clamped to a small positive delay if that moment has
+// already passed (or no tick has been observed yet, in which case it just
+// falls back to the current cadence estimate).
+func (c *AdaptiveCadence) NextDelay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lastTick.IsZero() {
+		return c.cadence
+	}
+	next := c.lastTick.Add(c.cadence).Add(pollLead)
+	delay := time.Until(next)
+	if delay <= 0 {
+		return 100 * time.Millisecond
+	}
+	return delay
+}
+
+// UpdateScheduler is the home for every shared resource PriceCache.update's
+// per-fetch jobs need: Submit runs a job concurrently (replacing the bare
+// `go func(){}()` calls update used to start directly), each job reaches
+// its target host's RateLimiter through RateLimiterFor, and Cadence is the
+// AdaptiveCadence driving main()'s poll loop instead of a fixed ticker.
+type UpdateScheduler struct {
+	wg      sync.WaitGroup
+	Cadence *AdaptiveCadence
+}
+
+// NewUpdateScheduler returns an UpdateScheduler whose Cadence starts at
+// initialCadence.
+func NewUpdateScheduler(initialCadence time.Duration) *UpdateScheduler {
+	return &UpdateScheduler{Cadence: NewAdaptiveCadence(initialCadence)}
+}
+
+// Submit runs job in its own goroutine, tracked by s so Wait can block
+// until every job submitted since the last Wait has finished.
+func (s *UpdateScheduler) Submit(job func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		job()
+	}()
+}
+
+// Wait blocks until every job Submit has started since the last Wait call
+// has returned.
+func (s *UpdateScheduler) Wait() {
+	s.wg.Wait()
+}
+
+// RateLimiterFor returns rawURL's host's shared RateLimiter.
+func (s *UpdateScheduler) RateLimiterFor(rawURL string) *RateLimiter {
+	return rateLimiterForURL(rawURL)
+}
+
+// scheduler is the single UpdateScheduler PriceCache.update's jobs and
+// main()'s poll loop share, seeded with the existing fixed cacheTimeout as
+// its first cadence guess.
+var scheduler = NewUpdateScheduler(cacheTimeout)